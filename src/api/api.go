@@ -8,13 +8,21 @@ package api
 import (
 	"../config"
 	"../logging"
+	"../utils/upgrade"
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-contrib/cors"
+	"io/ioutil"
+	"net/http"
 )
 
 /* gin app */
 var app *gin.Engine
 
+/* http server serving app */
+var server *http.Server
+
 /**
  * Initialize module
  */
@@ -23,7 +31,7 @@ func init() {
 }
 
 /**
- * Starts REST API server
+ * Starts REST API server, listening on bind before returning
  */
 func Start(cfg config.ApiConfig) {
 
@@ -49,31 +57,80 @@ func Start(cfg config.ApiConfig) {
 		log.Info("API CORS enabled")
 	}
 
+	/* probes are attached before authentication */
+	attachHealth(app)
+
 	r := app.Group("/")
 
-	if cfg.BasicAuth != nil {
-		log.Info("Using HTTP Basic Auth")
-		r.Use(gin.BasicAuth(gin.Accounts{
-			cfg.BasicAuth.Login: cfg.BasicAuth.Password,
-		}))
+	auth, err := authenticate(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if auth != nil {
+		log.Info("Using HTTP Basic Auth / Bearer tokens")
+		r.Use(auth)
 	}
 
 	/* attach endpoints */
 	attachRoot(r)
 	attachServers(r)
 
-	var err error
-	/* start rest api server */
+	/* start rest api server, taking listener from old process on upgrade */
+	listener, err := upgrade.Listen(cfg.Bind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = &http.Server{
+		Addr:    cfg.Bind,
+		Handler: app,
+	}
+
 	if cfg.Tls != nil {
+
+		/* require client certificate signed by ca if needed */
+		if cfg.Tls.ClientCaPath != "" {
+			pem, err := ioutil.ReadFile(cfg.Tls.ClientCaPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				log.Fatal("No certificates found in ", cfg.Tls.ClientCaPath)
+			}
+
+			server.TLSConfig = &tls.Config{
+				ClientCAs:  pool,
+				ClientAuth: tls.RequireAndVerifyClientCert,
+			}
+			log.Info("Requiring API client certificates")
+		}
+
 		log.Info("Starting HTTPS server ", cfg.Bind)
-		err = app.RunTLS(cfg.Bind, cfg.Tls.CertPath, cfg.Tls.KeyPath)
 	} else {
 		log.Info("Starting HTTP server ", cfg.Bind)
-		err = app.Run(cfg.Bind)
 	}
 
-	if err != nil {
-		log.Fatal(err)
-	}
+	go func() {
+		if cfg.Tls != nil {
+			err = server.ServeTLS(listener, cfg.Tls.CertPath, cfg.Tls.KeyPath)
+		} else {
+			err = server.Serve(listener)
+		}
+
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
 
+/**
+ * Stops REST API server, closing its connections
+ */
+func Stop() {
+	if server != nil {
+		server.Close()
+	}
 }
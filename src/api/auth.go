@@ -0,0 +1,147 @@
+/**
+ * auth.go - rest api authentication and authorization
+ */
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"../config"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	/* Role allowed to call all endpoints */
+	API_ROLE_ADMIN = "admin"
+
+	/* Role allowed to call read endpoints only (stats, config, servers GET) */
+	API_ROLE_READONLY = "readonly"
+)
+
+/**
+ * Request context key of authorized role
+ */
+const apiRoleKey = "gobetween.api.role"
+
+/**
+ * Credentials with role
+ */
+type credentials struct {
+	secret string
+	role   string
+}
+
+/**
+ * Returns role, admin if empty, or false if role is unknown
+ */
+func apiRole(role string) (string, bool) {
+	switch role {
+	case "", API_ROLE_ADMIN:
+		return API_ROLE_ADMIN, true
+	case API_ROLE_READONLY:
+		return API_ROLE_READONLY, true
+	}
+	return "", false
+}
+
+/**
+ * Returns middleware allowing requests with valid basic auth
+ * credentials or bearer token, having role allowing request method,
+ * or nil if none configured
+ */
+func authenticate(cfg config.ApiConfig) (gin.HandlerFunc, error) {
+
+	users := map[string]credentials{}
+	if cfg.BasicAuth != nil {
+		users[cfg.BasicAuth.Login] = credentials{cfg.BasicAuth.Password, API_ROLE_ADMIN}
+	}
+	for _, u := range cfg.Users {
+		role, ok := apiRole(u.Role)
+		if !ok {
+			return nil, errors.New("Unknown API role " + u.Role + " of user " + u.Login)
+		}
+		users[u.Login] = credentials{u.Password, role}
+	}
+
+	tokens := []credentials{}
+	for _, t := range cfg.Tokens {
+		if t.Token == "" {
+			return nil, errors.New("API token should not be empty")
+		}
+		role, ok := apiRole(t.Role)
+		if !ok {
+			return nil, errors.New("Unknown API role " + t.Role + " of token")
+		}
+		tokens = append(tokens, credentials{t.Token, role})
+	}
+
+	if len(users) == 0 && len(tokens) == 0 {
+		return nil, nil
+	}
+
+	return func(c *gin.Context) {
+
+		header := c.GetHeader("Authorization")
+
+		if strings.HasPrefix(header, "Bearer ") {
+			token := strings.TrimPrefix(header, "Bearer ")
+			for _, t := range tokens {
+				if secureEqual(token, t.secret) {
+					authorize(c, t.role)
+					return
+				}
+			}
+		}
+
+		if login, password, ok := c.Request.BasicAuth(); ok {
+			if u, exists := users[login]; exists && secureEqual(password, u.secret) {
+				c.Set(gin.AuthUserKey, login)
+				authorize(c, u.role)
+				return
+			}
+		}
+
+		if len(users) > 0 {
+			c.Header("WWW-Authenticate", `Basic realm="gobetween"`)
+		}
+
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}, nil
+}
+
+/**
+ * Continues request if role allows it's method, read only roles
+ * are allowed GET and HEAD only, as all modifying endpoints have other methods
+ */
+func authorize(c *gin.Context, role string) {
+
+	method := c.Request.Method
+
+	if role == API_ROLE_READONLY && method != "GET" && method != "HEAD" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Set(apiRoleKey, role)
+	c.Next()
+}
+
+/**
+ * Checks if request may see secrets of configuration. Readonly roles may not,
+ * so their credentials can't be used to find admin ones.
+ * Without authentication configured every request may
+ */
+func showSecrets(c *gin.Context) bool {
+	role, ok := c.Get(apiRoleKey)
+	return !ok || role == API_ROLE_ADMIN
+}
+
+/**
+ * Compares strings in constant time
+ */
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
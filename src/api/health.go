@@ -0,0 +1,39 @@
+/**
+ * health.go - /healthz and /readyz probes
+ */
+package api
+
+import (
+	"../manager"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+/**
+ * Attaches probes handlers. They are not authenticated, so orchestrators can use them
+ */
+func attachHealth(app gin.IRoutes) {
+
+	/**
+	 * Liveness, app is running and api responds
+	 */
+	app.GET("/healthz", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	/**
+	 * Readiness, 200 if all configured servers are listening with
+	 * at least min_healthy_backends live backends, 503 otherwise
+	 */
+	app.GET("/readyz", func(c *gin.Context) {
+
+		readiness := manager.GetReadiness()
+
+		status := http.StatusOK
+		if !readiness.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		c.IndentedJSON(status, readiness)
+	})
+}
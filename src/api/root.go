@@ -6,8 +6,11 @@
 package api
 
 import (
+	"../cluster"
+	"../ha"
 	"../info"
 	"../manager"
+	"../utils/upgrade"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"os"
@@ -40,7 +43,7 @@ func attachRoot(app *gin.RouterGroup) {
 	app.GET("/dump", func(c *gin.Context) {
 		format := c.DefaultQuery("format", "toml")
 
-		data, err := manager.DumpConfig(format)
+		data, err := manager.DumpConfig(format, !showSecrets(c))
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, err.Error())
 			return
@@ -48,4 +51,93 @@ func attachRoot(app *gin.RouterGroup) {
 
 		c.String(http.StatusOK, data)
 	})
+
+	/**
+	 * Effective configuration app is running with, with ?format=json (default), toml or yaml
+	 */
+	app.GET("/config", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+
+		data, err := manager.DumpConfig(format, !showSecrets(c))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondConfig(c, data, format)
+	})
+
+	/**
+	 * Cluster node, peers and shared stick tables and rate limits
+	 */
+	app.GET("/cluster", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, cluster.Global.Status())
+	})
+
+	/**
+	 * High availability node state and virtual ips
+	 */
+	app.GET("/ha", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, ha.GetStatus())
+	})
+
+	/**
+	 * Binary upgrade status
+	 */
+	app.GET("/upgrade", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, upgrade.GetStatus())
+	})
+
+	/**
+	 * Reload configuration from the original source.
+	 * With dry_run=true it's only validated and errors are returned
+	 */
+	app.POST("/reload", func(c *gin.Context) {
+
+		if c.Query("dry_run") == "true" {
+			errs, err := manager.CheckReload()
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			messages := []string{}
+			for _, e := range errs {
+				messages = append(messages, e.Error())
+			}
+
+			status := http.StatusOK
+			if len(messages) > 0 {
+				status = http.StatusBadRequest
+			}
+
+			c.IndentedJSON(status, gin.H{"errors": messages})
+			return
+		}
+
+		if err := manager.Reload(); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, nil)
+	})
+}
+
+/**
+ * Responds with config encoded in format
+ */
+func respondConfig(c *gin.Context, data string, format string) {
+
+	contentType := "text/plain; charset=utf-8"
+	switch format {
+	case "json":
+		contentType = "application/json; charset=utf-8"
+	case "toml":
+		contentType = "application/toml; charset=utf-8"
+	case "yaml":
+		contentType = "application/yaml; charset=utf-8"
+	}
+
+	c.Data(http.StatusOK, contentType, []byte(data))
 }
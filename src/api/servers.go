@@ -10,7 +10,9 @@ import (
 	"../manager"
 	"../stats"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
+	"strconv"
 )
 
 /**
@@ -22,7 +24,15 @@ func attachServers(app *gin.RouterGroup) {
 	 * Find all current configured servers
 	 */
 	app.GET("/servers", func(c *gin.Context) {
-		c.IndentedJSON(http.StatusOK, manager.All())
+
+		servers := manager.All()
+		if !showSecrets(c) {
+			for name, server := range servers {
+				servers[name] = server.Redacted()
+			}
+		}
+
+		c.IndentedJSON(http.StatusOK, servers)
 	})
 
 	/**
@@ -30,7 +40,33 @@ func attachServers(app *gin.RouterGroup) {
 	 */
 	app.GET("/servers/:name", func(c *gin.Context) {
 		name := c.Param("name")
-		c.IndentedJSON(http.StatusOK, manager.Get(name))
+
+		server := manager.Get(name)
+		if cfg, ok := server.(config.Server); ok && !showSecrets(c) {
+			server = cfg.Redacted()
+		}
+
+		c.IndentedJSON(http.StatusOK, server)
+	})
+
+	/**
+	 * Effective server configuration with defaults applied and runtime
+	 * changes, with ?format=json (default), toml or yaml
+	 */
+	app.GET("/servers/:name/config", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+
+		data, err := manager.DumpServerConfig(c.Param("name"), format, !showSecrets(c))
+		if err != nil {
+			status := http.StatusBadRequest
+			if err.Error() == "Server not found" {
+				status = http.StatusNotFound
+			}
+			c.IndentedJSON(status, err.Error())
+			return
+		}
+
+		respondConfig(c, data, format)
 	})
 
 	/**
@@ -38,7 +74,278 @@ func attachServers(app *gin.RouterGroup) {
 	 */
 	app.DELETE("/servers/:name", func(c *gin.Context) {
 		name := c.Param("name")
-		manager.Delete(name)
+
+		if err := manager.Delete(name); err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondPersisted(c)
+	})
+
+	/**
+	 * Gracefully delete server by name, letting active connections
+	 * to finish up to ?timeout= (server drain_timeout by default)
+	 */
+	app.POST("/servers/:name/drain", func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := manager.Drain(name, c.Query("timeout")); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondPersisted(c)
+	})
+
+	/**
+	 * Get server access rules
+	 */
+	app.GET("/servers/:name/access", func(c *gin.Context) {
+		name := c.Param("name")
+
+		access, err := manager.GetAccess(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, access)
+	})
+
+	/**
+	 * Replace server access rules without restart
+	 */
+	app.PUT("/servers/:name/access", func(c *gin.Context) {
+		name := c.Param("name")
+
+		cfg := config.AccessConfig{}
+		if err := c.BindJSON(&cfg); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := manager.UpdateAccess(name, cfg); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondPersisted(c)
+	})
+
+	/**
+	 * Get healthcheck history of server backends: last check results
+	 * with latencies and failure reasons, and live status changes
+	 */
+	app.GET("/servers/:name/healthchecks", func(c *gin.Context) {
+		name := c.Param("name")
+
+		history, err := manager.HealthcheckHistory(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, history)
+	})
+
+	/**
+	 * Take backend out of rotation: it gets no new connections,
+	 * active ones are allowed to finish. Backend is addressed as host:port
+	 */
+	app.POST("/servers/:name/backends/:addr/drain", func(c *gin.Context) {
+		name := c.Param("name")
+		addr := c.Param("addr")
+
+		if err := manager.DrainBackend(name, addr, true); err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, nil)
+	})
+
+	/**
+	 * Return drained backend to rotation
+	 */
+	app.POST("/servers/:name/backends/:addr/enable", func(c *gin.Context) {
+		name := c.Param("name")
+		addr := c.Param("addr")
+
+		if err := manager.DrainBackend(name, addr, false); err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, nil)
+	})
+
+	/**
+	 * Get server current connections with proxied bytes and rates, i.e. top talkers with
+	 * ?sort=rx_second. Sorted by ?sort=age|rx|tx|rx_second|tx_second (age by default),
+	 * descending unless ?order=asc, and paged with ?offset=<n>&limit=<n>
+	 */
+	app.GET("/servers/:name/connections", func(c *gin.Context) {
+		name := c.Param("name")
+
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "offset should be integer")
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "limit should be integer")
+			return
+		}
+
+		connections, total, err := manager.Connections(name, c.Query("sort"), c.Query("order") != "asc", offset, limit)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"total":       total,
+			"connections": connections,
+		})
+	})
+
+	/**
+	 * Forcibly close server client connection by id
+	 */
+	app.DELETE("/servers/:name/connections/:id", func(c *gin.Context) {
+		name := c.Param("name")
+
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "id should be integer")
+			return
+		}
+
+		if err := manager.KillConnection(name, id); err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, nil)
+	})
+
+	/**
+	 * Get server current connections counts of client ips, the most connected first,
+	 * i.e. to see clients close to max_connections_per_client
+	 */
+	app.GET("/servers/:name/clients", func(c *gin.Context) {
+		name := c.Param("name")
+
+		counts, err := manager.ClientConnections(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, counts)
+	})
+
+	/**
+	 * Get server discovery health: last success, last error and consecutive errors
+	 */
+	app.GET("/servers/:name/discovery", func(c *gin.Context) {
+		name := c.Param("name")
+
+		health, err := manager.DiscoveryHealth(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, health)
+	})
+
+	/**
+	 * Get server stick table entries: client ips with backends they stick to
+	 */
+	app.GET("/servers/:name/sticky", func(c *gin.Context) {
+		name := c.Param("name")
+
+		entries, err := manager.StickTable(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, entries)
+	})
+
+	/**
+	 * Flush server stick table, or only entry of ?client=<ip>
+	 */
+	app.DELETE("/servers/:name/sticky", func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := manager.FlushStickTable(name, c.Query("client")); err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, nil)
+	})
+
+	/**
+	 * Get server canary config with current weight
+	 */
+	app.GET("/servers/:name/canary", func(c *gin.Context) {
+		name := c.Param("name")
+
+		canary, err := manager.GetCanary(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, canary)
+	})
+
+	/**
+	 * Change percent of connections taken by canary without restart, i.e. {"weight": 5}
+	 */
+	app.PUT("/servers/:name/canary", func(c *gin.Context) {
+		name := c.Param("name")
+
+		body := struct {
+			Weight *int `json:"weight"`
+		}{}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if body.Weight == nil {
+			c.IndentedJSON(http.StatusBadRequest, "weight is required")
+			return
+		}
+
+		if err := manager.UpdateCanary(name, *body.Weight); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondPersisted(c)
+	})
+
+	/**
+	 * Reload server tls certificate from files
+	 */
+	app.POST("/servers/:name/tls/reload", func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := manager.ReloadTls(name); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
 		c.IndentedJSON(http.StatusOK, nil)
 	})
 
@@ -60,15 +367,112 @@ func attachServers(app *gin.RouterGroup) {
 			return
 		}
 
-		c.IndentedJSON(http.StatusOK, nil)
+		respondPersisted(c)
 	})
 
 	/**
-	 * Get server stats
+	 * Update server with name :name, restarting it with new config
 	 */
-	app.GET("/servers/:name/stats", func(c *gin.Context) {
+	app.PUT("/servers/:name", func(c *gin.Context) {
+
 		name := c.Param("name")
-		c.IndentedJSON(http.StatusOK, stats.GetStats(name))
+
+		cfg := config.Server{}
+		if err := c.BindJSON(&cfg); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if manager.Get(name) == nil {
+			c.IndentedJSON(http.StatusNotFound, "Server not found")
+			return
+		}
+
+		if err := manager.Update(name, cfg); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondPersisted(c)
+	})
+
+	/**
+	 * Get server stats, or stats of sni route with ?route=<hostname>[@<alpn>], of mux
+	 * route with ?route=mux:<name>, of shadow pool with ?shadow=true or of canary pool with ?canary=true
+	 */
+	app.GET("/servers/:name/stats", func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, stats.GetStats(statsName(c)))
 	})
 
+	/**
+	 * Stream server stats (or sni route / shadow pool stats, same as for /stats)
+	 * as server-sent events, one "stats" event every stats interval
+	 */
+	app.GET("/servers/:name/stats/stream", func(c *gin.Context) {
+		name := statsName(c)
+
+		ch, unsubscribe, ok := stats.Subscribe(name)
+		if !ok {
+			c.IndentedJSON(http.StatusNotFound, "Server not found")
+			return
+		}
+		defer unsubscribe()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case s, ok := <-ch:
+				if !ok {
+					return false
+				}
+				c.SSEvent("stats", s)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+}
+
+/**
+ * Responds with success, persisting current configuration
+ * before if ?persist=true is requested
+ */
+func respondPersisted(c *gin.Context) {
+
+	if persist, _ := strconv.ParseBool(c.Query("persist")); persist {
+		if err := manager.Persist(); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Applied, but not persisted: "+err.Error())
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusOK, nil)
+}
+
+/**
+ * Returns stats handler name of requested server, its sni route with ?route=<hostname>[@<alpn>]
+ * or mux route with ?route=mux:<name>, shadow pool with ?shadow=true
+ * or canary pool with ?canary=true
+ */
+func statsName(c *gin.Context) string {
+
+	name := c.Param("name")
+
+	if route := c.Query("route"); route != "" {
+		return name + "/" + route
+	}
+
+	if shadow, _ := strconv.ParseBool(c.Query("shadow")); shadow {
+		return name + ":shadow"
+	}
+
+	if canary, _ := strconv.ParseBool(c.Query("canary")); canary {
+		return name + ":canary"
+	}
+
+	return name
 }
@@ -0,0 +1,75 @@
+/**
+ * plugin.go - loading balancers of go plugins
+ */
+
+package balance
+
+import (
+	"errors"
+	"plugin"
+	"sync"
+
+	"../core"
+	"../logging"
+)
+
+/**
+ * Symbol plugin exports, returning its balancers factories by balance name
+ */
+const pluginSymbol = "Balancers"
+
+/**
+ * Paths of loaded plugins, plugins can't be unloaded so every one is loaded once
+ */
+var plugins = struct {
+	sync.Mutex
+	loaded map[string]bool
+}{
+	loaded: map[string]bool{},
+}
+
+/**
+ * Loads go plugins (built with -buildmode=plugin against the same gobetween sources and
+ * go version) and registers their balancers. Every plugin exports
+ * func Balancers() map[string]func() core.Balancer. Already loaded plugins are skipped
+ */
+func LoadPlugins(paths []string) error {
+
+	log := logging.For("balance")
+
+	plugins.Lock()
+	defer plugins.Unlock()
+
+	for _, path := range paths {
+
+		if plugins.loaded[path] {
+			continue
+		}
+
+		p, err := plugin.Open(path)
+		if err != nil {
+			return err
+		}
+
+		symbol, err := p.Lookup(pluginSymbol)
+		if err != nil {
+			return err
+		}
+
+		balancers, ok := symbol.(func() map[string]func() core.Balancer)
+		if !ok {
+			return errors.New("plugin " + path + ": " + pluginSymbol + " should be func() map[string]func() core.Balancer of the same gobetween sources")
+		}
+
+		for name, factory := range balancers() {
+			if err := Register(name, factory); err != nil {
+				return errors.New("plugin " + path + ": " + err.Error())
+			}
+			log.Info("Registered balancer ", name, " of plugin ", path)
+		}
+
+		plugins.loaded[path] = true
+	}
+
+	return nil
+}
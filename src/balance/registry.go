@@ -7,7 +7,9 @@
 package balance
 
 import (
-	"reflect"
+	"errors"
+	"sort"
+	"sync"
 
 	"./middleware"
 
@@ -16,19 +18,81 @@ import (
 )
 
 /**
- * Type registry of available Balancers
+ * Factory creating balancer of every backends pool using it
  */
-var typeRegistry = make(map[string]reflect.Type)
+type Factory func() core.Balancer
 
 /**
- * Initialize type registry
+ * Registry of available balancers factories by balance name
+ */
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{
+	factories: map[string]Factory{},
+}
+
+/**
+ * Register builtin balancers
  */
 func init() {
-	typeRegistry["leastconn"] = reflect.TypeOf(LeastconnBalancer{})
-	typeRegistry["roundrobin"] = reflect.TypeOf(RoundrobinBalancer{})
-	typeRegistry["weight"] = reflect.TypeOf(WeightBalancer{})
-	typeRegistry["iphash"] = reflect.TypeOf(IphashBalancer{})
-	typeRegistry["leastbandwidth"] = reflect.TypeOf(LeastbandwidthBalancer{})
+	Register("leastconn", func() core.Balancer { return &LeastconnBalancer{} })
+	Register("roundrobin", func() core.Balancer { return &RoundrobinBalancer{} })
+	Register("weight", func() core.Balancer { return &WeightBalancer{} })
+	Register("iphash", func() core.Balancer { return &IphashBalancer{} })
+	Register("leastbandwidth", func() core.Balancer { return &LeastbandwidthBalancer{} })
+}
+
+/**
+ * Registers balancer factory under balance name, so servers may use it.
+ * Builtin balancers and ones of plugins are registered the same way
+ */
+func Register(name string, factory Factory) error {
+
+	if name == "" || factory == nil {
+		return errors.New("Balancer name and factory are required")
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.factories[name]; ok {
+		return errors.New("Balancer " + name + " is already registered")
+	}
+
+	registry.factories[name] = factory
+
+	return nil
+}
+
+/**
+ * Checks if balancer is registered under balance name
+ */
+func Registered(name string) bool {
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	_, ok := registry.factories[name]
+
+	return ok
+}
+
+/**
+ * Returns sorted names of registered balancers
+ */
+func Names() []string {
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
 
 /**
@@ -36,7 +100,12 @@ func init() {
  * Wrap it in middlewares if needed
  */
 func New(sniConf *config.Sni, balance string) core.Balancer {
-	balancer := reflect.New(typeRegistry[balance]).Elem().Addr().Interface().(core.Balancer)
+
+	registry.RLock()
+	factory := registry.factories[balance]
+	registry.RUnlock()
+
+	balancer := factory()
 
 	if sniConf == nil {
 		return balancer
@@ -0,0 +1,614 @@
+/**
+ * cluster.go - state shared with peer gobetween instances
+ */
+
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../utils"
+	"../utils/upgrade"
+)
+
+const (
+	/* Default bind peers push state to */
+	DEFAULT_BIND = "0.0.0.0:7946"
+
+	/* Default interval of pushing state to peers */
+	DEFAULT_INTERVAL = 1 * time.Second
+
+	/* Default push request timeout */
+	DEFAULT_TIMEOUT = 2 * time.Second
+
+	/* Max changes waiting for push, new ones are dropped over it */
+	maxPending = 100000
+)
+
+/**
+ * Process-wide cluster configured with [cluster] section
+ */
+var Global = New()
+
+/**
+ * Stick table entry shared with peers
+ */
+type StickEntry struct {
+	Client  string            `json:"client"`
+	Target  core.Target       `json:"target"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Expires time.Time         `json:"expires"`
+}
+
+/**
+ * New connections of rate limited client, or its ban, shared with peers
+ */
+type RateCount struct {
+	Client      string    `json:"client"`
+	Hits        int       `json:"hits"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+/**
+ * Stick table shared with peers
+ */
+type StickTable interface {
+
+	/**
+	 * Returns current entries, sent to peers that joined
+	 */
+	SharedEntries() []StickEntry
+
+	/**
+	 * Applies entries and flushed clients received from peer, empty client means all
+	 */
+	MergeShared(entries []StickEntry, flushes []string)
+}
+
+/**
+ * Rate limit shared with peers
+ */
+type RateLimit interface {
+
+	/**
+	 * Applies connections and bans of clients received from peer
+	 */
+	MergeShared(counts []RateCount, now time.Time)
+}
+
+/**
+ * Changes of shared state pushed to peers, by table or limit name
+ */
+type changes struct {
+	Sticks  map[string][]StickEntry `json:"sticks,omitempty"`
+	Flushes map[string][]string     `json:"flushes,omitempty"`
+	Rates   map[string][]RateCount  `json:"rates,omitempty"`
+}
+
+/**
+ * Changes not yet pushed, latest entry and summed counts by client
+ */
+type pending struct {
+	sticks  map[string]map[string]StickEntry
+	flushes map[string][]string
+	rates   map[string]map[string]*RateCount
+
+	count   int
+	dropped int
+}
+
+/**
+ * Peer state is pushed to
+ */
+type peer struct {
+	address string
+
+	/* Name and incarnation peer responded with */
+	node        string
+	incarnation string
+
+	/* Peer is this node itself, listed in peers */
+	self bool
+
+	/* Peer should get full state on next push, as it joined or missed changes */
+	full bool
+
+	alive     bool
+	lastSeen  time.Time
+	lastError string
+}
+
+/**
+ * Peer status
+ */
+type PeerStatus struct {
+	Address   string    `json:"address"`
+	Node      string    `json:"node"`
+	Alive     bool      `json:"alive"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+/**
+ * Cluster status
+ */
+type Status struct {
+	Enabled     bool         `json:"enabled"`
+	Node        string       `json:"node"`
+	Peers       []PeerStatus `json:"peers"`
+	StickTables []string     `json:"stick_tables"`
+	RateLimits  []string     `json:"rate_limits"`
+}
+
+/**
+ * Cluster pushes changes of local stick tables and rate limits to every peer
+ * each interval, and merges changes pushed by peers into tables and limits
+ * of the same name. Peers joining, restarted or unreachable for a while get full
+ * stick tables, so state converges without coordination
+ */
+type Cluster struct {
+
+	/* Guards configuration and current node */
+	sync.Mutex
+
+	/* Set if cluster is enabled, changes are collected only then */
+	enabled int32
+
+	cfg        config.ClusterConfig
+	configured bool
+
+	/* Random id of this process, changes on restart so peers send full state */
+	incarnation string
+
+	/* Node of current configuration, nil if disabled */
+	current *node
+
+	/* Tables and limits shared, by name */
+	registry sync.RWMutex
+	tables   map[string]StickTable
+	limits   map[string]RateLimit
+
+	/* Changes not yet pushed, guarded by its own lock as they are reported on every connection */
+	pendingLock sync.Mutex
+	pending     pending
+}
+
+/**
+ * Running cluster node of one configuration
+ */
+type node struct {
+	name     string
+	secret   []byte
+	interval time.Duration
+	client   *http.Client
+
+	/* Guards peers, updated on every push */
+	sync.Mutex
+	peers []*peer
+
+	server  *http.Server
+	stop    chan bool
+	stopped chan bool
+}
+
+/**
+ * Creates cluster, not started until configured
+ */
+func New() *Cluster {
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &Cluster{
+		incarnation: hex.EncodeToString(id),
+		tables:      map[string]StickTable{},
+		limits:      map[string]RateLimit{},
+		pending:     newPending(),
+	}
+}
+
+/**
+ * Applies configuration, restarting cluster if it's changed
+ */
+func (this *Cluster) Configure(cfg config.ClusterConfig) error {
+
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.configured && reflect.DeepEqual(cfg, this.cfg) {
+		return nil
+	}
+
+	if !cfg.Enabled {
+		this.shutdown()
+		this.cfg = cfg
+		this.configured = true
+		return nil
+	}
+
+	n := &node{
+		name:     cfg.NodeName,
+		secret:   []byte(cfg.Secret),
+		interval: durationOrDefault(cfg.Interval, DEFAULT_INTERVAL),
+		client:   &http.Client{Timeout: durationOrDefault(cfg.Timeout, DEFAULT_TIMEOUT)},
+		peers:    []*peer{},
+		stop:     make(chan bool),
+		stopped:  make(chan bool),
+	}
+
+	if n.name == "" {
+		n.name, _ = os.Hostname()
+	}
+
+	for _, address := range cfg.Peers {
+		n.peers = append(n.peers, &peer{address: address, full: true})
+	}
+
+	bind := clusterBind(cfg)
+
+	// Current node keeps running until new one listens, unless it's on the same bind
+	if this.current != nil && clusterBind(this.cfg) == bind {
+		this.shutdown()
+	}
+
+	// Listener is taken from old process on upgrade
+	listener, err := upgrade.Listen(bind)
+	if err != nil {
+		// Current node is stopped, so the same configuration is applied again
+		if this.current == nil {
+			this.configured = false
+		}
+		return err
+	}
+
+	this.shutdown()
+
+	log := logging.For("cluster")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(syncPath, func(w http.ResponseWriter, r *http.Request) {
+		this.handleSync(n, w, r)
+	})
+	n.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := n.server.Serve(listener); err != http.ErrServerClosed {
+			log.Error("Cluster server ", bind, " failed: ", err)
+		}
+	}()
+
+	this.cfg = cfg
+	this.configured = true
+	this.current = n
+	atomic.StoreInt32(&this.enabled, 1)
+
+	go this.loop(n)
+
+	log.Info("Cluster node ", n.name, " listening on ", bind, ", pushing to ", len(n.peers), " peers every ", n.interval)
+
+	return nil
+}
+
+/**
+ * Returns bind of cluster node, default one if not set
+ */
+func clusterBind(cfg config.ClusterConfig) string {
+
+	if cfg.Bind == "" {
+		return DEFAULT_BIND
+	}
+
+	return cfg.Bind
+}
+
+/**
+ * Stops cluster, i.e. on binary upgrade
+ */
+func (this *Cluster) Stop() {
+	this.Lock()
+	defer this.Unlock()
+
+	this.shutdown()
+}
+
+/**
+ * Stops server and pushing of current node, should be called with lock held
+ */
+func (this *Cluster) shutdown() {
+
+	atomic.StoreInt32(&this.enabled, 0)
+
+	if this.current != nil {
+		this.current.server.Close()
+		close(this.current.stop)
+		<-this.current.stopped
+		this.current = nil
+	}
+
+	this.takePending()
+}
+
+/**
+ * Shares stick table with peers tables of the same name
+ */
+func (this *Cluster) AddStickTable(name string, table StickTable) {
+	this.registry.Lock()
+	defer this.registry.Unlock()
+
+	this.tables[name] = table
+}
+
+/**
+ * Stops sharing stick table, unless table of the same name replaced it
+ */
+func (this *Cluster) RemoveStickTable(name string, table StickTable) {
+	this.registry.Lock()
+	defer this.registry.Unlock()
+
+	if this.tables[name] == table {
+		delete(this.tables, name)
+	}
+}
+
+/**
+ * Shares rate limit with peers limits of the same name
+ */
+func (this *Cluster) AddRateLimit(name string, limit RateLimit) {
+	this.registry.Lock()
+	defer this.registry.Unlock()
+
+	this.limits[name] = limit
+}
+
+/**
+ * Stops sharing rate limit, unless limit of the same name replaced it
+ */
+func (this *Cluster) RemoveRateLimit(name string, limit RateLimit) {
+	this.registry.Lock()
+	defer this.registry.Unlock()
+
+	if this.limits[name] == limit {
+		delete(this.limits, name)
+	}
+}
+
+/**
+ * Reports stick table entry added or updated locally
+ */
+func (this *Cluster) ReportStick(table string, entry StickEntry) {
+
+	if atomic.LoadInt32(&this.enabled) == 0 {
+		return
+	}
+
+	this.pendingLock.Lock()
+	defer this.pendingLock.Unlock()
+
+	entries, ok := this.pending.sticks[table]
+	if !ok {
+		entries = map[string]StickEntry{}
+		this.pending.sticks[table] = entries
+	}
+
+	if _, ok := entries[entry.Client]; !ok && !this.pending.reserve() {
+		return
+	}
+
+	entries[entry.Client] = entry
+}
+
+/**
+ * Reports stick table client flushed locally, empty client means all
+ */
+func (this *Cluster) ReportStickFlush(table string, client string) {
+
+	if atomic.LoadInt32(&this.enabled) == 0 {
+		return
+	}
+
+	this.pendingLock.Lock()
+	defer this.pendingLock.Unlock()
+
+	if !this.pending.reserve() {
+		return
+	}
+
+	/* Entries put before flush are not pushed, peers apply flushes first */
+	if client == "" {
+		delete(this.pending.sticks, table)
+	} else {
+		delete(this.pending.sticks[table], client)
+	}
+
+	this.pending.flushes[table] = append(this.pending.flushes[table], client)
+}
+
+/**
+ * Reports new connections or ban of rate limited client
+ */
+func (this *Cluster) ReportRate(limit string, count RateCount) {
+
+	if atomic.LoadInt32(&this.enabled) == 0 {
+		return
+	}
+
+	this.pendingLock.Lock()
+	defer this.pendingLock.Unlock()
+
+	counts, ok := this.pending.rates[limit]
+	if !ok {
+		counts = map[string]*RateCount{}
+		this.pending.rates[limit] = counts
+	}
+
+	c, ok := counts[count.Client]
+	if !ok {
+		if !this.pending.reserve() {
+			return
+		}
+		c = &RateCount{Client: count.Client}
+		counts[count.Client] = c
+	}
+
+	c.Hits += count.Hits
+	if count.BannedUntil.After(c.BannedUntil) {
+		c.BannedUntil = count.BannedUntil
+	}
+}
+
+/**
+ * Takes pending changes and count of ones dropped as there were too many of them
+ */
+func (this *Cluster) takePending() (changes, int) {
+
+	this.pendingLock.Lock()
+	taken := this.pending
+	this.pending = newPending()
+	this.pendingLock.Unlock()
+
+	result := changes{
+		Sticks:  map[string][]StickEntry{},
+		Flushes: taken.flushes,
+		Rates:   map[string][]RateCount{},
+	}
+
+	for table, entries := range taken.sticks {
+		for _, e := range entries {
+			result.Sticks[table] = append(result.Sticks[table], e)
+		}
+	}
+
+	for limit, counts := range taken.rates {
+		for _, c := range counts {
+			result.Rates[limit] = append(result.Rates[limit], *c)
+		}
+	}
+
+	return result, taken.dropped
+}
+
+/**
+ * Counts new pending change, returns false if there are too many of them
+ */
+func (this *pending) reserve() bool {
+
+	if this.count >= maxPending {
+		this.dropped++
+		return false
+	}
+
+	this.count++
+	return true
+}
+
+/**
+ * Returns current cluster status
+ */
+func (this *Cluster) Status() Status {
+
+	status := Status{
+		Peers:       []PeerStatus{},
+		StickTables: []string{},
+		RateLimits:  []string{},
+	}
+
+	this.Lock()
+	n := this.current
+	this.Unlock()
+
+	if n != nil {
+		status.Enabled = true
+		status.Node = n.name
+
+		n.Lock()
+		for _, p := range n.peers {
+			if !p.self {
+				status.Peers = append(status.Peers, PeerStatus{p.address, p.node, p.alive, p.lastSeen, p.lastError})
+			}
+		}
+		n.Unlock()
+	}
+
+	this.registry.RLock()
+	for name := range this.tables {
+		status.StickTables = append(status.StickTables, name)
+	}
+	for name := range this.limits {
+		status.RateLimits = append(status.RateLimits, name)
+	}
+	this.registry.RUnlock()
+
+	sort.Strings(status.StickTables)
+	sort.Strings(status.RateLimits)
+
+	return status
+}
+
+/**
+ * Validates cluster configuration
+ */
+func Validate(cfg config.ClusterConfig) error {
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if len(cfg.Secret) < 16 {
+		return errors.New("secret of at least 16 characters is required")
+	}
+
+	if cfg.Bind != "" {
+		if _, _, err := net.SplitHostPort(cfg.Bind); err != nil {
+			return errors.New("bind should be host:port")
+		}
+	}
+
+	for _, p := range cfg.Peers {
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			return errors.New("peer " + p + " should be host:port")
+		}
+	}
+
+	for _, d := range []string{cfg.Interval, cfg.Timeout} {
+		if d == "" {
+			continue
+		}
+		if parsed, err := time.ParseDuration(d); err != nil || parsed <= 0 {
+			return errors.New("interval and timeout should be positive durations")
+		}
+	}
+
+	return nil
+}
+
+func newPending() pending {
+	return pending{
+		sticks:  map[string]map[string]StickEntry{},
+		flushes: map[string][]string{},
+		rates:   map[string]map[string]*RateCount{},
+	}
+}
+
+func durationOrDefault(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	return utils.ParseDurationOrDefault(value, def)
+}
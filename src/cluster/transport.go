@@ -0,0 +1,361 @@
+/**
+ * transport.go - pushing changes to peers over http, signed with shared secret
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../logging"
+)
+
+const (
+	/* Path peers push changes to */
+	syncPath = "/cluster/v1/sync"
+
+	/* Max push body size */
+	maxMessageSize = 64 << 20
+
+	/* Max difference of push timestamp and local time, so captured pushes can't be replayed later */
+	maxClockSkew = 1 * time.Minute
+
+	timestampHeader = "X-Gobetween-Timestamp"
+	signatureHeader = "X-Gobetween-Signature"
+)
+
+/**
+ * Push of node changes
+ */
+type message struct {
+	Node        string `json:"node"`
+	Incarnation string `json:"incarnation"`
+	changes
+}
+
+/**
+ * Peer response to push
+ */
+type reply struct {
+	Node        string `json:"node"`
+	Incarnation string `json:"incarnation"`
+}
+
+/**
+ * Pushes pending changes to peers every interval until node is stopped
+ */
+func (this *Cluster) loop(n *node) {
+
+	log := logging.For("cluster")
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	defer close(n.stopped)
+
+	for {
+		select {
+		case <-n.stop:
+			return
+
+		case <-ticker.C:
+			delta, dropped := this.takePending()
+
+			if dropped > 0 {
+				log.Warn("Dropped ", dropped, " changes over ", maxPending, " since last push, sending full state to peers")
+				n.Lock()
+				for _, p := range n.peers {
+					p.full = true
+				}
+				n.Unlock()
+			}
+
+			this.push(n, delta)
+		}
+	}
+}
+
+/**
+ * Sends changes to every peer, or full state to peers that need it, and waits for responses
+ */
+func (this *Cluster) push(n *node, delta changes) {
+
+	log := logging.For("cluster")
+
+	type target struct {
+		peer *peer
+		full bool
+	}
+
+	targets := []target{}
+	needFull := false
+
+	n.Lock()
+	for _, p := range n.peers {
+		if !p.self {
+			targets = append(targets, target{p, p.full})
+			needFull = needFull || p.full
+		}
+	}
+	n.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	deltaBody, err := json.Marshal(message{n.name, this.incarnation, delta})
+	if err != nil {
+		log.Error("Can't encode changes: ", err)
+		return
+	}
+
+	fullBody := deltaBody
+	if needFull {
+		if fullBody, err = json.Marshal(message{n.name, this.incarnation, this.full(delta)}); err != nil {
+			log.Error("Can't encode full state: ", err)
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+
+			body := deltaBody
+			if t.full {
+				body = fullBody
+			}
+
+			r, err := this.send(n, t.peer.address, body)
+
+			n.Lock()
+			defer n.Unlock()
+
+			p := t.peer
+
+			if err != nil {
+				if p.lastError == "" {
+					log.Warn("Peer ", p.address, " is unreachable: ", err)
+				}
+				p.alive = false
+				p.full = true
+				p.lastError = err.Error()
+				return
+			}
+
+			if r.Incarnation == this.incarnation {
+				log.Info("Peer ", p.address, " is this node, skipping it")
+				p.self = true
+				return
+			}
+
+			if !p.alive {
+				log.Info("Peer ", p.address, " (", r.Node, ") is alive")
+			}
+
+			/* Restarted peer lost state it got before */
+			if t.full {
+				p.full = false
+			} else if r.Incarnation != p.incarnation {
+				p.full = true
+			}
+
+			p.node = r.Node
+			p.incarnation = r.Incarnation
+			p.alive = true
+			p.lastSeen = time.Now()
+			p.lastError = ""
+		}(t)
+	}
+
+	wg.Wait()
+}
+
+/**
+ * Returns current entries of every shared stick table, with flushes and rates of delta
+ */
+func (this *Cluster) full(delta changes) changes {
+
+	this.registry.RLock()
+	tables := make(map[string]StickTable, len(this.tables))
+	for name, t := range this.tables {
+		tables[name] = t
+	}
+	this.registry.RUnlock()
+
+	full := changes{
+		Sticks:  map[string][]StickEntry{},
+		Flushes: delta.Flushes,
+		Rates:   delta.Rates,
+	}
+
+	for name, t := range tables {
+		if entries := t.SharedEntries(); len(entries) > 0 {
+			full.Sticks[name] = entries
+		}
+	}
+
+	return full
+}
+
+/**
+ * Posts signed body to peer
+ */
+func (this *Cluster) send(n *node, address string, body []byte) (reply, error) {
+
+	r := reply{}
+
+	req, err := http.NewRequest("POST", "http://"+address+syncPath, bytes.NewReader(body))
+	if err != nil {
+		return r, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, sign(n.secret, timestamp, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return r, errors.New(resp.Status + " " + strings.TrimSpace(string(b)))
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&r)
+
+	return r, err
+}
+
+/**
+ * Handles push of peer, merging its changes
+ */
+func (this *Cluster) handleSync(n *node, w http.ResponseWriter, r *http.Request) {
+
+	log := logging.For("cluster")
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(body) > maxMessageSize {
+		http.Error(w, "message is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := verify(n.secret, r.Header, body, time.Now()); err != nil {
+		log.Warn("Rejected push of ", r.RemoteAddr, ": ", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	msg := message{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if msg.Incarnation != this.incarnation {
+		this.merge(msg.changes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply{n.name, this.incarnation})
+}
+
+/**
+ * Applies changes of peer to tables and limits of the same name
+ */
+func (this *Cluster) merge(c changes) {
+
+	now := time.Now()
+
+	this.registry.RLock()
+	tables := map[string]StickTable{}
+	for name := range c.Sticks {
+		if t, ok := this.tables[name]; ok {
+			tables[name] = t
+		}
+	}
+	for name := range c.Flushes {
+		if t, ok := this.tables[name]; ok {
+			tables[name] = t
+		}
+	}
+	limits := map[string]RateLimit{}
+	for name := range c.Rates {
+		if l, ok := this.limits[name]; ok {
+			limits[name] = l
+		}
+	}
+	this.registry.RUnlock()
+
+	for name, t := range tables {
+		t.MergeShared(c.Sticks[name], c.Flushes[name])
+	}
+
+	for name, l := range limits {
+		l.MergeShared(c.Rates[name], now)
+	}
+}
+
+/**
+ * Returns hex HMAC-SHA256 of timestamp and body
+ */
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Checks push is signed with secret and is recent
+ */
+func verify(secret []byte, header http.Header, body []byte, now time.Time) error {
+
+	timestamp := header.Get(timestampHeader)
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("no valid timestamp")
+	}
+
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.New("timestamp is off by " + skew.String() + ", clocks should be synchronized")
+	}
+
+	expected := sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(signatureHeader))) {
+		return errors.New("signature mismatch, secret differs")
+	}
+
+	return nil
+}
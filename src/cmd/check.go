@@ -0,0 +1,62 @@
+/**
+ * check.go - validate config file and exit
+ */
+package cmd
+
+import (
+	"../manager"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+/* Parsed options */
+var checkConfigPath string
+
+/**
+ * Add command
+ */
+func init() {
+	CheckCmd.Flags().StringVarP(&checkConfigPath, "config", "c", "", "Path to configuration file")
+	RootCmd.AddCommand(CheckCmd)
+}
+
+/**
+ * Check command
+ */
+var CheckCmd = &cobra.Command{
+	Use:   "check -c <path>",
+	Short: "Validate config file and exit",
+	Long:  `Parse config file with included ones failing on unknown keys, validate servers as on start, tls files and binds conflicts, and exit with non-zero status if config is not valid`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		path := checkConfigPath
+		if path == "" && len(args) == 1 {
+			path = args[0]
+		}
+
+		if path == "" {
+			cmd.Help()
+			os.Exit(2)
+		}
+
+		strictDecode = true
+
+		cfg, _, err := loadFromFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, path+": "+err.Error())
+			os.Exit(1)
+		}
+
+		errs := manager.Validate(*cfg)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, path+": "+err.Error())
+		}
+
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+
+		fmt.Println(path + ": configuration is valid")
+	},
+}
@@ -7,17 +7,39 @@ package cmd
 
 import (
 	"../config"
+	"time"
 )
 
+/* Wait before retrying watching config source after error */
+const watchRetryWait = 2 * time.Second
+
+/**
+ * Loads configuration again from the same source
+ * app was started with, used on configuration reload
+ */
+type ConfigLoader func() (*config.Config, error)
+
+/**
+ * Saves configuration back to the source app was started
+ * with, nil if source does not support it
+ */
+type ConfigSaver func(config.Config) error
+
+/**
+ * Watches source app was started with for configuration changes, calling
+ * reload on every change, nil if source does not support it. Runs forever
+ */
+type ConfigWatcher func(reload func())
+
 /**
  * App Start function to call after initialization
  */
-var start func(*config.Config)
+var start func(*config.Config, ConfigLoader, ConfigSaver, ConfigWatcher)
 
 /**
  * Execute processing flags
  */
-func Execute(f func(*config.Config)) {
+func Execute(f func(*config.Config, ConfigLoader, ConfigSaver, ConfigWatcher)) {
 	start = f
 	RootCmd.Execute()
 }
@@ -0,0 +1,198 @@
+/**
+ * decode.go - decoding config with environment variables and includes
+ */
+package cmd
+
+import (
+	"../config"
+	"../utils/codec"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Fail on unknown keys when decoding, set by check command
+ */
+var strictDecode bool
+
+/**
+ * Matches "${VAR}", "${VAR:-default}" and "$$" escaping "$"
+ */
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+/**
+ * Returns format of config from url, consul or etcd, --format or "toml"
+ */
+func sourceFormat() string {
+
+	if format == "" {
+		return "toml"
+	}
+
+	return format
+}
+
+/**
+ * Returns format of config file, --format if it's set, or
+ * "json" and "yaml" detected by extension, "toml" otherwise
+ */
+func fileFormat(path string) string {
+
+	if format != "" {
+		return format
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "toml"
+	}
+}
+
+/**
+ * Decodes config in format, substituting environment variables.
+ * Returns true if any variable was substituted
+ */
+func decodeConfig(data string, format string) (*config.Config, bool, error) {
+
+	data, substituted, err := interpolateEnv(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	decode := codec.Decode
+	if strictDecode {
+		decode = codec.DecodeStrict
+	}
+
+	var cfg config.Config
+	if err := decode(data, &cfg, format); err != nil {
+		return nil, false, err
+	}
+
+	return &cfg, substituted, nil
+}
+
+/**
+ * Decodes config of url, consul or etcd source, substituting environment
+ * variables. Include is supported for config files only
+ */
+func decodeRemoteConfig(data string) (*config.Config, error) {
+
+	cfg, _, err := decodeConfig(data, sourceFormat())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Include) > 0 {
+		return nil, errors.New("include is supported for config files only")
+	}
+
+	return cfg, nil
+}
+
+/**
+ * Substitutes "${VAR}" with value of environment variable, and "${VAR:-default}"
+ * with default if variable is not set or empty. "$$" is left as "$".
+ * Lines commented with "#" are left as is. Returns true if any variable was substituted
+ */
+func interpolateEnv(data string) (string, bool, error) {
+
+	var err error
+	substituted := false
+
+	lines := strings.Split(data, "\n")
+
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		lines[i] = envPattern.ReplaceAllStringFunc(line, func(match string) string {
+
+			if match == "$$" {
+				return "$"
+			}
+
+			groups := envPattern.FindStringSubmatch(match)
+			name, hasDefault, def := groups[1], groups[2] != "", groups[3]
+
+			substituted = true
+
+			value, ok := os.LookupEnv(name)
+			if ok && (value != "" || !hasDefault) {
+				return value
+			}
+
+			if !hasDefault {
+				err = errors.New("Environment variable " + name + " is not set")
+			}
+
+			return def
+		})
+	}
+
+	return strings.Join(lines, "\n"), substituted, err
+}
+
+/**
+ * Merges servers of config files matching include patterns into cfg. Relative
+ * patterns are relative to dir. Included files may contain servers only
+ */
+func includeConfigs(cfg *config.Config, dir string) error {
+
+	for _, pattern := range cfg.Include {
+
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.New("Bad include pattern " + pattern + ": " + err.Error())
+		}
+
+		for _, path := range paths {
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			included, _, err := decodeConfig(string(data), fileFormat(path))
+			if err != nil {
+				return errors.New("Error decoding included " + path + ": " + err.Error())
+			}
+
+			servers := included.Servers
+			included.Servers = nil
+
+			if !reflect.DeepEqual(*included, config.Config{}) {
+				return errors.New("Included " + path + " may contain servers only")
+			}
+
+			if cfg.Servers == nil {
+				cfg.Servers = map[string]config.Server{}
+			}
+
+			for name, server := range servers {
+				if _, ok := cfg.Servers[name]; ok {
+					return errors.New("Server " + name + " of included " + path + " is already defined")
+				}
+				cfg.Servers[name] = server
+			}
+		}
+	}
+
+	cfg.Include = nil
+
+	return nil
+}
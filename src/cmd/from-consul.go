@@ -8,15 +8,22 @@ package cmd
 import (
 	"../config"
 	"../info"
-	"../utils/codec"
+	"../logging"
+	"bytes"
+	"errors"
 	consul "github.com/hashicorp/consul/api"
 	"github.com/spf13/cobra"
 	"log"
+	"time"
 )
 
 /* Parsed options */
 var consulKey string
 var consulConfig consul.Config = consul.Config{}
+var consulWatch bool
+
+/* Wait time of consul blocking query watching config key */
+const consulWatchWait = 5 * time.Minute
 
 /**
  * Add command
@@ -25,6 +32,8 @@ func init() {
 
 	FromConsulCmd.Flags().StringVarP(&consulKey, "key", "k", "gobetween", "Consul Key to pull config from")
 	FromConsulCmd.Flags().StringVarP(&consulConfig.Scheme, "scheme", "s", "http", "http or https")
+	FromConsulCmd.Flags().StringVarP(&consulConfig.Token, "token", "t", "", "Consul ACL token")
+	FromConsulCmd.Flags().BoolVarP(&consulWatch, "watch", "w", false, "Watch key and reload config when it's changed")
 
 	RootCmd.AddCommand(FromConsulCmd)
 }
@@ -44,31 +53,100 @@ var FromConsulCmd = &cobra.Command{
 		}
 
 		consulConfig.Address = args[0]
-		client, err := consul.NewClient(&consulConfig)
+		load := func() (*config.Config, error) {
+			return loadFromConsul(consulConfig, consulKey)
+		}
+
+		var watch ConfigWatcher
+		if consulWatch {
+			watch = func(reload func()) {
+				watchConsul(consulConfig, consulKey, reload)
+			}
+		}
+
+		cfg, err := load()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		pair, _, err := client.KV().Get(consulKey, nil)
+		info.Configuration = struct {
+			Kind  string `json:"kind"`
+			Host  string `json:"host"`
+			Key   string `json:"key"`
+			Watch bool   `json:"watch"`
+		}{"consul", consulConfig.Address, consulKey, consulWatch}
+
+		start(cfg, load, nil, watch)
+	},
+}
+
+/**
+ * Fetch and decode config from consul key
+ */
+func loadFromConsul(consulConfig consul.Config, key string) (*config.Config, error) {
+
+	client, err := consul.NewClient(&consulConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, _, err := client.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil {
+		return nil, errors.New("Empty value for key " + key)
+	}
+
+	return decodeRemoteConfig(string(pair.Value))
+}
+
+/**
+ * Watch consul key with blocking queries, calling reload when its value is changed
+ */
+func watchConsul(consulConfig consul.Config, key string, reload func()) {
+
+	log := logging.For("cmd/consul")
+
+	client, err := consul.NewClient(&consulConfig)
+	if err != nil {
+		log.Error("Can't watch config in consul: ", err)
+		return
+	}
+
+	var index uint64
+	var value []byte
+
+	for {
+		pair, meta, err := client.KV().Get(key, &consul.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  consulWatchWait,
+		})
+
 		if err != nil {
-			log.Fatal(err)
+			log.Error("Error watching config key ", key, ": ", err, ", retrying in ", watchRetryWait)
+			time.Sleep(watchRetryWait)
+			continue
 		}
 
-		if pair == nil {
-			log.Fatal("Empty value for key " + consulKey)
+		// Index going backwards means consul state was reset
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
 		}
 
-		var cfg config.Config
-		if err := codec.Decode(string(pair.Value), &cfg, format); err != nil {
-			log.Fatal(err)
+		if pair == nil {
+			log.Warn("Config key ", key, " is removed, keeping current config")
+			continue
 		}
 
-		info.Configuration = struct {
-			Kind string `json:"kind"`
-			Host string `json:"host"`
-			Key  string `json:"key"`
-		}{"consul", consulConfig.Address, consulKey}
+		if value != nil && !bytes.Equal(value, pair.Value) {
+			log.Info("Config key ", key, " is changed, reloading")
+			reload()
+		}
 
-		start(&cfg)
-	},
+		value = pair.Value
+	}
 }
@@ -0,0 +1,198 @@
+/**
+ * from-etcd.go - pull config from etcd v3 and run
+ */
+package cmd
+
+import (
+	"../config"
+	"../info"
+	"../logging"
+	"errors"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+	"log"
+	"strings"
+	"time"
+)
+
+/* Parsed options */
+var etcdKey string
+var etcdUsername string
+var etcdPassword string
+var etcdTlsCertPath string
+var etcdTlsKeyPath string
+var etcdTlsCacertPath string
+var etcdWatch bool
+
+/* Timeout of etcd connection and requests */
+const etcdTimeout = 5 * time.Second
+
+/**
+ * Add command
+ */
+func init() {
+
+	FromEtcdCmd.Flags().StringVarP(&etcdKey, "key", "k", "gobetween", "Etcd key to pull config from")
+	FromEtcdCmd.Flags().StringVarP(&etcdUsername, "username", "u", "", "Etcd username")
+	FromEtcdCmd.Flags().StringVarP(&etcdPassword, "password", "p", "", "Etcd password")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsCertPath, "tls-cert", "", "Client certificate path for etcd tls")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsKeyPath, "tls-key", "", "Client key path for etcd tls")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsCacertPath, "tls-cacert", "", "CA certificate path for etcd tls, enables tls")
+	FromEtcdCmd.Flags().BoolVarP(&etcdWatch, "watch", "w", false, "Watch key and reload config when it's changed")
+
+	RootCmd.AddCommand(FromEtcdCmd)
+}
+
+/**
+ * FromEtcd command
+ */
+var FromEtcdCmd = &cobra.Command{
+	Use:   "from-etcd <host:port>[,<host:port>...]",
+	Short: "Start using config from etcd",
+	Long:  `Start using config from the etcd v3 key-value storage`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+
+		endpoints := strings.Split(args[0], ",")
+		load := func() (*config.Config, error) {
+			return loadFromEtcd(endpoints, etcdKey)
+		}
+
+		var watch ConfigWatcher
+		if etcdWatch {
+			watch = func(reload func()) {
+				watchEtcd(endpoints, etcdKey, reload)
+			}
+		}
+
+		cfg, err := load()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		info.Configuration = struct {
+			Kind      string   `json:"kind"`
+			Endpoints []string `json:"endpoints"`
+			Key       string   `json:"key"`
+			Watch     bool     `json:"watch"`
+		}{"etcd", endpoints, etcdKey, etcdWatch}
+
+		start(cfg, load, nil, watch)
+	},
+}
+
+/**
+ * Create etcd v3 client
+ */
+func etcdClient(endpoints []string) (*clientv3.Client, error) {
+
+	clientCfg := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: etcdTimeout,
+		Username:    etcdUsername,
+		Password:    etcdPassword,
+	}
+
+	if etcdTlsCacertPath != "" || etcdTlsCertPath != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      etcdTlsCertPath,
+			KeyFile:       etcdTlsKeyPath,
+			TrustedCAFile: etcdTlsCacertPath,
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		clientCfg.TLS = tlsConfig
+	}
+
+	return clientv3.New(clientCfg)
+}
+
+/**
+ * Fetch and decode config from etcd key
+ */
+func loadFromEtcd(endpoints []string, key string) (*config.Config, error) {
+
+	client, err := etcdClient(endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, errors.New("Empty value for key " + key)
+	}
+
+	return decodeRemoteConfig(string(resp.Kvs[0].Value))
+}
+
+/**
+ * Watch etcd key, calling reload when it's put
+ */
+func watchEtcd(endpoints []string, key string, reload func()) {
+
+	log := logging.For("cmd/etcd")
+
+	for {
+		if err := watchEtcdKey(endpoints, key, reload); err != nil {
+			log.Error("Error watching config key ", key, ": ", err, ", retrying in ", watchRetryWait)
+		}
+
+		time.Sleep(watchRetryWait)
+	}
+}
+
+/**
+ * Watch etcd key until watch fails
+ */
+func watchEtcdKey(endpoints []string, key string, reload func()) error {
+
+	log := logging.For("cmd/etcd")
+
+	client, err := etcdClient(endpoints)
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for watchResp := range client.Watch(ctx, key) {
+
+		if err := watchResp.Err(); err != nil {
+			return err
+		}
+
+		for _, event := range watchResp.Events {
+			switch event.Type {
+			case clientv3.EventTypePut:
+				log.Info("Config key ", key, " is changed, reloading")
+				reload()
+			case clientv3.EventTypeDelete:
+				log.Warn("Config key ", key, " is removed, keeping current config")
+			}
+		}
+	}
+
+	return errors.New("etcd watch channel closed")
+}
@@ -9,9 +9,12 @@ import (
 	"../config"
 	"../info"
 	"../utils/codec"
+	"errors"
 	"github.com/spf13/cobra"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 )
 
 /**
@@ -34,13 +37,28 @@ var FromFileCmd = &cobra.Command{
 			return
 		}
 
-		data, err := ioutil.ReadFile(args[0])
-		if err != nil {
-			log.Fatal(err)
+		path := args[0]
+
+		// Config assembled from includes or environment can't be written back as is
+		var generated bool
+
+		load := func() (*config.Config, error) {
+			cfg, g, err := loadFromFile(path)
+			if err == nil {
+				generated = g
+			}
+			return cfg, err
+		}
+
+		save := func(cfg config.Config) error {
+			if generated {
+				return errors.New("Config file with include or environment variables can't be saved")
+			}
+			return saveToFile(path, cfg)
 		}
 
-		var cfg config.Config
-		if err = codec.Decode(string(data), &cfg, format); err != nil {
+		cfg, err := load()
+		if err != nil {
 			log.Fatal(err)
 		}
 
@@ -49,6 +67,49 @@ var FromFileCmd = &cobra.Command{
 			Path string `json:"path"`
 		}{"file", args[0]}
 
-		start(&cfg)
+		start(cfg, load, save, nil)
 	},
 }
+
+/**
+ * Read and decode config file with included ones. Returns true
+ * if config has includes or environment variables substituted
+ */
+func loadFromFile(path string) (*config.Config, bool, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cfg, substituted, err := decodeConfig(string(data), fileFormat(path))
+	if err != nil {
+		return nil, false, err
+	}
+
+	generated := substituted || len(cfg.Include) > 0
+
+	if err := includeConfigs(cfg, filepath.Dir(path)); err != nil {
+		return nil, false, err
+	}
+
+	return cfg, generated, nil
+}
+
+/**
+ * Encode and write config file, replacing it atomically
+ */
+func saveToFile(path string, cfg config.Config) error {
+
+	var data string
+	if err := codec.Encode(cfg, &data, fileFormat(path)); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -8,18 +8,27 @@ package cmd
 import (
 	"../config"
 	"../info"
-	"../utils/codec"
+	"../logging"
+	"bytes"
+	"errors"
 	"github.com/spf13/cobra"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+/* Parsed options */
+var urlWatchInterval time.Duration
+
 /**
  * Add command
  */
 func init() {
 
+	FromUrlCmd.Flags().DurationVarP(&urlWatchInterval, "watch-interval", "w", 0, "Poll url with interval, i.e. 30s, and reload config when it's changed, 0 disables")
+
 	RootCmd.AddCommand(FromUrlCmd)
 }
 
@@ -36,30 +45,89 @@ var FromUrlCmd = &cobra.Command{
 			return
 		}
 
-		client := http.Client{}
-		res, err := client.Get(args[0])
-		if err != nil {
-			log.Fatal(err)
+		url := args[0]
+		load := func() (*config.Config, error) {
+			return loadFromUrl(url)
 		}
 
-		defer res.Body.Close()
-
-		// Read response
-		content, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			log.Fatal(err)
+		var watch ConfigWatcher
+		if urlWatchInterval > 0 {
+			watch = func(reload func()) {
+				watchUrl(url, urlWatchInterval, reload)
+			}
 		}
 
-		var cfg config.Config
-		if err := codec.Decode(string(content), &cfg, format); err != nil {
+		cfg, err := load()
+		if err != nil {
 			log.Fatal(err)
 		}
 
 		info.Configuration = struct {
-			Kind string `json:"kind"`
-			Url  string `json:"url"`
-		}{"url", args[0]}
+			Kind          string `json:"kind"`
+			Url           string `json:"url"`
+			WatchInterval string `json:"watch_interval"`
+		}{"url", args[0], urlWatchInterval.String()}
 
-		start(&cfg)
+		start(cfg, load, nil, watch)
 	},
 }
+
+/**
+ * Fetch and decode config from url
+ */
+func loadFromUrl(url string) (*config.Config, error) {
+
+	content, err := fetchUrl(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRemoteConfig(string(content))
+}
+
+/**
+ * Fetch url response body
+ */
+func fetchUrl(url string) ([]byte, error) {
+
+	client := http.Client{}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Unexpected response status " + strconv.Itoa(res.StatusCode))
+	}
+
+	// Read response
+	return ioutil.ReadAll(res.Body)
+}
+
+/**
+ * Poll url with interval, calling reload when response is changed
+ */
+func watchUrl(url string, interval time.Duration, reload func()) {
+
+	log := logging.For("cmd/url")
+
+	var content []byte
+
+	for {
+		fetched, err := fetchUrl(url)
+
+		if err != nil {
+			log.Error("Error watching config url ", url, ": ", err)
+		} else {
+			if content != nil && !bytes.Equal(content, fetched) {
+				log.Info("Config url ", url, " response is changed, reloading")
+				reload()
+			}
+			content = fetched
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -26,7 +26,7 @@ var showVersion bool
 func init() {
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Print version information and quit")
 	RootCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to configuration file")
-	RootCmd.PersistentFlags().StringVarP(&format, "format", "f", "toml", "Configuration file format: \"toml\" or \"json\"")
+	RootCmd.PersistentFlags().StringVarP(&format, "format", "f", "", "Configuration format: \"toml\" (default), \"json\" or \"yaml\". Detected by extension for files if not set")
 }
 
 /**
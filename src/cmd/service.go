@@ -0,0 +1,95 @@
+/**
+ * service.go - install, uninstall and run as windows service
+ */
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+/* Service name */
+var serviceName string
+
+/**
+ * Add Service Commands
+ */
+func init() {
+	ServiceCmd.PersistentFlags().StringVarP(&serviceName, "name", "n", "gobetween", "Service name")
+	ServiceCmd.AddCommand(ServiceInstallCmd, ServiceUninstallCmd, ServiceRunCmd)
+	RootCmd.AddCommand(ServiceCmd)
+}
+
+/**
+ * Service Command
+ */
+var ServiceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage windows service",
+}
+
+/**
+ * Service Install Command
+ */
+var ServiceInstallCmd = &cobra.Command{
+	Use:   "install -- <arguments>",
+	Short: "Install windows service started with arguments, i.e. -- -c C:\\gobetween\\gobetween.toml",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(args) == 0 {
+			cmd.Help()
+			return
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Service is started by SCM in system dir, so config paths should be absolute
+		if exe, err = filepath.Abs(exe); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := installService(serviceName, exe, append([]string{"service", "run", "--name", serviceName, "--"}, args...)); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Installed service ", serviceName)
+	},
+}
+
+/**
+ * Service Uninstall Command
+ */
+var ServiceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall windows service",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if err := uninstallService(serviceName); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Uninstalled service ", serviceName)
+	},
+}
+
+/**
+ * Service Run Command, is started by windows service manager
+ */
+var ServiceRunCmd = &cobra.Command{
+	Use:   "run -- <arguments>",
+	Short: "Run as windows service with arguments, used by windows service manager",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if err := runService(serviceName, func() {
+			RootCmd.SetArgs(args)
+			RootCmd.Execute()
+		}); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
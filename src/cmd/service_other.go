@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * service_other.go - windows service is not available on other platforms
+ */
+package cmd
+
+import (
+	"errors"
+)
+
+var errServiceNotSupported = errors.New("Service commands are supported on windows only, use systemd or other service manager")
+
+func installService(name string, exe string, args []string) error {
+	return errServiceNotSupported
+}
+
+func uninstallService(name string) error {
+	return errServiceNotSupported
+}
+
+func runService(name string, run func()) error {
+	return errServiceNotSupported
+}
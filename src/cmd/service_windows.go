@@ -0,0 +1,124 @@
+/**
+ * service_windows.go - windows service control
+ */
+package cmd
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+/**
+ * Registers service starting exe with args automatically,
+ * and event log source of the same name for eventlog logging output
+ */
+func installService(name string, exe string, args []string) error {
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return errors.New("Service " + name + " already exists")
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "Gobetween load balancer",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return err
+	}
+
+	return nil
+}
+
+/**
+ * Removes service and its event log source
+ */
+func uninstallService(name string) error {
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return errors.New("Service " + name + " is not installed")
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+
+	// Source may be removed manually
+	eventlog.Remove(name)
+
+	return nil
+}
+
+/**
+ * Runs as service until it's stopped by service manager
+ */
+func runService(name string, run func()) error {
+
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+
+	if !isService {
+		return errors.New("Not started by windows service manager, use service install")
+	}
+
+	return svc.Run(name, &serviceHandler{run})
+}
+
+/**
+ * Service handler, runs app in background and reports its state
+ */
+type serviceHandler struct {
+	run func()
+}
+
+/**
+ * Handles service manager requests, returning when service is stopped
+ */
+func (this *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+
+	status <- svc.Status{State: svc.StartPending}
+
+	go this.run()
+
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for r := range requests {
+		switch r.Cmd {
+		case svc.Interrogate:
+			status <- r.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
@@ -0,0 +1,86 @@
+/**
+ * binds.go - server listening addresses, set as single address or list of them
+ */
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/**
+ * Addresses server listens on, every one is handled by the same
+ * server, sharing its scheduler, stats and modules
+ */
+type Binds []string
+
+/**
+ * Decodes toml string or array of strings
+ */
+func (this *Binds) UnmarshalTOML(data interface{}) error {
+
+	switch v := data.(type) {
+	case string:
+		*this = Binds{v}
+		return nil
+	case []interface{}:
+		binds := make(Binds, 0, len(v))
+		for _, item := range v {
+			bind, ok := item.(string)
+			if !ok {
+				return errors.New("bind should be string or array of strings")
+			}
+			binds = append(binds, bind)
+		}
+		*this = binds
+		return nil
+	default:
+		return errors.New("bind should be string or array of strings")
+	}
+}
+
+/**
+ * Decodes json string or array of strings
+ */
+func (this *Binds) UnmarshalJSON(data []byte) error {
+
+	var bind string
+	if err := json.Unmarshal(data, &bind); err == nil {
+		*this = Binds{bind}
+		return nil
+	}
+
+	var binds []string
+	if err := json.Unmarshal(data, &binds); err != nil {
+		return errors.New("bind should be string or array of strings")
+	}
+
+	*this = binds
+	return nil
+}
+
+/**
+ * Encodes single address as string, as it was before binds lists, and several ones as array
+ */
+func (this Binds) MarshalJSON() ([]byte, error) {
+
+	if len(this) == 1 {
+		return json.Marshal(this[0])
+	}
+
+	return json.Marshal([]string(this))
+}
+
+/**
+ * Returns addresses separated by comma, for logs
+ */
+func (this Binds) String() string {
+
+	if len(this) == 1 {
+		return this[0]
+	}
+
+	return fmt.Sprintf("[%s]", strings.Join(this, ", "))
+}
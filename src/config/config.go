@@ -10,18 +10,145 @@ package config
  * Config file top-level object
  */
 type Config struct {
+	Include  []string          `toml:"include" json:"include,omitempty"`
 	Logging  LoggingConfig     `toml:"logging" json:"logging"`
 	Api      ApiConfig         `toml:"api" json:"api"`
-	Defaults ConnectionOptions `toml:"defaults" json:"defaults"`
+	Metrics  MetricsConfig     `toml:"metrics" json:"metrics"`
+	Debug    DebugConfig       `toml:"debug" json:"debug"`
+	Defaults DefaultsConfig    `toml:"defaults" json:"defaults"`
+	Limits   LimitsConfig      `toml:"limits" json:"limits"`
+	Upgrade  UpgradeConfig     `toml:"upgrade" json:"upgrade"`
+	Geoip    GeoipConfig       `toml:"geoip" json:"geoip"`
+	Tracing  TracingConfig     `toml:"tracing" json:"tracing"`
+	Events   EventsConfig      `toml:"events" json:"events"`
+	Cluster  ClusterConfig     `toml:"cluster" json:"cluster"`
+	Ha       HaConfig          `toml:"ha" json:"ha"`
+	Plugins  PluginsConfig     `toml:"plugins" json:"plugins"`
 	Servers  map[string]Server `toml:"servers" json:"servers"`
 }
 
+/**
+ * Plugins section, go plugins loaded on start and reload
+ */
+type PluginsConfig struct {
+	Balancers []string `toml:"balancers" json:"balancers,omitempty"`
+}
+
 /**
  * Logging config section
  */
 type LoggingConfig struct {
-	Level  string `toml:"level" json:"level"`
-	Output string `toml:"output" json:"output"`
+	Level          string            `toml:"level" json:"level"`
+	Output         string            `toml:"output" json:"output"`
+	SyslogTag      string            `toml:"syslog_tag" json:"syslog_tag"`
+	SyslogFacility string            `toml:"syslog_facility" json:"syslog_facility"`
+	MaxSize        int64             `toml:"max_size" json:"max_size"`
+	MaxAge         string            `toml:"max_age" json:"max_age"`
+	MaxBackups     int               `toml:"max_backups" json:"max_backups"`
+	Levels         map[string]string `toml:"levels" json:"levels"`
+}
+
+/**
+ * Process-wide limits section
+ */
+type LimitsConfig struct {
+	MaxConnections int `toml:"max_connections" json:"max_connections"`
+}
+
+/**
+ * Binary upgrade section
+ */
+type UpgradeConfig struct {
+	ReadyTimeout string `toml:"ready_timeout" json:"ready_timeout"`
+}
+
+/**
+ * Process-wide MaxMind databases section, used by country / asn
+ * access rules and servers preferring backends of client region
+ */
+type GeoipConfig struct {
+	CountryDatabase string `toml:"country_database" json:"country_database"`
+	AsnDatabase     string `toml:"asn_database" json:"asn_database"`
+}
+
+/**
+ * Process-wide tracing section. Spans of proxied sessions
+ * are exported to OpenTelemetry collector with OTLP/HTTP
+ */
+type TracingConfig struct {
+	Enabled       bool              `toml:"enabled" json:"enabled"`
+	Endpoint      string            `toml:"endpoint" json:"endpoint"`
+	ServiceName   string            `toml:"service_name" json:"service_name"`
+	SamplingRatio *float64          `toml:"sampling_ratio" json:"sampling_ratio"`
+	Headers       map[string]string `toml:"headers" json:"headers"`
+	Interval      string            `toml:"interval" json:"interval"`
+	Timeout       string            `toml:"timeout" json:"timeout"`
+	MaxQueue      int               `toml:"max_queue" json:"max_queue"`
+}
+
+/**
+ * Process-wide events section. Backends and servers events
+ * are pushed to every configured sink
+ */
+type EventsConfig struct {
+	QueueSize int                 `toml:"queue_size" json:"queue_size"`
+	Webhooks  []WebhookSinkConfig `toml:"webhooks" json:"webhooks"`
+	Nats      []NatsSinkConfig    `toml:"nats" json:"nats"`
+}
+
+/**
+ * Webhook events sink, event is POSTed as JSON
+ */
+type WebhookSinkConfig struct {
+	Url     string            `toml:"url" json:"url"`
+	Events  []string          `toml:"events" json:"events"`
+	Headers map[string]string `toml:"headers" json:"headers"`
+	Timeout string            `toml:"timeout" json:"timeout"`
+	Retries int               `toml:"retries" json:"retries"`
+}
+
+/**
+ * NATS events sink, event is published as JSON to <subject>.<event type>
+ */
+type NatsSinkConfig struct {
+	Address  string   `toml:"address" json:"address"`
+	Subject  string   `toml:"subject" json:"subject"`
+	Events   []string `toml:"events" json:"events"`
+	Username string   `toml:"username" json:"username"`
+	Password string   `toml:"password" json:"password"`
+	Token    string   `toml:"token" json:"token"`
+	Timeout  string   `toml:"timeout" json:"timeout"`
+}
+
+/**
+ * Process-wide cluster section. Stick tables and rate limits
+ * of servers with the same name are shared with peers
+ */
+type ClusterConfig struct {
+	Enabled  bool     `toml:"enabled" json:"enabled"`
+	Bind     string   `toml:"bind" json:"bind"`
+	Peers    []string `toml:"peers" json:"peers"`
+	Secret   string   `toml:"secret" json:"secret"`
+	NodeName string   `toml:"node_name" json:"node_name"`
+	Interval string   `toml:"interval" json:"interval"`
+	Timeout  string   `toml:"timeout" json:"timeout"`
+}
+
+/**
+ * High availability section. Virtual ips float between nodes,
+ * held by ready one with the highest priority
+ */
+type HaConfig struct {
+	Enabled        bool     `toml:"enabled" json:"enabled"`
+	Interface      string   `toml:"interface" json:"interface"`
+	VirtualIps     []string `toml:"virtual_ips" json:"virtual_ips"`
+	Bind           string   `toml:"bind" json:"bind"`
+	Peers          []string `toml:"peers" json:"peers"`
+	Secret         string   `toml:"secret" json:"secret"`
+	NodeName       string   `toml:"node_name" json:"node_name"`
+	Priority       int      `toml:"priority" json:"priority"`
+	Preempt        *bool    `toml:"preempt" json:"preempt"`
+	AdvertInterval string   `toml:"advert_interval" json:"advert_interval"`
 }
 
 /**
@@ -31,6 +158,8 @@ type ApiConfig struct {
 	Enabled   bool                `toml:"enabled" json:"enabled"`
 	Bind      string              `toml:"bind" json:"bind"`
 	BasicAuth *ApiBasicAuthConfig `toml:"basic_auth" json:"basic_auth"`
+	Users     []ApiUserConfig     `toml:"users" json:"users"`
+	Tokens    []ApiTokenConfig    `toml:"tokens" json:"tokens"`
 	Tls       *ApiTlsConfig       `toml:"tls" json:"tls"`
 	Cors      bool                `toml:"cors" json:"cors"`
 }
@@ -43,12 +172,79 @@ type ApiBasicAuthConfig struct {
 	Password string `toml:"password" json:"password"`
 }
 
+/**
+ * Api Basic Auth user Config
+ */
+type ApiUserConfig struct {
+	Login    string `toml:"login" json:"login"`
+	Password string `toml:"password" json:"password"`
+	Role     string `toml:"role" json:"role"`
+}
+
+/**
+ * Api Bearer token Config
+ */
+type ApiTokenConfig struct {
+	Token string `toml:"token" json:"token"`
+	Role  string `toml:"role" json:"role"`
+}
+
 /**
  * Api TLS server Config
  */
 type ApiTlsConfig struct {
-	CertPath string `toml:"cert_path" json:"cert_path"`
-	KeyPath  string `toml:"key_path" json:"key_path"`
+	CertPath     string `toml:"cert_path" json:"cert_path"`
+	KeyPath      string `toml:"key_path" json:"key_path"`
+	ClientCaPath string `toml:"client_ca_path" json:"client_ca_path"`
+}
+
+/**
+ * Metrics config section
+ */
+type MetricsConfig struct {
+	Enabled  bool            `toml:"enabled" json:"enabled"`
+	Bind     string          `toml:"bind" json:"bind"`
+	Statsd   *StatsdConfig   `toml:"statsd" json:"statsd"`
+	Influxdb *InfluxdbConfig `toml:"influxdb" json:"influxdb"`
+}
+
+/**
+ * Debug server section, exposing pprof, expvar and internal state
+ */
+type DebugConfig struct {
+	Enabled              bool   `toml:"enabled" json:"enabled"`
+	Bind                 string `toml:"bind" json:"bind"`
+	BlockProfileRate     int    `toml:"block_profile_rate" json:"block_profile_rate"`
+	MutexProfileFraction int    `toml:"mutex_profile_fraction" json:"mutex_profile_fraction"`
+}
+
+/**
+ * InfluxDB metrics push config. Token, organization and bucket are for v2,
+ * database, retention policy, username and password are for v1
+ */
+type InfluxdbConfig struct {
+	Url             string            `toml:"url" json:"url"`
+	Interval        string            `toml:"interval" json:"interval"`
+	Timeout         string            `toml:"timeout" json:"timeout"`
+	Tags            map[string]string `toml:"tags" json:"tags"`
+	Token           string            `toml:"token" json:"token"`
+	Organization    string            `toml:"organization" json:"organization"`
+	Bucket          string            `toml:"bucket" json:"bucket"`
+	Database        string            `toml:"database" json:"database"`
+	RetentionPolicy string            `toml:"retention_policy" json:"retention_policy"`
+	Username        string            `toml:"username" json:"username"`
+	Password        string            `toml:"password" json:"password"`
+}
+
+/**
+ * Statsd / DogStatsD metrics push config
+ */
+type StatsdConfig struct {
+	Address   string   `toml:"address" json:"address"`
+	Prefix    string   `toml:"prefix" json:"prefix"`
+	Interval  string   `toml:"interval" json:"interval"`
+	Dogstatsd bool     `toml:"dogstatsd" json:"dogstatsd"`
+	Tags      []string `toml:"tags" json:"tags"`
 }
 
 /**
@@ -56,9 +252,33 @@ type ApiTlsConfig struct {
  */
 type ConnectionOptions struct {
 	MaxConnections           *int    `toml:"max_connections" json:"max_connections"`
+	MaxConnectionsPerClient  *int    `toml:"max_connections_per_client" json:"max_connections_per_client"`
 	ClientIdleTimeout        *string `toml:"client_idle_timeout" json:"client_idle_timeout"`
+	ClientWriteTimeout       *string `toml:"client_write_timeout" json:"client_write_timeout"`
 	BackendIdleTimeout       *string `toml:"backend_idle_timeout" json:"backend_idle_timeout"`
+	BackendWriteTimeout      *string `toml:"backend_write_timeout" json:"backend_write_timeout"`
 	BackendConnectionTimeout *string `toml:"backend_connection_timeout" json:"backend_connection_timeout"`
+	MaxSessionDuration       *string `toml:"max_session_duration" json:"max_session_duration"`
+	DrainTimeout             *string `toml:"drain_timeout" json:"drain_timeout"`
+	MaxDialRetries           *int    `toml:"max_dial_retries" json:"max_dial_retries"`
+	BufferSize               *int    `toml:"buffer_size" json:"buffer_size"`
+	MinHealthyBackends       *int    `toml:"min_healthy_backends" json:"min_healthy_backends"`
+}
+
+/**
+ * Defaults section, values are inherited by servers not setting them
+ */
+type DefaultsConfig struct {
+	ConnectionOptions
+
+	// Balance of servers without balance
+	Balance string `toml:"balance" json:"balance"`
+
+	// Healthcheck of servers without healthcheck, and empty options of servers healthchecks
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+
+	// Tls of tls / dtls servers without tls, and empty options of servers tls
+	Tls *Tls `toml:"tls" json:"tls"`
 }
 
 /**
@@ -67,30 +287,117 @@ type ConnectionOptions struct {
 type Server struct {
 	ConnectionOptions
 
-	// hostname:port
-	Bind string `toml:"bind" json:"bind"`
+	// hostname:port, or list of them
+	Bind Binds `toml:"bind" json:"bind"`
 
-	// tcp | udp | tls
+	// Optional options of unix socket binds
+	UnixSocket *UnixSocketConfig `toml:"unix_socket" json:"unix_socket"`
+
+	// tcp | udp | tls | dtls | http | quic
 	Protocol string `toml:"protocol" json:"protocol"`
 
+	// Open several listeners with SO_REUSEPORT, each with own accepting goroutine
+	ReusePort bool `toml:"reuse_port" json:"reuse_port"`
+
+	// Listeners count for reuse_port, 0 means number of CPUs
+	Listeners int `toml:"listeners" json:"listeners"`
+
+	// Time connection over max_connections waits for capacity, 0 means it's closed immediately
+	QueueTimeout string `toml:"queue_timeout" json:"queue_timeout"`
+
+	// Max connections waiting for capacity, 0 means unlimited
+	QueueSize int `toml:"queue_size" json:"queue_size"`
+
+	// Connect to backends from client address with IP_TRANSPARENT
+	Transparent bool `toml:"transparent" json:"transparent"`
+
 	// weight | leastconn | roundrobin
 	Balance string `toml:"balance" json:"balance"`
 
+	// Duration to ramp weight of backend became healthy from 0 to configured one
+	SlowStart string `toml:"slow_start" json:"slow_start"`
+
+	// Only backends having all these discovered labels are used
+	BackendLabels map[string]string `toml:"backend_labels" json:"backend_labels"`
+
 	// Optional configuration for server name indication
 	Sni *Sni `toml:"sni" json:"sni"`
 
+	// Optional routing of client protocol detected by first bytes, for protocol = "tcp"
+	Mux *Mux `toml:"mux" json:"mux"`
+
 	// Optional configuration for protocol = tls
 	Tls *Tls `toml:"tls" json:"tls"`
 
 	// Optional configuration for backend_tls_enabled = true
 	BackendsTls *BackendsTls `toml:"backends_tls" json:"backends_tls"`
 
+	// Optional proxy to connect to backends through
+	UpstreamProxy *UpstreamProxy `toml:"upstream_proxy" json:"upstream_proxy"`
+
+	// Optional source ips and interface of backend connections
+	BackendBind *BackendBindConfig `toml:"backend_bind" json:"backend_bind"`
+
+	// Optional dual-stack backends host names connecting
+	HappyEyeballs *HappyEyeballsConfig `toml:"happy_eyeballs" json:"happy_eyeballs"`
+
 	// Optional configuration for protocol = udp
 	Udp *Udp `toml:"udp" json:"udp"`
 
+	// Optional configuration for protocol = http
+	Http *Http `toml:"http" json:"http"`
+
+	// Optional configuration for protocol = quic
+	Quic *Quic `toml:"quic" json:"quic"`
+
+	// Optional configuration for PROXY protocol
+	ProxyProtocol *ProxyProtocol `toml:"proxy_protocol" json:"proxy_protocol"`
+
 	// Access configuration
 	Access *AccessConfig `toml:"access" json:"access"`
 
+	// New connections rate limiting configuration
+	RateLimit *RateLimitConfig `toml:"rate_limit" json:"rate_limit"`
+
+	// Holding denied and rate limited connections configuration
+	Tarpit *TarpitConfig `toml:"tarpit" json:"tarpit"`
+
+	// Access log configuration
+	AccessLog *AccessLogConfig `toml:"access_log" json:"access_log"`
+
+	// Lua script hooks of connections
+	Script *ScriptConfig `toml:"script" json:"script"`
+
+	// Bandwidth throttling configuration
+	Throttle *ThrottleConfig `toml:"throttle" json:"throttle"`
+
+	// Per backend circuit breaker configuration
+	CircuitBreaker *CircuitBreakerConfig `toml:"circuit_breaker" json:"circuit_breaker"`
+
+	// Client ip to backend stick table configuration
+	Sticky *StickyConfig `toml:"sticky" json:"sticky"`
+
+	// Backends priority failover configuration
+	Failover *FailoverConfig `toml:"failover" json:"failover"`
+
+	// Preferring backends labeled with client geoip region
+	Geoip *GeoipBalanceConfig `toml:"geoip" json:"geoip"`
+
+	// Idle backend connections pool configuration
+	BackendPool *BackendPoolConfig `toml:"backend_pool" json:"backend_pool"`
+
+	// Shadow backends pool client traffic is mirrored to
+	Shadow *ShadowConfig `toml:"shadow" json:"shadow"`
+
+	// Canary backends pool taking weight percent of connections
+	Canary *CanaryConfig `toml:"canary" json:"canary"`
+
+	// Client side sockets options
+	ClientSocket *SocketOptions `toml:"client_socket" json:"client_socket"`
+
+	// Backend side sockets options
+	BackendSocket *SocketOptions `toml:"backend_socket" json:"backend_socket"`
+
 	// Discovery configuration
 	Discovery *DiscoveryConfig `toml:"discovery" json:"discovery"`
 
@@ -98,6 +405,57 @@ type Server struct {
 	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
 }
 
+/**
+ * Local side of backend connections
+ */
+type BackendBindConfig struct {
+
+	// Source ips, connections are spread over them round robin
+	Addresses []string `toml:"addresses" json:"addresses"`
+
+	// Interface to bind backend sockets to, linux only
+	Interface string `toml:"interface" json:"interface"`
+}
+
+/**
+ * Connecting to backends host names resolving to ipv6 and ipv4
+ * addresses in parallel, with head start of preferred family
+ */
+type HappyEyeballsConfig struct {
+
+	// Head start of connection attempt before next address is tried, i.e. "250ms"
+	AttemptDelay string `toml:"attempt_delay" json:"attempt_delay"`
+
+	// "ipv6" | "ipv4" family tried first
+	Prefer string `toml:"prefer" json:"prefer"`
+}
+
+/**
+ * Unix socket binds options, not set ones are left as socket is created
+ */
+type UnixSocketConfig struct {
+
+	// Octal permissions of socket file, i.e. "0660"
+	Mode string `toml:"mode" json:"mode"`
+
+	// User and group name or id owning socket file
+	Owner string `toml:"owner" json:"owner"`
+	Group string `toml:"group" json:"group"`
+}
+
+/**
+ * Tcp socket options, not set ones are left system defaults
+ */
+type SocketOptions struct {
+	Keepalive         *bool  `toml:"keepalive" json:"keepalive"`
+	KeepaliveInterval string `toml:"keepalive_interval" json:"keepalive_interval"`
+	KeepaliveCount    int    `toml:"keepalive_count" json:"keepalive_count"`
+	NoDelay           *bool  `toml:"no_delay" json:"no_delay"`
+	RecvBuffer        int    `toml:"recv_buffer" json:"recv_buffer"`
+	SendBuffer        int    `toml:"send_buffer" json:"send_buffer"`
+	Tos               int    `toml:"tos" json:"tos"`
+}
+
 /**
  * Server Sni options
  */
@@ -105,6 +463,49 @@ type Sni struct {
 	HostnameMatchingStrategy   string `toml:"hostname_matching_strategy" json:"hostname_matching_strategy"`
 	UnexpectedHostnameStrategy string `toml:"unexpected_hostname_strategy" json:"unexpected_hostname_strategy"`
 	ReadTimeout                string `toml:"read_timeout" json:"read_timeout"`
+
+	// Optional routes of hostnames to separate backends pools
+	Routes []SniRoute `toml:"routes" json:"routes,omitempty"`
+}
+
+/**
+ * Sni route of hostname and / or negotiated ALPN protocol to separate backends pool
+ */
+type SniRoute struct {
+	Hostname    string             `toml:"hostname" json:"hostname"`
+	Alpn        string             `toml:"alpn" json:"alpn,omitempty"`
+	Balance     string             `toml:"balance" json:"balance"`
+	Discovery   *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+}
+
+/**
+ * Detecting client protocol by first bytes it sends, to route
+ * different protocols on the same bind to separate backends pools
+ */
+type Mux struct {
+	ReadTimeout string `toml:"read_timeout" json:"read_timeout"`
+
+	// Max first bytes read to detect protocol and match routes
+	ReadSize int `toml:"read_size" json:"read_size"`
+
+	// Routes of detected protocols or first bytes to separate backends pools
+	Routes []MuxRoute `toml:"routes" json:"routes,omitempty"`
+}
+
+/**
+ * Mux route of detected protocol, or of first bytes matching
+ * prefix, hex prefix or regexp, to separate backends pool
+ */
+type MuxRoute struct {
+	Name        string             `toml:"name" json:"name"`
+	Protocol    string             `toml:"protocol" json:"protocol"`
+	Prefix      string             `toml:"prefix" json:"prefix"`
+	HexPrefix   string             `toml:"hex_prefix" json:"hex_prefix"`
+	Regexp      string             `toml:"regexp" json:"regexp"`
+	Balance     string             `toml:"balance" json:"balance"`
+	Discovery   *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
 }
 
 /**
@@ -126,6 +527,37 @@ type Tls struct {
 	CertPath string `toml:"cert_path" json:"cert_path"`
 	KeyPath  string `toml:"key_path" json:"key_path"`
 	tlsCommon
+
+	// Interval to check cert and key files for changes, "0" disables
+	ReloadInterval string `toml:"reload_interval" json:"reload_interval"`
+
+	// Optional ALPN protocols advertised to clients, in order of preference
+	Alpn []string `toml:"alpn" json:"alpn,omitempty"`
+
+	// Optional certificates selected by sni hostname
+	Certificates []TlsCertificate `toml:"certificates" json:"certificates,omitempty"`
+
+	// Optional client certificates authentication
+	ClientAuth *TlsClientAuth `toml:"client_auth" json:"client_auth"`
+}
+
+/**
+ * Server Tls certificate selected for hosts
+ */
+type TlsCertificate struct {
+	CertPath string   `toml:"cert_path" json:"cert_path"`
+	KeyPath  string   `toml:"key_path" json:"key_path"`
+	Hosts    []string `toml:"hosts" json:"hosts"`
+}
+
+/**
+ * Server Tls client certificates authentication options
+ */
+type TlsClientAuth struct {
+	Mode         string   `toml:"mode" json:"mode"`
+	CaCertPath   string   `toml:"ca_cert_path" json:"ca_cert_path"`
+	CrlPath      string   `toml:"crl_path" json:"crl_path"`
+	AllowedNames []string `toml:"allowed_names" json:"allowed_names"`
 }
 
 type BackendsTls struct {
@@ -136,21 +568,220 @@ type BackendsTls struct {
 	tlsCommon
 }
 
+/**
+ * Proxy backends connections are established through,
+ * for protocol = "tcp" | "tls" | "http"
+ */
+type UpstreamProxy struct {
+	Kind     string `toml:"kind" json:"kind"`
+	Address  string `toml:"address" json:"address"`
+	Username string `toml:"username" json:"username"`
+	Password string `toml:"password" json:"password"`
+}
+
 /**
  * Server udp options
  * for protocol = "udp"
  */
 type Udp struct {
-	MaxRequests  uint64 `toml:"max_requests" json:"max_requests"`
-	MaxResponses uint64 `toml:"max_responses" json:"max_responses"`
+	MaxRequests      uint64 `toml:"max_requests" json:"max_requests"`
+	MaxResponses     uint64 `toml:"max_responses" json:"max_responses"`
+	MaxRequestBytes  uint64 `toml:"max_request_bytes" json:"max_request_bytes"`
+	MaxResponseBytes uint64 `toml:"max_response_bytes" json:"max_response_bytes"`
+	MaxPacketSize    int    `toml:"max_packet_size" json:"max_packet_size"`
+	SessionTimeout   string `toml:"session_timeout" json:"session_timeout"`
+	MaxSessions      int    `toml:"max_sessions" json:"max_sessions"`
+
+	// Optional DNS mode, every query is proxied separately
+	Dns *UdpDns `toml:"dns" json:"dns"`
+}
+
+/**
+ * Server udp DNS mode options
+ */
+type UdpDns struct {
+	QueryTimeout string `toml:"query_timeout" json:"query_timeout"`
+	Retries      int    `toml:"retries" json:"retries"`
+}
+
+/**
+ * Server quic options
+ * for protocol = "quic"
+ */
+type Quic struct {
+	// Optional protocol of backends: "tcp" (connection per stream) | "quic" (connection per client connection)
+	BackendProtocol string `toml:"backend_protocol" json:"backend_protocol"`
+
+	// Optional max concurrent streams client can open in connection, 0 means default of 100
+	MaxStreams int64 `toml:"max_streams" json:"max_streams"`
+}
+
+/**
+ * Server http options
+ * for protocol = "http"
+ */
+type Http struct {
+	// Optional protocol of requests to backends: "http1" | "h2c" | "h2"
+	BackendProtocol string `toml:"backend_protocol" json:"backend_protocol"`
+
+	// Optional routes of host and path prefix to separate backends pools, first matching is used
+	Routes []HttpRoute `toml:"routes" json:"routes,omitempty"`
+}
+
+/**
+ * Http route of host and / or path prefix to separate backends pool
+ */
+type HttpRoute struct {
+	Host        string             `toml:"host" json:"host"`
+	PathPrefix  string             `toml:"path_prefix" json:"path_prefix"`
+	StripPrefix bool               `toml:"strip_prefix" json:"strip_prefix"`
+	Balance     string             `toml:"balance" json:"balance"`
+	Discovery   *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+}
+
+/**
+ * Server PROXY protocol options
+ * for protocol = "tcp" | "tls"
+ */
+type ProxyProtocol struct {
+	BackendVersion string `toml:"backend_version" json:"backend_version"`
+	Accept         bool   `toml:"accept" json:"accept"`
+	ReadTimeout    string `toml:"read_timeout" json:"read_timeout"`
 }
 
 /**
  * Access configuration
  */
 type AccessConfig struct {
-	Default string   `toml:"default" json:"default"`
-	Rules   []string `toml:"rules" json:"rules"`
+	Default   string   `toml:"default" json:"default"`
+	Rules     []string `toml:"rules" json:"rules"`
+	RulesFile string   `toml:"rules_file" json:"rules_file"`
+}
+
+/**
+ * Rate limit configuration
+ */
+type RateLimitConfig struct {
+	ConnectionsPerSecond float64 `toml:"connections_per_second" json:"connections_per_second"`
+	Burst                int     `toml:"burst" json:"burst"`
+	BanDuration          string  `toml:"ban_duration" json:"ban_duration"`
+}
+
+/**
+ * Tarpit configuration. Denied and rate limited connections are held
+ * open silently for duration instead of being closed at once
+ */
+type TarpitConfig struct {
+	Duration       string `toml:"duration" json:"duration"`
+	MaxConnections int    `toml:"max_connections" json:"max_connections"`
+}
+
+/**
+ * Access log configuration
+ */
+type AccessLogConfig struct {
+	Format   string `toml:"format" json:"format"`
+	Template string `toml:"template" json:"template"`
+	Output   string `toml:"output" json:"output"`
+}
+
+/**
+ * Lua script hooks configuration
+ */
+type ScriptConfig struct {
+	Path    string `toml:"path" json:"path"`
+	Timeout string `toml:"timeout" json:"timeout"`
+
+	// What failed or timed out on_accept hook decides, "accept" | "reject"
+	Failpolicy string `toml:"failpolicy" json:"failpolicy"`
+
+	// Max first bytes of client read for hooks, unless they are read by mux
+	ReadSize    int    `toml:"read_size" json:"read_size"`
+	ReadTimeout string `toml:"read_timeout" json:"read_timeout"`
+}
+
+/**
+ * Bandwidth throttling configuration, bytes per second, 0 means unlimited.
+ * Rx is data received from backends, tx is data transmitted to backends
+ */
+type ThrottleConfig struct {
+	ConnectionRxBytesPerSecond int `toml:"connection_rx_bytes_per_second" json:"connection_rx_bytes_per_second"`
+	ConnectionTxBytesPerSecond int `toml:"connection_tx_bytes_per_second" json:"connection_tx_bytes_per_second"`
+	ServerRxBytesPerSecond     int `toml:"server_rx_bytes_per_second" json:"server_rx_bytes_per_second"`
+	ServerTxBytesPerSecond     int `toml:"server_tx_bytes_per_second" json:"server_tx_bytes_per_second"`
+}
+
+/**
+ * Circuit breaker configuration, 0 means unlimited
+ */
+type CircuitBreakerConfig struct {
+	MaxConnections    int    `toml:"max_connections" json:"max_connections"`
+	MaxPendingDials   int    `toml:"max_pending_dials" json:"max_pending_dials"`
+	ConsecutiveErrors int    `toml:"consecutive_errors" json:"consecutive_errors"`
+	OpenTimeout       string `toml:"open_timeout" json:"open_timeout"`
+}
+
+/**
+ * Stick table configuration. Client ip is remembered with backend
+ * it was proxied to, and proxied to it again while entry is not expired
+ */
+type StickyConfig struct {
+	Ttl             string `toml:"ttl" json:"ttl"`
+	MaxEntries      int    `toml:"max_entries" json:"max_entries"`
+	PersistPath     string `toml:"persist_path" json:"persist_path"`
+	PersistInterval string `toml:"persist_interval" json:"persist_interval"`
+}
+
+/**
+ * Priority failover configuration. Only backends of the highest priority
+ * (lowest value) tier having at least min_healthy healthy backends are balanced
+ */
+type FailoverConfig struct {
+	MinHealthy int `toml:"min_healthy" json:"min_healthy"`
+}
+
+/**
+ * Geoip region preference configuration. Backends having label prefer_label equal
+ * to client country or continent code are balanced, if any of them is electable
+ */
+type GeoipBalanceConfig struct {
+	PreferLabel string `toml:"prefer_label" json:"prefer_label"`
+	Region      string `toml:"region" json:"region"`
+}
+
+/**
+ * Idle backend connections pool configuration
+ */
+type BackendPoolConfig struct {
+	// Protocol of sessions, the only one pooling is safe for is "request_response"
+	Mode string `toml:"mode" json:"mode"`
+
+	MaxIdle      int    `toml:"max_idle" json:"max_idle"`
+	IdleTimeout  string `toml:"idle_timeout" json:"idle_timeout"`
+	DrainTimeout string `toml:"drain_timeout" json:"drain_timeout"`
+}
+
+/**
+ * Shadow backends pool configuration. Client data is duplicated
+ * to shadow backend, its responses are discarded
+ */
+type ShadowConfig struct {
+	Balance     string             `toml:"balance" json:"balance"`
+	QueueSize   int                `toml:"queue_size" json:"queue_size"`
+	Discovery   *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+}
+
+/**
+ * Canary backends pool configuration. Weight is percent
+ * of new connections (0..100) proxied to canary backends
+ */
+type CanaryConfig struct {
+	Weight      int                `toml:"weight" json:"weight"`
+	Balance     string             `toml:"balance" json:"balance"`
+	Discovery   *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
 }
 
 /**
@@ -162,6 +793,9 @@ type DiscoveryConfig struct {
 	Interval   string `toml:"interval" json:"interval"`
 	Timeout    string `toml:"timeout" json:"timeout"`
 
+	StaleTtl    string `toml:"stale_ttl" json:"stale_ttl"`
+	MaxFailures int    `toml:"max_failures" json:"max_failures"`
+
 	/* Depends on Kind */
 
 	*StaticDiscoveryConfig
@@ -172,20 +806,41 @@ type DiscoveryConfig struct {
 	*PlaintextDiscoveryConfig
 	*ConsulDiscoveryConfig
 	*LXDDiscoveryConfig
+	*KubernetesDiscoveryConfig
+	*EtcdDiscoveryConfig
+	*AwsDiscoveryConfig
+	*ZookeeperDiscoveryConfig
+	*MergeDiscoveryConfig
+	*GrpcDiscoveryConfig
+
+	/* Http options of json and plaintext */
+
+	*HttpDiscoveryConfig
 }
 
 type StaticDiscoveryConfig struct {
 	StaticList []string `toml:"static_list" json:"static_list"`
+
+	// Resolve host names in discovery, again as records ttl expires
+	StaticResolve bool `toml:"static_resolve" json:"static_resolve"`
+
+	// Every resolved address of host name is its own backend, implies static_resolve
+	StaticExpand bool `toml:"static_expand" json:"static_expand"`
 }
 
 type SrvDiscoveryConfig struct {
 	SrvLookupServer  string `toml:"srv_lookup_server" json:"srv_lookup_server"`
 	SrvLookupPattern string `toml:"srv_lookup_pattern" json:"srv_lookup_pattern"`
 	SrvDnsProtocol   string `toml:"srv_dns_protocol" json:"srv_dns_protocol"`
+
+	SrvLookupServers  []string `toml:"srv_lookup_servers" json:"srv_lookup_servers"`
+	SrvLookupPatterns []string `toml:"srv_lookup_patterns" json:"srv_lookup_patterns"`
+	SrvRespectTtl     bool     `toml:"srv_respect_ttl" json:"srv_respect_ttl"`
 }
 
 type ExecDiscoveryConfig struct {
 	ExecCommand []string `toml:"exec_command" json:"exec_command"`
+	ExecMode    string   `toml:"exec_mode" json:"exec_mode"`
 }
 
 type JsonDiscoveryConfig struct {
@@ -195,6 +850,29 @@ type JsonDiscoveryConfig struct {
 	JsonWeightPattern   string `toml:"json_weight_pattern" json:"json_weight_pattern"`
 	JsonPriorityPattern string `toml:"json_priority_pattern" json:"json_priority_pattern"`
 	JsonSniPattern      string `toml:"json_sni_pattern" json:"json_sni_pattern"`
+
+	JsonMaxConnectionsPattern string `toml:"json_max_connections_pattern" json:"json_max_connections_pattern"`
+}
+
+type MergeDiscoveryConfig struct {
+	MergeSources []MergeSource `toml:"merge_sources" json:"merge_sources"`
+}
+
+type MergeSource struct {
+	DiscoveryConfig
+	Label string `toml:"label" json:"label"`
+}
+
+type HttpDiscoveryConfig struct {
+	HttpTlsCacertPath     string `toml:"http_tls_cacert_path" json:"http_tls_cacert_path"`
+	HttpTlsCertPath       string `toml:"http_tls_cert_path" json:"http_tls_cert_path"`
+	HttpTlsKeyPath        string `toml:"http_tls_key_path" json:"http_tls_key_path"`
+	HttpTlsSkipVerify     bool   `toml:"http_tls_skip_verify" json:"http_tls_skip_verify"`
+	HttpBasicAuthUsername string `toml:"http_basic_auth_username" json:"http_basic_auth_username"`
+	HttpBasicAuthPassword string `toml:"http_basic_auth_password" json:"http_basic_auth_password"`
+	HttpBearerToken       string `toml:"http_bearer_token" json:"http_bearer_token"`
+	HttpRetries           int    `toml:"http_retries" json:"http_retries"`
+	HttpRetryWait         string `toml:"http_retry_wait" json:"http_retry_wait"`
 }
 
 type PlaintextDiscoveryConfig struct {
@@ -215,11 +893,13 @@ type DockerDiscoveryConfig struct {
 }
 
 type ConsulDiscoveryConfig struct {
-	ConsulHost               string `toml:"consul_host" json:"consul_host"`
-	ConsulServiceName        string `toml:"consul_service_name" json:"consul_service_name"`
-	ConsulServiceTag         string `toml:"consul_service_tag" json:"consul_service_tag"`
-	ConsulServicePassingOnly bool   `toml:"consul_service_passing_only" json:"consul_service_passing_only"`
-	ConsulDatacenter         string `toml:"consul_datacenter" json:"consul_datacenter"`
+	ConsulHost               string   `toml:"consul_host" json:"consul_host"`
+	ConsulServiceName        string   `toml:"consul_service_name" json:"consul_service_name"`
+	ConsulServiceTag         string   `toml:"consul_service_tag" json:"consul_service_tag"`
+	ConsulServiceTags        []string `toml:"consul_service_tags" json:"consul_service_tags"`
+	ConsulServicePassingOnly bool     `toml:"consul_service_passing_only" json:"consul_service_passing_only"`
+	ConsulDatacenter         string   `toml:"consul_datacenter" json:"consul_datacenter"`
+	ConsulAclToken           string   `toml:"consul_acl_token" json:"consul_acl_token"`
 
 	ConsulAuthUsername string `toml:"consul_auth_username" json:"consul_auth_username"`
 	ConsulAuthPassword string `toml:"consul_auth_password" json:"consul_auth_password"`
@@ -235,6 +915,11 @@ type LXDDiscoveryConfig struct {
 	LXDServerRemoteName     string `toml:"lxd_server_remote_name" json:"lxd_server_remote_name"`
 	LXDServerRemotePassword string `toml:"lxd_server_remote_password" json:"lxd_server_remote_password"`
 
+	LXDServerAddresses []string `toml:"lxd_server_addresses" json:"lxd_server_addresses"`
+	LXDClusterMembers  []string `toml:"lxd_cluster_members" json:"lxd_cluster_members"`
+	LXDProject         string   `toml:"lxd_project" json:"lxd_project"`
+	LXDEvents          bool     `toml:"lxd_events" json:"lxd_events"`
+
 	LXDConfigDirectory     string `toml:"lxd_config_directory" json:"lxd_config_directory"`
 	LXDGenerateClientCerts bool   `toml:"lxd_generate_client_certs" json:"lxd_generate_client_certs"`
 	LXDAcceptServerCert    bool   `toml:"lxd_accept_server_cert" json:"lxd_accept_server_cert"`
@@ -242,6 +927,9 @@ type LXDDiscoveryConfig struct {
 	LXDContainerLabelKey   string `toml:"lxd_container_label_key" json:"lxd_container_label_key"`
 	LXDContainerLabelValue string `toml:"lxd_container_label_value" json:"lxd_container_label_value"`
 
+	LXDContainerProfiles []string `toml:"lxd_container_profiles" json:"lxd_container_profiles"`
+	LXDContainerStatuses []string `toml:"lxd_container_statuses" json:"lxd_container_statuses"`
+
 	LXDContainerPort    int    `toml:"lxd_container_port" json:"lxd_container_port"`
 	LXDContainerPortKey string `toml:"lxd_container_port_key" json:"lxd_container_port_key"`
 
@@ -252,6 +940,61 @@ type LXDDiscoveryConfig struct {
 	LXDContainerAddressType string `toml:"lxd_container_address_type" json:"lxd_container_address_type"`
 }
 
+type KubernetesDiscoveryConfig struct {
+	KubernetesApiServer      string `toml:"kubernetes_api_server" json:"kubernetes_api_server"`
+	KubernetesKubeconfigPath string `toml:"kubernetes_kubeconfig_path" json:"kubernetes_kubeconfig_path"`
+
+	KubernetesNamespace   string `toml:"kubernetes_namespace" json:"kubernetes_namespace"`
+	KubernetesServiceName string `toml:"kubernetes_service_name" json:"kubernetes_service_name"`
+	KubernetesPortName    string `toml:"kubernetes_port_name" json:"kubernetes_port_name"`
+
+	KubernetesPodWeightLabel   string `toml:"kubernetes_pod_weight_label" json:"kubernetes_pod_weight_label"`
+	KubernetesPodPriorityLabel string `toml:"kubernetes_pod_priority_label" json:"kubernetes_pod_priority_label"`
+	KubernetesPodLabels        bool   `toml:"kubernetes_pod_labels" json:"kubernetes_pod_labels"`
+}
+
+type EtcdDiscoveryConfig struct {
+	EtcdEndpoints []string `toml:"etcd_endpoints" json:"etcd_endpoints"`
+	EtcdPrefix    string   `toml:"etcd_prefix" json:"etcd_prefix"`
+
+	EtcdUsername string `toml:"etcd_username" json:"etcd_username"`
+	EtcdPassword string `toml:"etcd_password" json:"etcd_password"`
+
+	EtcdTlsEnabled    bool   `toml:"etcd_tls_enabled" json:"etcd_tls_enabled"`
+	EtcdTlsCertPath   string `toml:"etcd_tls_cert_path" json:"etcd_tls_cert_path"`
+	EtcdTlsKeyPath    string `toml:"etcd_tls_key_path" json:"etcd_tls_key_path"`
+	EtcdTlsCacertPath string `toml:"etcd_tls_cacert_path" json:"etcd_tls_cacert_path"`
+}
+
+type AwsDiscoveryConfig struct {
+	AwsRegion          string `toml:"aws_region" json:"aws_region"`
+	AwsProfile         string `toml:"aws_profile" json:"aws_profile"`
+	AwsAccessKeyId     string `toml:"aws_access_key_id" json:"aws_access_key_id"`
+	AwsSecretAccessKey string `toml:"aws_secret_access_key" json:"aws_secret_access_key"`
+
+	AwsTagFilters       []string `toml:"aws_tag_filters" json:"aws_tag_filters"`
+	AwsAutoscalingGroup string   `toml:"aws_autoscaling_group" json:"aws_autoscaling_group"`
+
+	AwsAddressType string `toml:"aws_address_type" json:"aws_address_type"`
+	AwsPort        int    `toml:"aws_port" json:"aws_port"`
+	AwsPortTag     string `toml:"aws_port_tag" json:"aws_port_tag"`
+	AwsSniTag      string `toml:"aws_sni_tag" json:"aws_sni_tag"`
+}
+
+type GrpcDiscoveryConfig struct {
+	GrpcAddress string `toml:"grpc_address" json:"grpc_address"`
+	GrpcService string `toml:"grpc_service" json:"grpc_service"`
+}
+
+type ZookeeperDiscoveryConfig struct {
+	ZookeeperServers        []string `toml:"zookeeper_servers" json:"zookeeper_servers"`
+	ZookeeperPath           string   `toml:"zookeeper_path" json:"zookeeper_path"`
+	ZookeeperSessionTimeout string   `toml:"zookeeper_session_timeout" json:"zookeeper_session_timeout"`
+
+	ZookeeperUsername string `toml:"zookeeper_username" json:"zookeeper_username"`
+	ZookeeperPassword string `toml:"zookeeper_password" json:"zookeeper_password"`
+}
+
 /**
  * Healthcheck configuration
  */
@@ -262,10 +1005,23 @@ type HealthcheckConfig struct {
 	Fails    int    `toml:"fails" json:"fails"`
 	Timeout  string `toml:"timeout" json:"timeout"`
 
+	/* Max random shift of every check from interval, so checks don't synchronize */
+	Jitter string `toml:"jitter" json:"jitter"`
+
+	/* Consecutive failed proxied connections to mark backend as inactive, 0 to disable */
+	PassiveFails int `toml:"passive_fails" json:"passive_fails"`
+
+	/* Last check results and live changes kept per backend for api */
+	HistorySize int `toml:"history_size" json:"history_size"`
+
 	/* Depends on Kind */
 
 	*PingHealthcheckConfig
 	*ExecHealthcheckConfig
+	*HttpHealthcheckConfig
+	*ConnectHealthcheckConfig
+	*TlsHealthcheckConfig
+	*UdpHealthcheckConfig
 }
 
 type PingHealthcheckConfig struct{}
@@ -274,4 +1030,33 @@ type ExecHealthcheckConfig struct {
 	ExecCommand                string `toml:"exec_command" json:"exec_command,omitempty"`
 	ExecExpectedPositiveOutput string `toml:"exec_expected_positive_output" json:"exec_expected_positive_output"`
 	ExecExpectedNegativeOutput string `toml:"exec_expected_negative_output" json:"exec_expected_negative_output"`
+	ExecOutputFormat           string `toml:"exec_output_format" json:"exec_output_format,omitempty"`
+}
+
+type HttpHealthcheckConfig struct {
+	HttpPath             string `toml:"http_path" json:"http_path,omitempty"`
+	HttpMethod           string `toml:"http_method" json:"http_method,omitempty"`
+	HttpHost             string `toml:"http_host" json:"http_host,omitempty"`
+	HttpExpectedStatuses []int  `toml:"http_expected_statuses" json:"http_expected_statuses,omitempty"`
+	HttpTlsEnabled       bool   `toml:"http_tls_enabled" json:"http_tls_enabled"`
+	HttpTlsSkipVerify    bool   `toml:"http_tls_skip_verify" json:"http_tls_skip_verify"`
+}
+
+type ConnectHealthcheckConfig struct {
+	ConnectProtocol string `toml:"connect_protocol" json:"connect_protocol,omitempty"`
+	ConnectSend     string `toml:"connect_send" json:"connect_send,omitempty"`
+	ConnectExpect   string `toml:"connect_expect" json:"connect_expect,omitempty"`
+}
+
+type UdpHealthcheckConfig struct {
+	UdpSend          string `toml:"udp_send" json:"udp_send,omitempty"`
+	UdpExpect        string `toml:"udp_expect" json:"udp_expect,omitempty"`
+	UdpPayloadFormat string `toml:"udp_payload_format" json:"udp_payload_format,omitempty"`
+}
+
+type TlsHealthcheckConfig struct {
+	TlsServerName     string `toml:"tls_server_name" json:"tls_server_name,omitempty"`
+	TlsSkipVerify     bool   `toml:"tls_skip_verify" json:"tls_skip_verify"`
+	TlsRootCaCertPath string `toml:"tls_root_ca_cert_path" json:"tls_root_ca_cert_path,omitempty"`
+	TlsMinExpiryDays  int    `toml:"tls_min_expiry_days" json:"tls_min_expiry_days"`
 }
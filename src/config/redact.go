@@ -0,0 +1,107 @@
+/**
+ * redact.go - copies of configuration with secrets hidden, for readonly api users
+ */
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+/**
+ * Value secrets are replaced with
+ */
+const RedactedValue = "******"
+
+/**
+ * Returns deep copy of configuration with passwords, tokens, secrets
+ * and headers values replaced, leaving the original untouched
+ */
+func (this Config) Redacted() Config {
+	return redact(reflect.ValueOf(this), false).Interface().(Config)
+}
+
+/**
+ * Returns deep copy of server configuration with secrets replaced
+ */
+func (this Server) Redacted() Server {
+	return redact(reflect.ValueOf(this), false).Interface().(Server)
+}
+
+/**
+ * Checks if option of toml name holds secret
+ */
+func isSecret(name string) bool {
+	switch name {
+	case "password", "token", "secret", "headers":
+		return true
+	}
+
+	return strings.HasSuffix(name, "_password") ||
+		strings.HasSuffix(name, "_token") ||
+		strings.HasSuffix(name, "_secret_access_key")
+}
+
+/**
+ * Copies value, replacing non-empty strings in it if secret is set,
+ * and secret options of structs in it
+ */
+func redact(v reflect.Value, secret bool) reflect.Value {
+
+	switch v.Kind() {
+
+	case reflect.String:
+		if secret && v.Len() > 0 {
+			result := reflect.New(v.Type()).Elem()
+			result.SetString(RedactedValue)
+			return result
+		}
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		if v.Kind() == reflect.Interface {
+			result := reflect.New(v.Type()).Elem()
+			result.Set(redact(v.Elem(), secret))
+			return result
+		}
+		result := reflect.New(v.Type().Elem())
+		result.Elem().Set(redact(v.Elem(), secret))
+		return result
+
+	case reflect.Struct:
+		result := reflect.New(v.Type()).Elem()
+		result.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if !result.Field(i).CanSet() {
+				continue
+			}
+			name := strings.Split(v.Type().Field(i).Tag.Get("toml"), ",")[0]
+			result.Field(i).Set(redact(v.Field(i), secret || isSecret(name)))
+		}
+		return result
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(redact(v.Index(i), secret))
+		}
+		return result
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			result.SetMapIndex(key, redact(v.MapIndex(key), secret))
+		}
+		return result
+	}
+
+	return v
+}
@@ -16,10 +16,23 @@ import (
  */
 type Backend struct {
 	Target
-	Priority int          `json:"priority"`
-	Weight   int          `json:"weight"`
-	Sni      string       `json:"sni,omitempty"`
-	Stats    BackendStats `json:"stats"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Sni      string `json:"sni,omitempty"`
+
+	/* Max active connections to backend, 0 means unlimited */
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	/* Label of discovery source backend came from, if discoveries are merged */
+	Source string `json:"source,omitempty"`
+
+	/* Host name backend ip was resolved from in discovery, tls server name of backend connections */
+	Hostname string `json:"hostname,omitempty"`
+
+	/* Metadata labels from discovery: docker labels, consul tags, kubernetes pod labels */
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Stats BackendStats `json:"stats"`
 }
 
 /**
@@ -34,6 +47,13 @@ type BackendStats struct {
 	TxBytes            uint64 `json:"tx"`
 	RxSecond           uint   `json:"rx_second"`
 	TxSecond           uint   `json:"tx_second"`
+	CircuitBreaker     string `json:"circuit_breaker,omitempty"`
+
+	/* Backend is administratively out of rotation, regardless of live status */
+	Drained bool `json:"drained"`
+
+	/* Total ended connections by reason they ended with */
+	Disconnects map[string]uint64 `json:"disconnects,omitempty"`
 }
 
 /**
@@ -43,6 +63,16 @@ func (this *Backend) EqualTo(other Backend) bool {
 	return this.Target.EqualTo(other.Target)
 }
 
+/**
+ * Returns name backend is known by: host name it was resolved from, or host
+ */
+func (this *Backend) ServerName() string {
+	if this.Hostname != "" {
+		return this.Hostname
+	}
+	return this.Host
+}
+
 /**
  * Merge another backend to this one
  */
@@ -51,6 +81,10 @@ func (this *Backend) MergeFrom(other Backend) *Backend {
 	this.Priority = other.Priority
 	this.Weight = other.Weight
 	this.Sni = other.Sni
+	this.MaxConnections = other.MaxConnections
+	this.Source = other.Source
+	this.Hostname = other.Hostname
+	this.Labels = other.Labels
 
 	return this
 }
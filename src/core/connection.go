@@ -0,0 +1,45 @@
+/**
+ * connection.go - client connection info
+ */
+
+package core
+
+import (
+	"time"
+)
+
+/**
+ * Current client connection proxied by server
+ */
+type Connection struct {
+
+	/* Connection id, unique within server */
+	Id uint64 `json:"id"`
+
+	/* Client address */
+	Client string `json:"client"`
+
+	/* Sni hostname requested by client, if any */
+	Sni string `json:"sni,omitempty"`
+
+	/* Backend address connection is proxied to, empty until connected */
+	Backend string `json:"backend"`
+
+	/* Time connection was accepted */
+	Started time.Time `json:"started"`
+
+	/* Connection age, seconds */
+	Age float64 `json:"age"`
+
+	/* Received bytes from backend */
+	Rx uint64 `json:"rx"`
+
+	/* Transmitted bytes to backend */
+	Tx uint64 `json:"tx"`
+
+	/* Received bytes from backend / second */
+	RxSecond uint `json:"rx_second"`
+
+	/* Transmitted bytes to backend / second */
+	TxSecond uint `json:"tx_second"`
+}
@@ -8,6 +8,21 @@ package core
 
 import "net"
 
+/**
+ * Ip of clients connected to unix domain sockets, they're local
+ */
+var UnixClientIp = net.IPv4(127, 0, 0, 1)
+
+/**
+ * Returns ip of client address, UnixClientIp if it's not tcp one
+ */
+func AddrIp(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return UnixClientIp
+}
+
 type Context interface {
 	String() string
 	Ip() net.IP
@@ -24,6 +39,26 @@ type TcpContext struct {
 	 * Current client connection
 	 */
 	Conn net.Conn
+	/**
+	 * Client protocol detected by mux, empty if mux is disabled or protocol is unknown
+	 */
+	Protocol string
+	/**
+	 * Name of mux route matched by client first bytes, empty if none matched
+	 */
+	MuxRoute string
+	/**
+	 * Alpn protocol negotiated with tls client
+	 */
+	Alpn string
+	/**
+	 * First bytes client sent, read by mux or for script
+	 */
+	FirstBytes []byte
+	/**
+	 * Tags script set for connection
+	 */
+	Tags map[string]string
 }
 
 func (t TcpContext) String() string {
@@ -31,11 +66,14 @@ func (t TcpContext) String() string {
 }
 
 func (t TcpContext) Ip() net.IP {
-	return t.Conn.RemoteAddr().(*net.TCPAddr).IP
+	return AddrIp(t.Conn.RemoteAddr())
 }
 
 func (t TcpContext) Port() int {
-	return t.Conn.RemoteAddr().(*net.TCPAddr).Port
+	if tcpAddr, ok := t.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.Port
+	}
+	return 0
 }
 
 func (t TcpContext) Sni() string {
@@ -68,3 +106,67 @@ func (u UdpContext) Port() int {
 func (u UdpContext) Sni() string {
 	return ""
 }
+
+/*
+ * Proxy quic context
+ */
+type QuicContext struct {
+
+	/**
+	 * Server name client requested with sni
+	 */
+	Hostname string
+
+	/**
+	 * Current client remote address
+	 */
+	RemoteAddr net.UDPAddr
+}
+
+func (q QuicContext) String() string {
+	return q.RemoteAddr.String()
+}
+
+func (q QuicContext) Ip() net.IP {
+	return q.RemoteAddr.IP
+}
+
+func (q QuicContext) Port() int {
+	return q.RemoteAddr.Port
+}
+
+func (q QuicContext) Sni() string {
+	return q.Hostname
+}
+
+/*
+ * Proxy http context
+ */
+type HttpContext struct {
+
+	/**
+	 * Requested host, without port
+	 */
+	Host string
+
+	/**
+	 * Current client remote address
+	 */
+	RemoteAddr net.TCPAddr
+}
+
+func (h HttpContext) String() string {
+	return h.RemoteAddr.String()
+}
+
+func (h HttpContext) Ip() net.IP {
+	return h.RemoteAddr.IP
+}
+
+func (h HttpContext) Port() int {
+	return h.RemoteAddr.Port
+}
+
+func (h HttpContext) Sni() string {
+	return h.Host
+}
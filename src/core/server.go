@@ -8,6 +8,8 @@
 package core
 
 import (
+	"time"
+
 	"../config"
 )
 
@@ -26,6 +28,12 @@ type Server interface {
 	 */
 	Stop()
 
+	/**
+	 * Stop accepting new connections and stop server
+	 * after active ones are finished or timeout passed
+	 */
+	Drain(timeout time.Duration)
+
 	/**
 	 * Get server configuration
 	 */
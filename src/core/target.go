@@ -5,6 +5,13 @@
  */
 package core
 
+import "strings"
+
+/**
+ * Prefix of unix domain socket targets hosts, i.e. "unix:/var/run/app.sock", such targets have no port
+ */
+const UNIX_PREFIX = "unix:"
+
 /**
  * Target host and port
  */
@@ -23,12 +30,42 @@ func (t *Target) EqualTo(other Target) bool {
 
 /**
  * Get target full address
- * host:port
+ * host:port, or unix:path for unix domain socket
  */
 func (this *Target) Address() string {
+	if this.IsUnix() {
+		return this.Host
+	}
 	return this.Host + ":" + this.Port
 }
 
+/**
+ * Checks if target is unix domain socket
+ */
+func (this *Target) IsUnix() bool {
+	return strings.HasPrefix(this.Host, UNIX_PREFIX)
+}
+
+/**
+ * Stream network target is dialed with, "unix" or "tcp"
+ */
+func (this *Target) Network() string {
+	if this.IsUnix() {
+		return "unix"
+	}
+	return "tcp"
+}
+
+/**
+ * Address target is dialed with, socket path or host:port
+ */
+func (this *Target) DialAddress() string {
+	if this.IsUnix() {
+		return strings.TrimPrefix(this.Host, UNIX_PREFIX)
+	}
+	return this.Address()
+}
+
 /**
  * To String conversion
  */
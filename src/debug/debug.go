@@ -0,0 +1,87 @@
+/**
+ * debug.go - debug server exposing pprof, expvar and internal state
+ */
+
+package debug
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+
+	"../config"
+	"../logging"
+	"../utils/upgrade"
+)
+
+const (
+	/* Default debug server bind, local only as profiles expose internals */
+	DEFAULT_BIND = "localhost:6060"
+)
+
+/* http server exposing debug endpoints */
+var server *http.Server
+
+/* state is published to expvar once */
+var publish sync.Once
+
+/**
+ * Starts debug server, listening on bind before returning
+ */
+func Start(cfg config.DebugConfig) {
+
+	log := logging.For("debug")
+
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.Bind == "" {
+		cfg.Bind = DEFAULT_BIND
+	}
+
+	runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+
+	publish.Do(func() {
+		expvar.Publish("gobetween", expvar.Func(func() interface{} {
+			return State()
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/state", handleState)
+
+	log.Warn("Starting debug server ", cfg.Bind, ", it should not be reachable publicly")
+
+	// Listener is taken from old process on upgrade
+	listener, err := upgrade.Listen(cfg.Bind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+/**
+ * Stops debug server
+ */
+func Stop() {
+	if server != nil {
+		server.Close()
+	}
+}
@@ -0,0 +1,156 @@
+/**
+ * state.go - internal queues and goroutines per server
+ */
+
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+
+	"../manager"
+	"../server/modules/connlimit"
+	"../server/scheduler"
+	"../tracing"
+)
+
+/**
+ * Goroutine profile record header and labels lines, as written with debug = 1
+ */
+var (
+	recordPattern      = regexp.MustCompile(`^(\d+) @`)
+	serverLabelPattern = regexp.MustCompile(`^# labels: .*"server":("(?:[^"\\]|\\.)*")`)
+)
+
+/**
+ * Process internal state, for diagnosing stalls
+ */
+type ProcessState struct {
+
+	/* Current goroutines, and ones not started by any server */
+	Goroutines      int `json:"goroutines"`
+	OtherGoroutines int `json:"other_goroutines"`
+
+	/* Process-wide current connections and ones queued over limits.max_connections */
+	Connections int `json:"connections"`
+	Queued      int `json:"queued"`
+
+	/* Finished spans waiting for export */
+	TracingQueue int `json:"tracing_queue"`
+
+	Servers map[string]ServerState `json:"servers"`
+}
+
+/**
+ * Server internal queues and goroutines it started, including
+ * ones of stopped server instance still draining
+ */
+type ServerState struct {
+	manager.ServerState
+	Goroutines int `json:"goroutines"`
+}
+
+/**
+ * Returns current internal state
+ */
+func State() ProcessState {
+
+	state := ProcessState{
+		Goroutines:   runtime.NumGoroutine(),
+		TracingQueue: tracing.Global.Queued(),
+		Servers:      map[string]ServerState{},
+	}
+
+	state.Connections, state.Queued = connlimit.Global.Count()
+
+	for name, s := range manager.ServersState() {
+		state.Servers[name] = ServerState{ServerState: s}
+	}
+
+	goroutines, other := serverGoroutines()
+	state.OtherGoroutines = other
+
+	for name, count := range goroutines {
+		s, ok := state.Servers[name]
+		if !ok {
+			s.Pools = []scheduler.State{}
+		}
+		s.Goroutines = count
+		state.Servers[name] = s
+	}
+
+	return state
+}
+
+/**
+ * Counts goroutines by server label they inherited from server start,
+ * and goroutines without it
+ */
+func serverGoroutines() (map[string]int, int) {
+
+	counts := map[string]int{}
+	other := 0
+
+	var b bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		return counts, 0
+	}
+
+	/* Records are "<count> @ <pcs>" lines, followed by "# labels: {...}" if goroutines are labeled */
+	pending := 0
+	scanner := bufio.NewScanner(&b)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := recordPattern.FindStringSubmatch(line); m != nil {
+			other += pending
+			pending, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		if pending == 0 {
+			continue
+		}
+
+		if m := serverLabelPattern.FindStringSubmatch(line); m != nil {
+			if name, err := strconv.Unquote(m[1]); err == nil {
+				counts[name] += pending
+				pending = 0
+			}
+			continue
+		}
+
+		/* Stack frames follow labels, so record has no server label */
+		if len(line) > 1 && line[0] == '#' && line[1] == '\t' {
+			other += pending
+			pending = 0
+		}
+	}
+
+	other += pending
+
+	return counts, other
+}
+
+/**
+ * Handles GET /debug/state
+ */
+func handleState(w http.ResponseWriter, r *http.Request) {
+
+	body, err := json.MarshalIndent(State(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,207 @@
+/**
+ * aws.go - AWS EC2 instances / autoscaling group discovery implementation
+ */
+
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	awsRetryWaitDuration = 2 * time.Second
+	awsTimeout           = 10 * time.Second
+)
+
+/**
+ * Create new Discovery with AWS fetch func
+ */
+func NewAwsDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{awsRetryWaitDuration},
+		fetch: awsFetch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Fetch backends from running EC2 instances, filtered by tags
+ * or being in service of autoscaling group
+ */
+func awsFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+
+	log := logging.For("awsFetch")
+
+	log.Info("Fetching ", cfg)
+
+	// TODO move session creation to constructor
+	awsCfg := aws.NewConfig().
+		WithRegion(cfg.AwsRegion).
+		WithHTTPClient(&http.Client{Timeout: utils.ParseDurationOrDefault(cfg.Timeout, awsTimeout)})
+
+	// Static credentials if set, default credentials chain otherwise
+	if cfg.AwsAccessKeyId != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AwsAccessKeyId, cfg.AwsSecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:  *awsCfg,
+		Profile: cfg.AwsProfile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("running")},
+		}},
+	}
+
+	if cfg.AwsAutoscalingGroup != "" {
+
+		ids, err := awsAutoscalingGroupInstances(autoscaling.New(sess), cfg.AwsAutoscalingGroup)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ids) == 0 {
+			return &[]core.Backend{}, nil
+		}
+
+		input.InstanceIds = ids
+	}
+
+	for _, f := range cfg.AwsTagFilters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Invalid aws_tag_filters entry, expected <tag>=<value>: " + f)
+		}
+
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name:   aws.String("tag:" + kv[0]),
+			Values: []*string{aws.String(kv[1])},
+		})
+	}
+
+	// Gather backends
+	backends := []core.Backend{}
+
+	err = ec2.New(sess).DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				backend, err := awsBackend(cfg, instance)
+				if err != nil {
+					log.Warn("Skipping instance ", aws.StringValue(instance.InstanceId), ": ", err)
+					continue
+				}
+				backends = append(backends, *backend)
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &backends, nil
+}
+
+/**
+ * Returns ids of instances in service of autoscaling group
+ */
+func awsAutoscalingGroupInstances(client *autoscaling.AutoScaling, name string) ([]*string, error) {
+
+	out, err := client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.AutoScalingGroups) == 0 {
+		return nil, errors.New("Autoscaling group not found: " + name)
+	}
+
+	ids := []*string{}
+	for _, instance := range out.AutoScalingGroups[0].Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			ids = append(ids, instance.InstanceId)
+		}
+	}
+
+	return ids, nil
+}
+
+/**
+ * Converts instance to backend, taking address of configured type
+ * and port from tag if configured
+ */
+func awsBackend(cfg config.DiscoveryConfig, instance *ec2.Instance) (*core.Backend, error) {
+
+	host := aws.StringValue(instance.PrivateIpAddress)
+	if cfg.AwsAddressType == "public" {
+		host = aws.StringValue(instance.PublicIpAddress)
+	}
+
+	if host == "" {
+		return nil, errors.New("No " + cfg.AwsAddressType + " ip address")
+	}
+
+	port := cfg.AwsPort
+	sni := ""
+
+	for _, tag := range instance.Tags {
+		key, value := aws.StringValue(tag.Key), aws.StringValue(tag.Value)
+
+		if cfg.AwsPortTag != "" && key == cfg.AwsPortTag {
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.New("Invalid port in tag " + key + ": " + value)
+			}
+			port = p
+		}
+
+		if cfg.AwsSniTag != "" && key == cfg.AwsSniTag {
+			sni = value
+		}
+	}
+
+	if port == 0 {
+		return nil, errors.New("No port")
+	}
+
+	return &core.Backend{
+		Target: core.Target{
+			Host: host,
+			Port: fmt.Sprintf("%v", port),
+		},
+		Priority: 1,
+		Weight:   1,
+		Stats: core.BackendStats{
+			Live: true,
+		},
+		Sni: sni,
+	}, nil
+}
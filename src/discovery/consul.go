@@ -14,6 +14,7 @@ import (
 	"fmt"
 	consul "github.com/hashicorp/consul/api"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,16 +22,28 @@ import (
 const (
 	consulRetryWaitDuration = 2 * time.Second
 	consulTimeout           = 2 * time.Second
+
+	/* Max time blocking query waits for changes */
+	consulWaitTime = 5 * time.Minute
 )
 
 /**
- * Create new Discovery with Consul fetch func
+ * Result of blocking query
+ */
+type consulResult struct {
+	entries []*consul.ServiceEntry
+	meta    *consul.QueryMeta
+	err     error
+}
+
+/**
+ * Create new Discovery with Consul watch func
  */
 func NewConsulDiscovery(cfg config.DiscoveryConfig) interface{} {
 
 	d := Discovery{
 		opts:  DiscoveryOpts{consulRetryWaitDuration},
-		fetch: consulFetch,
+		watch: consulWatch,
 		cfg:   cfg,
 	}
 
@@ -38,16 +51,78 @@ func NewConsulDiscovery(cfg config.DiscoveryConfig) interface{} {
 }
 
 /**
- * Fetch backends from Consul API
+ * Watch backends with Consul API blocking queries
  */
-func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func consulWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("consulWatch")
 
-	log := logging.For("consulFetch")
+	log.Info("Watching ", cfg)
 
-	log.Info("Fetching ", cfg)
+	client, err := consulClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Server side tag filtering supports single tag only, others are filtered here
+	tags := consulTags(cfg)
+	tag := ""
+	if len(tags) > 0 {
+		tag = tags[0]
+	}
+
+	var index uint64
+
+	for {
+		results := make(chan consulResult, 1)
+
+		go func(index uint64) {
+			entries, meta, err := client.Health().Service(cfg.ConsulServiceName, tag, cfg.ConsulServicePassingOnly, &consul.QueryOptions{
+				Datacenter: cfg.ConsulDatacenter,
+				Token:      cfg.ConsulAclToken,
+				WaitIndex:  index,
+				WaitTime:   consulWaitTime,
+			})
+			results <- consulResult{entries, meta, err}
+		}(index)
+
+		var result consulResult
+		select {
+		case result = <-results:
+		case <-stop:
+			return nil
+		}
+
+		if result.err != nil {
+			return result.err
+		}
+
+		// Wait timed out without changes
+		if index != 0 && result.meta.LastIndex == index {
+			continue
+		}
+
+		// Reset index if it went backwards, i.e. consul state was reset
+		if result.meta.LastIndex < index {
+			index = 0
+		} else {
+			index = result.meta.LastIndex
+		}
+
+		select {
+		case out <- consulBackends(result.entries, tags):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Create consul client from config
+ */
+func consulClient(cfg config.DiscoveryConfig) (*consul.Client, error) {
 
 	// Prepare vars for http client
-	// TODO move http & consul client creation to constructor
 	scheme := "http"
 	transport := &http.Transport{
 		DisableKeepAlives: true,
@@ -69,49 +144,86 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		scheme = "https"
 	}
 
-	// Parse http timeout
-	timeout := utils.ParseDurationOrDefault(cfg.Timeout, consulTimeout)
+	// Parse http timeout, blocking queries are waited on top of it (consul adds up to wait / 16 jitter)
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, consulTimeout) + consulWaitTime + consulWaitTime/16
 
 	// Create consul client
-	client, _ := consul.NewClient(&consul.Config{
+	return consul.NewClient(&consul.Config{
 		Scheme:     scheme,
 		Address:    cfg.ConsulHost,
 		Datacenter: cfg.ConsulDatacenter,
+		Token:      cfg.ConsulAclToken,
 		HttpAuth: &consul.HttpBasicAuth{
 			Username: cfg.ConsulAuthUsername,
 			Password: cfg.ConsulAuthPassword,
 		},
 		HttpClient: &http.Client{Timeout: timeout, Transport: transport},
 	})
+}
 
-	// Query service
-	service, _, err := client.Health().Service(cfg.ConsulServiceName, cfg.ConsulServiceTag, cfg.ConsulServicePassingOnly, nil)
-	if err != nil {
-		return nil, err
+/**
+ * Returns all tags services should have
+ */
+func consulTags(cfg config.DiscoveryConfig) []string {
+
+	tags := []string{}
+	if cfg.ConsulServiceTag != "" {
+		tags = append(tags, cfg.ConsulServiceTag)
 	}
 
-	// Gather backends
+	return append(tags, cfg.ConsulServiceTags...)
+}
+
+/**
+ * Convert service entries having all tags and not in critical state to backends
+ */
+func consulBackends(entries []*consul.ServiceEntry, tags []string) []core.Backend {
+
 	backends := []core.Backend{}
-	for _, entry := range service {
+
+	for _, entry := range entries {
 		s := entry.Service
+
+		if entry.Checks.AggregatedStatus() == consul.HealthCritical {
+			continue
+		}
+
+		if !consulHasTags(s.Tags, tags) {
+			continue
+		}
+
 		sni := ""
+		maxConnections := 0
+		labels := map[string]string{}
 
+		// Tag is label "<key>=<value>", or label with empty value
 		for _, tag := range s.Tags {
 			split := strings.SplitN(tag, "=", 2)
 
 			if len(split) != 2 {
+				labels[tag] = ""
 				continue
 			}
 
-			if split[0] != "sni" {
-				continue
+			labels[split[0]] = split[1]
+
+			switch split[0] {
+			case "sni":
+				sni = split[1]
+			case "max_connections":
+				maxConnections, _ = strconv.Atoi(split[1])
 			}
-			sni = split[1]
+		}
+
+		// Service address may be empty, meaning node address should be used
+		host := s.Address
+		if host == "" && entry.Node != nil {
+			host = entry.Node.Address
 		}
 
 		backends = append(backends, core.Backend{
 			Target: core.Target{
-				Host: s.Address,
+				Host: host,
 				Port: fmt.Sprintf("%v", s.Port),
 			},
 			Priority: 1,
@@ -119,9 +231,32 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 			Stats: core.BackendStats{
 				Live: true,
 			},
-			Sni: sni,
+			Sni:            sni,
+			MaxConnections: maxConnections,
+			Labels:         labels,
 		})
 	}
 
-	return &backends, nil
+	return backends
+}
+
+/**
+ * Check if service tags contain all required tags
+ */
+func consulHasTags(serviceTags []string, required []string) bool {
+
+	for _, r := range required {
+		found := false
+		for _, t := range serviceTags {
+			if t == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
@@ -10,9 +10,16 @@ import (
 	"../config"
 	"../core"
 	"../logging"
+	"../utils"
+	"sync"
 	"time"
 )
 
+/**
+ * Minimal wait before restarting watch that ended without error
+ */
+const watchRestartMinWait = 1 * time.Second
+
 /**
  * Registry of factory methods for Discoveries
  */
@@ -30,6 +37,12 @@ func init() {
 	registry["plaintext"] = NewPlaintextDiscovery
 	registry["consul"] = NewConsulDiscovery
 	registry["lxd"] = NewLXDDiscovery
+	registry["kubernetes"] = NewKubernetesDiscovery
+	registry["etcd"] = NewEtcdDiscovery
+	registry["aws"] = NewAwsDiscovery
+	registry["zookeeper"] = NewZookeeperDiscovery
+	registry["merge"] = NewMergeDiscovery
+	registry["grpc"] = NewGrpcDiscovery
 }
 
 /**
@@ -44,6 +57,32 @@ func New(strategy string, cfg config.DiscoveryConfig) *Discovery {
  */
 type FetchFunc func(config.DiscoveryConfig) (*[]core.Backend, error)
 
+/**
+ * Watch func for push discovery.
+ * Should send backends to out on every change until stop is closed.
+ * Returns error if watch failed, or nil if it should be just restarted
+ */
+type WatchFunc func(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error
+
+/**
+ * Discovery health
+ */
+type Health struct {
+
+	/* Time backends were discovered last time, zero if never */
+	LastSuccess time.Time `json:"last_success"`
+
+	/* Last discovery error, if any */
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+
+	/* Errors since last success */
+	ConsecutiveErrors int `json:"consecutive_errors"`
+
+	/* Backends were emptied by failpolicy and not discovered since */
+	Emptied bool `json:"emptied"`
+}
+
 /**
  * Options for pull discovery
  */
@@ -66,11 +105,21 @@ type Discovery struct {
 	 */
 	fetch FetchFunc
 
+	/**
+	 * Function to watch backends, used instead of fetch if set
+	 */
+	watch WatchFunc
+
 	/**
 	 * Options for fetch
 	 */
 	opts DiscoveryOpts
 
+	/**
+	 * If set, watch retry wait is doubled on consecutive failures up to this duration
+	 */
+	maxRetryWait time.Duration
+
 	/**
 	 * Discovery configuration
 	 */
@@ -80,6 +129,23 @@ type Discovery struct {
 	 * Channel where to push newly discovered backends
 	 */
 	out chan ([]core.Backend)
+
+	/**
+	 * Channel for stopping discovery, closed once
+	 */
+	stop     chan bool
+	stopOnce sync.Once
+
+	/**
+	 * Time discovery was started
+	 */
+	started time.Time
+
+	/**
+	 * Current health, guarded by mutex as it's read by api
+	 */
+	health      Health
+	healthMutex sync.Mutex
 }
 
 /**
@@ -90,6 +156,13 @@ func (this *Discovery) Start() {
 	log := logging.For("discovery")
 
 	this.out = make(chan []core.Backend)
+	this.stop = make(chan bool)
+	this.started = time.Now()
+
+	if this.watch != nil {
+		go this.watchLoop()
+		return
+	}
 
 	// Prepare interval
 	interval, err := time.ParseDuration(this.cfg.Interval)
@@ -102,24 +175,25 @@ func (this *Discovery) Start() {
 			backends, err := this.fetch(this.cfg)
 
 			if err != nil {
+				this.failed(err)
 				log.Error(this.cfg.Kind, " error ", err, " retrying in ", this.opts.RetryWaitDuration.String())
 
-				log.Info("Applying failpolicy ", this.cfg.Failpolicy)
-
-				if this.cfg.Failpolicy == "setempty" {
-					this.backends = &[]core.Backend{}
-					this.out <- *this.backends
+				if !this.applyFailpolicy() || !this.wait(this.opts.RetryWaitDuration) {
+					return
 				}
-
-				time.Sleep(this.opts.RetryWaitDuration)
 				continue
 			}
 
 			// cache
 			this.backends = backends
+			this.succeeded()
 
 			// out
-			this.out <- *this.backends
+			select {
+			case this.out <- *this.backends:
+			case <-this.stop:
+				return
+			}
 
 			// exit gorouting if no cacheTtl
 			// used for static discovery
@@ -127,16 +201,191 @@ func (this *Discovery) Start() {
 				return
 			}
 
-			time.Sleep(interval)
+			if !this.wait(interval) {
+				return
+			}
 		}
 	}()
 }
 
+/**
+ * Watch backends loop, restarting watch when it ends
+ */
+func (this *Discovery) watchLoop() {
+
+	log := logging.For("discovery")
+
+	retryWait := this.opts.RetryWaitDuration
+
+	// Relay watched backends, so every update counts as success
+	watched := make(chan []core.Backend)
+
+	go func() {
+		for {
+			select {
+			case backends := <-watched:
+				this.succeeded()
+				select {
+				case this.out <- backends:
+				case <-this.stop:
+					return
+				}
+			case <-this.stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		started := time.Now()
+		err := this.watch(this.cfg, watched, this.stop)
+
+		select {
+		case <-this.stop:
+			return
+		default:
+		}
+
+		if err == nil {
+			log.Debug(this.cfg.Kind, " watch ended, restarting")
+
+			// Watch ending at once should not be restarted in a busy loop
+			restartWait := this.opts.RetryWaitDuration
+			if restartWait < watchRestartMinWait {
+				restartWait = watchRestartMinWait
+			}
+
+			if time.Since(started) < restartWait && !this.wait(restartWait) {
+				return
+			}
+			continue
+		}
+
+		// Watch worked for a while, so failures are not consecutive
+		if time.Since(started) > this.maxRetryWait {
+			retryWait = this.opts.RetryWaitDuration
+		}
+
+		this.failed(err)
+
+		log.Error(this.cfg.Kind, " error ", err, " retrying in ", retryWait.String())
+
+		if !this.applyFailpolicy() || !this.wait(retryWait) {
+			return
+		}
+
+		if this.maxRetryWait > 0 {
+			retryWait *= 2
+			if retryWait > this.maxRetryWait {
+				retryWait = this.maxRetryWait
+			}
+		}
+	}
+}
+
+/**
+ * Apply failpolicy after discovery error: "setempty" empties backends after
+ * max_failures consecutive errors, "keeplast" keeps last discovered backends
+ * until they are older than stale_ttl, if set.
+ * Returns false if discovery was stopped meanwhile
+ */
+func (this *Discovery) applyFailpolicy() bool {
+
+	log := logging.For("discovery")
+
+	health := this.Health()
+
+	switch this.cfg.Failpolicy {
+	case "setempty":
+		if health.ConsecutiveErrors < this.cfg.MaxFailures {
+			return true
+		}
+	default:
+		staleTtl := utils.ParseDurationOrDefault(this.cfg.StaleTtl, 0)
+
+		last := health.LastSuccess
+		if last.IsZero() {
+			last = this.started
+		}
+
+		if staleTtl == 0 || time.Since(last) < staleTtl {
+			return true
+		}
+	}
+
+	log.Info("Applying failpolicy ", this.cfg.Failpolicy, ", emptying backends")
+
+	this.backends = &[]core.Backend{}
+
+	this.healthMutex.Lock()
+	this.health.Emptied = true
+	this.healthMutex.Unlock()
+
+	select {
+	case this.out <- *this.backends:
+		return true
+	case <-this.stop:
+		return false
+	}
+}
+
+/**
+ * Wait for duration d.
+ * Returns false if discovery was stopped meanwhile
+ */
+func (this *Discovery) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-this.stop:
+		return false
+	}
+}
+
+/**
+ * Records successful discovery
+ */
+func (this *Discovery) succeeded() {
+
+	this.healthMutex.Lock()
+	defer this.healthMutex.Unlock()
+
+	this.health.LastSuccess = time.Now()
+	this.health.ConsecutiveErrors = 0
+	this.health.Emptied = false
+}
+
+/**
+ * Records discovery error
+ */
+func (this *Discovery) failed(err error) {
+
+	this.healthMutex.Lock()
+	defer this.healthMutex.Unlock()
+
+	this.health.LastError = err.Error()
+	this.health.LastErrorTime = time.Now()
+	this.health.ConsecutiveErrors++
+}
+
+/**
+ * Returns current discovery health
+ */
+func (this *Discovery) Health() Health {
+
+	this.healthMutex.Lock()
+	defer this.healthMutex.Unlock()
+
+	return this.health
+}
+
 /**
  * Stop discovery
  */
 func (this *Discovery) Stop() {
-	// TODO: Add stopping function
+	this.stopOnce.Do(func() {
+		close(this.stop)
+	})
 }
 
 /**
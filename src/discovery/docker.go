@@ -105,7 +105,8 @@ func dockerFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 				Stats: core.BackendStats{
 					Live: true,
 				},
-				Sni: container.Labels["sni"],
+				Sni:    container.Labels["sni"],
+				Labels: container.Labels,
 			})
 		}
 	}
@@ -0,0 +1,252 @@
+/**
+ * etcd.go - etcd v3 key prefix watch discovery implementation
+ */
+
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../utils"
+	"../utils/parsers"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/pkg/transport"
+	"golang.org/x/net/context"
+)
+
+const (
+	etcdRetryWaitDuration = 2 * time.Second
+	etcdTimeout           = 5 * time.Second
+)
+
+/**
+ * Backend value in etcd in json form
+ */
+type etcdBackendValue struct {
+	Host     string      `json:"host"`
+	Port     interface{} `json:"port"`
+	Weight   int         `json:"weight"`
+	Priority int         `json:"priority"`
+	Sni      string      `json:"sni"`
+
+	MaxConnections int               `json:"max_connections"`
+	Labels         map[string]string `json:"labels"`
+}
+
+/**
+ * Create new Discovery with etcd watch func
+ */
+func NewEtcdDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{etcdRetryWaitDuration},
+		watch: etcdWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Get backends under prefix and watch for it's changes
+ */
+func etcdWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("etcdWatch")
+
+	log.Info("Watching ", cfg.EtcdEndpoints, " ", cfg.EtcdPrefix)
+
+	client, err := etcdBuildClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	/* Fetch current backends */
+
+	getCtx, getCancel := context.WithTimeout(ctx, utils.ParseDurationOrDefault(cfg.Timeout, etcdTimeout))
+	resp, err := client.Get(getCtx, cfg.EtcdPrefix, clientv3.WithPrefix())
+	getCancel()
+
+	if err != nil {
+		return err
+	}
+
+	backends := map[string]core.Backend{}
+
+	for _, kv := range resp.Kvs {
+		etcdUpdateBackend(backends, string(kv.Key), kv.Value)
+	}
+
+	select {
+	case out <- etcdBackendsList(backends):
+	case <-stop:
+		return nil
+	}
+
+	/* Watch for changes since fetched revision */
+
+	watchChan := client.Watch(ctx, cfg.EtcdPrefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+
+	for watchResp := range watchChan {
+
+		if err := watchResp.Err(); err != nil {
+			return err
+		}
+
+		for _, event := range watchResp.Events {
+			switch event.Type {
+			case clientv3.EventTypePut:
+				etcdUpdateBackend(backends, string(event.Kv.Key), event.Kv.Value)
+			case clientv3.EventTypeDelete:
+				delete(backends, string(event.Kv.Key))
+			}
+		}
+
+		select {
+		case out <- etcdBackendsList(backends):
+		case <-stop:
+			return nil
+		}
+	}
+
+	select {
+	case <-stop:
+		return nil
+	default:
+		return errors.New("etcd watch channel closed")
+	}
+}
+
+/**
+ * Create etcd v3 client
+ */
+func etcdBuildClient(cfg config.DiscoveryConfig) (*clientv3.Client, error) {
+
+	clientCfg := clientv3.Config{
+		Endpoints:   cfg.EtcdEndpoints,
+		DialTimeout: utils.ParseDurationOrDefault(cfg.Timeout, etcdTimeout),
+		Username:    cfg.EtcdUsername,
+		Password:    cfg.EtcdPassword,
+	}
+
+	if cfg.EtcdTlsEnabled {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      cfg.EtcdTlsCertPath,
+			KeyFile:       cfg.EtcdTlsKeyPath,
+			TrustedCAFile: cfg.EtcdTlsCacertPath,
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		clientCfg.TLS = tlsConfig
+	}
+
+	return clientv3.New(clientCfg)
+}
+
+/**
+ * Parse key value and put backend to backends map.
+ * Value is either "<host>:<port> [weight=<int>] [priority=<int>] [sni=<string>] [max_connections=<int>]"
+ * or json object with host, port, weight, priority, sni and max_connections fields
+ */
+func etcdUpdateBackend(backends map[string]core.Backend, key string, value []byte) {
+
+	log := logging.For("etcdUpdateBackend")
+
+	var backend *core.Backend
+	var err error
+
+	if bytes.HasPrefix(bytes.TrimSpace(value), []byte("{")) {
+		backend, err = etcdParseJsonBackend(value)
+	} else {
+		backend, err = parsers.ParseBackendDefault(string(value))
+	}
+
+	if err != nil {
+		log.Warn("Can't parse value of ", key, ": ", err)
+		delete(backends, key)
+		return
+	}
+
+	backends[key] = *backend
+}
+
+/**
+ * Parse backend in json form
+ */
+func etcdParseJsonBackend(value []byte) (*core.Backend, error) {
+
+	v := etcdBackendValue{
+		Weight:   1,
+		Priority: 1,
+	}
+
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil, err
+	}
+
+	if v.Host == "" || v.Port == nil {
+		return nil, errors.New("host and port should be specified")
+	}
+
+	return &core.Backend{
+		Target: core.Target{
+			Host: v.Host,
+			Port: fmt.Sprintf("%v", v.Port),
+		},
+		Priority: v.Priority,
+		Weight:   v.Weight,
+		Sni:      v.Sni,
+
+		MaxConnections: v.MaxConnections,
+		Labels:         v.Labels,
+
+		Stats: core.BackendStats{
+			Live: true,
+		},
+	}, nil
+}
+
+/**
+ * Returns backends ordered by keys
+ */
+func etcdBackendsList(backends map[string]core.Backend) []core.Backend {
+
+	keys := make([]string, 0, len(backends))
+	for k := range backends {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]core.Backend, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, backends[k])
+	}
+
+	return result
+}
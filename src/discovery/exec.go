@@ -13,17 +13,24 @@ import (
 	"../logging"
 	"../utils"
 	"../utils/parsers"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 )
 
 const (
-	execRetryWaitDuration   = 2 * time.Second
-	execResponseWaitTimeout = 3 * time.Second
+	execRetryWaitDuration    = 2 * time.Second
+	execMaxRetryWaitDuration = 1 * time.Minute
+	execResponseWaitTimeout  = 3 * time.Second
+	execStreamMaxLineSize    = 1024 * 1024
 )
 
 /**
- * Create new Discovery with Exec fetch func
+ * Create new Discovery with Exec fetch func,
+ * or watch func for "stream" exec_mode
  */
 func NewExecDiscovery(cfg config.DiscoveryConfig) interface{} {
 
@@ -33,6 +40,12 @@ func NewExecDiscovery(cfg config.DiscoveryConfig) interface{} {
 		cfg:   cfg,
 	}
 
+	if cfg.ExecDiscoveryConfig != nil && cfg.ExecMode == "stream" {
+		d.fetch = nil
+		d.watch = execWatch
+		d.maxRetryWait = execMaxRetryWaitDuration
+	}
+
 	return &d
 }
 
@@ -72,3 +85,123 @@ func execFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	return &backends, nil
 }
+
+/**
+ * Run long-running process and read backends it streams to stdout,
+ * every line is json array of full current backends list.
+ * Process is killed when discovery is stopped and restarted if it exits
+ */
+func execWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("execWatch")
+
+	log.Info("Starting ", cfg.ExecCommand)
+
+	cmd, stdout, stderr, err := utils.ExecStart(cfg.ExecCommand...)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan bool)
+
+	go func() {
+		select {
+		case <-stop:
+			utils.KillProcess(cmd)
+		case <-done:
+		}
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Warn(cfg.ExecCommand[0], ": ", scanner.Text())
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), execStreamMaxLineSize)
+
+	stopped := false
+
+	for !stopped && scanner.Scan() {
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		backends, err := execParseStreamLine(line)
+		if err != nil {
+			log.Warn("Can't parse ", cfg.ExecCommand, " output: ", err)
+			continue
+		}
+
+		log.Debug("Received ", backends)
+
+		select {
+		case out <- backends:
+		case <-stop:
+			stopped = true
+		}
+	}
+
+	// Kill children left behind too, then reap the process
+	close(done)
+	utils.KillProcess(cmd)
+	waitErr := cmd.Wait()
+
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if waitErr != nil {
+		return waitErr
+	}
+
+	return errors.New("exec process exited")
+}
+
+/**
+ * Parse streamed json array of backends. Every backend is either
+ * "<host>:<port> [weight=<int>] [priority=<int>] [sni=<string>] [max_connections=<int>]"
+ * string or object with host, port, weight, priority, sni and max_connections fields
+ */
+func execParseStreamLine(line []byte) ([]core.Backend, error) {
+
+	values := []json.RawMessage{}
+	if err := json.Unmarshal(line, &values); err != nil {
+		return nil, err
+	}
+
+	backends := make([]core.Backend, 0, len(values))
+
+	for _, value := range values {
+
+		var backend *core.Backend
+		var err error
+
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("{")) {
+			backend, err = etcdParseJsonBackend(value)
+		} else {
+			var s string
+			if err = json.Unmarshal(value, &s); err == nil {
+				backend, err = parsers.ParseBackendDefault(s)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		backends = append(backends, *backend)
+	}
+
+	return backends, nil
+}
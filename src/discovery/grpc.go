@@ -0,0 +1,172 @@
+/**
+ * grpc.go - gRPC external discovery plugin implementation
+ */
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../utils"
+	"./grpcdiscovery"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	grpcRetryWaitDuration    = 2 * time.Second
+	grpcMaxRetryWaitDuration = 1 * time.Minute
+	grpcResponseWaitTimeout  = 10 * time.Second
+)
+
+/**
+ * Create new Discovery with gRPC plugin watch func
+ */
+func NewGrpcDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:         DiscoveryOpts{grpcRetryWaitDuration},
+		maxRetryWait: grpcMaxRetryWaitDuration,
+		watch:        grpcWatch,
+		cfg:          cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Connect to plugin, subscribe to backends of service and send
+ * every list plugin pushes until stream fails
+ */
+func grpcWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("grpcWatch")
+
+	log.Info("Watching ", cfg.GrpcAddress, " ", cfg.GrpcService)
+
+	conn, err := grpc.NewClient(cfg.GrpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := grpcdiscovery.NewDiscoveryClient(conn).DiscoverBackends(ctx, &grpcdiscovery.DiscoverRequest{
+		Service: cfg.GrpcService,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Plugin should send current backends at once, not only on the first change
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, 0)
+	if timeout <= 0 {
+		timeout = grpcResponseWaitTimeout
+	}
+
+	timer := time.AfterFunc(timeout, cancel)
+	waiting := true
+
+	for {
+		response, err := stream.Recv()
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// Timer not stopped in time has cancelled or is cancelling the stream
+		if waiting {
+			waiting = false
+			if !timer.Stop() {
+				return errors.New("no backends were received in " + timeout.String())
+			}
+		}
+
+		if err == io.EOF {
+			return errors.New("plugin ended stream")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		backends := []core.Backend{}
+		for _, b := range response.GetBackends() {
+
+			backend, err := grpcParseBackend(b)
+			if err != nil {
+				log.Warn("Can't parse backend ", b.String(), ": ", err)
+				continue
+			}
+
+			backends = append(backends, *backend)
+		}
+
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Convert backend plugin sent
+ */
+func grpcParseBackend(b *grpcdiscovery.Backend) (*core.Backend, error) {
+
+	backend := &core.Backend{
+		Target: core.Target{
+			Host: b.GetHost(),
+			Port: b.GetPort(),
+		},
+		Priority: int(b.GetPriority()),
+		Weight:   int(b.GetWeight()),
+		Sni:      b.GetSni(),
+
+		MaxConnections: int(b.GetMaxConnections()),
+		Labels:         b.GetLabels(),
+
+		Stats: core.BackendStats{
+			Live: true,
+		},
+	}
+
+	if backend.Host == "" || (backend.Port == "" && !backend.IsUnix()) {
+		return nil, errors.New("host and port should be specified")
+	}
+
+	if backend.Weight == 0 {
+		backend.Weight = 1
+	}
+
+	if backend.Priority == 0 {
+		backend.Priority = 1
+	}
+
+	if backend.Weight < 0 || backend.Priority < 0 || backend.MaxConnections < 0 {
+		return nil, errors.New("weight, priority and max_connections can't be negative")
+	}
+
+	return backend, nil
+}
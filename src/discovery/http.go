@@ -0,0 +1,181 @@
+/**
+ * http.go - http(s) fetching for json and plaintext discoveries
+ */
+
+package discovery
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../config"
+	"../logging"
+	"../utils"
+)
+
+/**
+ * Fetches discovery endpoint, remembering last response so next requests are
+ * conditional and unchanged content is not transferred again.
+ * Is used by single discovery loop, so is not synchronized
+ */
+type httpFetcher struct {
+
+	/* Client, created on first fetch */
+	client *http.Client
+
+	/* Validators of cached content */
+	etag         string
+	lastModified string
+
+	/* Content of last successful response */
+	content []byte
+}
+
+/**
+ * Fetch endpoint content, retrying failed requests
+ * with doubling wait as configured
+ */
+func (this *httpFetcher) fetch(cfg config.DiscoveryConfig, endpoint string, defaultTimeout time.Duration) ([]byte, error) {
+
+	log := logging.For("httpFetcher")
+
+	if this.client == nil {
+		client, err := httpBuildClient(cfg, defaultTimeout)
+		if err != nil {
+			return nil, err
+		}
+		this.client = client
+	}
+
+	retries := 0
+	retryWait := time.Duration(0)
+	if cfg.HttpDiscoveryConfig != nil {
+		retries = cfg.HttpRetries
+		retryWait = utils.ParseDurationOrDefault(cfg.HttpRetryWait, 0)
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		content, retryable, err := this.get(cfg, endpoint)
+		if err == nil {
+			return content, nil
+		}
+
+		if !retryable || attempt >= retries {
+			return nil, err
+		}
+
+		log.Warn("Fetching ", endpoint, " failed: ", err, ", retrying in ", retryWait.String())
+
+		time.Sleep(retryWait)
+		retryWait *= 2
+	}
+}
+
+/**
+ * Make single request. Returns if failed request may be retried
+ */
+func (this *httpFetcher) get(cfg config.DiscoveryConfig, endpoint string) ([]byte, bool, error) {
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cfg.HttpDiscoveryConfig != nil {
+		if cfg.HttpBasicAuthUsername != "" {
+			req.SetBasicAuth(cfg.HttpBasicAuthUsername, cfg.HttpBasicAuthPassword)
+		}
+		if cfg.HttpBearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+cfg.HttpBearerToken)
+		}
+	}
+
+	if this.content != nil {
+		if this.etag != "" {
+			req.Header.Set("If-None-Match", this.etag)
+		}
+		if this.lastModified != "" {
+			req.Header.Set("If-Modified-Since", this.lastModified)
+		}
+	}
+
+	res, err := this.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && this.content != nil {
+		logging.For("httpFetcher").Debug(endpoint, " is not modified")
+		return this.content, false, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		retryable := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, errors.New("Unexpected response status " + strconv.Itoa(res.StatusCode))
+	}
+
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	this.content = content
+	this.etag = res.Header.Get("ETag")
+	this.lastModified = res.Header.Get("Last-Modified")
+
+	return content, false, nil
+}
+
+/**
+ * Create http client with discovery timeout and tls options
+ */
+func httpBuildClient(cfg config.DiscoveryConfig, defaultTimeout time.Duration) (*http.Client, error) {
+
+	client := &http.Client{
+		Timeout: utils.ParseDurationOrDefault(cfg.Timeout, defaultTimeout),
+	}
+
+	if cfg.HttpDiscoveryConfig == nil {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.HttpTlsSkipVerify,
+	}
+
+	if cfg.HttpTlsCacertPath != "" {
+		caCertPem, err := ioutil.ReadFile(cfg.HttpTlsCacertPath)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCertPem) {
+			return nil, errors.New("Unable to load ca pem " + cfg.HttpTlsCacertPath)
+		}
+	}
+
+	if cfg.HttpTlsCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.HttpTlsCertPath, cfg.HttpTlsKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	return client, nil
+}
@@ -10,15 +10,12 @@ package discovery
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"time"
 
 	"../config"
 	"../core"
 	"../logging"
-	"../utils"
 	"github.com/elgs/gojq"
 )
 
@@ -30,6 +27,8 @@ const (
 	jsonDefaultWeightPattern   = "weight"
 	jsonDefaultPriorityPattern = "priority"
 	jsonDefaultSniPattern      = "sni"
+
+	jsonDefaultMaxConnectionsPattern = "max_connections"
 )
 
 /**
@@ -59,10 +58,18 @@ func NewJsonDiscovery(cfg config.DiscoveryConfig) interface{} {
 		cfg.JsonSniPattern = jsonDefaultSniPattern
 	}
 
+	if cfg.JsonMaxConnectionsPattern == "" {
+		cfg.JsonMaxConnectionsPattern = jsonDefaultMaxConnectionsPattern
+	}
+
+	fetcher := &httpFetcher{}
+
 	d := Discovery{
-		opts:  DiscoveryOpts{jsonRetryWaitDuration},
-		fetch: jsonFetch,
-		cfg:   cfg,
+		opts: DiscoveryOpts{jsonRetryWaitDuration},
+		fetch: func(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+			return jsonFetch(fetcher, cfg)
+		},
+		cfg: cfg,
 	}
 
 	return &d
@@ -71,24 +78,13 @@ func NewJsonDiscovery(cfg config.DiscoveryConfig) interface{} {
 /**
  * Fetch / refresh backends from URL with json in response
  */
-func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func jsonFetch(fetcher *httpFetcher, cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	log := logging.For("jsonFetch")
 
 	log.Info("fetching ", cfg.JsonEndpoint)
 
-	// Make request
-	timeout := utils.ParseDurationOrDefault(cfg.Timeout, jsonDefaultHttpTimeout)
-	client := http.Client{Timeout: timeout}
-	res, err := client.Get(cfg.JsonEndpoint)
-	if err != nil {
-		return nil, err
-	}
-
-	defer res.Body.Close()
-
-	// Read response
-	content, err := ioutil.ReadAll(res.Body)
+	content, err := fetcher.fetch(cfg, cfg.JsonEndpoint, jsonDefaultHttpTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +140,10 @@ func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 			backend.Sni = sni
 		}
 
+		if maxConnections, err := parsed.QueryToFloat64(key + cfg.JsonMaxConnectionsPattern); err == nil {
+			backend.MaxConnections = int(maxConnections)
+		}
+
 		backends = append(backends, backend)
 	}
 
@@ -0,0 +1,298 @@
+/**
+ * kubernetes.go - Kubernetes Endpoints discovery implementation
+ */
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	kubernetesRetryWaitDuration = 2 * time.Second
+	kubernetesDefaultNamespace  = "default"
+)
+
+/**
+ * Create new Discovery with Kubernetes watch func
+ */
+func NewKubernetesDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	if cfg.KubernetesNamespace == "" {
+		cfg.KubernetesNamespace = kubernetesDefaultNamespace
+	}
+
+	d := Discovery{
+		opts:  DiscoveryOpts{kubernetesRetryWaitDuration},
+		watch: kubernetesWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Watch service Endpoints and send backends on every change
+ */
+func kubernetesWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("kubernetesWatch")
+
+	log.Info("Watching ", cfg.KubernetesNamespace, "/", cfg.KubernetesServiceName)
+
+	client, err := kubernetesBuildClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Requests to api server are cancelled on stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	w, err := client.CoreV1().Endpoints(cfg.KubernetesNamespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: "metadata.name=" + cfg.KubernetesServiceName,
+	})
+	if err != nil {
+		return err
+	}
+
+	defer w.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case event, ok := <-w.ResultChan():
+
+			// Watch was closed by api server, should be restarted
+			if !ok {
+				return nil
+			}
+
+			var backends []core.Backend
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				endpoints, ok := event.Object.(*v1.Endpoints)
+				if !ok {
+					return errors.New("Unexpected object in kubernetes watch event")
+				}
+				backends = kubernetesBackends(ctx, client, cfg, endpoints)
+			case watch.Deleted:
+				backends = []core.Backend{}
+			case watch.Error:
+				if status, ok := event.Object.(*metav1.Status); ok {
+					return errors.New("Kubernetes watch error: " + status.Message)
+				}
+				return errors.New("Kubernetes watch error")
+			default:
+				continue
+			}
+
+			log.Debug("Discovered ", backends)
+
+			select {
+			case out <- backends:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+/**
+ * Create Kubernetes client either from kubeconfig or from
+ * in-cluster service account
+ */
+func kubernetesBuildClient(cfg config.DiscoveryConfig) (*kubernetes.Clientset, error) {
+
+	var restConfig *rest.Config
+	var err error
+
+	if cfg.KubernetesApiServer == "" && cfg.KubernetesKubeconfigPath == "" {
+		restConfig, err = rest.InClusterConfig()
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags(cfg.KubernetesApiServer, cfg.KubernetesKubeconfigPath)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(restConfig)
+}
+
+/**
+ * Convert ready endpoints addresses to backends
+ */
+func kubernetesBackends(ctx context.Context, client *kubernetes.Clientset, cfg config.DiscoveryConfig, endpoints *v1.Endpoints) []core.Backend {
+
+	log := logging.For("kubernetesBackends")
+
+	backends := []core.Backend{}
+
+	pods, err := kubernetesServicePods(ctx, client, cfg)
+	if err != nil {
+		log.Warn("Can't list pods of ", cfg.KubernetesNamespace, "/", cfg.KubernetesServiceName, ": ", err)
+	}
+
+	for _, subset := range endpoints.Subsets {
+
+		port, ok := kubernetesPort(cfg, subset.Ports)
+		if !ok {
+			continue
+		}
+
+		for _, address := range subset.Addresses {
+
+			backend := core.Backend{
+				Target: core.Target{
+					Host: address.IP,
+					Port: fmt.Sprintf("%v", port),
+				},
+				Priority: 1,
+				Weight:   1,
+				Stats: core.BackendStats{
+					Live: true,
+				},
+			}
+
+			if err := kubernetesApplyPodLabels(ctx, client, cfg, pods, address, &backend); err != nil {
+				log.Warn("Can't get pod labels for ", address.IP, ": ", err)
+			}
+
+			backends = append(backends, backend)
+		}
+	}
+
+	return backends
+}
+
+/**
+ * Find port to use either by name or take the first one
+ */
+func kubernetesPort(cfg config.DiscoveryConfig, ports []v1.EndpointPort) (int32, bool) {
+
+	for _, p := range ports {
+		if cfg.KubernetesPortName == "" || cfg.KubernetesPortName == p.Name {
+			return p.Port, true
+		}
+	}
+
+	return 0, false
+}
+
+/**
+ * Checks if backends take weight, priority or labels from pod labels
+ */
+func kubernetesUsesPodLabels(cfg config.DiscoveryConfig) bool {
+	return cfg.KubernetesPodWeightLabel != "" || cfg.KubernetesPodPriorityLabel != "" || cfg.KubernetesPodLabels
+}
+
+/**
+ * Lists pods selected by service with one request, instead of getting pod of every endpoint address.
+ * Returns pods by namespace and name, empty if pod labels are not used or service has no selector
+ */
+func kubernetesServicePods(ctx context.Context, client *kubernetes.Clientset, cfg config.DiscoveryConfig) (map[string]*v1.Pod, error) {
+
+	pods := map[string]*v1.Pod{}
+
+	if !kubernetesUsesPodLabels(cfg) {
+		return pods, nil
+	}
+
+	service, err := client.CoreV1().Services(cfg.KubernetesNamespace).Get(ctx, cfg.KubernetesServiceName, metav1.GetOptions{})
+	if err != nil {
+		return pods, err
+	}
+
+	// Endpoints of service without selector are managed manually, their pods are got one by one
+	if len(service.Spec.Selector) == 0 {
+		return pods, nil
+	}
+
+	list, err := client.CoreV1().Pods(cfg.KubernetesNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return pods, err
+	}
+
+	for i := range list.Items {
+		pod := &list.Items[i]
+		pods[cfg.KubernetesNamespace+"/"+pod.Name] = pod
+	}
+
+	return pods, nil
+}
+
+/**
+ * Take weight, priority and backend labels from labels of pod the address points to,
+ * taking it from listed service pods or getting it if it's not there
+ */
+func kubernetesApplyPodLabels(ctx context.Context, client *kubernetes.Clientset, cfg config.DiscoveryConfig, pods map[string]*v1.Pod, address v1.EndpointAddress, backend *core.Backend) error {
+
+	if !kubernetesUsesPodLabels(cfg) {
+		return nil
+	}
+
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return nil
+	}
+
+	namespace := address.TargetRef.Namespace
+	if namespace == "" {
+		namespace = cfg.KubernetesNamespace
+	}
+
+	pod, ok := pods[namespace+"/"+address.TargetRef.Name]
+	if !ok {
+		var err error
+		if pod, err = client.CoreV1().Pods(namespace).Get(ctx, address.TargetRef.Name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+
+	if cfg.KubernetesPodLabels {
+		backend.Labels = pod.Labels
+	}
+
+	if v, ok := pod.Labels[cfg.KubernetesPodWeightLabel]; ok {
+		if weight, err := strconv.Atoi(v); err == nil {
+			backend.Weight = weight
+		}
+	}
+
+	if v, ok := pod.Labels[cfg.KubernetesPodPriorityLabel]; ok {
+		if priority, err := strconv.Atoi(v); err == nil {
+			backend.Priority = priority
+		}
+	}
+
+	return nil
+}
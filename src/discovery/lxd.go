@@ -7,6 +7,8 @@
 package discovery
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -21,15 +23,18 @@ import (
 	lxd "github.com/lxc/lxd/client"
 	lxd_config "github.com/lxc/lxd/lxc/config"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
 )
 
 const (
-	lxdRetryWaitDuration = 2 * time.Second
-	lxdTimeout           = 5 * time.Second
+	lxdRetryWaitDuration    = 2 * time.Second
+	lxdMaxRetryWaitDuration = 1 * time.Minute
+	lxdTimeout              = 5 * time.Second
 )
 
 /**
- * Create new Discovery with LXD fetch func
+ * Create new Discovery with LXD fetch func,
+ * or watch func if lxd_events is enabled
  */
 func NewLXDDiscovery(cfg config.DiscoveryConfig) interface{} {
 
@@ -39,6 +44,12 @@ func NewLXDDiscovery(cfg config.DiscoveryConfig) interface{} {
 		cfg:   cfg,
 	}
 
+	if cfg.LXDDiscoveryConfig != nil && cfg.LXDEvents {
+		d.fetch = nil
+		d.watch = lxdWatch
+		d.maxRetryWait = lxdMaxRetryWaitDuration
+	}
+
 	return &d
 }
 
@@ -46,16 +57,132 @@ func NewLXDDiscovery(cfg config.DiscoveryConfig) interface{} {
  * Fetch backends from LXD API
  */
 func lxdFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
-	log := logging.For("lxdFetch")
 
-	/* Get an LXD client */
-	client, err := lxdBuildClient(cfg)
+	client, err := lxdConnect(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	/* Get an LXD config */
-	lxdConfig, err := lxdBuildConfig(cfg)
+	backends, _, err := lxdListBackends(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backends, nil
+}
+
+/**
+ * Fetch backends from LXD API and fetch them again on every
+ * instance lifecycle event, until events stream is disconnected
+ */
+func lxdWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("lxdWatch")
+
+	client, err := lxdConnect(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before listing, so no change is missed in between
+	listener, err := client.GetEvents()
+	if err != nil {
+		return err
+	}
+
+	defer listener.Disconnect()
+
+	changed := make(chan bool, 1)
+
+	_, err = listener.AddHandler([]string{"lifecycle"}, func(event api.Event) {
+
+		lifecycle := api.EventLifecycle{}
+		if err := json.Unmarshal(event.Metadata, &lifecycle); err == nil &&
+			!strings.HasPrefix(lifecycle.Action, "instance-") && !strings.HasPrefix(lifecycle.Action, "container-") {
+			return
+		}
+
+		select {
+		case changed <- true:
+		default:
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	disconnected := make(chan error, 1)
+	go func() {
+		disconnected <- listener.Wait()
+	}()
+
+	for {
+		backends, incomplete, err := lxdListBackends(client, cfg)
+		if err != nil {
+			return err
+		}
+
+		log.Debug("Discovered ", backends)
+
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
+
+		// Started container may get its address a bit later, so look again
+		var recheck <-chan time.Time
+		if incomplete {
+			recheck = time.After(lxdRetryWaitDuration)
+		}
+
+		select {
+		case <-changed:
+		case <-recheck:
+		case err := <-disconnected:
+			if err == nil {
+				err = errors.New("LXD events stream disconnected")
+			}
+			return err
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Connect to the first available of LXD server (or cluster members)
+ * addresses, using project if configured
+ */
+func lxdConnect(cfg config.DiscoveryConfig) (lxd.ContainerServer, error) {
+
+	log := logging.For("lxdConnect")
+
+	addresses := cfg.LXDServerAddresses
+	if cfg.LXDServerAddress != "" {
+		addresses = append([]string{cfg.LXDServerAddress}, addresses...)
+	}
+
+	var client lxd.ContainerServer
+	var err error
+
+	for _, address := range addresses {
+
+		lxdCfg := *cfg.LXDDiscoveryConfig
+		lxdCfg.LXDServerAddress = address
+
+		addressCfg := cfg
+		addressCfg.LXDDiscoveryConfig = &lxdCfg
+
+		if client, err = lxdBuildClient(addressCfg); err == nil {
+			log.Debug("Fetching containers from ", address)
+			break
+		}
+
+		log.Warn("Can't connect LXD server ", address, ": ", err)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -68,21 +195,50 @@ func lxdFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	httpClient.Timeout = utils.ParseDurationOrDefault(cfg.Timeout, lxdTimeout)
 
-	log.Debug("Fetching containers from ", lxdConfig.Remotes[cfg.LXDServerRemoteName].Addr)
+	if cfg.LXDProject != "" {
+		client = client.UseProject(cfg.LXDProject)
+	}
+
+	return client, nil
+}
+
+/**
+ * List backends of containers matching filters. Returns if some
+ * matching containers were skipped as they have no address yet
+ */
+func lxdListBackends(client lxd.ContainerServer, cfg config.DiscoveryConfig) ([]core.Backend, bool, error) {
+
+	log := logging.For("lxdFetch")
 
 	/* Create backends from response */
 	backends := []core.Backend{}
+	incomplete := false
 
 	/* Fetch containers */
 	containers, err := client.GetContainers()
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	statuses := cfg.LXDContainerStatuses
+	if len(statuses) == 0 {
+		statuses = []string{"Running"}
 	}
 
 	for _, container := range containers {
 
-		/* Ignore containers that aren't running */
-		if container.Status != "Running" {
+		/* Ignore containers not having expected status, running by default */
+		if !lxdContains(statuses, container.Status) {
+			continue
+		}
+
+		/* Ignore containers located on other cluster members */
+		if len(cfg.LXDClusterMembers) > 0 && !lxdContains(cfg.LXDClusterMembers, container.Location) {
+			continue
+		}
+
+		/* Ignore containers not having all profiles */
+		if !lxdHasProfiles(container.Profiles, cfg.LXDContainerProfiles) {
 			continue
 		}
 
@@ -124,6 +280,7 @@ func lxdFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		ip := ""
 		if ip, err = lxdDetermineContainerIP(client, container.Name, iface, cfg.LXDContainerAddressType); err != nil {
 			log.Error(fmt.Sprintf("Can't determine %s container ip address: %s. Skipping", container.Name, err))
+			incomplete = true
 			continue
 		}
 
@@ -146,7 +303,31 @@ func lxdFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		})
 	}
 
-	return &backends, nil
+	return backends, incomplete, nil
+}
+
+/**
+ * Checks if container has all profiles
+ */
+func lxdHasProfiles(containerProfiles []string, profiles []string) bool {
+	for _, profile := range profiles {
+		if !lxdContains(containerProfiles, profile) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Checks if list contains value
+ */
+func lxdContains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 /**
@@ -281,21 +462,22 @@ func lxdDetermineContainerIP(client lxd.ContainerServer, container, iface, addrT
 		}
 
 		for _, ip := range network.Addresses {
-			if ip.Family == inet {
+			/* Link-local address is not reachable from other hosts */
+			if ip.Family == inet && ip.Scope != "link" {
 				containerIP = ip.Address
 				break
 			}
 		}
 	}
 
+	if containerIP == "" {
+		return "", fmt.Errorf("Unable to determine IP address for LXD container %s", container)
+	}
+
 	/* If IPv6, format correctly */
 	if inet == "inet6" {
 		containerIP = fmt.Sprintf("[%s]", containerIP)
 	}
 
-	if containerIP == "" {
-		return "", fmt.Errorf("Unable to determine IP address for LXD container %s", container)
-	}
-
 	return containerIP, nil
 }
@@ -0,0 +1,119 @@
+/**
+ * merge.go - union of several discoveries
+ */
+
+package discovery
+
+import (
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+const (
+	mergeRetryWaitDuration = 2 * time.Second
+)
+
+/**
+ * Backends discovered by merge source
+ */
+type mergeUpdate struct {
+	source   int
+	backends []core.Backend
+}
+
+/**
+ * Create new Discovery merging backends of several discoveries
+ */
+func NewMergeDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{mergeRetryWaitDuration},
+		watch: mergeWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Run every source discovery and send union of their backends on every
+ * change. Sources retry and apply failpolicy on their own. If backend is
+ * discovered by several sources, the first listed one wins
+ */
+func mergeWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("mergeWatch")
+
+	updates := make(chan mergeUpdate)
+
+	for i, source := range cfg.MergeSources {
+
+		d := New(source.Kind, source.DiscoveryConfig)
+		d.Start()
+		defer d.Stop()
+
+		go func(i int, d *Discovery) {
+			for {
+				select {
+				case backends := <-d.Discover():
+					select {
+					case updates <- mergeUpdate{i, backends}:
+					case <-stop:
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(i, d)
+	}
+
+	discovered := make([][]core.Backend, len(cfg.MergeSources))
+
+	for {
+		select {
+		case update := <-updates:
+			discovered[update.source] = update.backends
+
+			backends := mergeBackends(cfg.MergeSources, discovered)
+
+			log.Debug("Merged ", backends)
+
+			select {
+			case out <- backends:
+			case <-stop:
+				return nil
+			}
+
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Returns union of sources backends labeled by source, without duplicates
+ */
+func mergeBackends(sources []config.MergeSource, discovered [][]core.Backend) []core.Backend {
+
+	seen := map[core.Target]bool{}
+	result := []core.Backend{}
+
+	for i, backends := range discovered {
+		for _, backend := range backends {
+
+			if seen[backend.Target] {
+				continue
+			}
+			seen[backend.Target] = true
+
+			backend.Source = sources[i].Label
+			result = append(result, backend)
+		}
+	}
+
+	return result
+}
@@ -11,10 +11,7 @@ import (
 	"../config"
 	"../core"
 	"../logging"
-	"../utils"
 	"../utils/parsers"
-	"io/ioutil"
-	"net/http"
 	"strings"
 	"time"
 )
@@ -33,10 +30,14 @@ func NewPlaintextDiscovery(cfg config.DiscoveryConfig) interface{} {
 		cfg.PlaintextRegexpPattern = parsers.DEFAULT_BACKEND_PATTERN
 	}
 
+	fetcher := &httpFetcher{}
+
 	d := Discovery{
-		opts:  DiscoveryOpts{plaintextDefaultRetryWaitDuration},
-		fetch: plaintextFetch,
-		cfg:   cfg,
+		opts: DiscoveryOpts{plaintextDefaultRetryWaitDuration},
+		fetch: func(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+			return plaintextFetch(fetcher, cfg)
+		},
+		cfg: cfg,
 	}
 
 	return &d
@@ -45,24 +46,13 @@ func NewPlaintextDiscovery(cfg config.DiscoveryConfig) interface{} {
 /**
  * Fetch / refresh backends from URL with plain text
  */
-func plaintextFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func plaintextFetch(fetcher *httpFetcher, cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	log := logging.For("plaintextFetch")
 
 	log.Info("Fetching ", cfg.PlaintextEndpoint)
 
-	// Make request
-	timeout := utils.ParseDurationOrDefault(cfg.Timeout, plaintextDefaultHttpTimeout)
-	client := http.Client{Timeout: timeout}
-	res, err := client.Get(cfg.PlaintextEndpoint)
-	if err != nil {
-		return nil, err
-	}
-
-	defer res.Body.Close()
-
-	// Read response
-	content, err := ioutil.ReadAll(res.Body)
+	content, err := fetcher.fetch(cfg, cfg.PlaintextEndpoint, plaintextDefaultHttpTimeout)
 	if err != nil {
 		return nil, err
 	}
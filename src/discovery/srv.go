@@ -7,7 +7,9 @@
 package discovery
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -22,13 +24,15 @@ const (
 	srvRetryWaitDuration  = 2 * time.Second
 	srvDefaultWaitTimeout = 5 * time.Second
 	srvUdpSize            = 4096
+	srvMinTtl             = 1 * time.Second
+	srvResolvConf         = "/etc/resolv.conf"
 )
 
 func NewSrvDiscovery(cfg config.DiscoveryConfig) interface{} {
 
 	d := Discovery{
 		opts:  DiscoveryOpts{srvRetryWaitDuration},
-		fetch: srvFetch,
+		watch: srvWatch,
 		cfg:   cfg,
 	}
 
@@ -36,55 +40,234 @@ func NewSrvDiscovery(cfg config.DiscoveryConfig) interface{} {
 }
 
 /**
- * Create new Discovery with Srv fetch func
+ * Fetch backends and refetch them every interval,
+ * or when records TTL expires if srv_respect_ttl is set
  */
-func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func srvWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	interval := utils.ParseDurationOrDefault(cfg.Interval, 0)
+
+	for {
+		backends, ttl, err := srvFetch(cfg)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
+
+		wait := interval
+		if cfg.SrvRespectTtl {
+			wait = ttl
+		}
+
+		// 0 means never refetch
+		var next <-chan time.Time
+		if wait > 0 {
+			next = time.After(wait)
+		}
+
+		select {
+		case <-next:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Fetch backends for all lookup patterns merged into one pool.
+ * Returns backends and min TTL of records
+ */
+func srvFetch(cfg config.DiscoveryConfig) ([]core.Backend, time.Duration, error) {
 
 	log := logging.For("srvFetch")
 
-	log.Info("Fetching ", cfg.SrvLookupServer, " ", cfg.SrvLookupPattern)
+	servers, err := srvServers(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	log.Info("Fetching ", servers, " ", srvPatterns(cfg))
+
+	results := []core.Backend{}
+	var minTtl uint32
+
+	for _, pattern := range srvPatterns(cfg) {
+
+		r, err := srvExchange(cfg, servers, pattern, dns.TypeSRV)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Get hosts from additional section
+		hosts := make(map[string]string)
+		for _, ans := range r.Extra {
+			switch record := ans.(type) {
+			case *dns.A:
+				hosts[record.Header().Name] = record.A.String()
+			case *dns.AAAA:
+				if _, ok := hosts[record.Header().Name]; !ok {
+					hosts[record.Header().Name] = record.AAAA.String()
+				}
+			}
+		}
+
+		found := 0
+		for _, ans := range r.Answer {
+			record, ok := ans.(*dns.SRV)
+			if !ok {
+				continue
+			}
+
+			if minTtl == 0 || record.Header().Ttl < minTtl {
+				minTtl = record.Header().Ttl
+			}
+
+			// Resolve target if additional section lacks it
+			host, ok := hosts[record.Target]
+			if !ok {
+				host, err = srvResolve(cfg, servers, record.Target)
+				if err != nil {
+					log.Warn("Skipping ", record.Target, ": ", err)
+					continue
+				}
+			}
+
+			// Weight 0 is valid in SRV, but is not allowed by balancers
+			weight := int(record.Weight)
+			if weight == 0 {
+				weight = 1
+			}
+
+			results = append(results, core.Backend{
+				Target: core.Target{
+					Host: host,
+					Port: fmt.Sprintf("%v", record.Port),
+				},
+				Priority: int(record.Priority),
+				Weight:   weight,
+				Stats: core.BackendStats{
+					Live: true,
+				},
+				Sni: strings.TrimRight(record.Target, "."),
+			})
+			found++
+		}
+
+		if found == 0 {
+			log.Warn("Empty response from ", servers, " ", pattern)
+		}
+	}
+
+	ttl := time.Duration(minTtl) * time.Second
+	if ttl < srvMinTtl {
+		ttl = srvMinTtl
+	}
+
+	return results, ttl, nil
+}
+
+/**
+ * Resolve A (or AAAA, if no A) record of host
+ */
+func srvResolve(cfg config.DiscoveryConfig, servers []string, host string) (string, error) {
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+
+		r, err := srvExchange(cfg, servers, host, qtype)
+		if err != nil {
+			return "", err
+		}
+
+		for _, ans := range r.Answer {
+			switch record := ans.(type) {
+			case *dns.A:
+				return record.A.String(), nil
+			case *dns.AAAA:
+				return record.AAAA.String(), nil
+			}
+		}
+	}
+
+	return "", errors.New("No A or AAAA records")
+}
+
+/**
+ * Query servers in order until one responds, retrying
+ * over tcp if udp response is truncated
+ */
+func srvExchange(cfg config.DiscoveryConfig, servers []string, name string, qtype uint16) (*dns.Msg, error) {
 
 	timeout := utils.ParseDurationOrDefault(cfg.Timeout, srvDefaultWaitTimeout)
-	c := dns.Client{Net: cfg.SrvDnsProtocol, Timeout: timeout}
-	m := dns.Msg{}
 
-	m.SetQuestion(cfg.SrvLookupPattern, dns.TypeSRV)
+	m := dns.Msg{}
+	m.SetQuestion(dns.Fqdn(name), qtype)
 	m.SetEdns0(srvUdpSize, true)
-	r, _, err := c.Exchange(&m, cfg.SrvLookupServer)
 
+	var err error
+	for _, server := range servers {
+
+		c := dns.Client{Net: cfg.SrvDnsProtocol, Timeout: timeout}
+
+		var r *dns.Msg
+		r, _, err = c.Exchange(&m, server)
+		if err == nil && r.Truncated && c.Net != "tcp" {
+			c.Net = "tcp"
+			r, _, err = c.Exchange(&m, server)
+		}
+
+		if err == nil {
+			return r, nil
+		}
+	}
+
+	return nil, err
+}
+
+/**
+ * Returns lookup servers, using system resolvers if none configured
+ */
+func srvServers(cfg config.DiscoveryConfig) ([]string, error) {
+
+	servers := []string{}
+	if cfg.SrvLookupServer != "" {
+		servers = append(servers, cfg.SrvLookupServer)
+	}
+	servers = append(servers, cfg.SrvLookupServers...)
+
+	if len(servers) > 0 {
+		return servers, nil
+	}
+
+	clientCfg, err := dns.ClientConfigFromFile(srvResolvConf)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(r.Answer) == 0 {
-		log.Warn("Empty response from", cfg.SrvLookupServer, cfg.SrvLookupPattern)
-		return &[]core.Backend{}, nil
+	for _, s := range clientCfg.Servers {
+		servers = append(servers, net.JoinHostPort(s, clientCfg.Port))
 	}
 
-	// Get hosts from A section
-	hosts := make(map[string]string)
-	for _, ans := range r.Extra {
-		record := ans.(*dns.A)
-		hosts[record.Header().Name] = record.A.String()
+	if len(servers) == 0 {
+		return nil, errors.New("No lookup servers configured or found in " + srvResolvConf)
 	}
 
-	// Results for combined SRV + A
-	results := []core.Backend{}
-	for _, ans := range r.Answer {
-		record := ans.(*dns.SRV)
-		results = append(results, core.Backend{
-			Target: core.Target{
-				Host: hosts[record.Target],
-				Port: fmt.Sprintf("%v", record.Port),
-			},
-			Priority: int(record.Priority),
-			Weight:   int(record.Weight),
-			Stats: core.BackendStats{
-				Live: true,
-			},
-			Sni: strings.TrimRight(record.Target, "."),
-		})
+	return servers, nil
+}
+
+/**
+ * Returns all lookup patterns
+ */
+func srvPatterns(cfg config.DiscoveryConfig) []string {
+
+	patterns := []string{}
+	if cfg.SrvLookupPattern != "" {
+		patterns = append(patterns, cfg.SrvLookupPattern)
 	}
 
-	return &results, nil
+	return append(patterns, cfg.SrvLookupPatterns...)
 }
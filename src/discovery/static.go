@@ -7,12 +7,26 @@
 package discovery
 
 import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
 	"../config"
 	"../core"
 	"../logging"
+	"../utils"
 	"../utils/parsers"
+	"github.com/miekg/dns"
 )
 
+/**
+ * Time addresses of host names not found in dns, but resolved by system
+ * resolver (i.e. from /etc/hosts), are used until they are resolved again
+ */
+const staticHostsTtl = 30 * time.Second
+
 /**
  * Creates new static discovery
  */
@@ -24,6 +38,12 @@ func NewStaticDiscovery(cfg config.DiscoveryConfig) interface{} {
 		fetch: staticFetch,
 	}
 
+	if cfg.StaticDiscoveryConfig != nil && (cfg.StaticResolve || cfg.StaticExpand) {
+		d.opts = DiscoveryOpts{srvRetryWaitDuration}
+		d.fetch = nil
+		d.watch = staticWatch
+	}
+
 	return &d
 }
 
@@ -46,3 +66,210 @@ func staticFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	return &backends, nil
 }
+
+/**
+ * Resolves backends host names to addresses and resolves every name again when its
+ * records TTL expires. Names failed to resolve keep their last addresses
+ */
+func staticWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("discovery/static")
+
+	// Lookup servers and dns protocol are the same as of srv discovery
+	if cfg.SrvDiscoveryConfig == nil {
+		cfg.SrvDiscoveryConfig = &config.SrvDiscoveryConfig{}
+	}
+
+	// Without lookup servers names are resolved by system resolver only
+	servers, err := srvServers(cfg)
+	if err != nil {
+		log.Warn(err, ", using system resolver without records TTL")
+	}
+
+	backends, err := staticFetch(cfg)
+	if err != nil {
+		return err
+	}
+
+	/* Last resolved addresses, address used if not expanding, and resolving time of every host name */
+	resolved := map[string][]string{}
+	chosen := map[string]string{}
+	expires := map[string]time.Time{}
+
+	for {
+		now := time.Now()
+
+		for _, backend := range *backends {
+
+			host := backend.Host
+			if backend.IsUnix() || net.ParseIP(host) != nil {
+				continue
+			}
+
+			if expire, ok := expires[host]; ok && now.Before(expire) {
+				continue
+			}
+
+			addresses, ttl, err := staticResolve(cfg, servers, host)
+			if err != nil {
+				log.Warn("Can't resolve ", host, ", keeping last addresses ", resolved[host], ": ", err)
+				ttl = srvRetryWaitDuration
+			} else {
+				resolved[host] = addresses
+			}
+
+			expires[host] = now.Add(ttl)
+		}
+
+		results := []core.Backend{}
+
+		for _, backend := range *backends {
+
+			addresses, ok := resolved[backend.Host]
+			if !ok {
+				// Not a host name, or it was never resolved
+				if _, isName := expires[backend.Host]; !isName {
+					results = append(results, backend)
+				}
+				continue
+			}
+
+			// Keep using the same address while it's resolved, so backend does not flap
+			if !cfg.StaticExpand {
+				address := addresses[0]
+				for _, a := range addresses {
+					if a == chosen[backend.Host] {
+						address = a
+					}
+				}
+				chosen[backend.Host] = address
+				addresses = []string{address}
+			}
+
+			for _, address := range addresses {
+				result := backend
+				result.Host = address
+				result.Hostname = backend.Host
+
+				// Names resolved to the same address give the same backend
+				duplicate := false
+				for _, other := range results {
+					if other.EqualTo(result) {
+						duplicate = true
+					}
+				}
+
+				if !duplicate {
+					results = append(results, result)
+				}
+			}
+		}
+
+		select {
+		case out <- results:
+		case <-stop:
+			return nil
+		}
+
+		// Wait for the first name to expire, forever if there are no names
+		var first time.Time
+		for _, expire := range expires {
+			if first.IsZero() || expire.Before(first) {
+				first = expire
+			}
+		}
+
+		var next <-chan time.Time
+		if !first.IsZero() {
+			next = time.After(time.Until(first))
+		}
+
+		select {
+		case <-next:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Resolves A records of host, or AAAA if it has no A ones, with records min TTL.
+ * Names not found in dns are resolved by system resolver
+ */
+func staticResolve(cfg config.DiscoveryConfig, servers []string, host string) ([]string, time.Duration, error) {
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+
+		if len(servers) == 0 {
+			break
+		}
+
+		r, err := srvExchange(cfg, servers, host, qtype)
+		if err != nil {
+			break
+		}
+
+		addresses := []string{}
+		var minTtl uint32
+
+		for _, ans := range r.Answer {
+			switch record := ans.(type) {
+			case *dns.A:
+				addresses = append(addresses, record.A.String())
+			case *dns.AAAA:
+				addresses = append(addresses, record.AAAA.String())
+			default:
+				// CNAMEs are followed by resolver, their TTL counts too
+			}
+
+			if minTtl == 0 || ans.Header().Ttl < minTtl {
+				minTtl = ans.Header().Ttl
+			}
+		}
+
+		if len(addresses) > 0 {
+			sort.Strings(addresses)
+
+			ttl := time.Duration(minTtl) * time.Second
+			if ttl < srvMinTtl {
+				ttl = srvMinTtl
+			}
+
+			return addresses, ttl, nil
+		}
+	}
+
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, 0)
+	if timeout <= 0 {
+		timeout = srvDefaultWaitTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	addresses := []string{}
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			addresses = append(addresses, ip.IP.String())
+		}
+	}
+
+	if len(addresses) == 0 {
+		for _, ip := range ips {
+			addresses = append(addresses, ip.IP.String())
+		}
+	}
+
+	if len(addresses) == 0 {
+		return nil, 0, errors.New("No A or AAAA records")
+	}
+
+	sort.Strings(addresses)
+
+	return addresses, staticHostsTtl, nil
+}
@@ -0,0 +1,237 @@
+/**
+ * zookeeper.go - ZooKeeper znode children watch discovery implementation
+ */
+
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../utils"
+	"../utils/parsers"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	zookeeperRetryWaitDuration    = 1 * time.Second
+	zookeeperMaxRetryWaitDuration = 1 * time.Minute
+	zookeeperTimeout              = 10 * time.Second
+)
+
+/**
+ * Backend value in znode in json form. Both own and
+ * Curator service discovery (address, port, sslPort) fields are supported
+ */
+type zookeeperBackendValue struct {
+	Host     string      `json:"host"`
+	Address  string      `json:"address"`
+	Port     interface{} `json:"port"`
+	SslPort  interface{} `json:"sslPort"`
+	Weight   int         `json:"weight"`
+	Priority int         `json:"priority"`
+	Sni      string      `json:"sni"`
+
+	MaxConnections int               `json:"max_connections"`
+	Labels         map[string]string `json:"labels"`
+}
+
+/**
+ * Logger passing zk client logs to our logging
+ */
+type zookeeperLogger struct{}
+
+func (zookeeperLogger) Printf(format string, args ...interface{}) {
+	logging.For("zookeeper").Debugf(format, args...)
+}
+
+/**
+ * Create new Discovery with ZooKeeper watch func
+ */
+func NewZookeeperDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:         DiscoveryOpts{zookeeperRetryWaitDuration},
+		maxRetryWait: zookeeperMaxRetryWaitDuration,
+		watch:        zookeeperWatch,
+		cfg:          cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Establish session, get children of znode path and watch for it's changes
+ * until session expires
+ */
+func zookeeperWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("zookeeperWatch")
+
+	log.Info("Watching ", cfg.ZookeeperServers, " ", cfg.ZookeeperPath)
+
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, zookeeperTimeout)
+	sessionTimeout := utils.ParseDurationOrDefault(cfg.ZookeeperSessionTimeout, zookeeperTimeout)
+
+	conn, events, err := zk.Connect(cfg.ZookeeperServers, sessionTimeout, zk.WithLogger(zookeeperLogger{}))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if cfg.ZookeeperUsername != "" {
+		if err := conn.AddAuth("digest", []byte(cfg.ZookeeperUsername+":"+cfg.ZookeeperPassword)); err != nil {
+			return err
+		}
+	}
+
+	/* Wait for session */
+
+	deadline := time.After(timeout)
+
+	for established := false; !established; {
+		select {
+		case event := <-events:
+			established = event.State == zk.StateHasSession
+		case <-deadline:
+			return errors.New("zookeeper session was not established in " + timeout.String())
+		case <-stop:
+			return nil
+		}
+	}
+
+	/* Get children and watch them until session expires */
+
+	for {
+		children, _, watch, err := conn.ChildrenW(cfg.ZookeeperPath)
+		if err != nil {
+			return err
+		}
+
+		sort.Strings(children)
+
+		backends := []core.Backend{}
+		for _, child := range children {
+
+			data, _, err := conn.Get(path.Join(cfg.ZookeeperPath, child))
+			if err == zk.ErrNoNode {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			backend, err := zookeeperParseBackend(child, data)
+			if err != nil {
+				log.Warn("Can't parse znode ", child, ": ", err)
+				continue
+			}
+
+			backends = append(backends, *backend)
+		}
+
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
+
+		if err := zookeeperWait(watch, events, stop); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+/**
+ * Wait until watch fires, session expires or watch is stopped
+ */
+func zookeeperWait(watch <-chan zk.Event, events <-chan zk.Event, stop <-chan bool) error {
+
+	for {
+		select {
+		case event := <-watch:
+			return event.Err
+		case event := <-events:
+			if event.State == zk.StateExpired {
+				return errors.New("zookeeper session expired")
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Parse znode data to backend.
+ * Data is either "<host>:<port> [weight=<int>] [priority=<int>] [sni=<string>] [max_connections=<int>]"
+ * or json object, or empty meaning znode name is "<host>:<port>"
+ */
+func zookeeperParseBackend(name string, data []byte) (*core.Backend, error) {
+
+	value := strings.TrimSpace(string(data))
+
+	if value == "" {
+		return parsers.ParseBackendDefault(name)
+	}
+
+	if !strings.HasPrefix(value, "{") {
+		return parsers.ParseBackendDefault(value)
+	}
+
+	v := zookeeperBackendValue{
+		Weight:   1,
+		Priority: 1,
+	}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	host := v.Host
+	if host == "" {
+		host = v.Address
+	}
+
+	port := v.Port
+	if port == nil {
+		port = v.SslPort
+	}
+
+	if host == "" || port == nil {
+		return nil, errors.New("host (or address) and port (or sslPort) should be specified")
+	}
+
+	return &core.Backend{
+		Target: core.Target{
+			Host: host,
+			Port: fmt.Sprintf("%v", port),
+		},
+		Priority: v.Priority,
+		Weight:   v.Weight,
+		Sni:      v.Sni,
+
+		MaxConnections: v.MaxConnections,
+		Labels:         v.Labels,
+
+		Stats: core.BackendStats{
+			Live: true,
+		},
+	}, nil
+}
@@ -0,0 +1,341 @@
+/**
+ * events.go - backends and servers events pushed to sinks
+ */
+
+package events
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+/**
+ * Event types
+ */
+const (
+	BACKEND_UP      = "backend_up"
+	BACKEND_DOWN    = "backend_down"
+	BACKEND_ADDED   = "backend_added"
+	BACKEND_REMOVED = "backend_removed"
+	BACKEND_DRAINED = "backend_drained"
+	BACKEND_ENABLED = "backend_enabled"
+	SERVER_STARTED  = "server_started"
+	SERVER_STOPPED  = "server_stopped"
+	SERVER_DRAINING = "server_draining"
+)
+
+var types = []string{
+	BACKEND_UP, BACKEND_DOWN, BACKEND_ADDED, BACKEND_REMOVED, BACKEND_DRAINED, BACKEND_ENABLED,
+	SERVER_STARTED, SERVER_STOPPED, SERVER_DRAINING,
+}
+
+const (
+	/* Default events waiting to be sent per sink */
+	DEFAULT_QUEUE_SIZE = 1024
+
+	/* Max time to send queued events on stop */
+	STOP_TIMEOUT = 10 * time.Second
+)
+
+/**
+ * Process-wide events bus configured with [events] section
+ */
+var Global = &Bus{}
+
+/**
+ * Event of backend or server
+ */
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	/* Hostname of gobetween instance */
+	Host string `json:"host"`
+
+	/* Server name, or stats name of its backends pool for backends events, i.e. <server>/<route> */
+	Server string `json:"server"`
+
+	/* Backend of backends events */
+	Backend *core.Target `json:"backend,omitempty"`
+}
+
+/**
+ * Sink events are sent to
+ */
+type Sink interface {
+
+	/**
+	 * Sends event, returns error if it's not sent
+	 */
+	Send(event Event) error
+
+	/**
+	 * Releases sink resources, i.e. connection
+	 */
+	Close()
+
+	/**
+	 * Returns sink description for logging
+	 */
+	String() string
+}
+
+/**
+ * Bus dispatches published events to sinks, every sink
+ * having its own queue so slow one does not delay others
+ */
+type Bus struct {
+	sync.RWMutex
+
+	cfg         config.EventsConfig
+	configured  bool
+	dispatchers []*dispatcher
+}
+
+/**
+ * Sink with queue of events waiting to be sent
+ */
+type dispatcher struct {
+
+	/* Count of events dropped since queue was full, first to be 64-bit aligned */
+	dropped uint64
+
+	sink Sink
+
+	/* Types of events sent to sink, nil means all */
+	types map[string]bool
+
+	queue   chan Event
+	stopped chan bool
+}
+
+/**
+ * Applies configuration, replacing sinks if it's changed.
+ * Events queued to replaced sinks are sent in background
+ */
+func (this *Bus) Configure(cfg config.EventsConfig) error {
+
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.configured && reflect.DeepEqual(cfg, this.cfg) {
+		return nil
+	}
+
+	queueSize := cfg.QueueSize
+	if queueSize == 0 {
+		queueSize = DEFAULT_QUEUE_SIZE
+	}
+
+	dispatchers := []*dispatcher{}
+	for _, c := range cfg.Webhooks {
+		dispatchers = append(dispatchers, newDispatcher(newWebhook(c), c.Events, queueSize))
+	}
+	for _, c := range cfg.Nats {
+		dispatchers = append(dispatchers, newDispatcher(newNats(c), c.Events, queueSize))
+	}
+
+	for _, d := range this.dispatchers {
+		go d.stop(STOP_TIMEOUT)
+	}
+
+	if len(dispatchers) > 0 {
+		logging.For("events").Info("Sending events to ", len(dispatchers), " sinks")
+	}
+
+	this.cfg = cfg
+	this.configured = true
+	this.dispatchers = dispatchers
+
+	return nil
+}
+
+/**
+ * Queues event to every sink it's configured for, dropping it if sink queue is full
+ */
+func (this *Bus) Publish(event Event) {
+
+	this.RLock()
+	defer this.RUnlock()
+
+	if len(this.dispatchers) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if event.Host == "" {
+		event.Host, _ = os.Hostname()
+	}
+
+	for _, d := range this.dispatchers {
+		d.add(event)
+	}
+}
+
+/**
+ * Sends queued events, up to STOP_TIMEOUT, and stops sending
+ */
+func (this *Bus) Stop() {
+
+	this.Lock()
+	dispatchers := this.dispatchers
+	this.dispatchers = nil
+	this.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range dispatchers {
+		wg.Add(1)
+		go func(d *dispatcher) {
+			defer wg.Done()
+			d.stop(STOP_TIMEOUT)
+		}(d)
+	}
+	wg.Wait()
+}
+
+/**
+ * Creates dispatcher and starts sending events to sink
+ */
+func newDispatcher(sink Sink, filter []string, queueSize int) *dispatcher {
+
+	this := &dispatcher{
+		sink:    sink,
+		queue:   make(chan Event, queueSize),
+		stopped: make(chan bool),
+	}
+
+	if len(filter) > 0 {
+		this.types = map[string]bool{}
+		for _, t := range filter {
+			this.types[t] = true
+		}
+	}
+
+	go this.loop()
+
+	return this
+}
+
+/**
+ * Queues event if sink is configured for its type
+ */
+func (this *dispatcher) add(event Event) {
+
+	if this.types != nil && !this.types[event.Type] {
+		return
+	}
+
+	select {
+	case this.queue <- event:
+	default:
+		if atomic.AddUint64(&this.dropped, 1) == 1 {
+			logging.For("events").Warn("Events queue of ", this.sink, " is full, dropping events")
+		}
+	}
+}
+
+/**
+ * Sends queued events until queue is closed
+ */
+func (this *dispatcher) loop() {
+
+	log := logging.For("events")
+
+	defer close(this.stopped)
+	defer this.sink.Close()
+
+	for event := range this.queue {
+		if err := this.sink.Send(event); err != nil {
+			log.Error("Failed to send ", event.Type, " event to ", this.sink, ": ", err)
+		}
+
+		if dropped := atomic.SwapUint64(&this.dropped, 0); dropped > 0 {
+			log.Warn("Dropped ", dropped, " events of ", this.sink, ", queue was full")
+		}
+	}
+}
+
+/**
+ * Stops accepting events and waits up to timeout until queued ones are sent
+ */
+func (this *dispatcher) stop(timeout time.Duration) {
+
+	close(this.queue)
+
+	select {
+	case <-this.stopped:
+	case <-time.After(timeout):
+		logging.For("events").Warn("Timeout sending queued events to ", this.sink)
+	}
+}
+
+/**
+ * Validates events configuration
+ */
+func Validate(cfg config.EventsConfig) error {
+
+	if cfg.QueueSize < 0 {
+		return errors.New("queue_size should not be negative")
+	}
+
+	check := func(filter []string, timeout string) error {
+		for _, t := range filter {
+			if !isType(t) {
+				return errors.New("Unknown event type " + t)
+			}
+		}
+		if timeout != "" {
+			if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+				return errors.New("timeout should be positive duration")
+			}
+		}
+		return nil
+	}
+
+	for _, c := range cfg.Webhooks {
+		if err := validateWebhook(c); err != nil {
+			return err
+		}
+		if err := check(c.Events, c.Timeout); err != nil {
+			return errors.New("webhook " + c.Url + ": " + err.Error())
+		}
+	}
+
+	for _, c := range cfg.Nats {
+		if err := validateNats(c); err != nil {
+			return err
+		}
+		if err := check(c.Events, c.Timeout); err != nil {
+			return errors.New("nats " + c.Address + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Checks if event type is known
+ */
+func isType(t string) bool {
+	for _, known := range types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+/**
+ * nats.go - events sink publishing events to NATS server
+ */
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../config"
+	"../logging"
+	"../utils"
+)
+
+const (
+	/* Default subject, event is published to <subject>.<event type> */
+	DEFAULT_NATS_SUBJECT = "gobetween.events"
+
+	/* Default connect and write timeout */
+	DEFAULT_NATS_TIMEOUT = 5 * time.Second
+)
+
+/**
+ * NATS sink, speaking NATS client text protocol over plain tcp
+ */
+type nats struct {
+	sync.Mutex
+
+	cfg     config.NatsSinkConfig
+	subject string
+	timeout time.Duration
+
+	/* Current connection, nil if not connected or broken */
+	conn net.Conn
+}
+
+/**
+ * NATS INFO fields sink cares about
+ */
+type natsInfo struct {
+	TlsRequired bool `json:"tls_required"`
+}
+
+/**
+ * Creates NATS sink. Connection is established on first event
+ */
+func newNats(cfg config.NatsSinkConfig) *nats {
+
+	this := &nats{
+		cfg:     cfg,
+		subject: cfg.Subject,
+		timeout: DEFAULT_NATS_TIMEOUT,
+	}
+
+	if this.subject == "" {
+		this.subject = DEFAULT_NATS_SUBJECT
+	}
+
+	if cfg.Timeout != "" {
+		this.timeout = utils.ParseDurationOrDefault(cfg.Timeout, this.timeout)
+	}
+
+	return this
+}
+
+/**
+ * Publishes event, reconnecting once if connection is broken
+ */
+func (this *nats) Send(event Event) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := make([]byte, 0, len(payload)+64)
+	msg = append(msg, "PUB "+this.subject+"."+event.Type+" "+strconv.Itoa(len(payload))+"\r\n"...)
+	msg = append(msg, payload...)
+	msg = append(msg, "\r\n"...)
+
+	this.Lock()
+	defer this.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err = this.write(msg); err == nil || attempt >= 1 {
+			return err
+		}
+	}
+}
+
+/**
+ * Writes to connection, connecting if needed. Should be called with lock held
+ */
+func (this *nats) write(msg []byte) error {
+
+	if this.conn == nil {
+		conn, reader, err := this.connect()
+		if err != nil {
+			return err
+		}
+		this.conn = conn
+		go this.read(conn, reader)
+	}
+
+	this.conn.SetWriteDeadline(time.Now().Add(this.timeout))
+	if _, err := this.conn.Write(msg); err != nil {
+		this.conn.Close()
+		this.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+/**
+ * Connects to server, sends CONNECT and waits for PONG to its PING
+ */
+func (this *nats) connect() (net.Conn, *bufio.Reader, error) {
+
+	conn, err := net.DialTimeout("tcp", this.cfg.Address, this.timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(this.timeout))
+
+	reader := bufio.NewReader(conn)
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, errors.New("Unexpected server greeting " + strings.TrimSpace(line))
+	}
+
+	info := natsInfo{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line[5:])), &info); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if info.TlsRequired {
+		conn.Close()
+		return nil, nil, errors.New("Server requires tls, which is not supported")
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "gobetween",
+		"lang":     "go",
+	}
+
+	if this.cfg.Username != "" {
+		options["user"] = this.cfg.Username
+		options["pass"] = this.cfg.Password
+	}
+
+	if this.cfg.Token != "" {
+		options["auth_token"] = this.cfg.Token
+	}
+
+	connect, _ := json.Marshal(options)
+
+	if _, err := conn.Write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n")); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line == "PONG" {
+			break
+		}
+
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, nil, errors.New(line)
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return conn, reader, nil
+}
+
+/**
+ * Reads server messages answering PINGs, until connection is closed
+ */
+func (this *nats) read(conn net.Conn, reader *bufio.Reader) {
+
+	log := logging.For("events")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PING":
+			this.Lock()
+			if this.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(this.timeout))
+				conn.Write([]byte("PONG\r\n"))
+			}
+			this.Unlock()
+
+		case strings.HasPrefix(line, "-ERR"):
+			log.Error(this, ": ", line)
+		}
+	}
+
+	this.Lock()
+	if this.conn == conn {
+		conn.Close()
+		this.conn = nil
+	}
+	this.Unlock()
+}
+
+func (this *nats) Close() {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.conn != nil {
+		this.conn.Close()
+		this.conn = nil
+	}
+}
+
+func (this *nats) String() string {
+	return "nats " + this.cfg.Address
+}
+
+/**
+ * Validates NATS sink configuration
+ */
+func validateNats(cfg config.NatsSinkConfig) error {
+
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return errors.New("nats address should be host:port")
+	}
+
+	if strings.ContainsAny(cfg.Subject, " \t\r\n*>") || strings.HasSuffix(cfg.Subject, ".") {
+		return errors.New("nats " + cfg.Address + ": subject is not valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/**
+ * webhook.go - events sink POSTing events as JSON
+ */
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"../config"
+	"../utils"
+)
+
+const (
+	/* Default webhook request timeout */
+	DEFAULT_WEBHOOK_TIMEOUT = 5 * time.Second
+
+	/* Delay before first retry, doubled for every next one */
+	webhookRetryDelay = 1 * time.Second
+)
+
+/**
+ * Webhook sink
+ */
+type webhook struct {
+	url     string
+	headers map[string]string
+	retries int
+	client  *http.Client
+}
+
+/**
+ * Creates webhook sink
+ */
+func newWebhook(cfg config.WebhookSinkConfig) *webhook {
+
+	timeout := DEFAULT_WEBHOOK_TIMEOUT
+	if cfg.Timeout != "" {
+		timeout = utils.ParseDurationOrDefault(cfg.Timeout, timeout)
+	}
+
+	return &webhook{
+		url:     cfg.Url,
+		headers: cfg.Headers,
+		retries: cfg.Retries,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+/**
+ * POSTs event, retrying on error or non 2xx response
+ */
+func (this *webhook) Send(event Event) error {
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+
+	for attempt := 0; ; attempt++ {
+		if err = this.post(body); err == nil || attempt >= this.retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+/**
+ * Sends one request
+ */
+func (this *webhook) post(body []byte) error {
+
+	req, err := http.NewRequest("POST", this.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range this.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New(resp.Status + " " + strings.TrimSpace(string(body)))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+func (this *webhook) Close() {}
+
+func (this *webhook) String() string {
+	return "webhook " + this.url
+}
+
+/**
+ * Validates webhook sink configuration
+ */
+func validateWebhook(cfg config.WebhookSinkConfig) error {
+
+	u, err := url.Parse(cfg.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url should be http(s) url")
+	}
+
+	if cfg.Retries < 0 {
+		return errors.New("webhook " + cfg.Url + ": retries should not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,698 @@
+/**
+ * ha.go - active / passive high availability with floating virtual ips
+ */
+
+package ha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"../config"
+	"../logging"
+	"../utils"
+	"../utils/upgrade"
+)
+
+const (
+	/* Default udp bind adverts are received on */
+	DEFAULT_BIND = "0.0.0.0:7947"
+
+	/* Default priority, 1 to 254 */
+	DEFAULT_PRIORITY = 100
+
+	/* Default interval master sends adverts */
+	DEFAULT_ADVERT_INTERVAL = 1 * time.Second
+
+	/* Max difference of advert time and local time */
+	maxClockSkew = 1 * time.Minute
+
+	/* Gratuitous ARP announcements sent once virtual ips are taken, and interval of them */
+	announceCount    = 3
+	announceInterval = 200 * time.Millisecond
+)
+
+/**
+ * Node states
+ */
+const (
+	STATE_BACKUP = "backup"
+	STATE_MASTER = "master"
+
+	/* App is not ready, node does not take virtual ips */
+	STATE_FAULT = "fault"
+)
+
+/* Running node, nil if disabled */
+var current *node
+
+/**
+ * Returns if app is ready to take traffic, and if it's still starting
+ */
+type Readiness func() (ready bool, starting bool)
+
+/**
+ * Advert master sends to peers every interval. Priority 0
+ * means master releases virtual ips, so backup takes them at once
+ */
+type advert struct {
+	Node     string `json:"node"`
+	Priority int    `json:"priority"`
+
+	/* Unix nanoseconds, increasing, so captured adverts can't be replayed */
+	Time int64 `json:"time"`
+}
+
+/**
+ * High availability status
+ */
+type Status struct {
+	Enabled    bool      `json:"enabled"`
+	Node       string    `json:"node"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Priority   int       `json:"priority"`
+	Master     string    `json:"master"`
+	VirtualIps []string  `json:"virtual_ips"`
+}
+
+/**
+ * Node holding virtual ips while it's master. Master is elected like with VRRP: node
+ * with the highest priority, or the highest name if priorities are equal, sends adverts
+ * every interval. Backup takes over once there are no adverts for 3 intervals and skew
+ * decreasing with priority, or at once when master sends priority 0. Node that is not ready
+ * (see api GET /readyz) releases virtual ips and does not take them until it's ready again
+ */
+type node struct {
+	sync.Mutex
+
+	name     string
+	priority int
+	preempt  bool
+	interval time.Duration
+	secret   []byte
+
+	iface *net.Interface
+	vips  []*net.IPNet
+
+	readiness Readiness
+
+	conn  *net.UDPConn
+	peers []*net.UDPAddr
+
+	state  string
+	since  time.Time
+	master string
+
+	/* Priority of master in its last advert */
+	masterPriority int
+
+	adverts chan advert
+	stop    chan bool
+	stopped chan bool
+}
+
+/**
+ * Starts node if enabled. Node starts as master if virtual
+ * ips are already on interface, i.e. after binary upgrade
+ */
+func Start(cfg config.HaConfig, readiness Readiness) {
+
+	log := logging.For("ha")
+
+	if !cfg.Enabled {
+		return
+	}
+
+	if err := Validate(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	this := &node{
+		name:      cfg.NodeName,
+		priority:  cfg.Priority,
+		preempt:   cfg.Preempt == nil || *cfg.Preempt,
+		interval:  DEFAULT_ADVERT_INTERVAL,
+		secret:    []byte(cfg.Secret),
+		readiness: readiness,
+		state:     STATE_BACKUP,
+		since:     time.Now(),
+		adverts:   make(chan advert, 16),
+		stop:      make(chan bool),
+		stopped:   make(chan bool),
+	}
+
+	if this.name == "" {
+		this.name, _ = os.Hostname()
+	}
+
+	if this.priority == 0 {
+		this.priority = DEFAULT_PRIORITY
+	}
+
+	if cfg.AdvertInterval != "" {
+		this.interval = utils.ParseDurationOrDefault(cfg.AdvertInterval, this.interval)
+	}
+
+	var err error
+	if this.iface, err = net.InterfaceByName(cfg.Interface); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, v := range cfg.VirtualIps {
+		this.vips = append(this.vips, parseVip(v))
+	}
+
+	for _, p := range cfg.Peers {
+		addr, err := net.ResolveUDPAddr("udp", p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		this.peers = append(this.peers, addr)
+	}
+
+	bind := cfg.Bind
+	if bind == "" {
+		bind = DEFAULT_BIND
+	}
+
+	// Socket is taken from old process on upgrade
+	if this.conn = upgrade.UDPConn(bind); this.conn == nil {
+		addr, err := net.ResolveUDPAddr("udp", bind)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if this.conn, err = net.ListenUDP("udp", addr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	upgrade.RegisterUDPConn(bind, this.conn)
+
+	if this.holdsVips() {
+		log.Info("Virtual ips are on ", this.iface.Name, ", starting as master")
+		this.state = STATE_MASTER
+		this.master = this.name
+	}
+
+	log.Info("Starting node ", this.name, " with priority ", this.priority, " on ", bind, ", ", this.state)
+
+	current = this
+
+	go this.receive()
+	go this.loop()
+}
+
+/**
+ * Stops node keeping virtual ips, so new process takes them over on upgrade
+ */
+func Stop() {
+	if current != nil {
+		current.shutdown()
+		current.conn.Close()
+	}
+}
+
+/**
+ * Stops node releasing virtual ips if it holds them, so peer takes them at once
+ */
+func Release() {
+
+	if current == nil {
+		return
+	}
+
+	this := current
+	this.shutdown()
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.state == STATE_MASTER {
+		logging.For("ha").Info("Releasing virtual ips")
+		this.advertise(0)
+		this.removeVips()
+		this.setState(STATE_BACKUP)
+	}
+
+	this.conn.Close()
+}
+
+/**
+ * Returns current high availability status
+ */
+func GetStatus() Status {
+
+	if current == nil {
+		return Status{VirtualIps: []string{}}
+	}
+
+	this := current
+
+	this.Lock()
+	defer this.Unlock()
+
+	status := Status{
+		Enabled:    true,
+		Node:       this.name,
+		State:      this.state,
+		Since:      this.since,
+		Priority:   this.priority,
+		Master:     this.master,
+		VirtualIps: []string{},
+	}
+
+	for _, v := range this.vips {
+		status.VirtualIps = append(status.VirtualIps, v.String())
+	}
+
+	return status
+}
+
+/**
+ * Stops sending and handling adverts
+ */
+func (this *node) shutdown() {
+
+	select {
+	case <-this.stop:
+		return
+	default:
+	}
+
+	close(this.stop)
+	<-this.stopped
+}
+
+/**
+ * Handles adverts and timers until stopped
+ */
+func (this *node) loop() {
+
+	defer close(this.stopped)
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	masterDown := time.NewTimer(this.masterDownInterval())
+	defer masterDown.Stop()
+
+	this.Lock()
+	if this.state == STATE_MASTER {
+		this.takeVips()
+	}
+	this.Unlock()
+
+	for {
+		select {
+		case <-this.stop:
+			return
+
+		case a := <-this.adverts:
+			this.Lock()
+			this.handleAdvert(a, masterDown)
+			this.Unlock()
+
+		case <-ticker.C:
+			this.Lock()
+			this.tick()
+			this.Unlock()
+
+		case <-masterDown.C:
+			this.Lock()
+			this.handleMasterDown(masterDown)
+			this.Unlock()
+		}
+	}
+}
+
+/**
+ * Sends advert if master, and follows app readiness
+ */
+func (this *node) tick() {
+
+	log := logging.For("ha")
+
+	ready, starting := this.readiness()
+	fault := !ready && !starting
+
+	switch this.state {
+
+	case STATE_MASTER:
+		if fault {
+			log.Warn("Not ready, releasing virtual ips")
+			this.advertise(0)
+			this.removeVips()
+			this.master = ""
+			this.setState(STATE_FAULT)
+			return
+		}
+		this.advertise(this.priority)
+
+	case STATE_BACKUP:
+		if fault {
+			log.Warn("Not ready, won't take virtual ips")
+			this.setState(STATE_FAULT)
+		}
+
+	case STATE_FAULT:
+		if ready {
+			log.Info("Ready, backup")
+			this.setState(STATE_BACKUP)
+		}
+	}
+}
+
+/**
+ * Handles advert of peer
+ */
+func (this *node) handleAdvert(a advert, masterDown *time.Timer) {
+
+	log := logging.For("ha")
+
+	/* Own adverts, i.e. of old process on upgrade, or self listed in peers */
+	if a.Node == this.name {
+		return
+	}
+
+	switch this.state {
+
+	case STATE_MASTER:
+		if a.Priority > this.priority || (a.Priority == this.priority && a.Node > this.name) {
+			log.Info("Node ", a.Node, " with priority ", a.Priority, " is master, releasing virtual ips")
+			this.removeVips()
+			this.master = a.Node
+			this.setState(STATE_BACKUP)
+			masterDown.Reset(this.masterDownInterval())
+			return
+		}
+
+		/* Let lower priority peer know this node is master */
+		this.advertise(this.priority)
+
+	default:
+		if a.Priority == 0 {
+			/* Master released virtual ips, take them after skew only */
+			this.master = ""
+			masterDown.Reset(this.skew())
+			return
+		}
+
+		this.master = a.Node
+		this.masterPriority = a.Priority
+
+		/* With preempt, adverts of lower priority master are ignored, so this node takes over */
+		if this.preempt && this.state == STATE_BACKUP && a.Priority < this.priority {
+			return
+		}
+
+		masterDown.Reset(this.masterDownInterval())
+	}
+}
+
+/**
+ * Takes virtual ips if there were no adverts of master for master down interval
+ */
+func (this *node) handleMasterDown(masterDown *time.Timer) {
+
+	masterDown.Reset(this.masterDownInterval())
+
+	if ready, _ := this.readiness(); this.state != STATE_BACKUP || !ready {
+		return
+	}
+
+	if this.master != "" && this.masterPriority < this.priority {
+		logging.For("ha").Info("Preempting master ", this.master, " with lower priority ", this.masterPriority, ", taking virtual ips")
+	} else if this.master != "" {
+		logging.For("ha").Warn("No adverts of master ", this.master, ", taking virtual ips")
+	} else {
+		logging.For("ha").Info("No master, taking virtual ips")
+	}
+
+	this.master = this.name
+	this.setState(STATE_MASTER)
+	this.takeVips()
+	this.advertise(this.priority)
+}
+
+/**
+ * Adds virtual ips to interface and announces them
+ */
+func (this *node) takeVips() {
+
+	log := logging.For("ha")
+
+	for _, v := range this.vips {
+		if err := addAddress(this.iface, v); err != nil {
+			log.Error("Can't add ", v, " to ", this.iface.Name, ": ", err)
+		}
+	}
+
+	iface, vips, stop := this.iface, this.vips, this.stop
+
+	go func() {
+		for i := 0; i < announceCount; i++ {
+			for _, v := range vips {
+				if err := announce(iface, v.IP); err != nil {
+					log.Warn("Can't announce ", v.IP, " on ", iface.Name, ": ", err)
+					return
+				}
+			}
+			select {
+			case <-time.After(announceInterval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+/**
+ * Removes virtual ips from interface
+ */
+func (this *node) removeVips() {
+	for _, v := range this.vips {
+		if err := removeAddress(this.iface, v); err != nil {
+			logging.For("ha").Error("Can't remove ", v, " from ", this.iface.Name, ": ", err)
+		}
+	}
+}
+
+/**
+ * Checks if all virtual ips are on interface
+ */
+func (this *node) holdsVips() bool {
+
+	addrs, err := this.iface.Addrs()
+	if err != nil {
+		return false
+	}
+
+	for _, v := range this.vips {
+		found := false
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(v.IP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return len(this.vips) > 0
+}
+
+func (this *node) setState(state string) {
+	if this.state != state {
+		this.state = state
+		this.since = time.Now()
+	}
+}
+
+/**
+ * Time backup waits for adverts before taking over, 3 intervals and skew
+ */
+func (this *node) masterDownInterval() time.Duration {
+	return 3*this.interval + this.skew()
+}
+
+/**
+ * Delay decreasing with priority, so higher priority backup takes over first
+ */
+func (this *node) skew() time.Duration {
+	return time.Duration(256-this.priority) * this.interval / 256
+}
+
+/**
+ * Sends signed advert to every peer
+ */
+func (this *node) advertise(priority int) {
+
+	body, _ := json.Marshal(advert{this.name, priority, time.Now().UnixNano()})
+	packet := append([]byte(sign(this.secret, body)), body...)
+
+	for _, p := range this.peers {
+		if _, err := this.conn.WriteToUDP(packet, p); err != nil {
+			logging.For("ha").Debug("Can't send advert to ", p, ": ", err)
+		}
+	}
+}
+
+/**
+ * Reads adverts of peers, dropping ones not signed with secret or replayed
+ */
+func (this *node) receive() {
+
+	log := logging.For("ha")
+
+	/* Last advert time by node */
+	last := map[string]int64{}
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, addr, err := this.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		a, err := verify(this.secret, buf[:n], time.Now())
+		if err != nil {
+			log.Warn("Dropped advert of ", addr, ": ", err)
+			continue
+		}
+
+		if a.Time <= last[a.Node] {
+			continue
+		}
+		last[a.Node] = a.Time
+
+		select {
+		case this.adverts <- a:
+		default:
+		}
+	}
+}
+
+/**
+ * Returns hex HMAC-SHA256 of body
+ */
+func sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Checks packet is signed advert sent recently, and decodes it
+ */
+func verify(secret []byte, packet []byte, now time.Time) (advert, error) {
+
+	a := advert{}
+	size := sha256.Size * 2
+
+	if len(packet) <= size {
+		return a, errors.New("packet is too short")
+	}
+
+	signature, body := packet[:size], packet[size:]
+
+	if !hmac.Equal(signature, []byte(sign(secret, body))) {
+		return a, errors.New("signature mismatch, secret differs")
+	}
+
+	if err := json.Unmarshal(body, &a); err != nil {
+		return a, err
+	}
+
+	if skew := now.Sub(time.Unix(0, a.Time)); skew > maxClockSkew || skew < -maxClockSkew {
+		return a, errors.New("time is off by " + skew.String() + ", clocks should be synchronized")
+	}
+
+	return a, nil
+}
+
+/**
+ * Parses virtual ip, with /32 prefix if it's not set
+ */
+func parseVip(v string) *net.IPNet {
+
+	if ip, ipnet, err := net.ParseCIDR(v); err == nil {
+		ipnet.IP = ip
+		return ipnet
+	}
+
+	if ip := net.ParseIP(v); ip != nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	}
+
+	return nil
+}
+
+/**
+ * Validates high availability configuration
+ */
+func Validate(cfg config.HaConfig) error {
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if !supported {
+		return errors.New("virtual ips are supported on linux only")
+	}
+
+	if cfg.Interface == "" {
+		return errors.New("interface is required")
+	}
+
+	if len(cfg.VirtualIps) == 0 {
+		return errors.New("virtual_ips are required")
+	}
+
+	for _, v := range cfg.VirtualIps {
+		if vip := parseVip(v); vip == nil || vip.IP.To4() == nil {
+			return errors.New("virtual ip " + v + " should be ipv4 address, optionally with prefix")
+		}
+	}
+
+	if len(cfg.Secret) < 16 {
+		return errors.New("secret of at least 16 characters is required")
+	}
+
+	if len(cfg.Peers) == 0 {
+		return errors.New("peers are required")
+	}
+
+	for _, p := range cfg.Peers {
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			return errors.New("peer " + p + " should be host:port")
+		}
+	}
+
+	if cfg.Bind != "" {
+		if _, _, err := net.SplitHostPort(cfg.Bind); err != nil {
+			return errors.New("bind should be host:port")
+		}
+	}
+
+	if cfg.Priority < 0 || cfg.Priority > 254 {
+		return errors.New("priority should be from 1 to 254")
+	}
+
+	if cfg.AdvertInterval != "" {
+		if d, err := time.ParseDuration(cfg.AdvertInterval); err != nil || d <= 0 {
+			return errors.New("advert_interval should be positive duration")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+/**
+ * vip_linux.go - adding virtual ips with netlink and announcing them with gratuitous ARP
+ */
+
+package ha
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const supported = true
+
+/* Byte order of netlink messages, host one */
+var native binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		native = binary.BigEndian
+	}
+}
+
+/**
+ * Adds address to interface, address already there is not an error
+ */
+func addAddress(iface *net.Interface, ip *net.IPNet) error {
+	err := addressRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, iface, ip)
+	if err == syscall.EEXIST {
+		return nil
+	}
+	return err
+}
+
+/**
+ * Removes address from interface, address not there is not an error
+ */
+func removeAddress(iface *net.Interface, ip *net.IPNet) error {
+	err := addressRequest(syscall.RTM_DELADDR, 0, iface, ip)
+	if err == syscall.EADDRNOTAVAIL {
+		return nil
+	}
+	return err
+}
+
+/**
+ * Sends RTM_NEWADDR or RTM_DELADDR request for ipv4 address and waits for ack
+ */
+func addressRequest(msgType uint16, flags uint16, iface *net.Interface, ip *net.IPNet) error {
+
+	ip4 := ip.IP.To4()
+	if ip4 == nil {
+		return errors.New("Only ipv4 addresses are supported")
+	}
+
+	prefix, _ := ip.Mask.Size()
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	/* ifaddrmsg followed by IFA_LOCAL and IFA_ADDRESS attributes */
+	body := make([]byte, syscall.SizeofIfAddrmsg)
+	body[0] = syscall.AF_INET
+	body[1] = byte(prefix)
+	native.PutUint32(body[4:], uint32(iface.Index))
+
+	for _, attrType := range []uint16{syscall.IFA_LOCAL, syscall.IFA_ADDRESS} {
+		attr := make([]byte, syscall.SizeofRtAttr+len(ip4))
+		native.PutUint16(attr[0:], uint16(len(attr)))
+		native.PutUint16(attr[2:], attrType)
+		copy(attr[syscall.SizeofRtAttr:], ip4)
+		body = append(body, attr...)
+	}
+
+	msg := make([]byte, syscall.SizeofNlMsghdr, syscall.SizeofNlMsghdr+len(body))
+	native.PutUint32(msg[0:], uint32(syscall.SizeofNlMsghdr+len(body)))
+	native.PutUint16(msg[4:], msgType)
+	native.PutUint16(msg[6:], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	native.PutUint32(msg[8:], 1)
+	msg = append(msg, body...)
+
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			if errno := int32(native.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+/**
+ * Broadcasts gratuitous ARP request for ip, so neighbours update their caches
+ */
+func announce(iface *net.Interface, ip net.IP) error {
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return errors.New("Only ipv4 addresses are supported")
+	}
+
+	if len(iface.HardwareAddr) != 6 {
+		return errors.New("Interface has no ethernet address")
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	/* Ethernet header */
+	frame := make([]byte, 0, 42)
+	frame = append(frame, broadcast...)
+	frame = append(frame, iface.HardwareAddr...)
+	frame = append(frame, 0x08, 0x06)
+
+	/* ARP request: ethernet / ipv4, sender and target ip are both virtual ip, target mac is unknown */
+	frame = append(frame, 0x00, 0x01, 0x08, 0x00, 6, 4, 0x00, 0x01)
+	frame = append(frame, iface.HardwareAddr...)
+	frame = append(frame, ip4...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, ip4...)
+
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], broadcast)
+
+	return syscall.Sendto(fd, frame, 0, addr)
+}
+
+/**
+ * Converts to network byte order
+ */
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return native.Uint16(b)
+}
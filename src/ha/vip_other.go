@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * vip_other.go - virtual ips are managed on linux only
+ */
+
+package ha
+
+import (
+	"errors"
+	"net"
+)
+
+const supported = false
+
+var errUnsupported = errors.New("Virtual ips are supported on linux only")
+
+func addAddress(iface *net.Interface, ip *net.IPNet) error {
+	return errUnsupported
+}
+
+func removeAddress(iface *net.Interface, ip *net.IPNet) error {
+	return errUnsupported
+}
+
+func announce(iface *net.Interface, ip net.IP) error {
+	return errUnsupported
+}
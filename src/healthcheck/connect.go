@@ -0,0 +1,120 @@
+/**
+ * connect.go - TCP / UDP connect healthcheck with optional send / expect payloads
+ */
+
+package healthcheck
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+/* Max response bytes to read looking for expected payload */
+const connectMaxRead = 4096
+
+/**
+ * Error of response not containing expected payload
+ */
+var errUnexpectedResponse = errors.New("Response does not contain expected payload")
+
+/**
+ * Connect healthcheck. Opens connection to backend port, sends payload
+ * and checks response contains expected one, if configured
+ */
+func connect(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+
+	log := logging.For("healthcheck/connect")
+
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+
+	checkResult := CheckResult{
+		Target: t.Target,
+	}
+
+	err := connectProbe(t.Target, cfg, timeout)
+	if err != nil {
+		log.Debug("Connect check of ", t.Address(), " failed: ", err)
+		checkResult.Error = err.Error()
+	}
+
+	checkResult.Live = err == nil
+
+	select {
+	case result <- checkResult:
+	default:
+		log.Warn("Channel is full. Discarding value")
+	}
+}
+
+/**
+ * Connects to target and exchanges payloads, returning error if target is not live
+ */
+func connectProbe(t core.Target, cfg config.HealthcheckConfig, timeout time.Duration) error {
+
+	network, address := cfg.ConnectProtocol, t.Address()
+	if t.IsUnix() {
+		if network != "tcp" {
+			return errors.New("Unix socket backends can be checked with tcp connect_protocol only")
+		}
+		network, address = t.Network(), t.DialAddress()
+	}
+
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if cfg.ConnectSend != "" {
+		if _, err := conn.Write([]byte(cfg.ConnectSend)); err != nil {
+			return err
+		}
+	}
+
+	udp := cfg.ConnectProtocol == "udp"
+
+	if cfg.ConnectExpect == "" && !udp {
+		return nil
+	}
+
+	buf := make([]byte, connectMaxRead)
+	received := ""
+
+	for {
+		n, err := conn.Read(buf)
+		received += string(buf[0:n])
+
+		// Udp backend is live if it responds anything or does not respond
+		// in timeout, but not if port is unreachable (read is refused)
+		if cfg.ConnectExpect == "" {
+			if e, ok := err.(net.Error); err == nil || ok && e.Timeout() {
+				return nil
+			}
+			return err
+		}
+
+		if strings.Contains(received, cfg.ConnectExpect) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Response is single datagram for udp
+		if udp || len(received) >= connectMaxRead {
+			return errUnexpectedResponse
+		}
+	}
+}
@@ -7,6 +7,11 @@
 package healthcheck
 
 import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
 	"../config"
 	"../core"
 	"../logging"
@@ -15,29 +20,97 @@ import (
 )
 
 /**
- * Exec healthcheck
+ * Result printed by command with json output format
+ */
+type execJsonOutput struct {
+
+	/* Backend is live */
+	Live bool `json:"live"`
+
+	/* Weight to set for backend, 0 keeps current one */
+	Weight int `json:"weight"`
+
+	/* Reason check failed with */
+	Error string `json:"error"`
+}
+
+/**
+ * Returns GOBETWEEN_BACKEND_* environment variables of backend properties
+ * exec command gets. Labels are GOBETWEEN_BACKEND_LABEL_<KEY>, with keys
+ * uppercased and characters not valid in variable names replaced by _
  */
-func exec(t core.Target, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+func ExecEnv(t core.Backend) []string {
+
+	env := []string{
+		"GOBETWEEN_BACKEND_HOST=" + t.Host,
+		"GOBETWEEN_BACKEND_PORT=" + t.Port,
+		"GOBETWEEN_BACKEND_WEIGHT=" + strconv.Itoa(t.Weight),
+		"GOBETWEEN_BACKEND_PRIORITY=" + strconv.Itoa(t.Priority),
+		"GOBETWEEN_BACKEND_SNI=" + t.Sni,
+		"GOBETWEEN_BACKEND_MAX_CONNECTIONS=" + strconv.Itoa(t.MaxConnections),
+	}
+
+	keys := []string{}
+	for key := range t.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.Map(func(r rune) rune {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(key))
+
+		env = append(env, "GOBETWEEN_BACKEND_LABEL_"+name+"="+t.Labels[key])
+	}
+
+	return env
+}
+
+/**
+ * Exec healthcheck. Command gets backend host and port as arguments,
+ * and backend properties as GOBETWEEN_BACKEND_* environment variables
+ */
+func exec(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
 
 	log := logging.For("healthcheck/exec")
 
 	execTimeout, _ := time.ParseDuration(cfg.Timeout)
 
 	checkResult := CheckResult{
-		Target: t,
+		Target: t.Target,
 	}
 
-	out, err := utils.ExecTimeout(execTimeout, cfg.ExecCommand, t.Host, t.Port)
+	out, err := utils.ExecTimeoutEnv(execTimeout, ExecEnv(t), cfg.ExecCommand, t.Host, t.Port)
 	if err != nil {
 		// TODO: Decide better what to do in this case
 		checkResult.Live = false
+		checkResult.Error = err.Error()
 		log.Warn(err)
+	} else if cfg.ExecOutputFormat == "json" {
+		output := execJsonOutput{}
+		if err := json.Unmarshal([]byte(out), &output); err != nil {
+			checkResult.Error = "Unexpected output: " + err.Error()
+			log.Warn("Unexpected output: ", out)
+		} else {
+			checkResult.Live = output.Live
+			checkResult.Weight = output.Weight
+			checkResult.Error = output.Error
+			if !output.Live && output.Error == "" {
+				checkResult.Error = "Negative output"
+			}
+		}
 	} else {
 		if out == cfg.ExecExpectedPositiveOutput {
 			checkResult.Live = true
 		} else if out == cfg.ExecExpectedNegativeOutput {
 			checkResult.Live = false
+			checkResult.Error = "Negative output"
 		} else {
+			checkResult.Error = "Unexpected output: " + out
 			log.Warn("Unexpected output: ", out)
 		}
 	}
@@ -7,15 +7,18 @@
 package healthcheck
 
 import (
+	"sync"
+	"time"
+
 	"../config"
 	"../core"
 )
 
 /**
- * Health Check function
+ * Health Check function of backend
  * Returns channel in which only one check result will be delivered
  */
-type CheckFunc func(core.Target, config.HealthcheckConfig, chan<- CheckResult)
+type CheckFunc func(core.Backend, config.HealthcheckConfig, chan<- CheckResult)
 
 /**
  * Check result
@@ -28,6 +31,28 @@ type CheckResult struct {
 
 	/* Check live status */
 	Live bool
+
+	/* Reason check failed with, if any */
+	Error string
+
+	/* Check duration */
+	Latency time.Duration
+
+	/* Weight backend reported for itself, 0 if none */
+	Weight int
+}
+
+/**
+ * Outcome of proxied connection to target,
+ * used for passive healthcheck
+ */
+type PassiveResult struct {
+
+	/* Target connection was proxied to */
+	Target core.Target
+
+	/* If connection succeeded */
+	Ok bool
 }
 
 /**
@@ -41,19 +66,30 @@ type Healthcheck struct {
 	/* Healthcheck configuration */
 	cfg config.HealthcheckConfig
 
-	/* Input channel to accept targets */
-	In chan []core.Target
+	/* Input channel to accept backends to check */
+	In chan []core.Backend
 
 	/* Output channel to send check results for individual target */
 	Out chan CheckResult
 
+	/* Channel to accept outcomes of proxied connections */
+	passive chan PassiveResult
+
 	/* Current check workers */
 	workers []*Worker
 
+	/* Guards workers for history reading */
+	workersLock sync.RWMutex
+
 	/* Channel to handle stop */
 	stop chan bool
 }
 
+/**
+ * Size of buffer of not yet processed passive results
+ */
+const PASSIVE_BUFFER_SIZE = 64
+
 /**
  * Registry of factory methods
  */
@@ -65,6 +101,10 @@ var registry = make(map[string]CheckFunc)
 func init() {
 	registry["ping"] = ping
 	registry["exec"] = exec
+	registry["http"] = httpCheck
+	registry["connect"] = connect
+	registry["tls"] = tlsCheck
+	registry["udp"] = udpCheck
 	registry["none"] = nil
 }
 
@@ -80,8 +120,9 @@ func New(strategy string, cfg config.HealthcheckConfig) *Healthcheck {
 	h := Healthcheck{
 		check:   check,
 		cfg:     cfg,
-		In:      make(chan []core.Target),
+		In:      make(chan []core.Backend),
 		Out:     make(chan CheckResult),
+		passive: make(chan PassiveResult, PASSIVE_BUFFER_SIZE),
 		workers: []*Worker{},
 		stop:    make(chan bool),
 	}
@@ -98,9 +139,18 @@ func (this *Healthcheck) Start() {
 		for {
 			select {
 
-			/* got new targets */
-			case targets := <-this.In:
-				this.UpdateWorkers(targets)
+			/* got new backends */
+			case backends := <-this.In:
+				this.UpdateWorkers(backends)
+
+			/* got proxied connection outcome */
+			case result := <-this.passive:
+				for _, w := range this.workers {
+					if w.target.EqualTo(result.Target) {
+						w.Passive(result.Ok)
+						break
+					}
+				}
 
 			/* got stop requst */
 			case <-this.stop:
@@ -111,7 +161,9 @@ func (this *Healthcheck) Start() {
 				}
 
 				// And free it's memory
+				this.workersLock.Lock()
 				this.workers = []*Worker{}
+				this.workersLock.Unlock()
 
 				return
 			}
@@ -120,34 +172,39 @@ func (this *Healthcheck) Start() {
 }
 
 /**
- * Sync current workers to represent healtcheck on targets
+ * Sync current workers to represent healtcheck on backends
  * Will remove not needed workers, and add needed
  */
-func (this *Healthcheck) UpdateWorkers(targets []core.Target) {
+func (this *Healthcheck) UpdateWorkers(backends []core.Backend) {
 
 	result := []*Worker{}
 
 	// Keep or add needed workers
-	for _, t := range targets {
+	for _, b := range backends {
 		var keep *Worker
 		for i := range this.workers {
 			c := this.workers[i]
-			if t.EqualTo(c.target) {
+			if b.Target.EqualTo(c.target) {
 				keep = c
 				break
 			}
 		}
 
-		if keep == nil {
+		if keep != nil {
+			keep.setBackend(b)
+		} else {
 			keep = &Worker{
-				target: t,
-				stop:   make(chan bool),
-				out:    this.Out,
-				cfg:    this.cfg,
-				check:  this.check,
+				target:  b.Target,
+				backend: b,
+				stop:    make(chan bool),
+				passive: make(chan bool, PASSIVE_BUFFER_SIZE),
+				out:     this.Out,
+				cfg:     this.cfg,
+				check:   this.check,
 				LastResult: CheckResult{
 					Live: true,
 				},
+				history: newHistory(this.cfg.HistorySize),
 			}
 			keep.Start()
 		}
@@ -158,8 +215,8 @@ func (this *Healthcheck) UpdateWorkers(targets []core.Target) {
 	for i := range this.workers {
 		c := this.workers[i]
 		remove := true
-		for _, t := range targets {
-			if c.target.EqualTo(t) {
+		for _, b := range backends {
+			if c.target.EqualTo(b.Target) {
 				remove = false
 				break
 			}
@@ -170,8 +227,48 @@ func (this *Healthcheck) UpdateWorkers(targets []core.Target) {
 		}
 	}
 
+	this.workersLock.Lock()
 	this.workers = result
+	this.workersLock.Unlock()
+}
 
+/**
+ * Returns healthcheck history of current targets
+ */
+func (this *Healthcheck) History() []TargetHistory {
+
+	this.workersLock.RLock()
+	defer this.workersLock.RUnlock()
+
+	result := make([]TargetHistory, 0, len(this.workers))
+	for _, w := range this.workers {
+		result = append(result, w.History())
+	}
+
+	return result
+}
+
+/**
+ * Report outcome of connection proxied to target for passive healthcheck.
+ * Doesn't block, outcome is dropped if healthcheck is busy or passive check is disabled
+ */
+func (this *Healthcheck) ReportPassive(target core.Target, ok bool) {
+
+	if this.cfg.PassiveFails <= 0 {
+		return
+	}
+
+	select {
+	case this.passive <- PassiveResult{target, ok}:
+	default:
+	}
+}
+
+/**
+ * Returns count of passive results waiting to be processed
+ */
+func (this *Healthcheck) Pending() int {
+	return len(this.passive)
 }
 
 /**
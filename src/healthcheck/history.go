@@ -0,0 +1,155 @@
+/**
+ * history.go - recent healthcheck results of target
+ */
+
+package healthcheck
+
+import (
+	"sync"
+	"time"
+
+	"../core"
+)
+
+/**
+ * Default number of last check results and live changes kept per target
+ */
+const HISTORY_SIZE = 10
+
+/**
+ * Result of single active check
+ */
+type CheckRecord struct {
+
+	/* Time check was finished */
+	Time time.Time `json:"time"`
+
+	/* Check result */
+	Live bool `json:"live"`
+
+	/* Check duration, seconds */
+	Latency float64 `json:"latency"`
+
+	/* Reason check failed with, if any */
+	Error string `json:"error,omitempty"`
+}
+
+/**
+ * Change of target live status
+ */
+type Transition struct {
+
+	/* Time live status was changed */
+	Time time.Time `json:"time"`
+
+	/* New live status */
+	Live bool `json:"live"`
+
+	/* Change was caused by failed proxied connections, not active checks */
+	Passive bool `json:"passive"`
+}
+
+/**
+ * Healthcheck history of target, most recent last
+ */
+type TargetHistory struct {
+	Target      core.Target   `json:"target"`
+	Live        bool          `json:"live"`
+	Checks      []CheckRecord `json:"checks"`
+	Transitions []Transition  `json:"transitions"`
+}
+
+/**
+ * Bounded history of worker checks, safe for concurrent reading
+ */
+type history struct {
+	sync.Mutex
+
+	/* Max records of each kind kept */
+	size int
+
+	/* Current live status, targets are live until checks fail */
+	live bool
+
+	checks      []CheckRecord
+	transitions []Transition
+}
+
+/**
+ * Creates history of size, HISTORY_SIZE if not positive
+ */
+func newHistory(size int) *history {
+
+	if size <= 0 {
+		size = HISTORY_SIZE
+	}
+
+	return &history{size: size, live: true}
+}
+
+/**
+ * Adds result of check
+ */
+func (this *history) addCheck(result CheckResult) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	record := CheckRecord{
+		Time:    time.Now(),
+		Live:    result.Live,
+		Latency: result.Latency.Seconds(),
+		Error:   result.Error,
+	}
+
+	// Drop the oldest one when full
+	if len(this.checks) >= this.size {
+		copy(this.checks, this.checks[1:])
+		this.checks[len(this.checks)-1] = record
+		return
+	}
+
+	this.checks = append(this.checks, record)
+}
+
+/**
+ * Adds change of live status
+ */
+func (this *history) addTransition(live bool, passive bool) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.live = live
+
+	transition := Transition{
+		Time:    time.Now(),
+		Live:    live,
+		Passive: passive,
+	}
+
+	// Drop the oldest one when full
+	if len(this.transitions) >= this.size {
+		copy(this.transitions, this.transitions[1:])
+		this.transitions[len(this.transitions)-1] = transition
+		return
+	}
+
+	this.transitions = append(this.transitions, transition)
+}
+
+/**
+ * Returns copy of history
+ */
+func (this *history) snapshot(target core.Target) TargetHistory {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return TargetHistory{
+		Target:      target,
+		Live:        this.live,
+		Checks:      append([]CheckRecord{}, this.checks...),
+		Transitions: append([]Transition{}, this.transitions...),
+	}
+}
@@ -0,0 +1,114 @@
+/**
+ * http.go - HTTP healthcheck
+ */
+
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+/* Max response body bytes to read before closing connection */
+const httpMaxBodyRead = 4096
+
+/**
+ * HTTP healthcheck
+ */
+func httpCheck(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+
+	log := logging.For("healthcheck/http")
+
+	httpTimeout, _ := time.ParseDuration(cfg.Timeout)
+
+	checkResult := CheckResult{
+		Target: t.Target,
+	}
+
+	scheme := "http"
+	if cfg.HttpTlsEnabled {
+		scheme = "https"
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: cfg.HttpTlsSkipVerify,
+			ServerName:         cfg.HttpHost,
+		},
+	}
+
+	// Backend resolved from host name is requested by name, connected by ip
+	if cfg.HttpHost == "" && t.Hostname != "" {
+		transport.TLSClientConfig.ServerName = t.Hostname
+	}
+
+	// Unix socket backend is requested as localhost, connected to its socket
+	host := t.Address()
+	if t.IsUnix() {
+		host = "localhost"
+		transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", t.DialAddress())
+		}
+	}
+
+	client := http.Client{
+		Timeout:   httpTimeout,
+		Transport: transport,
+		// Redirect response is a result of the check itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(cfg.HttpMethod, scheme+"://"+host+cfg.HttpPath, nil)
+	if err != nil {
+		checkResult.Error = err.Error()
+		log.Warn(err)
+	} else {
+
+		if cfg.HttpHost != "" {
+			req.Host = cfg.HttpHost
+		} else if t.Hostname != "" {
+			req.Host = net.JoinHostPort(t.Hostname, t.Port)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			checkResult.Live = false
+			checkResult.Error = err.Error()
+		} else {
+			io.CopyN(ioutil.Discard, resp.Body, httpMaxBodyRead)
+			resp.Body.Close()
+
+			checkResult.Live = false
+			for _, status := range cfg.HttpExpectedStatuses {
+				if resp.StatusCode == status {
+					checkResult.Live = true
+					break
+				}
+			}
+
+			if !checkResult.Live {
+				checkResult.Error = "Unexpected status " + strconv.Itoa(resp.StatusCode)
+				log.Debug("Unexpected status ", resp.StatusCode, " from ", t.Address())
+			}
+		}
+	}
+
+	select {
+	case result <- checkResult:
+	default:
+		log.Warn("Channel is full. Discarding value")
+	}
+}
@@ -17,19 +17,20 @@ import (
 /**
  * Ping healthcheck
  */
-func ping(t core.Target, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+func ping(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
 
 	pingTimeoutDuration, _ := time.ParseDuration(cfg.Timeout)
 
 	log := logging.For("healthcheck/ping")
 
 	checkResult := CheckResult{
-		Target: t,
+		Target: t.Target,
 	}
 
-	conn, err := net.DialTimeout("tcp", t.Address(), pingTimeoutDuration)
+	conn, err := net.DialTimeout(t.Network(), t.DialAddress(), pingTimeoutDuration)
 	if err != nil {
 		checkResult.Live = false
+		checkResult.Error = err.Error()
 	} else {
 		checkResult.Live = true
 		conn.Close()
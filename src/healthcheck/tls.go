@@ -0,0 +1,96 @@
+/**
+ * tls.go - TLS handshake healthcheck verifying backend certificate
+ */
+
+package healthcheck
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+/**
+ * Tls healthcheck. Completes tls handshake with backend, verifying its
+ * certificate chain (if not skipped) and that certificate does not expire soon
+ */
+func tlsCheck(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+
+	log := logging.For("healthcheck/tls")
+
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+
+	checkResult := CheckResult{
+		Target: t.Target,
+	}
+
+	err := tlsProbe(t, cfg, timeout)
+	if err != nil {
+		log.Debug("Tls check of ", t.Address(), " failed: ", err)
+		checkResult.Error = err.Error()
+	}
+
+	checkResult.Live = err == nil
+
+	select {
+	case result <- checkResult:
+	default:
+		log.Warn("Channel is full. Discarding value")
+	}
+}
+
+/**
+ * Handshakes with target and checks its certificate, returning error if target is not live
+ */
+func tlsProbe(t core.Backend, cfg config.HealthcheckConfig, timeout time.Duration) error {
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TlsSkipVerify,
+		ServerName:         cfg.TlsServerName,
+	}
+
+	if tlsConfig.ServerName == "" && t.IsUnix() {
+		tlsConfig.ServerName = "localhost"
+	} else if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = t.ServerName()
+	}
+
+	if cfg.TlsRootCaCertPath != "" {
+		caCertPem, err := ioutil.ReadFile(cfg.TlsRootCaCertPath)
+		if err != nil {
+			return err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCertPem) {
+			return errors.New("Unable to load root pem " + cfg.TlsRootCaCertPath)
+		}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, t.Network(), t.DialAddress(), tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return errors.New("No certificate presented")
+	}
+
+	// Checked even if verification is skipped, so expired certificate is never live
+	deadline := time.Now().AddDate(0, 0, cfg.TlsMinExpiryDays)
+	if notAfter := certificates[0].NotAfter; notAfter.Before(deadline) {
+		return errors.New("Certificate expires at " + notAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
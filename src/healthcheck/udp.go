@@ -0,0 +1,128 @@
+/**
+ * udp.go - UDP probe healthcheck with send / expect payloads
+ */
+
+package healthcheck
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+)
+
+/* Max response datagram size */
+const udpMaxRead = 65535
+
+/**
+ * Udp healthcheck. Sends payload to backend port and checks it responds
+ * within timeout, with datagram containing expected payload if configured
+ */
+func udpCheck(t core.Backend, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+
+	log := logging.For("healthcheck/udp")
+
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+
+	checkResult := CheckResult{
+		Target: t.Target,
+	}
+
+	err := udpProbe(t.Target, cfg, timeout)
+	if err != nil {
+		log.Debug("Udp check of ", t.Address(), " failed: ", err)
+		checkResult.Error = err.Error()
+	}
+
+	checkResult.Live = err == nil
+
+	select {
+	case result <- checkResult:
+	default:
+		log.Warn("Channel is full. Discarding value")
+	}
+}
+
+/**
+ * Sends payload to target and reads responses until expected one or timeout,
+ * returning error if target is not live. Port unreachable fails check
+ * as soon as it's reported, as read on connected socket is refused
+ */
+func udpProbe(t core.Target, cfg config.HealthcheckConfig, timeout time.Duration) error {
+
+	if t.IsUnix() {
+		return errors.New("Unix socket backends can't be checked with udp")
+	}
+
+	send, err := DecodeUdpPayload(cfg.UdpSend, cfg.UdpPayloadFormat)
+	if err != nil {
+		return err
+	}
+
+	expect, err := DecodeUdpPayload(cfg.UdpExpect, cfg.UdpPayloadFormat)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("udp", t.Address(), timeout)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(send); err != nil {
+		return err
+	}
+
+	buf := make([]byte, udpMaxRead)
+	received := false
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				if received {
+					return errUnexpectedResponse
+				}
+				return errors.New("No response in timeout")
+			}
+			return err
+		}
+
+		// Other datagrams may be late responses to previous checks
+		if len(expect) == 0 || bytes.Contains(buf[0:n], expect) {
+			return nil
+		}
+
+		received = true
+	}
+}
+
+/**
+ * Decodes udp healthcheck payload of format "string" | "hex".
+ * Hex payload may have whitespaces between bytes
+ */
+func DecodeUdpPayload(payload string, format string) ([]byte, error) {
+
+	switch format {
+	case "", "string":
+		return []byte(payload), nil
+	case "hex":
+		decoded, err := hex.DecodeString(strings.Join(strings.Fields(payload), ""))
+		if err != nil {
+			return nil, errors.New("Invalid hex payload: " + err.Error())
+		}
+		return decoded, nil
+	default:
+		return nil, errors.New("Not supported udp payload format " + format)
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"../config"
 	"../core"
 	"../logging"
+	"../utils"
+	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -23,6 +26,15 @@ type Worker struct {
 	/* Target to monitor and check */
 	target core.Target
 
+	/* Backend of target as last discovered, passed to check */
+	backend core.Backend
+
+	/* Guards backend, updated on discovery while checks run */
+	backendLock sync.Mutex
+
+	/* Last weight backend reported for itself */
+	weight int
+
 	/* Function that does actual check */
 	check CheckFunc
 
@@ -35,14 +47,23 @@ type Worker struct {
 	/* Stop channel to worker to stop */
 	stop chan bool
 
+	/* Channel to accept outcomes of proxied connections */
+	passive chan bool
+
 	/* Last confirmed check result */
 	LastResult CheckResult
 
-	/* Current passes count, if LastResult.Live = true */
+	/* Current consecutive passed checks, if LastResult.Live = false */
 	passes int
 
-	/* Current fails count, if LastResult.Live = false */
+	/* Current consecutive failed checks, if LastResult.Live = true */
 	fails int
+
+	/* Current consecutive failed proxied connections, if LastResult.Live = true */
+	passiveFails int
+
+	/* Recent check results and live changes */
+	history *history
 }
 
 /**
@@ -58,8 +79,11 @@ func (this *Worker) Start() {
 	}
 
 	interval, _ := time.ParseDuration(this.cfg.Interval)
+	jitter := utils.ParseDurationOrDefault(this.cfg.Jitter, 0)
 
-	ticker := time.NewTicker(interval)
+	// First check is at random point of interval, so checks of
+	// targets started together are spread instead of firing at once
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
 	c := make(chan CheckResult, 1)
 
 	go func() {
@@ -67,18 +91,23 @@ func (this *Worker) Start() {
 			select {
 
 			/* new check interval has reached */
-			case <-ticker.C:
+			case <-timer.C:
 				log.Debug("Next check ", this.cfg.Kind, " for ", this.target)
-				go this.check(this.target, this.cfg, c)
+				go this.run(c)
+				timer.Reset(nextCheckDelay(interval, jitter))
 
 			/* new check result is ready */
 			case checkResult := <-c:
 				log.Debug("Got check result ", this.cfg.Kind, ": ", checkResult)
 				this.process(checkResult)
 
+			/* new proxied connection outcome */
+			case ok := <-this.passive:
+				this.processPassive(ok)
+
 			/* request to stop worker */
 			case <-this.stop:
-				ticker.Stop()
+				timer.Stop()
 				//close(c) // TODO: Check!
 				return
 			}
@@ -87,34 +116,157 @@ func (this *Worker) Start() {
 }
 
 /**
- * Process next check result,
- * counting passes and fails as needed, and
- * sending updated check result to out
+ * Returns delay until next check, interval randomly shifted by up to jitter
+ */
+func nextCheckDelay(interval time.Duration, jitter time.Duration) time.Duration {
+
+	if jitter <= 0 {
+		return interval
+	}
+
+	return interval - jitter + time.Duration(rand.Int63n(int64(2*jitter)))
+}
+
+/**
+ * Runs check, measuring its latency
+ */
+func (this *Worker) run(out chan<- CheckResult) {
+
+	c := make(chan CheckResult, 1)
+	start := time.Now()
+
+	this.backendLock.Lock()
+	backend := this.backend
+	this.backendLock.Unlock()
+
+	this.check(backend, this.cfg, c)
+
+	select {
+	case checkResult := <-c:
+		checkResult.Latency = time.Since(start)
+		select {
+		case out <- checkResult:
+		default:
+			logging.For("healthcheck/worker").Warn("Channel is full. Discarding value")
+		}
+	default:
+	}
+}
+
+/**
+ * Process next check result, counting consecutive
+ * passes and fails, and sending updated check result to out
+ * once there are cfg.Passes / cfg.Fails of them in a row
  */
 func (this *Worker) process(checkResult CheckResult) {
 
 	log := logging.For("healthcheck/worker")
 
-	if this.LastResult.Live && !checkResult.Live {
+	this.history.addCheck(checkResult)
+
+	// Reported weight is passed to scheduler as is, with currently confirmed live status
+	if checkResult.Weight > 0 && checkResult.Weight != this.weight {
+		this.weight = checkResult.Weight
+		this.out <- CheckResult{
+			Target: this.target,
+			Live:   this.LastResult.Live,
+			Weight: this.weight,
+		}
+	}
+
+	if this.LastResult.Live == checkResult.Live {
+		// check status not changed, so the series of opposite results is broken
 		this.passes = 0
-		this.fails++
-	} else if !this.LastResult.Live && checkResult.Live {
 		this.fails = 0
+		return
+	}
+
+	if checkResult.Live {
 		this.passes++
 	} else {
-		// check status not changed
-		return
+		this.fails++
 	}
 
-	if this.passes == 0 && this.fails >= this.cfg.Fails ||
-		this.fails == 0 && this.passes >= this.cfg.Passes {
-		this.LastResult = checkResult
+	if this.fails >= this.cfg.Fails || this.passes >= this.cfg.Passes {
+		this.passes = 0
+		this.fails = 0
+		this.setLastResult(checkResult, false)
 
 		log.Info("Sending to scheduler: ", this.LastResult)
 		this.out <- checkResult
 	}
 }
 
+/**
+ * Process outcome of proxied connection, marking backend
+ * as inactive after configured consecutive failures.
+ * Backend is marked as active again by active checks
+ */
+func (this *Worker) processPassive(ok bool) {
+
+	if !this.LastResult.Live {
+		return
+	}
+
+	if ok {
+		this.passiveFails = 0
+		return
+	}
+
+	this.passiveFails++
+	if this.passiveFails < this.cfg.PassiveFails {
+		return
+	}
+
+	this.passiveFails = 0
+	this.passes = 0
+	this.setLastResult(CheckResult{
+		Target: this.target,
+		Live:   false,
+		Error:  "Passive check failed",
+	}, true)
+
+	logging.For("healthcheck/worker").Info("Passive check failed, sending to scheduler: ", this.LastResult)
+	this.out <- this.LastResult
+}
+
+/**
+ * Confirms check result, recording live status change
+ */
+func (this *Worker) setLastResult(checkResult CheckResult, passive bool) {
+
+	this.LastResult = checkResult
+	this.history.addTransition(checkResult.Live, passive)
+}
+
+/**
+ * Updates backend of target passed to next checks
+ */
+func (this *Worker) setBackend(backend core.Backend) {
+	this.backendLock.Lock()
+	defer this.backendLock.Unlock()
+
+	this.backend = backend
+}
+
+/**
+ * Returns worker target healthcheck history
+ */
+func (this *Worker) History() TargetHistory {
+	return this.history.snapshot(this.target)
+}
+
+/**
+ * Pass outcome of proxied connection to worker,
+ * dropping it if worker is busy
+ */
+func (this *Worker) Passive(ok bool) {
+	select {
+	case this.passive <- ok:
+	default:
+	}
+}
+
 /**
  * Stop worker
  */
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * eventlog_other.go - windows event log output is not available on other platforms
+ */
+
+package logging
+
+import (
+	"errors"
+)
+
+/**
+ * Event log is supported on windows only
+ */
+func openEventlog(opts OutputOptions) (LevelWriter, error) {
+	return nil, errors.New("eventlog output is supported on windows only, use syslog")
+}
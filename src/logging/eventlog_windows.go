@@ -0,0 +1,69 @@
+/**
+ * eventlog_windows.go - windows event log output
+ */
+
+package logging
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+/**
+ * Event id of all logged events
+ */
+const eventlogEventId = 1
+
+/**
+ * Windows event log writer
+ */
+type eventLog struct {
+	*eventlog.Log
+}
+
+/**
+ * Opens event log output, source named as tag should be
+ * registered, i.e. by service install command
+ */
+func openEventlog(opts OutputOptions) (LevelWriter, error) {
+
+	log, err := eventlog.Open(opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventLog{log}, nil
+}
+
+/**
+ * Writes line as info event
+ */
+func (this *eventLog) Write(p []byte) (int, error) {
+	return this.WriteLevel(logrus.InfoLevel, p)
+}
+
+/**
+ * Writes line as event of type corresponding to log level
+ */
+func (this *eventLog) WriteLevel(level logrus.Level, p []byte) (int, error) {
+
+	msg := strings.TrimRight(string(p), "\n")
+
+	var err error
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		err = this.Error(eventlogEventId, msg)
+	case logrus.WarnLevel:
+		err = this.Warning(eventlogEventId, msg)
+	default:
+		err = this.Info(eventlogEventId, msg)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
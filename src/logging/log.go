@@ -9,11 +9,26 @@ package logging
 import (
 	"bytes"
 	"fmt"
-	"github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"../config"
+	"github.com/Sirupsen/logrus"
 )
 
+/**
+ * Loggers with own level for module names, configured in logging.levels
+ */
+var modules = struct {
+	sync.RWMutex
+	loggers map[string]*logrus.Logger
+}{
+	loggers: map[string]*logrus.Logger{},
+}
+
 /**
  * Logging initialize
  */
@@ -26,29 +41,120 @@ func init() {
 /**
  * Configure logging
  */
-func Configure(output string, l string) {
+func Configure(cfg config.LoggingConfig) {
+
+	var maxAge time.Duration
+	if cfg.MaxAge != "" {
+		var err error
+		if maxAge, err = time.ParseDuration(cfg.MaxAge); err != nil {
+			logrus.Fatal("Invalid logging max_age ", cfg.MaxAge)
+		}
+	}
+
+	tag := cfg.SyslogTag
+	if tag == "" {
+		tag = "gobetween"
+	}
 
-	if output == "" || output == "stdout" {
-		logrus.SetOutput(os.Stdout)
-	} else if output == "stderr" {
-		logrus.SetOutput(os.Stderr)
+	output, err := OpenOutput(cfg.Output, OutputOptions{
+		Tag:        tag,
+		Facility:   cfg.SyslogFacility,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     maxAge,
+		MaxBackups: cfg.MaxBackups,
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	/* Syslog outputs get entries via hook to keep their severity */
+	if w, ok := output.(LevelWriter); ok {
+		logrus.SetOutput(ioutil.Discard)
+		logrus.AddHook(&levelHook{w})
 	} else {
-		f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+		logrus.SetOutput(output)
+	}
+
+	if cfg.Level != "" {
+		if level, err := logrus.ParseLevel(cfg.Level); err != nil {
+			logrus.Fatal("Unknown loglevel ", cfg.Level)
+		} else {
+			logrus.SetLevel(level)
+		}
+	}
+
+	/* Module loggers share output, formatter and hooks of standard logger */
+	std := logrus.StandardLogger()
+	loggers := map[string]*logrus.Logger{}
+
+	for name, l := range cfg.Levels {
+		level, err := logrus.ParseLevel(l)
 		if err != nil {
-			logrus.Fatal(err)
+			logrus.Fatal("Unknown loglevel ", l, " for ", name)
 		}
-		logrus.SetOutput(f)
+
+		logger := logrus.New()
+		logger.Out = std.Out
+		logger.Formatter = std.Formatter
+		logger.Hooks = std.Hooks
+		logger.Level = level
+
+		loggers[name] = logger
 	}
 
-	if l == "" {
-		return
+	modules.Lock()
+	modules.loggers = loggers
+	modules.Unlock()
+}
+
+/**
+ * Returns logger of module name belongs to, i.e. "healthcheck" for
+ * "healthcheck/worker" or "server" for "server.handle". Longest module name wins
+ */
+func loggerFor(name string) *logrus.Logger {
+
+	modules.RLock()
+	defer modules.RUnlock()
+
+	var logger *logrus.Logger
+	matched := -1
+
+	for module, l := range modules.loggers {
+		if len(module) <= matched {
+			continue
+		}
+
+		if name == module || strings.HasPrefix(name, module+"/") || strings.HasPrefix(name, module+".") {
+			logger = l
+			matched = len(module)
+		}
 	}
 
-	if level, err := logrus.ParseLevel(l); err != nil {
-		logrus.Fatal("Unknown loglevel ", l)
-	} else {
-		logrus.SetLevel(level)
+	return logger
+}
+
+/**
+ * Hook passing entries to level writer
+ */
+type levelHook struct {
+	writer LevelWriter
+}
+
+func (this *levelHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+/**
+ * Write entry without timestamp, as syslog adds own
+ */
+func (this *levelHook) Fire(entry *logrus.Entry) error {
+	name, ok := entry.Data["name"]
+	if !ok {
+		name = "default"
 	}
+
+	_, err := this.writer.WriteLevel(entry.Level, []byte(fmt.Sprintf("[%-5.5s] (%s): %s", strings.ToUpper(entry.Level.String()), name, entry.Message)))
+	return err
 }
 
 /**
@@ -73,6 +179,9 @@ func (f *MyFormatter) Format(entry *logrus.Entry) ([]byte, error) {
  * Add logger name as field var
  */
 func For(name string) *logrus.Entry {
+	if logger := loggerFor(name); logger != nil {
+		return logger.WithField("name", name)
+	}
 	return logrus.WithField("name", name)
 }
 
@@ -0,0 +1,88 @@
+/**
+ * output.go - log outputs
+ */
+
+package logging
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+/**
+ * Options of output
+ */
+type OutputOptions struct {
+
+	/* Syslog tag (app name) */
+	Tag string
+
+	/* Syslog facility name, "daemon" if empty */
+	Facility string
+
+	/* Rotate file when it exceeds size in bytes, 0 disables */
+	MaxSize int64
+
+	/* Rotate file when it is older than age, 0 disables */
+	MaxAge time.Duration
+
+	/* Number of rotated files to keep, 0 keeps all */
+	MaxBackups int
+}
+
+/**
+ * Output writing lines with severity of log level, i.e. syslog
+ */
+type LevelWriter interface {
+	io.WriteCloser
+	WriteLevel(level logrus.Level, p []byte) (int, error)
+}
+
+/**
+ * Output that is not closed, for stdout and stderr
+ */
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+/**
+ * Opens output to write log lines to:
+ * "stdout", "stderr", "syslog" (local syslog), "syslog+udp://host:port" or
+ * "syslog+tcp://host:port" (remote RFC5424 syslog), "eventlog" (windows event log)
+ * or file path to append to
+ */
+func OpenOutput(output string, opts OutputOptions) (io.WriteCloser, error) {
+
+	switch output {
+	case "", "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	case "syslog":
+		return openSyslog(opts)
+	case "eventlog":
+		return openEventlog(opts)
+	}
+
+	if strings.HasPrefix(output, "syslog+udp://") {
+		return openRemoteSyslog("udp", strings.TrimPrefix(output, "syslog+udp://"), opts)
+	}
+
+	if strings.HasPrefix(output, "syslog+tcp://") {
+		return openRemoteSyslog("tcp", strings.TrimPrefix(output, "syslog+tcp://"), opts)
+	}
+
+	if opts.MaxSize > 0 || opts.MaxAge > 0 {
+		return openRotatingFile(output, opts)
+	}
+
+	return os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
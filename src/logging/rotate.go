@@ -0,0 +1,189 @@
+/**
+ * rotate.go - file output rotated by size and age
+ */
+
+package logging
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * Time layout of rotated file suffix
+ */
+const rotatedTimeLayout = "20060102-150405.000000000"
+
+/**
+ * File output that is renamed to <path>.<time> and reopened
+ * when exceeds max size or max age
+ */
+type rotatingFile struct {
+	sync.Mutex
+
+	path string
+	opts OutputOptions
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+/**
+ * Opens rotating file output
+ */
+func openRotatingFile(path string, opts OutputOptions) (io.WriteCloser, error) {
+
+	r := &rotatingFile{
+		path: path,
+		opts: opts,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+/**
+ * Writes p, rotating file before if needed
+ */
+func (this *rotatingFile) Write(p []byte) (int, error) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.file == nil {
+		if err := this.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if this.needsRotate(int64(len(p))) {
+		if err := this.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := this.file.Write(p)
+	this.size += int64(n)
+
+	return n, err
+}
+
+/**
+ * Closes current file
+ */
+func (this *rotatingFile) Close() error {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.file == nil {
+		return nil
+	}
+
+	err := this.file.Close()
+	this.file = nil
+
+	return err
+}
+
+/**
+ * Opens file for append, taking it's size and modification time
+ * as open time, so age is kept across restarts
+ */
+func (this *rotatingFile) open() error {
+
+	f, err := os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	this.file = f
+	this.size = info.Size()
+	this.opened = time.Now()
+
+	if info.Size() > 0 && info.ModTime().Before(this.opened) {
+		this.opened = info.ModTime()
+	}
+
+	return nil
+}
+
+/**
+ * Checks if writing n more bytes requires rotation
+ */
+func (this *rotatingFile) needsRotate(n int64) bool {
+
+	if this.size == 0 {
+		return false
+	}
+
+	if this.opts.MaxSize > 0 && this.size+n > this.opts.MaxSize {
+		return true
+	}
+
+	if this.opts.MaxAge > 0 && time.Since(this.opened) > this.opts.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+/**
+ * Renames current file, opens new one and removes old rotated files
+ */
+func (this *rotatingFile) rotate() error {
+
+	if err := this.file.Close(); err != nil {
+		return err
+	}
+	this.file = nil
+
+	if err := os.Rename(this.path, this.path+"."+time.Now().Format(rotatedTimeLayout)); err != nil {
+		return err
+	}
+
+	if err := this.open(); err != nil {
+		return err
+	}
+
+	this.removeBackups()
+
+	return nil
+}
+
+/**
+ * Removes oldest rotated files exceeding max backups
+ */
+func (this *rotatingFile) removeBackups() {
+
+	if this.opts.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(this.path + ".*")
+	if err != nil {
+		return
+	}
+
+	// Time layout sorts lexicographically
+	sort.Strings(backups)
+
+	for len(backups) > this.opts.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
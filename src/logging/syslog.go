@@ -0,0 +1,70 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/**
+ * syslog.go - local syslog output
+ */
+
+package logging
+
+import (
+	"log/syslog"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+/**
+ * Local syslog writer
+ */
+type localSyslog struct {
+	*syslog.Writer
+}
+
+/**
+ * Opens local syslog output
+ */
+func openSyslog(opts OutputOptions) (LevelWriter, error) {
+
+	facility, err := syslogFacility(opts.Facility)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := syslog.New(syslog.Priority(facility*8)|syslog.LOG_INFO, opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localSyslog{w}, nil
+}
+
+/**
+ * Writes p with severity of level
+ */
+func (this *localSyslog) WriteLevel(level logrus.Level, p []byte) (int, error) {
+
+	msg := strings.TrimRight(string(p), "\n")
+
+	var err error
+	switch level {
+	case logrus.PanicLevel:
+		err = this.Alert(msg)
+	case logrus.FatalLevel:
+		err = this.Crit(msg)
+	case logrus.ErrorLevel:
+		err = this.Err(msg)
+	case logrus.WarnLevel:
+		err = this.Warning(msg)
+	case logrus.InfoLevel:
+		err = this.Info(msg)
+	default:
+		err = this.Debug(msg)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
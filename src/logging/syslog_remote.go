@@ -0,0 +1,229 @@
+/**
+ * syslog_remote.go - remote syslog output (RFC5424)
+ */
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	remoteSyslogTimeout = 5 * time.Second
+)
+
+/**
+ * Syslog facility codes by name
+ */
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+/**
+ * Returns syslog facility code by name, "daemon" if empty
+ */
+func syslogFacility(name string) (int, error) {
+
+	if name == "" {
+		name = "daemon"
+	}
+
+	facility, ok := syslogFacilities[name]
+	if !ok {
+		return 0, errors.New("Unknown syslog facility " + name)
+	}
+
+	return facility, nil
+}
+
+/**
+ * Returns syslog severity of log level
+ */
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 1 // alert
+	case logrus.FatalLevel:
+		return 2 // crit
+	case logrus.ErrorLevel:
+		return 3 // err
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+/**
+ * Remote syslog writer sending RFC5424 messages over udp
+ * or over tcp using octet counting framing (RFC6587)
+ */
+type remoteSyslog struct {
+	sync.Mutex
+
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+
+	conn net.Conn
+}
+
+/**
+ * Opens remote syslog output
+ */
+func openRemoteSyslog(network string, address string, opts OutputOptions) (LevelWriter, error) {
+
+	facility, err := syslogFacility(opts.Facility)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	tag := opts.Tag
+	if tag == "" {
+		tag = "-"
+	}
+
+	s := &remoteSyslog{
+		network:  network,
+		address:  address,
+		facility: facility,
+		tag:      tag,
+		hostname: hostname,
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/**
+ * Writes p with info severity
+ */
+func (this *remoteSyslog) Write(p []byte) (int, error) {
+	return this.WriteLevel(logrus.InfoLevel, p)
+}
+
+/**
+ * Writes p as one message with severity of level, reconnecting once on failure
+ */
+func (this *remoteSyslog) WriteLevel(level logrus.Level, p []byte) (int, error) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	msg := this.format(level, p)
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if this.conn == nil {
+			if err = this.connect(); err != nil {
+				continue
+			}
+		}
+
+		if _, err = this.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+
+		this.conn.Close()
+		this.conn = nil
+	}
+
+	return 0, err
+}
+
+/**
+ * Closes connection
+ */
+func (this *remoteSyslog) Close() error {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.conn == nil {
+		return nil
+	}
+
+	err := this.conn.Close()
+	this.conn = nil
+
+	return err
+}
+
+/**
+ * Connects to remote syslog
+ */
+func (this *remoteSyslog) connect() error {
+
+	conn, err := net.DialTimeout(this.network, this.address, remoteSyslogTimeout)
+	if err != nil {
+		return err
+	}
+
+	this.conn = conn
+
+	return nil
+}
+
+/**
+ * Formats RFC5424 message:
+ * <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+ */
+func (this *remoteSyslog) format(level logrus.Level, p []byte) []byte {
+
+	b := &bytes.Buffer{}
+
+	fmt.Fprintf(b, "<%d>1 %s %s %s %d - - %s",
+		this.facility*8+syslogSeverity(level),
+		time.Now().Format(time.RFC3339Nano),
+		this.hostname,
+		this.tag,
+		os.Getpid(),
+		strings.TrimRight(string(p), "\n"),
+	)
+
+	if this.network != "tcp" {
+		return b.Bytes()
+	}
+
+	return append([]byte(fmt.Sprintf("%d ", b.Len())), b.Bytes()...)
+}
@@ -0,0 +1,16 @@
+/**
+ * syslog_windows.go - local syslog output is not available on windows
+ */
+
+package logging
+
+import (
+	"errors"
+)
+
+/**
+ * Local syslog is not supported on windows, remote syslog should be used
+ */
+func openSyslog(opts OutputOptions) (LevelWriter, error) {
+	return nil, errors.New("syslog output is not supported on windows, use syslog+udp:// or syslog+tcp://")
+}
@@ -6,16 +6,28 @@ package main
 
 import (
 	"./api"
+	"./cluster"
 	"./cmd"
 	"./config"
+	"./debug"
+	"./events"
+	"./ha"
 	"./info"
 	"./logging"
 	"./manager"
+	"./metrics"
+	"./tracing"
+	"./utils"
 	"./utils/codec"
+	"./utils/systemd"
+	"./utils/upgrade"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -64,18 +76,97 @@ func main() {
 	}
 
 	// Process flags and start
-	cmd.Execute(func(cfg *config.Config) {
+	cmd.Execute(func(cfg *config.Config, load cmd.ConfigLoader, save cmd.ConfigSaver, watch cmd.ConfigWatcher) {
 
 		// Configure logging
-		logging.Configure(cfg.Logging.Output, cfg.Logging.Level)
+		logging.Configure(cfg.Logging)
 
 		// Start API
-		go api.Start((*cfg).Api)
+		api.Start((*cfg).Api)
 
-		// Start manager
-		go manager.Initialize(*cfg)
+		// Start metrics server
+		metrics.Start((*cfg).Metrics)
 
-		// block forever
-		<-(chan string)(nil)
+		// Start debug server if enabled
+		debug.Start((*cfg).Debug)
+
+		// Start high availability node if enabled, releasing virtual ips on termination
+		ha.Start((*cfg).Ha, func() (bool, bool) {
+			readiness := manager.GetReadiness()
+			return readiness.Ready, readiness.Starting
+		})
+
+		if cfg.Ha.Enabled {
+			go func() {
+				sigterm := make(chan os.Signal, 1)
+				signal.Notify(sigterm, syscall.SIGTERM, syscall.SIGINT)
+				<-sigterm
+				ha.Release()
+				os.Exit(0)
+			}()
+		}
+
+		// Start manager, notifying old process on upgrade and systemd when servers are started
+		go func() {
+			manager.Initialize(*cfg, load, save)
+			if upgrade.Ready() {
+				systemd.Notify("MAINPID=" + strconv.Itoa(os.Getpid()))
+			}
+			systemd.Notify("READY=1")
+		}()
+
+		// Upgrade to new binary on SIGUSR2, draining and exiting when it has taken over
+		go func() {
+			sigusr2 := make(chan os.Signal, 1)
+			upgrade.Notify(sigusr2)
+
+			for range sigusr2 {
+				log := logging.For("main")
+				log.Info("Upgrading")
+
+				if err := upgrade.Start(utils.ParseDurationOrDefault(cfg.Upgrade.ReadyTimeout, time.Minute)); err != nil {
+					log.Error("Upgrade failed: ", err)
+					continue
+				}
+
+				log.Info("Upgraded to pid ", upgrade.GetStatus().Pid, ", draining")
+
+				api.Stop()
+				metrics.Stop()
+				debug.Stop()
+				ha.Stop()
+				cluster.Global.Stop()
+				manager.DrainAll()
+				tracing.Global.Stop()
+				events.Global.Stop()
+
+				log.Info("Drained, exiting")
+				os.Exit(0)
+			}
+		}()
+
+		// Keep systemd watchdog, if enabled, notified
+		go systemd.Watchdog(nil)
+
+		reload := func() {
+			systemd.Notify("RELOADING=1")
+			if err := manager.Reload(); err != nil {
+				logging.For("main").Error("Reload failed: ", err)
+			}
+			systemd.Notify("READY=1")
+		}
+
+		// Reload configuration when it's changed in source, if watched
+		if watch != nil {
+			go watch(reload)
+		}
+
+		// Reload configuration on SIGHUP, blocking forever
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+
+		for range sighup {
+			reload()
+		}
 	})
 }
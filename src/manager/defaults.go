@@ -0,0 +1,182 @@
+/**
+ * defaults.go - inheriting [defaults] section by servers
+ */
+package manager
+
+import (
+	"../config"
+)
+
+/**
+ * Applies defaults section to server: balance, healthcheck and tls
+ * it does not set are taken from defaults, empty options of
+ * healthcheck and tls it sets are filled from defaults ones
+ */
+func applyDefaults(server config.Server, defaults config.DefaultsConfig) config.Server {
+
+	if server.Balance == "" {
+		server.Balance = defaults.Balance
+	}
+
+	if defaults.Healthcheck != nil {
+		if server.Healthcheck == nil {
+			server.Healthcheck = copyHealthcheck(defaults.Healthcheck)
+		} else {
+			server.Healthcheck = mergeHealthcheck(*server.Healthcheck, defaults.Healthcheck)
+		}
+	}
+
+	// Tls defaults are meaningful only for servers terminating tls
+	if defaults.Tls != nil && (server.Protocol == "tls" || server.Protocol == "dtls" || server.Protocol == "quic") {
+		if server.Tls == nil {
+			tls := *defaults.Tls
+			server.Tls = &tls
+		} else {
+			server.Tls = mergeTls(*server.Tls, defaults.Tls)
+		}
+	}
+
+	return server
+}
+
+/**
+ * Fills empty options of server healthcheck from defaults. Kind specific
+ * options are inherited only if healthcheck is of the same kind and sets none of them
+ */
+func mergeHealthcheck(healthcheck config.HealthcheckConfig, defaults *config.HealthcheckConfig) *config.HealthcheckConfig {
+
+	if healthcheck.Kind == "" {
+		healthcheck.Kind = defaults.Kind
+	}
+
+	if healthcheck.Interval == "" {
+		healthcheck.Interval = defaults.Interval
+	}
+
+	if healthcheck.Timeout == "" {
+		healthcheck.Timeout = defaults.Timeout
+	}
+
+	if healthcheck.Passes == 0 {
+		healthcheck.Passes = defaults.Passes
+	}
+
+	if healthcheck.Fails == 0 {
+		healthcheck.Fails = defaults.Fails
+	}
+
+	if healthcheck.Jitter == "" {
+		healthcheck.Jitter = defaults.Jitter
+	}
+
+	if healthcheck.PassiveFails == 0 {
+		healthcheck.PassiveFails = defaults.PassiveFails
+	}
+
+	if healthcheck.HistorySize == 0 {
+		healthcheck.HistorySize = defaults.HistorySize
+	}
+
+	if healthcheck.Kind == defaults.Kind {
+		inherited := copyHealthcheck(defaults)
+
+		if healthcheck.PingHealthcheckConfig == nil {
+			healthcheck.PingHealthcheckConfig = inherited.PingHealthcheckConfig
+		}
+		if healthcheck.ExecHealthcheckConfig == nil {
+			healthcheck.ExecHealthcheckConfig = inherited.ExecHealthcheckConfig
+		}
+		if healthcheck.HttpHealthcheckConfig == nil {
+			healthcheck.HttpHealthcheckConfig = inherited.HttpHealthcheckConfig
+		}
+		if healthcheck.ConnectHealthcheckConfig == nil {
+			healthcheck.ConnectHealthcheckConfig = inherited.ConnectHealthcheckConfig
+		}
+		if healthcheck.TlsHealthcheckConfig == nil {
+			healthcheck.TlsHealthcheckConfig = inherited.TlsHealthcheckConfig
+		}
+		if healthcheck.UdpHealthcheckConfig == nil {
+			healthcheck.UdpHealthcheckConfig = inherited.UdpHealthcheckConfig
+		}
+	}
+
+	return &healthcheck
+}
+
+/**
+ * Copies healthcheck with its kind specific options,
+ * so preparing server config does not change defaults
+ */
+func copyHealthcheck(healthcheck *config.HealthcheckConfig) *config.HealthcheckConfig {
+
+	result := *healthcheck
+
+	if healthcheck.PingHealthcheckConfig != nil {
+		ping := *healthcheck.PingHealthcheckConfig
+		result.PingHealthcheckConfig = &ping
+	}
+	if healthcheck.ExecHealthcheckConfig != nil {
+		exec := *healthcheck.ExecHealthcheckConfig
+		result.ExecHealthcheckConfig = &exec
+	}
+	if healthcheck.HttpHealthcheckConfig != nil {
+		http := *healthcheck.HttpHealthcheckConfig
+		result.HttpHealthcheckConfig = &http
+	}
+	if healthcheck.ConnectHealthcheckConfig != nil {
+		connect := *healthcheck.ConnectHealthcheckConfig
+		result.ConnectHealthcheckConfig = &connect
+	}
+	if healthcheck.TlsHealthcheckConfig != nil {
+		tls := *healthcheck.TlsHealthcheckConfig
+		result.TlsHealthcheckConfig = &tls
+	}
+	if healthcheck.UdpHealthcheckConfig != nil {
+		udp := *healthcheck.UdpHealthcheckConfig
+		result.UdpHealthcheckConfig = &udp
+	}
+
+	return &result
+}
+
+/**
+ * Fills empty options of server tls from defaults. Certificate and key
+ * are inherited together, flags are inherited only by servers without tls section
+ */
+func mergeTls(tls config.Tls, defaults *config.Tls) *config.Tls {
+
+	if tls.CertPath == "" && tls.KeyPath == "" {
+		tls.CertPath = defaults.CertPath
+		tls.KeyPath = defaults.KeyPath
+	}
+
+	if len(tls.Ciphers) == 0 {
+		tls.Ciphers = defaults.Ciphers
+	}
+
+	if tls.MinVersion == "" {
+		tls.MinVersion = defaults.MinVersion
+	}
+
+	if tls.MaxVersion == "" {
+		tls.MaxVersion = defaults.MaxVersion
+	}
+
+	if tls.ReloadInterval == "" {
+		tls.ReloadInterval = defaults.ReloadInterval
+	}
+
+	if len(tls.Alpn) == 0 {
+		tls.Alpn = defaults.Alpn
+	}
+
+	if len(tls.Certificates) == 0 {
+		tls.Certificates = defaults.Certificates
+	}
+
+	if tls.ClientAuth == nil {
+		tls.ClientAuth = defaults.ClientAuth
+	}
+
+	return &tls
+}
@@ -6,44 +6,104 @@
 package manager
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
+	"net"
 	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"../balance"
+	"../cluster"
 	"../config"
 	"../core"
+	"../discovery"
+	"../events"
+	"../healthcheck"
 	"../logging"
 	"../server"
+	"../server/modules/connlimit"
+	"../server/modules/script"
+	"../server/scheduler"
+	"../server/tcp"
+	"../tracing"
+	"../utils/backendbind"
 	"../utils/codec"
+	"../utils/geoip"
+	"../utils/systemd"
+	"../utils/unixsocket"
 )
 
-/* Map of app current servers */
+/* Map of app current servers, configured ones that failed to start, names of configuration source ones,
+ * and names of ones being started or restarted, that are not locked while they are stopped and started */
 var servers = struct {
 	sync.RWMutex
-	m map[string]core.Server
-}{m: make(map[string]core.Server)}
+	m          map[string]core.Server
+	failed     map[string]bool
+	configured map[string]bool
+	pending    map[string]bool
+}{m: make(map[string]core.Server), failed: make(map[string]bool), configured: make(map[string]bool), pending: make(map[string]bool)}
 
 /* default configuration for server */
-var defaults config.ConnectionOptions
+var defaults config.DefaultsConfig
 
 /* original cfg read from the file */
 var originalCfg config.Config
 
+/* loads config again from the original source on reload */
+var configLoader func() (*config.Config, error)
+
+/* saves config back to the original source, nil if not supported */
+var configSaver func(config.Config) error
+
+/* serializes concurrent reloads */
+var reloadMutex sync.Mutex
+
 /**
  * Initialize manager from the initial/default configuration
  */
-func Initialize(cfg config.Config) {
+func Initialize(cfg config.Config, loader func() (*config.Config, error), saver func(config.Config) error) {
 
 	log := logging.For("manager")
 	log.Info("Initializing...")
 
 	originalCfg = cfg
+	configLoader = loader
+	configSaver = saver
 
 	// save defaults for futher reuse
 	defaults = cfg.Defaults
 
+	connlimit.Global.SetMax(cfg.Limits.MaxConnections)
+
+	if err := balance.LoadPlugins(cfg.Plugins.Balancers); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := geoip.Global.Configure(cfg.Geoip); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := tracing.Global.Configure(cfg.Tracing); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := events.Global.Configure(cfg.Events); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := cluster.Global.Configure(cfg.Cluster); err != nil {
+		log.Fatal(err)
+	}
+
 	// Go through config and start servers for each server
 	for name, serverCfg := range cfg.Servers {
 		err := Create(name, serverCfg)
@@ -52,31 +112,309 @@ func Initialize(cfg config.Config) {
 		}
 	}
 
+	servers.Lock()
+	for name := range cfg.Servers {
+		servers.configured[name] = true
+	}
+	servers.Unlock()
+
+	atomic.StoreInt32(&initialized, 1)
+
 	log.Info("Initialized")
 }
 
 /**
- * Dumps current [servers] section to
- * the config file
+ * Reload configuration from the original source and apply
+ * changes to [servers] section. New servers are started, removed
+ * ones are stopped, and changed ones are restarted with new
+ * configuration, restoring previous one if new fails to start.
+ * Servers that are not changed, and ones created in runtime
+ * that are not in the source, are not touched. Everything is
+ * validated before anything is applied.
  */
-func DumpConfig(format string) (string, error) {
+func Reload() error {
 
-	originalCfg.Servers = map[string]config.Server{}
+	log := logging.For("manager")
 
-	servers.RLock()
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if configLoader == nil {
+		return errors.New("Reload is not supported for current configuration source")
+	}
+
+	log.Info("Reloading configuration...")
+
+	cfg, err := configLoader()
+	if err != nil {
+		return err
+	}
+
+	/* Balancers of new plugins are needed to validate servers using them */
+	if err := balance.LoadPlugins(cfg.Plugins.Balancers); err != nil {
+		return errors.New("plugins: " + err.Error())
+	}
+
+	/* Validate all servers before applying anything */
+	prepared := map[string]config.Server{}
+	for name, serverCfg := range cfg.Servers {
+		c, err := prepareConfig(name, serverCfg, cfg.Defaults)
+		if err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+		prepared[name] = c
+	}
+
+	if err := tracing.Validate(cfg.Tracing); err != nil {
+		return errors.New("tracing: " + err.Error())
+	}
+
+	if err := events.Validate(cfg.Events); err != nil {
+		return errors.New("events: " + err.Error())
+	}
+
+	if err := cluster.Validate(cfg.Cluster); err != nil {
+		return errors.New("cluster: " + err.Error())
+	}
+
+	if err := configureGlobals(*cfg); err != nil {
+		return err
+	}
+
+	servers.Lock()
+
+	/* Original config is guarded by servers lock, as it's read with them */
+	originalCfg.Plugins = cfg.Plugins
+	originalCfg.Geoip = cfg.Geoip
+	originalCfg.Tracing = cfg.Tracing
+	originalCfg.Events = cfg.Events
+	originalCfg.Cluster = cfg.Cluster
+
+	defaults = cfg.Defaults
+	originalCfg.Defaults = cfg.Defaults
+
+	connlimit.Global.SetMax(cfg.Limits.MaxConnections)
+	originalCfg.Limits = cfg.Limits
+
+	/* Changed and removed servers are taken out of servers, and are stopped and started
+	 * unlocked, as they are drained. Servers created in runtime that are not in the source are kept */
+	changed := map[string]core.Server{}
+	removed := map[string]core.Server{}
 	for name, server := range servers.m {
-		originalCfg.Servers[name] = server.Cfg()
+		c, ok := prepared[name]
+		if ok && reflect.DeepEqual(c, server.Cfg()) {
+			delete(prepared, name)
+			continue
+		}
+
+		if ok {
+			changed[name] = server
+			delete(servers.m, name)
+			continue
+		}
+
+		if !servers.configured[name] {
+			continue
+		}
+
+		removed[name] = server
+		delete(servers.m, name)
+	}
+
+	var failed []string
+	for name := range prepared {
+		if servers.pending[name] {
+			log.Error("Failed to start server ", name, ": server with this name is being started")
+			failed = append(failed, name)
+			delete(prepared, name)
+			continue
+		}
+		servers.pending[name] = true
+	}
+
+	servers.configured = map[string]bool{}
+	for name := range cfg.Servers {
+		servers.configured[name] = true
+	}
+
+	for name := range servers.failed {
+		if !servers.configured[name] {
+			delete(servers.failed, name)
+		}
+	}
+
+	servers.Unlock()
+
+	var stopping sync.WaitGroup
+	for name, server := range removed {
+		log.Info("Server removed, stopping: ", name)
+
+		stopping.Add(1)
+		go func(name string, server core.Server) {
+			server.Stop()
+			publish(events.SERVER_STOPPED, name)
+			stopping.Done()
+		}(name, server)
+	}
+
+	/* Removed servers release their listeners before new ones are started */
+	stopping.Wait()
+
+	for name, c := range prepared {
+
+		if old, ok := changed[name]; ok {
+			log.Info("Server changed, restarting: ", name)
+			server, err := restart(name, old, c)
+			if err != nil {
+				failed = append(failed, name)
+			}
+			finishPending(name, server)
+			continue
+		}
+
+		server, err := start(name, c)
+		if err != nil {
+			log.Error("Failed to start server ", name, ": ", err)
+			failed = append(failed, name)
+		}
+		finishPending(name, server)
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("Failed to start servers: " + strings.Join(failed, ", "))
+	}
+
+	log.Info("Reloaded")
+
+	return nil
+}
+
+/**
+ * Applies geoip, tracing, events and cluster configuration. If any of
+ * them fails, ones already applied are restored to previous configuration
+ */
+func configureGlobals(cfg config.Config) error {
+
+	log := logging.For("manager")
+
+	steps := []struct {
+		name      string
+		configure func(config.Config) error
+	}{
+		{"geoip", func(c config.Config) error { return geoip.Global.Configure(c.Geoip) }},
+		{"tracing", func(c config.Config) error { return tracing.Global.Configure(c.Tracing) }},
+		{"events", func(c config.Config) error { return events.Global.Configure(c.Events) }},
+		{"cluster", func(c config.Config) error { return cluster.Global.Configure(c.Cluster) }},
+	}
+
+	for i, step := range steps {
+		err := step.configure(cfg)
+		if err == nil {
+			continue
+		}
+
+		for j := i - 1; j >= 0; j-- {
+			if restoreErr := steps[j].configure(originalCfg); restoreErr != nil {
+				log.Error("Failed to restore ", steps[j].name, " configuration: ", restoreErr)
+			}
+		}
+
+		return errors.New(step.name + ": " + err.Error())
+	}
+
+	return nil
+}
+
+/**
+ * Dumps current [servers] section to
+ * the config file, with secrets replaced if redacted
+ */
+func DumpConfig(format string, redacted bool) (string, error) {
+
+	cfg := currentConfig()
+	if redacted {
+		cfg = cfg.Redacted()
 	}
-	servers.RUnlock()
 
 	var out *string = new(string)
-	if err := codec.Encode(originalCfg, out, format); err != nil {
+	if err := codec.Encode(cfg, out, format); err != nil {
 		return "", err
 	}
 
 	return *out, nil
 }
 
+/**
+ * Dumps effective configuration of server, with defaults
+ * applied and changes made in runtime, with secrets replaced if redacted
+ */
+func DumpServerConfig(name string, format string, redacted bool) (string, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return "", errors.New("Server not found")
+	}
+
+	cfg := server.Cfg()
+	if redacted {
+		cfg = cfg.Redacted()
+	}
+
+	var out string
+	if err := codec.Encode(cfg, &out, format); err != nil {
+		return "", err
+	}
+
+	return out, nil
+}
+
+/**
+ * Saves current [servers] section back to the
+ * configuration source app was started with
+ */
+func Persist() error {
+
+	if configSaver == nil {
+		return errors.New("Persisting is not supported for current configuration source")
+	}
+
+	cfg := currentConfig()
+	if err := configSaver(cfg); err != nil {
+		return err
+	}
+
+	/* Saved servers are in the source now, so reload treats them as such */
+	servers.Lock()
+	for name := range cfg.Servers {
+		servers.configured[name] = true
+	}
+	servers.Unlock()
+
+	return nil
+}
+
+/**
+ * Returns copy of original config with current servers
+ */
+func currentConfig() config.Config {
+
+	servers.RLock()
+	defer servers.RUnlock()
+
+	cfg := originalCfg
+	cfg.Servers = map[string]config.Server{}
+
+	for name, server := range servers.m {
+		cfg.Servers[name] = server.Cfg()
+	}
+
+	return cfg
+}
+
 /**
  * Returns map of servers with configurations
  */
@@ -114,116 +452,862 @@ func Get(name string) interface{} {
 func Create(name string, cfg config.Server) error {
 
 	servers.Lock()
-	defer servers.Unlock()
 
-	if _, ok := servers.m[name]; ok {
+	if _, ok := servers.m[name]; ok || servers.pending[name] {
+		servers.Unlock()
 		return errors.New("Server with this name already exists: " + name)
 	}
 
 	c, err := prepareConfig(name, cfg, defaults)
 	if err != nil {
+		servers.Unlock()
 		return err
 	}
 
-	server, err := server.New(name, c)
-	if err != nil {
-		return err
+	servers.pending[name] = true
+	servers.Unlock()
+
+	server, err := start(name, c)
+
+	servers.Lock()
+	delete(servers.pending, name)
+	if err == nil {
+		servers.m[name] = server
+		delete(servers.failed, name)
 	}
+	servers.Unlock()
 
-	if err = server.Start(); err != nil {
-		return err
+	return err
+}
+
+/**
+ * Creates and starts server. Its goroutines are labeled with
+ * server name, so they are counted per server in goroutine profile
+ */
+func start(name string, cfg config.Server) (s core.Server, err error) {
+
+	pprof.Do(context.Background(), pprof.Labels("server", name), func(context.Context) {
+		if s, err = server.New(name, cfg); err == nil {
+			err = s.Start()
+		}
+	})
+
+	if err == nil {
+		publish(events.SERVER_STARTED, name)
 	}
 
-	servers.m[name] = server
+	return s, err
+}
 
-	return nil
+/**
+ * Publishes event of server
+ */
+func publish(eventType string, name string) {
+	events.Global.Publish(events.Event{Type: eventType, Server: name})
 }
 
 /**
- * Delete server stopping all active connections
+ * Update existing server configuration restarting it.
+ * If new configuration fails to start, previous one is restored
  */
-func Delete(name string) error {
+func Update(name string, cfg config.Server) error {
+
+	c, err := prepareConfig(name, cfg, defaults)
+	if err != nil {
+		return err
+	}
 
 	servers.Lock()
-	defer servers.Unlock()
 
-	server, ok := servers.m[name]
+	old, ok := servers.m[name]
 	if !ok {
+		servers.Unlock()
+		if isPending(name) {
+			return errors.New("Server is being restarted")
+		}
 		return errors.New("Server not found")
 	}
 
-	server.Stop()
 	delete(servers.m, name)
+	servers.pending[name] = true
+	servers.Unlock()
 
-	return nil
+	server, err := restart(name, old, c)
+	finishPending(name, server)
+
+	return err
 }
 
 /**
- * Returns stats for the server
+ * Checks if server is being started or restarted
  */
-func Stats(name string) interface{} {
+func isPending(name string) bool {
 
-	servers.Lock()
-	server := servers.m[name]
-	servers.Unlock()
+	servers.RLock()
+	defer servers.RUnlock()
 
-	return server
+	return servers.pending[name]
 }
 
 /**
- * Prepare config (merge default configuration, and try to validate)
- * TODO: make validation better
+ * Stops server and starts it with new configuration, restoring previous
+ * one if it fails to start. Returns started server, nil if restoring failed
+ * too. Should be called with server taken out of servers and marked pending,
+ * but not locked, as server is drained while stopping
  */
-func prepareConfig(name string, server config.Server, defaults config.ConnectionOptions) (config.Server, error) {
+func restart(name string, old core.Server, cfg config.Server) (core.Server, error) {
 
-	/* ----- Prerequisites ----- */
+	log := logging.For("manager")
 
-	if server.Bind == "" {
-		return config.Server{}, errors.New("No bind specified")
-	}
+	old.Stop()
+	publish(events.SERVER_STOPPED, name)
 
-	if server.Discovery == nil {
-		return config.Server{}, errors.New("No .discovery specified")
-	}
+	updated, err := start(name, cfg)
 
-	if server.Healthcheck == nil {
-		server.Healthcheck = &config.HealthcheckConfig{
-			Kind:     "none",
-			Interval: "0",
-			Timeout:  "0",
-		}
+	if err == nil {
+		return updated, nil
 	}
 
-	switch server.Healthcheck.Kind {
-	case
-		"ping",
-		"exec",
-		"none":
-	default:
-		return config.Server{}, errors.New("Not supported healthcheck type " + server.Healthcheck.Kind)
-	}
+	log.Warn("Failed to start updated server ", name, ", restoring previous configuration: ", err)
 
-	if server.Healthcheck.Interval == "" {
-		server.Healthcheck.Interval = "0"
+	restored, restoreErr := start(name, old.Cfg())
+
+	if restoreErr != nil {
+		log.Error("Failed to restore server ", name, ": ", restoreErr)
+		return nil, err
 	}
 
-	if server.Healthcheck.Timeout == "" {
-		server.Healthcheck.Timeout = "0"
+	return restored, err
+}
+
+/**
+ * Puts started pending server back to servers, or marks it failed if it's nil
+ */
+func finishPending(name string, server core.Server) {
+
+	servers.Lock()
+	defer servers.Unlock()
+
+	delete(servers.pending, name)
+
+	if server == nil {
+		servers.failed[name] = true
+		return
 	}
 
-	if server.Healthcheck.Fails <= 0 {
-		server.Healthcheck.Fails = 1
+	servers.m[name] = server
+	delete(servers.failed, name)
+}
+
+/**
+ * Delete server stopping all active connections
+ * (after drain_timeout if configured)
+ */
+func Delete(name string) error {
+
+	servers.Lock()
+	server, ok := servers.m[name]
+	delete(servers.m, name)
+	delete(servers.failed, name)
+	pending := servers.pending[name]
+	servers.Unlock()
+
+	if !ok && pending {
+		return errors.New("Server is being restarted")
 	}
 
-	if server.Healthcheck.Passes <= 0 {
-		server.Healthcheck.Passes = 1
+	if !ok {
+		return errors.New("Server not found")
 	}
 
-	if server.Sni != nil {
+	server.Stop()
+	publish(events.SERVER_STOPPED, name)
 
-		if server.Sni.ReadTimeout == "" {
-			server.Sni.ReadTimeout = "2s"
-		}
+	return nil
+}
+
+/**
+ * Delete server gracefully, letting active connections
+ * to finish up to timeout. Empty timeout means server drain_timeout
+ */
+func Drain(name string, timeout string) error {
+
+	var d time.Duration
+	var err error
+
+	if timeout != "" {
+		if d, err = time.ParseDuration(timeout); err != nil {
+			return errors.New("timeout parsing error")
+		}
+	}
+
+	servers.Lock()
+	server, ok := servers.m[name]
+	delete(servers.m, name)
+	delete(servers.failed, name)
+	pending := servers.pending[name]
+	servers.Unlock()
+
+	if !ok && pending {
+		return errors.New("Server is being restarted")
+	}
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	if timeout == "" {
+		d, _ = time.ParseDuration(*server.Cfg().DrainTimeout)
+	}
+
+	publish(events.SERVER_DRAINING, name)
+	server.Drain(d)
+	publish(events.SERVER_STOPPED, name)
+
+	return nil
+}
+
+/**
+ * Stops all servers, letting active connections of every server finish
+ * up to its drain_timeout, before process exits, i.e. after upgrade
+ */
+func DrainAll() {
+
+	atomic.StoreInt32(&draining, 1)
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	servers.Lock()
+	all := servers.m
+	servers.m = map[string]core.Server{}
+	servers.Unlock()
+
+	wg := sync.WaitGroup{}
+
+	for name, s := range all {
+		wg.Add(1)
+		go func(name string, s core.Server) {
+			defer wg.Done()
+			publish(events.SERVER_DRAINING, name)
+			s.Stop()
+			publish(events.SERVER_STOPPED, name)
+		}(name, s)
+	}
+
+	wg.Wait()
+}
+
+/**
+ * Returns server access configuration with current rules
+ */
+func GetAccess(name string) (*config.AccessConfig, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	return server.Cfg().Access, nil
+}
+
+/**
+ * Replace server access rules without restart
+ */
+func UpdateAccess(name string, cfg config.AccessConfig) error {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	updatable, ok := server.(interface {
+		UpdateAccess(*config.AccessConfig) error
+	})
+
+	if !ok {
+		return errors.New("Server does not support access update")
+	}
+
+	if cfg.Default == "" {
+		cfg.Default = "allow"
+	}
+
+	return updatable.UpdateAccess(&cfg)
+}
+
+/**
+ * Returns healthcheck history of server backends
+ */
+func HealthcheckHistory(name string) ([]healthcheck.TargetHistory, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	checked, ok := server.(interface {
+		HealthcheckHistory() []healthcheck.TargetHistory
+	})
+
+	if !ok {
+		return nil, errors.New("Server does not support healthcheck history")
+	}
+
+	return checked.HealthcheckHistory(), nil
+}
+
+/**
+ * Takes server backend "host:port" or "unix:path" out of rotation (drained = true), so it gets
+ * no new connections while active ones are finished, or enables it back
+ */
+func DrainBackend(name string, addr string, drained bool) error {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	target := core.Target{Host: addr}
+
+	if !target.IsUnix() {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		target = core.Target{Host: host, Port: port}
+	}
+
+	drainable, ok := server.(interface {
+		SetBackendDrained(core.Target, bool) error
+	})
+
+	if !ok {
+		return errors.New("Server does not support backend drain")
+	}
+
+	return drainable.SetBackendDrained(target, drained)
+}
+
+/**
+ * Returns page of server current connections sorted by "age" | "rx" | "tx" |
+ * "rx_second" | "tx_second" (descending if desc), and total connections count.
+ * Zero limit means all connections after offset
+ */
+func Connections(name string, sortBy string, desc bool, offset int, limit int) ([]core.Connection, int, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, 0, errors.New("Server not found")
+	}
+
+	listed, ok := server.(interface {
+		Connections() []core.Connection
+	})
+
+	if !ok {
+		return nil, 0, errors.New("Server does not support connections listing")
+	}
+
+	keys := map[string]func(c core.Connection) float64{
+		"age":       func(c core.Connection) float64 { return c.Age },
+		"rx":        func(c core.Connection) float64 { return float64(c.Rx) },
+		"tx":        func(c core.Connection) float64 { return float64(c.Tx) },
+		"rx_second": func(c core.Connection) float64 { return float64(c.RxSecond) },
+		"tx_second": func(c core.Connection) float64 { return float64(c.TxSecond) },
+	}
+
+	if sortBy == "" {
+		sortBy = "age"
+	}
+
+	key, ok := keys[sortBy]
+	if !ok {
+		return nil, 0, errors.New("Unknown sort " + sortBy)
+	}
+
+	if offset < 0 || limit < 0 {
+		return nil, 0, errors.New("offset and limit should not be negative")
+	}
+
+	connections := listed.Connections()
+
+	sort.SliceStable(connections, func(i, j int) bool {
+		if desc {
+			return key(connections[i]) > key(connections[j])
+		}
+		return key(connections[i]) < key(connections[j])
+	})
+
+	total := len(connections)
+
+	if offset > total {
+		offset = total
+	}
+	connections = connections[offset:]
+
+	if limit > 0 && limit < len(connections) {
+		connections = connections[:limit]
+	}
+
+	return connections, total, nil
+}
+
+/**
+ * Closes server client connection with id
+ */
+func KillConnection(name string, id uint64) error {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	killable, ok := server.(interface {
+		KillConnection(uint64) error
+	})
+
+	if !ok {
+		return errors.New("Server does not support connections listing")
+	}
+
+	return killable.KillConnection(id)
+}
+
+/**
+ * Returns server current connections counts of client ips, the most connected first
+ */
+func ClientConnections(name string) ([]connlimit.ClientCount, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	counted, ok := server.(interface {
+		ClientConnections() []connlimit.ClientCount
+	})
+
+	if !ok {
+		return nil, errors.New("Server does not count client connections")
+	}
+
+	return counted.ClientConnections(), nil
+}
+
+/**
+ * Server internal queues, for diagnostics
+ */
+type ServerState struct {
+
+	/* Current connections and ones queued over max_connections */
+	Connections int `json:"connections"`
+	Queued      int `json:"queued"`
+
+	/* Schedulers queues of backends pools */
+	Pools []scheduler.State `json:"pools"`
+}
+
+/**
+ * Returns internal queues of all servers
+ */
+func ServersState() map[string]ServerState {
+
+	servers.RLock()
+	defer servers.RUnlock()
+
+	result := make(map[string]ServerState, len(servers.m))
+
+	for name, server := range servers.m {
+		state := ServerState{Pools: []scheduler.State{}}
+
+		if queued, ok := server.(interface {
+			ConnectionsQueue() (int, int)
+		}); ok {
+			state.Connections, state.Queued = queued.ConnectionsQueue()
+		}
+
+		if pooled, ok := server.(interface {
+			Schedulers() []*scheduler.Scheduler
+		}); ok {
+			for _, s := range pooled.Schedulers() {
+				state.Pools = append(state.Pools, s.State())
+			}
+		}
+
+		result[name] = state
+	}
+
+	return result
+}
+
+/**
+ * Returns health of server discovery
+ */
+func DiscoveryHealth(name string) (*discovery.Health, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	discovered, ok := server.(interface {
+		DiscoveryHealth() discovery.Health
+	})
+
+	if !ok {
+		return nil, errors.New("Server does not support discovery health")
+	}
+
+	health := discovered.DiscoveryHealth()
+	return &health, nil
+}
+
+/**
+ * Returns server stick table entries
+ */
+func StickTable(name string) ([]scheduler.StickEntry, error) {
+
+	table, err := stickTable(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return table.Entries(), nil
+}
+
+/**
+ * Removes server stick table entry of client ip, or all entries if client is empty
+ */
+func FlushStickTable(name string, client string) error {
+
+	table, err := stickTable(name)
+	if err != nil {
+		return err
+	}
+
+	table.Flush(client)
+	return nil
+}
+
+/**
+ * Returns stick table of server, if it has one
+ */
+func stickTable(name string) (*scheduler.StickTable, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	sticky, ok := server.(interface {
+		StickTable() *scheduler.StickTable
+	})
+
+	if !ok || sticky.StickTable() == nil {
+		return nil, errors.New("Server has no stick table")
+	}
+
+	return sticky.StickTable(), nil
+}
+
+/**
+ * Returns server canary configuration with current weight
+ */
+func GetCanary(name string) (*config.CanaryConfig, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
+	}
+
+	canary := server.Cfg().Canary
+	if canary == nil {
+		return nil, errors.New("Server has no canary")
+	}
+
+	return canary, nil
+}
+
+/**
+ * Change percent of server connections taken by canary without restart
+ */
+func UpdateCanary(name string, weight int) error {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	updatable, ok := server.(interface {
+		UpdateCanary(int) error
+	})
+
+	if !ok {
+		return errors.New("Server does not support canary update")
+	}
+
+	return updatable.UpdateCanary(weight)
+}
+
+/**
+ * Reload server tls certificate from files
+ */
+func ReloadTls(name string) error {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return errors.New("Server not found")
+	}
+
+	reloadable, ok := server.(interface {
+		ReloadTls() error
+	})
+
+	if !ok {
+		return errors.New("Server does not support tls reload")
+	}
+
+	return reloadable.ReloadTls()
+}
+
+/**
+ * Returns stats for the server
+ */
+func Stats(name string) interface{} {
+
+	servers.Lock()
+	server := servers.m[name]
+	servers.Unlock()
+
+	return server
+}
+
+/**
+ * Prepare config (merge default configuration, and try to validate)
+ * TODO: make validation better
+ */
+func prepareConfig(name string, server config.Server, defaults config.DefaultsConfig) (config.Server, error) {
+
+	server = applyDefaults(server, defaults)
+
+	/* ----- Prerequisites ----- */
+
+	if len(server.Bind) == 0 {
+		return config.Server{}, errors.New("No bind specified")
+	}
+
+	for i, bind := range server.Bind {
+		if bind == "" {
+			return config.Server{}, errors.New("Empty bind specified")
+		}
+		for _, other := range server.Bind[:i] {
+			if bind == other {
+				return config.Server{}, errors.New("Bind " + bind + " is specified more than once")
+			}
+		}
+	}
+
+	if server.Discovery == nil {
+		return config.Server{}, errors.New("No .discovery specified")
+	}
+
+	/* Datagram protocols, sharing udp server implementation */
+	udp := server.Protocol == "udp" || server.Protocol == "dtls"
+
+	if server.Healthcheck == nil {
+		server.Healthcheck = &config.HealthcheckConfig{
+			Kind:     "none",
+			Interval: "0",
+			Timeout:  "0",
+		}
+	}
+
+	switch server.Healthcheck.Kind {
+	case
+		"ping",
+		"exec",
+		"http",
+		"connect",
+		"tls",
+		"udp",
+		"none":
+	default:
+		return config.Server{}, errors.New("Not supported healthcheck type " + server.Healthcheck.Kind)
+	}
+
+	if server.Healthcheck.Kind == "exec" {
+
+		if server.Healthcheck.ExecHealthcheckConfig == nil {
+			return config.Server{}, errors.New("healthcheck.exec_command is required")
+		}
+
+		switch server.Healthcheck.ExecOutputFormat {
+		case "":
+			server.Healthcheck.ExecOutputFormat = "text"
+		case "text", "json":
+		default:
+			return config.Server{}, errors.New("Not supported healthcheck.exec_output_format " + server.Healthcheck.ExecOutputFormat)
+		}
+	}
+
+	if server.Healthcheck.Kind == "connect" {
+
+		if server.Healthcheck.ConnectHealthcheckConfig == nil {
+			server.Healthcheck.ConnectHealthcheckConfig = &config.ConnectHealthcheckConfig{}
+		}
+
+		switch server.Healthcheck.ConnectProtocol {
+		case "":
+			server.Healthcheck.ConnectProtocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return config.Server{}, errors.New("Not supported healthcheck.connect_protocol " + server.Healthcheck.ConnectProtocol)
+		}
+
+		if server.Healthcheck.ConnectProtocol == "udp" && server.Healthcheck.ConnectSend == "" {
+			return config.Server{}, errors.New("healthcheck.connect_send is required for udp connect_protocol")
+		}
+
+		// Response is read up to timeout, so it's needed not to hang forever
+		if server.Healthcheck.ConnectProtocol == "udp" || server.Healthcheck.ConnectExpect != "" {
+			if timeout, err := time.ParseDuration(server.Healthcheck.Timeout); err != nil || timeout <= 0 {
+				return config.Server{}, errors.New("healthcheck.timeout is required for udp connect_protocol or connect_expect")
+			}
+		}
+	}
+
+	if server.Healthcheck.Kind == "udp" {
+
+		if server.Healthcheck.UdpHealthcheckConfig == nil || server.Healthcheck.UdpSend == "" {
+			return config.Server{}, errors.New("healthcheck.udp_send is required")
+		}
+
+		if server.Healthcheck.UdpPayloadFormat == "" {
+			server.Healthcheck.UdpPayloadFormat = "string"
+		}
+
+		for _, payload := range []string{server.Healthcheck.UdpSend, server.Healthcheck.UdpExpect} {
+			if _, err := healthcheck.DecodeUdpPayload(payload, server.Healthcheck.UdpPayloadFormat); err != nil {
+				return config.Server{}, errors.New("healthcheck.udp_payload_format: " + err.Error())
+			}
+		}
+
+		// Response is waited up to timeout, so it's needed not to hang forever
+		if timeout, err := time.ParseDuration(server.Healthcheck.Timeout); err != nil || timeout <= 0 {
+			return config.Server{}, errors.New("healthcheck.timeout is required for udp healthcheck")
+		}
+	}
+
+	if server.Healthcheck.Kind == "http" {
+
+		if server.Healthcheck.HttpHealthcheckConfig == nil {
+			server.Healthcheck.HttpHealthcheckConfig = &config.HttpHealthcheckConfig{}
+		}
+
+		if server.Healthcheck.HttpPath == "" {
+			server.Healthcheck.HttpPath = "/"
+		}
+
+		if !strings.HasPrefix(server.Healthcheck.HttpPath, "/") {
+			return config.Server{}, errors.New("healthcheck.http_path should start with /")
+		}
+
+		if server.Healthcheck.HttpMethod == "" {
+			server.Healthcheck.HttpMethod = "GET"
+		}
+
+		if len(server.Healthcheck.HttpExpectedStatuses) == 0 {
+			server.Healthcheck.HttpExpectedStatuses = []int{200}
+		}
+	}
+
+	if server.Healthcheck.Kind == "tls" {
+
+		if server.Healthcheck.TlsHealthcheckConfig == nil {
+			server.Healthcheck.TlsHealthcheckConfig = &config.TlsHealthcheckConfig{}
+		}
+
+		if server.Healthcheck.TlsMinExpiryDays < 0 {
+			return config.Server{}, errors.New("healthcheck.tls_min_expiry_days should not be negative")
+		}
+
+		if server.Healthcheck.TlsRootCaCertPath != "" {
+			if _, err := os.Stat(server.Healthcheck.TlsRootCaCertPath); err != nil {
+				return config.Server{}, errors.New("healthcheck.tls_root_ca_cert_path: " + err.Error())
+			}
+		}
+	}
+
+	if server.Healthcheck.Interval == "" {
+		server.Healthcheck.Interval = "0"
+	}
+
+	if server.Healthcheck.Timeout == "" {
+		server.Healthcheck.Timeout = "0"
+	}
+
+	if server.Healthcheck.Fails <= 0 {
+		server.Healthcheck.Fails = 1
+	}
+
+	if server.Healthcheck.Passes <= 0 {
+		server.Healthcheck.Passes = 1
+	}
+
+	if server.Healthcheck.PassiveFails < 0 {
+		return config.Server{}, errors.New("healthcheck.passive_fails should not be negative")
+	}
+
+	if server.Healthcheck.HistorySize < 0 {
+		return config.Server{}, errors.New("healthcheck.history_size should not be negative")
+	}
+
+	if server.Healthcheck.PassiveFails > 0 && server.Healthcheck.Kind == "none" {
+		return config.Server{}, errors.New("healthcheck.passive_fails requires active healthcheck to mark backend as active again")
+	}
+
+	if server.Sni != nil {
+
+		if server.Sni.ReadTimeout == "" {
+			server.Sni.ReadTimeout = "2s"
+		}
 
 		if server.Sni.UnexpectedHostnameStrategy == "" {
 			server.Sni.UnexpectedHostnameStrategy = "default"
@@ -259,54 +1343,682 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		return config.Server{}, errors.New("timeout parsing error")
 	}
 
-	if _, err := time.ParseDuration(server.Healthcheck.Interval); err != nil {
+	interval, err := time.ParseDuration(server.Healthcheck.Interval)
+	if err != nil {
 		return config.Server{}, errors.New("interval parsing error")
 	}
 
+	if interval <= 0 && server.Healthcheck.Kind != "none" {
+		return config.Server{}, errors.New("healthcheck.interval should be positive")
+	}
+
+	if server.Healthcheck.Jitter == "" {
+		server.Healthcheck.Jitter = "0"
+	}
+
+	if jitter, err := time.ParseDuration(server.Healthcheck.Jitter); err != nil {
+		return config.Server{}, errors.New("healthcheck.jitter parsing error")
+	} else if jitter < 0 || jitter > 0 && jitter >= interval {
+		return config.Server{}, errors.New("healthcheck.jitter should not be negative and should be less than interval")
+	}
+
 	if server.BackendsTls != nil && ((server.BackendsTls.KeyPath == nil) != (server.BackendsTls.CertPath == nil)) {
 		return config.Server{}, errors.New("backend_tls.cert_path and .key_path should be specified together")
 	}
 
+	if server.RateLimit != nil {
+		if server.RateLimit.ConnectionsPerSecond <= 0 {
+			return config.Server{}, errors.New("rate_limit.connections_per_second should be positive")
+		}
+
+		if server.RateLimit.Burst < 0 {
+			return config.Server{}, errors.New("rate_limit.burst should not be negative")
+		}
+
+		if server.RateLimit.BanDuration == "" {
+			server.RateLimit.BanDuration = "0"
+		}
+
+		if _, err := time.ParseDuration(server.RateLimit.BanDuration); err != nil {
+			return config.Server{}, errors.New("rate_limit.ban_duration parsing error")
+		}
+	}
+
+	if server.Tarpit != nil {
+		switch server.Protocol {
+		case "", "tcp", "tls":
+		default:
+			return config.Server{}, errors.New("tarpit is supported for tcp and tls only")
+		}
+
+		if server.Tarpit.Duration == "" {
+			server.Tarpit.Duration = "30s"
+		}
+
+		if d, err := time.ParseDuration(server.Tarpit.Duration); err != nil || d <= 0 {
+			return config.Server{}, errors.New("tarpit.duration should be positive duration")
+		}
+
+		if server.Tarpit.MaxConnections < 0 {
+			return config.Server{}, errors.New("tarpit.max_connections should not be negative")
+		}
+
+		if server.Tarpit.MaxConnections == 0 {
+			server.Tarpit.MaxConnections = 1000
+		}
+	}
+
+	if server.Script != nil {
+		switch server.Protocol {
+		case "", "tcp", "tls":
+		default:
+			return config.Server{}, errors.New("script is supported for tcp and tls only")
+		}
+
+		if server.Script.Path == "" {
+			return config.Server{}, errors.New("script.path is required")
+		}
+
+		if server.Script.Timeout == "" {
+			server.Script.Timeout = "100ms"
+		}
+
+		if d, err := time.ParseDuration(server.Script.Timeout); err != nil || d <= 0 {
+			return config.Server{}, errors.New("script.timeout should be positive duration")
+		}
+
+		switch server.Script.Failpolicy {
+		case "accept", "reject":
+		case "":
+			server.Script.Failpolicy = "accept"
+		default:
+			return config.Server{}, errors.New("script.failpolicy should be \"accept\" or \"reject\"")
+		}
+
+		if server.Script.ReadSize < 0 || server.Script.ReadSize > 16384 {
+			return config.Server{}, errors.New("script.read_size should be between 0 and 16384")
+		}
+
+		if server.Script.ReadTimeout == "" {
+			server.Script.ReadTimeout = "2s"
+		}
+
+		if _, err := time.ParseDuration(server.Script.ReadTimeout); err != nil {
+			return config.Server{}, errors.New("script.read_timeout parsing error")
+		}
+
+		if err := script.Check(server.Script); err != nil {
+			return config.Server{}, errors.New("script: " + err.Error())
+		}
+	}
+
+	if server.AccessLog != nil {
+		if udp {
+			return config.Server{}, errors.New("access_log is not supported for udp")
+		}
+
+		switch server.AccessLog.Format {
+		case "json", "text":
+		case "":
+			server.AccessLog.Format = "json"
+		default:
+			return config.Server{}, errors.New("Not supported access_log.format " + server.AccessLog.Format)
+		}
+
+		if server.AccessLog.Output == "" {
+			server.AccessLog.Output = "stdout"
+		}
+	}
+
+	if server.Throttle != nil {
+		if udp {
+			return config.Server{}, errors.New("throttle is not supported for udp")
+		}
+
+		t := server.Throttle
+		if t.ConnectionRxBytesPerSecond < 0 || t.ConnectionTxBytesPerSecond < 0 ||
+			t.ServerRxBytesPerSecond < 0 || t.ServerTxBytesPerSecond < 0 {
+			return config.Server{}, errors.New("throttle bytes per second should not be negative")
+		}
+	}
+
+	if server.CircuitBreaker != nil {
+		if udp {
+			return config.Server{}, errors.New("circuit_breaker is not supported for udp")
+		}
+
+		cb := server.CircuitBreaker
+		if cb.MaxConnections < 0 || cb.MaxPendingDials < 0 || cb.ConsecutiveErrors < 0 {
+			return config.Server{}, errors.New("circuit_breaker limits should not be negative")
+		}
+
+		if cb.OpenTimeout == "" {
+			cb.OpenTimeout = "30s"
+		}
+
+		if _, err := time.ParseDuration(cb.OpenTimeout); err != nil {
+			return config.Server{}, errors.New("circuit_breaker.open_timeout parsing error")
+		}
+	}
+
+	if server.QueueTimeout == "" {
+		server.QueueTimeout = "0"
+	}
+
+	if _, err := time.ParseDuration(server.QueueTimeout); err != nil {
+		return config.Server{}, errors.New("queue_timeout parsing error")
+	}
+
+	if server.QueueSize < 0 {
+		return config.Server{}, errors.New("queue_size should not be negative")
+	}
+
+	if udp && (server.QueueTimeout != "0" || server.QueueSize != 0) {
+		return config.Server{}, errors.New("queue_timeout and queue_size are not supported for udp")
+	}
+
+	// Udp sessions are not limited per client, so default is not inherited
+	if udp {
+		if server.MaxConnectionsPerClient != nil && *server.MaxConnectionsPerClient != 0 {
+			return config.Server{}, errors.New("max_connections_per_client is not supported for udp")
+		}
+		server.MaxConnectionsPerClient = new(int)
+	}
+
+	if server.Failover != nil {
+		if server.Failover.MinHealthy < 0 {
+			return config.Server{}, errors.New("failover.min_healthy should not be negative")
+		}
+
+		if server.Failover.MinHealthy == 0 {
+			server.Failover.MinHealthy = 1
+		}
+	}
+
+	if server.Geoip != nil {
+		if server.Geoip.PreferLabel == "" {
+			return config.Server{}, errors.New("geoip.prefer_label is required")
+		}
+
+		switch server.Geoip.Region {
+		case "":
+			server.Geoip.Region = "country"
+		case "country", "continent":
+		default:
+			return config.Server{}, errors.New("Not supported geoip.region " + server.Geoip.Region)
+		}
+	}
+
+	if server.Sticky != nil {
+		st := server.Sticky
+
+		if st.Ttl == "" {
+			st.Ttl = "30m"
+		}
+
+		if ttl, err := time.ParseDuration(st.Ttl); err != nil || ttl <= 0 {
+			return config.Server{}, errors.New("sticky.ttl should be positive duration")
+		}
+
+		if st.MaxEntries < 0 {
+			return config.Server{}, errors.New("sticky.max_entries should not be negative")
+		}
+
+		if st.PersistInterval == "" {
+			st.PersistInterval = "1m"
+		}
+
+		if _, err := time.ParseDuration(st.PersistInterval); err != nil {
+			return config.Server{}, errors.New("sticky.persist_interval parsing error")
+		}
+	}
+
+	for _, bind := range server.Bind {
+		if !systemd.IsSocketBind(bind) {
+			continue
+		}
+
+		if strings.TrimPrefix(bind, systemd.BindPrefix) == "" {
+			return config.Server{}, errors.New("bind \"systemd:\" requires socket name, i.e. \"systemd:gobetween.socket\"")
+		}
+
+		if server.ReusePort {
+			return config.Server{}, errors.New("reuse_port can't be used with systemd sockets, they're passed already open")
+		}
+
+		if server.Protocol == "dtls" {
+			return config.Server{}, errors.New("systemd sockets are not supported for dtls")
+		}
+	}
+
+	for _, bind := range server.Bind {
+		if !unixsocket.IsSocketBind(bind) {
+			continue
+		}
+
+		if unixsocket.Path(bind) == "" {
+			return config.Server{}, errors.New("bind \"unix:\" requires socket path, i.e. \"unix:/var/run/gobetween.sock\"")
+		}
+
+		if udp || server.Protocol == "quic" {
+			return config.Server{}, errors.New("unix socket binds are supported for tcp, tls and http only")
+		}
+
+		if server.ReusePort {
+			return config.Server{}, errors.New("reuse_port can't be used with unix socket binds")
+		}
+
+		if server.Transparent {
+			return config.Server{}, errors.New("transparent can't be used with unix socket binds, as clients have no ip")
+		}
+	}
+
+	if err := unixsocket.CheckOptions(server.UnixSocket); err != nil {
+		return config.Server{}, err
+	}
+
+	if server.ReusePort {
+		if udp {
+			return config.Server{}, errors.New("reuse_port is not supported for udp")
+		}
+
+		if server.Listeners < 0 {
+			return config.Server{}, errors.New("listeners should not be negative")
+		}
+
+		if server.Listeners == 0 {
+			server.Listeners = runtime.NumCPU()
+		}
+	} else if server.Listeners > 1 {
+		return config.Server{}, errors.New("listeners > 1 require reuse_port = true")
+	}
+
+	if server.BackendPool != nil {
+		if udp {
+			return config.Server{}, errors.New("backend_pool is not supported for udp")
+		}
+
+		if server.ProxyProtocol != nil && server.ProxyProtocol.BackendVersion != "" {
+			return config.Server{}, errors.New("backend_pool can't be used with proxy_protocol.backend_version, as header is per client")
+		}
+
+		if server.BackendPool.Mode != "request_response" {
+			return config.Server{}, errors.New("backend_pool.mode should be \"request_response\", backend connection can be reused only if backend answers every client request")
+		}
+
+		if server.BackendPool.MaxIdle < 0 {
+			return config.Server{}, errors.New("backend_pool.max_idle should not be negative")
+		}
+
+		if server.BackendPool.MaxIdle == 0 {
+			server.BackendPool.MaxIdle = 8
+		}
+
+		if server.BackendPool.IdleTimeout == "" {
+			server.BackendPool.IdleTimeout = "30s"
+		}
+
+		if _, err := time.ParseDuration(server.BackendPool.IdleTimeout); err != nil {
+			return config.Server{}, errors.New("backend_pool.idle_timeout parsing error")
+		}
+
+		if server.BackendPool.DrainTimeout == "" {
+			server.BackendPool.DrainTimeout = "100ms"
+		}
+
+		if drain, err := time.ParseDuration(server.BackendPool.DrainTimeout); err != nil || drain <= 0 {
+			return config.Server{}, errors.New("backend_pool.drain_timeout should be positive duration")
+		}
+	}
+
+	if server.Transparent {
+		if udp {
+			return config.Server{}, errors.New("transparent is not supported for udp")
+		}
+
+		if server.BackendPool != nil {
+			return config.Server{}, errors.New("transparent can't be used with backend_pool, as backend connection is per client")
+		}
+	}
+
+	/* Upstream Proxy */
+	if proxy := server.UpstreamProxy; proxy != nil {
+		if udp {
+			return config.Server{}, errors.New("upstream_proxy is not supported for udp")
+		}
+
+		if server.Transparent {
+			return config.Server{}, errors.New("upstream_proxy can't be used with transparent")
+		}
+
+		switch proxy.Kind {
+		case "socks5", "http":
+		default:
+			return config.Server{}, errors.New("Not supported upstream_proxy.kind " + proxy.Kind)
+		}
+
+		if _, _, err := net.SplitHostPort(proxy.Address); err != nil {
+			return config.Server{}, errors.New("upstream_proxy.address should be host:port")
+		}
+
+		if proxy.Username == "" && proxy.Password != "" {
+			return config.Server{}, errors.New("upstream_proxy.password requires username")
+		}
+
+		if proxy.Kind == "socks5" && (len(proxy.Username) > 255 || len(proxy.Password) > 255) {
+			return config.Server{}, errors.New("upstream_proxy.username and .password should be up to 255 bytes for socks5")
+		}
+	}
+
+	/* Backend bind */
+	if server.BackendBind != nil {
+		if server.Transparent {
+			return config.Server{}, errors.New("backend_bind can't be used with transparent, as backends are connected from client address")
+		}
+	}
+
+	/* Happy eyeballs */
+	if server.HappyEyeballs != nil {
+		if udp {
+			return config.Server{}, errors.New("happy_eyeballs is not supported for udp")
+		}
+
+		if server.Transparent {
+			return config.Server{}, errors.New("happy_eyeballs can't be used with transparent, as backends are connected from client address")
+		}
+	}
+
+	if err := backendbind.Check(server.BackendBind, server.HappyEyeballs); err != nil {
+		return config.Server{}, err
+	}
+
+	/* Sockets options */
+	if (server.ClientSocket != nil || server.BackendSocket != nil) && udp {
+		return config.Server{}, errors.New("client_socket and backend_socket are not supported for udp")
+	}
+
+	if err := validateSocketOptions("client_socket", server.ClientSocket); err != nil {
+		return config.Server{}, err
+	}
+
+	if err := validateSocketOptions("backend_socket", server.BackendSocket); err != nil {
+		return config.Server{}, err
+	}
+
+	if server.Access != nil && server.Access.Default == "" {
+		server.Access.Default = "allow"
+	}
+
 	/* ----- Connections params and overrides ----- */
 
 	/* Protocol */
 	switch server.Protocol {
 	case "":
 		server.Protocol = "tcp"
-	case "tls":
+	case "tls", "dtls", "http", "quic":
+		// Tls is terminated by http server only if it has tls section
+		if server.Tls == nil && server.Protocol == "http" {
+			break
+		}
 		if server.Tls == nil {
-			return config.Server{}, errors.New("Need tls section for tls protocol")
+			return config.Server{}, errors.New("Need tls section for " + server.Protocol + " protocol")
+		}
+		if (server.Tls.CertPath == "") != (server.Tls.KeyPath == "") {
+			return config.Server{}, errors.New("tls.cert_path and .key_path should be specified together")
+		}
+		if server.Tls.CertPath == "" && len(server.Tls.Certificates) == 0 {
+			return config.Server{}, errors.New("tls.cert_path and .key_path or tls.certificates are required")
+		}
+		for _, c := range server.Tls.Certificates {
+			if c.CertPath == "" || c.KeyPath == "" {
+				return config.Server{}, errors.New("tls.certificates cert_path and key_path are required")
+			}
+			if len(c.Hosts) == 0 {
+				return config.Server{}, errors.New("tls.certificates hosts are required for " + c.CertPath)
+			}
+		}
+		if server.Tls.ReloadInterval == "" {
+			server.Tls.ReloadInterval = "0"
+		}
+		if _, err := time.ParseDuration(server.Tls.ReloadInterval); err != nil {
+			return config.Server{}, errors.New("tls.reload_interval parsing error")
+		}
+		if server.Tls.ClientAuth != nil {
+			switch server.Tls.ClientAuth.Mode {
+			case
+				"require",
+				"verify":
+			case "":
+				server.Tls.ClientAuth.Mode = "require"
+			default:
+				return config.Server{}, errors.New("Not supported tls.client_auth.mode " + server.Tls.ClientAuth.Mode)
+			}
+
+			if server.Tls.ClientAuth.CaCertPath == "" {
+				return config.Server{}, errors.New("tls.client_auth.ca_cert_path is required")
+			}
+
+			for _, name := range server.Tls.ClientAuth.AllowedNames {
+				if _, err := regexp.Compile(name); err != nil {
+					return config.Server{}, errors.New("tls.client_auth.allowed_names regexp error: " + err.Error())
+				}
+			}
+		}
+		if !udp {
+			break
 		}
 		fallthrough
-	case "tcp":
 	case "udp":
 		if server.BackendsTls != nil {
 			return config.Server{}, errors.New("backends_tls should not be enabled for udp protocol")
 		}
+		if server.ProxyProtocol != nil {
+			return config.Server{}, errors.New("proxy_protocol should not be enabled for udp protocol")
+		}
+		if server.Udp != nil && server.Udp.SessionTimeout != "" {
+			if _, err := time.ParseDuration(server.Udp.SessionTimeout); err != nil {
+				return config.Server{}, errors.New("udp.session_timeout parsing error")
+			}
+		}
+		if server.Udp != nil && server.Udp.MaxSessions < 0 {
+			return config.Server{}, errors.New("udp.max_sessions should not be negative")
+		}
+		if server.Udp != nil && server.Udp.MaxPacketSize < 0 {
+			return config.Server{}, errors.New("udp.max_packet_size should not be negative")
+		}
+		if server.Udp != nil && server.Udp.Dns != nil {
+			dns := server.Udp.Dns
+			if server.Protocol != "udp" {
+				return config.Server{}, errors.New("udp.dns is available for udp protocol only")
+			}
+			if server.Udp.MaxRequests > 0 || server.Udp.MaxResponses > 0 || server.Udp.MaxRequestBytes > 0 ||
+				server.Udp.MaxResponseBytes > 0 || server.Udp.SessionTimeout != "" {
+				return config.Server{}, errors.New("udp.dns can't be used with session options (max_requests, max_responses, max_request_bytes, max_response_bytes, session_timeout)")
+			}
+			if dns.QueryTimeout == "" {
+				dns.QueryTimeout = "2s"
+			}
+			if timeout, err := time.ParseDuration(dns.QueryTimeout); err != nil || timeout <= 0 {
+				return config.Server{}, errors.New("udp.dns.query_timeout should be positive duration")
+			}
+			if dns.Retries < 0 {
+				return config.Server{}, errors.New("udp.dns.retries should not be negative")
+			}
+		}
+	case "tcp":
 	default:
 		return config.Server{}, errors.New("Not supported protocol " + server.Protocol)
 	}
 
+	/* Http */
+	if server.Http != nil && server.Protocol != "http" {
+		return config.Server{}, errors.New("http section is available for http protocol only")
+	}
+
+	if server.Protocol == "http" {
+		unsupported := []struct {
+			option string
+			set    bool
+		}{
+			{"sni", server.Sni != nil},
+			{"proxy_protocol", server.ProxyProtocol != nil},
+			{"access_log", server.AccessLog != nil},
+			{"throttle", server.Throttle != nil},
+			{"backend_pool", server.BackendPool != nil},
+			{"shadow", server.Shadow != nil},
+			{"canary", server.Canary != nil},
+			{"transparent", server.Transparent},
+			{"reuse_port", server.ReusePort},
+			{"client_socket", server.ClientSocket != nil},
+			{"backend_socket", server.BackendSocket != nil},
+		}
+
+		for _, u := range unsupported {
+			if u.set {
+				return config.Server{}, errors.New(u.option + " is not supported for http protocol")
+			}
+		}
+
+		if server.Http == nil {
+			server.Http = &config.Http{}
+		}
+
+		switch server.Http.BackendProtocol {
+		case "":
+			server.Http.BackendProtocol = "http1"
+		case "http1":
+		case "h2c":
+			if server.BackendsTls != nil {
+				return config.Server{}, errors.New("http.backend_protocol h2c can't be used with backends_tls, use h2")
+			}
+		case "h2":
+			if server.BackendsTls == nil {
+				return config.Server{}, errors.New("http.backend_protocol h2 requires backends_tls")
+			}
+		default:
+			return config.Server{}, errors.New("Not supported http.backend_protocol " + server.Http.BackendProtocol)
+		}
+	}
+
+	/* Quic */
+	if server.Quic != nil && server.Protocol != "quic" {
+		return config.Server{}, errors.New("quic section is available for quic protocol only")
+	}
+
+	if server.Protocol == "quic" {
+		if len(server.Tls.Alpn) == 0 {
+			return config.Server{}, errors.New("tls.alpn is required for quic protocol")
+		}
+
+		unsupported := []struct {
+			option string
+			set    bool
+		}{
+			{"sni", server.Sni != nil},
+			{"proxy_protocol", server.ProxyProtocol != nil},
+			{"access_log", server.AccessLog != nil},
+			{"throttle", server.Throttle != nil},
+			{"backend_pool", server.BackendPool != nil},
+			{"shadow", server.Shadow != nil},
+			{"canary", server.Canary != nil},
+			{"transparent", server.Transparent},
+			{"reuse_port", server.ReusePort},
+			{"client_socket", server.ClientSocket != nil},
+			{"backend_socket", server.BackendSocket != nil},
+			{"udp", server.Udp != nil},
+		}
+
+		for _, u := range unsupported {
+			if u.set {
+				return config.Server{}, errors.New(u.option + " is not supported for quic protocol")
+			}
+		}
+
+		if server.Quic == nil {
+			server.Quic = &config.Quic{}
+		}
+
+		switch server.Quic.BackendProtocol {
+		case "":
+			server.Quic.BackendProtocol = "tcp"
+		case "tcp":
+		case "quic":
+			if server.UpstreamProxy != nil {
+				return config.Server{}, errors.New("upstream_proxy can't be used with quic.backend_protocol quic")
+			}
+
+			if server.BackendBind != nil || server.HappyEyeballs != nil {
+				return config.Server{}, errors.New("backend_bind and happy_eyeballs can't be used with quic.backend_protocol quic")
+			}
+		default:
+			return config.Server{}, errors.New("Not supported quic.backend_protocol " + server.Quic.BackendProtocol)
+		}
+
+		if server.Quic.MaxStreams < 0 {
+			return config.Server{}, errors.New("quic.max_streams should not be negative")
+		}
+	}
+
+	/* Proxy Protocol */
+	if server.ProxyProtocol != nil {
+		switch server.ProxyProtocol.BackendVersion {
+		case
+			"",
+			"v1",
+			"v2":
+		default:
+			return config.Server{}, errors.New("Not supported proxy_protocol.backend_version " + server.ProxyProtocol.BackendVersion)
+		}
+
+		if server.ProxyProtocol.ReadTimeout == "" {
+			server.ProxyProtocol.ReadTimeout = "2s"
+		}
+
+		if _, err := time.ParseDuration(server.ProxyProtocol.ReadTimeout); err != nil {
+			return config.Server{}, errors.New("proxy_protocol.read_timeout parsing error")
+		}
+	}
+
 	/* Healthcheck and protocol match */
 
-	if server.Healthcheck.Kind == "ping" && server.Protocol == "udp" {
+	if server.Healthcheck.Kind == "ping" && udp {
 		return config.Server{}, errors.New("Cant use ping healthcheck with udp server")
 	}
 
-	/* Balance */
-	switch server.Balance {
-	case
-		"weight",
-		"leastconn",
-		"roundrobin",
-		"leastbandwidth",
-		"iphash":
-	case "":
+	if server.Healthcheck.Kind == "http" && udp {
+		return config.Server{}, errors.New("Cant use http healthcheck with udp server")
+	}
+
+	if server.Healthcheck.Kind == "tls" && udp {
+		return config.Server{}, errors.New("Cant use tls healthcheck with udp server")
+	}
+
+	if server.Healthcheck.Kind == "connect" && server.Healthcheck.ConnectProtocol == "tcp" && udp {
+		return config.Server{}, errors.New("Cant use tcp connect healthcheck with udp server, use connect_protocol = \"udp\"")
+	}
+
+	if server.Healthcheck.PassiveFails > 0 && udp {
+		return config.Server{}, errors.New("Cant use passive healthcheck with udp server")
+	}
+
+	/* Balance, builtin or registered by plugin */
+	if server.Balance == "" {
 		server.Balance = "weight"
-	default:
+	}
+
+	if !balance.Registered(server.Balance) {
 		return config.Server{}, errors.New("Not supported balance type " + server.Balance)
 	}
 
+	/* Slow start */
+	if server.SlowStart == "" {
+		server.SlowStart = "0"
+	}
+
+	if _, err := time.ParseDuration(server.SlowStart); err != nil {
+		return config.Server{}, errors.New("slow_start parsing error")
+	}
+
 	/* Discovery */
 	switch server.Discovery.Failpolicy {
 	case
@@ -326,14 +2038,89 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		server.Discovery.Timeout = "0"
 	}
 
+	if server.Discovery.StaleTtl == "" {
+		server.Discovery.StaleTtl = "0"
+	}
+
+	if _, err := time.ParseDuration(server.Discovery.StaleTtl); err != nil {
+		return config.Server{}, errors.New("discovery stale_ttl parsing error")
+	}
+
+	if server.Discovery.MaxFailures == 0 {
+		server.Discovery.MaxFailures = 1
+	}
+
+	if server.Discovery.MaxFailures < 0 {
+		return config.Server{}, errors.New("discovery max_failures should be positive")
+	}
+
+	/* Json and Plaintext Discovery http options */
+	if opts := server.Discovery.HttpDiscoveryConfig; opts != nil && (server.Discovery.Kind == "json" || server.Discovery.Kind == "plaintext") {
+
+		if (opts.HttpTlsCertPath == "") != (opts.HttpTlsKeyPath == "") {
+			return config.Server{}, errors.New("http_tls_cert_path and http_tls_key_path should be specified together")
+		}
+
+		if opts.HttpBasicAuthUsername != "" && opts.HttpBearerToken != "" {
+			return config.Server{}, errors.New("http_basic_auth_username and http_bearer_token can't be used together")
+		}
+
+		if opts.HttpRetries < 0 {
+			return config.Server{}, errors.New("http_retries should not be negative")
+		}
+
+		if opts.HttpRetryWait == "" {
+			opts.HttpRetryWait = "1s"
+		}
+
+		if _, err := time.ParseDuration(opts.HttpRetryWait); err != nil {
+			return config.Server{}, errors.New("http_retry_wait parsing error")
+		}
+	}
+
+	/* Exec Discovery */
+	if server.Discovery.Kind == "exec" {
+
+		if server.Discovery.ExecDiscoveryConfig == nil || len(server.Discovery.ExecCommand) == 0 {
+			return config.Server{}, errors.New("exec_command is required")
+		}
+
+		switch server.Discovery.ExecMode {
+		case
+			"oneshot",
+			"stream":
+		case "":
+			server.Discovery.ExecMode = "oneshot"
+		default:
+			return config.Server{}, errors.New("Not supported exec_mode " + server.Discovery.ExecMode)
+		}
+	}
+
+	/* Static Discovery resolving host names, with srv discovery lookup options */
+	if server.Discovery.Kind == "static" && server.Discovery.StaticDiscoveryConfig != nil && server.Discovery.SrvDiscoveryConfig != nil &&
+		(server.Discovery.StaticResolve || server.Discovery.StaticExpand) {
+
+		switch server.Discovery.SrvDnsProtocol {
+		case "", "udp", "tcp":
+		default:
+			return config.Server{}, errors.New("Not supported srv_dns_protocol " + server.Discovery.SrvDnsProtocol)
+		}
+	}
+
 	/* SRV Discovery */
 	if server.Discovery.Kind == "srv" {
+
+		if server.Discovery.SrvDiscoveryConfig == nil ||
+			server.Discovery.SrvLookupPattern == "" && len(server.Discovery.SrvLookupPatterns) == 0 {
+			return config.Server{}, errors.New("srv_lookup_pattern or srv_lookup_patterns is required")
+		}
+
 		switch server.Discovery.SrvDnsProtocol {
 		case
 			"udp",
 			"tcp":
 		case "":
-			server.Discovery.Failpolicy = "udp"
+			server.Discovery.SrvDnsProtocol = "udp"
 		default:
 			return config.Server{}, errors.New("Not supported srv_dns_protocol " + server.Discovery.SrvDnsProtocol)
 		}
@@ -342,14 +2129,21 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 	/* LXD Discovery */
 	if server.Discovery.Kind == "lxd" {
 
-		if server.Discovery.LXDServerAddress == "" {
+		if server.Discovery.LXDServerAddress == "" && len(server.Discovery.LXDServerAddresses) == 0 {
 			return config.Server{}, errors.New("lxd_server_address is required" + server.Discovery.LXDServerAddress)
 		}
 
-		if !(strings.HasPrefix(server.Discovery.LXDServerAddress, "https:") ||
-			strings.HasPrefix(server.Discovery.LXDServerAddress, "unix:")) {
+		addresses := server.Discovery.LXDServerAddresses
+		if server.Discovery.LXDServerAddress != "" {
+			addresses = append([]string{server.Discovery.LXDServerAddress}, addresses...)
+		}
+
+		for _, address := range addresses {
+			if !(strings.HasPrefix(address, "https:") ||
+				strings.HasPrefix(address, "unix:")) {
 
-			return config.Server{}, errors.New("lxd_server_address should start with either unix:// or https:// but got " + server.Discovery.LXDServerAddress)
+				return config.Server{}, errors.New("lxd_server_address should start with either unix:// or https:// but got " + address)
+			}
 		}
 
 		if server.Discovery.LXDServerRemoteName == "" {
@@ -376,6 +2170,353 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 
 	}
 
+	/* Consul Discovery */
+	if server.Discovery.Kind == "consul" {
+
+		if server.Discovery.ConsulDiscoveryConfig == nil || server.Discovery.ConsulServiceName == "" {
+			return config.Server{}, errors.New("consul_service_name is required")
+		}
+	}
+
+	/* Kubernetes Discovery */
+	if server.Discovery.Kind == "kubernetes" {
+
+		if server.Discovery.KubernetesServiceName == "" {
+			return config.Server{}, errors.New("kubernetes_service_name is required")
+		}
+
+		if server.Discovery.KubernetesNamespace == "" {
+			server.Discovery.KubernetesNamespace = "default"
+		}
+	}
+
+	/* Etcd Discovery */
+	if server.Discovery.Kind == "etcd" {
+
+		if len(server.Discovery.EtcdEndpoints) == 0 {
+			return config.Server{}, errors.New("etcd_endpoints is required")
+		}
+
+		if server.Discovery.EtcdPrefix == "" {
+			return config.Server{}, errors.New("etcd_prefix is required")
+		}
+	}
+
+	/* ZooKeeper Discovery */
+	if server.Discovery.Kind == "zookeeper" {
+
+		if server.Discovery.ZookeeperDiscoveryConfig == nil || len(server.Discovery.ZookeeperServers) == 0 {
+			return config.Server{}, errors.New("zookeeper_servers is required")
+		}
+
+		if !strings.HasPrefix(server.Discovery.ZookeeperPath, "/") {
+			return config.Server{}, errors.New("zookeeper_path is required and should start with /")
+		}
+
+		if server.Discovery.ZookeeperSessionTimeout == "" {
+			server.Discovery.ZookeeperSessionTimeout = "10s"
+		}
+
+		if _, err := time.ParseDuration(server.Discovery.ZookeeperSessionTimeout); err != nil {
+			return config.Server{}, errors.New("zookeeper_session_timeout parsing error")
+		}
+	}
+
+	/* AWS Discovery */
+	if server.Discovery.Kind == "aws" {
+
+		if server.Discovery.AwsDiscoveryConfig == nil || server.Discovery.AwsRegion == "" {
+			return config.Server{}, errors.New("aws_region is required")
+		}
+
+		if len(server.Discovery.AwsTagFilters) == 0 && server.Discovery.AwsAutoscalingGroup == "" {
+			return config.Server{}, errors.New("aws_tag_filters or aws_autoscaling_group is required")
+		}
+
+		if server.Discovery.AwsPort == 0 && server.Discovery.AwsPortTag == "" {
+			return config.Server{}, errors.New("aws_port or aws_port_tag is required")
+		}
+
+		switch server.Discovery.AwsAddressType {
+		case
+			"private",
+			"public":
+		case "":
+			server.Discovery.AwsAddressType = "private"
+		default:
+			return config.Server{}, errors.New("Invalid aws_address_type. Must be private or public")
+		}
+	}
+
+	/* gRPC Discovery */
+	if server.Discovery.Kind == "grpc" {
+
+		if server.Discovery.GrpcDiscoveryConfig == nil || server.Discovery.GrpcAddress == "" {
+			return config.Server{}, errors.New("grpc_address is required")
+		}
+	}
+
+	/* Merge Discovery */
+	if server.Discovery.Kind == "merge" {
+
+		if server.Discovery.MergeDiscoveryConfig == nil || len(server.Discovery.MergeSources) == 0 {
+			return config.Server{}, errors.New("merge_sources is required")
+		}
+
+		sources := make([]config.MergeSource, len(server.Discovery.MergeSources))
+
+		for i, source := range server.Discovery.MergeSources {
+
+			if source.Kind == "merge" {
+				return config.Server{}, errors.New("merge_sources can't be merge discoveries")
+			}
+
+			// Source is validated as discovery of pool with server settings
+			prepared, err := preparePoolConfig(name, server, defaults, server.Balance, &source.DiscoveryConfig, server.Healthcheck)
+			if err != nil {
+				return config.Server{}, errors.New("merge_sources " + source.Kind + " " + source.Label + ": " + err.Error())
+			}
+
+			sources[i] = config.MergeSource{
+				DiscoveryConfig: *prepared.Discovery,
+				Label:           source.Label,
+			}
+		}
+
+		merge := *server.Discovery
+		merge.MergeDiscoveryConfig = &config.MergeDiscoveryConfig{MergeSources: sources}
+		server.Discovery = &merge
+	}
+
+	/* Sni Routes */
+	if server.Sni != nil {
+		for i, route := range server.Sni.Routes {
+
+			if route.Hostname == "" && route.Alpn == "" {
+				return config.Server{}, errors.New("sni.routes hostname or alpn is required")
+			}
+
+			if route.Alpn != "" && !alpnAdvertised(server, route.Alpn) {
+				return config.Server{}, errors.New("sni.routes alpn " + route.Alpn + " should be one of tls.alpn of tls protocol server")
+			}
+
+			if route.Hostname != "" && server.Sni.HostnameMatchingStrategy == "regexp" {
+				if _, err := regexp.Compile(route.Hostname); err != nil {
+					return config.Server{}, errors.New("sni.routes hostname regexp error: " + err.Error())
+				}
+			}
+
+			if route.Discovery == nil {
+				return config.Server{}, errors.New("No sni.routes discovery specified for " + routeName(route))
+			}
+
+			prepared, err := preparePoolConfig(name, server, defaults, route.Balance, route.Discovery, route.Healthcheck)
+			if err != nil {
+				return config.Server{}, errors.New("sni.routes " + routeName(route) + ": " + err.Error())
+			}
+
+			route.Balance = prepared.Balance
+			route.Discovery = prepared.Discovery
+			route.Healthcheck = prepared.Healthcheck
+
+			server.Sni.Routes[i] = route
+		}
+	}
+
+	/* Mux Routes */
+	if server.Mux != nil {
+
+		if server.Protocol != "tcp" {
+			return config.Server{}, errors.New("mux is available for tcp protocol only")
+		}
+
+		if server.Mux.ReadTimeout == "" {
+			server.Mux.ReadTimeout = "2s"
+		}
+
+		if _, err := time.ParseDuration(server.Mux.ReadTimeout); err != nil {
+			return config.Server{}, errors.New("mux.read_timeout parsing error")
+		}
+
+		if server.Mux.ReadSize == 0 {
+			server.Mux.ReadSize = tcp.MuxDefaultReadSize
+		}
+
+		if server.Mux.ReadSize < 0 || server.Mux.ReadSize > 16384 {
+			return config.Server{}, errors.New("mux.read_size should be between 1 and 16384")
+		}
+
+		if len(server.Mux.Routes) == 0 {
+			return config.Server{}, errors.New("mux.routes are required")
+		}
+
+		for i, route := range server.Mux.Routes {
+
+			rules := 0
+			for _, rule := range []string{route.Protocol, route.Prefix, route.HexPrefix, route.Regexp} {
+				if rule != "" {
+					rules++
+				}
+			}
+
+			if rules != 1 {
+				return config.Server{}, errors.New("mux.routes should have exactly one of protocol, prefix, hex_prefix or regexp")
+			}
+
+			if route.Name == "" {
+				if route.Protocol == "" {
+					return config.Server{}, errors.New("mux.routes name is required for prefix, hex_prefix and regexp routes")
+				}
+				route.Name = route.Protocol
+			}
+
+			if route.Protocol != "" {
+				known := false
+				for _, protocol := range tcp.MuxProtocols {
+					known = known || protocol == route.Protocol
+				}
+
+				if !known {
+					return config.Server{}, errors.New("Not supported mux.routes protocol " + route.Protocol)
+				}
+			}
+
+			prefix := []byte(route.Prefix)
+			if route.HexPrefix != "" {
+				var err error
+				if prefix, err = hex.DecodeString(route.HexPrefix); err != nil {
+					return config.Server{}, errors.New("mux.routes " + route.Name + ": hex_prefix parsing error")
+				}
+			}
+
+			if len(prefix) > server.Mux.ReadSize {
+				return config.Server{}, errors.New("mux.routes " + route.Name + ": prefix is longer than mux.read_size")
+			}
+
+			if route.Regexp != "" {
+				if _, err := regexp.Compile(route.Regexp); err != nil {
+					return config.Server{}, errors.New("mux.routes " + route.Name + ": regexp parsing error: " + err.Error())
+				}
+			}
+
+			for _, other := range server.Mux.Routes[:i] {
+				if other.Name == route.Name {
+					return config.Server{}, errors.New("mux.routes " + route.Name + " is specified more than once")
+				}
+			}
+
+			if route.Discovery == nil {
+				return config.Server{}, errors.New("No mux.routes discovery specified for " + route.Name)
+			}
+
+			prepared, err := preparePoolConfig(name, server, defaults, route.Balance, route.Discovery, route.Healthcheck)
+			if err != nil {
+				return config.Server{}, errors.New("mux.routes " + route.Name + ": " + err.Error())
+			}
+
+			route.Balance = prepared.Balance
+			route.Discovery = prepared.Discovery
+			route.Healthcheck = prepared.Healthcheck
+
+			server.Mux.Routes[i] = route
+		}
+	}
+
+	/* Http Routes */
+	if server.Http != nil {
+		for i, route := range server.Http.Routes {
+
+			if route.Host == "" && route.PathPrefix == "" {
+				return config.Server{}, errors.New("http.routes host or path_prefix is required")
+			}
+
+			if strings.Contains(strings.TrimPrefix(route.Host, "*."), "*") {
+				return config.Server{}, errors.New("http.routes host " + route.Host + " may have wildcard only as \"*.\" prefix")
+			}
+
+			if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+				return config.Server{}, errors.New("http.routes path_prefix should start with /")
+			}
+
+			if route.StripPrefix && route.PathPrefix == "" {
+				return config.Server{}, errors.New("http.routes strip_prefix requires path_prefix")
+			}
+
+			if route.Discovery == nil {
+				return config.Server{}, errors.New("No http.routes discovery specified for " + route.Host + route.PathPrefix)
+			}
+
+			prepared, err := preparePoolConfig(name, server, defaults, route.Balance, route.Discovery, route.Healthcheck)
+			if err != nil {
+				return config.Server{}, errors.New("http.routes " + route.Host + route.PathPrefix + ": " + err.Error())
+			}
+
+			route.Balance = prepared.Balance
+			route.Discovery = prepared.Discovery
+			route.Healthcheck = prepared.Healthcheck
+
+			server.Http.Routes[i] = route
+		}
+	}
+
+	/* Shadow pool */
+	if server.Shadow != nil {
+
+		if udp {
+			return config.Server{}, errors.New("shadow is not supported for udp")
+		}
+
+		if server.Shadow.Discovery == nil {
+			return config.Server{}, errors.New("No shadow discovery specified")
+		}
+
+		if server.Shadow.QueueSize < 0 {
+			return config.Server{}, errors.New("shadow.queue_size should be >= 0")
+		}
+
+		if server.Shadow.QueueSize == 0 {
+			server.Shadow.QueueSize = 64
+		}
+
+		prepared, err := preparePoolConfig(name, server, defaults, server.Shadow.Balance, server.Shadow.Discovery, server.Shadow.Healthcheck)
+		if err != nil {
+			return config.Server{}, errors.New("shadow: " + err.Error())
+		}
+
+		shadow := *server.Shadow
+		shadow.Balance = prepared.Balance
+		shadow.Discovery = prepared.Discovery
+		shadow.Healthcheck = prepared.Healthcheck
+		server.Shadow = &shadow
+	}
+
+	/* Canary pool */
+	if server.Canary != nil {
+
+		if udp {
+			return config.Server{}, errors.New("canary is not supported for udp")
+		}
+
+		if server.Canary.Discovery == nil {
+			return config.Server{}, errors.New("No canary discovery specified")
+		}
+
+		if server.Canary.Weight < 0 || server.Canary.Weight > 100 {
+			return config.Server{}, errors.New("canary.weight should be in 0..100")
+		}
+
+		prepared, err := preparePoolConfig(name, server, defaults, server.Canary.Balance, server.Canary.Discovery, server.Canary.Healthcheck)
+		if err != nil {
+			return config.Server{}, errors.New("canary: " + err.Error())
+		}
+
+		canary := *server.Canary
+		canary.Balance = prepared.Balance
+		canary.Discovery = prepared.Discovery
+		canary.Healthcheck = prepared.Healthcheck
+		server.Canary = &canary
+	}
+
 	/* TODO: Still need to decide how to get rid of this */
 
 	if defaults.MaxConnections == nil {
@@ -386,6 +2527,14 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		*server.MaxConnections = *defaults.MaxConnections
 	}
 
+	if defaults.MaxConnectionsPerClient == nil {
+		defaults.MaxConnectionsPerClient = new(int)
+	}
+	if server.MaxConnectionsPerClient == nil {
+		server.MaxConnectionsPerClient = new(int)
+		*server.MaxConnectionsPerClient = *defaults.MaxConnectionsPerClient
+	}
+
 	if defaults.ClientIdleTimeout == nil {
 		defaults.ClientIdleTimeout = new(string)
 		*defaults.ClientIdleTimeout = "0"
@@ -404,6 +2553,45 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		*server.BackendIdleTimeout = *defaults.BackendIdleTimeout
 	}
 
+	if defaults.ClientWriteTimeout == nil {
+		defaults.ClientWriteTimeout = new(string)
+		*defaults.ClientWriteTimeout = "0"
+	}
+	if server.ClientWriteTimeout == nil {
+		server.ClientWriteTimeout = new(string)
+		*server.ClientWriteTimeout = *defaults.ClientWriteTimeout
+	}
+
+	if _, err := time.ParseDuration(*server.ClientWriteTimeout); err != nil {
+		return config.Server{}, errors.New("client_write_timeout parsing error")
+	}
+
+	if defaults.BackendWriteTimeout == nil {
+		defaults.BackendWriteTimeout = new(string)
+		*defaults.BackendWriteTimeout = "0"
+	}
+	if server.BackendWriteTimeout == nil {
+		server.BackendWriteTimeout = new(string)
+		*server.BackendWriteTimeout = *defaults.BackendWriteTimeout
+	}
+
+	if _, err := time.ParseDuration(*server.BackendWriteTimeout); err != nil {
+		return config.Server{}, errors.New("backend_write_timeout parsing error")
+	}
+
+	if defaults.MaxSessionDuration == nil {
+		defaults.MaxSessionDuration = new(string)
+		*defaults.MaxSessionDuration = "0"
+	}
+	if server.MaxSessionDuration == nil {
+		server.MaxSessionDuration = new(string)
+		*server.MaxSessionDuration = *defaults.MaxSessionDuration
+	}
+
+	if _, err := time.ParseDuration(*server.MaxSessionDuration); err != nil {
+		return config.Server{}, errors.New("max_session_duration parsing error")
+	}
+
 	if defaults.BackendConnectionTimeout == nil {
 		defaults.BackendConnectionTimeout = new(string)
 		*defaults.BackendConnectionTimeout = "0"
@@ -413,5 +2601,139 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		*server.BackendConnectionTimeout = *defaults.BackendConnectionTimeout
 	}
 
+	if defaults.DrainTimeout == nil {
+		defaults.DrainTimeout = new(string)
+		*defaults.DrainTimeout = "0"
+	}
+	if server.DrainTimeout == nil {
+		server.DrainTimeout = new(string)
+		*server.DrainTimeout = *defaults.DrainTimeout
+	}
+
+	if _, err := time.ParseDuration(*server.DrainTimeout); err != nil {
+		return config.Server{}, errors.New("drain_timeout parsing error")
+	}
+
+	if defaults.MaxDialRetries == nil {
+		defaults.MaxDialRetries = new(int)
+	}
+	if server.MaxDialRetries == nil {
+		server.MaxDialRetries = new(int)
+		*server.MaxDialRetries = *defaults.MaxDialRetries
+	}
+
+	if *server.MaxDialRetries < 0 {
+		return config.Server{}, errors.New("max_dial_retries should not be negative")
+	}
+
+	if *server.MaxConnectionsPerClient < 0 {
+		return config.Server{}, errors.New("max_connections_per_client should not be negative")
+	}
+
+	if defaults.MinHealthyBackends == nil {
+		defaults.MinHealthyBackends = new(int)
+		*defaults.MinHealthyBackends = 1
+	}
+	if server.MinHealthyBackends == nil {
+		server.MinHealthyBackends = new(int)
+		*server.MinHealthyBackends = *defaults.MinHealthyBackends
+	}
+
+	if *server.MinHealthyBackends < 0 {
+		return config.Server{}, errors.New("min_healthy_backends should not be negative")
+	}
+
+	if defaults.BufferSize == nil {
+		defaults.BufferSize = new(int)
+		*defaults.BufferSize = 16 * 1024
+	}
+	if server.BufferSize == nil {
+		server.BufferSize = new(int)
+		*server.BufferSize = *defaults.BufferSize
+	}
+
+	if *server.BufferSize <= 0 {
+		return config.Server{}, errors.New("buffer_size should be positive")
+	}
+
 	return server, nil
 }
+
+/**
+ * Checks if alpn protocol is advertised by tls protocol server
+ */
+func alpnAdvertised(server config.Server, alpn string) bool {
+
+	if server.Protocol != "tls" || server.Tls == nil {
+		return false
+	}
+
+	for _, p := range server.Tls.Alpn {
+		if p == alpn {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Prepares additional backends pool config (of sni route, shadow or canary)
+ * same way as server's one. Pool inherits server balance and healthcheck if it does not override them
+ */
+func preparePoolConfig(name string, server config.Server, defaults config.DefaultsConfig, balance string, discovery *config.DiscoveryConfig, healthcheck *config.HealthcheckConfig) (config.Server, error) {
+
+	if balance == "" {
+		balance = server.Balance
+	}
+
+	if healthcheck == nil {
+		serverHealthcheck := *server.Healthcheck
+		healthcheck = &serverHealthcheck
+	}
+
+	poolServer := server
+	poolServer.Sni = nil
+	poolServer.Mux = nil
+	poolServer.Http = nil
+	poolServer.Shadow = nil
+	poolServer.Canary = nil
+	poolServer.Balance = balance
+	poolServer.Discovery = discovery
+	poolServer.Healthcheck = healthcheck
+
+	return prepareConfig(name, poolServer, defaults)
+}
+
+/**
+ * Returns sni route name for error messages
+ */
+func routeName(route config.SniRoute) string {
+	return strings.TrimSpace(route.Hostname + " " + route.Alpn)
+}
+
+/**
+ * Validates socket options of section name, if set
+ */
+func validateSocketOptions(name string, opts *config.SocketOptions) error {
+
+	if opts == nil {
+		return nil
+	}
+
+	if opts.KeepaliveInterval != "" {
+		if _, err := time.ParseDuration(opts.KeepaliveInterval); err != nil {
+			return errors.New(name + ".keepalive_interval parsing error")
+		}
+	}
+
+	if opts.KeepaliveCount < 0 || opts.RecvBuffer < 0 || opts.SendBuffer < 0 {
+		return errors.New(name + " keepalive_count, recv_buffer and send_buffer should not be negative")
+	}
+
+	if opts.Tos < 0 || opts.Tos > 255 {
+		return errors.New(name + ".tos should be between 0 and 255")
+	}
+
+	return nil
+}
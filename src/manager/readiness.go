@@ -0,0 +1,93 @@
+/**
+ * readiness.go - app readiness to take traffic
+ */
+
+package manager
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"../server/scheduler"
+	"../stats"
+)
+
+/* Set once all servers of initial configuration are started */
+var initialized int32
+
+/* Set once servers are drained for binary upgrade */
+var draining int32
+
+/**
+ * Readiness of configured server
+ */
+type ServerReadiness struct {
+	Ready              bool `json:"ready"`
+	Listening          bool `json:"listening"`
+	HealthyBackends    int  `json:"healthy_backends"`
+	MinHealthyBackends int  `json:"min_healthy_backends"`
+}
+
+/**
+ * Readiness of app, ready if it's started, not draining, and all configured servers are
+ * listening with at least min_healthy_backends live, not drained backends with circuit breaker not open
+ */
+type Readiness struct {
+	Ready    bool                       `json:"ready"`
+	Starting bool                       `json:"starting"`
+	Draining bool                       `json:"draining"`
+	Servers  map[string]ServerReadiness `json:"servers"`
+
+	/* Names of not ready servers, sorted */
+	NotReady []string `json:"not_ready"`
+}
+
+/**
+ * Returns current readiness of app and its servers
+ */
+func GetReadiness() Readiness {
+
+	readiness := Readiness{
+		Starting: atomic.LoadInt32(&initialized) == 0,
+		Draining: atomic.LoadInt32(&draining) == 1,
+		Servers:  map[string]ServerReadiness{},
+		NotReady: []string{},
+	}
+
+	servers.RLock()
+
+	for name, server := range servers.m {
+		r := ServerReadiness{
+			Listening:          true,
+			MinHealthyBackends: *server.Cfg().MinHealthyBackends,
+		}
+
+		if s, ok := stats.GetStats(name).(stats.Stats); ok {
+			for _, b := range s.Backends {
+				if b.Stats.Live && !b.Stats.Drained && b.Stats.CircuitBreaker != scheduler.BREAKER_OPEN {
+					r.HealthyBackends++
+				}
+			}
+		}
+
+		r.Ready = r.HealthyBackends >= r.MinHealthyBackends
+		readiness.Servers[name] = r
+	}
+
+	for name := range servers.failed {
+		readiness.Servers[name] = ServerReadiness{}
+	}
+
+	servers.RUnlock()
+
+	for name, r := range readiness.Servers {
+		if !r.Ready {
+			readiness.NotReady = append(readiness.NotReady, name)
+		}
+	}
+	sort.Strings(readiness.NotReady)
+
+	readiness.Ready = !readiness.Starting && !readiness.Draining && len(readiness.NotReady) == 0
+
+	return readiness
+}
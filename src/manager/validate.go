@@ -0,0 +1,328 @@
+/**
+ * validate.go - validating whole configuration without applying it
+ */
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"../balance"
+	"../cluster"
+	"../config"
+	"../events"
+	"../ha"
+	"../tracing"
+	"../utils/geoip"
+	"../utils/systemd"
+	tlsutil "../utils/tls"
+	"../utils/unixsocket"
+
+	"github.com/Sirupsen/logrus"
+)
+
+/**
+ * Listening address of server, api, metrics or debug
+ */
+type bindAddr struct {
+	network string
+	host    string
+	port    string
+	owner   string
+}
+
+/**
+ * Validates configuration: logging, api, metrics, debug and upgrade sections, every server as on start,
+ * tls certificates and keys files, balancer plugins, geoip databases, tracing, events, cluster, ha and conflicting binds.
+ * Returns all found errors, nothing is started
+ */
+func Validate(cfg config.Config) []error {
+
+	errs := []error{}
+
+	fail := func(prefix string, err error) {
+		errs = append(errs, errors.New(prefix+": "+err.Error()))
+	}
+
+	/* Logging */
+
+	if cfg.Logging.Level != "" {
+		if _, err := logrus.ParseLevel(cfg.Logging.Level); err != nil {
+			fail("logging.level", err)
+		}
+	}
+
+	for name, level := range cfg.Logging.Levels {
+		if _, err := logrus.ParseLevel(level); err != nil {
+			fail("logging.levels."+name, err)
+		}
+	}
+
+	if cfg.Logging.MaxAge != "" {
+		if _, err := time.ParseDuration(cfg.Logging.MaxAge); err != nil {
+			fail("logging.max_age", err)
+		}
+	}
+
+	if cfg.Upgrade.ReadyTimeout != "" {
+		if _, err := time.ParseDuration(cfg.Upgrade.ReadyTimeout); err != nil {
+			fail("upgrade.ready_timeout", err)
+		}
+	}
+
+	/* Plugins, loaded before servers are validated as their balancers may be used */
+
+	if err := balance.LoadPlugins(cfg.Plugins.Balancers); err != nil {
+		fail("plugins", err)
+	}
+
+	/* Geoip databases */
+
+	if err := geoip.CheckDatabase(cfg.Geoip.CountryDatabase); err != nil {
+		fail("geoip.country_database", err)
+	}
+
+	if err := geoip.CheckDatabase(cfg.Geoip.AsnDatabase); err != nil {
+		fail("geoip.asn_database", err)
+	}
+
+	/* Tracing */
+
+	if err := tracing.Validate(cfg.Tracing); err != nil {
+		fail("tracing", err)
+	}
+
+	/* Events */
+
+	if err := events.Validate(cfg.Events); err != nil {
+		fail("events", err)
+	}
+
+	/* Cluster */
+
+	if err := cluster.Validate(cfg.Cluster); err != nil {
+		fail("cluster", err)
+	}
+
+	/* High availability */
+
+	if err := ha.Validate(cfg.Ha); err != nil {
+		fail("ha", err)
+	}
+
+	binds := []bindAddr{}
+
+	addBind := func(owner string, network string, bind string) {
+
+		// Sockets passed by systemd are bound already
+		if systemd.IsSocketBind(bind) {
+			return
+		}
+
+		// Unix sockets conflict by path only
+		if unixsocket.IsSocketBind(bind) {
+			binds = append(binds, bindAddr{"unix", unixsocket.Path(bind), "", owner})
+			return
+		}
+
+		host, port, err := net.SplitHostPort(bind)
+		if err != nil {
+			fail(owner+".bind", err)
+			return
+		}
+
+		binds = append(binds, bindAddr{network, host, port, owner})
+	}
+
+	/* Api, metrics and debug */
+
+	if cfg.Api.Enabled {
+		addBind("api", "tcp", cfg.Api.Bind)
+
+		if cfg.Api.Tls != nil {
+			if _, err := tls.LoadX509KeyPair(cfg.Api.Tls.CertPath, cfg.Api.Tls.KeyPath); err != nil {
+				fail("api.tls", err)
+			}
+
+			if cfg.Api.Tls.ClientCaPath != "" {
+				if err := checkCaFile(cfg.Api.Tls.ClientCaPath); err != nil {
+					fail("api.tls.client_ca_path", err)
+				}
+			}
+		}
+	}
+
+	if cfg.Metrics.Enabled {
+		bind := cfg.Metrics.Bind
+		if bind == "" {
+			bind = ":9284"
+		}
+		addBind("metrics", "tcp", bind)
+	}
+
+	if cfg.Debug.Enabled {
+		bind := cfg.Debug.Bind
+		if bind == "" {
+			bind = "localhost:6060"
+		}
+		addBind("debug", "tcp", bind)
+
+		if cfg.Debug.BlockProfileRate < 0 {
+			fail("debug.block_profile_rate", errors.New("should not be negative"))
+		}
+
+		if cfg.Debug.MutexProfileFraction < 0 {
+			fail("debug.mutex_profile_fraction", errors.New("should not be negative"))
+		}
+	}
+
+	if cfg.Cluster.Enabled {
+		bind := cfg.Cluster.Bind
+		if bind == "" {
+			bind = cluster.DEFAULT_BIND
+		}
+		addBind("cluster", "tcp", bind)
+	}
+
+	if cfg.Ha.Enabled {
+		bind := cfg.Ha.Bind
+		if bind == "" {
+			bind = ha.DEFAULT_BIND
+		}
+		addBind("ha", "udp", bind)
+	}
+
+	/* Servers, in order of names for stable output */
+
+	names := []string{}
+	for name := range cfg.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+
+		prefix := "servers." + name
+
+		server, err := prepareConfig(name, cfg.Servers[name], cfg.Defaults)
+		if err != nil {
+			fail(prefix, err)
+			continue
+		}
+
+		network := "tcp"
+		if server.Protocol == "udp" || server.Protocol == "dtls" || server.Protocol == "quic" {
+			network = "udp"
+		}
+		for _, bind := range server.Bind {
+			addBind(prefix, network, bind)
+		}
+
+		if server.Geoip != nil && cfg.Geoip.CountryDatabase == "" {
+			fail(prefix+".geoip", errors.New("geoip.country_database is required to know client region"))
+		}
+
+		if server.Access != nil {
+			for _, rule := range server.Access.Rules {
+				if strings.Contains(rule, " country=") && cfg.Geoip.CountryDatabase == "" {
+					fail(prefix+".access", errors.New("geoip.country_database is required for rule "+rule))
+				}
+				if strings.Contains(rule, " asn=") && cfg.Geoip.AsnDatabase == "" {
+					fail(prefix+".access", errors.New("geoip.asn_database is required for rule "+rule))
+				}
+			}
+		}
+
+		if server.Tls != nil && (server.Protocol == "tls" || server.Protocol == "dtls" || server.Protocol == "http" || server.Protocol == "quic") {
+			if _, err := tlsutil.NewCertificates(server.Tls); err != nil {
+				fail(prefix+".tls", err)
+			}
+
+			if server.Tls.ClientAuth != nil {
+				if err := tlsutil.ConfigureClientAuth(&tls.Config{}, server.Tls.ClientAuth); err != nil {
+					fail(prefix+".tls.client_auth", err)
+				}
+			}
+		}
+
+		if bt := server.BackendsTls; bt != nil {
+			if bt.CertPath != nil && bt.KeyPath != nil {
+				if _, err := tls.LoadX509KeyPair(*bt.CertPath, *bt.KeyPath); err != nil {
+					fail(prefix+".backends_tls", err)
+				}
+			}
+
+			if bt.RootCaCertPath != nil {
+				if err := checkCaFile(*bt.RootCaCertPath); err != nil {
+					fail(prefix+".backends_tls.root_ca_cert_path", err)
+				}
+			}
+		}
+	}
+
+	/* Binds conflicts, wildcard host conflicts with any host of the same port */
+
+	for i, a := range binds {
+		for _, b := range binds[i+1:] {
+			if a.network != b.network || a.port != b.port {
+				continue
+			}
+
+			if a.network == "unix" && a.host == b.host {
+				errs = append(errs, errors.New(b.owner+".bind: conflicts with "+a.owner+" bind "+unixsocket.BindPrefix+a.host))
+			} else if a.network != "unix" && (a.host == b.host || isWildcard(a.host) || isWildcard(b.host)) {
+				errs = append(errs, errors.New(b.owner+".bind: conflicts with "+a.owner+" bind "+net.JoinHostPort(a.host, a.port)))
+			}
+		}
+	}
+
+	return errs
+}
+
+/**
+ * Validates configuration loaded again from the original source,
+ * as it would be on reload, without applying it
+ */
+func CheckReload() ([]error, error) {
+
+	if configLoader == nil {
+		return nil, errors.New("Reload is not supported for current configuration source")
+	}
+
+	cfg, err := configLoader()
+	if err != nil {
+		return nil, err
+	}
+
+	return Validate(*cfg), nil
+}
+
+/**
+ * Checks if file has PEM certificates
+ */
+func checkCaFile(path string) error {
+
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return errors.New("No certificates found in " + path)
+	}
+
+	return nil
+}
+
+/**
+ * Checks if bind host means all addresses
+ */
+func isWildcard(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::" || host == "*"
+}
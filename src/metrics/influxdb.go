@@ -0,0 +1,229 @@
+/**
+ * influxdb.go - InfluxDB line protocol metrics pusher
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"../config"
+	"../logging"
+	"../stats"
+	"../utils"
+)
+
+const (
+	/* Default interval to push metrics */
+	INFLUXDB_DEFAULT_INTERVAL = 10 * time.Second
+
+	/* Default write request timeout */
+	INFLUXDB_DEFAULT_TIMEOUT = 5 * time.Second
+)
+
+/**
+ * Escapes measurement, tag keys and values in line protocol
+ */
+var influxdbEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+/**
+ * Pushes servers and backends stats to InfluxDB
+ */
+type Influxdb struct {
+	cfg    config.InfluxdbConfig
+	client *http.Client
+
+	/* Write endpoint url with query */
+	url string
+
+	/* Configured tags part of series key, sorted */
+	tags string
+}
+
+/**
+ * Starts pushing metrics to InfluxDB every interval
+ */
+func startInfluxdb(cfg config.InfluxdbConfig) {
+
+	log := logging.For("metrics/influxdb")
+
+	i, err := NewInfluxdb(cfg)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	interval := utils.ParseDurationOrDefault(cfg.Interval, INFLUXDB_DEFAULT_INTERVAL)
+
+	log.Info("Pushing metrics to InfluxDB ", cfg.Url, " every ", interval)
+
+	for now := range time.Tick(interval) {
+		if err := i.Push(stats.GetAllStats(), now); err != nil {
+			log.Warn("Can't push metrics to InfluxDB ", cfg.Url, ": ", err)
+		}
+	}
+}
+
+/**
+ * Creates pusher, using v2 api if token is set and v1 api otherwise
+ */
+func NewInfluxdb(cfg config.InfluxdbConfig) (*Influxdb, error) {
+
+	if cfg.Url == "" {
+		return nil, errors.New("metrics.influxdb.url should be set")
+	}
+
+	query := url.Values{}
+	query.Set("precision", "s")
+
+	path := "/write"
+
+	if cfg.Token != "" {
+		if cfg.Organization == "" || cfg.Bucket == "" {
+			return nil, errors.New("metrics.influxdb.organization and bucket should be set with token")
+		}
+		path = "/api/v2/write"
+		query.Set("org", cfg.Organization)
+		query.Set("bucket", cfg.Bucket)
+	} else {
+		if cfg.Database == "" {
+			return nil, errors.New("metrics.influxdb.database should be set")
+		}
+		query.Set("db", cfg.Database)
+		if cfg.RetentionPolicy != "" {
+			query.Set("rp", cfg.RetentionPolicy)
+		}
+	}
+
+	keys := []string{}
+	for k := range cfg.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := ""
+	for _, k := range keys {
+		tags += "," + influxdbEscaper.Replace(k) + "=" + influxdbEscaper.Replace(cfg.Tags[k])
+	}
+
+	return &Influxdb{
+		cfg:    cfg,
+		client: &http.Client{Timeout: utils.ParseDurationOrDefault(cfg.Timeout, INFLUXDB_DEFAULT_TIMEOUT)},
+		url:    strings.TrimRight(cfg.Url, "/") + path + "?" + query.Encode(),
+		tags:   tags,
+	}, nil
+}
+
+/**
+ * Writes points of all servers and backends in one request
+ */
+func (this *Influxdb) Push(all map[string]stats.Stats, now time.Time) error {
+
+	b := &bytes.Buffer{}
+	ts := now.Unix()
+
+	for name, s := range all {
+
+		live := 0
+		for _, backend := range s.Backends {
+			if backend.Stats.Live {
+				live++
+			}
+
+			fmt.Fprintf(b, "%s_backend,server=%s,host=%s,port=%s%s live=%t,active_connections=%di,connections_total=%di,refused_connections_total=%di,rx_total=%di,tx_total=%di,rx_second=%di,tx_second=%di %d\n",
+				namespace,
+				influxdbEscaper.Replace(name),
+				influxdbEscaper.Replace(backend.Host),
+				influxdbEscaper.Replace(backend.Port),
+				this.tags,
+				backend.Stats.Live,
+				backend.Stats.ActiveConnections,
+				backend.Stats.TotalConnections,
+				backend.Stats.RefusedConnections,
+				backend.Stats.RxBytes,
+				backend.Stats.TxBytes,
+				backend.Stats.RxSecond,
+				backend.Stats.TxSecond,
+				ts,
+			)
+
+			for reason, n := range backend.Stats.Disconnects {
+				fmt.Fprintf(b, "%s_backend_disconnects,server=%s,host=%s,port=%s,reason=%s%s total=%di %d\n",
+					namespace,
+					influxdbEscaper.Replace(name),
+					influxdbEscaper.Replace(backend.Host),
+					influxdbEscaper.Replace(backend.Port),
+					influxdbEscaper.Replace(reason),
+					this.tags,
+					n,
+					ts,
+				)
+			}
+		}
+
+		for reason, n := range s.Disconnects {
+			fmt.Fprintf(b, "%s_server_disconnects,server=%s,reason=%s%s total=%di %d\n",
+				namespace,
+				influxdbEscaper.Replace(name),
+				influxdbEscaper.Replace(reason),
+				this.tags,
+				n,
+				ts,
+			)
+		}
+
+		fmt.Fprintf(b, "%s_server,server=%s%s active_connections=%di,rx_total=%di,tx_total=%di,rx_second=%di,tx_second=%di,dial_retries_total=%di,backends=%di,live_backends=%di %d\n",
+			namespace,
+			influxdbEscaper.Replace(name),
+			this.tags,
+			s.ActiveConnections,
+			s.RxTotal,
+			s.TxTotal,
+			s.RxSecond,
+			s.TxSecond,
+			s.DialRetriesTotal,
+			len(s.Backends),
+			live,
+			ts,
+		)
+	}
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", this.url, b)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if this.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+this.cfg.Token)
+	} else if this.cfg.Username != "" {
+		req.SetBasicAuth(this.cfg.Username, this.cfg.Password)
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New(resp.Status + " " + strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+/**
+ * metrics.go - prometheus metrics exporter
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"os"
+
+	"../config"
+	"../logging"
+	"../stats"
+	"../utils/upgrade"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	/* Default metrics server bind */
+	DEFAULT_BIND = ":9284"
+
+	namespace = "gobetween"
+)
+
+/* http server exposing /metrics */
+var server *http.Server
+
+var (
+	serverLabels  = []string{"server"}
+	backendLabels = []string{"server", "host", "port"}
+
+	serverActiveConnections = prometheus.NewDesc(namespace+"_server_active_connections",
+		"Current active client connections", serverLabels, nil)
+	serverRxBytes = prometheus.NewDesc(namespace+"_server_rx_bytes_total",
+		"Total received bytes from backends", serverLabels, nil)
+	serverTxBytes = prometheus.NewDesc(namespace+"_server_tx_bytes_total",
+		"Total transmitted bytes to backends", serverLabels, nil)
+	serverRxSecond = prometheus.NewDesc(namespace+"_server_rx_bytes_per_second",
+		"Received bytes from backends per second", serverLabels, nil)
+	serverTxSecond = prometheus.NewDesc(namespace+"_server_tx_bytes_per_second",
+		"Transmitted bytes to backends per second", serverLabels, nil)
+	serverRxThrottledBytes = prometheus.NewDesc(namespace+"_server_rx_throttled_bytes_total",
+		"Total received bytes from backends delayed by throttling", serverLabels, nil)
+	serverTxThrottledBytes = prometheus.NewDesc(namespace+"_server_tx_throttled_bytes_total",
+		"Total transmitted bytes to backends delayed by throttling", serverLabels, nil)
+	serverDialRetries = prometheus.NewDesc(namespace+"_server_dial_retries_total",
+		"Total retries to connect to the next backend after failed one", serverLabels, nil)
+	serverBackends = prometheus.NewDesc(namespace+"_server_backends",
+		"Current discovered backends count", serverLabels, nil)
+	serverLiveBackends = prometheus.NewDesc(namespace+"_server_live_backends",
+		"Current live backends count", serverLabels, nil)
+	serverDisconnects = prometheus.NewDesc(namespace+"_server_disconnects_total",
+		"Total ended or rejected client connections by reason", append(serverLabels, "reason"), nil)
+
+	backendLive = prometheus.NewDesc(namespace+"_backend_live",
+		"Backend healthcheck status (1 - live, 0 - not live)", backendLabels, nil)
+	backendActiveConnections = prometheus.NewDesc(namespace+"_backend_active_connections",
+		"Current active connections to backend", backendLabels, nil)
+	backendTotalConnections = prometheus.NewDesc(namespace+"_backend_connections_total",
+		"Total connections to backend", backendLabels, nil)
+	backendRefusedConnections = prometheus.NewDesc(namespace+"_backend_refused_connections_total",
+		"Total refused connections to backend", backendLabels, nil)
+	backendRxBytes = prometheus.NewDesc(namespace+"_backend_rx_bytes_total",
+		"Total received bytes from backend", backendLabels, nil)
+	backendTxBytes = prometheus.NewDesc(namespace+"_backend_tx_bytes_total",
+		"Total transmitted bytes to backend", backendLabels, nil)
+	backendRxSecond = prometheus.NewDesc(namespace+"_backend_rx_bytes_per_second",
+		"Received bytes from backend per second", backendLabels, nil)
+	backendTxSecond = prometheus.NewDesc(namespace+"_backend_tx_bytes_per_second",
+		"Transmitted bytes to backend per second", backendLabels, nil)
+	backendDisconnects = prometheus.NewDesc(namespace+"_backend_disconnects_total",
+		"Total ended connections to backend by reason", append(backendLabels, "reason"), nil)
+)
+
+/**
+ * Collects servers and backends stats on every scrape
+ */
+type collector struct{}
+
+/**
+ * Describe all metrics exposed by collector
+ */
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		serverActiveConnections, serverRxBytes, serverTxBytes, serverRxSecond, serverTxSecond,
+		serverRxThrottledBytes, serverTxThrottledBytes, serverDialRetries, serverBackends, serverLiveBackends,
+		serverDisconnects, backendLive, backendActiveConnections, backendTotalConnections, backendRefusedConnections,
+		backendRxBytes, backendTxBytes, backendRxSecond, backendTxSecond, backendDisconnects,
+	} {
+		ch <- d
+	}
+}
+
+/**
+ * Collect current stats
+ */
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	counter := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+	}
+
+	for name, s := range stats.GetAllStats() {
+
+		gauge(serverActiveConnections, float64(s.ActiveConnections), name)
+		counter(serverRxBytes, float64(s.RxTotal), name)
+		counter(serverTxBytes, float64(s.TxTotal), name)
+		gauge(serverRxSecond, float64(s.RxSecond), name)
+		gauge(serverTxSecond, float64(s.TxSecond), name)
+		counter(serverRxThrottledBytes, float64(s.RxThrottledTotal), name)
+		counter(serverTxThrottledBytes, float64(s.TxThrottledTotal), name)
+		counter(serverDialRetries, float64(s.DialRetriesTotal), name)
+
+		for reason, n := range s.Disconnects {
+			counter(serverDisconnects, float64(n), name, reason)
+		}
+
+		live := 0
+		for _, b := range s.Backends {
+
+			labels := []string{name, b.Host, b.Port}
+
+			isLive := 0.0
+			if b.Stats.Live {
+				isLive = 1
+				live++
+			}
+
+			gauge(backendLive, isLive, labels...)
+			gauge(backendActiveConnections, float64(b.Stats.ActiveConnections), labels...)
+			counter(backendTotalConnections, float64(b.Stats.TotalConnections), labels...)
+			counter(backendRefusedConnections, float64(b.Stats.RefusedConnections), labels...)
+			counter(backendRxBytes, float64(b.Stats.RxBytes), labels...)
+			counter(backendTxBytes, float64(b.Stats.TxBytes), labels...)
+			gauge(backendRxSecond, float64(b.Stats.RxSecond), labels...)
+			gauge(backendTxSecond, float64(b.Stats.TxSecond), labels...)
+
+			for reason, n := range b.Stats.Disconnects {
+				counter(backendDisconnects, float64(n), append(labels, reason)...)
+			}
+		}
+
+		gauge(serverBackends, float64(len(s.Backends)), name)
+		gauge(serverLiveBackends, float64(live), name)
+	}
+}
+
+/**
+ * Starts metrics server exposing /metrics endpoint, listening on
+ * bind before returning, and metrics pushers
+ */
+func Start(cfg config.MetricsConfig) {
+
+	log := logging.For("metrics")
+
+	if cfg.Statsd != nil {
+		go startStatsd(*cfg.Statsd)
+	}
+
+	if cfg.Influxdb != nil {
+		go startInfluxdb(*cfg.Influxdb)
+	}
+
+	if !cfg.Enabled {
+		log.Info("Metrics disabled")
+		return
+	}
+
+	if cfg.Bind == "" {
+		cfg.Bind = DEFAULT_BIND
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector{})
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(os.Getpid(), namespace))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	log.Info("Starting metrics server ", cfg.Bind)
+
+	// Listener is taken from old process on upgrade
+	listener, err := upgrade.Listen(cfg.Bind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+/**
+ * Stops metrics server
+ */
+func Stop() {
+	if server != nil {
+		server.Close()
+	}
+}
@@ -0,0 +1,289 @@
+/**
+ * statsd.go - statsd / DogStatsD metrics pusher
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"../config"
+	"../core"
+	"../logging"
+	"../stats"
+	"../utils"
+)
+
+const (
+	/* Default interval to push metrics */
+	STATSD_DEFAULT_INTERVAL = 10 * time.Second
+
+	/* Default metric names prefix */
+	STATSD_DEFAULT_PREFIX = namespace
+
+	/* Max udp packet size, to fit to common network MTU */
+	statsdMaxPacketSize = 1432
+)
+
+/**
+ * Chars not allowed in metric name parts
+ */
+var statsdInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+/**
+ * Pushes servers and backends stats to statsd
+ */
+type Statsd struct {
+	cfg    config.StatsdConfig
+	conn   net.Conn
+	prefix string
+
+	/* Packet being filled with lines */
+	packet bytes.Buffer
+
+	/* Counters values of previous push, to send deltas */
+	counters map[string]uint64
+
+	/* Backends live status of previous push, to detect transitions */
+	live map[string]bool
+}
+
+/**
+ * Starts pushing metrics to statsd every interval
+ */
+func startStatsd(cfg config.StatsdConfig) {
+
+	log := logging.For("metrics/statsd")
+
+	if cfg.Address == "" {
+		log.Error("metrics.statsd.address should be set")
+		return
+	}
+
+	interval := utils.ParseDurationOrDefault(cfg.Interval, STATSD_DEFAULT_INTERVAL)
+
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		log.Error("Can't connect to statsd ", cfg.Address, ": ", err)
+		return
+	}
+
+	s := NewStatsd(cfg, conn)
+
+	log.Info("Pushing metrics to statsd ", cfg.Address, " every ", interval)
+
+	for range time.Tick(interval) {
+		s.Push(stats.GetAllStats())
+	}
+}
+
+/**
+ * Creates pusher sending metrics packets to conn
+ */
+func NewStatsd(cfg config.StatsdConfig, conn net.Conn) *Statsd {
+
+	prefix := strings.TrimRight(cfg.Prefix, ".")
+	if cfg.Prefix == "" {
+		prefix = STATSD_DEFAULT_PREFIX
+	}
+
+	return &Statsd{
+		cfg:      cfg,
+		conn:     conn,
+		prefix:   prefix,
+		counters: map[string]uint64{},
+		live:     map[string]bool{},
+	}
+}
+
+/**
+ * Sends metrics of all servers. Counters are sent as deltas since
+ * previous push, so they are not sent on the first one
+ */
+func (this *Statsd) Push(all map[string]stats.Stats) {
+
+	counters := map[string]uint64{}
+	live := map[string]bool{}
+
+	for name, s := range all {
+
+		server := []string{"server:" + name}
+
+		this.gauge(server, "server.active_connections", uint64(s.ActiveConnections))
+		this.gauge(server, "server.rx_bytes_per_second", uint64(s.RxSecond))
+		this.gauge(server, "server.tx_bytes_per_second", uint64(s.TxSecond))
+		this.counter(counters, server, "server.rx_bytes", s.RxTotal)
+		this.counter(counters, server, "server.tx_bytes", s.TxTotal)
+		this.counter(counters, server, "server.dial_retries", s.DialRetriesTotal)
+
+		for reason, n := range s.Disconnects {
+			this.counter(counters, append(server, "reason:"+reason), "server.disconnects", n)
+		}
+
+		liveBackends := 0
+		for _, b := range s.Backends {
+
+			backend := []string{"server:" + name, "host:" + b.Host, "port:" + b.Port}
+
+			isLive := uint64(0)
+			if b.Stats.Live {
+				isLive = 1
+				liveBackends++
+			}
+
+			this.gauge(backend, "backend.live", isLive)
+			this.gauge(backend, "backend.active_connections", uint64(b.Stats.ActiveConnections))
+			this.gauge(backend, "backend.rx_bytes_per_second", uint64(b.Stats.RxSecond))
+			this.gauge(backend, "backend.tx_bytes_per_second", uint64(b.Stats.TxSecond))
+			this.counter(counters, backend, "backend.connections", uint64(b.Stats.TotalConnections))
+			this.counter(counters, backend, "backend.refused_connections", b.Stats.RefusedConnections)
+			this.counter(counters, backend, "backend.rx_bytes", b.Stats.RxBytes)
+			this.counter(counters, backend, "backend.tx_bytes", b.Stats.TxBytes)
+
+			for reason, n := range b.Stats.Disconnects {
+				this.counter(counters, append(backend, "reason:"+reason), "backend.disconnects", n)
+			}
+
+			key := name + "/" + b.Address()
+			live[key] = b.Stats.Live
+
+			if was, ok := this.live[key]; ok && was != b.Stats.Live {
+				this.transition(name, b, backend)
+			}
+		}
+
+		this.gauge(server, "server.backends", uint64(len(s.Backends)))
+		this.gauge(server, "server.live_backends", uint64(liveBackends))
+	}
+
+	this.counters = counters
+	this.live = live
+
+	this.flush()
+}
+
+/**
+ * Sends backend health transition counter, and event for DogStatsD
+ */
+func (this *Statsd) transition(server string, b core.Backend, tags []string) {
+
+	this.line(tags, "backend.health_transitions", "1|c")
+
+	if !this.cfg.Dogstatsd {
+		return
+	}
+
+	status, alert := "down", "warning"
+	if b.Stats.Live {
+		status, alert = "up", "success"
+	}
+
+	title := "gobetween backend " + status
+	text := "Backend " + b.Address() + " of server " + server + " is " + status
+
+	this.write(fmt.Sprintf("_e{%d,%d}:%s|%s|t:%s%s", len(title), len(text), title, text, alert, this.tags(tags)))
+}
+
+/**
+ * Sends gauge
+ */
+func (this *Statsd) gauge(tags []string, metric string, value uint64) {
+	this.line(tags, metric, fmt.Sprintf("%d|g", value))
+}
+
+/**
+ * Sends delta of total counter since previous push
+ */
+func (this *Statsd) counter(counters map[string]uint64, tags []string, metric string, total uint64) {
+
+	key := strings.Join(tags, ",") + "/" + metric
+	counters[key] = total
+
+	previous, ok := this.counters[key]
+	if !ok {
+		return
+	}
+
+	// Counter was reset, i.e. server was recreated
+	if total < previous {
+		previous = 0
+	}
+
+	this.line(tags, metric, fmt.Sprintf("%d|c", total-previous))
+}
+
+/**
+ * Sends metric line. For plain statsd tag values are put to name,
+ * i.e. gobetween.backend.<server>.<host>_<port>.live, and as tags for DogStatsD
+ */
+func (this *Statsd) line(tags []string, metric string, value string) {
+
+	if this.cfg.Dogstatsd {
+		this.write(this.prefix + "." + metric + ":" + value + this.tags(tags))
+		return
+	}
+
+	parts := strings.SplitN(metric, ".", 2)
+
+	values := []string{}
+	for _, tag := range tags {
+		values = append(values, statsdInvalidChars.ReplaceAllString(strings.SplitN(tag, ":", 2)[1], "_"))
+	}
+
+	// Host and port make one name part
+	if strings.HasPrefix(metric, "backend.") && len(values) >= 3 {
+		values = append([]string{values[0], values[1] + "_" + values[2]}, values[3:]...)
+	}
+
+	this.write(this.prefix + "." + parts[0] + "." + strings.Join(values, ".") + "." + parts[1] + ":" + value)
+}
+
+/**
+ * Returns DogStatsD tags section with configured tags
+ */
+func (this *Statsd) tags(tags []string) string {
+
+	all := append(append([]string{}, this.cfg.Tags...), tags...)
+	if len(all) == 0 {
+		return ""
+	}
+
+	return "|#" + strings.Join(all, ",")
+}
+
+/**
+ * Adds line to packet, sending packet if it would not fit
+ */
+func (this *Statsd) write(line string) {
+
+	if this.packet.Len() > 0 && this.packet.Len()+1+len(line) > statsdMaxPacketSize {
+		this.flush()
+	}
+
+	if this.packet.Len() > 0 {
+		this.packet.WriteByte('\n')
+	}
+
+	this.packet.WriteString(line)
+}
+
+/**
+ * Sends packet
+ */
+func (this *Statsd) flush() {
+
+	if this.packet.Len() == 0 {
+		return
+	}
+
+	if _, err := this.conn.Write(this.packet.Bytes()); err != nil {
+		logging.For("metrics/statsd").Warn("Can't send metrics to statsd ", this.cfg.Address, ": ", err)
+	}
+
+	this.packet.Reset()
+}
@@ -0,0 +1,217 @@
+/**
+ * listener.go - client connections accepting with limits and tracking
+ */
+
+package http
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../../core"
+	"../../logging"
+	"../modules/connlimit"
+)
+
+/**
+ * Client connections of server, accepted by its sockets if allowed by rate limit and
+ * connections limits, kept to be closed on stop, including hijacked (upgraded) ones
+ */
+type listener struct {
+
+	/* Server connections are accepted for */
+	server *Server
+
+	/* Current connections */
+	sync.Mutex
+	conns map[*conn]bool
+
+	/* Current connections count, updated atomically */
+	count int64
+}
+
+/**
+ * Listening socket of one of server binds, accepting connections with server listener.
+ * Connections are accepted in background and every one waits for its slots separately,
+ * so connections queued over limits don't hold others
+ */
+type socket struct {
+	net.Listener
+
+	/* Listener accepted connections are tracked by */
+	listener *listener
+
+	/* Connections allowed by limits, waiting to be served */
+	admitted chan net.Conn
+
+	/* Closed when socket failed to accept, with err set */
+	done chan bool
+	err  error
+}
+
+/**
+ * Client connection releasing its slots when closed
+ */
+type conn struct {
+	net.Conn
+
+	/* Listener connection was accepted by */
+	listener *listener
+
+	/* Client ip connection slot is taken for */
+	ip net.IP
+
+	/* Releases connection once */
+	closeOnce sync.Once
+}
+
+/**
+ * Creates listener of server, listening sockets are created on start
+ */
+func newListener(server *Server) *listener {
+	return &listener{
+		server: server,
+		conns:  make(map[*conn]bool),
+	}
+}
+
+/**
+ * Creates socket of listening one, and starts accepting its connections
+ */
+func newSocket(l net.Listener, listener *listener) *socket {
+
+	s := &socket{
+		Listener: l,
+		listener: listener,
+		admitted: make(chan net.Conn),
+		done:     make(chan bool),
+	}
+
+	go s.acceptAll()
+
+	return s
+}
+
+/**
+ * Accepts connections until socket is closed, admitting every one in its own goroutine
+ */
+func (this *socket) acceptAll() {
+
+	for {
+		c, err := this.Listener.Accept()
+		if err != nil {
+			this.err = err
+			close(this.done)
+			return
+		}
+
+		go func() {
+			tracked := this.listener.admit(c)
+			if tracked == nil {
+				return
+			}
+
+			select {
+			case this.admitted <- tracked:
+			case <-this.done:
+				tracked.Close()
+			}
+		}()
+	}
+}
+
+/**
+ * Returns next client connection allowed by limits
+ */
+func (this *socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-this.admitted:
+		return c, nil
+	case <-this.done:
+		return nil, this.err
+	}
+}
+
+/**
+ * Takes slots of accepted client connection and tracks it,
+ * returns nil closing connection if it exceeds limits
+ */
+func (this *listener) admit(c net.Conn) *conn {
+
+	log := logging.For("http/server")
+
+	ip := core.AddrIp(c.RemoteAddr())
+
+	if this.server.rateLimit != nil && !this.server.rateLimit.Allows(ip, time.Now()) {
+		log.Debug("Client exceeded connections rate limit ", c.RemoteAddr())
+		this.server.statsHandler.Disconnected("rate_limited")
+		c.Close()
+		return nil
+	}
+
+	if err := this.server.limits.Acquire(ip); err != nil {
+		if err != connlimit.ErrStopping {
+			this.server.statsHandler.Disconnected(connlimit.Reason(err))
+		}
+		c.Close()
+		return nil
+	}
+
+	tracked := &conn{Conn: c, listener: this, ip: ip}
+
+	this.Lock()
+	this.conns[tracked] = true
+	this.Unlock()
+
+	atomic.AddInt64(&this.count, 1)
+
+	return tracked
+}
+
+/**
+ * Returns current connections count
+ */
+func (this *listener) Count() uint {
+	return uint(atomic.LoadInt64(&this.count))
+}
+
+/**
+ * Closes all current connections
+ */
+func (this *listener) closeAll() {
+
+	this.Lock()
+	conns := make([]*conn, 0, len(this.conns))
+	for c := range this.conns {
+		conns = append(conns, c)
+	}
+	this.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+/**
+ * Closes connection, releasing its slots
+ */
+func (this *conn) Close() error {
+
+	err := this.Conn.Close()
+
+	this.closeOnce.Do(func() {
+		l := this.listener
+
+		l.Lock()
+		delete(l.conns, this)
+		l.Unlock()
+
+		atomic.AddInt64(&l.count, -1)
+
+		l.server.limits.Release(this.ip)
+	})
+
+	return err
+}
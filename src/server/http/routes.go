@@ -0,0 +1,113 @@
+/**
+ * routes.go - host and path prefix routing to separate backends pools
+ */
+
+package http
+
+import (
+	"strings"
+
+	"../../config"
+	"../../stats"
+	"../scheduler"
+)
+
+/**
+ * Route of host and / or path prefix to its own backends pool
+ */
+type route struct {
+
+	/* Host, "*." prefixed matches subdomains, empty matches any host */
+	host string
+
+	/* Path prefix, empty matches any path */
+	pathPrefix string
+
+	/* Remove path prefix from path of proxied request */
+	stripPrefix bool
+
+	/* Scheduler of route backends pool */
+	scheduler *scheduler.Scheduler
+
+	/* Stats handler of route backends pool */
+	statsHandler *stats.Handler
+}
+
+/**
+ * Creates routes for server http config.
+ * Every route has stats named "<server>/<host><path_prefix>"
+ */
+func newRoutes(name string, cfg config.Server) []*route {
+
+	if cfg.Http == nil {
+		return nil
+	}
+
+	routes := make([]*route, 0, len(cfg.Http.Routes))
+
+	for _, routeCfg := range cfg.Http.Routes {
+
+		statsHandler := stats.NewHandler(name + "/" + routeCfg.Host + routeCfg.PathPrefix)
+
+		routes = append(routes, &route{
+			host:         strings.ToLower(routeCfg.Host),
+			pathPrefix:   routeCfg.PathPrefix,
+			stripPrefix:  routeCfg.StripPrefix,
+			statsHandler: statsHandler,
+			scheduler:    scheduler.NewPool(cfg, statsHandler, routeCfg.Balance, routeCfg.Discovery, routeCfg.Healthcheck),
+		})
+	}
+
+	return routes
+}
+
+/**
+ * Checks if route matches request host (lowercased, without port) and path
+ */
+func (this *route) matches(host string, path string) bool {
+
+	if this.pathPrefix != "" && !strings.HasPrefix(path, this.pathPrefix) {
+		return false
+	}
+
+	if this.host == "" {
+		return true
+	}
+
+	if strings.HasPrefix(this.host, "*.") {
+		return strings.HasSuffix(host, this.host[1:])
+	}
+
+	return this.host == host
+}
+
+/**
+ * Returns path of proxied request, without prefix if it's stripped
+ */
+func (this *route) rewrite(path string) string {
+
+	if !this.stripPrefix {
+		return path
+	}
+
+	path = strings.TrimPrefix(path, this.pathPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+/**
+ * Returns first route matching host and path, or nil
+ */
+func (this *Server) routeFor(host string, path string) *route {
+
+	for _, r := range this.routes {
+		if r.matches(host, path) {
+			return r
+		}
+	}
+
+	return nil
+}
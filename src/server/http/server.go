@@ -0,0 +1,648 @@
+/**
+ * server.go - http reverse proxy server implementation
+ */
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"../../balance"
+	"../../config"
+	"../../core"
+	"../../discovery"
+	"../../healthcheck"
+	"../../logging"
+	"../../stats"
+	"../../tracing"
+	"../../utils"
+	"../../utils/systemd"
+	tlsutil "../../utils/tls"
+	"../../utils/unixsocket"
+	"../../utils/upgrade"
+	"../modules/access"
+	"../modules/connlimit"
+	"../modules/ratelimit"
+	"../scheduler"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+/**
+ * Server terminating http and proxying requests to backends
+ * pool of first route matching request host and path
+ */
+type Server struct {
+
+	/* Server friendly name */
+	name string
+
+	/* Configuration */
+	cfg config.Server
+
+	/* Scheduler of requests not matching any route */
+	scheduler scheduler.Scheduler
+
+	/* Host and path routes to separate backends pools */
+	routes []*route
+
+	/* Stats handler */
+	statsHandler *stats.Handler
+
+	/* Http server serving client connections */
+	httpServer *http.Server
+
+	/* Proxy of requests to backends */
+	proxy *httputil.ReverseProxy
+
+	/* Writer of http server errors to log, closed on stop */
+	errorLog *io.PipeWriter
+
+	/* Listener of client connections */
+	listener *listener
+
+	/* Tls config used to connect to backends */
+	backendsTlsConfig *tls.Config
+
+	/* Reloadable tls certificates, if tls is terminated */
+	certificates *tlsutil.Certificates
+
+	/* Closed when server starts stopping, so queued connections give up */
+	stopping chan bool
+
+	/* Server is drained only once, even if stopped or drained again */
+	drainOnce sync.Once
+
+	/* ----- modules ----- */
+
+	/* Access module checks if client is allowed to make requests */
+	access *access.Access
+
+	/* Rate limit module checks if client does not connect too often */
+	rateLimit *ratelimit.RateLimit
+
+	/* Connections limits module rejects connections over max_connections_per_client
+	 * and queues ones over server and process-wide max_connections */
+	limits *connlimit.Limiters
+}
+
+/**
+ * Creates new http server
+ */
+func New(name string, cfg config.Server) (*Server, error) {
+
+	log := logging.For("http/server")
+
+	var err error
+	statsHandler := stats.NewHandler(name)
+
+	stopping := make(chan bool)
+
+	server := &Server{
+		name:         name,
+		cfg:          cfg,
+		stopping:     stopping,
+		limits:       connlimit.NewLimiters(name, *cfg.MaxConnections, cfg.QueueSize, *cfg.MaxConnectionsPerClient, utils.ParseDurationOrDefault(cfg.QueueTimeout, 0), stopping),
+		statsHandler: statsHandler,
+		scheduler: scheduler.Scheduler{
+			Balancer:       balance.New(nil, cfg.Balance),
+			Discovery:      discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
+			Healthcheck:    healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
+			StatsHandler:   statsHandler,
+			SlowStart:      utils.ParseDurationOrDefault(cfg.SlowStart, 0),
+			CircuitBreaker: cfg.CircuitBreaker,
+			StickTable:     scheduler.NewStickTable(cfg.Sticky),
+			Failover:       cfg.Failover,
+			Geoip:          cfg.Geoip,
+			LabelFilter:    cfg.BackendLabels,
+		},
+	}
+
+	server.listener = newListener(server)
+	statsHandler.Clients = server.listener
+
+	/* Add host and path routes if needed */
+	server.routes = newRoutes(name, cfg)
+
+	/* Add access, allowing everything if not configured, so it could be updated in runtime */
+	server.access = access.NewAllowAll()
+	if cfg.Access != nil {
+		server.access, err = access.NewAccess(cfg.Access)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add rate limit if needed */
+	if cfg.RateLimit != nil {
+		server.rateLimit, err = ratelimit.NewRateLimit(cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add backend tls config if needed */
+	if cfg.BackendsTls != nil {
+		server.backendsTlsConfig, err = tlsutil.NewBackendsConfig(cfg.BackendsTls)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	proxyTransport, err := newTransport(server)
+	if err != nil {
+		return nil, err
+	}
+
+	server.proxy = &httputil.ReverseProxy{
+		Director:     server.direct,
+		Transport:    proxyTransport,
+		ErrorHandler: server.handleError,
+	}
+
+	/* Stream responses to clients as they come, i.e. grpc streams, if backends speak h2 */
+	if backendProtocol(cfg) != "http1" {
+		server.proxy.FlushInterval = -1
+	}
+
+	log.Info("Creating http server '", name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
+
+	return server, nil
+}
+
+/**
+ * Returns current server configuration
+ */
+func (this *Server) Cfg() config.Server {
+	cfg := this.cfg
+	cfg.Access = this.access.Config()
+	return cfg
+}
+
+/**
+ * Replace access rules without restart
+ */
+func (this *Server) UpdateAccess(cfg *config.AccessConfig) error {
+	return this.access.Update(cfg)
+}
+
+/**
+ * Returns healthcheck history of server backends
+ */
+func (this *Server) HealthcheckHistory() []healthcheck.TargetHistory {
+	return this.scheduler.HealthcheckHistory()
+}
+
+/**
+ * Returns health of server discovery
+ */
+func (this *Server) DiscoveryHealth() discovery.Health {
+	return this.scheduler.Discovery.Health()
+}
+
+/**
+ * Returns current connections counts of client ips
+ */
+func (this *Server) ClientConnections() []connlimit.ClientCount {
+	return this.limits.ClientCounts()
+}
+
+/**
+ * Returns client ip to backend stick table, nil if disabled
+ */
+func (this *Server) StickTable() *scheduler.StickTable {
+	return this.scheduler.StickTable
+}
+
+/**
+ * Returns schedulers of all server backends pools: main and routes ones
+ */
+func (this *Server) Schedulers() []*scheduler.Scheduler {
+
+	schedulers := []*scheduler.Scheduler{&this.scheduler}
+	for _, r := range this.routes {
+		schedulers = append(schedulers, r.scheduler)
+	}
+
+	return schedulers
+}
+
+/**
+ * Returns current connections and connections queued over max_connections
+ */
+func (this *Server) ConnectionsQueue() (int, int) {
+	return this.limits.Count()
+}
+
+/**
+ * Drains backend (drained = true) or enables it back in every
+ * backends pool of server having it: main and routes ones
+ */
+func (this *Server) SetBackendDrained(target core.Target, drained bool) error {
+
+	var result error
+	found := false
+
+	for _, s := range this.Schedulers() {
+		if err := s.SetDrained(target, drained); err != nil {
+			result = err
+			continue
+		}
+		found = true
+	}
+
+	if found {
+		return nil
+	}
+
+	return result
+}
+
+/**
+ * Reload tls certificate from files
+ */
+func (this *Server) ReloadTls() error {
+
+	if this.certificates == nil {
+		return errors.New("Server has no tls certificate")
+	}
+
+	return this.certificates.Reload()
+}
+
+/**
+ * Start server
+ */
+func (this *Server) Start() error {
+
+	this.statsHandler.Start()
+	this.scheduler.Start()
+
+	for _, r := range this.routes {
+		r.statsHandler.Start()
+		r.scheduler.Start()
+	}
+
+	if this.rateLimit != nil {
+		this.rateLimit.Share(this.name)
+	}
+
+	if err := this.listen(); err != nil {
+		this.Drain(0)
+		return err
+	}
+
+	return nil
+}
+
+/**
+ * Listen for client connections and serve them
+ */
+func (this *Server) listen() error {
+
+	log := logging.For("http/server")
+
+	var err error
+	var tlsConfig *tls.Config
+
+	if this.cfg.Tls != nil {
+
+		if this.certificates, err = tlsutil.NewCertificates(this.cfg.Tls); err != nil {
+			log.Error(err)
+			return err
+		}
+
+		if interval := utils.ParseDurationOrDefault(this.cfg.Tls.ReloadInterval, 0); interval > 0 {
+			this.certificates.Watch(interval, this.stopping)
+		}
+
+		if tlsConfig, err = tlsutil.NewServerConfig(this.cfg.Tls, this.certificates); err != nil {
+			log.Error(err)
+			return err
+		}
+
+		if len(tlsConfig.NextProtos) == 0 {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+	}
+
+	sockets := []net.Listener{}
+
+	for _, bind := range this.cfg.Bind {
+		var l net.Listener
+		if l, err = listenBind(bind, this.cfg.UnixSocket); err != nil {
+			break
+		}
+		sockets = append(sockets, newSocket(l, this.listener))
+	}
+
+	if err != nil {
+		log.Error("Error starting http server: ", err)
+		for _, l := range sockets {
+			l.Close()
+		}
+		return err
+	}
+
+	this.errorLog = log.WriterLevel(logrus.DebugLevel)
+
+	clientIdleTimeout := utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0)
+
+	this.httpServer = &http.Server{
+		Handler:           this,
+		ReadHeaderTimeout: clientIdleTimeout,
+		IdleTimeout:       clientIdleTimeout,
+		ErrorLog:          stdlog(this.errorLog),
+	}
+
+	// Serve h2 to clients negotiated it with alpn, every stream is proxied as separate request
+	if tlsConfig != nil && advertises(tlsConfig, http2.NextProtoTLS) {
+		if err := http2.ConfigureServer(this.httpServer, &http2.Server{}); err != nil {
+			log.Error(err)
+			for _, l := range sockets {
+				l.Close()
+			}
+			return err
+		}
+	}
+
+	for _, served := range sockets {
+		if tlsConfig != nil {
+			served = tls.NewListener(served, tlsConfig)
+		}
+
+		go func(served net.Listener) {
+			if err := this.httpServer.Serve(served); err != nil && err != http.ErrServerClosed {
+				log.Error(err)
+			}
+		}(served)
+	}
+
+	return nil
+}
+
+/**
+ * Listens on tcp or unix socket bind, taking listener passed by old process on upgrade, or by systemd
+ */
+func listenBind(bind string, unixSocket *config.UnixSocketConfig) (l net.Listener, err error) {
+
+	if unixsocket.IsSocketBind(bind) {
+		l, err = unixsocket.Listen(bind, unixSocket)
+	} else if inherited := upgrade.Listeners(bind); len(inherited) > 0 {
+		l = inherited[0]
+	} else if systemd.IsSocketBind(bind) {
+		var listeners []net.Listener
+		if listeners, err = systemd.Listeners(bind); err == nil {
+			l = listeners[0]
+		}
+	} else {
+		l, err = net.Listen("tcp", bind)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade.RegisterListener(bind, l)
+
+	return l, nil
+}
+
+/**
+ * Proxies request to backends pool of matching route, or server one
+ */
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	log := logging.For("http/server")
+
+	ctx := core.HttpContext{
+		Host: requestHost(r),
+	}
+
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx.RemoteAddr = *addr
+	} else {
+		// Client of unix socket
+		ctx.RemoteAddr.IP = core.UnixClientIp
+	}
+
+	/* Request span, child of client one if request has traceparent */
+	span := tracing.Global.Start("HTTP "+r.Method, tracing.KindServer, r.Header.Get("traceparent"))
+	span.SetAttribute("gobetween.server", this.name)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	span.SetAttribute("server.address", ctx.Host)
+	span.SetAddress("client", r.RemoteAddr)
+
+	accessClient := access.Client{Ip: &ctx.RemoteAddr.IP}
+
+	var identity string
+	if r.TLS != nil {
+		accessClient.Sni = r.TLS.ServerName
+		if this.cfg.Tls.ClientAuth != nil {
+			identity = tlsutil.ClientIdentity(*r.TLS)
+			accessClient.Identity = identity
+			accessClient.Certificate = tlsutil.ClientCertificate(*r.TLS)
+		}
+	}
+
+	if !this.access.AllowsConnection(accessClient) {
+		log.Debug("Client disallowed to make requests ", r.RemoteAddr, " ", identity)
+		this.statsHandler.Disconnected("access_denied")
+		span.SetAttribute("gobetween.reason", "access_denied")
+		span.SetAttribute("http.response.status_code", http.StatusForbidden)
+		span.End()
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	p := &proxied{
+		pool: &this.scheduler,
+		ctx:  ctx,
+		span: span,
+	}
+
+	if route := this.routeFor(ctx.Host, r.URL.Path); route != nil {
+		p.pool = route.scheduler
+		if route.stripPrefix {
+			r.URL.Path = route.rewrite(r.URL.Path)
+			r.URL.RawPath = ""
+		}
+	}
+
+	this.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxiedKey{}, p)))
+
+	if p.reason != "" {
+		span.SetAttribute("gobetween.reason", p.reason)
+		if p.reason != "client_closed" {
+			span.SetError(p.reason)
+		}
+	}
+	span.End()
+}
+
+/**
+ * Prepares request to backend, adding X-Forwarded-Proto and X-Forwarded-Host headers.
+ * X-Forwarded-For is appended with client ip by proxy itself, Host is kept
+ */
+func (this *Server) direct(r *http.Request) {
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	r.Header.Set("X-Forwarded-Proto", proto)
+	r.Header.Set("X-Forwarded-Host", r.Host)
+
+	// Go http client adds user agent otherwise
+	if _, ok := r.Header["User-Agent"]; !ok {
+		r.Header.Set("User-Agent", "")
+	}
+}
+
+/**
+ * Responds to client with error of failed request:
+ * 503 if there is no backend, 504 on timeout, 502 otherwise
+ */
+func (this *Server) handleError(w http.ResponseWriter, r *http.Request, err error) {
+
+	log := logging.For("http/server")
+
+	p := r.Context().Value(proxiedKey{}).(*proxied)
+	log.Debug("Request ", r.Method, " ", r.URL.Path, " of ", r.RemoteAddr, " failed: ", err)
+
+	status := http.StatusBadGateway
+
+	switch e, ok := err.(net.Error); {
+	case err == errNoBackend:
+		status = http.StatusServiceUnavailable
+	case ok && e.Timeout():
+		status = http.StatusGatewayTimeout
+		p.reason = "idle_timeout"
+	case err == context.Canceled:
+		// Client has gone, nobody to respond to
+		p.reason = "client_closed"
+	}
+
+	if p.reason == "" {
+		p.reason = "error"
+	}
+
+	this.statsHandler.Disconnected(p.reason)
+
+	w.WriteHeader(status)
+}
+
+/**
+ * Stop, dropping all connections
+ */
+func (this *Server) Stop() {
+	this.Drain(utils.ParseDurationOrDefault(*this.cfg.DrainTimeout, 0))
+}
+
+/**
+ * Stop accepting new connections and wait until active requests
+ * and upgraded connections are finished up to timeout, then drop the rest
+ */
+func (this *Server) Drain(timeout time.Duration) {
+	this.drainOnce.Do(func() {
+
+		log := logging.For("http/server")
+		log.Info("Stopping ", this.name)
+
+		// Stopping is closed without http server too, when it failed to listen, so certificates watch ends
+		close(this.stopping)
+
+		if this.httpServer != nil {
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			this.httpServer.Shutdown(ctx)
+
+			// Upgraded connections are not tracked by http server
+			for this.listener.Count() > 0 && ctx.Err() == nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			if this.listener.Count() > 0 {
+				log.Warn("Drain timeout, dropping ", this.listener.Count(), " connections of ", this.name)
+			}
+
+			this.httpServer.Close()
+			this.listener.closeAll()
+			this.errorLog.Close()
+		}
+
+		this.stop()
+	})
+}
+
+/**
+ * Stops schedulers, stats and modules
+ */
+func (this *Server) stop() {
+
+	this.scheduler.Stop()
+	this.statsHandler.Stop()
+	this.access.Stop()
+
+	if this.rateLimit != nil {
+		this.rateLimit.Unshare()
+	}
+
+	for _, r := range this.routes {
+		r.scheduler.Stop()
+		r.statsHandler.Stop()
+	}
+}
+
+/**
+ * Returns request host lowercased and without port
+ */
+func requestHost(r *http.Request) string {
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+/**
+ * Checks if tls config advertises alpn protocol to clients
+ */
+func advertises(tlsConfig *tls.Config, proto string) bool {
+
+	for _, p := range tlsConfig.NextProtos {
+		if p == proto {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Creates standard logger writing to w
+ */
+func stdlog(w io.Writer) *log.Logger {
+	return log.New(w, "", 0)
+}
@@ -0,0 +1,441 @@
+/**
+ * transport.go - proxying requests to elected backends
+ */
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../../config"
+	"../../core"
+	"../../logging"
+	"../../tracing"
+	"../../utils"
+	"../../utils/backendbind"
+	"../../utils/upstreamproxy"
+	"../scheduler"
+
+	"golang.org/x/net/http2"
+)
+
+/**
+ * Error of backends pool having no backend to proxy request to
+ */
+var errNoBackend = errors.New("No backend available")
+
+/**
+ * Suffix of backends urls hosts standing for unix sockets, i.e. "<hex encoded path>.unix.gobetween",
+ * so connections to every socket are kept apart and socket path is known when connection is dialed
+ */
+const unixHostSuffix = ".unix.gobetween"
+
+/**
+ * Suffix of backends urls hosts standing for backends resolved from host names in discovery, i.e.
+ * "<hex encoded name and ip>.resolved.gobetween", so ip is dialed and name is tls server name
+ */
+const resolvedHostSuffix = ".resolved.gobetween"
+
+/**
+ * Key of proxied request in request context
+ */
+type proxiedKey struct{}
+
+/**
+ * Request being proxied: backends pool and context to elect backend with
+ */
+type proxied struct {
+	pool *scheduler.Scheduler
+	ctx  core.HttpContext
+
+	/* Request span, nil if not traced */
+	span *tracing.Span
+
+	/* Disconnect reason of failed request */
+	reason string
+}
+
+/**
+ * Round tripper electing backend from request pool for every request
+ * and connecting to it, retrying next backends if connection fails
+ */
+type transport struct {
+
+	/* Transport keeping backends connections, multiplexing requests over them with h2c and h2 */
+	http http.RoundTripper
+
+	/* Scheme of backends urls, "https" if backends tls is enabled */
+	scheme string
+
+	/* Next backends to try if connection to elected one fails */
+	maxDialRetries int
+}
+
+/**
+ * Creates transport for server of backend protocol:
+ * "http1" (https with backends tls), "h2c" (h2 without tls) or "h2"
+ */
+func newTransport(server *Server) (*transport, error) {
+
+	cfg := server.cfg
+
+	dialer, err := backendbind.New(cfg.BackendBind, cfg.HappyEyeballs, utils.ParseDurationOrDefault(*cfg.BackendConnectionTimeout, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	tcpDial := dialer.DialContext
+	if cfg.UpstreamProxy != nil {
+		tcpDial = upstreamproxy.New(cfg.UpstreamProxy, dialer).DialContext
+	}
+
+	// Unix socket backends are local, so they're connected directly
+	dial := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if path, ok := unixPath(addr); ok {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		if _, address, ok := resolvedAddress(addr); ok {
+			return tcpDial(ctx, network, address)
+		}
+		return tcpDial(ctx, network, addr)
+	}
+
+	// Tls server name of backend resolved from host name is the name, unless it's configured
+	serverName := func(tlsConfig *tls.Config, addr string) *tls.Config {
+		name, _, ok := resolvedAddress(addr)
+		if !ok || server.backendsTlsConfig.ServerName != "" {
+			return tlsConfig
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = name
+		return tlsConfig
+	}
+
+	result := &transport{
+		scheme:         "http",
+		maxDialRetries: *cfg.MaxDialRetries,
+	}
+
+	if server.backendsTlsConfig != nil {
+		result.scheme = "https"
+	}
+
+	switch backendProtocol(cfg) {
+	case "h2c":
+		result.http = &http2.Transport{
+			AllowHTTP:       true,
+			IdleConnTimeout: 90 * time.Second,
+			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	case "h2":
+		tlsConfig := server.backendsTlsConfig.Clone()
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+		result.http = &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			IdleConnTimeout: 90 * time.Second,
+			DialTLSContext: func(ctx context.Context, network string, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialTls(ctx, dial, dialer.Timeout, network, addr, serverName(cfg, addr))
+			},
+		}
+	default:
+		transport := &http.Transport{
+			DialContext:           dial,
+			TLSClientConfig:       server.backendsTlsConfig,
+			ResponseHeaderTimeout: utils.ParseDurationOrDefault(*cfg.BackendIdleTimeout, 0),
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		}
+
+		// Tls session is established by transport itself, with server name of url host
+		if server.backendsTlsConfig != nil {
+			transport.DialTLSContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				tlsConfig := server.backendsTlsConfig
+				if host, _, err := net.SplitHostPort(addr); err == nil && tlsConfig.ServerName == "" {
+					tlsConfig = tlsConfig.Clone()
+					tlsConfig.ServerName = host
+				}
+				return dialTls(ctx, dial, dialer.Timeout, network, addr, serverName(tlsConfig, addr))
+			}
+		}
+
+		result.http = transport
+	}
+
+	return result, nil
+}
+
+/**
+ * Connects to backend with dial and establishes tls session on connection,
+ * handshake is limited by timeout if it's set
+ */
+func dialTls(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), timeout time.Duration, network string, addr string, cfg *tls.Config) (net.Conn, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+/**
+ * Returns protocol of requests to backends, "http1" if not set
+ */
+func backendProtocol(cfg config.Server) string {
+
+	if cfg.Http == nil || cfg.Http.BackendProtocol == "" {
+		return "http1"
+	}
+
+	return cfg.Http.BackendProtocol
+}
+
+/**
+ * Proxies request to elected backend
+ */
+func (this *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	log := logging.For("http/transport")
+
+	p := req.Context().Value(proxiedKey{}).(*proxied)
+	pool := p.pool
+
+	// Request body is read only once connection to backend is established, and it's
+	// not closed on failed attempts, so it's sent to next backend. Server closes it anyway
+	var body *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &countingBody{ReadCloser: ioutil.NopCloser(req.Body)}
+		req.Body = body
+	}
+
+	var tried []core.Target
+
+	for {
+		backend, err := pool.TakeBackendExcluding(p.ctx, tried)
+		if err != nil {
+			p.reason = "no_backend"
+			if len(tried) > 0 {
+				p.reason = "dial_failed"
+			}
+			return nil, errNoBackend
+		}
+
+		// Every attempt gets its own headers, so ones set for backend don't change client request
+		outreq := req.Clone(req.Context())
+		url := *req.URL
+		url.Scheme = this.scheme
+		url.Host = urlHost(backend)
+		outreq.URL = &url
+
+		/* Backend request span, passed to backend so its spans are children of it */
+		span := p.span.Child("backend request", tracing.KindClient)
+		if span != nil {
+			span.SetAddress("server", backend.Address())
+			span.SetAttribute("http.request.method", req.Method)
+			outreq.Header.Set("traceparent", span.Traceparent())
+		}
+
+		pool.IncrementConnection(*backend)
+
+		res, err := this.http.RoundTrip(outreq)
+		if err == nil {
+			pool.ReportPassive(*backend, true)
+
+			p.span.SetAttribute("gobetween.backend", backend.Address())
+			p.span.SetAttribute("http.response.status_code", res.StatusCode)
+			span.SetAttribute("http.response.status_code", res.StatusCode)
+			if res.StatusCode >= 500 {
+				span.SetError(res.Status)
+			}
+
+			res.Body = &countingBody{
+				ReadCloser: res.Body,
+				onClose: func(rx uint64, tx uint64) {
+					if body != nil {
+						tx += body.count()
+					}
+					pool.IncrementRx(*backend, uint(rx))
+					pool.IncrementTx(*backend, uint(tx))
+					pool.DecrementConnection(*backend)
+
+					span.SetAttribute("gobetween.rx_bytes", rx)
+					span.SetAttribute("gobetween.tx_bytes", tx)
+					span.End()
+				},
+			}
+			return res, nil
+		}
+
+		span.SetError(err.Error())
+		span.End()
+
+		pool.DecrementConnection(*backend)
+
+		if !isDialError(err) {
+			p.reason = "error"
+			pool.ReportPassive(*backend, false)
+			return nil, err
+		}
+
+		pool.IncrementRefused(*backend)
+		pool.ReportPassive(*backend, false)
+		log.Error(err)
+
+		tried = append(tried, backend.Target)
+		if len(tried) > this.maxDialRetries {
+			p.reason = "dial_failed"
+			return nil, err
+		}
+
+		pool.IncrementDialRetries()
+		log.Debug("Retrying next backend for ", p.ctx.String())
+	}
+}
+
+/**
+ * Returns host of backend url, address of tcp backend, encoded path of unix socket one
+ * or encoded host name and ip of one resolved in discovery
+ */
+func urlHost(backend *core.Backend) string {
+
+	if backend.IsUnix() {
+		return hex.EncodeToString([]byte(backend.DialAddress())) + unixHostSuffix
+	}
+
+	if backend.Hostname != "" {
+		host := hex.EncodeToString([]byte(backend.Hostname+" "+backend.Host)) + resolvedHostSuffix
+		return net.JoinHostPort(host, backend.Port)
+	}
+
+	return backend.Address()
+}
+
+/**
+ * Returns host name and address to dial of dialed address if its host is encoded resolved one
+ */
+func resolvedAddress(addr string) (string, string, bool) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasSuffix(host, resolvedHostSuffix) {
+		return "", "", false
+	}
+
+	decoded, err := hex.DecodeString(strings.TrimSuffix(host, resolvedHostSuffix))
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(decoded), " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], net.JoinHostPort(parts[1], port), true
+}
+
+/**
+ * Returns unix socket path of dialed address if its host is encoded one
+ */
+func unixPath(addr string) (string, bool) {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+
+	path, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix))
+	if err != nil {
+		return "", false
+	}
+
+	return string(path), true
+}
+
+/**
+ * Checks if err is failure to connect backend, so request is not sent yet
+ */
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !errors.Is(err, context.Canceled)
+}
+
+/**
+ * Body counting bytes read from it, and written to it for upgraded connections.
+ * onClose, if set, is called once with counts when body is closed
+ */
+type countingBody struct {
+	io.ReadCloser
+
+	/* Read and written bytes, updated atomically */
+	read, written uint64
+
+	onClose   func(read uint64, written uint64)
+	closeOnce sync.Once
+}
+
+func (this *countingBody) Read(b []byte) (int, error) {
+	n, err := this.ReadCloser.Read(b)
+	atomic.AddUint64(&this.read, uint64(n))
+	return n, err
+}
+
+/**
+ * Writes to upgraded connection body, so proxy can use it as io.ReadWriteCloser
+ */
+func (this *countingBody) Write(b []byte) (int, error) {
+
+	w, ok := this.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, errors.New("Body is not writable")
+	}
+
+	n, err := w.Write(b)
+	atomic.AddUint64(&this.written, uint64(n))
+	return n, err
+}
+
+func (this *countingBody) Close() error {
+
+	err := this.ReadCloser.Close()
+
+	if this.onClose != nil {
+		this.closeOnce.Do(func() {
+			this.onClose(atomic.LoadUint64(&this.read), atomic.LoadUint64(&this.written))
+		})
+	}
+
+	return err
+}
+
+/**
+ * Returns bytes read from body
+ */
+func (this *countingBody) count() uint64 {
+	return atomic.LoadUint64(&this.read)
+}
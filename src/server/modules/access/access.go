@@ -10,14 +10,23 @@ import (
 	"../../../config"
 	"errors"
 	"net"
+	"sync"
 )
 
 /**
  * Access defines access rules chain
  */
 type Access struct {
+	sync.RWMutex
+
 	AllowDefault bool
 	Rules        []AccessRule
+
+	/* Configuration rules are parsed from, nil if access is not configured */
+	cfg *config.AccessConfig
+
+	/* Watcher of rules file, if any */
+	watcher *watcher
 }
 
 /**
@@ -29,41 +38,186 @@ func NewAccess(cfg *config.AccessConfig) (*Access, error) {
 		return nil, errors.New("AccessConfig is nil")
 	}
 
-	if cfg.Default == "" {
-		cfg.Default = "allow"
+	access := &Access{}
+	if err := access.Update(cfg); err != nil {
+		return nil, err
 	}
 
-	if cfg.Default != "allow" && cfg.Default != "deny" {
-		return nil, errors.New("AccessConfig Unexpected Default: " + cfg.Default)
-	}
+	return access, nil
+}
 
-	access := Access{
-		AllowDefault: cfg.Default == "allow",
+/**
+ * Creates new Access allowing everything,
+ * until it's updated with rules
+ */
+func NewAllowAll() *Access {
+	return &Access{
+		AllowDefault: true,
 		Rules:        []AccessRule{},
 	}
+}
 
-	// Parse rules
-	for _, r := range cfg.Rules {
-		rule, err := ParseAccessRule(r)
+/**
+ * Replaces rules with ones parsed from config, keeping
+ * current rules if config is not valid
+ */
+func (this *Access) Update(cfg *config.AccessConfig) error {
+
+	c := *cfg
+	if c.Default == "" {
+		c.Default = "allow"
+	}
+
+	if c.Default != "allow" && c.Default != "deny" {
+		return errors.New("AccessConfig Unexpected Default: " + c.Default)
+	}
+
+	rules, err := parseRules(c.Rules)
+	if err != nil {
+		return err
+	}
+
+	if c.RulesFile != "" {
+		fileRules, err := readRulesFile(c.RulesFile)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		access.Rules = append(access.Rules, *rule)
+		rules = append(rules, fileRules...)
+	}
+
+	this.Lock()
+	rulesFileChanged := this.cfg == nil || this.cfg.RulesFile != c.RulesFile
+
+	this.AllowDefault = c.Default == "allow"
+	this.Rules = rules
+	this.cfg = &c
+
+	var previous *watcher
+	if rulesFileChanged {
+		previous = this.watcher
+		this.watcher = nil
+	}
+	this.Unlock()
+
+	// Watchers are stopped and started without lock held,
+	// since watcher may be reloading rules at the moment
+	if previous != nil {
+		previous.stop()
+	}
+
+	if !rulesFileChanged || c.RulesFile == "" {
+		return nil
+	}
+
+	w, err := watchRulesFile(c.RulesFile, this.reloadRulesFile)
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	previous = this.watcher
+	this.watcher = w
+	this.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+
+	return nil
+}
+
+/**
+ * Returns configuration current rules are parsed from,
+ * or nil if access was not configured
+ */
+func (this *Access) Config() *config.AccessConfig {
+
+	this.RLock()
+	defer this.RUnlock()
+
+	if this.cfg == nil {
+		return nil
 	}
 
-	return &access, nil
+	c := *this.cfg
+	return &c
+}
+
+/**
+ * Stops watching rules file
+ */
+func (this *Access) Stop() {
+
+	this.Lock()
+	w := this.watcher
+	this.watcher = nil
+	this.Unlock()
+
+	if w != nil {
+		w.stop()
+	}
 }
 
 /**
  * Checks if ip is allowed
  */
 func (this *Access) Allows(ip *net.IP) bool {
+	return this.AllowsClient(ip, "")
+}
+
+/**
+ * Checks if client with ip and verified identity
+ * (empty if not verified) is allowed
+ */
+func (this *Access) AllowsClient(ip *net.IP, identity string) bool {
+	return this.AllowsConnection(Client{Ip: ip, Identity: identity})
+}
+
+/**
+ * Checks if client connection is allowed by the first rule matching
+ * its ip, geoip location, sni hostname or verified certificate, or by default
+ */
+func (this *Access) AllowsConnection(client Client) bool {
+
+	this.RLock()
+	defer this.RUnlock()
 
 	for _, r := range this.Rules {
-		if r.Matches(ip) {
+		if r.MatchesConnection(client) {
 			return r.Allows()
 		}
 	}
 
 	return this.AllowDefault
 }
+
+/**
+ * Parses rules from the current config again, called on rules file change
+ */
+func (this *Access) reloadRulesFile() error {
+
+	cfg := this.Config()
+	if cfg == nil {
+		return nil
+	}
+
+	return this.Update(cfg)
+}
+
+/**
+ * Parses list of rules
+ */
+func parseRules(list []string) ([]AccessRule, error) {
+
+	rules := []AccessRule{}
+
+	for _, r := range list {
+		rule, err := ParseAccessRule(r)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,112 @@
+/**
+ * file.go - access rules file and its watching
+ */
+
+package access
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"../../../logging"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/**
+ * Watches rules file for changes
+ */
+type watcher struct {
+	fsWatcher *fsnotify.Watcher
+}
+
+/**
+ * Reads rules file, one rule per line.
+ * Empty lines and lines starting with # are ignored
+ */
+func readRulesFile(path string) ([]AccessRule, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var list []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return parseRules(list)
+}
+
+/**
+ * Starts watching rules file, calling onChange when it's written or replaced.
+ * File directory is watched, since editors and tools often replace files
+ */
+func watchRulesFile(path string, onChange func() error) (*watcher, error) {
+
+	log := logging.For("access/file")
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	path = filepath.Clean(path)
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(event.Name) != path || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+
+				if err := onChange(); err != nil {
+					log.Error("Failed to reload access rules from ", path, ", keeping current: ", err)
+					continue
+				}
+
+				log.Info("Reloaded access rules from ", path)
+
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn("Error watching ", path, ": ", err)
+			}
+		}
+	}()
+
+	return &watcher{fsWatcher}, nil
+}
+
+/**
+ * Stops watching
+ */
+func (this *watcher) stop() {
+	this.fsWatcher.Close()
+}
@@ -7,20 +7,61 @@
 package access
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"net"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"../../../utils/geoip"
 )
 
 /**
  * AccessRule defines order (access, deny)
- * and IP or Network
+ * and IP or Network, sni hostname or client certificate
  */
 type AccessRule struct {
 	Allow     bool
 	IsNetwork bool
 	Ip        *net.IP
 	Network   *net.IPNet
+
+	/* Pattern of verified client certificate common name, "cn=<regexp>" */
+	Identity *regexp.Regexp
+
+	/* Pattern of hostname client requested with sni, "sni=<regexp>" */
+	Sni *regexp.Regexp
+
+	/* Pattern of any of verified client certificate subject alternative names, "san=<regexp>" */
+	San *regexp.Regexp
+
+	/* Lowercase hex SHA-256 fingerprint of verified client certificate, "fingerprint=<hex>" */
+	Fingerprint string
+
+	/* Upper case geoip country codes of client ip, "country=<code>[,<code>...]" */
+	Countries []string
+
+	/* Geoip autonomous system numbers of client ip, "asn=<number>[,<number>...]" */
+	Asns []uint
+}
+
+/**
+ * Client connection checked by access rules
+ */
+type Client struct {
+	Ip *net.IP
+
+	/* Hostname client requested with sni, empty if none */
+	Sni string
+
+	/* Verified client certificate common name, empty if client is not verified */
+	Identity string
+
+	/* Verified client certificate, nil if client is not verified */
+	Certificate *x509.Certificate
 }
 
 /**
@@ -28,7 +69,7 @@ type AccessRule struct {
  */
 func ParseAccessRule(rule string) (*AccessRule, error) {
 
-	parts := strings.Split(rule, " ")
+	parts := strings.SplitN(rule, " ", 2)
 	if len(parts) != 2 {
 		return nil, errors.New("Bad access rule format: " + rule)
 	}
@@ -40,6 +81,81 @@ func ParseAccessRule(rule string) (*AccessRule, error) {
 		return nil, errors.New("Cant parse rule definition " + rule)
 	}
 
+	// try check if it's client identity, sni or certificate pattern and handle
+
+	if strings.HasPrefix(cidrOrIp, "cn=") {
+		identity, err := regexp.Compile(strings.TrimPrefix(cidrOrIp, "cn="))
+		if err != nil {
+			return nil, errors.New("Cant parse access rule identity pattern: " + err.Error())
+		}
+		return &AccessRule{
+			Allow:    r == "allow",
+			Identity: identity,
+		}, nil
+	}
+
+	if strings.HasPrefix(cidrOrIp, "sni=") {
+		sni, err := regexp.Compile(strings.TrimPrefix(cidrOrIp, "sni="))
+		if err != nil {
+			return nil, errors.New("Cant parse access rule sni pattern: " + err.Error())
+		}
+		return &AccessRule{
+			Allow: r == "allow",
+			Sni:   sni,
+		}, nil
+	}
+
+	if strings.HasPrefix(cidrOrIp, "san=") {
+		san, err := regexp.Compile(strings.TrimPrefix(cidrOrIp, "san="))
+		if err != nil {
+			return nil, errors.New("Cant parse access rule san pattern: " + err.Error())
+		}
+		return &AccessRule{
+			Allow: r == "allow",
+			San:   san,
+		}, nil
+	}
+
+	if strings.HasPrefix(cidrOrIp, "fingerprint=") {
+		sum := strings.ToLower(strings.Replace(strings.TrimPrefix(cidrOrIp, "fingerprint="), ":", "", -1))
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return nil, errors.New("Cant parse access rule fingerprint, not a hex SHA-256: " + cidrOrIp)
+		}
+		return &AccessRule{
+			Allow:       r == "allow",
+			Fingerprint: sum,
+		}, nil
+	}
+
+	if strings.HasPrefix(cidrOrIp, "country=") {
+		var countries []string
+		for _, c := range strings.Split(strings.TrimPrefix(cidrOrIp, "country="), ",") {
+			if len(c) != 2 {
+				return nil, errors.New("Cant parse access rule country, not a 2 letter code: " + c)
+			}
+			countries = append(countries, strings.ToUpper(c))
+		}
+		return &AccessRule{
+			Allow:     r == "allow",
+			Countries: countries,
+		}, nil
+	}
+
+	if strings.HasPrefix(cidrOrIp, "asn=") {
+		var asns []uint
+		for _, a := range strings.Split(strings.TrimPrefix(cidrOrIp, "asn="), ",") {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(a), "AS"), 10, 32)
+			if err != nil {
+				return nil, errors.New("Cant parse access rule asn: " + a)
+			}
+			asns = append(asns, uint(asn))
+		}
+		return &AccessRule{
+			Allow: r == "allow",
+			Asns:  asns,
+		}, nil
+	}
+
 	// try check if cidrOrIp is ip and handle
 
 	ipShould := net.ParseIP(cidrOrIp)
@@ -66,10 +182,49 @@ func ParseAccessRule(rule string) (*AccessRule, error) {
 
 }
 
+/**
+ * Checks if client with ip and verified identity matches access rule
+ */
+func (this *AccessRule) MatchesClient(ip *net.IP, identity string) bool {
+	return this.MatchesConnection(Client{Ip: ip, Identity: identity})
+}
+
+/**
+ * Checks if client connection matches access rule. Sni and certificate rules do not
+ * match clients without sni or verified certificate, country and asn rules do not
+ * match clients which location is unknown
+ */
+func (this *AccessRule) MatchesConnection(client Client) bool {
+
+	switch {
+	case this.Identity != nil:
+		return client.Identity != "" && this.Identity.MatchString(client.Identity)
+	case this.Sni != nil:
+		return client.Sni != "" && this.Sni.MatchString(client.Sni)
+	case this.San != nil:
+		return client.Certificate != nil && matchesAny(this.San, subjectAltNames(client.Certificate))
+	case this.Fingerprint != "":
+		return client.Certificate != nil && this.Fingerprint == fingerprint(client.Certificate)
+	case this.Countries != nil:
+		return containsString(this.Countries, geoip.Global.Lookup(*client.Ip).Country)
+	case this.Asns != nil:
+		return containsUint(this.Asns, geoip.Global.Lookup(*client.Ip).Asn)
+	}
+
+	return this.matchesIp(client.Ip)
+}
+
 /**
  * Checks if ip matches access rule
  */
 func (this *AccessRule) Matches(ip *net.IP) bool {
+	return this.MatchesConnection(Client{Ip: ip})
+}
+
+/**
+ * Checks if ip matches ip or network of rule
+ */
+func (this *AccessRule) matchesIp(ip *net.IP) bool {
 
 	switch this.IsNetwork {
 	case true:
@@ -87,3 +242,72 @@ func (this *AccessRule) Matches(ip *net.IP) bool {
 func (this *AccessRule) Allows() bool {
 	return this.Allow
 }
+
+/**
+ * Returns dns names, emails, ips and uris of certificate
+ */
+func subjectAltNames(cert *x509.Certificate) []string {
+
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	return names
+}
+
+/**
+ * Checks if pattern matches any of values
+ */
+func matchesAny(pattern *regexp.Regexp, values []string) bool {
+
+	for _, v := range values {
+		if pattern.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Checks if value is not empty and is one of values
+ */
+func containsString(values []string, value string) bool {
+
+	for _, v := range values {
+		if value != "" && v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Checks if value is not zero and is one of values
+ */
+func containsUint(values []uint, value uint) bool {
+
+	for _, v := range values {
+		if value != 0 && v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Returns lowercase hex SHA-256 fingerprint of certificate
+ */
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,159 @@
+/**
+ * accesslog.go - per proxied connection access log
+ */
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"../../../config"
+	"../../../logging"
+)
+
+/**
+ * Default template for text format
+ */
+const DEFAULT_TEMPLATE = `{{.Time.Format "2006-01-02T15:04:05Z07:00"}} {{.Server}} {{.Client}} {{or .Sni "-"}} {{or .Backend "-"}} {{.Rx}} {{.Tx}} {{.Duration}} {{.Reason}}`
+
+/**
+ * Access log record of a proxied connection
+ */
+type Record struct {
+
+	/* Time connection was accepted */
+	Time time.Time `json:"time"`
+
+	/* Server name */
+	Server string `json:"server"`
+
+	/* Client address */
+	Client string `json:"client"`
+
+	/* Sni hostname requested by client, if any */
+	Sni string `json:"sni,omitempty"`
+
+	/* Alpn protocol negotiated with client, if any */
+	Alpn string `json:"alpn,omitempty"`
+
+	/* Backend address connection was proxied to, if any */
+	Backend string `json:"backend,omitempty"`
+
+	/* Discovered labels of backend */
+	BackendLabels map[string]string `json:"backend_labels,omitempty"`
+
+	/* Received bytes from backend */
+	Rx uint64 `json:"rx"`
+
+	/* Transmitted bytes to backend */
+	Tx uint64 `json:"tx"`
+
+	/* Connection duration */
+	Duration time.Duration `json:"-"`
+
+	/* Connection duration, seconds */
+	DurationSeconds float64 `json:"duration"`
+
+	/* Reason connection was ended */
+	Reason string `json:"reason"`
+
+	/* Tags script set for connection */
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+/**
+ * Access log writes records to output
+ */
+type AccessLog struct {
+	sync.Mutex
+
+	/* Template for text format, nil for json */
+	template *template.Template
+
+	/* Output records are written to */
+	output io.WriteCloser
+}
+
+/**
+ * Creates new access log based on config
+ */
+func NewAccessLog(cfg *config.AccessLogConfig) (*AccessLog, error) {
+
+	if cfg == nil {
+		return nil, errors.New("AccessLogConfig is nil")
+	}
+
+	accessLog := &AccessLog{}
+
+	switch cfg.Format {
+	case "", "json":
+	case "text":
+		text := cfg.Template
+		if text == "" {
+			text = DEFAULT_TEMPLATE
+		}
+
+		t, err := template.New("access_log").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		accessLog.template = t
+	default:
+		return nil, errors.New("AccessLogConfig Unexpected Format: " + cfg.Format)
+	}
+
+	output, err := logging.OpenOutput(cfg.Output, logging.OutputOptions{Tag: "gobetween-access"})
+	if err != nil {
+		return nil, err
+	}
+	accessLog.output = output
+
+	return accessLog, nil
+}
+
+/**
+ * Writes record to output
+ */
+func (this *AccessLog) Log(record Record) {
+
+	record.DurationSeconds = record.Duration.Seconds()
+
+	b := &bytes.Buffer{}
+
+	if this.template == nil {
+		if err := json.NewEncoder(b).Encode(record); err != nil {
+			logging.For("accesslog").Error("Can't encode access log record: ", err)
+			return
+		}
+	} else {
+		if err := this.template.Execute(b, record); err != nil {
+			logging.For("accesslog").Error("Can't execute access log template: ", err)
+			return
+		}
+		b.WriteString("\n")
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if _, err := this.output.Write(b.Bytes()); err != nil {
+		logging.For("accesslog").Error("Can't write access log record: ", err)
+	}
+}
+
+/**
+ * Closes output
+ */
+func (this *AccessLog) Close() error {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return this.output.Close()
+}
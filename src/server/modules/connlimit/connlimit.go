@@ -0,0 +1,147 @@
+/**
+ * connlimit.go - connections limit with waiting queue
+ */
+
+package connlimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+/**
+ * Process-wide connections limit shared by all servers, unlimited by default
+ */
+var Global = New(0, 0)
+
+/**
+ * Limits count of concurrent connections. Connection over the limit
+ * may wait in queue for a released slot, first queued is served first
+ */
+type Limiter struct {
+	sync.Mutex
+
+	/* Max connections, 0 means unlimited */
+	max int
+
+	/* Max queued connections, 0 means unlimited */
+	queueSize int
+
+	/* Current connections */
+	count int
+
+	/* Channels of queued connections, slot is handed by sending to it */
+	waiters *list.List
+}
+
+/**
+ * Creates new limiter
+ */
+func New(max int, queueSize int) *Limiter {
+	return &Limiter{
+		max:       max,
+		queueSize: queueSize,
+		waiters:   list.New(),
+	}
+}
+
+/**
+ * Changes max connections, handing new slots to queued connections.
+ * If it's decreased, current connections are kept
+ */
+func (this *Limiter) SetMax(max int) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.max = max
+
+	for this.waiters.Len() > 0 && (this.max <= 0 || this.count < this.max) {
+		this.count++
+		this.waiters.Remove(this.waiters.Front()).(chan bool) <- true
+	}
+}
+
+/**
+ * Takes connection slot, waiting for it in queue up to timeout
+ * until cancel is closed. Returns false if slot was not taken
+ */
+func (this *Limiter) Acquire(timeout time.Duration, cancel <-chan bool) bool {
+
+	this.Lock()
+
+	if this.max <= 0 || this.count < this.max {
+		this.count++
+		this.Unlock()
+		return true
+	}
+
+	if timeout <= 0 || (this.queueSize > 0 && this.waiters.Len() >= this.queueSize) {
+		this.Unlock()
+		return false
+	}
+
+	granted := make(chan bool, 1)
+	el := this.waiters.PushBack(granted)
+
+	this.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-granted:
+		return true
+	case <-timer.C:
+	case <-cancel:
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	// Slot may be handed meanwhile
+	select {
+	case <-granted:
+		this.release()
+	default:
+		this.waiters.Remove(el)
+	}
+
+	return false
+}
+
+/**
+ * Releases connection slot, handing it to first queued connection if any
+ */
+func (this *Limiter) Release() {
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.release()
+}
+
+/**
+ * Returns current connections and queued connections counts
+ */
+func (this *Limiter) Count() (int, int) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return this.count, this.waiters.Len()
+}
+
+/**
+ * Releases slot, should be called with lock held
+ */
+func (this *Limiter) release() {
+
+	if this.waiters.Len() > 0 && (this.max <= 0 || this.count <= this.max) {
+		this.waiters.Remove(this.waiters.Front()).(chan bool) <- true
+		return
+	}
+
+	this.count--
+}
@@ -0,0 +1,139 @@
+/**
+ * limiters.go - connection slots of client ip, server and process-wide limits taken together
+ */
+
+package connlimit
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"../../../logging"
+)
+
+/**
+ * Errors of connection slots not taken
+ */
+var (
+	ErrClientLimit = errors.New("Too many connections from client")
+	ErrServerLimit = errors.New("Too many connections to server")
+	ErrGlobalLimit = errors.New("Too many connections in total")
+	ErrStopping    = errors.New("Server is stopping")
+)
+
+/**
+ * Connections limits of server: per client ip one rejecting connections at once,
+ * and server and process-wide ones queueing them up to queue timeout
+ */
+type Limiters struct {
+
+	/* Server name, for log messages */
+	name string
+
+	/* Connections limit of every client ip */
+	perClient *PerClient
+
+	/* Server connections limit */
+	server *Limiter
+
+	/* Max time connection waits in queues */
+	queueTimeout time.Duration
+
+	/* Closed when server starts stopping, so queued connections give up */
+	stopping <-chan bool
+}
+
+/**
+ * Creates limiters of server
+ */
+func NewLimiters(name string, max int, queueSize int, maxPerClient int, queueTimeout time.Duration, stopping <-chan bool) *Limiters {
+	return &Limiters{
+		name:         name,
+		perClient:    NewPerClient(maxPerClient),
+		server:       New(max, queueSize),
+		queueTimeout: queueTimeout,
+		stopping:     stopping,
+	}
+}
+
+/**
+ * Takes client ip, server and process-wide connection slots, waiting for server and
+ * process-wide ones in queue up to queue timeout. Returns error if slots were not taken,
+ * ErrStopping if server started stopping meanwhile
+ */
+func (this *Limiters) Acquire(ip net.IP) error {
+
+	log := logging.For("connlimit")
+
+	if !this.perClient.Acquire(ip) {
+		log.Debug("Too many connections from ", ip, " to ", this.name)
+		return ErrClientLimit
+	}
+
+	deadline := time.Now().Add(this.queueTimeout)
+
+	if !this.server.Acquire(time.Until(deadline), this.stopping) {
+		this.perClient.Release(ip)
+		return this.rejected(ErrServerLimit)
+	}
+
+	if !Global.Acquire(time.Until(deadline), this.stopping) {
+		this.server.Release()
+		this.perClient.Release(ip)
+		return this.rejected(ErrGlobalLimit)
+	}
+
+	return nil
+}
+
+/**
+ * Releases connection slots taken by Acquire
+ */
+func (this *Limiters) Release(ip net.IP) {
+	Global.Release()
+	this.server.Release()
+	this.perClient.Release(ip)
+}
+
+/**
+ * Returns current and queued server connections counts
+ */
+func (this *Limiters) Count() (int, int) {
+	return this.server.Count()
+}
+
+/**
+ * Returns current connections counts of clients, the most connected first
+ */
+func (this *Limiters) ClientCounts() []ClientCount {
+	return this.perClient.Counts()
+}
+
+/**
+ * Returns error of queued connection not taken slot, logging it unless server is stopping
+ */
+func (this *Limiters) rejected(err error) error {
+
+	select {
+	case <-this.stopping:
+		return ErrStopping
+	default:
+	}
+
+	logging.For("connlimit").Warn(err, ", rejecting connection to ", this.name)
+
+	return err
+}
+
+/**
+ * Returns disconnect reason of error returned by Acquire
+ */
+func Reason(err error) string {
+
+	if err == ErrClientLimit {
+		return "max_connections_per_client"
+	}
+
+	return "max_connections"
+}
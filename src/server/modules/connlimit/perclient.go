@@ -0,0 +1,102 @@
+/**
+ * perclient.go - concurrent connections limit of every client ip
+ */
+
+package connlimit
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+/**
+ * Current connections count of client ip
+ */
+type ClientCount struct {
+	Client      string `json:"client"`
+	Connections int    `json:"connections"`
+}
+
+/**
+ * Counts current connections of every client ip and limits them.
+ * Connection over the limit is rejected at once, not queued,
+ * so one client can't take all server slots or queue
+ */
+type PerClient struct {
+	sync.Mutex
+
+	/* Max connections of client ip, 0 means unlimited */
+	max int
+
+	/* Current connections by client ip */
+	counts map[string]int
+}
+
+/**
+ * Creates new per client limiter
+ */
+func NewPerClient(max int) *PerClient {
+	return &PerClient{
+		max:    max,
+		counts: make(map[string]int),
+	}
+}
+
+/**
+ * Takes connection slot of client ip. Returns false if client has max connections already
+ */
+func (this *PerClient) Acquire(ip net.IP) bool {
+
+	this.Lock()
+	defer this.Unlock()
+
+	client := ip.String()
+
+	if this.max > 0 && this.counts[client] >= this.max {
+		return false
+	}
+
+	this.counts[client]++
+	return true
+}
+
+/**
+ * Releases connection slot of client ip taken by Acquire
+ */
+func (this *PerClient) Release(ip net.IP) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	client := ip.String()
+
+	if this.counts[client] <= 1 {
+		delete(this.counts, client)
+		return
+	}
+
+	this.counts[client]--
+}
+
+/**
+ * Returns current connections counts of clients, the most connected first
+ */
+func (this *PerClient) Counts() []ClientCount {
+
+	this.Lock()
+	counts := make([]ClientCount, 0, len(this.counts))
+	for client, n := range this.counts {
+		counts = append(counts, ClientCount{client, n})
+	}
+	this.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Connections != counts[j].Connections {
+			return counts[i].Connections > counts[j].Connections
+		}
+		return counts[i].Client < counts[j].Client
+	})
+
+	return counts
+}
@@ -0,0 +1,223 @@
+/**
+ * ratelimit.go - per client ip new connections rate limiting
+ */
+
+package ratelimit
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"../../../cluster"
+	"../../../config"
+	"../../../utils"
+)
+
+/* Interval to forget clients that are idle and not banned */
+const cleanupInterval = time.Minute
+
+/**
+ * Token bucket of a client ip
+ */
+type bucket struct {
+
+	/* Available new connections */
+	tokens float64
+
+	/* Last time tokens were refilled */
+	last time.Time
+
+	/* Client is banned until this time */
+	bannedUntil time.Time
+}
+
+/**
+ * RateLimit limits new connections per second per client ip,
+ * banning clients exceeded limit. Is safe for concurrent use
+ */
+type RateLimit struct {
+	sync.Mutex
+
+	/* New connections per second */
+	rate float64
+
+	/* Max new connections at once */
+	burst float64
+
+	/* Time to reject all connections of client exceeded limit */
+	banDuration time.Duration
+
+	/* Buckets by client ip */
+	clients map[string]*bucket
+
+	/* Last time idle clients were forgotten */
+	lastCleanup time.Time
+
+	/* Name limit is shared with in cluster, empty if not shared */
+	name string
+}
+
+/**
+ * Creates new RateLimit based on config
+ */
+func NewRateLimit(cfg *config.RateLimitConfig) (*RateLimit, error) {
+
+	if cfg == nil {
+		return nil, errors.New("RateLimitConfig is nil")
+	}
+
+	if cfg.ConnectionsPerSecond <= 0 {
+		return nil, errors.New("RateLimitConfig connections_per_second should be positive")
+	}
+
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimit{
+		rate:        cfg.ConnectionsPerSecond,
+		burst:       burst,
+		banDuration: utils.ParseDurationOrDefault(cfg.BanDuration, 0),
+		clients:     make(map[string]*bucket),
+	}, nil
+}
+
+/**
+ * Checks if new connection of client ip is allowed at the moment now
+ */
+func (this *RateLimit) Allows(ip net.IP, now time.Time) bool {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if now.Sub(this.lastCleanup) > cleanupInterval {
+		this.cleanup(now)
+	}
+
+	key := ip.String()
+
+	b, ok := this.clients[key]
+	if !ok {
+		b = &bucket{tokens: this.burst, last: now}
+		this.clients[key] = b
+	}
+
+	if now.Before(b.bannedUntil) {
+		return false
+	}
+
+	this.refill(b, now)
+
+	if b.tokens < 1 {
+		if this.banDuration > 0 {
+			b.bannedUntil = now.Add(this.banDuration)
+			this.report(cluster.RateCount{Client: key, BannedUntil: b.bannedUntil})
+		}
+		return false
+	}
+
+	b.tokens--
+	this.report(cluster.RateCount{Client: key, Hits: 1})
+
+	return true
+}
+
+/**
+ * Shares limit with limits of the same name of cluster peers, so clients
+ * connections to any of them are counted, and bans apply to all of them
+ */
+func (this *RateLimit) Share(name string) {
+
+	this.Lock()
+	this.name = name
+	this.Unlock()
+
+	cluster.Global.AddRateLimit(name, this)
+}
+
+/**
+ * Stops sharing limit in cluster
+ */
+func (this *RateLimit) Unshare() {
+
+	this.Lock()
+	name := this.name
+	this.name = ""
+	this.Unlock()
+
+	if name != "" {
+		cluster.Global.RemoveRateLimit(name, this)
+	}
+}
+
+/**
+ * Takes tokens of connections clients made to cluster peer, and its bans
+ */
+func (this *RateLimit) MergeShared(counts []cluster.RateCount, now time.Time) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	for _, c := range counts {
+		b, ok := this.clients[c.Client]
+		if !ok {
+			b = &bucket{tokens: this.burst, last: now}
+			this.clients[c.Client] = b
+		}
+
+		this.refill(b, now)
+
+		b.tokens -= float64(c.Hits)
+		if b.tokens < 0 {
+			b.tokens = 0
+		}
+
+		if c.BannedUntil.After(b.bannedUntil) {
+			b.bannedUntil = c.BannedUntil
+		}
+	}
+}
+
+/**
+ * Reports connection or ban to cluster, should be called with lock held
+ */
+func (this *RateLimit) report(count cluster.RateCount) {
+	if this.name != "" {
+		cluster.Global.ReportRate(this.name, count)
+	}
+}
+
+/**
+ * Adds tokens for the time passed since last refill
+ */
+func (this *RateLimit) refill(b *bucket, now time.Time) {
+
+	b.tokens += now.Sub(b.last).Seconds() * this.rate
+	if b.tokens > this.burst {
+		b.tokens = this.burst
+	}
+
+	b.last = now
+}
+
+/**
+ * Forgets clients with full buckets that are not banned
+ */
+func (this *RateLimit) cleanup(now time.Time) {
+
+	for key, b := range this.clients {
+		if now.Before(b.bannedUntil) {
+			continue
+		}
+
+		this.refill(b, now)
+		if b.tokens >= this.burst {
+			delete(this.clients, key)
+		}
+	}
+
+	this.lastCleanup = now
+}
@@ -0,0 +1,388 @@
+/**
+ * script.go - lua script hooks deciding on connections
+ */
+
+package script
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"../../../config"
+	"../../../core"
+	"../../../logging"
+	"../accesslog"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/**
+ * Hooks script may define as global functions
+ */
+const (
+	onAccept      = "on_accept"
+	selectBackend = "select_backend"
+	onClose       = "on_close"
+)
+
+/**
+ * Max idle lua states kept to run hooks, more are created on demand
+ */
+const maxIdleStates = 32
+
+/**
+ * Script runs hooks of connections: on_accept may reject connection, select_backend
+ * may override backend chosen by balancer and on_close sees how connection ended.
+ * Every hook may tag connection for access log.
+ * Lua states are not goroutine safe, so every hook call takes its own one
+ */
+type Script struct {
+
+	/* Server name */
+	server string
+
+	/* Compiled script */
+	proto *lua.FunctionProto
+
+	/* Max duration of hook call and of script loading */
+	timeout time.Duration
+
+	/* If connection is rejected when on_accept hook fails */
+	rejectOnFailure bool
+
+	/* Hooks script defines */
+	hooks map[string]bool
+
+	/* Idle lua states */
+	states chan *lua.LState
+}
+
+/**
+ * Loads script of server
+ */
+func New(server string, cfg *config.ScriptConfig) (*Script, error) {
+
+	proto, err := compile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, errors.New("script.timeout parsing error")
+	}
+
+	script := &Script{
+		server:          server,
+		proto:           proto,
+		timeout:         timeout,
+		rejectOnFailure: cfg.Failpolicy == "reject",
+		hooks:           map[string]bool{},
+		states:          make(chan *lua.LState, maxIdleStates),
+	}
+
+	L, err := script.newState()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range []string{onAccept, selectBackend, onClose} {
+		script.hooks[hook] = L.GetGlobal(hook).Type() == lua.LTFunction
+	}
+
+	if !script.hooks[onAccept] && !script.hooks[selectBackend] && !script.hooks[onClose] {
+		L.Close()
+		return nil, errors.New("script " + cfg.Path + " defines none of on_accept, select_backend and on_close functions")
+	}
+
+	script.put(L)
+
+	return script, nil
+}
+
+/**
+ * Checks script configuration, loading it
+ */
+func Check(cfg *config.ScriptConfig) error {
+
+	script, err := New("", cfg)
+	if err != nil {
+		return err
+	}
+
+	script.Stop()
+
+	return nil
+}
+
+/**
+ * Compiles script file
+ */
+func compile(path string) (*lua.FunctionProto, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunk, err := parse.Parse(file, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return lua.Compile(chunk, path)
+}
+
+/**
+ * Creates lua state running script, limiting its top level code to timeout
+ */
+func (this *Script) newState() (*lua.LState, error) {
+
+	L := lua.NewState()
+
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		message := ""
+		for i := 1; i <= L.GetTop(); i++ {
+			message += L.ToStringMeta(L.Get(i)).String()
+		}
+		logging.For("script").Info(this.server, ": ", message)
+		return 0
+	}))
+
+	timeout, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+
+	L.SetContext(timeout)
+	L.Push(L.NewFunctionFromProto(this.proto))
+	err := L.PCall(0, lua.MultRet, nil)
+	L.RemoveContext()
+
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	return L, nil
+}
+
+/**
+ * Takes idle lua state or creates new one
+ */
+func (this *Script) get() (*lua.LState, error) {
+	select {
+	case L := <-this.states:
+		return L, nil
+	default:
+		return this.newState()
+	}
+}
+
+/**
+ * Returns lua state to idle ones, closing it if there are enough of them
+ */
+func (this *Script) put(L *lua.LState) {
+	select {
+	case this.states <- L:
+	default:
+		L.Close()
+	}
+}
+
+/**
+ * Closes idle lua states
+ */
+func (this *Script) Stop() {
+	for {
+		select {
+		case L := <-this.states:
+			L.Close()
+		default:
+			return
+		}
+	}
+}
+
+/**
+ * Runs on_accept hook, returns false if connection should be rejected.
+ * Failed hook rejects connection only with "reject" failpolicy
+ */
+func (this *Script) OnAccept(ctx *core.TcpContext) bool {
+
+	if !this.hooks[onAccept] {
+		return true
+	}
+
+	result, ok := this.call(onAccept, ctx, nil)
+	if !ok {
+		return !this.rejectOnFailure
+	}
+
+	return result != lua.LFalse
+}
+
+/**
+ * Checks if script defines select_backend hook
+ */
+func (this *Script) SelectsBackend() bool {
+	return this.hooks[selectBackend]
+}
+
+/**
+ * Runs select_backend hook with backends allowed to be elected, returns target of backend
+ * it chose, nil if it chose none. It's run by connection, not to hold election of others
+ */
+func (this *Script) SelectBackend(ctx *core.TcpContext, backends []core.Backend) *core.Target {
+
+	if len(backends) == 0 {
+		return nil
+	}
+
+	result, ok := this.call(selectBackend, ctx, func(L *lua.LState) []lua.LValue {
+		list := L.NewTable()
+		for i := range backends {
+			list.Append(backendTable(L, &backends[i]))
+		}
+		return []lua.LValue{list}
+	})
+
+	address, isString := result.(lua.LString)
+	if !ok || !isString {
+		return nil
+	}
+
+	for _, backend := range backends {
+		if backend.Address() == string(address) {
+			return &backend.Target
+		}
+	}
+
+	logging.For("script").Warn(this.server, ": select_backend returned ", address, " that is not an electable backend, using balancer")
+
+	return nil
+}
+
+/**
+ * Runs on_close hook with record of ended connection
+ */
+func (this *Script) OnClose(ctx *core.TcpContext, record accesslog.Record) {
+
+	this.call(onClose, ctx, func(L *lua.LState) []lua.LValue {
+		session := L.NewTable()
+		session.RawSetString("backend", lua.LString(record.Backend))
+		session.RawSetString("rx", lua.LNumber(record.Rx))
+		session.RawSetString("tx", lua.LNumber(record.Tx))
+		session.RawSetString("duration", lua.LNumber(record.Duration.Seconds()))
+		session.RawSetString("reason", lua.LString(record.Reason))
+		return []lua.LValue{session}
+	})
+}
+
+/**
+ * Calls hook with connection table and args, if script defines it, updating connection
+ * tags with ones hook set. Returns hook result, false if it's not defined or failed
+ */
+func (this *Script) call(hook string, ctx *core.TcpContext, args func(*lua.LState) []lua.LValue) (lua.LValue, bool) {
+
+	if !this.hooks[hook] {
+		return lua.LNil, false
+	}
+
+	log := logging.For("script")
+
+	L, err := this.get()
+	if err != nil {
+		log.Error(this.server, ": can't load script: ", err)
+		return lua.LNil, false
+	}
+
+	conn := this.connTable(L, ctx)
+
+	params := []lua.LValue{conn}
+	if args != nil {
+		params = append(params, args(L)...)
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+
+	L.SetContext(timeout)
+	err = L.CallByParam(lua.P{Fn: L.GetGlobal(hook), NRet: 1, Protect: true}, params...)
+	L.RemoveContext()
+
+	if err != nil {
+		// State may be left in the middle of the hook, so it's not reused
+		log.Error(this.server, ": ", hook, " failed for ", ctx.String(), ": ", err)
+		L.Close()
+		return lua.LNil, false
+	}
+
+	result := L.Get(-1)
+	L.Pop(1)
+
+	if tags, ok := conn.RawGetString("tags").(*lua.LTable); ok {
+		ctx.Tags = map[string]string{}
+		tags.ForEach(func(key lua.LValue, value lua.LValue) {
+			switch value.Type() {
+			case lua.LTString, lua.LTNumber, lua.LTBool:
+				ctx.Tags[key.String()] = value.String()
+			}
+		})
+	}
+
+	this.put(L)
+
+	return result, true
+}
+
+/**
+ * Creates table of connection hooks see
+ */
+func (this *Script) connTable(L *lua.LState, ctx *core.TcpContext) *lua.LTable {
+
+	conn := L.NewTable()
+	conn.RawSetString("server", lua.LString(this.server))
+	conn.RawSetString("client", lua.LString(ctx.String()))
+	conn.RawSetString("client_ip", lua.LString(ctx.Ip().String()))
+	conn.RawSetString("client_port", lua.LNumber(ctx.Port()))
+	conn.RawSetString("local", lua.LString(ctx.Conn.LocalAddr().String()))
+	conn.RawSetString("sni", lua.LString(ctx.Hostname))
+	conn.RawSetString("alpn", lua.LString(ctx.Alpn))
+	conn.RawSetString("protocol", lua.LString(ctx.Protocol))
+	conn.RawSetString("mux_route", lua.LString(ctx.MuxRoute))
+	conn.RawSetString("first_bytes", lua.LString(ctx.FirstBytes))
+
+	tags := L.NewTable()
+	for key, value := range ctx.Tags {
+		tags.RawSetString(key, lua.LString(value))
+	}
+	conn.RawSetString("tags", tags)
+
+	return conn
+}
+
+/**
+ * Creates table of backend select_backend sees
+ */
+func backendTable(L *lua.LState, backend *core.Backend) *lua.LTable {
+
+	table := L.NewTable()
+	table.RawSetString("address", lua.LString(backend.Address()))
+	table.RawSetString("host", lua.LString(backend.Host))
+	table.RawSetString("hostname", lua.LString(backend.Hostname))
+	table.RawSetString("port", lua.LString(backend.Port))
+	table.RawSetString("priority", lua.LNumber(backend.Priority))
+	table.RawSetString("weight", lua.LNumber(backend.Weight))
+	table.RawSetString("sni", lua.LString(backend.Sni))
+	table.RawSetString("active_connections", lua.LNumber(backend.Stats.ActiveConnections))
+
+	labels := L.NewTable()
+	for key, value := range backend.Labels {
+		labels.RawSetString(key, lua.LString(value))
+	}
+	table.RawSetString("labels", labels)
+
+	return table
+}
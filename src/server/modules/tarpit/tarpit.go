@@ -0,0 +1,116 @@
+/**
+ * tarpit.go - holding rejected client connections open to slow down scanners
+ */
+
+package tarpit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"../../../config"
+	"../../../utils"
+)
+
+/**
+ * Tarpit holds rejected connections open silently for duration, nothing is read
+ * from or written to them, then closes them. Connections over max are closed at once
+ */
+type Tarpit struct {
+	sync.Mutex
+
+	/* Time connection is held */
+	duration time.Duration
+
+	/* Max connections held at once */
+	max int
+
+	/* Held connections with timers closing them */
+	conns map[net.Conn]*time.Timer
+
+	/* Tarpit is stopped, new connections are closed at once */
+	stopped bool
+}
+
+/**
+ * Creates new tarpit based on config
+ */
+func New(cfg *config.TarpitConfig) *Tarpit {
+	return &Tarpit{
+		duration: utils.ParseDurationOrDefault(cfg.Duration, 0),
+		max:      cfg.MaxConnections,
+		conns:    make(map[net.Conn]*time.Timer),
+	}
+}
+
+/**
+ * Takes connection to hold it for duration, or closes it at once
+ * if tarpit is full. Connection should not be used by caller after it
+ */
+func (this *Tarpit) Hold(conn net.Conn) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.stopped || len(this.conns) >= this.max {
+		conn.Close()
+		return
+	}
+
+	this.conns[conn] = time.AfterFunc(this.duration, func() {
+		this.release(conn)
+	})
+}
+
+/**
+ * Checks if connection is held
+ */
+func (this *Tarpit) Holds(conn net.Conn) bool {
+
+	this.Lock()
+	defer this.Unlock()
+
+	_, ok := this.conns[conn]
+	return ok
+}
+
+/**
+ * Returns count of held connections
+ */
+func (this *Tarpit) Count() int {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return len(this.conns)
+}
+
+/**
+ * Closes held connection
+ */
+func (this *Tarpit) release(conn net.Conn) {
+
+	this.Lock()
+	delete(this.conns, conn)
+	this.Unlock()
+
+	conn.Close()
+}
+
+/**
+ * Closes all held connections, new ones are closed at once
+ */
+func (this *Tarpit) Stop() {
+
+	this.Lock()
+	this.stopped = true
+	conns := this.conns
+	this.conns = make(map[net.Conn]*time.Timer)
+	this.Unlock()
+
+	for conn, timer := range conns {
+		timer.Stop()
+		conn.Close()
+	}
+}
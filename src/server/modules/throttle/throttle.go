@@ -0,0 +1,177 @@
+/**
+ * throttle.go - rx/tx bandwidth throttling per connection and per server
+ */
+
+package throttle
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../../../config"
+)
+
+/**
+ * Token bucket limiting bytes per second.
+ * Bytes are reserved in advance, so limiter can be shared by connections
+ */
+type Limiter struct {
+	sync.Mutex
+
+	/* Bytes per second */
+	rate float64
+
+	/* Available bytes, negative if reserved in advance */
+	tokens float64
+
+	/* Last time tokens were refilled */
+	last time.Time
+}
+
+/**
+ * Creates new limiter, or nil if bytesPerSecond is 0
+ */
+func NewLimiter(bytesPerSecond int) *Limiter {
+
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+/**
+ * Reserves n bytes at the moment now and returns
+ * how long to wait before they could be sent
+ */
+func (this *Limiter) Reserve(n int, now time.Time) time.Duration {
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.tokens += now.Sub(this.last).Seconds() * this.rate
+	if this.tokens > this.rate {
+		this.tokens = this.rate
+	}
+	this.last = now
+
+	this.tokens -= float64(n)
+	if this.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-this.tokens / this.rate * float64(time.Second))
+}
+
+/**
+ * Throttle of the server, shares server limiters
+ * between connections and counts throttled bytes
+ */
+type Throttle struct {
+
+	/* Configuration */
+	cfg config.ThrottleConfig
+
+	/* Server limiters, nil if not limited */
+	rx *Limiter
+	tx *Limiter
+
+	/* Bytes delayed by throttling, updated atomically */
+	rxThrottled uint64
+	txThrottled uint64
+}
+
+/**
+ * Creates new Throttle based on config
+ */
+func NewThrottle(cfg config.ThrottleConfig) *Throttle {
+	return &Throttle{
+		cfg: cfg,
+		rx:  NewLimiter(cfg.ServerRxBytesPerSecond),
+		tx:  NewLimiter(cfg.ServerTxBytesPerSecond),
+	}
+}
+
+/**
+ * Returns new stream throttling data received from backend by one connection
+ */
+func (this *Throttle) Rx() *Stream {
+	return newStream(&this.rxThrottled, this.rx, NewLimiter(this.cfg.ConnectionRxBytesPerSecond))
+}
+
+/**
+ * Returns new stream throttling data transmitted to backend by one connection
+ */
+func (this *Throttle) Tx() *Stream {
+	return newStream(&this.txThrottled, this.tx, NewLimiter(this.cfg.ConnectionTxBytesPerSecond))
+}
+
+/**
+ * Returns total bytes delayed by throttling
+ */
+func (this *Throttle) Throttled() (rx uint64, tx uint64) {
+	return atomic.LoadUint64(&this.rxThrottled), atomic.LoadUint64(&this.txThrottled)
+}
+
+/**
+ * Stream throttles one direction of one connection
+ */
+type Stream struct {
+
+	/* Limiters to satisfy */
+	limiters []*Limiter
+
+	/* Counter of delayed bytes */
+	throttled *uint64
+}
+
+/**
+ * Creates new stream, or nil if there is nothing to limit
+ */
+func newStream(throttled *uint64, limiters ...*Limiter) *Stream {
+
+	stream := &Stream{throttled: throttled}
+	for _, l := range limiters {
+		if l != nil {
+			stream.limiters = append(stream.limiters, l)
+		}
+	}
+
+	if len(stream.limiters) == 0 {
+		return nil
+	}
+
+	return stream
+}
+
+/**
+ * Blocks until n bytes are allowed to be sent.
+ * Does nothing for nil stream
+ */
+func (this *Stream) Wait(n int) {
+
+	if this == nil {
+		return
+	}
+
+	now := time.Now()
+
+	var delay time.Duration
+	for _, l := range this.limiters {
+		if d := l.Reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return
+	}
+
+	atomic.AddUint64(this.throttled, uint64(n))
+	time.Sleep(delay)
+}
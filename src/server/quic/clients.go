@@ -0,0 +1,148 @@
+/**
+ * clients.go - current client connections of quic server
+ */
+
+package quic
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+/**
+ * Registry of current client connections, closed when server stops
+ */
+type clients struct {
+	sync.Mutex
+	conns  map[*quic.Conn]*client
+	closed bool
+}
+
+/**
+ * Client connection and reason it was closed by server with, if it was
+ */
+type client struct {
+	*quic.Conn
+
+	/* Reason of closing connection by server */
+	sync.Mutex
+	reason string
+}
+
+/**
+ * Creates empty clients registry
+ */
+func newClients() *clients {
+	return &clients{
+		conns: make(map[*quic.Conn]*client),
+	}
+}
+
+/**
+ * Registers connection, returns nil if registry is closed already
+ */
+func (this *clients) add(conn *quic.Conn) *client {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	c := &client{Conn: conn}
+	this.conns[conn] = c
+	return c
+}
+
+/**
+ * Unregisters connection
+ */
+func (this *clients) remove(conn *quic.Conn) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.conns, conn)
+}
+
+/**
+ * Returns current connections count
+ */
+func (this *clients) Count() uint {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return uint(len(this.conns))
+}
+
+/**
+ * Closes registry and all current connections
+ */
+func (this *clients) closeAll() {
+
+	this.Lock()
+	this.closed = true
+	conns := make([]*client, 0, len(this.conns))
+	for _, c := range this.conns {
+		conns = append(conns, c)
+	}
+	this.Unlock()
+
+	for _, c := range conns {
+		c.close(closeStopped, "drain")
+	}
+}
+
+/**
+ * Closes connection with error code, keeping reason of the first close
+ */
+func (this *client) close(code quic.ApplicationErrorCode, reason string) {
+
+	this.Lock()
+	if this.reason == "" {
+		this.reason = reason
+	}
+	this.Unlock()
+
+	this.CloseWithError(code, reason)
+}
+
+/**
+ * Returns reason connection was closed with: by server, or by client or timeout
+ */
+func (this *client) disconnectReason() string {
+
+	<-this.Context().Done()
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.reason != "" {
+		return this.reason
+	}
+
+	return closeReason(context.Cause(this.Context()))
+}
+
+/**
+ * Returns disconnect reason of connection closed with err
+ */
+func closeReason(err error) string {
+
+	var idleErr *quic.IdleTimeoutError
+	var appErr *quic.ApplicationError
+
+	switch {
+	case errors.As(err, &idleErr):
+		return "idle_timeout"
+	case errors.As(err, &appErr) && appErr.Remote:
+		return "client_closed"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,237 @@
+/**
+ * relay.go - relaying client connections to quic backends stream by stream
+ */
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+
+	"../../core"
+	"../../logging"
+	"../../utils"
+
+	"github.com/quic-go/quic-go"
+)
+
+/**
+ * Relays client connection to elected quic backend: every stream opened by client
+ * or backend, bidirectional or unidirectional, is mirrored by stream to the other side.
+ * Client negotiated alpn protocol is requested from backend. Connection ends when either side closes it
+ */
+func (this *Server) relay(ctx core.QuicContext, client *client) {
+
+	log := logging.For("quic/relay")
+
+	alpn := client.ConnectionState().TLS.NegotiatedProtocol
+
+	var backend *core.Backend
+	var backendConn *quic.Conn
+	var tried []core.Target
+
+	for {
+		var err error
+		backend, err = this.scheduler.TakeBackendExcluding(ctx, tried)
+		if err != nil {
+			log.Debug(err, " Closing connection ", ctx.String())
+			client.close(closeBackend, "no_backend")
+			return
+		}
+
+		backendConn, err = this.dialQuic(backend, alpn)
+		if err == nil {
+			break
+		}
+
+		this.scheduler.IncrementRefused(*backend)
+		this.scheduler.ReportPassive(*backend, false)
+		log.Error(err)
+
+		tried = append(tried, backend.Target)
+		if len(tried) > *this.cfg.MaxDialRetries {
+			client.close(closeBackend, "dial_failed")
+			return
+		}
+
+		this.scheduler.IncrementDialRetries()
+		log.Debug("Retrying next backend for ", ctx.String())
+	}
+
+	this.scheduler.ReportPassive(*backend, true)
+	this.scheduler.IncrementConnection(*backend)
+	defer this.scheduler.DecrementConnection(*backend)
+
+	countTx := func(n int) { this.scheduler.IncrementTx(*backend, uint(n)) }
+	countRx := func(n int) { this.scheduler.IncrementRx(*backend, uint(n)) }
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	go func() {
+		defer wg.Done()
+		this.relayStreams(client.Conn, backendConn, countTx, countRx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		this.relayStreams(backendConn, client.Conn, countRx, countTx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		this.relayUniStreams(client.Conn, backendConn, countTx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		this.relayUniStreams(backendConn, client.Conn, countRx)
+	}()
+
+	// Close the other side with the same error code as soon as one side is closed
+	var reason string
+	select {
+	case <-client.Context().Done():
+		reason = client.disconnectReason()
+		backendConn.CloseWithError(applicationErrorCode(context.Cause(client.Context())), "client closed")
+	case <-backendConn.Context().Done():
+		reason = "backend_closed"
+		if closeReason(context.Cause(backendConn.Context())) == "idle_timeout" {
+			reason = "idle_timeout"
+		}
+		client.close(applicationErrorCode(context.Cause(backendConn.Context())), reason)
+	}
+
+	wg.Wait()
+
+	this.scheduler.IncrementDisconnect(*backend, reason)
+}
+
+/**
+ * Connects to quic backend requesting alpn protocol
+ */
+func (this *Server) dialQuic(backend *core.Backend, alpn string) (*quic.Conn, error) {
+
+	if backend.IsUnix() {
+		return nil, errors.New("Unix socket backend " + backend.Address() + " is not supported for quic backend_protocol")
+	}
+
+	tlsConfig := &tls.Config{}
+	if this.backendsTlsConfig != nil {
+		tlsConfig = this.backendsTlsConfig.Clone()
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = backend.ServerName()
+	}
+
+	tlsConfig.NextProtos = []string{alpn}
+
+	ctx := context.Background()
+	if timeout := utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return quic.DialAddr(ctx, backend.Address(), tlsConfig, this.quicConfig(utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0)))
+}
+
+/**
+ * Mirrors bidirectional streams opened by from with streams opened to to,
+ * until either connection is closed
+ */
+func (this *Server) relayStreams(from *quic.Conn, to *quic.Conn, countForward func(int), countBackward func(int)) {
+
+	bufferSize := *this.cfg.BufferSize
+
+	for {
+		in, err := from.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		out, err := to.OpenStreamSync(from.Context())
+		if err != nil {
+			in.CancelRead(streamBackendError)
+			in.CancelWrite(streamBackendError)
+			return
+		}
+
+		go pipeStream(out, in, bufferSize, countForward)
+		go pipeStream(in, out, bufferSize, countBackward)
+	}
+}
+
+/**
+ * Mirrors unidirectional streams opened by from with streams opened to to,
+ * until either connection is closed
+ */
+func (this *Server) relayUniStreams(from *quic.Conn, to *quic.Conn, count func(int)) {
+
+	bufferSize := *this.cfg.BufferSize
+
+	for {
+		in, err := from.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		out, err := to.OpenUniStreamSync(from.Context())
+		if err != nil {
+			in.CancelRead(streamBackendError)
+			return
+		}
+
+		go pipeStream(out, in, bufferSize, count)
+	}
+}
+
+/**
+ * Stream receive side data is read from
+ */
+type receiveStream interface {
+	Read(b []byte) (int, error)
+	CancelRead(code quic.StreamErrorCode)
+}
+
+/**
+ * Stream send side data is written to
+ */
+type sendStream interface {
+	Write(b []byte) (int, error)
+	Close() error
+	CancelWrite(code quic.StreamErrorCode)
+}
+
+/**
+ * Copies data of one direction of mirrored streams: finishes to when from is finished,
+ * and passes resets - of from to to, and stop sending of to back to from, with the same error codes
+ */
+func pipeStream(to sendStream, from receiveStream, bufferSize int, count func(int)) {
+
+	err := copyData(to, from, bufferSize, nil, count)
+	if err == nil {
+		to.Close()
+		return
+	}
+
+	code := streamErrorCode(err, streamBackendError)
+	to.CancelWrite(code)
+	from.CancelRead(code)
+}
+
+/**
+ * Returns application error code connection was closed with, or no error code
+ */
+func applicationErrorCode(err error) quic.ApplicationErrorCode {
+
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.ErrorCode
+	}
+
+	return closeNoError
+}
@@ -0,0 +1,515 @@
+/**
+ * server.go - quic server implementation
+ */
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"../../balance"
+	"../../config"
+	"../../core"
+	"../../discovery"
+	"../../healthcheck"
+	"../../logging"
+	"../../stats"
+	"../../utils"
+	"../../utils/backendbind"
+	"../../utils/systemd"
+	tlsutil "../../utils/tls"
+	"../../utils/upgrade"
+	"../../utils/upstreamproxy"
+	"../modules/access"
+	"../modules/connlimit"
+	"../modules/ratelimit"
+	"../scheduler"
+
+	"github.com/quic-go/quic-go"
+)
+
+/**
+ * Application error codes connections are closed with
+ */
+const (
+	closeNoError quic.ApplicationErrorCode = 0x0
+	closeRefused quic.ApplicationErrorCode = 0x1
+	closeStopped quic.ApplicationErrorCode = 0x2
+	closeBackend quic.ApplicationErrorCode = 0x3
+)
+
+/**
+ * Server terminating quic and proxying client streams to tcp backends,
+ * or client connections to quic backends
+ */
+type Server struct {
+
+	/* Server friendly name */
+	name string
+
+	/* Configuration */
+	cfg config.Server
+
+	/* Scheduler */
+	scheduler scheduler.Scheduler
+
+	/* Stats handler */
+	statsHandler *stats.Handler
+
+	/* Listeners of client connections, one per bind */
+	listeners []*quic.Listener
+
+	/* Current client connections */
+	clients *clients
+
+	/* Reloadable tls certificates */
+	certificates *tlsutil.Certificates
+
+	/* Tls config used to connect to backends */
+	backendsTlsConfig *tls.Config
+
+	/* Dials tcp backends, directly or through upstream proxy */
+	dial func(ctx context.Context, network string, address string) (net.Conn, error)
+
+	/* Closed when server starts stopping, so queued connections give up */
+	stopping chan bool
+
+	/* Closed when accepting loops are finished */
+	stopped chan bool
+
+	/* Server is drained only once, even if stopped or drained again */
+	drainOnce sync.Once
+
+	/* ----- modules ----- */
+
+	/* Access module checks if client is allowed to connect */
+	access *access.Access
+
+	/* Rate limit module checks if client does not connect too often */
+	rateLimit *ratelimit.RateLimit
+
+	/* Connections limits module rejects connections over max_connections_per_client
+	 * and queues ones over server and process-wide max_connections */
+	limits *connlimit.Limiters
+}
+
+/**
+ * Creates new quic server
+ */
+func New(name string, cfg config.Server) (*Server, error) {
+
+	log := logging.For("quic/server")
+
+	var err error
+	statsHandler := stats.NewHandler(name)
+
+	stopping := make(chan bool)
+
+	server := &Server{
+		name:         name,
+		cfg:          cfg,
+		clients:      newClients(),
+		stopping:     stopping,
+		stopped:      make(chan bool),
+		limits:       connlimit.NewLimiters(name, *cfg.MaxConnections, cfg.QueueSize, *cfg.MaxConnectionsPerClient, utils.ParseDurationOrDefault(cfg.QueueTimeout, 0), stopping),
+		statsHandler: statsHandler,
+		scheduler: scheduler.Scheduler{
+			Balancer:       balance.New(nil, cfg.Balance),
+			Discovery:      discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
+			Healthcheck:    healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
+			StatsHandler:   statsHandler,
+			SlowStart:      utils.ParseDurationOrDefault(cfg.SlowStart, 0),
+			CircuitBreaker: cfg.CircuitBreaker,
+			StickTable:     scheduler.NewStickTable(cfg.Sticky),
+			Failover:       cfg.Failover,
+			Geoip:          cfg.Geoip,
+			LabelFilter:    cfg.BackendLabels,
+		},
+	}
+
+	statsHandler.Clients = server.clients
+
+	/* Add access, allowing everything if not configured, so it could be updated in runtime */
+	server.access = access.NewAllowAll()
+	if cfg.Access != nil {
+		server.access, err = access.NewAccess(cfg.Access)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add rate limit if needed */
+	if cfg.RateLimit != nil {
+		server.rateLimit, err = ratelimit.NewRateLimit(cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add backend tls config if needed */
+	if cfg.BackendsTls != nil {
+		server.backendsTlsConfig, err = tlsutil.NewBackendsConfig(cfg.BackendsTls)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	dialer, err := backendbind.New(cfg.BackendBind, cfg.HappyEyeballs, utils.ParseDurationOrDefault(*cfg.BackendConnectionTimeout, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	server.dial = dialer.DialContext
+	if cfg.UpstreamProxy != nil {
+		server.dial = upstreamproxy.New(cfg.UpstreamProxy, dialer).DialContext
+	}
+
+	log.Info("Creating quic server '", name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
+
+	return server, nil
+}
+
+/**
+ * Returns current server configuration
+ */
+func (this *Server) Cfg() config.Server {
+	cfg := this.cfg
+	cfg.Access = this.access.Config()
+	return cfg
+}
+
+/**
+ * Replace access rules without restart
+ */
+func (this *Server) UpdateAccess(cfg *config.AccessConfig) error {
+	return this.access.Update(cfg)
+}
+
+/**
+ * Returns healthcheck history of server backends
+ */
+func (this *Server) HealthcheckHistory() []healthcheck.TargetHistory {
+	return this.scheduler.HealthcheckHistory()
+}
+
+/**
+ * Returns health of server discovery
+ */
+func (this *Server) DiscoveryHealth() discovery.Health {
+	return this.scheduler.Discovery.Health()
+}
+
+/**
+ * Returns current connections counts of client ips
+ */
+func (this *Server) ClientConnections() []connlimit.ClientCount {
+	return this.limits.ClientCounts()
+}
+
+/**
+ * Returns client ip to backend stick table, nil if disabled
+ */
+func (this *Server) StickTable() *scheduler.StickTable {
+	return this.scheduler.StickTable
+}
+
+/**
+ * Returns scheduler of server backends pool
+ */
+func (this *Server) Schedulers() []*scheduler.Scheduler {
+	return []*scheduler.Scheduler{&this.scheduler}
+}
+
+/**
+ * Returns current connections and connections queued over max_connections
+ */
+func (this *Server) ConnectionsQueue() (int, int) {
+	return this.limits.Count()
+}
+
+/**
+ * Drains backend (drained = true) or enables it back
+ */
+func (this *Server) SetBackendDrained(target core.Target, drained bool) error {
+	return this.scheduler.SetDrained(target, drained)
+}
+
+/**
+ * Reload tls certificate from files
+ */
+func (this *Server) ReloadTls() error {
+
+	if this.certificates == nil {
+		return errors.New("Server has no tls certificate")
+	}
+
+	return this.certificates.Reload()
+}
+
+/**
+ * Start server
+ */
+func (this *Server) Start() error {
+
+	this.statsHandler.Start()
+	this.scheduler.Start()
+
+	if this.rateLimit != nil {
+		this.rateLimit.Share(this.name)
+	}
+
+	if err := this.listen(); err != nil {
+		this.Drain(0)
+		return err
+	}
+
+	return nil
+}
+
+/**
+ * Listen for client connections and handle them
+ */
+func (this *Server) listen() error {
+
+	log := logging.For("quic/server")
+
+	var err error
+
+	if this.certificates, err = tlsutil.NewCertificates(this.cfg.Tls); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if interval := utils.ParseDurationOrDefault(this.cfg.Tls.ReloadInterval, 0); interval > 0 {
+		this.certificates.Watch(interval, this.stopping)
+	}
+
+	tlsConfig, err := tlsutil.NewServerConfig(this.cfg.Tls, this.certificates)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	quicConfig := this.quicConfig(utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0))
+
+	for _, bind := range this.cfg.Bind {
+		var listener *quic.Listener
+		if listener, err = listenBind(bind, tlsConfig, quicConfig); err != nil {
+			break
+		}
+		this.listeners = append(this.listeners, listener)
+	}
+
+	if err != nil {
+		log.Error("Error starting quic server: ", err)
+		for _, listener := range this.listeners {
+			listener.Close()
+		}
+		this.listeners = nil
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, listener := range this.listeners {
+		wg.Add(1)
+		go func(listener *quic.Listener) {
+			defer wg.Done()
+
+			for {
+				conn, err := listener.Accept(context.Background())
+				if err != nil {
+					if err != quic.ErrServerClosed {
+						log.Error(err)
+					}
+					return
+				}
+
+				go this.handle(conn)
+			}
+		}(listener)
+	}
+
+	go func() {
+		wg.Wait()
+		close(this.stopped)
+	}()
+
+	return nil
+}
+
+/**
+ * Listens on bind, taking socket passed by old process on upgrade, or by systemd
+ */
+func listenBind(bind string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Listener, error) {
+
+	var conn *net.UDPConn
+	var err error
+
+	if inherited := upgrade.UDPConn(bind); inherited != nil {
+		conn = inherited
+	} else if systemd.IsSocketBind(bind) {
+		conn, err = systemd.UDPConn(bind)
+	} else {
+		var listenAddr *net.UDPAddr
+		if listenAddr, err = net.ResolveUDPAddr("udp", bind); err != nil {
+			return nil, err
+		}
+
+		conn, err = net.ListenUDP("udp", listenAddr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade.RegisterUDPConn(bind, conn)
+
+	listener, err := quic.Listen(conn, tlsConfig, quicConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+/**
+ * Returns quic config of client or backend connections with idle timeout
+ */
+func (this *Server) quicConfig(idleTimeout time.Duration) *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:        idleTimeout,
+		MaxIncomingStreams:    this.cfg.Quic.MaxStreams,
+		MaxIncomingUniStreams: this.cfg.Quic.MaxStreams,
+	}
+}
+
+/**
+ * Handles client connection: checks access and limits, then proxies its streams
+ */
+func (this *Server) handle(conn *quic.Conn) {
+
+	log := logging.For("quic/server")
+
+	state := conn.ConnectionState()
+	ctx := core.QuicContext{
+		Hostname:   state.TLS.ServerName,
+		RemoteAddr: *conn.RemoteAddr().(*net.UDPAddr),
+	}
+
+	if this.rateLimit != nil && !this.rateLimit.Allows(ctx.Ip(), time.Now()) {
+		log.Debug("Client exceeded connections rate limit ", ctx.String())
+		this.statsHandler.Disconnected("rate_limited")
+		conn.CloseWithError(closeRefused, "rate limited")
+		return
+	}
+
+	accessClient := access.Client{
+		Ip:  &ctx.RemoteAddr.IP,
+		Sni: state.TLS.ServerName,
+	}
+
+	var identity string
+	if this.cfg.Tls.ClientAuth != nil {
+		identity = tlsutil.ClientIdentity(state.TLS)
+		accessClient.Identity = identity
+		accessClient.Certificate = tlsutil.ClientCertificate(state.TLS)
+	}
+
+	if !this.access.AllowsConnection(accessClient) {
+		log.Debug("Client disallowed to connect ", ctx.String(), " ", identity)
+		this.statsHandler.Disconnected("access_denied")
+		conn.CloseWithError(closeRefused, "access denied")
+		return
+	}
+
+	if err := this.limits.Acquire(ctx.Ip()); err != nil {
+		if err != connlimit.ErrStopping {
+			this.statsHandler.Disconnected(connlimit.Reason(err))
+		}
+		conn.CloseWithError(closeRefused, "too many connections")
+		return
+	}
+
+	defer this.limits.Release(ctx.Ip())
+
+	client := this.clients.add(conn)
+	if client == nil {
+		conn.CloseWithError(closeStopped, "server stopped")
+		return
+	}
+
+	defer this.clients.remove(conn)
+
+	log.Debug("Accepted ", ctx.String(), " ", identity, " ", state.TLS.NegotiatedProtocol)
+
+	if this.cfg.Quic.BackendProtocol == "quic" {
+		this.relay(ctx, client)
+	} else {
+		this.serveStreams(ctx, client)
+	}
+
+	this.statsHandler.Disconnected(client.disconnectReason())
+}
+
+/**
+ * Stop, draining connections up to drain_timeout
+ */
+func (this *Server) Stop() {
+	this.Drain(utils.ParseDurationOrDefault(*this.cfg.DrainTimeout, 0))
+}
+
+/**
+ * Stop accepting new connections and wait until active
+ * ones are finished up to timeout, then drop the rest
+ */
+func (this *Server) Drain(timeout time.Duration) {
+	this.drainOnce.Do(func() {
+
+		log := logging.For("quic/server")
+		log.Info("Stopping ", this.name)
+
+		// Stopping is closed without listeners too, when server failed to listen, so certificates watch ends
+		close(this.stopping)
+
+		if len(this.listeners) > 0 {
+
+			for _, listener := range this.listeners {
+				listener.Close()
+			}
+			<-this.stopped
+
+			deadline := time.Now().Add(timeout)
+			for this.clients.Count() > 0 && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			if this.clients.Count() > 0 {
+				log.Warn("Drain timeout, dropping ", this.clients.Count(), " connections of ", this.name)
+			}
+
+			this.clients.closeAll()
+		}
+
+		this.stop()
+	})
+}
+
+/**
+ * Stops scheduler, stats and modules
+ */
+func (this *Server) stop() {
+	this.scheduler.Stop()
+	this.statsHandler.Stop()
+	this.access.Stop()
+	if this.rateLimit != nil {
+		this.rateLimit.Unshare()
+	}
+}
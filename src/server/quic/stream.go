@@ -0,0 +1,267 @@
+/**
+ * stream.go - proxying client streams to tcp backends
+ */
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"../../core"
+	"../../logging"
+	"../../utils"
+
+	"github.com/quic-go/quic-go"
+)
+
+/**
+ * Stream error code client streams are reset with if backend fails
+ */
+const streamBackendError quic.StreamErrorCode = 0x1
+
+/**
+ * Proxies every bidirectional stream of client connection to separately elected
+ * tcp backend, until connection is closed. Unidirectional streams are refused
+ */
+func (this *Server) serveStreams(ctx core.QuicContext, client *client) {
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		for {
+			stream, err := client.AcceptUniStream(context.Background())
+			if err != nil {
+				return
+			}
+			stream.CancelRead(streamBackendError)
+		}
+	}()
+
+	for {
+		stream, err := client.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			this.proxyStream(ctx, stream)
+		}()
+	}
+}
+
+/**
+ * Elects backend for stream and connects to it, retrying next backends
+ * on failure, then proxies data both ways until both sides are finished
+ */
+func (this *Server) proxyStream(ctx core.QuicContext, stream *quic.Stream) {
+
+	log := logging.For("quic/stream")
+
+	var backend *core.Backend
+	var backendConn net.Conn
+	var tried []core.Target
+
+	for {
+		var err error
+		backend, err = this.scheduler.TakeBackendExcluding(ctx, tried)
+		if err != nil {
+			log.Debug(err, " Resetting stream of ", ctx.String())
+			stream.CancelRead(streamBackendError)
+			stream.CancelWrite(streamBackendError)
+			return
+		}
+
+		backendConn, err = this.dialTcp(backend)
+		if err == nil {
+			break
+		}
+
+		this.scheduler.IncrementRefused(*backend)
+		this.scheduler.ReportPassive(*backend, false)
+		log.Error(err)
+
+		tried = append(tried, backend.Target)
+		if len(tried) > *this.cfg.MaxDialRetries {
+			stream.CancelRead(streamBackendError)
+			stream.CancelWrite(streamBackendError)
+			return
+		}
+
+		this.scheduler.IncrementDialRetries()
+		log.Debug("Retrying next backend for ", ctx.String())
+	}
+
+	this.scheduler.ReportPassive(*backend, true)
+	this.scheduler.IncrementConnection(*backend)
+	defer this.scheduler.DecrementConnection(*backend)
+
+	defer backendConn.Close()
+
+	idleTimeout := utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0)
+	bufferSize := *this.cfg.BufferSize
+
+	// Whichever side finishes first is the reason stream ended with
+	var reason string
+	var reasonOnce sync.Once
+	finish := func(r string) {
+		reasonOnce.Do(func() { reason = r })
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		err := copyData(backendConn, stream, bufferSize, nil, func(n int) {
+			this.scheduler.IncrementTx(*backend, uint(n))
+		})
+
+		finish("client_closed")
+
+		// Client reset stream, backend should not get EOF as if request is complete
+		if err != nil {
+			backendConn.Close()
+			return
+		}
+
+		closeWrite(backendConn)
+	}()
+
+	err := copyData(stream, backendConn, bufferSize, func() {
+		if idleTimeout > 0 {
+			backendConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+	}, func(n int) {
+		this.scheduler.IncrementRx(*backend, uint(n))
+	})
+
+	if err != nil {
+		var streamErr *quic.StreamError
+		if errors.As(err, &streamErr) {
+			finish("client_closed")
+		} else if e, ok := err.(net.Error); ok && e.Timeout() {
+			finish("idle_timeout")
+		} else {
+			finish("backend_reset")
+		}
+		stream.CancelWrite(streamBackendError)
+		stream.CancelRead(streamBackendError)
+	} else {
+		finish("backend_closed")
+		stream.Close()
+	}
+
+	wg.Wait()
+
+	this.scheduler.IncrementDisconnect(*backend, reason)
+}
+
+/**
+ * Connects to tcp or unix socket backend, establishing tls session if needed
+ */
+func (this *Server) dialTcp(backend *core.Backend) (net.Conn, error) {
+
+	ctx := context.Background()
+	if timeout := utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	var err error
+
+	if backend.IsUnix() {
+		// Unix socket backends are local, so they're connected directly
+		conn, err = (&net.Dialer{}).DialContext(ctx, "unix", backend.DialAddress())
+	} else {
+		conn, err = this.dial(ctx, "tcp", backend.Address())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if this.backendsTlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConfig := this.backendsTlsConfig
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = backend.ServerName()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+/**
+ * Copies data from reader to writer until reader is finished, counting
+ * copied bytes with count. Before, if set, is called before every read.
+ * Returns nil if reader is finished with EOF
+ */
+func copyData(to io.Writer, from io.Reader, bufferSize int, before func(), count func(int)) error {
+
+	buf := make([]byte, bufferSize)
+
+	for {
+		if before != nil {
+			before()
+		}
+
+		n, err := from.Read(buf)
+		if n > 0 {
+			if _, werr := to.Write(buf[0:n]); werr != nil {
+				return werr
+			}
+			count(n)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/**
+ * Closes write side of connection, so backend gets EOF, if connection supports it
+ */
+func closeWrite(conn net.Conn) {
+
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
+
+/**
+ * Returns error code of stream reset err is caused by, or code if it's not
+ */
+func streamErrorCode(err error, code quic.StreamErrorCode) quic.StreamErrorCode {
+
+	var streamErr *quic.StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.ErrorCode
+	}
+
+	return code
+}
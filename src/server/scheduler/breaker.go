@@ -0,0 +1,184 @@
+/**
+ * breaker.go - per backend circuit breaker
+ */
+
+package scheduler
+
+import (
+	"time"
+
+	"../../config"
+	"../../core"
+	"../../logging"
+	"../../utils"
+)
+
+/**
+ * Circuit breaker states, as exposed in backend stats
+ */
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+/**
+ * Circuit breaker of a backend
+ */
+type breaker struct {
+
+	/* Current state */
+	state string
+
+	/* Dials backend was elected for, but not finished yet */
+	pendingDials int
+
+	/* Consecutive failed dials */
+	consecutiveErrors int
+
+	/* Time breaker was opened */
+	openedAt time.Time
+
+	/* If probing dial is in progress in half-open state */
+	probing bool
+}
+
+/**
+ * Circuit breakers of backends
+ */
+type breakers struct {
+
+	/* Configuration */
+	cfg config.CircuitBreakerConfig
+
+	/* Time to keep breaker open before probing */
+	openTimeout time.Duration
+
+	/* Breakers by target */
+	byTarget map[core.Target]*breaker
+}
+
+/**
+ * Creates new breakers, or nil if circuit breaking is not configured
+ */
+func newBreakers(cfg *config.CircuitBreakerConfig) *breakers {
+
+	if cfg == nil {
+		return nil
+	}
+
+	return &breakers{
+		cfg:         *cfg,
+		openTimeout: utils.ParseDurationOrDefault(cfg.OpenTimeout, 0),
+		byTarget:    make(map[core.Target]*breaker),
+	}
+}
+
+/**
+ * Returns breaker of backend, creating closed one if needed
+ */
+func (this *breakers) get(backend *core.Backend) *breaker {
+
+	b, ok := this.byTarget[backend.Target]
+	if !ok {
+		b = &breaker{state: BREAKER_CLOSED}
+		this.byTarget[backend.Target] = b
+		backend.Stats.CircuitBreaker = b.state
+	}
+
+	return b
+}
+
+/**
+ * Checks if backend could be elected at the moment now,
+ * moving open breaker to half-open when open timeout passed
+ */
+func (this *breakers) allows(backend *core.Backend, now time.Time) bool {
+
+	b := this.get(backend)
+
+	if this.cfg.MaxConnections > 0 && int(backend.Stats.ActiveConnections) >= this.cfg.MaxConnections {
+		return false
+	}
+
+	if this.cfg.MaxPendingDials > 0 && b.pendingDials >= this.cfg.MaxPendingDials {
+		return false
+	}
+
+	if b.state == BREAKER_OPEN && now.Sub(b.openedAt) >= this.openTimeout {
+		this.setState(backend, b, BREAKER_HALF_OPEN)
+	}
+
+	switch b.state {
+	case BREAKER_OPEN:
+		return false
+	case BREAKER_HALF_OPEN:
+		return !b.probing
+	}
+
+	return true
+}
+
+/**
+ * Handles backend elected for dialing
+ */
+func (this *breakers) elected(backend *core.Backend) {
+
+	b := this.get(backend)
+	b.pendingDials++
+
+	if b.state == BREAKER_HALF_OPEN {
+		b.probing = true
+	}
+}
+
+/**
+ * Handles finished dial to backend, opening breaker after
+ * consecutive failures or failed probe and closing it after succeeded probe
+ */
+func (this *breakers) dialed(backend *core.Backend, ok bool, now time.Time) {
+
+	b := this.get(backend)
+	if b.pendingDials > 0 {
+		b.pendingDials--
+	}
+
+	if ok {
+		b.consecutiveErrors = 0
+		if b.state == BREAKER_HALF_OPEN {
+			b.probing = false
+			this.setState(backend, b, BREAKER_CLOSED)
+		}
+		return
+	}
+
+	b.consecutiveErrors++
+
+	if b.state == BREAKER_HALF_OPEN ||
+		b.state == BREAKER_CLOSED && this.cfg.ConsecutiveErrors > 0 && b.consecutiveErrors >= this.cfg.ConsecutiveErrors {
+		b.probing = false
+		b.openedAt = now
+		this.setState(backend, b, BREAKER_OPEN)
+	}
+}
+
+/**
+ * Forgets breakers of backends not present in backends anymore
+ */
+func (this *breakers) retain(backends map[core.Target]*core.Backend) {
+	for target := range this.byTarget {
+		if _, ok := backends[target]; !ok {
+			delete(this.byTarget, target)
+		}
+	}
+}
+
+/**
+ * Changes breaker state, reflecting it in backend stats
+ */
+func (this *breakers) setState(backend *core.Backend, b *breaker, state string) {
+	b.state = state
+	backend.Stats.CircuitBreaker = state
+
+	logging.For("scheduler/breaker").Info("Circuit breaker of ", backend.Target, " is ", state)
+}
@@ -0,0 +1,32 @@
+/**
+ * pool.go - schedulers of additional backends pools of servers
+ */
+
+package scheduler
+
+import (
+	"../../balance"
+	"../../config"
+	"../../discovery"
+	"../../healthcheck"
+	"../../stats"
+	"../../utils"
+)
+
+/**
+ * Creates scheduler of additional backends pool of server (route, shadow or canary one),
+ * having its own balance, discovery and healthcheck
+ */
+func NewPool(cfg config.Server, statsHandler *stats.Handler, balanceKind string, discoveryCfg *config.DiscoveryConfig, healthcheckCfg *config.HealthcheckConfig) *Scheduler {
+	return &Scheduler{
+		Balancer:       balance.New(nil, balanceKind),
+		Discovery:      discovery.New(discoveryCfg.Kind, *discoveryCfg),
+		Healthcheck:    healthcheck.New(healthcheckCfg.Kind, *healthcheckCfg),
+		StatsHandler:   statsHandler,
+		SlowStart:      utils.ParseDurationOrDefault(cfg.SlowStart, 0),
+		CircuitBreaker: cfg.CircuitBreaker,
+		Failover:       cfg.Failover,
+		Geoip:          cfg.Geoip,
+		LabelFilter:    cfg.BackendLabels,
+	}
+}
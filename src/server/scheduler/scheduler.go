@@ -7,14 +7,22 @@
 package scheduler
 
 import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"../../config"
 	"../../core"
 	"../../discovery"
+	"../../events"
 	"../../healthcheck"
 	"../../logging"
 	"../../stats"
 	"../../stats/counters"
+	"../../utils/geoip"
 )
 
 /**
@@ -31,6 +39,8 @@ const (
 	IncrementRefused
 	IncrementTx
 	IncrementRx
+	IncrementDialRetries
+	IncrementDisconnect
 )
 
 /**
@@ -49,6 +59,27 @@ type ElectRequest struct {
 	Context  core.Context
 	Response chan core.Backend
 	Err      chan error
+
+	/* Target to take instead of election if it's live, may be nil */
+	Preferred *core.Target
+
+	/* Targets to exclude from election, for example already failed ones */
+	Exclude []core.Target
+
+	/* Target chosen by caller among candidates, elected instead of balancer choice if it's still electable, may be nil */
+	Selected *core.Target
+
+	/* If set, backends that can be elected are sent to it instead of electing one */
+	Candidates chan []core.Backend
+}
+
+/**
+ * Request to drain or enable backend
+ */
+type drainRequest struct {
+	target  core.Target
+	drained bool
+	err     chan error
 }
 
 /**
@@ -65,6 +96,24 @@ type Scheduler struct {
 	/* Healthcheck impl */
 	Healthcheck *healthcheck.Healthcheck
 
+	/* Duration to ramp weight of backend became live, 0 to disable */
+	SlowStart time.Duration
+
+	/* Circuit breaker configuration, nil to disable */
+	CircuitBreaker *config.CircuitBreakerConfig
+
+	/* Client ip to backend stick table, nil to disable */
+	StickTable *StickTable
+
+	/* Priority failover configuration, nil to ignore backends priorities */
+	Failover *config.FailoverConfig
+
+	/* Labels backends should have to be used, empty to use all */
+	LabelFilter map[string]string
+
+	/* Geoip region preference configuration, nil to ignore client location */
+	Geoip *config.GeoipBalanceConfig
+
 	/* ----- backends ------*/
 
 	/* Current cached backends map */
@@ -73,6 +122,18 @@ type Scheduler struct {
 	/* Current cached backends list (same as backends.list) but preserving order */
 	backendsList []*core.Backend
 
+	/* Times backends in slow start became live */
+	liveSince map[core.Target]time.Time
+
+	/* Weights backends reported for themselves with healthcheck, overriding discovered ones */
+	checkedWeights map[core.Target]int
+
+	/* Circuit breakers of backends, nil if disabled */
+	breakers *breakers
+
+	/* Connections of elected backends not dialed yet, counted to their max connections */
+	reserved map[core.Target]int
+
 	/* Stats */
 	StatsHandler *stats.Handler
 
@@ -84,8 +145,36 @@ type Scheduler struct {
 	/* Stop channel */
 	stop chan bool
 
+	/* Closed when scheduler goroutine stopped, so operations sent after it are dropped */
+	done chan bool
+
 	/* Elect backend channel */
 	elect chan ElectRequest
+
+	/* Drain or enable backend channel */
+	drain chan drainRequest
+
+	/* Elect requests and operations waiting to be taken by scheduler goroutine, updated atomically */
+	pendingElects int32
+	pendingOps    int32
+}
+
+/**
+ * Scheduler internal queues, for diagnostics
+ */
+type State struct {
+
+	/* Stats name of backends pool */
+	Pool string `json:"pool"`
+
+	/* Elect requests waiting to be taken by scheduler */
+	PendingElects int32 `json:"pending_elects"`
+
+	/* Backend operations waiting to be taken by scheduler */
+	PendingOps int32 `json:"pending_ops"`
+
+	/* Passive healthcheck results waiting to be processed */
+	PendingPassive int `json:"pending_passive_healthchecks"`
 }
 
 /**
@@ -99,7 +188,13 @@ func (this *Scheduler) Start() {
 
 	this.ops = make(chan Op)
 	this.elect = make(chan ElectRequest)
+	this.drain = make(chan drainRequest)
 	this.stop = make(chan bool)
+	this.done = make(chan bool)
+	this.liveSince = make(map[core.Target]time.Time)
+	this.checkedWeights = make(map[core.Target]int)
+	this.reserved = make(map[core.Target]int)
+	this.breakers = newBreakers(this.CircuitBreaker)
 
 	this.Discovery.Start()
 	this.Healthcheck.Start()
@@ -107,6 +202,26 @@ func (this *Scheduler) Start() {
 	// backends stats pusher ticker
 	backendsPushTicker := time.NewTicker(2 * time.Second)
 
+	// stick table expiration and persisting tickers, if enabled
+	var stickExpireTicker, stickPersistTicker *time.Ticker
+	var stickExpireC, stickPersistC <-chan time.Time
+
+	if this.StickTable != nil {
+		if err := this.StickTable.Load(); err != nil {
+			log.Warn("Can't load stick table ", this.StickTable.persistPath, ": ", err)
+		}
+
+		this.StickTable.Share(this.StatsHandler.Name())
+
+		stickExpireTicker = time.NewTicker(this.StickTable.ttl)
+		stickExpireC = stickExpireTicker.C
+
+		if this.StickTable.persistPath != "" && this.StickTable.persistInterval > 0 {
+			stickPersistTicker = time.NewTicker(this.StickTable.persistInterval)
+			stickPersistC = stickPersistTicker.C
+		}
+	}
+
 	/**
 	 * Goroutine updates and manages backends
 	 */
@@ -119,7 +234,7 @@ func (this *Scheduler) Start() {
 			// handle newly discovered backends
 			case backends := <-this.Discovery.Discover():
 				this.HandleBackendsUpdate(backends)
-				this.Healthcheck.In <- this.Targets()
+				this.Healthcheck.In <- this.Backends()
 				this.StatsHandler.BackendsCounter.In <- this.Targets()
 
 			/* ------ healthcheck ----- */
@@ -127,6 +242,9 @@ func (this *Scheduler) Start() {
 			// handle backend healthcheck result
 			case checkResult := <-this.Healthcheck.Out:
 				this.HandleBackendLiveChange(checkResult.Target, checkResult.Live)
+				if checkResult.Weight > 0 {
+					this.HandleBackendWeightChange(checkResult.Target, checkResult.Weight)
+				}
 
 			/* ----- stats ----- */
 
@@ -148,14 +266,41 @@ func (this *Scheduler) Start() {
 			case electReq := <-this.elect:
 				this.HandleBackendElect(electReq)
 
+			// drain or enable backend
+			case drainReq := <-this.drain:
+				drainReq.err <- this.HandleBackendDrain(drainReq.target, drainReq.drained)
+
+			/* ----- stick table ----- */
+
+			// forget clients with expired entries
+			case now := <-stickExpireC:
+				this.StickTable.Expire(now)
+
+			// persist entries
+			case <-stickPersistC:
+				if err := this.StickTable.Save(); err != nil {
+					log.Warn("Can't persist stick table ", this.StickTable.persistPath, ": ", err)
+				}
+
 			/* ----- stop ----- */
 
 			// handle scheduler stop
 			case <-this.stop:
 				log.Info("Stopping scheduler")
+				close(this.done)
 				backendsPushTicker.Stop()
 				this.Discovery.Stop()
 				this.Healthcheck.Stop()
+				if this.StickTable != nil {
+					this.StickTable.Unshare()
+					stickExpireTicker.Stop()
+					if stickPersistTicker != nil {
+						stickPersistTicker.Stop()
+					}
+					if err := this.StickTable.Save(); err != nil {
+						log.Warn("Can't persist stick table ", this.StickTable.persistPath, ": ", err)
+					}
+				}
 				return
 			}
 		}
@@ -182,7 +327,17 @@ func (this *Scheduler) Backends() []core.Backend {
 
 	backends := make([]core.Backend, 0, len(this.backends))
 	for _, b := range this.backends {
-		backends = append(backends, *b)
+		backend := *b
+
+		// Copy counters, so they are not changed while backends are used by others
+		if b.Stats.Disconnects != nil {
+			backend.Stats.Disconnects = make(map[string]uint64, len(b.Stats.Disconnects))
+			for reason, n := range b.Stats.Disconnects {
+				backend.Stats.Disconnects[reason] = n
+			}
+		}
+
+		backends = append(backends, backend)
 	}
 
 	return backends
@@ -216,29 +371,128 @@ func (this *Scheduler) HandleBackendLiveChange(target core.Target, live bool) {
 		return
 	}
 
+	if this.SlowStart > 0 && live && !backend.Stats.Live {
+		this.liveSince[target] = time.Now()
+	}
+
+	if !live {
+		delete(this.liveSince, target)
+	}
+
+	if backend.Stats.Live != live {
+		if live {
+			this.publish(events.BACKEND_UP, target)
+		} else {
+			this.publish(events.BACKEND_DOWN, target)
+		}
+	}
+
 	backend.Stats.Live = live
 }
 
+/**
+ * Updates backend weight reported by healthcheck, it's kept over discovery updates
+ */
+func (this *Scheduler) HandleBackendWeightChange(target core.Target, weight int) {
+
+	backend, ok := this.backends[target]
+	if !ok {
+		logging.For("scheduler").Warn("No backends for checkResult ", target)
+		return
+	}
+
+	this.checkedWeights[target] = weight
+	backend.Weight = weight
+}
+
+/**
+ * Returns backend with weight ramped proportionally to time passed
+ * since it became live, or backend itself if it's not in slow start
+ */
+func (this *Scheduler) slowStarted(backend *core.Backend, now time.Time) *core.Backend {
+
+	since, ok := this.liveSince[backend.Target]
+	if !ok {
+		return backend
+	}
+
+	elapsed := now.Sub(since)
+	if elapsed >= this.SlowStart {
+		delete(this.liveSince, backend.Target)
+		return backend
+	}
+
+	ramped := *backend
+	ramped.Weight = int(float64(backend.Weight) * float64(elapsed) / float64(this.SlowStart))
+	if ramped.Weight < 1 {
+		ramped.Weight = 1
+	}
+
+	return &ramped
+}
+
 /**
  * Update backends map
  */
 func (this *Scheduler) HandleBackendsUpdate(backends []core.Backend) {
 
 	updated := map[core.Target]*core.Backend{}
-	updatedList := make([]*core.Backend, len(backends))
+	updatedList := make([]*core.Backend, 0, len(backends))
 
 	for i := range backends {
 		b := backends[i]
+
+		if !hasLabels(b, this.LabelFilter) {
+			continue
+		}
+
 		oldB, ok := this.backends[b.Target]
 
 		if ok {
 			// if we have this backend, update it's discovery properties
 			updatedB := oldB.MergeFrom(b)
+			if weight, checked := this.checkedWeights[b.Target]; checked {
+				updatedB.Weight = weight
+			}
 			updated[oldB.Target] = updatedB
-			updatedList[i] = updatedB
+			updatedList = append(updatedList, updatedB)
 		} else {
 			updated[b.Target] = &b
-			updatedList[i] = &b
+			updatedList = append(updatedList, &b)
+		}
+	}
+
+	for target := range this.liveSince {
+		if _, ok := updated[target]; !ok {
+			delete(this.liveSince, target)
+		}
+	}
+
+	for target := range this.checkedWeights {
+		if _, ok := updated[target]; !ok {
+			delete(this.checkedWeights, target)
+		}
+	}
+
+	for target := range this.reserved {
+		if _, ok := updated[target]; !ok {
+			delete(this.reserved, target)
+		}
+	}
+
+	if this.breakers != nil {
+		this.breakers.retain(updated)
+	}
+
+	for target := range this.backends {
+		if _, ok := updated[target]; !ok {
+			this.publish(events.BACKEND_REMOVED, target)
+		}
+	}
+
+	for _, b := range updatedList {
+		if _, ok := this.backends[b.Target]; !ok {
+			this.publish(events.BACKEND_ADDED, b.Target)
 		}
 	}
 
@@ -246,32 +500,234 @@ func (this *Scheduler) HandleBackendsUpdate(backends []core.Backend) {
 	this.backendsList = updatedList
 }
 
+/**
+ * Publishes event of backend of this scheduler pool
+ */
+func (this *Scheduler) publish(eventType string, target core.Target) {
+	events.Global.Publish(events.Event{
+		Type:    eventType,
+		Server:  this.StatsHandler.Name(),
+		Backend: &target,
+	})
+}
+
 /**
  * Perform backend election
  */
 func (this *Scheduler) HandleBackendElect(req ElectRequest) {
 
-	// Filter only live backends
-	var backends []*core.Backend
-	for _, b := range this.backendsList {
+	// Take preferred backend if it's still discovered, live and not saturated
+	if req.Preferred != nil {
+		if backend, ok := this.backends[*req.Preferred]; ok && backend.Stats.Live && !backend.Stats.Drained && !this.saturated(backend) {
+			this.reserved[backend.Target]++
+			req.Response <- *backend
+			return
+		}
+	}
 
-		if !b.Stats.Live {
-			continue
+	now := time.Now()
+	electable := this.candidates(req, now)
+
+	// Respond with candidates, with ramped weights of backends in slow start
+	if req.Candidates != nil {
+		candidates := make([]core.Backend, len(electable))
+		for i, b := range electable {
+			candidates[i] = *this.slowStarted(b, now)
 		}
+		req.Candidates <- candidates
+		return
+	}
 
-		backends = append(backends, b)
+	// Take backend client sticks to if it's still electable
+	var client string
+	if this.StickTable != nil {
+		client = req.Context.Ip().String()
+		if target := this.StickTable.Get(client, now); target != nil {
+			for _, backend := range electable {
+				if backend.Target != *target {
+					continue
+				}
+				this.StickTable.Put(client, *backend, now)
+				if this.breakers != nil {
+					this.breakers.elected(backend)
+				}
+				this.reserved[backend.Target]++
+				req.Response <- *backend
+				return
+			}
+		}
 	}
 
-	// Elect backend
-	backend, err := this.Balancer.Elect(req.Context, backends)
-	if err != nil {
-		req.Err <- err
-		return
+	// Take backend caller selected if it's still electable
+	var backend *core.Backend
+	if req.Selected != nil {
+		for _, b := range electable {
+			if b.Target == *req.Selected {
+				backend = b
+			}
+		}
+	}
+
+	if backend == nil {
+		// Ramp weights of backends in slow start
+		backends := make([]*core.Backend, len(electable))
+		for i, b := range electable {
+			backends[i] = this.slowStarted(b, now)
+		}
+
+		// Elect backend
+		var err error
+		backend, err = this.Balancer.Elect(req.Context, backends)
+		if err != nil {
+			req.Err <- err
+			return
+		}
+	}
+
+	// Respond with tracked backend, not the one with ramped weight
+	if tracked, ok := this.backends[backend.Target]; ok {
+		backend = tracked
+	}
+
+	if this.breakers != nil {
+		this.breakers.elected(backend)
 	}
 
+	if this.StickTable != nil {
+		this.StickTable.Put(client, *backend, now)
+	}
+
+	this.reserved[backend.Target]++
+
 	req.Response <- *backend
 }
 
+/**
+ * Returns backends election is made from: live, not drained, not excluded and not saturated ones
+ * allowed by circuit breakers, of the top priority tier and client region if they're enabled
+ */
+func (this *Scheduler) candidates(req ElectRequest, now time.Time) []*core.Backend {
+
+	var electable []*core.Backend
+	for _, b := range this.backendsList {
+		if this.electable(b, req.Exclude, now) {
+			electable = append(electable, b)
+		}
+	}
+
+	// Leave only backends of the top priority tier having enough of them
+	if this.Failover != nil {
+		electable = failoverTier(electable, this.Failover.MinHealthy)
+	}
+
+	// Leave only backends of client region if there are any
+	if this.Geoip != nil {
+		electable = regionBackends(electable, this.Geoip, req.Context.Ip())
+	}
+
+	return electable
+}
+
+/**
+ * Returns backends of the highest priority (lowest value) tier having at least
+ * minHealthy of them, or of the highest priority tier if none has that many
+ */
+func failoverTier(backends []*core.Backend, minHealthy int) []*core.Backend {
+
+	tiers := map[int][]*core.Backend{}
+	priorities := []int{}
+
+	for _, b := range backends {
+		if _, ok := tiers[b.Priority]; !ok {
+			priorities = append(priorities, b.Priority)
+		}
+		tiers[b.Priority] = append(tiers[b.Priority], b)
+	}
+
+	if len(priorities) == 0 {
+		return backends
+	}
+
+	sort.Ints(priorities)
+
+	for _, priority := range priorities {
+		if len(tiers[priority]) >= minHealthy {
+			return tiers[priority]
+		}
+	}
+
+	return tiers[priorities[0]]
+}
+
+/**
+ * Returns backends labeled with region of client ip, or all of
+ * them if there are none or client location is unknown
+ */
+func regionBackends(backends []*core.Backend, cfg *config.GeoipBalanceConfig, ip net.IP) []*core.Backend {
+
+	location := geoip.Global.Lookup(ip)
+
+	region := location.Country
+	if cfg.Region == "continent" {
+		region = location.Continent
+	}
+
+	if region == "" {
+		return backends
+	}
+
+	var preferred []*core.Backend
+	for _, b := range backends {
+		if strings.EqualFold(b.Labels[cfg.PreferLabel], region) {
+			preferred = append(preferred, b)
+		}
+	}
+
+	if len(preferred) == 0 {
+		return backends
+	}
+
+	return preferred
+}
+
+/**
+ * Checks if backend can be elected: it's live, not drained, not
+ * excluded, not saturated and allowed by its circuit breaker
+ */
+func (this *Scheduler) electable(backend *core.Backend, exclude []core.Target, now time.Time) bool {
+
+	if !backend.Stats.Live || backend.Stats.Drained || excluded(backend.Target, exclude) || this.saturated(backend) {
+		return false
+	}
+
+	return this.breakers == nil || this.breakers.allows(backend, now)
+}
+
+/**
+ * Marks backend as drained, so it's not elected for new connections
+ * while active ones are finished, or enables it back.
+ * Backend stays drained until enabled or removed by discovery
+ */
+func (this *Scheduler) HandleBackendDrain(target core.Target, drained bool) error {
+
+	backend, ok := this.backends[target]
+	if !ok {
+		return errors.New("Backend " + target.Address() + " not found")
+	}
+
+	if backend.Stats.Drained != drained {
+		logging.For("scheduler").Info("Backend ", target, " drained: ", drained)
+		if drained {
+			this.publish(events.BACKEND_DRAINED, target)
+		} else {
+			this.publish(events.BACKEND_ENABLED, target)
+		}
+	}
+
+	backend.Stats.Drained = drained
+	return nil
+}
+
 /**
  * Handle operation on the backend
  */
@@ -286,6 +742,9 @@ func (this *Scheduler) HandleOp(op Op) {
 	case IncrementRx:
 		this.StatsHandler.Traffic <- core.ReadWriteCount{CountRead: op.param.(uint), Target: op.target}
 		return
+	case IncrementDialRetries:
+		this.StatsHandler.DialRetries <- 1
+		return
 	}
 
 	log := logging.For("scheduler")
@@ -298,18 +757,74 @@ func (this *Scheduler) HandleOp(op Op) {
 
 	switch op.op {
 	case IncrementRefused:
+		this.release(backend)
 		backend.Stats.RefusedConnections++
+		if this.breakers != nil {
+			this.breakers.dialed(backend, false, time.Now())
+		}
 	case IncrementConnection:
+		this.release(backend)
 		backend.Stats.ActiveConnections++
 		backend.Stats.TotalConnections++
+		if this.breakers != nil {
+			this.breakers.dialed(backend, true, time.Now())
+		}
 	case DecrementConnection:
 		backend.Stats.ActiveConnections--
+	case IncrementDisconnect:
+		if backend.Stats.Disconnects == nil {
+			backend.Stats.Disconnects = map[string]uint64{}
+		}
+		backend.Stats.Disconnects[op.param.(string)]++
 	default:
 		log.Warn("Don't know how to handle op ", op.op)
 	}
 
 }
 
+/**
+ * Checks if backend reached it's max connections, counting ones elected
+ * but not dialed yet, as active connections are counted after dial
+ */
+func (this *Scheduler) saturated(backend *core.Backend) bool {
+	return backend.MaxConnections > 0 && int(backend.Stats.ActiveConnections)+this.reserved[backend.Target] >= backend.MaxConnections
+}
+
+/**
+ * Releases connection reserved for backend on election, when it's dialed or dial failed
+ */
+func (this *Scheduler) release(backend *core.Backend) {
+	if this.reserved[backend.Target] > 1 {
+		this.reserved[backend.Target]--
+		return
+	}
+	delete(this.reserved, backend.Target)
+}
+
+/**
+ * Checks if backend has all labels with the same values
+ */
+func hasLabels(backend core.Backend, labels map[string]string) bool {
+	for k, v := range labels {
+		if value, ok := backend.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Checks if target is in exclude list
+ */
+func excluded(target core.Target, exclude []core.Target) bool {
+	for _, t := range exclude {
+		if t.EqualTo(target) {
+			return true
+		}
+	}
+	return false
+}
+
 /**
  * Stop scheduler
  */
@@ -318,11 +833,86 @@ func (this *Scheduler) Stop() {
 }
 
 /**
- * Take elect backend for proxying
+ * Returns healthcheck history of backends
+ */
+func (this *Scheduler) HealthcheckHistory() []healthcheck.TargetHistory {
+	return this.Healthcheck.History()
+}
+
+/**
+ * Returns current internal queues of scheduler
+ */
+func (this *Scheduler) State() State {
+	return State{
+		Pool:           this.StatsHandler.Name(),
+		PendingElects:  atomic.LoadInt32(&this.pendingElects),
+		PendingOps:     atomic.LoadInt32(&this.pendingOps),
+		PendingPassive: this.Healthcheck.Pending(),
+	}
+}
+
+/**
+ * Drain backend (drained = true) or enable it back
+ */
+func (this *Scheduler) SetDrained(target core.Target, drained bool) error {
+	r := drainRequest{target, drained, make(chan error)}
+	this.drain <- r
+	return <-r.err
+}
+
+/**
+ * Take elect backend for proxying. Connection is reserved for taken
+ * backend until caller reports dial with IncrementConnection or IncrementRefused
  */
 func (this *Scheduler) TakeBackend(context core.Context) (*core.Backend, error) {
-	r := ElectRequest{context, make(chan core.Backend), make(chan error)}
+	return this.TakeBackendPreferring(context, nil)
+}
+
+/**
+ * Take preferred backend for proxying if it's live,
+ * or elect another one otherwise
+ */
+func (this *Scheduler) TakeBackendPreferring(context core.Context, preferred *core.Target) (*core.Backend, error) {
+	return this.take(ElectRequest{Context: context, Response: make(chan core.Backend), Err: make(chan error), Preferred: preferred})
+}
+
+/**
+ * Take elect backend for proxying, except exclude ones
+ */
+func (this *Scheduler) TakeBackendExcluding(context core.Context, exclude []core.Target) (*core.Backend, error) {
+	return this.take(ElectRequest{Context: context, Response: make(chan core.Backend), Err: make(chan error), Exclude: exclude})
+}
+
+/**
+ * Take selected backend for proxying if it's still electable, or elect another
+ * one otherwise, except exclude ones. Selected one is chosen from Candidates
+ */
+func (this *Scheduler) TakeBackendSelected(context core.Context, exclude []core.Target, selected *core.Target) (*core.Backend, error) {
+	return this.take(ElectRequest{Context: context, Response: make(chan core.Backend), Err: make(chan error), Exclude: exclude, Selected: selected})
+}
+
+/**
+ * Returns backends that can be elected for context, except exclude ones.
+ * Election is not made, so selecting one of them is up to caller
+ */
+func (this *Scheduler) Candidates(context core.Context, exclude []core.Target) []core.Backend {
+
+	r := ElectRequest{Context: context, Exclude: exclude, Candidates: make(chan []core.Backend, 1)}
+
+	atomic.AddInt32(&this.pendingElects, 1)
 	this.elect <- r
+	atomic.AddInt32(&this.pendingElects, -1)
+
+	return <-r.Candidates
+}
+
+/**
+ * Send elect request and wait for elected backend
+ */
+func (this *Scheduler) take(r ElectRequest) (*core.Backend, error) {
+	atomic.AddInt32(&this.pendingElects, 1)
+	this.elect <- r
+	atomic.AddInt32(&this.pendingElects, -1)
 	select {
 	case err := <-r.Err:
 		return nil, err
@@ -331,37 +921,70 @@ func (this *Scheduler) TakeBackend(context core.Context) (*core.Backend, error)
 	}
 }
 
+/**
+ * Send operation to scheduler goroutine
+ */
+func (this *Scheduler) op(op Op) {
+	atomic.AddInt32(&this.pendingOps, 1)
+	select {
+	case this.ops <- op:
+	case <-this.done:
+	}
+	atomic.AddInt32(&this.pendingOps, -1)
+}
+
+/**
+ * Increment count of retries to connect to the next backend
+ */
+func (this *Scheduler) IncrementDialRetries() {
+	this.op(Op{core.Target{}, IncrementDialRetries, nil})
+}
+
 /**
  * Increment connection refused count for backend
  */
 func (this *Scheduler) IncrementRefused(backend core.Backend) {
-	this.ops <- Op{backend.Target, IncrementRefused, nil}
+	this.op(Op{backend.Target, IncrementRefused, nil})
 }
 
 /**
  * Increment backend connection counter
  */
 func (this *Scheduler) IncrementConnection(backend core.Backend) {
-	this.ops <- Op{backend.Target, IncrementConnection, nil}
+	this.op(Op{backend.Target, IncrementConnection, nil})
 }
 
 /**
  * Decrement backends connection counter
  */
 func (this *Scheduler) DecrementConnection(backend core.Backend) {
-	this.ops <- Op{backend.Target, DecrementConnection, nil}
+	this.op(Op{backend.Target, DecrementConnection, nil})
+}
+
+/**
+ * Increment backends ended connections counter of reason
+ */
+func (this *Scheduler) IncrementDisconnect(backend core.Backend, reason string) {
+	this.op(Op{backend.Target, IncrementDisconnect, reason})
+}
+
+/**
+ * Report outcome of connection proxied to backend for passive healthcheck
+ */
+func (this *Scheduler) ReportPassive(backend core.Backend, ok bool) {
+	this.Healthcheck.ReportPassive(backend.Target, ok)
 }
 
 /**
  * Increment Rx stats for backend
  */
 func (this *Scheduler) IncrementRx(backend core.Backend, c uint) {
-	this.ops <- Op{backend.Target, IncrementRx, c}
+	this.op(Op{backend.Target, IncrementRx, c})
 }
 
 /**
  * Increment Tx stats for backends
  */
 func (this *Scheduler) IncrementTx(backend core.Backend, c uint) {
-	this.ops <- Op{backend.Target, IncrementTx, c}
+	this.op(Op{backend.Target, IncrementTx, c})
 }
@@ -0,0 +1,351 @@
+/**
+ * sticktable.go - client ip to backend stick table
+ */
+
+package scheduler
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"../../cluster"
+	"../../config"
+	"../../core"
+	"../../utils"
+)
+
+/**
+ * Stick table entry
+ */
+type StickEntry struct {
+
+	/* Client ip */
+	Client string `json:"client"`
+
+	/* Backend client sticks to */
+	Target core.Target `json:"target"`
+
+	/* Labels of backend at the time client stuck to it */
+	Labels map[string]string `json:"labels,omitempty"`
+
+	/* Time entry expires unless client connects again */
+	Expires time.Time `json:"expires"`
+}
+
+/**
+ * Remembers backend every client ip was proxied to, so reconnecting
+ * client is proxied to the same backend regardless of balance.
+ * Is safe for concurrent use
+ */
+type StickTable struct {
+	sync.Mutex
+
+	/* Entry expiration time since last client connection */
+	ttl time.Duration
+
+	/* Max entries count, 0 means unlimited */
+	maxEntries int
+
+	/* File entries are persisted to, empty to disable */
+	persistPath string
+
+	/* Interval of persisting entries */
+	persistInterval time.Duration
+
+	/* Entries by client ip */
+	entries map[string]*list.Element
+
+	/* Entries ordered from most to least recently used */
+	lru *list.List
+
+	/* Name table is shared with in cluster, empty if not shared */
+	name string
+}
+
+/**
+ * Creates stick table for config, nil config means no table
+ */
+func NewStickTable(cfg *config.StickyConfig) *StickTable {
+
+	if cfg == nil {
+		return nil
+	}
+
+	return &StickTable{
+		ttl:             utils.ParseDurationOrDefault(cfg.Ttl, 0),
+		maxEntries:      cfg.MaxEntries,
+		persistPath:     cfg.PersistPath,
+		persistInterval: utils.ParseDurationOrDefault(cfg.PersistInterval, 0),
+		entries:         make(map[string]*list.Element),
+		lru:             list.New(),
+	}
+}
+
+/**
+ * Returns target client sticks to, or nil
+ */
+func (this *StickTable) Get(client string, now time.Time) *core.Target {
+
+	this.Lock()
+	defer this.Unlock()
+
+	el, ok := this.entries[client]
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*StickEntry)
+	if now.After(entry.Expires) {
+		this.remove(el)
+		return nil
+	}
+
+	target := entry.Target
+	return &target
+}
+
+/**
+ * Sticks client to backend for ttl, evicting least
+ * recently used entry if table is full
+ */
+func (this *StickTable) Put(client string, backend core.Backend, now time.Time) {
+
+	entry := StickEntry{client, backend.Target, backend.Labels, now.Add(this.ttl)}
+
+	this.Lock()
+	this.put(entry)
+	name := this.name
+	this.Unlock()
+
+	if name != "" {
+		cluster.Global.ReportStick(name, cluster.StickEntry(entry))
+	}
+}
+
+/**
+ * Returns current entries, most recently used first
+ */
+func (this *StickTable) Entries() []StickEntry {
+
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+	result := []StickEntry{}
+
+	for el := this.lru.Front(); el != nil; el = el.Next() {
+		if entry := el.Value.(*StickEntry); !now.After(entry.Expires) {
+			result = append(result, *entry)
+		}
+	}
+
+	return result
+}
+
+/**
+ * Removes entry of client, or all entries if client is empty
+ */
+func (this *StickTable) Flush(client string) {
+
+	this.Lock()
+	this.flush(client)
+	name := this.name
+	this.Unlock()
+
+	if name != "" {
+		cluster.Global.ReportStickFlush(name, client)
+	}
+}
+
+/**
+ * Shares table with tables of the same name of cluster peers
+ */
+func (this *StickTable) Share(name string) {
+
+	this.Lock()
+	this.name = name
+	this.Unlock()
+
+	cluster.Global.AddStickTable(name, this)
+}
+
+/**
+ * Stops sharing table in cluster
+ */
+func (this *StickTable) Unshare() {
+
+	this.Lock()
+	name := this.name
+	this.name = ""
+	this.Unlock()
+
+	if name != "" {
+		cluster.Global.RemoveStickTable(name, this)
+	}
+}
+
+/**
+ * Returns current entries, sent to peers joining cluster
+ */
+func (this *StickTable) SharedEntries() []cluster.StickEntry {
+
+	entries := this.Entries()
+
+	result := make([]cluster.StickEntry, len(entries))
+	for i, e := range entries {
+		result[i] = cluster.StickEntry(e)
+	}
+
+	return result
+}
+
+/**
+ * Applies flushes and entries of cluster peer. Entry is taken
+ * if client has no entry or it expires earlier, i.e. peer proxied client later
+ */
+func (this *StickTable) MergeShared(entries []cluster.StickEntry, flushes []string) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	for _, client := range flushes {
+		this.flush(client)
+	}
+
+	now := time.Now()
+
+	for _, e := range entries {
+		if now.After(e.Expires) {
+			continue
+		}
+
+		if el, ok := this.entries[e.Client]; ok && !el.Value.(*StickEntry).Expires.Before(e.Expires) {
+			continue
+		}
+
+		this.put(StickEntry(e))
+	}
+}
+
+/**
+ * Removes expired entries
+ */
+func (this *StickTable) Expire(now time.Time) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	for el := this.lru.Front(); el != nil; {
+		next := el.Next()
+		if now.After(el.Value.(*StickEntry).Expires) {
+			this.remove(el)
+		}
+		el = next
+	}
+}
+
+/**
+ * Loads entries from persist file, if any. Missing file is not an error
+ */
+func (this *StickTable) Load() error {
+
+	if this.persistPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(this.persistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := []StickEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	now := time.Now()
+
+	// Entries are persisted most recently used first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !now.After(entries[i].Expires) {
+			this.put(entries[i])
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Writes entries to persist file, if enabled. File is replaced
+ * atomically, so it's never left partially written
+ */
+func (this *StickTable) Save() error {
+
+	if this.persistPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(this.Entries())
+	if err != nil {
+		return err
+	}
+
+	tmp := this.persistPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, this.persistPath)
+}
+
+/**
+ * Adds or updates entry, should be called with lock held
+ */
+func (this *StickTable) put(entry StickEntry) {
+
+	if el, ok := this.entries[entry.Client]; ok {
+		*el.Value.(*StickEntry) = entry
+		this.lru.MoveToFront(el)
+		return
+	}
+
+	if this.maxEntries > 0 && this.lru.Len() >= this.maxEntries {
+		this.remove(this.lru.Back())
+	}
+
+	this.entries[entry.Client] = this.lru.PushFront(&entry)
+}
+
+/**
+ * Removes entry of client, or all entries if client is empty, should be called with lock held
+ */
+func (this *StickTable) flush(client string) {
+
+	if client == "" {
+		this.entries = make(map[string]*list.Element)
+		this.lru.Init()
+		return
+	}
+
+	if el, ok := this.entries[client]; ok {
+		this.remove(el)
+	}
+}
+
+/**
+ * Removes entry, should be called with lock held
+ */
+func (this *StickTable) remove(el *list.Element) {
+	this.lru.Remove(el)
+	delete(this.entries, el.Value.(*StickEntry).Client)
+}
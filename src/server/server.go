@@ -10,6 +10,8 @@ package server
 import (
 	"../config"
 	"../core"
+	"./http"
+	"./quic"
 	"./tcp"
 	"./udp"
 	"errors"
@@ -22,8 +24,12 @@ func New(name string, cfg config.Server) (core.Server, error) {
 	switch cfg.Protocol {
 	case "tls", "tcp":
 		return tcp.New(name, cfg)
-	case "udp":
+	case "udp", "dtls":
 		return udp.New(name, cfg)
+	case "http":
+		return http.New(name, cfg)
+	case "quic":
+		return quic.New(name, cfg)
 	default:
 		return nil, errors.New("Can't create server for protocol " + cfg.Protocol)
 	}
@@ -0,0 +1,97 @@
+/**
+ * canary.go - weighted split of connections to canary backends pool
+ */
+
+package tcp
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+
+	"../../config"
+	"../../stats"
+	"../scheduler"
+)
+
+/**
+ * Canary backends pool, taking weight percent of server connections
+ */
+type canary struct {
+
+	/* Scheduler of canary backends pool */
+	scheduler *scheduler.Scheduler
+
+	/* Stats handler of canary backends pool */
+	statsHandler *stats.Handler
+
+	/* Percent of connections taken by canary, updatable in runtime */
+	weight int32
+}
+
+/**
+ * Creates canary pool for server canary config, if any.
+ * Canary pool has stats named "<server>:canary"
+ */
+func newCanary(name string, cfg config.Server) *canary {
+
+	if cfg.Canary == nil {
+		return nil
+	}
+
+	statsHandler := stats.NewHandler(name + ":canary")
+
+	return &canary{
+		statsHandler: statsHandler,
+		weight:       int32(cfg.Canary.Weight),
+		scheduler:    scheduler.NewPool(cfg, statsHandler, cfg.Canary.Balance, cfg.Canary.Discovery, cfg.Canary.Healthcheck),
+	}
+}
+
+/**
+ * Starts canary pool scheduler and stats
+ */
+func (this *canary) Start() {
+	this.statsHandler.Start()
+	this.scheduler.Start()
+}
+
+/**
+ * Stops canary pool scheduler and stats
+ */
+func (this *canary) Stop() {
+	this.scheduler.Stop()
+	this.statsHandler.Stop()
+}
+
+/**
+ * Decides if new connection goes to canary pool
+ */
+func (this *canary) takes() bool {
+	return rand.Int31n(100) < atomic.LoadInt32(&this.weight)
+}
+
+/**
+ * Returns current percent of connections taken by canary
+ */
+func (this *canary) Weight() int {
+	return int(atomic.LoadInt32(&this.weight))
+}
+
+/**
+ * Changes percent of new connections taken by canary without restart
+ */
+func (this *Server) UpdateCanary(weight int) error {
+
+	if this.canary == nil {
+		return errors.New("Server has no canary")
+	}
+
+	if weight < 0 || weight > 100 {
+		return errors.New("canary weight should be in 0..100")
+	}
+
+	atomic.StoreInt32(&this.canary.weight, int32(weight))
+
+	return nil
+}
@@ -0,0 +1,353 @@
+/**
+ * clients.go - registry of current client connections
+ */
+
+package tcp
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../../core"
+)
+
+const (
+	/* Number of registry shards, each locked separately */
+	CLIENTS_SHARDS = 64
+)
+
+/**
+ * Shard of client connections
+ */
+type clientsShard struct {
+	sync.Mutex
+	conns map[net.Conn]*connection
+}
+
+/**
+ * Client connection with its proxying progress
+ */
+type connection struct {
+	sync.Mutex
+
+	/* Id unique within registry */
+	id uint64
+
+	/* Client connection */
+	conn net.Conn
+
+	/* Sni hostname requested by client */
+	sni string
+
+	/* Time connection was accepted */
+	started time.Time
+
+	/* Backend address, empty until connected */
+	backend string
+
+	/* Received from backend and transmitted to backend bytes */
+	rx, tx uint64
+
+	/* Bytes / second of the last counted rx and tx, and times they were counted */
+	rxSecond, txSecond uint
+	rxAt, txAt         time.Time
+
+	/* Connection was closed with kill */
+	killed bool
+}
+
+/**
+ * Registry of current client connections, sharded by client address
+ * so connects and disconnects are not serialized. Connections are keyed
+ * by themselves, so clients with the same address do not collide
+ */
+type clients struct {
+
+	/* Current connections count, updated atomically */
+	count int64
+
+	/* Last connection id, updated atomically */
+	lastId uint64
+
+	/* 1 if registry is closed and new connections are rejected */
+	closed int32
+
+	/* 1 if current connections were closed by closeAll */
+	dropped int32
+
+	/* Closed when registry is closed and has no connections */
+	drained chan bool
+	once    sync.Once
+
+	shards [CLIENTS_SHARDS]clientsShard
+}
+
+/**
+ * Creates new empty registry
+ */
+func newClients() *clients {
+
+	c := &clients{
+		drained: make(chan bool),
+	}
+
+	for i := range c.shards {
+		c.shards[i].conns = make(map[net.Conn]*connection)
+	}
+
+	return c
+}
+
+/**
+ * Returns shard of connection
+ */
+func (this *clients) shard(conn net.Conn) *clientsShard {
+	h := fnv.New32a()
+	h.Write([]byte(conn.RemoteAddr().String()))
+	return &this.shards[h.Sum32()%CLIENTS_SHARDS]
+}
+
+/**
+ * Adds connection if registry is not closed. Returns
+ * connection to track its proxying with, or nil
+ */
+func (this *clients) add(ctx *core.TcpContext) *connection {
+
+	if this.isClosed() {
+		return nil
+	}
+
+	atomic.AddInt64(&this.count, 1)
+
+	now := time.Now()
+
+	c := &connection{
+		id:      atomic.AddUint64(&this.lastId, 1),
+		conn:    ctx.Conn,
+		sni:     ctx.Hostname,
+		started: now,
+		rxAt:    now,
+		txAt:    now,
+	}
+
+	s := this.shard(ctx.Conn)
+	s.Lock()
+	s.conns[ctx.Conn] = c
+	s.Unlock()
+
+	// Registry may be closed meanwhile
+	if this.isClosed() {
+		this.remove(ctx.Conn)
+		return nil
+	}
+
+	return c
+}
+
+/**
+ * Checks if registry is closed
+ */
+func (this *clients) isClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+/**
+ * Removes connection
+ */
+func (this *clients) remove(conn net.Conn) {
+
+	s := this.shard(conn)
+	s.Lock()
+	_, ok := s.conns[conn]
+	delete(s.conns, conn)
+	s.Unlock()
+
+	if ok {
+		this.release()
+	}
+}
+
+/**
+ * Decrements count, signaling drained if closed registry became empty
+ */
+func (this *clients) release() {
+	if atomic.AddInt64(&this.count, -1) == 0 && this.isClosed() {
+		this.once.Do(func() { close(this.drained) })
+	}
+}
+
+/**
+ * Returns current connections count
+ */
+func (this *clients) Count() uint {
+	return uint(atomic.LoadInt64(&this.count))
+}
+
+/**
+ * Rejects new connections. Returned channel is closed when
+ * all current connections are removed
+ */
+func (this *clients) close() <-chan bool {
+
+	atomic.StoreInt32(&this.closed, 1)
+
+	if atomic.LoadInt64(&this.count) == 0 {
+		this.once.Do(func() { close(this.drained) })
+	}
+
+	return this.drained
+}
+
+/**
+ * Returns current connections
+ */
+func (this *clients) list() []core.Connection {
+
+	now := time.Now()
+	result := []core.Connection{}
+
+	for i := range this.shards {
+		s := &this.shards[i]
+		s.Lock()
+		for _, c := range s.conns {
+			result = append(result, c.info(now))
+		}
+		s.Unlock()
+	}
+
+	return result
+}
+
+/**
+ * Closes connection with id, returns false if there is no such one
+ */
+func (this *clients) kill(id uint64) bool {
+
+	for i := range this.shards {
+		s := &this.shards[i]
+		s.Lock()
+		for conn, c := range s.conns {
+			if c.id == id {
+				s.Unlock()
+				c.Lock()
+				c.killed = true
+				c.Unlock()
+				conn.Close()
+				return true
+			}
+		}
+		s.Unlock()
+	}
+
+	return false
+}
+
+/**
+ * Checks if connections were closed by closeAll
+ */
+func (this *clients) isDropped() bool {
+	return atomic.LoadInt32(&this.dropped) == 1
+}
+
+/**
+ * Closes all current connections
+ */
+func (this *clients) closeAll() {
+
+	atomic.StoreInt32(&this.dropped, 1)
+
+	for i := range this.shards {
+		s := &this.shards[i]
+		s.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.Unlock()
+	}
+}
+
+/**
+ * Sets backend connection is proxied to
+ */
+func (this *connection) setBackend(address string) {
+	this.Lock()
+	this.backend = address
+	this.Unlock()
+}
+
+/**
+ * Checks if connection was closed with kill
+ */
+func (this *connection) isKilled() bool {
+	this.Lock()
+	defer this.Unlock()
+	return this.killed
+}
+
+/**
+ * Counts proxied bytes, rate is bytes counted divided by time since previous count
+ */
+func (this *connection) count(rx uint, tx uint, now time.Time) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if rx > 0 {
+		this.rx += uint64(rx)
+		this.rxSecond = perSecond(rx, now.Sub(this.rxAt))
+		this.rxAt = now
+	}
+
+	if tx > 0 {
+		this.tx += uint64(tx)
+		this.txSecond = perSecond(tx, now.Sub(this.txAt))
+		this.txAt = now
+	}
+}
+
+/**
+ * Returns connection info. Rate of direction not counted
+ * for more than two stats push intervals is 0
+ */
+func (this *connection) info(now time.Time) core.Connection {
+
+	this.Lock()
+	defer this.Unlock()
+
+	info := core.Connection{
+		Id:      this.id,
+		Client:  this.conn.RemoteAddr().String(),
+		Sni:     this.sni,
+		Backend: this.backend,
+		Started: this.started,
+		Age:     now.Sub(this.started).Seconds(),
+		Rx:      this.rx,
+		Tx:      this.tx,
+	}
+
+	if now.Sub(this.rxAt) <= 2*PROXY_STATS_PUSH_INTERVAL {
+		info.RxSecond = this.rxSecond
+	}
+
+	if now.Sub(this.txAt) <= 2*PROXY_STATS_PUSH_INTERVAL {
+		info.TxSecond = this.txSecond
+	}
+
+	return info
+}
+
+/**
+ * Returns bytes / second of n bytes proxied within elapsed,
+ * which is at least stats push interval
+ */
+func perSecond(n uint, elapsed time.Duration) uint {
+
+	if elapsed < PROXY_STATS_PUSH_INTERVAL {
+		elapsed = PROXY_STATS_PUSH_INTERVAL
+	}
+
+	return uint(float64(n) / elapsed.Seconds())
+}
@@ -0,0 +1,289 @@
+/**
+ * mux.go - detecting client protocol and matching payload rules by first bytes it sends, for mux routes
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"regexp"
+	"time"
+
+	"../../config"
+)
+
+/**
+ * Default max first bytes read to detect protocol and match rules
+ */
+const MuxDefaultReadSize = 64
+
+/**
+ * Protocols detected by mux
+ */
+var MuxProtocols = []string{"tls", "ssh", "http", "h2c", "socks5"}
+
+/**
+ * Methods starting http/1 requests
+ */
+var httpMethods = []string{"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH "}
+
+/**
+ * Rule of mux route, matching detected protocol, payload prefix or payload regexp
+ */
+type muxRule struct {
+
+	/* Route name */
+	name string
+
+	/* Detected protocol, if route routes protocol */
+	protocol string
+
+	/* First bytes prefix, if route routes prefix or hex_prefix */
+	prefix []byte
+
+	/* Regexp of first bytes, if route routes regexp */
+	regexp *regexp.Regexp
+}
+
+/**
+ * Creates rules of mux routes, in routes order
+ */
+func newMuxRules(cfg *config.Mux) ([]*muxRule, error) {
+
+	rules := []*muxRule{}
+
+	if cfg == nil {
+		return rules, nil
+	}
+
+	for _, routeCfg := range cfg.Routes {
+
+		rule := &muxRule{
+			name:     routeCfg.Name,
+			protocol: routeCfg.Protocol,
+		}
+
+		switch {
+		case routeCfg.Prefix != "":
+			rule.prefix = []byte(routeCfg.Prefix)
+
+		case routeCfg.HexPrefix != "":
+			prefix, err := hex.DecodeString(routeCfg.HexPrefix)
+			if err != nil {
+				return nil, err
+			}
+			rule.prefix = prefix
+
+		case routeCfg.Regexp != "":
+			re, err := regexp.Compile(routeCfg.Regexp)
+			if err != nil {
+				return nil, err
+			}
+			rule.regexp = re
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+/**
+ * Checks if rule matches first bytes and detected protocol. Returns false
+ * if more bytes are needed to know it, unless no more bytes will be read
+ */
+func (this *muxRule) match(data []byte, protocol string, detected bool, final bool) (bool, bool) {
+
+	switch {
+	case this.prefix != nil:
+		match, complete := prefixMatch(data, this.prefix)
+		return match && complete, complete || !match || final
+
+	case this.regexp != nil:
+		// Regexp is matched against bytes read so far, so it can't be known not to match until the end
+		if this.regexp.Match(data) {
+			return true, true
+		}
+		return false, final
+
+	default:
+		return detected && protocol == this.protocol, detected || final
+	}
+}
+
+/**
+ * Connection reading sniffed bytes first
+ */
+type sniffedConn struct {
+	data   []byte
+	reader io.Reader
+	net.Conn
+}
+
+func newSniffedConn(conn net.Conn, data []byte) *sniffedConn {
+	return &sniffedConn{data, io.MultiReader(bytes.NewReader(data), conn), conn}
+}
+
+func (this *sniffedConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+/**
+ * Reads up to readSize first bytes of connection until protocol is detected and the first
+ * matching rule is known, or readTimeout fires. Returns connection reading these bytes again,
+ * detected protocol and name of matched rule. Protocol is empty if client sent nothing within
+ * timeout (i.e. protocol where server speaks first) or it's unknown, rule is empty if none matched.
+ * Bytes client sent before closing its side are matched as if the timeout fired
+ */
+func sniffMux(conn net.Conn, readTimeout time.Duration, readSize int, rules []*muxRule) (*sniffedConn, string, string, error) {
+
+	buf := make([]byte, readSize)
+	n := 0
+	protocol := ""
+	matched := ""
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+	for {
+		read, err := conn.Read(buf[n:])
+		n += read
+
+		final := n == len(buf)
+		if err != nil {
+			// Client that sent first bytes and closed writing won't send more
+			netErr, ok := err.(net.Error)
+			if !(ok && netErr.Timeout()) && !(err == io.EOF && n > 0) {
+				return nil, "", "", err
+			}
+			final = true
+		}
+
+		var detected, decided bool
+		protocol, detected = detectProtocol(buf[:n])
+		matched, decided = matchMuxRules(rules, buf[:n], protocol, detected, final)
+
+		if final || (detected && decided) {
+			break
+		}
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	return newSniffedConn(conn, buf[:n]), protocol, matched, nil
+}
+
+/**
+ * Reads up to readSize first bytes of connection, until readTimeout fires
+ * or client closes its side. Returns connection reading these bytes again
+ */
+func peekFirstBytes(conn net.Conn, readTimeout time.Duration, readSize int) (*sniffedConn, error) {
+
+	buf := make([]byte, readSize)
+	n := 0
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+
+		if err != nil {
+			if netErr, ok := err.(net.Error); (ok && netErr.Timeout()) || (err == io.EOF && n > 0) {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	return newSniffedConn(conn, buf[:n]), nil
+}
+
+/**
+ * Returns name of the first rule matching first bytes, empty if none matches.
+ * Returns false if more bytes are needed to know it
+ */
+func matchMuxRules(rules []*muxRule, data []byte, protocol string, detected bool, final bool) (string, bool) {
+
+	for _, rule := range rules {
+		match, complete := rule.match(data, protocol, detected, final)
+		if !complete {
+			return "", false
+		}
+		if match {
+			return rule.name, true
+		}
+	}
+
+	return "", true
+}
+
+/**
+ * Detects protocol of first bytes of connection. Returns false if more bytes
+ * are needed to know it, and empty protocol if it's not any known one
+ */
+func detectProtocol(data []byte) (string, bool) {
+
+	candidates := 0
+
+	/* Tls handshake record header, i.e. 16 03 01 */
+	match, complete := prefixMatch(data, []byte{0x16, 0x03})
+	if match && complete {
+		return "tls", true
+	} else if match {
+		candidates++
+	}
+
+	/* Ssh identification string, sent by client without waiting for server one */
+	if match, complete = prefixMatch(data, []byte("SSH-")); match && complete {
+		return "ssh", true
+	} else if match {
+		candidates++
+	}
+
+	/* Http/2 prior knowledge connection preface, it's checked before http/1 methods */
+	if match, complete = prefixMatch(data, []byte("PRI * HTTP/2.0")); match && complete {
+		return "h2c", true
+	} else if match {
+		candidates++
+	}
+
+	for _, method := range httpMethods {
+		if match, complete = prefixMatch(data, []byte(method)); match && complete {
+			return "http", true
+		} else if match {
+			candidates++
+		}
+	}
+
+	/* Socks5 greeting: version 5, methods count and that many methods */
+	if len(data) >= 1 && data[0] == 0x05 {
+		if len(data) < 2 {
+			candidates++
+		} else if methods := int(data[1]); methods > 0 {
+			if len(data) == 2+methods {
+				return "socks5", true
+			} else if len(data) < 2+methods {
+				candidates++
+			}
+		}
+	}
+
+	return "", candidates == 0
+}
+
+/**
+ * Checks if data starts with prefix, or, while it's shorter, with its beginning
+ */
+func prefixMatch(data []byte, prefix []byte) (bool, bool) {
+
+	if len(data) < len(prefix) {
+		return bytes.HasPrefix(prefix, data), false
+	}
+
+	return bytes.HasPrefix(data, prefix), true
+}
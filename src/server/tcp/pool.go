@@ -0,0 +1,302 @@
+/**
+ * pool.go - idle backend connections pool
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"../../config"
+	"../../utils"
+)
+
+/**
+ * Read error of idle connection that received data
+ */
+var errUnexpectedData = errors.New("Unexpected data on idle backend connection")
+
+/**
+ * Idle backend connection, watched by goroutine blocked
+ * reading it until connection is taken, closed or expired
+ */
+type idleConn struct {
+	conn net.Conn
+
+	/* Time idle timeout expires, zero if never */
+	expires time.Time
+
+	/* Read error of watching goroutine, after connection is taken */
+	done chan error
+}
+
+/**
+ * Pool keeps backend connections of finished client sessions open,
+ * per backend address, to reuse them for new client sessions
+ */
+type backendPool struct {
+	sync.Mutex
+
+	/* Max idle connections per backend */
+	maxIdle int
+
+	/* Idle connections are closed after timeout, 0 means never */
+	idleTimeout time.Duration
+
+	/* Time backend should send nothing after session before connection is idle */
+	drainTimeout time.Duration
+
+	/* Idle connections per backend address, most recent last */
+	idle map[string][]*idleConn
+
+	/* Pool is closed, connections are not kept anymore */
+	closed bool
+}
+
+/**
+ * Creates new backend pool
+ */
+func newBackendPool(cfg config.BackendPoolConfig) *backendPool {
+	return &backendPool{
+		maxIdle:      cfg.MaxIdle,
+		idleTimeout:  utils.ParseDurationOrDefault(cfg.IdleTimeout, 0),
+		drainTimeout: utils.ParseDurationOrDefault(cfg.DrainTimeout, 0),
+		idle:         make(map[string][]*idleConn),
+	}
+}
+
+/**
+ * Takes idle connection to backend, or returns nil if none
+ */
+func (this *backendPool) get(address string) net.Conn {
+
+	for {
+		this.Lock()
+		conns := this.idle[address]
+		if len(conns) == 0 {
+			this.Unlock()
+			return nil
+		}
+
+		ic := conns[len(conns)-1]
+		this.idle[address] = conns[:len(conns)-1]
+		this.Unlock()
+
+		// Interrupt watching read. Connection is alive only if read timed out because of it,
+		// not because idle timeout expired before connection was taken
+		ic.conn.SetReadDeadline(time.Now())
+		err := <-ic.done
+
+		expired := !ic.expires.IsZero() && !time.Now().Before(ic.expires)
+
+		if e, ok := err.(net.Error); ok && e.Timeout() && !expired {
+			ic.conn.SetReadDeadline(time.Time{})
+			return ic.conn
+		}
+
+		// Backend closed connection, sent unexpected data or idle timeout expired
+		ic.conn.Close()
+	}
+}
+
+/**
+ * Puts connection to pool, closing it if pool is full
+ */
+func (this *backendPool) put(address string, conn net.Conn) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed || len(this.idle[address]) >= this.maxIdle {
+		conn.Close()
+		return
+	}
+
+	ic := &idleConn{
+		conn: conn,
+		done: make(chan error, 1),
+	}
+
+	if this.idleTimeout > 0 {
+		ic.expires = time.Now().Add(this.idleTimeout)
+	}
+
+	this.idle[address] = append(this.idle[address], ic)
+
+	go this.watch(address, ic)
+}
+
+/**
+ * Blocks reading idle connection. If read returns while connection is still in pool,
+ * backend closed it, sent data or idle timeout expired, so it's removed and closed
+ */
+func (this *backendPool) watch(address string, ic *idleConn) {
+
+	ic.conn.SetReadDeadline(ic.expires)
+
+	_, err := ic.conn.Read(make([]byte, 1))
+	if err == nil {
+		err = errUnexpectedData
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	conns := this.idle[address]
+	for i, c := range conns {
+		if c == ic {
+			this.idle[address] = append(conns[:i], conns[i+1:]...)
+			if len(this.idle[address]) == 0 {
+				delete(this.idle, address)
+			}
+			ic.conn.Close()
+			return
+		}
+	}
+
+	// Taken from pool
+	ic.done <- err
+}
+
+/**
+ * Returns connection of finished client session to pool if it's reusable and backend
+ * stays silent for drain timeout, so nothing of the session is left to the next one.
+ * Closes it otherwise. Blocks for drain timeout
+ */
+func (this *backendPool) release(address string, conn *pooledConn, reusable bool) {
+
+	if !reusable {
+		conn.Conn.Close()
+		return
+	}
+
+	conn.Conn.SetReadDeadline(time.Now().Add(this.drainTimeout))
+
+	n, err := conn.Conn.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); n > 0 || !ok || !e.Timeout() {
+		// Backend is still answering the session or closed connection
+		conn.Conn.Close()
+		return
+	}
+
+	conn.Conn.SetReadDeadline(time.Time{})
+	this.put(address, conn.Conn)
+}
+
+/**
+ * Closes all idle connections
+ */
+func (this *backendPool) Close() {
+
+	this.Lock()
+	defer this.Unlock()
+
+	for _, conns := range this.idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+	}
+
+	this.idle = make(map[string][]*idleConn)
+	this.closed = true
+}
+
+/**
+ * Backend connection of client session that may be returned to pool.
+ * Close only interrupts pending read, so proxying stops without closing connection,
+ * and further read deadlines are ignored, so proxy can't postpone it
+ */
+type pooledConn struct {
+	net.Conn
+
+	mutex  sync.Mutex
+	closed bool
+
+	/* Times backend sent data last time and client data was sent to it last time */
+	lastRead  time.Time
+	lastWrite time.Time
+}
+
+/**
+ * Read data backend sent, remembering time of it
+ */
+func (this *pooledConn) Read(b []byte) (int, error) {
+
+	n, err := this.Conn.Read(b)
+
+	if n > 0 {
+		this.mutex.Lock()
+		this.lastRead = time.Now()
+		this.mutex.Unlock()
+	}
+
+	return n, err
+}
+
+/**
+ * Write client data to backend, remembering time of it
+ */
+func (this *pooledConn) Write(b []byte) (int, error) {
+
+	this.mutex.Lock()
+	this.lastWrite = time.Now()
+	this.mutex.Unlock()
+
+	return this.Conn.Write(b)
+}
+
+/**
+ * Interrupt pending and further reads
+ */
+func (this *pooledConn) Close() error {
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.closed {
+		this.closed = true
+		this.Conn.SetReadDeadline(time.Now())
+	}
+
+	return nil
+}
+
+/**
+ * Set read deadline, ignored after close
+ */
+func (this *pooledConn) SetReadDeadline(t time.Time) error {
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	return this.Conn.SetReadDeadline(t)
+}
+
+/**
+ * Checks if backend sent data after the last client data was sent to it
+ */
+func (this *pooledConn) answered() bool {
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return !this.lastRead.Before(this.lastWrite)
+}
+
+/**
+ * Checks if read was interrupted by close
+ */
+func (this *pooledConn) interrupted() bool {
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.closed
+}
@@ -9,14 +9,18 @@ package tcp
 import (
 	"../../core"
 	"../../logging"
+	"../modules/throttle"
 	"io"
 	"net"
+	"os"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const (
 
-	/* Buffer size to handle data from socket */
+	/* Default buffer size to handle data from socket */
 	BUFFER_SIZE = 16 * 1024
 
 	/* Interval of pushing aggregated read/write stats */
@@ -24,15 +28,55 @@ const (
 )
 
 /**
- * Perform copy/proxy data from 'from' to 'to' socket, counting r/w stats and
- * dropping connection if timeout exceeded
+ * Writer supporting write deadlines, i.e. net.Conn
  */
-func proxy(to net.Conn, from net.Conn, timeout time.Duration) <-chan core.ReadWriteCount {
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+/**
+ * Pools of copy buffers by size, as servers may have different buffer_size
+ */
+var buffers = struct {
+	sync.Mutex
+	pools map[int]*sync.Pool
+}{pools: make(map[int]*sync.Pool)}
+
+/**
+ * Returns pool of buffers of size
+ */
+func buffersPool(size int) *sync.Pool {
+
+	buffers.Lock()
+	defer buffers.Unlock()
+
+	pool, ok := buffers.pools[size]
+	if !ok {
+		pool = &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		}
+		buffers.pools[size] = pool
+	}
+
+	return pool
+}
+
+/**
+ * Perform copy/proxy data from 'from' to 'to' socket, counting r/w stats,
+ * throttling bandwidth (if stream is not nil) and dropping connection if 'from' sends
+ * nothing for timeout or write to 'to' is blocked for more than writeTimeout.
+ * Error copying stopped with (nil if none) is delivered to the second channel
+ */
+func proxy(to net.Conn, from net.Conn, timeout time.Duration, writeTimeout time.Duration, stream *throttle.Stream, bufferSize int) (<-chan core.ReadWriteCount, <-chan error) {
 
 	log := logging.For("proxy")
 
 	stats := make(chan core.ReadWriteCount)
 	outStats := make(chan core.ReadWriteCount)
+	errs := make(chan error, 1)
 
 	rwcBuffer := core.ReadWriteCount{}
 	ticker := time.NewTicker(PROXY_STATS_PUSH_INTERVAL)
@@ -48,7 +92,8 @@ func proxy(to net.Conn, from net.Conn, timeout time.Duration) <-chan core.ReadWr
 		for {
 			select {
 			case <-ticker.C:
-				if !rwcBuffer.IsZero() {
+				// Buffer is kept after flush, so it's not pushed twice
+				if !flushed && !rwcBuffer.IsZero() {
 					outStats <- rwcBuffer
 				}
 				flushed = true
@@ -82,29 +127,105 @@ func proxy(to net.Conn, from net.Conn, timeout time.Duration) <-chan core.ReadWr
 
 	// Run proxy copier
 	go func() {
-		err := Copy(to, from, stats)
+		err := Copy(to, from, stats, stream, bufferSize, writeTimeout)
 		// hack to determine normal close. TODO: fix when it will be exposed in golang
 		e, ok := err.(*net.OpError)
-		if err != nil && (!ok || e.Err.Error() != "use of closed network connection") {
+
+		// read of pooled backend connection is interrupted on purpose
+		interrupted := false
+		if p, pooled := from.(*pooledConn); pooled {
+			interrupted = p.interrupted()
+		}
+
+		if err != nil && !interrupted && (!ok || e.Err.Error() != "use of closed network connection") {
 			log.Warn(err)
 		}
 
 		to.Close()
 		from.Close()
 
+		errs <- err
+
 		// Stop stats collecting goroutine
 		close(stats)
 	}()
 
-	return outStats
+	return outStats, errs
+}
+
+/**
+ * Returns reason proxying ended with, based on errors copying from backend
+ * and from client. Side having no error is the one closed connection first,
+ * the other side was closed by proxy
+ */
+func disconnectReason(backendErr error, clientErr error, backendReset bool) string {
+
+	if backendReset {
+		return "backend_reset"
+	}
+
+	for _, err := range []error{backendErr, clientErr} {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			if oe, ok := err.(*net.OpError); ok && oe.Op == "write" {
+				return "write_timeout"
+			}
+			return "idle_timeout"
+		}
+	}
+
+	for _, err := range []error{backendErr, clientErr} {
+		e, ok := err.(*net.OpError)
+		if err != nil && (!ok || e.Err.Error() != "use of closed network connection") {
+			return "error"
+		}
+	}
+
+	if clientErr == nil {
+		return "client_closed"
+	}
+
+	return "backend_closed"
+}
+
+/**
+ * Checks if err is connection reset or broken pipe during 'op' ("read" | "write")
+ */
+func isReset(err error, op string) bool {
+
+	e, ok := err.(*net.OpError)
+	if !ok || e.Op != op {
+		return false
+	}
+
+	se, ok := e.Err.(*os.SyscallError)
+	return ok && (se.Err == syscall.ECONNRESET || se.Err == syscall.EPIPE)
 }
 
 /**
- * It's build by analogy of io.Copy
+ * It's build by analogy of io.Copy, using pooled buffer of bufferSize and waiting
+ * for throttle stream (if not nil) before each write. Each write fails if it's
+ * blocked for more than writeTimeout (0 means no limit) and 'to' supports deadlines.
+ * Not throttled plain tcp connections are spliced on linux
  */
-func Copy(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount) error {
+func Copy(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount, stream *throttle.Stream, bufferSize int, writeTimeout time.Duration) error {
+
+	if stream == nil {
+		if spliced, err := splice(to, from, ch, writeTimeout); spliced {
+			return err
+		}
+	}
 
-	buf := make([]byte, BUFFER_SIZE)
+	deadliner, _ := to.(writeDeadliner)
+
+	if bufferSize <= 0 {
+		bufferSize = BUFFER_SIZE
+	}
+
+	pool := buffersPool(bufferSize)
+	b := pool.Get().(*[]byte)
+	defer pool.Put(b)
+
+	buf := *b
 	var err error = nil
 
 	for {
@@ -112,6 +233,12 @@ func Copy(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount) error {
 
 		if readN > 0 {
 
+			stream.Wait(readN)
+
+			if writeTimeout > 0 && deadliner != nil {
+				deadliner.SetWriteDeadline(time.Now().Add(writeTimeout))
+			}
+
 			writeN, writeErr := to.Write(buf[0:readN])
 
 			if writeN > 0 {
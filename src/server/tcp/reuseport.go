@@ -0,0 +1,37 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+/**
+ * reuseport.go - listening with SO_REUSEPORT
+ */
+
+package tcp
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+/**
+ * Listen tcp with SO_REUSEPORT set, so several listeners
+ * may be bound to the same address, kernel balancing connections between them
+ */
+func listenReusePort(bind string) (net.Listener, error) {
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	return lc.Listen(context.Background(), "tcp", bind)
+}
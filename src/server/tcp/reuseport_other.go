@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+/**
+ * reuseport_other.go - SO_REUSEPORT is not available on this platform
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+/**
+ * SO_REUSEPORT is not supported on this platform
+ */
+func listenReusePort(bind string) (net.Listener, error) {
+	return nil, errors.New("reuse_port is not supported on this platform")
+}
@@ -0,0 +1,142 @@
+/**
+ * routes.go - sni hostname, alpn protocol and client first bytes routing to separate backends pools
+ */
+
+package tcp
+
+import (
+	"regexp"
+	"strings"
+
+	"../../config"
+	"../../stats"
+	"../scheduler"
+)
+
+/**
+ * Route of sni hostname and / or negotiated alpn protocol, or of
+ * client first bytes matched by mux rule, to its own backends pool
+ */
+type route struct {
+
+	/* Hostname pattern, empty matches any hostname */
+	hostname string
+
+	/* Negotiated alpn protocol, empty matches any protocol */
+	alpn string
+
+	/* Name of mux rule matched by client first bytes, empty if route is not mux one */
+	mux string
+
+	/* Compiled hostname pattern for regexp matching strategy */
+	hostnameRegexp *regexp.Regexp
+
+	/* Scheduler of route backends pool */
+	scheduler *scheduler.Scheduler
+
+	/* Stats handler of route backends pool */
+	statsHandler *stats.Handler
+}
+
+/**
+ * Creates routes for server sni and mux configs, sni ones go first.
+ * Every sni route has stats named "<server>/<hostname>", or
+ * "<server>/<hostname>@<alpn>" if it routes alpn protocol,
+ * and every mux route "<server>/mux:<name>"
+ */
+func newRoutes(name string, cfg config.Server) ([]*route, error) {
+
+	routes := []*route{}
+
+	if sniCfg := cfg.Sni; sniCfg != nil {
+		for _, routeCfg := range sniCfg.Routes {
+
+			r := &route{
+				hostname: routeCfg.Hostname,
+				alpn:     routeCfg.Alpn,
+			}
+
+			if r.hostname != "" && sniCfg.HostnameMatchingStrategy == "regexp" {
+				re, err := regexp.Compile(routeCfg.Hostname)
+				if err != nil {
+					return nil, err
+				}
+				r.hostnameRegexp = re
+			}
+
+			statsName := name + "/" + routeCfg.Hostname
+			if routeCfg.Alpn != "" {
+				statsName += "@" + routeCfg.Alpn
+			}
+
+			r.statsHandler = stats.NewHandler(statsName)
+			r.scheduler = scheduler.NewPool(cfg, r.statsHandler, routeCfg.Balance, routeCfg.Discovery, routeCfg.Healthcheck)
+
+			routes = append(routes, r)
+		}
+	}
+
+	if cfg.Mux != nil {
+		for _, routeCfg := range cfg.Mux.Routes {
+
+			r := &route{
+				mux:          routeCfg.Name,
+				statsHandler: stats.NewHandler(name + "/mux:" + routeCfg.Name),
+			}
+
+			r.scheduler = scheduler.NewPool(cfg, r.statsHandler, routeCfg.Balance, routeCfg.Discovery, routeCfg.Healthcheck)
+
+			routes = append(routes, r)
+		}
+	}
+
+	return routes, nil
+}
+
+/**
+ * Checks if route matches sni hostname, negotiated alpn protocol and matched mux rule
+ */
+func (this *route) matches(hostname string, alpn string, mux string) bool {
+
+	if this.mux != "" {
+		return this.mux == mux
+	}
+
+	if this.alpn != "" && this.alpn != alpn {
+		return false
+	}
+
+	if this.hostname == "" {
+		return true
+	}
+
+	if hostname == "" {
+		return false
+	}
+
+	if this.hostnameRegexp != nil {
+		return this.hostnameRegexp.MatchString(hostname)
+	}
+
+	return strings.ToLower(this.hostname) == strings.ToLower(hostname)
+}
+
+/**
+ * Returns scheduler of the first route matching hostname, alpn protocol and
+ * matched mux rule, or, if there is no match, canary scheduler for
+ * canary share of connections and server scheduler for the rest
+ */
+func (this *Server) schedulerFor(hostname string, alpn string, mux string) *scheduler.Scheduler {
+
+	for _, r := range this.routes {
+		if r.matches(hostname, alpn, mux) {
+			return r.scheduler
+		}
+	}
+
+	if this.canary != nil && this.canary.takes() {
+		return this.canary.scheduler
+	}
+
+	return &this.scheduler
+}
@@ -8,9 +8,9 @@ package tcp
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"io/ioutil"
+	"errors"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"../../balance"
@@ -20,10 +20,23 @@ import (
 	"../../healthcheck"
 	"../../logging"
 	"../../stats"
+	"../../tracing"
 	"../../utils"
+	"../../utils/backendbind"
+	"../../utils/proxyprotocol"
+	"../../utils/systemd"
 	tlsutil "../../utils/tls"
 	"../../utils/tls/sni"
+	"../../utils/unixsocket"
+	"../../utils/upgrade"
+	"../../utils/upstreamproxy"
 	"../modules/access"
+	"../modules/accesslog"
+	"../modules/connlimit"
+	"../modules/ratelimit"
+	"../modules/script"
+	"../modules/tarpit"
+	"../modules/throttle"
 	"../scheduler"
 )
 
@@ -36,8 +49,8 @@ type Server struct {
 	/* Server friendly name */
 	name string
 
-	/* Listener */
-	listener net.Listener
+	/* Listeners, several if reuse_port is enabled */
+	listeners []net.Listener
 
 	/* Configuration */
 	cfg config.Server
@@ -45,30 +58,73 @@ type Server struct {
 	/* Scheduler deals with discovery, balancing and healthchecks */
 	scheduler scheduler.Scheduler
 
-	/* Current clients connection */
-	clients map[string]net.Conn
+	/* Sni routes to separate backends pools */
+	routes []*route
+
+	/* Rules of mux routes, matched by client first bytes */
+	muxRules []*muxRule
+
+	/* Shadow backends pool client traffic is mirrored to, if enabled */
+	shadow *shadow
+
+	/* Canary backends pool taking part of connections, if enabled */
+	canary *canary
+
+	/* Current clients connections */
+	clients *clients
 
 	/* Stats handler */
 	statsHandler *stats.Handler
 
 	/* ----- channels ----- */
 
-	/* Channel for new connections */
-	connect chan (*core.TcpContext)
+	/* Stop channel, accepts drain timeout */
+	stop chan time.Duration
 
-	/* Channel for dropping connections or connectons to drop */
-	disconnect chan (net.Conn)
+	/* Closed when server is stopped and listener is released */
+	stopped chan bool
 
-	/* Stop channel */
-	stop chan bool
+	/* Closed when server starts stopping, so queued connections give up */
+	stopping chan bool
 
 	/* Tls config used to connect to backends */
 	backendsTlsConfg *tls.Config
 
+	/* Reloadable tls certificates for protocol = "tls" */
+	certificates *tlsutil.Certificates
+
+	/* Idle backend connections to reuse, if enabled */
+	backendPool *backendPool
+
+	/* Dialer of backends from backend_bind source ips and interface */
+	backendDialer *backendbind.Dialer
+
+	/* Dialer of backends through upstream proxy, if configured */
+	upstreamProxy *upstreamproxy.Dialer
+
 	/* ----- modules ----- */
 
 	/* Access module checks if client is allowed to connect */
 	access *access.Access
+
+	/* Rate limit module checks if client does not connect too often */
+	rateLimit *ratelimit.RateLimit
+
+	/* Access log module writes record per proxied connection */
+	accessLog *accesslog.AccessLog
+
+	/* Throttle module limits rx/tx bandwidth */
+	throttle *throttle.Throttle
+
+	/* Connections limits module rejects connections over max_connections_per_client
+	 * and queues ones over server and process-wide max_connections */
+	limits *connlimit.Limiters
+
+	/* Tarpit module holds denied and rate limited connections, if enabled */
+	tarpit *tarpit.Tarpit
+
+	/* Script module runs lua hooks of connections, if enabled */
+	script *script.Script
 }
 
 /**
@@ -81,24 +137,53 @@ func New(name string, cfg config.Server) (*Server, error) {
 	var err error = nil
 	statsHandler := stats.NewHandler(name)
 
+	stopping := make(chan bool)
+
 	// Create server
 	server := &Server{
 		name:         name,
 		cfg:          cfg,
-		stop:         make(chan bool),
-		disconnect:   make(chan net.Conn),
-		connect:      make(chan *core.TcpContext),
-		clients:      make(map[string]net.Conn),
+		stop:         make(chan time.Duration),
+		stopped:      make(chan bool),
+		stopping:     stopping,
+		clients:      newClients(),
+		limits:       connlimit.NewLimiters(name, *cfg.MaxConnections, cfg.QueueSize, *cfg.MaxConnectionsPerClient, utils.ParseDurationOrDefault(cfg.QueueTimeout, 0), stopping),
 		statsHandler: statsHandler,
 		scheduler: scheduler.Scheduler{
-			Balancer:     balance.New(cfg.Sni, cfg.Balance),
-			Discovery:    discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
-			Healthcheck:  healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
-			StatsHandler: statsHandler,
+			Balancer:       balance.New(cfg.Sni, cfg.Balance),
+			Discovery:      discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
+			Healthcheck:    healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
+			StatsHandler:   statsHandler,
+			SlowStart:      utils.ParseDurationOrDefault(cfg.SlowStart, 0),
+			CircuitBreaker: cfg.CircuitBreaker,
+			StickTable:     scheduler.NewStickTable(cfg.Sticky),
+			Failover:       cfg.Failover,
+			Geoip:          cfg.Geoip,
+			LabelFilter:    cfg.BackendLabels,
 		},
 	}
 
-	/* Add access if needed */
+	statsHandler.Clients = server.clients
+
+	/* Add sni routes if needed */
+	server.routes, err = newRoutes(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	server.muxRules, err = newMuxRules(cfg.Mux)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Add shadow pool if needed */
+	server.shadow = newShadow(name, cfg)
+
+	/* Add canary pool if needed */
+	server.canary = newCanary(name, cfg)
+
+	/* Add access, allowing everything if not configured, so it could be updated in runtime */
+	server.access = access.NewAllowAll()
 	if cfg.Access != nil {
 		server.access, err = access.NewAccess(cfg.Access)
 		if err != nil {
@@ -106,10 +191,61 @@ func New(name string, cfg config.Server) (*Server, error) {
 		}
 	}
 
+	/* Add rate limit if needed */
+	if cfg.RateLimit != nil {
+		server.rateLimit, err = ratelimit.NewRateLimit(cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add tarpit if needed */
+	if cfg.Tarpit != nil {
+		server.tarpit = tarpit.New(cfg.Tarpit)
+	}
+
+	/* Add access log if needed */
+	if cfg.AccessLog != nil {
+		server.accessLog, err = accesslog.NewAccessLog(cfg.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add script if needed */
+	if cfg.Script != nil {
+		server.script, err = script.New(name, cfg.Script)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Add throttle if needed */
+	if cfg.Throttle != nil {
+		server.throttle = throttle.NewThrottle(*cfg.Throttle)
+		statsHandler.Throttle = server.throttle
+	}
+
+	/* Add backend connections pool if needed */
+	if cfg.BackendPool != nil {
+		server.backendPool = newBackendPool(*cfg.BackendPool)
+	}
+
+	server.backendDialer, err = backendbind.New(cfg.BackendBind, cfg.HappyEyeballs, utils.ParseDurationOrDefault(*cfg.BackendConnectionTimeout, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	/* Add upstream proxy if needed */
+	if cfg.UpstreamProxy != nil {
+		server.upstreamProxy = upstreamproxy.New(cfg.UpstreamProxy, server.backendDialer)
+	}
+
 	/* Add backend tls config if needed */
 	if cfg.BackendsTls != nil {
-		server.backendsTlsConfg, err = prepareBackendsTlsConfig(cfg)
+		server.backendsTlsConfg, err = tlsutil.NewBackendsConfig(cfg.BackendsTls)
 		if err != nil {
+			log.Error(err)
 			return nil, err
 		}
 	}
@@ -123,7 +259,118 @@ func New(name string, cfg config.Server) (*Server, error) {
  * Returns current server configuration
  */
 func (this *Server) Cfg() config.Server {
-	return this.cfg
+	cfg := this.cfg
+	cfg.Access = this.access.Config()
+	if this.canary != nil {
+		canary := *cfg.Canary
+		canary.Weight = this.canary.Weight()
+		cfg.Canary = &canary
+	}
+	return cfg
+}
+
+/**
+ * Replace access rules without restart
+ */
+func (this *Server) UpdateAccess(cfg *config.AccessConfig) error {
+	return this.access.Update(cfg)
+}
+
+/**
+ * Returns healthcheck history of server backends
+ */
+func (this *Server) HealthcheckHistory() []healthcheck.TargetHistory {
+	return this.scheduler.HealthcheckHistory()
+}
+
+/**
+ * Returns current client connections
+ */
+func (this *Server) Connections() []core.Connection {
+	return this.clients.list()
+}
+
+/**
+ * Returns current connections counts of client ips
+ */
+func (this *Server) ClientConnections() []connlimit.ClientCount {
+	return this.limits.ClientCounts()
+}
+
+/**
+ * Closes client connection with id
+ */
+func (this *Server) KillConnection(id uint64) error {
+
+	if !this.clients.kill(id) {
+		return errors.New("Connection not found")
+	}
+
+	return nil
+}
+
+/**
+ * Returns health of server discovery
+ */
+func (this *Server) DiscoveryHealth() discovery.Health {
+	return this.scheduler.Discovery.Health()
+}
+
+/**
+ * Returns client ip to backend stick table, nil if disabled
+ */
+func (this *Server) StickTable() *scheduler.StickTable {
+	return this.scheduler.StickTable
+}
+
+/**
+ * Returns schedulers of all server backends pools: main, routes, shadow and canary ones
+ */
+func (this *Server) Schedulers() []*scheduler.Scheduler {
+
+	schedulers := []*scheduler.Scheduler{&this.scheduler}
+	for _, r := range this.routes {
+		schedulers = append(schedulers, r.scheduler)
+	}
+	if this.shadow != nil {
+		schedulers = append(schedulers, this.shadow.scheduler)
+	}
+	if this.canary != nil {
+		schedulers = append(schedulers, this.canary.scheduler)
+	}
+
+	return schedulers
+}
+
+/**
+ * Returns current connections and connections queued over max_connections
+ */
+func (this *Server) ConnectionsQueue() (int, int) {
+	return this.limits.Count()
+}
+
+/**
+ * Drains backend (drained = true) or enables it back in every
+ * backends pool of server having it: main, routes, shadow and canary ones
+ */
+func (this *Server) SetBackendDrained(target core.Target, drained bool) error {
+
+	var result error
+	found := false
+
+	for _, s := range this.Schedulers() {
+		if err := s.SetDrained(target, drained); err != nil {
+			result = err
+			continue
+		}
+		found = true
+	}
+
+	if found {
+		return nil
+	}
+
+	return result
 }
 
 /**
@@ -133,27 +380,47 @@ func (this *Server) Start() error {
 
 	go func() {
 
-		for {
-			select {
-			case client := <-this.disconnect:
-				this.HandleClientDisconnect(client)
-
-			case ctx := <-this.connect:
-				this.HandleClientConnect(ctx)
-
-			case <-this.stop:
-				this.scheduler.Stop()
-				this.statsHandler.Stop()
-				if this.listener != nil {
-					this.listener.Close()
-					for _, conn := range this.clients {
-						conn.Close()
-					}
-				}
-				this.clients = make(map[string]net.Conn)
-				return
+		timeout := <-this.stop
+
+		close(this.stopping)
+		drained := this.clients.close()
+		if len(this.listeners) > 0 {
+			for _, l := range this.listeners {
+				l.Close()
 			}
+			this.drain(timeout, drained)
+		}
+		this.clients.closeAll()
+
+		this.scheduler.Stop()
+		this.statsHandler.Stop()
+		this.access.Stop()
+		if this.rateLimit != nil {
+			this.rateLimit.Unshare()
+		}
+		if this.tarpit != nil {
+			this.tarpit.Stop()
+		}
+		if this.accessLog != nil {
+			this.accessLog.Close()
 		}
+		if this.script != nil {
+			this.script.Stop()
+		}
+		if this.backendPool != nil {
+			this.backendPool.Close()
+		}
+		for _, r := range this.routes {
+			r.scheduler.Stop()
+			r.statsHandler.Stop()
+		}
+		if this.shadow != nil {
+			this.shadow.Stop()
+		}
+		if this.canary != nil {
+			this.canary.Stop()
+		}
+		close(this.stopped)
 	}()
 
 	// Start stats handler
@@ -162,6 +429,27 @@ func (this *Server) Start() error {
 	// Start scheduler
 	this.scheduler.Start()
 
+	// Share rate limit in cluster
+	if this.rateLimit != nil {
+		this.rateLimit.Share(this.name)
+	}
+
+	// Start sni routes
+	for _, r := range this.routes {
+		r.statsHandler.Start()
+		r.scheduler.Start()
+	}
+
+	// Start shadow pool
+	if this.shadow != nil {
+		this.shadow.Start()
+	}
+
+	// Start canary pool
+	if this.canary != nil {
+		this.canary.Start()
+	}
+
 	// Start listening
 	if err := this.Listen(); err != nil {
 		this.Stop()
@@ -175,41 +463,160 @@ func (this *Server) Start() error {
  * Handle client disconnection
  */
 func (this *Server) HandleClientDisconnect(client net.Conn) {
-	client.Close()
-	delete(this.clients, client.RemoteAddr().String())
-	this.statsHandler.Connections <- uint(len(this.clients))
+	if this.tarpit == nil || !this.tarpit.Holds(client) {
+		client.Close()
+	}
+	this.clients.remove(client)
+	this.limits.Release(core.AddrIp(client.RemoteAddr()))
 }
 
 /**
- * Handle new client connection
+ * Handle new client connection, session span is ended when connection is handled
  */
-func (this *Server) HandleClientConnect(ctx *core.TcpContext) {
+func (this *Server) HandleClientConnect(ctx *core.TcpContext, span *tracing.Span) {
 	client := ctx.Conn
 	log := logging.For("server")
 
-	if *this.cfg.MaxConnections != 0 && len(this.clients) >= *this.cfg.MaxConnections {
-		log.Warn("Too many connections to ", this.cfg.Bind)
+	/* Rate limited clients are rejected before taking connection slots and being registered */
+	if this.rateLimit != nil && !this.rateLimit.Allows(ctx.Ip(), time.Now()) {
+		log.Debug("Client exceeded connections rate limit ", client.RemoteAddr())
+		this.statsHandler.Disconnected("rate_limited")
+		span.SetAttribute("gobetween.reason", "rate_limited")
+		span.End()
+		this.reject(client)
+		return
+	}
+
+	if err := this.limits.Acquire(ctx.Ip()); err != nil {
+		if err != connlimit.ErrStopping {
+			this.statsHandler.Disconnected(connlimit.Reason(err))
+		}
+		span.SetError(err.Error())
+		span.End()
+		client.Close()
+		return
+	}
+
+	conn := this.clients.add(ctx)
+	if conn == nil {
+		this.limits.Release(ctx.Ip())
+		span.End()
 		client.Close()
 		return
 	}
 
-	this.clients[client.RemoteAddr().String()] = client
-	this.statsHandler.Connections <- uint(len(this.clients))
 	go func() {
-		this.handle(ctx)
-		this.disconnect <- client
+		this.handle(ctx, conn, span)
+		this.HandleClientDisconnect(client)
 	}()
 }
 
 /**
- * Stop, dropping all connections
+ * Closes denied or rate limited client connection, or hands it to tarpit if enabled
+ */
+func (this *Server) reject(client net.Conn) {
+
+	if this.tarpit != nil {
+		this.tarpit.Hold(client)
+		return
+	}
+
+	client.Close()
+}
+
+/**
+ * Finishes session span with access log record of it
+ */
+func endSpan(span *tracing.Span, record accesslog.Record) {
+
+	if span == nil {
+		return
+	}
+
+	span.SetAttribute("gobetween.reason", record.Reason)
+	span.SetAttribute("gobetween.rx_bytes", record.Rx)
+	span.SetAttribute("gobetween.tx_bytes", record.Tx)
+
+	if record.Sni != "" {
+		span.SetAttribute("tls.server_name", record.Sni)
+	}
+
+	if record.Alpn != "" {
+		span.SetAttribute("tls.alpn", record.Alpn)
+	}
+
+	if record.Backend != "" {
+		span.SetAttribute("gobetween.backend", record.Backend)
+	}
+
+	for key, value := range record.Tags {
+		span.SetAttribute("gobetween.tag."+key, value)
+	}
+
+	switch record.Reason {
+	case "tls_handshake_failed", "no_backend", "dial_failed", "backend_reset", "error":
+		span.SetError(record.Reason)
+	}
+
+	span.End()
+}
+
+/**
+ * Wait until active connections are finished (drained is closed), up to timeout.
+ * Listeners and clients registry should be already closed
+ */
+func (this *Server) drain(timeout time.Duration, drained <-chan bool) {
+
+	log := logging.For("server.drain")
+
+	if timeout <= 0 || this.clients.Count() == 0 {
+		return
+	}
+
+	log.Info("Draining ", this.clients.Count(), " connections of ", this.name, " up to ", timeout)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		log.Info("Drained ", this.name)
+	case <-timer.C:
+		log.Warn("Drain timeout, dropping ", this.clients.Count(), " connections of ", this.name)
+	}
+}
+
+/**
+ * Reload tls certificate from files
+ */
+func (this *Server) ReloadTls() error {
+
+	if this.certificates == nil {
+		return errors.New("Server has no tls certificate")
+	}
+
+	return this.certificates.Reload()
+}
+
+/**
+ * Stop, draining connections up to drain_timeout
  */
 func (this *Server) Stop() {
+	this.Drain(utils.ParseDurationOrDefault(*this.cfg.DrainTimeout, 0))
+}
+
+/**
+ * Stop accepting new connections and wait until active
+ * ones are finished up to timeout, then drop the rest.
+ * Zero timeout drops all connections immediately
+ */
+func (this *Server) Drain(timeout time.Duration) {
 
 	log := logging.For("server.Listen")
 	log.Info("Stopping ", this.name)
 
-	this.stop <- true
+	this.stop <- timeout
+	<-this.stopped
 }
 
 func (this *Server) wrap(conn net.Conn, sniEnabled bool, tlsConfig *tls.Config) {
@@ -218,16 +625,99 @@ func (this *Server) wrap(conn net.Conn, sniEnabled bool, tlsConfig *tls.Config)
 	var hostname string
 	var err error
 
+	/* Session span, from accept till close */
+	span := tracing.Global.Start("session", tracing.KindServer, "")
+	span.SetAttribute("gobetween.server", this.name)
+	span.SetAddress("server", conn.LocalAddr().String())
+
+	if this.cfg.ProxyProtocol != nil && this.cfg.ProxyProtocol.Accept {
+		proxyConn, err := proxyprotocol.Accept(conn, utils.ParseDurationOrDefault(this.cfg.ProxyProtocol.ReadTimeout, time.Second*2))
+
+		if err != nil {
+			log.Error("Failed to read / parse PROXY protocol header: ", err)
+			span.SetError("Failed to read PROXY protocol header: " + err.Error())
+			span.End()
+			conn.Close()
+			return
+		}
+
+		conn = proxyConn
+	}
+
+	span.SetAddress("client", conn.RemoteAddr().String())
+
+	var protocol, muxRoute string
+	var firstBytes []byte
+	if this.cfg.Mux != nil {
+		sniff := span.Child("protocol sniff", tracing.KindInternal)
+
+		readSize := this.cfg.Mux.ReadSize
+		if readSize <= 0 {
+			readSize = MuxDefaultReadSize
+		}
+
+		var sniffed *sniffedConn
+		sniffed, protocol, muxRoute, err = sniffMux(conn, utils.ParseDurationOrDefault(this.cfg.Mux.ReadTimeout, time.Second*2), readSize, this.muxRules)
+
+		if err != nil {
+			log.Debug("Failed to read first bytes of ", conn.RemoteAddr(), " to detect protocol: ", err)
+			sniff.SetError(err.Error())
+			sniff.End()
+			span.SetError("Failed to detect protocol: " + err.Error())
+			span.End()
+			conn.Close()
+			return
+		}
+
+		sniff.SetAttribute("gobetween.protocol", protocol)
+		sniff.SetAttribute("gobetween.mux_route", muxRoute)
+		sniff.End()
+
+		conn = sniffed
+		firstBytes = sniffed.data
+
+		// Only tls clients have sni, others may send nothing for it to read
+		sniEnabled = sniEnabled && protocol == "tls"
+
+	} else if this.script != nil && this.cfg.Script.ReadSize > 0 {
+		peek := span.Child("first bytes read", tracing.KindInternal)
+
+		sniffed, err := peekFirstBytes(conn, utils.ParseDurationOrDefault(this.cfg.Script.ReadTimeout, time.Second*2), this.cfg.Script.ReadSize)
+		if err != nil {
+			log.Debug("Failed to read first bytes of ", conn.RemoteAddr(), " for script: ", err)
+			peek.SetError(err.Error())
+			peek.End()
+			span.SetError("Failed to read first bytes: " + err.Error())
+			span.End()
+			conn.Close()
+			return
+		}
+
+		peek.End()
+
+		conn = sniffed
+		firstBytes = sniffed.data
+	}
+
 	if sniEnabled {
+		sniff := span.Child("sni sniff", tracing.KindInternal)
+
 		var sniConn net.Conn
 		sniConn, hostname, err = sni.Sniff(conn, utils.ParseDurationOrDefault(this.cfg.Sni.ReadTimeout, time.Second*2))
 
 		if err != nil {
 			log.Error("Failed to get / parse ClientHello for sni: ", err)
+			sniff.SetError(err.Error())
+			sniff.End()
+			span.SetError("Failed to get sni: " + err.Error())
+			span.End()
 			conn.Close()
 			return
 		}
 
+		sniff.SetAttribute("tls.server_name", hostname)
+		sniff.End()
+
 		conn = sniConn
 	}
 
@@ -235,10 +725,13 @@ func (this *Server) wrap(conn net.Conn, sniEnabled bool, tlsConfig *tls.Config)
 		conn = tls.Server(conn, tlsConfig)
 	}
 
-	this.connect <- &core.TcpContext{
-		hostname,
-		conn,
-	}
+	this.HandleClientConnect(&core.TcpContext{
+		Hostname:   hostname,
+		Conn:       conn,
+		Protocol:   protocol,
+		MuxRoute:   muxRoute,
+		FirstBytes: firstBytes,
+	}, span)
 
 }
 
@@ -249,8 +742,22 @@ func (this *Server) Listen() (err error) {
 
 	log := logging.For("server.Listen")
 
-	// create tcp listener
-	this.listener, err = net.Listen("tcp", this.cfg.Bind)
+	for _, bind := range this.cfg.Bind {
+		var listeners []net.Listener
+		if listeners, err = this.listenBind(bind); err != nil {
+			break
+		}
+		this.listeners = append(this.listeners, listeners...)
+	}
+
+	if err != nil {
+		log.Error("Error starting ", this.cfg.Protocol+" server: ", err)
+		for _, l := range this.listeners {
+			l.Close()
+		}
+		this.listeners = nil
+		return err
+	}
 
 	var tlsConfig *tls.Config
 	sniEnabled := this.cfg.Sni != nil
@@ -258,153 +765,425 @@ func (this *Server) Listen() (err error) {
 	if this.cfg.Protocol == "tls" {
 
 		// Create tls listener
-		var crt tls.Certificate
-		if crt, err = tls.LoadX509KeyPair(this.cfg.Tls.CertPath, this.cfg.Tls.KeyPath); err != nil {
+		if this.certificates, err = tlsutil.NewCertificates(this.cfg.Tls); err != nil {
 			log.Error(err)
 			return err
 		}
 
-		tlsConfig = &tls.Config{
-			Certificates:             []tls.Certificate{crt},
-			CipherSuites:             tlsutil.MapCiphers(this.cfg.Tls.Ciphers),
-			PreferServerCipherSuites: this.cfg.Tls.PreferServerCiphers,
-			MinVersion:               tlsutil.MapVersion(this.cfg.Tls.MinVersion),
-			MaxVersion:               tlsutil.MapVersion(this.cfg.Tls.MaxVersion),
-			SessionTicketsDisabled:   !this.cfg.Tls.SessionTickets,
+		if interval := utils.ParseDurationOrDefault(this.cfg.Tls.ReloadInterval, 0); interval > 0 {
+			this.certificates.Watch(interval, this.stopped)
+		}
+
+		if tlsConfig, err = tlsutil.NewServerConfig(this.cfg.Tls, this.certificates); err != nil {
+			log.Error(err)
+			return err
 		}
 	}
 
-	if err != nil {
-		log.Error("Error starting ", this.cfg.Protocol+" server: ", err)
-		return err
+	for _, l := range this.listeners {
+		go func(listener net.Listener) {
+			for {
+				conn, err := listener.Accept()
+
+				if err != nil {
+					log.Error(err)
+					return
+				}
+
+				if err := setSocketOptions(conn, this.cfg.ClientSocket); err != nil {
+					log.Warn("Can't set client socket options: ", err)
+				}
+
+				go this.wrap(conn, sniEnabled, tlsConfig)
+			}
+		}(l)
 	}
 
-	go func() {
-		for {
-			conn, err := this.listener.Accept()
+	return nil
+}
+
+/**
+ * Creates tcp or unix socket listeners of bind, one per accepting goroutine, taking ones passed by old process on upgrade
+ */
+func (this *Server) listenBind(bind string) (listeners []net.Listener, err error) {
 
-			if err != nil {
-				log.Error(err)
-				return
+	if unixsocket.IsSocketBind(bind) {
+		var l net.Listener
+		if l, err = unixsocket.Listen(bind, this.cfg.UnixSocket); err == nil {
+			listeners = append(listeners, l)
+		}
+	} else if inherited := upgrade.Listeners(bind); len(inherited) > 0 {
+		listeners = inherited
+	} else if systemd.IsSocketBind(bind) {
+		listeners, err = systemd.Listeners(bind)
+	} else if this.cfg.ReusePort {
+		for i := 0; i < this.cfg.Listeners && err == nil; i++ {
+			var l net.Listener
+			if l, err = listenReusePort(bind); err == nil {
+				listeners = append(listeners, l)
 			}
+		}
+	} else {
+		var l net.Listener
+		if l, err = net.Listen("tcp", bind); err == nil {
+			listeners = append(listeners, l)
+		}
+	}
 
-			go this.wrap(conn, sniEnabled, tlsConfig)
+	if err != nil {
+		for _, l := range listeners {
+			l.Close()
 		}
-	}()
+		return nil, err
+	}
 
-	return nil
+	for _, l := range listeners {
+		upgrade.RegisterListener(bind, l)
+	}
+
+	return listeners, nil
 }
 
 /**
  * Handle incoming connection and prox it to backend
  */
-func (this *Server) handle(ctx *core.TcpContext) {
+func (this *Server) handle(ctx *core.TcpContext, tracked *connection, span *tracing.Span) {
 	clientConn := ctx.Conn
 	log := logging.For("server.handle")
 
-	/* Check access if needed */
-	if this.access != nil {
-		if !this.access.Allows(&clientConn.RemoteAddr().(*net.TCPAddr).IP) {
-			log.Debug("Client disallowed to connect ", clientConn.RemoteAddr())
+	/* Write access log record when connection ends, if needed */
+	record := accesslog.Record{
+		Time:   time.Now(),
+		Server: this.name,
+		Client: clientConn.RemoteAddr().String(),
+		Sni:    ctx.Hostname,
+	}
+
+	if this.accessLog != nil || this.script != nil {
+		defer func() {
+			record.Duration = time.Since(record.Time)
+
+			/* Script on_close hook may tag connection too */
+			if this.script != nil {
+				this.script.OnClose(ctx, record)
+				record.Tags = ctx.Tags
+			}
+
+			if this.accessLog != nil {
+				this.accessLog.Log(record)
+			}
+		}()
+	}
+
+	/* Count connection by reason it ended with and finish its span */
+	defer func() {
+		this.statsHandler.Disconnected(record.Reason)
+		record.Tags = ctx.Tags
+		endSpan(span, record)
+	}()
+
+	/* Complete tls handshake, so client certificate and negotiated alpn protocol are known */
+	clientIp := core.AddrIp(clientConn.RemoteAddr())
+	accessClient := access.Client{
+		Ip:  &clientIp,
+		Sni: ctx.Hostname,
+	}
+
+	var identity string
+	var alpn string
+	if tlsConn, ok := clientConn.(*tls.Conn); ok {
+
+		if timeout := utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0); timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+
+		handshake := span.Child("tls handshake", tracing.KindInternal)
+
+		if err := tlsConn.Handshake(); err != nil {
+			log.Debug("Client ", clientConn.RemoteAddr(), " tls handshake failed: ", err)
+			handshake.SetError(err.Error())
+			handshake.End()
 			clientConn.Close()
+			record.Reason = "tls_handshake_failed"
 			return
 		}
+
+		handshake.End()
+
+		tlsConn.SetDeadline(time.Time{})
+
+		state := tlsConn.ConnectionState()
+		if this.cfg.Tls.ClientAuth != nil {
+			identity = tlsutil.ClientIdentity(state)
+			accessClient.Identity = identity
+			accessClient.Certificate = tlsutil.ClientCertificate(state)
+		}
+		if accessClient.Sni == "" {
+			accessClient.Sni = state.ServerName
+		}
+		alpn = state.NegotiatedProtocol
+		record.Alpn = alpn
+		ctx.Alpn = alpn
 	}
 
-	log.Debug("Accepted ", clientConn.RemoteAddr(), " -> ", this.listener.Addr())
+	/* Check access if needed */
+	if this.access != nil {
+		if !this.access.AllowsConnection(accessClient) {
+			log.Debug("Client disallowed to connect ", clientConn.RemoteAddr(), " ", identity)
+			this.reject(clientConn)
+			record.Reason = "access_denied"
+			return
+		}
+	}
 
-	/* Find out backend for proxying */
-	var err error
-	backend, err := this.scheduler.TakeBackend(ctx)
-	if err != nil {
-		log.Error(err, " Closing connection ", clientConn.RemoteAddr())
-		return
+	/* Run script accept hook if needed */
+	if this.script != nil {
+		if !this.script.OnAccept(ctx) {
+			log.Debug("Script rejected connection ", clientConn.RemoteAddr(), " ", identity)
+			this.reject(clientConn)
+			record.Reason = "script_rejected"
+			return
+		}
 	}
 
-	/* Connect to backend */
+	log.Debug("Accepted ", clientConn.RemoteAddr(), " ", identity, " -> ", clientConn.LocalAddr())
+
+	/* Find out backends pool for proxying */
+	pool := this.schedulerFor(ctx.Hostname, alpn, ctx.MuxRoute)
+
+	/* Elect backend and connect to it, retrying next backends on failure */
+	var backend *core.Backend
 	var backendConn net.Conn
+	var tried []core.Target
 
-	if this.cfg.BackendsTls != nil {
-		backendConn, err = tls.DialWithDialer(&net.Dialer{
-			Timeout: utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0),
-		}, "tcp", backend.Address(), this.backendsTlsConfg)
+	for {
+		var err error
+		selecting := span.Child("backend select", tracing.KindInternal)
 
-	} else {
-		backendConn, err = net.DialTimeout("tcp", backend.Address(), utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0))
-	}
+		/* Script select_backend hook chooses among candidates before balancer, out of scheduler */
+		var selected *core.Target
+		if this.script != nil && this.script.SelectsBackend() {
+			selected = this.script.SelectBackend(ctx, pool.Candidates(ctx, tried))
+		}
 
-	if err != nil {
-		this.scheduler.IncrementRefused(*backend)
+		backend, err = pool.TakeBackendSelected(ctx, tried, selected)
+		if err != nil {
+			selecting.SetError(err.Error())
+			selecting.End()
+			log.Error(err, " Closing connection ", clientConn.RemoteAddr())
+			record.Reason = "no_backend"
+			if len(tried) > 0 {
+				record.Reason = "dial_failed"
+			}
+			return
+		}
+
+		selecting.SetAttribute("gobetween.backend", backend.Address())
+		selecting.End()
+
+		record.Backend = backend.Address()
+		record.BackendLabels = backend.Labels
+		tracked.setBackend(record.Backend)
+
+		dialing := span.Child("backend dial", tracing.KindClient)
+		dialing.SetAddress("server", backend.Address())
+
+		backendConn, err = this.connectBackend(clientConn, backend)
+		if err == nil {
+			/* Local address of backend connection is client address seen by backend */
+			dialing.SetAddress("network.local", backendConn.LocalAddr().String())
+			dialing.End()
+			break
+		}
+
+		dialing.SetError(err.Error())
+		dialing.End()
+
+		pool.IncrementRefused(*backend)
+		pool.ReportPassive(*backend, false)
 		log.Error(err)
-		return
+
+		tried = append(tried, backend.Target)
+		if len(tried) > *this.cfg.MaxDialRetries {
+			record.Reason = "dial_failed"
+			return
+		}
+
+		pool.IncrementDialRetries()
+		log.Debug("Retrying next backend for ", clientConn.RemoteAddr())
+	}
+
+	pool.IncrementConnection(*backend)
+	defer pool.DecrementConnection(*backend)
+
+	defer func() {
+		pool.IncrementDisconnect(*backend, record.Reason)
+	}()
+
+	/* Mirror client data to shadow pool if needed */
+	var clientSide net.Conn = clientConn
+	if this.shadow != nil {
+		mirrored := this.mirror(ctx)
+		defer mirrored.finish()
+		clientSide = mirrored
 	}
-	this.scheduler.IncrementConnection(*backend)
-	defer this.scheduler.DecrementConnection(*backend)
 
 	/* Stat proxying */
-	log.Debug("Begin ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
-	cs := proxy(clientConn, backendConn, utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0))
-	bs := proxy(backendConn, clientConn, utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0))
+	log.Debug("Begin ", clientConn.RemoteAddr(), " -> ", clientConn.LocalAddr(), " -> ", backendConn.RemoteAddr())
+	var rxStream, txStream *throttle.Stream
+	if this.throttle != nil {
+		rxStream, txStream = this.throttle.Rx(), this.throttle.Tx()
+	}
+
+	/* Terminate session exceeding max duration, closing client connection stops proxying both ways */
+	var expired int32
+	if duration := utils.ParseDurationOrDefault(*this.cfg.MaxSessionDuration, 0); duration > 0 {
+		timer := time.AfterFunc(duration, func() {
+			atomic.StoreInt32(&expired, 1)
+			log.Debug("Session of ", clientConn.RemoteAddr(), " exceeded max duration ", duration)
+			clientConn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	cs, csErr := proxy(clientConn, backendConn,
+		utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0),
+		utils.ParseDurationOrDefault(*this.cfg.ClientWriteTimeout, 0),
+		rxStream, *this.cfg.BufferSize)
+	bs, bsErr := proxy(backendConn, clientSide,
+		utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0),
+		utils.ParseDurationOrDefault(*this.cfg.BackendWriteTimeout, 0),
+		txStream, *this.cfg.BufferSize)
 
 	isTx, isRx := true, true
 	for isTx || isRx {
 		select {
 		case s, ok := <-cs:
 			isRx = ok
-			this.scheduler.IncrementRx(*backend, s.CountWrite)
+			pool.IncrementRx(*backend, s.CountWrite)
+			record.Rx += uint64(s.CountWrite)
+			tracked.count(s.CountWrite, 0, time.Now())
 		case s, ok := <-bs:
 			isTx = ok
-			this.scheduler.IncrementTx(*backend, s.CountWrite)
+			pool.IncrementTx(*backend, s.CountWrite)
+			record.Tx += uint64(s.CountWrite)
+			tracked.count(0, s.CountWrite, time.Now())
 		}
 	}
 
-	log.Debug("End ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
+	/* Backend resetting connection in the middle of the stream is a passive healthcheck fail */
+	backendErr, clientErr := <-csErr, <-bsErr
+	reset := isReset(backendErr, "read") || isReset(clientErr, "write")
+	pool.ReportPassive(*backend, !reset)
+
+	record.Reason = disconnectReason(backendErr, clientErr, reset)
+	if this.clients.isDropped() {
+		record.Reason = "drain"
+	}
+	if tracked.isKilled() {
+		record.Reason = "killed"
+	}
+	if atomic.LoadInt32(&expired) == 1 {
+		record.Reason = "max_session_duration"
+	}
+
+	/* Backend connection is idle and may be reused only if client closed it's side first,
+	   after backend answered the last data client sent */
+	if conn, ok := backendConn.(*pooledConn); ok {
+		e, timeout := backendErr.(net.Error)
+		clientClosed := clientErr == nil && conn.interrupted() && timeout && e.Timeout()
+		if clientClosed {
+			record.Reason = "client_closed"
+		}
+		go this.backendPool.release(backend.Address(), conn, clientClosed && conn.answered())
+	}
+
+	log.Debug("End ", clientConn.RemoteAddr(), " -> ", clientConn.LocalAddr(), " -> ", backendConn.RemoteAddr())
 }
 
-func prepareBackendsTlsConfig(cfg config.Server) (*tls.Config, error) {
+/**
+ * Take idle backend connection from pool or dial new one,
+ * wrapping it to return to pool after session if pool is enabled
+ */
+func (this *Server) connectBackend(clientConn net.Conn, backend *core.Backend) (net.Conn, error) {
 
-	log := logging.For("server.prepareBackendsTlsConfig")
-	var err error
+	if this.backendPool == nil {
+		return this.dialBackend(clientConn, backend)
+	}
 
-	result := &tls.Config{
-		InsecureSkipVerify:       cfg.BackendsTls.IgnoreVerify,
-		CipherSuites:             tlsutil.MapCiphers(cfg.BackendsTls.Ciphers),
-		PreferServerCipherSuites: cfg.BackendsTls.PreferServerCiphers,
-		MinVersion:               tlsutil.MapVersion(cfg.BackendsTls.MinVersion),
-		MaxVersion:               tlsutil.MapVersion(cfg.BackendsTls.MaxVersion),
-		SessionTicketsDisabled:   !cfg.BackendsTls.SessionTickets,
+	if conn := this.backendPool.get(backend.Address()); conn != nil {
+		return &pooledConn{Conn: conn}, nil
 	}
 
-	if cfg.BackendsTls.CertPath != nil && cfg.BackendsTls.KeyPath != nil {
+	conn, err := this.dialBackend(clientConn, backend)
+	if err != nil {
+		return nil, err
+	}
 
-		var crt tls.Certificate
+	return &pooledConn{Conn: conn}, nil
+}
 
-		if crt, err = tls.LoadX509KeyPair(*cfg.BackendsTls.CertPath, *cfg.BackendsTls.KeyPath); err != nil {
-			log.Error(err)
-			return nil, err
-		}
+/**
+ * Connect to backend, directly or through upstream proxy unless it's unix socket, setting socket options,
+ * sending PROXY protocol header and establishing tls session if needed
+ */
+func (this *Server) dialBackend(clientConn net.Conn, backend *core.Backend) (net.Conn, error) {
 
-		result.Certificates = []tls.Certificate{crt}
+	timeout := utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0)
+
+	var conn net.Conn
+	var err error
+
+	if backend.IsUnix() {
+		// Unix socket backends are local, so they're connected directly
+		conn, err = net.DialTimeout("unix", backend.DialAddress(), timeout)
+	} else if this.cfg.Transparent {
+		conn, err = dialTransparent(backend.Address(), timeout, clientConn.RemoteAddr())
+	} else if this.upstreamProxy != nil {
+		conn, err = this.upstreamProxy.Dial("tcp", backend.Address())
+	} else {
+		conn, err = this.backendDialer.Dial("tcp", backend.Address())
 	}
 
-	if cfg.BackendsTls.RootCaCertPath != nil {
+	if err != nil {
+		return nil, err
+	}
 
-		var caCertPem []byte
+	if err := setSocketOptions(conn, this.cfg.BackendSocket); err != nil {
+		logging.For("server.dialBackend").Warn("Can't set backend socket options: ", err)
+	}
 
-		if caCertPem, err = ioutil.ReadFile(*cfg.BackendsTls.RootCaCertPath); err != nil {
-			log.Error(err)
+	// PROXY protocol header should go before anything else, including tls handshake
+	if this.cfg.ProxyProtocol != nil && this.cfg.ProxyProtocol.BackendVersion != "" {
+		err = proxyprotocol.WriteHeader(conn, this.cfg.ProxyProtocol.BackendVersion, clientConn.RemoteAddr(), clientConn.LocalAddr())
+		if err != nil {
+			conn.Close()
 			return nil, err
 		}
+	}
 
-		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCertPem); !ok {
-			log.Error("Unable to load root pem")
-		}
+	if this.cfg.BackendsTls == nil {
+		return conn, nil
+	}
 
-		result.RootCAs = caCertPool
+	// Same as tls.DialWithDialer does, but on already established connection
+	tlsConfig := this.backendsTlsConfg
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = backend.ServerName()
+	}
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
 
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return result, nil
+	conn.SetDeadline(time.Time{})
 
+	return tlsConn, nil
 }
@@ -0,0 +1,194 @@
+/**
+ * shadow.go - mirroring client traffic to shadow backends pool
+ */
+
+package tcp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"../../config"
+	"../../core"
+	"../../logging"
+	"../../stats"
+	"../../utils"
+	"../scheduler"
+)
+
+/**
+ * Shadow backends pool, client data is duplicated to it
+ * and its responses are discarded
+ */
+type shadow struct {
+
+	/* Scheduler of shadow backends pool */
+	scheduler *scheduler.Scheduler
+
+	/* Stats handler of shadow backends pool */
+	statsHandler *stats.Handler
+
+	/* Max client data chunks queued for shadow backend */
+	queueSize int
+}
+
+/**
+ * Client connection duplicating data read from it to shadow backend
+ */
+type shadowConn struct {
+	net.Conn
+
+	/* Client data chunks queued for shadow backend */
+	data chan []byte
+
+	/* Closes data, so no more chunks are queued */
+	finishOnce sync.Once
+
+	/* Queue was full and data is not mirrored anymore */
+	dropped bool
+}
+
+/**
+ * Creates shadow pool for server shadow config, if any.
+ * Shadow pool has stats named "<server>:shadow"
+ */
+func newShadow(name string, cfg config.Server) *shadow {
+
+	if cfg.Shadow == nil {
+		return nil
+	}
+
+	statsHandler := stats.NewHandler(name + ":shadow")
+
+	return &shadow{
+		statsHandler: statsHandler,
+		queueSize:    cfg.Shadow.QueueSize,
+		scheduler:    scheduler.NewPool(cfg, statsHandler, cfg.Shadow.Balance, cfg.Shadow.Discovery, cfg.Shadow.Healthcheck),
+	}
+}
+
+/**
+ * Starts shadow pool scheduler and stats
+ */
+func (this *shadow) Start() {
+	this.statsHandler.Start()
+	this.scheduler.Start()
+}
+
+/**
+ * Stops shadow pool scheduler and stats
+ */
+func (this *shadow) Stop() {
+	this.scheduler.Stop()
+	this.statsHandler.Stop()
+}
+
+/**
+ * Wraps client connection to mirror data read from it to shadow backend.
+ * Shadow backend is elected and connected in background, so client is not delayed.
+ * Returned connection should be finished when proxying ends
+ */
+func (this *Server) mirror(ctx *core.TcpContext) *shadowConn {
+
+	conn := &shadowConn{
+		Conn: ctx.Conn,
+		data: make(chan []byte, this.shadow.queueSize),
+	}
+
+	go this.shadow.forward(this, ctx, conn.data)
+
+	return conn
+}
+
+/**
+ * Connects to shadow backend and writes client data chunks
+ * to it until data is closed, discarding everything it responds
+ */
+func (this *shadow) forward(server *Server, ctx *core.TcpContext, data <-chan []byte) {
+
+	log := logging.For("server.shadow")
+
+	// Drain data on exit, so client side is never blocked
+	defer func() {
+		for range data {
+		}
+	}()
+
+	backend, err := this.scheduler.TakeBackend(ctx)
+	if err != nil {
+		log.Debug(err, " Not mirroring ", ctx.Conn.RemoteAddr())
+		return
+	}
+
+	conn, err := server.dialBackend(ctx.Conn, backend)
+	if err != nil {
+		this.scheduler.IncrementRefused(*backend)
+		log.Debug("Can't connect shadow backend ", backend.Address(), ": ", err)
+		return
+	}
+
+	defer conn.Close()
+
+	this.scheduler.IncrementConnection(*backend)
+	defer this.scheduler.DecrementConnection(*backend)
+
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, conn)
+		this.scheduler.IncrementRx(*backend, uint(n))
+	}()
+
+	timeout := utils.ParseDurationOrDefault(*server.cfg.BackendIdleTimeout, 0)
+
+	for chunk := range data {
+
+		if timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+
+		n, err := conn.Write(chunk)
+		this.scheduler.IncrementTx(*backend, uint(n))
+
+		if err != nil {
+			log.Debug("Error writing to shadow backend ", backend.Address(), ": ", err)
+			return
+		}
+	}
+}
+
+/**
+ * Reads client data, queueing copy of it to shadow backend.
+ * If shadow backend does not keep up and queue is full,
+ * mirroring of the connection stops, as the rest of data would have gaps
+ */
+func (this *shadowConn) Read(b []byte) (int, error) {
+
+	n, err := this.Conn.Read(b)
+
+	if n > 0 && !this.dropped {
+		select {
+		case this.data <- append([]byte(nil), b[0:n]...):
+		default:
+			logging.For("server.shadow").Debug("Shadow queue is full, not mirroring ", this.RemoteAddr(), " anymore")
+			this.dropped = true
+			this.finish()
+		}
+	}
+
+	if err != nil {
+		this.finish()
+	}
+
+	return n, err
+}
+
+/**
+ * Stops mirroring, shadow backend connection is closed after queued data is written
+ */
+func (this *shadowConn) finish() {
+	this.finishOnce.Do(func() {
+		close(this.data)
+	})
+}
@@ -0,0 +1,60 @@
+/**
+ * sockopt.go - client and backend side sockets options
+ */
+
+package tcp
+
+import (
+	"net"
+
+	"../../config"
+	"../../utils"
+)
+
+/**
+ * Sets socket options of tcp connection, leaving
+ * system defaults of options not configured
+ */
+func setSocketOptions(conn net.Conn, opts *config.SocketOptions) error {
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if opts == nil || !ok {
+		return nil
+	}
+
+	if opts.Keepalive != nil {
+		if err := tcpConn.SetKeepAlive(*opts.Keepalive); err != nil {
+			return err
+		}
+	}
+
+	if interval := utils.ParseDurationOrDefault(opts.KeepaliveInterval, 0); interval > 0 {
+		if err := tcpConn.SetKeepAlivePeriod(interval); err != nil {
+			return err
+		}
+	}
+
+	if opts.NoDelay != nil {
+		if err := tcpConn.SetNoDelay(*opts.NoDelay); err != nil {
+			return err
+		}
+	}
+
+	if opts.RecvBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(opts.RecvBuffer); err != nil {
+			return err
+		}
+	}
+
+	if opts.SendBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+
+	if opts.KeepaliveCount > 0 || opts.Tos > 0 {
+		return setRawSocketOptions(tcpConn, opts)
+	}
+
+	return nil
+}
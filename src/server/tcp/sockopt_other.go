@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd
+
+/**
+ * sockopt_other.go - raw socket options are not available on this platform
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+
+	"../../config"
+)
+
+/**
+ * Keepalive probes count and tos are not supported on this platform
+ */
+func setRawSocketOptions(conn *net.TCPConn, opts *config.SocketOptions) error {
+	return errors.New("keepalive_count and tos are not supported on this platform")
+}
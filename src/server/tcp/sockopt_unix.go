@@ -0,0 +1,54 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd
+// +build linux darwin dragonfly freebsd netbsd
+
+/**
+ * sockopt_unix.go - socket options not exposed by net package
+ */
+
+package tcp
+
+import (
+	"net"
+
+	"../../config"
+
+	"golang.org/x/sys/unix"
+)
+
+/**
+ * Sets keepalive probes count and IP_TOS (IPV6_TCLASS for ipv6) of connection
+ */
+func setRawSocketOptions(conn *net.TCPConn, opts *config.SocketOptions) error {
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	ipv6 := false
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+
+	if cerr := rawConn.Control(func(fd uintptr) {
+
+		if opts.KeepaliveCount > 0 {
+			if err = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_KEEPCNT, opts.KeepaliveCount); err != nil {
+				return
+			}
+		}
+
+		if opts.Tos > 0 {
+			if ipv6 {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, opts.Tos)
+			} else {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, opts.Tos)
+			}
+		}
+
+	}); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
@@ -0,0 +1,136 @@
+/**
+ * splice_linux.go - zero-copy proxying between tcp sockets with splice()
+ */
+
+package tcp
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"../../core"
+)
+
+const (
+	/* Max bytes moved through pipe at once, default pipe capacity */
+	SPLICE_CHUNK_SIZE = 64 * 1024
+
+	spliceFMove     = 0x1
+	spliceFNonblock = 0x2
+)
+
+/**
+ * Copy data from 'from' to 'to' through kernel pipe, without copying
+ * it to user space, if both are plain tcp connections. Moving data to 'to'
+ * fails if it's blocked for more than writeTimeout (0 means no limit).
+ * Returns false if connections can't be spliced, so regular copy should be used
+ */
+func splice(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount, writeTimeout time.Duration) (bool, error) {
+
+	dst, ok := to.(*net.TCPConn)
+	if !ok {
+		return false, nil
+	}
+
+	src, ok := from.(*net.TCPConn)
+	if !ok {
+		return false, nil
+	}
+
+	rc, err := src.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+
+	wc, err := dst.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return false, nil
+	}
+
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	for {
+		/* Move available data from source socket to pipe */
+		var n int64
+		var serr error
+
+		err := rc.Read(func(fd uintptr) bool {
+			n, serr = syscall.Splice(int(fd), nil, p[1], nil, SPLICE_CHUNK_SIZE, spliceFMove|spliceFNonblock)
+			return serr != syscall.EAGAIN
+		})
+
+		if err == nil {
+			err = serr
+		}
+
+		if err != nil {
+			return true, spliceError("read", src, err)
+		}
+
+		if n == 0 {
+			return true, nil
+		}
+
+		/* Move all data from pipe to destination socket */
+		if writeTimeout > 0 {
+			dst.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+
+		for remain := n; remain > 0; {
+			var m int64
+
+			err := wc.Write(func(fd uintptr) bool {
+				m, serr = syscall.Splice(p[0], nil, int(fd), nil, int(remain), spliceFMove|spliceFNonblock)
+				return serr != syscall.EAGAIN
+			})
+
+			if err == nil {
+				err = serr
+			}
+
+			if err != nil {
+				return true, spliceError("write", dst, err)
+			}
+
+			if m == 0 {
+				return true, spliceError("write", dst, io.ErrShortWrite)
+			}
+
+			remain -= m
+		}
+
+		ch <- core.ReadWriteCount{CountRead: uint(n), CountWrite: uint(n)}
+	}
+}
+
+/**
+ * Returns error in the same form as net.Conn Read / Write return
+ */
+func spliceError(op string, conn *net.TCPConn, err error) error {
+
+	// raw conn errors are already wrapped
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+
+	if errno, ok := err.(syscall.Errno); ok {
+		err = os.NewSyscallError("splice", errno)
+	}
+
+	return &net.OpError{
+		Op:     op,
+		Net:    "tcp",
+		Source: conn.LocalAddr(),
+		Addr:   conn.RemoteAddr(),
+		Err:    err,
+	}
+}
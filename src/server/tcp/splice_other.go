@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * splice_other.go - zero-copy proxying is not available on this platform
+ */
+
+package tcp
+
+import (
+	"io"
+	"time"
+
+	"../../core"
+)
+
+/**
+ * Regular copy should be used
+ */
+func splice(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount, writeTimeout time.Duration) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,49 @@
+/**
+ * transparent_linux.go - dialing backends from client address with IP_TRANSPARENT
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/**
+ * Dial address with client ip as source address, so backend sees
+ * true client ip. Needs CAP_NET_ADMIN and routing of backend
+ * responses back to local host, i.e. with TPROXY policy routing
+ */
+func dialTransparent(address string, timeout time.Duration, client net.Addr) (net.Conn, error) {
+
+	clientAddr, ok := client.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("Unexpected client address " + client.String())
+	}
+
+	ipv6 := clientAddr.IP.To4() == nil
+
+	dialer := net.Dialer{
+		Timeout:   timeout,
+		LocalAddr: &net.TCPAddr{IP: clientAddr.IP, Zone: clientAddr.Zone},
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				if ipv6 {
+					err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				} else {
+					err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				}
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	return dialer.Dial("tcp", address)
+}
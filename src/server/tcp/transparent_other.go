@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * transparent_other.go - IP_TRANSPARENT is not available on this platform
+ */
+
+package tcp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+/**
+ * Transparent dialing is not supported on this platform
+ */
+func dialTransparent(address string, timeout time.Duration, client net.Addr) (net.Conn, error) {
+	return nil, errors.New("transparent is not supported on this platform")
+}
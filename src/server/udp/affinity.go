@@ -0,0 +1,127 @@
+/**
+ * affinity.go - udp client to backend affinity table
+ */
+
+package udp
+
+import (
+	"container/list"
+	"time"
+
+	"../../core"
+)
+
+/**
+ * Affinity table entry
+ */
+type affinityEntry struct {
+
+	/* Client address */
+	clientAddr string
+
+	/* Backend client was served by */
+	target core.Target
+
+	/* Last time client sent data */
+	lastSeen time.Time
+}
+
+/**
+ * Remembers backend of every client address, so client keeps
+ * hitting the same backend even after its session is closed.
+ * Is not safe for concurrent use
+ */
+type affinity struct {
+
+	/* Entry expiration time since last client activity */
+	timeout time.Duration
+
+	/* Max entries count, 0 means unlimited */
+	maxEntries int
+
+	/* Entries by client address */
+	entries map[string]*list.Element
+
+	/* Entries ordered from most to least recently seen */
+	lru *list.List
+}
+
+/**
+ * Creates new affinity table
+ */
+func newAffinity(timeout time.Duration, maxEntries int) *affinity {
+	return &affinity{
+		timeout:    timeout,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+/**
+ * Returns target remembered for client address, or nil,
+ * and marks client as seen
+ */
+func (this *affinity) get(clientAddr string, now time.Time) *core.Target {
+
+	el, ok := this.entries[clientAddr]
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*affinityEntry)
+	if now.Sub(entry.lastSeen) > this.timeout {
+		this.remove(el)
+		return nil
+	}
+
+	entry.lastSeen = now
+	this.lru.MoveToFront(el)
+
+	return &entry.target
+}
+
+/**
+ * Remembers target for client address, evicting least
+ * recently seen client if table is full
+ */
+func (this *affinity) put(clientAddr string, target core.Target, now time.Time) {
+
+	if el, ok := this.entries[clientAddr]; ok {
+		entry := el.Value.(*affinityEntry)
+		entry.target = target
+		entry.lastSeen = now
+		this.lru.MoveToFront(el)
+		return
+	}
+
+	if this.maxEntries > 0 && this.lru.Len() >= this.maxEntries {
+		this.remove(this.lru.Back())
+	}
+
+	this.entries[clientAddr] = this.lru.PushFront(&affinityEntry{
+		clientAddr: clientAddr,
+		target:     target,
+		lastSeen:   now,
+	})
+}
+
+/**
+ * Removes entries of clients not seen for more than timeout
+ */
+func (this *affinity) expire(now time.Time) {
+	for el := this.lru.Back(); el != nil; el = this.lru.Back() {
+		if now.Sub(el.Value.(*affinityEntry).lastSeen) <= this.timeout {
+			return
+		}
+		this.remove(el)
+	}
+}
+
+/**
+ * Removes entry
+ */
+func (this *affinity) remove(el *list.Element) {
+	this.lru.Remove(el)
+	delete(this.entries, el.Value.(*affinityEntry).clientAddr)
+}
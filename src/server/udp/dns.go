@@ -0,0 +1,204 @@
+/**
+ * dns.go - DNS mode of udp server, proxying every query separately
+ */
+
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"../../core"
+	"../../logging"
+	"../../utils"
+)
+
+/* Size of DNS message header, shorter datagrams are not DNS messages */
+const dnsHeaderSize = 12
+
+/**
+ * Error of backend not responding to query in timeout
+ */
+var errDnsTimeout = errors.New("No response to query in timeout")
+
+/**
+ * Queries being proxied, by client address and query id
+ */
+type dnsQueries struct {
+	sync.Mutex
+	active map[string]bool
+}
+
+/**
+ * Registers query, returns false if the same query is being proxied already
+ */
+func (this *dnsQueries) begin(key string) bool {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.active[key] {
+		return false
+	}
+
+	this.active[key] = true
+	return true
+}
+
+/**
+ * Unregisters proxied query
+ */
+func (this *dnsQueries) end(key string) {
+
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.active, key)
+}
+
+/**
+ * Returns count of queries being proxied
+ */
+func (this *dnsQueries) count() int {
+
+	this.Lock()
+	defer this.Unlock()
+
+	return len(this.active)
+}
+
+/**
+ * Proxies DNS query to elected backend and its response back to client over serverConn query came to.
+ * Response is matched to query by id, so exchange ends right after it.
+ * If backend does not respond in query timeout, next backends are tried up to retries.
+ * Client retransmits of query being proxied are dropped
+ */
+func (this *Server) handleDns(query []byte, clientAddr net.UDPAddr, serverConn *net.UDPConn) {
+
+	log := logging.For("udp/dns")
+
+	// Not a query, i.e. header is truncated or QR bit is set
+	if len(query) < dnsHeaderSize || query[2]&0x80 != 0 {
+		log.Debug("Dropping not DNS query datagram from ", clientAddr)
+		return
+	}
+
+	if !this.access.Allows(&clientAddr.IP) {
+		log.Debug("Client disallowed to connect ", clientAddr)
+		this.statsHandler.Disconnected("access_denied")
+		return
+	}
+
+	if this.rateLimit != nil && !this.rateLimit.Allows(clientAddr.IP, time.Now()) {
+		log.Debug("Client exceeded queries rate limit ", clientAddr)
+		return
+	}
+
+	if max := *this.cfg.MaxConnections; max > 0 && this.dnsQueries.count() >= max {
+		log.Debug("Too many queries, dropping query of ", clientAddr)
+		this.statsHandler.Disconnected("max_connections")
+		return
+	}
+
+	key := clientAddr.String() + "#" + string(query[0:2])
+	if !this.dnsQueries.begin(key) {
+		log.Debug("Dropping retransmitted query of ", clientAddr)
+		return
+	}
+
+	this.sessionsCount.set(this.dnsQueries.count())
+
+	defer func() {
+		this.dnsQueries.end(key)
+		this.sessionsCount.set(this.dnsQueries.count())
+	}()
+
+	timeout := utils.ParseDurationOrDefault(this.cfg.Udp.Dns.QueryTimeout, 0)
+	ctx := &core.UdpContext{
+		RemoteAddr: clientAddr,
+	}
+
+	var tried []core.Target
+
+	for {
+		backend, err := this.scheduler.TakeBackendExcluding(ctx, tried)
+		if err != nil {
+			log.Debug(err, " Dropping query of ", clientAddr)
+			return
+		}
+
+		response, err := this.exchangeDns(backend, query, timeout)
+		if err == nil {
+			serverConn.WriteToUDP(response, &clientAddr)
+			return
+		}
+
+		this.scheduler.IncrementRefused(*backend)
+		log.Debug("Query of ", clientAddr, " to ", backend.Address(), " failed: ", err)
+
+		tried = append(tried, backend.Target)
+		if len(tried) > this.cfg.Udp.Dns.Retries {
+			return
+		}
+
+		this.scheduler.IncrementDialRetries()
+	}
+}
+
+/**
+ * Sends query to backend and waits for response with the same id up to timeout.
+ * Other datagrams, i.e. late responses to previous queries, are skipped
+ */
+func (this *Server) exchangeDns(backend *core.Backend, query []byte, timeout time.Duration) ([]byte, error) {
+
+	if backend.IsUnix() {
+		return nil, errors.New("Unix socket backend is not supported for udp")
+	}
+
+	backendAddr, err := net.ResolveUDPAddr("udp", backend.Target.String())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := this.backendDialer.DialUDP(backendAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	this.scheduler.IncrementConnection(*backend)
+	defer this.scheduler.DecrementConnection(*backend)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	this.scheduler.IncrementTx(*backend, uint(len(query)))
+
+	buf := make([]byte, this.packetSize+1)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil, errDnsTimeout
+			}
+			return nil, err
+		}
+
+		this.scheduler.IncrementRx(*backend, uint(n))
+
+		if n > this.packetSize || n < dnsHeaderSize {
+			continue
+		}
+
+		if buf[0] == query[0] && buf[1] == query[1] && buf[2]&0x80 != 0 {
+			return buf[0:n], nil
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/**
+ * dtls.go - DTLS termination for udp server
+ */
+
+package udp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"../../logging"
+	"../../utils"
+	tlsutil "../../utils/tls"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	udpconn "github.com/pion/transport/v2/udp"
+)
+
+/**
+ * Start accepting dtls connections, proxying decrypted datagrams
+ * of every connection within client session
+ */
+func (this *Server) listenDtls() error {
+
+	log := logging.For("udp/server")
+
+	dtlsConfig, err := this.prepareDtlsConfig()
+	if err != nil {
+		log.Error("Error preparing dtls config: ", err)
+		return err
+	}
+
+	// Same as dtls.Listen does, but handshakes are made outside of accept loop
+	listenConfig := udpconn.ListenConfig{
+		AcceptFilter: isHandshake,
+	}
+
+	for _, bind := range this.cfg.Bind {
+		var listenAddr *net.UDPAddr
+		var listener net.Listener
+
+		if listenAddr, err = net.ResolveUDPAddr("udp", bind); err == nil {
+			listener, err = listenConfig.Listen("udp", listenAddr)
+		}
+
+		if err != nil {
+			log.Error("Error starting DTLS server: ", err)
+			for _, l := range this.listeners {
+				l.Close()
+			}
+			this.listeners = nil
+			return err
+		}
+
+		this.listeners = append(this.listeners, listener)
+	}
+
+	for _, l := range this.listeners {
+		go func(listener net.Listener) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					if this.stopped {
+						return
+					}
+					log.Error("Error accepting dtls connection: ", err)
+					continue
+				}
+
+				go this.handleDtls(conn, dtlsConfig)
+			}
+		}(l)
+	}
+
+	return nil
+}
+
+/**
+ * Handshakes dtls connection and proxies its datagrams until it's closed
+ */
+func (this *Server) handleDtls(conn net.Conn, dtlsConfig *dtls.Config) {
+
+	log := logging.For("udp/server")
+
+	clientAddr := *conn.RemoteAddr().(*net.UDPAddr)
+
+	// Check access before handshake, so disallowed clients don't cost it
+	if !this.access.Allows(&clientAddr.IP) {
+		log.Debug("Client disallowed to connect ", clientAddr)
+		this.statsHandler.Disconnected("access_denied")
+		conn.Close()
+		return
+	}
+
+	dtlsConn, err := dtls.Server(conn, dtlsConfig)
+	if err != nil {
+		log.Debug("Dtls handshake with ", clientAddr, " failed: ", err)
+		conn.Close()
+		return
+	}
+
+	responseChan := make(chan sessionResponse, 1)
+
+	this.getOrCreate <- &sessionRequest{
+		clientAddr: clientAddr,
+		clientConn: dtlsConn,
+		response:   responseChan,
+	}
+
+	response := <-responseChan
+
+	if response.err != nil {
+		log.Error("Error creating session ", response.err)
+		dtlsConn.Close()
+		return
+	}
+
+	buf := make([]byte, this.packetSize)
+
+	for {
+		n, err := dtlsConn.Read(buf)
+
+		if err != nil {
+			// Datagram is larger than buffer
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Debug("Dropping datagram exceeding ", this.packetSize, " bytes from ", clientAddr)
+				continue
+			}
+
+			log.Debug("Dtls connection of ", clientAddr, " is closed: ", err)
+			this.remove <- clientAddr
+			return
+		}
+
+		if err := response.session.send(buf[0:n]); err != nil {
+			log.Error("Error sending data to backend ", err)
+		}
+	}
+}
+
+/**
+ * Creates dtls config from server tls options
+ */
+func (this *Server) prepareDtlsConfig() (*dtls.Config, error) {
+
+	var err error
+
+	if this.certificates, err = tlsutil.NewCertificates(this.cfg.Tls); err != nil {
+		return nil, err
+	}
+
+	if interval := utils.ParseDurationOrDefault(this.cfg.Tls.ReloadInterval, 0); interval > 0 {
+		this.certificates.Watch(interval, this.done)
+	}
+
+	dtlsConfig := &dtls.Config{
+		GetCertificate: func(hello *dtls.ClientHelloInfo) (*tls.Certificate, error) {
+			return this.certificates.Select(hello.ServerName)
+		},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		SupportedProtocols:   this.cfg.Tls.Alpn,
+	}
+
+	for _, cipher := range tlsutil.MapCiphers(this.cfg.Tls.Ciphers) {
+		dtlsConfig.CipherSuites = append(dtlsConfig.CipherSuites, dtls.CipherSuiteID(cipher))
+	}
+
+	if this.cfg.Tls.ClientAuth != nil {
+
+		// Configure client auth as for tls, then take it to dtls config
+		tlsConfig := &tls.Config{}
+		if err = tlsutil.ConfigureClientAuth(tlsConfig, this.cfg.Tls.ClientAuth); err != nil {
+			return nil, err
+		}
+
+		switch tlsConfig.ClientAuth {
+		case tls.RequireAndVerifyClientCert:
+			dtlsConfig.ClientAuth = dtls.RequireAndVerifyClientCert
+		case tls.VerifyClientCertIfGiven:
+			dtlsConfig.ClientAuth = dtls.VerifyClientCertIfGiven
+		default:
+			return nil, errors.New("Not supported client_auth mode " + this.cfg.Tls.ClientAuth.Mode)
+		}
+
+		dtlsConfig.ClientCAs = tlsConfig.ClientCAs
+		dtlsConfig.VerifyPeerCertificate = tlsConfig.VerifyPeerCertificate
+	}
+
+	return dtlsConfig, nil
+}
+
+/**
+ * Reload dtls certificate from files
+ */
+func (this *Server) ReloadTls() error {
+
+	if this.certificates == nil {
+		return errors.New("Server has no tls certificate")
+	}
+
+	return this.certificates.Reload()
+}
+
+/**
+ * Checks if datagram starts new connection, i.e. is a handshake record
+ */
+func isHandshake(packet []byte) bool {
+
+	records, err := recordlayer.UnpackDatagram(packet)
+	if err != nil || len(records) < 1 {
+		return false
+	}
+
+	header := &recordlayer.Header{}
+	if err := header.Unmarshal(records[0]); err != nil {
+		return false
+	}
+
+	return header.ContentType == protocol.ContentTypeHandshake
+}
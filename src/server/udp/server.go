@@ -9,6 +9,8 @@ package udp
 import (
 	"errors"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"../../balance"
 	"../../config"
@@ -18,7 +20,12 @@ import (
 	"../../logging"
 	"../../stats"
 	"../../utils"
+	"../../utils/backendbind"
+	"../../utils/systemd"
+	tlsutil "../../utils/tls"
+	"../../utils/upgrade"
 	"../modules/access"
+	"../modules/ratelimit"
 	"../scheduler"
 )
 
@@ -41,8 +48,26 @@ type Server struct {
 	/* Stats handler */
 	statsHandler *stats.Handler
 
-	/* Server connection */
-	serverConn *net.UDPConn
+	/* Server connections, one per bind */
+	serverConns []*net.UDPConn
+
+	/* Dtls listeners, used instead of server connections for protocol = "dtls" */
+	listeners []net.Listener
+
+	/* Dtls certificates, reloadable from files */
+	certificates *tlsutil.Certificates
+
+	/* Max size of datagrams proxied, larger ones are dropped */
+	packetSize int
+
+	/* Active sessions count */
+	sessionsCount *sessionsCounter
+
+	/* Queries being proxied in dns mode, nil otherwise */
+	dnsQueries *dnsQueries
+
+	/* Dialer of backends from backend_bind source ips and interface */
+	backendDialer *backendbind.Dialer
 
 	/* Flag indicating that server is stopped */
 	stopped bool
@@ -51,11 +76,15 @@ type Server struct {
 	getOrCreate chan *sessionRequest
 	remove      chan net.UDPAddr
 	stop        chan bool
+	done        chan bool
 
 	/* ----- modules ----- */
 
 	/* Access module checks if client is allowed to connect */
 	access *access.Access
+
+	/* Rate limit module checks if client does not create sessions too often */
+	rateLimit *ratelimit.RateLimit
 }
 
 /**
@@ -63,6 +92,8 @@ type Server struct {
  */
 type sessionRequest struct {
 	clientAddr net.UDPAddr
+	serverConn *net.UDPConn
+	clientConn net.Conn
 	response   chan sessionResponse
 }
 
@@ -74,6 +105,21 @@ type sessionResponse struct {
 	err     error
 }
 
+/**
+ * Active sessions counter, reported as server active connections
+ */
+type sessionsCounter struct {
+	count uint64
+}
+
+func (this *sessionsCounter) set(count int) {
+	atomic.StoreUint64(&this.count, uint64(count))
+}
+
+func (this *sessionsCounter) Count() uint {
+	return uint(atomic.LoadUint64(&this.count))
+}
+
 /**
  * Creates new UDP server
  */
@@ -87,19 +133,38 @@ func New(name string, cfg config.Server) (*Server, error) {
 		Discovery:    discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
 		Healthcheck:  healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
 		StatsHandler: statsHandler,
+		SlowStart:    utils.ParseDurationOrDefault(cfg.SlowStart, 0),
+		StickTable:   scheduler.NewStickTable(cfg.Sticky),
+		Failover:     cfg.Failover,
+		Geoip:        cfg.Geoip,
+		LabelFilter:  cfg.BackendLabels,
 	}
 
 	server := &Server{
-		name:         name,
-		cfg:          cfg,
-		scheduler:    scheduler,
-		statsHandler: statsHandler,
-		getOrCreate:  make(chan *sessionRequest),
-		remove:       make(chan net.UDPAddr),
-		stop:         make(chan bool),
+		name:          name,
+		cfg:           cfg,
+		scheduler:     scheduler,
+		statsHandler:  statsHandler,
+		packetSize:    UDP_PACKET_SIZE,
+		sessionsCount: &sessionsCounter{},
+		getOrCreate:   make(chan *sessionRequest),
+		remove:        make(chan net.UDPAddr),
+		stop:          make(chan bool),
+		done:          make(chan bool),
 	}
 
-	/* Add access if needed */
+	statsHandler.Clients = server.sessionsCount
+
+	if cfg.Udp != nil && cfg.Udp.MaxPacketSize > 0 && cfg.Udp.MaxPacketSize < UDP_PACKET_SIZE {
+		server.packetSize = cfg.Udp.MaxPacketSize
+	}
+
+	if cfg.Udp != nil && cfg.Udp.Dns != nil {
+		server.dnsQueries = &dnsQueries{active: make(map[string]bool)}
+	}
+
+	/* Add access, allowing everything if not configured, so it could be updated in runtime */
+	server.access = access.NewAllowAll()
 	if cfg.Access != nil {
 		access, err := access.NewAccess(cfg.Access)
 		if err != nil {
@@ -108,7 +173,22 @@ func New(name string, cfg config.Server) (*Server, error) {
 		server.access = access
 	}
 
-	log.Info("Creating UDP server '", name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
+	/* Add rate limit if needed */
+	if cfg.RateLimit != nil {
+		rateLimit, err := ratelimit.NewRateLimit(cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		server.rateLimit = rateLimit
+	}
+
+	backendDialer, err := backendbind.New(cfg.BackendBind, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	server.backendDialer = backendDialer
+
+	log.Info("Creating ", cfg.Protocol, " server '", name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
 	return server, nil
 }
 
@@ -116,7 +196,51 @@ func New(name string, cfg config.Server) (*Server, error) {
  * Returns current server configuration
  */
 func (this *Server) Cfg() config.Server {
-	return this.cfg
+	cfg := this.cfg
+	cfg.Access = this.access.Config()
+	return cfg
+}
+
+/**
+ * Replace access rules without restart
+ */
+func (this *Server) UpdateAccess(cfg *config.AccessConfig) error {
+	return this.access.Update(cfg)
+}
+
+/**
+ * Returns healthcheck history of server backends
+ */
+func (this *Server) HealthcheckHistory() []healthcheck.TargetHistory {
+	return this.scheduler.HealthcheckHistory()
+}
+
+/**
+ * Returns health of server discovery
+ */
+func (this *Server) DiscoveryHealth() discovery.Health {
+	return this.scheduler.Discovery.Health()
+}
+
+/**
+ * Returns client ip to backend stick table, nil if disabled
+ */
+func (this *Server) StickTable() *scheduler.StickTable {
+	return this.scheduler.StickTable
+}
+
+/**
+ * Returns scheduler of server backends pool
+ */
+func (this *Server) Schedulers() []*scheduler.Scheduler {
+	return []*scheduler.Scheduler{this.scheduler}
+}
+
+/**
+ * Drains backend (drained = true) or enables it back
+ */
+func (this *Server) SetBackendDrained(target core.Target, drained bool) error {
+	return this.scheduler.SetDrained(target, drained)
 }
 
 /**
@@ -129,21 +253,40 @@ func (this *Server) Start() error {
 	this.statsHandler.Start()
 	this.scheduler.Start()
 
-	// Start listening
-	if err := this.listen(); err != nil {
-		this.Stop()
-		log.Error("Error starting UDP Listen ", err)
-		return err
+	if this.rateLimit != nil {
+		this.rateLimit.Share(this.name)
 	}
 
+	// Sessions loop is started first, so Stop is received if listening fails
 	go func() {
 		sessions := make(map[string]*session)
+
+		/* client to backend affinity, if enabled */
+		var sticky *affinity
+		var expireC <-chan time.Time
+
+		if this.cfg.Udp != nil && this.cfg.Udp.SessionTimeout != "" {
+			if timeout := utils.ParseDurationOrDefault(this.cfg.Udp.SessionTimeout, 0); timeout > 0 {
+				sticky = newAffinity(timeout, this.cfg.Udp.MaxSessions)
+				ticker := time.NewTicker(timeout)
+				defer ticker.Stop()
+				expireC = ticker.C
+			}
+		}
+
 		for {
 			select {
 
 			/* handle get session request */
 			case sessionRequest := <-this.getOrCreate:
-				session, ok := sessions[sessionRequest.clientAddr.String()]
+				clientKey := sessionRequest.clientAddr.String()
+
+				var preferred *core.Target
+				if sticky != nil {
+					preferred = sticky.get(clientKey, time.Now())
+				}
+
+				session, ok := sessions[clientKey]
 
 				if ok {
 					sessionRequest.response <- sessionResponse{
@@ -153,9 +296,23 @@ func (this *Server) Start() error {
 					break
 				}
 
-				session, err := this.makeSession(sessionRequest.clientAddr)
+				/* Reject new session if server has max sessions already */
+				if max := *this.cfg.MaxConnections; max > 0 && len(sessions) >= max {
+					this.statsHandler.Disconnected("max_connections")
+					sessionRequest.response <- sessionResponse{
+						session: nil,
+						err:     errors.New("Too many sessions"),
+					}
+					break
+				}
+
+				session, err := this.makeSession(sessionRequest.clientAddr, sessionRequest.serverConn, sessionRequest.clientConn, preferred)
 				if err == nil {
-					sessions[sessionRequest.clientAddr.String()] = session
+					sessions[clientKey] = session
+					this.sessionsCount.set(len(sessions))
+					if sticky != nil {
+						sticky.put(clientKey, session.backend.Target, time.Now())
+					}
 				}
 
 				sessionRequest.response <- sessionResponse{
@@ -171,6 +328,11 @@ func (this *Server) Start() error {
 				}
 				session.stop()
 				delete(sessions, clientAddr.String())
+				this.sessionsCount.set(len(sessions))
+
+			/* forget clients with expired affinity */
+			case now := <-expireC:
+				sticky.expire(now)
 
 			/* handle server stop */
 			case <-this.stop:
@@ -182,6 +344,18 @@ func (this *Server) Start() error {
 		}
 	}()
 
+	// Start listening
+	listen := this.listen
+	if this.cfg.Protocol == "dtls" {
+		listen = this.listenDtls
+	}
+
+	if err := listen(); err != nil {
+		this.Stop()
+		log.Error("Error starting UDP Listen ", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -192,88 +366,151 @@ func (this *Server) listen() error {
 
 	log := logging.For("udp/server")
 
-	listenAddr, err := net.ResolveUDPAddr("udp", this.cfg.Bind)
-	if err != nil {
-		log.Error("Error resolving server bind addr ", err)
-		return err
+	for _, bind := range this.cfg.Bind {
+		serverConn, err := listenBind(bind)
+		if err != nil {
+			log.Error("Error starting UDP server: ", err)
+			for _, c := range this.serverConns {
+				c.Close()
+			}
+			this.serverConns = nil
+			return err
+		}
+		this.serverConns = append(this.serverConns, serverConn)
 	}
 
-	this.serverConn, err = net.ListenUDP("udp", listenAddr)
+	for _, serverConn := range this.serverConns {
+		go this.serve(serverConn)
+	}
+
+	return nil
+}
+
+/**
+ * Listens on bind, taking socket passed by old process on upgrade, or by systemd
+ */
+func listenBind(bind string) (*net.UDPConn, error) {
+
+	var serverConn *net.UDPConn
+	var err error
+
+	if inherited := upgrade.UDPConn(bind); inherited != nil {
+		serverConn = inherited
+	} else if systemd.IsSocketBind(bind) {
+		serverConn, err = systemd.UDPConn(bind)
+	} else {
+		var listenAddr *net.UDPAddr
+		if listenAddr, err = net.ResolveUDPAddr("udp", bind); err != nil {
+			return nil, err
+		}
+
+		serverConn, err = net.ListenUDP("udp", listenAddr)
+	}
 
 	if err != nil {
-		log.Error("Error starting UDP server: ", err)
-		return err
+		return nil, err
 	}
 
-	// Main proxy loop goroutine
-	go func() {
-		for {
-			buf := make([]byte, UDP_PACKET_SIZE)
-			n, clientAddr, err := this.serverConn.ReadFromUDP(buf)
+	upgrade.RegisterUDPConn(bind, serverConn)
 
-			if err != nil {
-				if this.stopped {
-					return
-				}
-				log.Error("Error ReadFromUDP: ", err)
-				continue
+	return serverConn, nil
+}
+
+/**
+ * Main proxy loop of server connection, until server is stopped
+ */
+func (this *Server) serve(serverConn *net.UDPConn) {
+
+	log := logging.For("udp/server")
+
+	for {
+		// Extra byte lets detect datagrams exceeding packet size
+		buf := make([]byte, this.packetSize+1)
+		n, clientAddr, err := serverConn.ReadFromUDP(buf)
+
+		if err != nil {
+			if this.stopped {
+				return
 			}
+			log.Error("Error ReadFromUDP: ", err)
+			continue
+		}
 
-			go func(buf []byte) {
-				responseChan := make(chan sessionResponse, 1)
+		if n > this.packetSize {
+			log.Debug("Dropping datagram exceeding ", this.packetSize, " bytes from ", clientAddr)
+			continue
+		}
 
-				this.getOrCreate <- &sessionRequest{
-					clientAddr: *clientAddr,
-					response:   responseChan,
-				}
+		if this.dnsQueries != nil {
+			go this.handleDns(buf[0:n], *clientAddr, serverConn)
+			continue
+		}
 
-				response := <-responseChan
+		go func(buf []byte) {
+			responseChan := make(chan sessionResponse, 1)
 
-				if response.err != nil {
-					log.Error("Error creating session ", response.err)
-					return
-				}
+			this.getOrCreate <- &sessionRequest{
+				clientAddr: *clientAddr,
+				serverConn: serverConn,
+				response:   responseChan,
+			}
 
-				err := response.session.send(buf)
+			response := <-responseChan
 
-				if err != nil {
-					log.Error("Error sending data to backend ", err)
-				}
+			if response.err != nil {
+				log.Error("Error creating session ", response.err)
+				return
+			}
 
-			}(buf[0:n])
-		}
-	}()
+			err := response.session.send(buf)
 
-	return nil
+			if err != nil {
+				log.Error("Error sending data to backend ", err)
+			}
+
+		}(buf[0:n])
+	}
 }
 
 /**
- * Makes new session
+ * Makes new session, with preferred backend if it's not nil and live.
+ * Responses are sent to client over clientConn if it's not nil, or serverConn datagrams came to otherwise
  */
-func (this *Server) makeSession(clientAddr net.UDPAddr) (*session, error) {
+func (this *Server) makeSession(clientAddr net.UDPAddr, serverConn *net.UDPConn, clientConn net.Conn, preferred *core.Target) (*session, error) {
 
 	log := logging.For("udp/server")
 	/* Check access if needed */
 	if this.access != nil {
 		if !this.access.Allows(&clientAddr.IP) {
 			log.Debug("Client disallowed to connect ", clientAddr)
+			this.statsHandler.Disconnected("access_denied")
 			return nil, errors.New("Access denied")
 		}
 	}
 
-	log.Debug("Accepted ", clientAddr, " -> ", this.serverConn.LocalAddr())
+	/* Check rate limit if needed */
+	if this.rateLimit != nil && !this.rateLimit.Allows(clientAddr.IP, time.Now()) {
+		log.Debug("Client exceeded sessions rate limit ", clientAddr)
+		return nil, errors.New("Rate limit exceeded")
+	}
+
+	log.Debug("Accepted ", clientAddr, " -> ", this.cfg.Bind)
 
 	var maxRequests uint64
 	var maxResponses uint64
+	var maxRequestBytes uint64
+	var maxResponseBytes uint64
 
 	if this.cfg.Udp != nil {
 		maxRequests = this.cfg.Udp.MaxRequests
 		maxResponses = this.cfg.Udp.MaxResponses
+		maxRequestBytes = this.cfg.Udp.MaxRequestBytes
+		maxResponseBytes = this.cfg.Udp.MaxResponseBytes
 	}
 
-	backend, err := this.scheduler.TakeBackend(&core.UdpContext{
+	backend, err := this.scheduler.TakeBackendPreferring(&core.UdpContext{
 		RemoteAddr: clientAddr,
-	})
+	}, preferred)
 
 	if err != nil {
 		return nil, err
@@ -284,13 +521,18 @@ func (this *Server) makeSession(clientAddr net.UDPAddr) (*session, error) {
 		backendIdleTimeout: utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0),
 		maxRequests:        maxRequests,
 		maxResponses:       maxResponses,
+		maxRequestBytes:    maxRequestBytes,
+		maxResponseBytes:   maxResponseBytes,
+		packetSize:         this.packetSize,
 		scheduler:          this.scheduler,
 		notifyClosed: func() {
 			this.remove <- clientAddr
 		},
-		serverConn: this.serverConn,
+		serverConn: serverConn,
+		clientConn: clientConn,
 		clientAddr: clientAddr,
 		backend:    backend,
+		dialer:     this.backendDialer,
 	}
 
 	err = session.start()
@@ -310,9 +552,26 @@ func (this *Server) Stop() {
 	log.Info("Stopping ", this.name)
 
 	this.stopped = true
-	this.serverConn.Close()
+	for _, l := range this.listeners {
+		l.Close()
+	}
+	for _, c := range this.serverConns {
+		c.Close()
+	}
+	close(this.done)
 
 	this.scheduler.Stop()
 	this.statsHandler.Stop()
+	this.access.Stop()
+	if this.rateLimit != nil {
+		this.rateLimit.Unshare()
+	}
 	this.stop <- true
 }
+
+/**
+ * Udp server has no connections to drain, so just stop it
+ */
+func (this *Server) Drain(timeout time.Duration) {
+	this.Stop()
+}
@@ -7,12 +7,14 @@
 package udp
 
 import (
+	"errors"
 	"net"
 	"sync/atomic"
 	"time"
 
 	"../../core"
 	"../../logging"
+	"../../utils/backendbind"
 	"../scheduler"
 )
 
@@ -36,12 +38,27 @@ type session struct {
 	/* max number of backend responses */
 	maxResponses uint64
 
+	/* max bytes of client requests */
+	maxRequestBytes uint64
+
+	/* actually sent bytes of client requests */
+	_sentRequestBytes uint64
+
+	/* max bytes of backend responses */
+	maxResponseBytes uint64
+
+	/* max size of backend response datagram, larger ones are dropped */
+	packetSize int
+
 	/* scheduler */
 	scheduler *scheduler.Scheduler
 
 	/* connection to send responses to client with */
 	serverConn *net.UDPConn
 
+	/* client dtls connection, responses are sent over it instead of server connection if not nil */
+	clientConn net.Conn
+
 	/* client address */
 	clientAddr net.UDPAddr
 
@@ -51,6 +68,9 @@ type session struct {
 	/* connection to previously elected backend */
 	backendConn *net.UDPConn
 
+	/* dialer of backend connection */
+	dialer *backendbind.Dialer
+
 	/* activity channel */
 	clientActivityC chan bool
 
@@ -70,26 +90,37 @@ func (s *session) start() error {
 
 	log := logging.For("udp/Session")
 
-	s.stopC = make(chan bool)
+	// Buffered, so stop is not lost while session goroutine is busy
+	s.stopC = make(chan bool, 1)
 	s.clientActivityC = make(chan bool)
 	s.clientLastActivity = time.Now()
 
+	if s.backend.IsUnix() {
+		s.scheduler.IncrementRefused(*s.backend)
+		return errors.New("Unix socket backend " + s.backend.Address() + " is not supported for udp")
+	}
+
 	backendAddr, err := net.ResolveUDPAddr("udp", s.backend.Target.String())
 
 	if err != nil {
+		s.scheduler.IncrementRefused(*s.backend)
 		log.Error("Error ResolveUDPAddr: ", err)
 		return err
 	}
 
-	backendConn, err := net.DialUDP("udp", nil, backendAddr)
+	backendConn, err := s.dialer.DialUDP(backendAddr)
 
 	if err != nil {
+		s.scheduler.IncrementRefused(*s.backend)
 		log.Debug("Error connecting to backend: ", err)
 		return err
 	}
 
 	s.backendConn = backendConn
 
+	/* Session is backend connection, counted until it's closed */
+	s.scheduler.IncrementConnection(*s.backend)
+
 	/**
 	 * Update time and wait for stop
 	 */
@@ -117,6 +148,10 @@ func (s *session) start() error {
 				stopped = true
 				log.Debug("Closing client session: ", s.clientAddr)
 				s.backendConn.Close()
+				if s.clientConn != nil {
+					s.clientConn.Close()
+				}
+				s.scheduler.DecrementConnection(*s.backend)
 				s.notifyClosed()
 				if t != nil {
 					t.Stop()
@@ -132,8 +167,9 @@ func (s *session) start() error {
 	 * Proxy data from backend to client
 	 */
 	go func() {
-		buf := make([]byte, UDP_PACKET_SIZE)
+		buf := make([]byte, s.packetSize+1)
 		var responses uint64
+		var responseBytes uint64
 
 		for {
 			if s.backendIdleTimeout > 0 {
@@ -156,8 +192,26 @@ func (s *session) start() error {
 				return
 			}
 
+			if n > s.packetSize {
+				log.Debug("Dropping datagram exceeding ", s.packetSize, " bytes from backend ", s.backend.Target)
+				continue
+			}
+
+			if s.maxResponseBytes > 0 {
+				responseBytes += uint64(n)
+				if responseBytes > s.maxResponseBytes {
+					log.Debug("Backend ", s.backend.Target, " exceeded max response bytes for client ", s.clientAddr)
+					s.stop()
+					return
+				}
+			}
+
 			s.scheduler.IncrementRx(*s.backend, uint(n))
-			s.serverConn.WriteToUDP(buf[0:n], &s.clientAddr)
+			if s.clientConn != nil {
+				s.clientConn.Write(buf[0:n])
+			} else {
+				s.serverConn.WriteToUDP(buf[0:n], &s.clientAddr)
+			}
 
 			if s.maxResponses > 0 {
 				responses++
@@ -180,6 +234,12 @@ func (s *session) send(buf []byte) error {
 	default:
 	}
 
+	if s.maxRequestBytes > 0 && atomic.AddUint64(&s._sentRequestBytes, uint64(len(buf))) > s.maxRequestBytes {
+		logging.For("udp/Session").Debug("Client ", s.clientAddr, " exceeded max request bytes")
+		s.stop()
+		return nil
+	}
+
 	_, err := s.backendConn.Write(buf)
 	if err != nil {
 		return err
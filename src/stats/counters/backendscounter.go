@@ -72,7 +72,6 @@ func (this *BackendsBandwidthCounter) Start() {
 
 				// close channels
 				close(this.In)
-				close(this.Traffic)
 				close(this.Out)
 				return
 
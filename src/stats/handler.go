@@ -9,6 +9,7 @@ package stats
 import (
 	"../core"
 	"./counters"
+	"sync"
 	"time"
 )
 
@@ -33,24 +34,60 @@ type Handler struct {
 	/* Current stats */
 	latestStats Stats
 
+	/* Subscribers receiving stats snapshot every interval */
+	subscribers struct {
+		sync.Mutex
+		channels map[chan Stats]bool
+		stopped  bool
+	}
+
+	/* Throttled bytes counter, if server is throttled */
+	Throttle ThrottleCounter
+
+	/* Server current connections counter */
+	Clients ClientsCounter
+
+	/* Ended or rejected connections counters by reason */
+	disconnects struct {
+		sync.Mutex
+		counts map[string]uint64
+	}
+
 	/* ----- channels ----- */
 
 	/* Server traffic data */
 	Traffic chan core.ReadWriteCount
 
-	/* Server current connections count */
-	Connections chan uint
-
 	/* Current backends pool */
 	Backends chan []core.Backend
 
+	/* Retries to connect to the next backend */
+	DialRetries chan uint64
+
 	/* Channel for indicating stop request */
 	stopChan chan bool
 
+	/* Closed when handler stops, so traffic is not forwarded to stopped counters */
+	stopped chan bool
+
 	/* Input channel for latest stats */
 	ServerStats chan counters.BandwidthStats
 }
 
+/**
+ * Counter of bytes delayed by throttling
+ */
+type ThrottleCounter interface {
+	Throttled() (rx uint64, tx uint64)
+}
+
+/**
+ * Counter of current client connections
+ */
+type ClientsCounter interface {
+	Count() uint
+}
+
 /**
  * Creates new stats handler for the server
  * with name 'name'
@@ -61,18 +98,23 @@ func NewHandler(name string) *Handler {
 		name:        name,
 		ServerStats: make(chan counters.BandwidthStats, 1),
 		Traffic:     make(chan core.ReadWriteCount),
-		Connections: make(chan uint),
 		Backends:    make(chan []core.Backend),
+		DialRetries: make(chan uint64),
 		stopChan:    make(chan bool),
+		stopped:     make(chan bool),
 		latestStats: Stats{
-			RxTotal:  0,
-			TxTotal:  0,
-			RxSecond: 0,
-			TxSecond: 0,
-			Backends: []core.Backend{},
+			RxTotal:     0,
+			TxTotal:     0,
+			RxSecond:    0,
+			TxSecond:    0,
+			Backends:    []core.Backend{},
+			Disconnects: map[string]uint64{},
 		},
 	}
 
+	handler.subscribers.channels = make(map[chan Stats]bool)
+	handler.disconnects.counts = make(map[string]uint64)
+
 	handler.serverCounter = counters.NewBandwidthCounter(INTERVAL, handler.ServerStats)
 	handler.BackendsCounter = counters.NewBackendsBandwidthCounter()
 
@@ -83,6 +125,13 @@ func NewHandler(name string) *Handler {
 	return handler
 }
 
+/**
+ * Returns name handler is registered with
+ */
+func (this *Handler) Name() string {
+	return this.name
+}
+
 /**
  * Start handler work asynchroniously
  */
@@ -99,17 +148,29 @@ func (this *Handler) Start() {
 			/* stop stats processor requested */
 			case <-this.stopChan:
 
+				close(this.stopped)
 				this.serverCounter.Stop()
 				this.BackendsCounter.Stop()
 
+				// handler with the same name may be created already
 				Store.Lock()
-				delete(Store.handlers, this.name)
+				if Store.handlers[this.name] == this {
+					delete(Store.handlers, this.name)
+				}
 				Store.Unlock()
 
 				// close channels
 				close(this.ServerStats)
 				close(this.Traffic)
-				close(this.Connections)
+
+				// close subscribers
+				this.subscribers.Lock()
+				for ch := range this.subscribers.channels {
+					close(ch)
+				}
+				this.subscribers.channels = nil
+				this.subscribers.stopped = true
+				this.subscribers.Unlock()
 				return
 
 			/* New server stats available */
@@ -118,21 +179,36 @@ func (this *Handler) Start() {
 				this.latestStats.TxTotal = b.TxTotal
 				this.latestStats.RxSecond = b.RxSecond
 				this.latestStats.TxSecond = b.TxSecond
+				if this.Throttle != nil {
+					this.latestStats.RxThrottledTotal, this.latestStats.TxThrottledTotal = this.Throttle.Throttled()
+				}
+				if this.Clients != nil {
+					this.latestStats.ActiveConnections = this.Clients.Count()
+				}
+				this.latestStats.Disconnects = this.disconnectsSnapshot()
+				this.publish()
 
 			/* New server backends with stats available */
 			case backends := <-this.Backends:
 				this.latestStats.Backends = backends
 
-			/* New sever connections count available */
-			case connections := <-this.Connections:
-				this.latestStats.ActiveConnections = connections
+			/* New dial retries happened */
+			case n := <-this.DialRetries:
+				this.latestStats.DialRetriesTotal += n
 
 			/* New traffic stats available */
 			case rwc := <-this.Traffic:
 				// forward to counters
 				go func() {
-					this.serverCounter.Traffic <- rwc
-					this.BackendsCounter.Traffic <- rwc
+					select {
+					case this.serverCounter.Traffic <- rwc:
+					case <-this.stopped:
+						return
+					}
+					select {
+					case this.BackendsCounter.Traffic <- rwc:
+					case <-this.stopped:
+					}
 				}()
 			}
 		}
@@ -140,6 +216,79 @@ func (this *Handler) Start() {
 
 }
 
+/**
+ * Subscribe to stats snapshots sent every interval, starting with current one.
+ * Channel is closed when handler stops. Returned func unsubscribes
+ */
+func (this *Handler) Subscribe() (<-chan Stats, func()) {
+
+	ch := make(chan Stats, 1)
+
+	this.subscribers.Lock()
+	defer this.subscribers.Unlock()
+
+	if this.subscribers.stopped {
+		close(ch)
+		return ch, func() {}
+	}
+
+	ch <- this.latestStats
+	this.subscribers.channels[ch] = true
+
+	return ch, func() {
+		this.subscribers.Lock()
+		defer this.subscribers.Unlock()
+
+		if this.subscribers.channels[ch] {
+			delete(this.subscribers.channels, ch)
+			close(ch)
+		}
+	}
+}
+
+/**
+ * Send latest stats to subscribers, skipping ones not
+ * received previous snapshot yet
+ */
+func (this *Handler) publish() {
+
+	this.subscribers.Lock()
+	defer this.subscribers.Unlock()
+
+	for ch := range this.subscribers.channels {
+		select {
+		case ch <- this.latestStats:
+		default:
+		}
+	}
+}
+
+/**
+ * Counts client connection ended or rejected with reason,
+ * safe to call from any goroutine and after handler is stopped
+ */
+func (this *Handler) Disconnected(reason string) {
+	this.disconnects.Lock()
+	this.disconnects.counts[reason]++
+	this.disconnects.Unlock()
+}
+
+/**
+ * Returns copy of current disconnects counters
+ */
+func (this *Handler) disconnectsSnapshot() map[string]uint64 {
+
+	this.disconnects.Lock()
+	defer this.disconnects.Unlock()
+
+	result := make(map[string]uint64, len(this.disconnects.counts))
+	for reason, n := range this.disconnects.counts {
+		result[reason] = n
+	}
+
+	return result
+}
+
 /**
  * Request handler stop and clear resources
  */
@@ -30,6 +30,18 @@ type Stats struct {
 	/* Transmitted bytes to backend / second */
 	TxSecond uint `json:"tx_second"`
 
+	/* Total received bytes from backend delayed by throttling */
+	RxThrottledTotal uint64 `json:"rx_throttled_total"`
+
+	/* Total transmitted bytes to backend delayed by throttling */
+	TxThrottledTotal uint64 `json:"tx_throttled_total"`
+
+	/* Total retries to connect to the next backend after failed one */
+	DialRetriesTotal uint64 `json:"dial_retries_total"`
+
+	/* Total ended or rejected client connections by reason */
+	Disconnects map[string]uint64 `json:"disconnects"`
+
 	/* Current backends pool */
 	Backends []core.Backend `json:"backends"`
 }
@@ -32,3 +32,37 @@ func GetStats(name string) interface{} {
 	}
 	return handler.latestStats // TODO: syncronize?
 }
+
+/**
+ * Subscribe to stats snapshots of the server, sent every stats interval.
+ * Returns false if server is not found
+ */
+func Subscribe(name string) (<-chan Stats, func(), bool) {
+
+	Store.RLock()
+	handler, ok := Store.handlers[name]
+	Store.RUnlock()
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	ch, unsubscribe := handler.Subscribe()
+	return ch, unsubscribe, true
+}
+
+/**
+ * Get stats for all servers
+ */
+func GetAllStats() map[string]Stats {
+
+	Store.RLock()
+	defer Store.RUnlock()
+
+	result := make(map[string]Stats, len(Store.handlers))
+	for name, handler := range Store.handlers {
+		result[name] = handler.latestStats
+	}
+
+	return result
+}
@@ -0,0 +1,307 @@
+/**
+ * otlp.go - exporting finished spans with OTLP/HTTP JSON
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"../config"
+	"../logging"
+)
+
+/**
+ * Max spans sent in one export request
+ */
+const maxBatch = 512
+
+/**
+ * Queues finished spans and exports them in batches every interval
+ */
+type exporter struct {
+
+	/* Count of spans dropped since last export because queue was full,
+	 * first to be 64-bit aligned for atomic operations */
+	dropped uint64
+
+	endpoint string
+	service  string
+	headers  map[string]string
+	interval time.Duration
+	client   *http.Client
+
+	/* Finished spans waiting for export */
+	queue chan *Span
+
+	stopping chan bool
+	stopped  chan bool
+}
+
+/**
+ * Creates exporter and starts exporting
+ */
+func newExporter(cfg config.TracingConfig) *exporter {
+
+	this := &exporter{
+		endpoint: cfg.Endpoint,
+		service:  cfg.ServiceName,
+		headers:  cfg.Headers,
+		interval: durationOrDefault(cfg.Interval, DEFAULT_INTERVAL),
+		client:   &http.Client{Timeout: durationOrDefault(cfg.Timeout, DEFAULT_TIMEOUT)},
+		stopping: make(chan bool),
+		stopped:  make(chan bool),
+	}
+
+	if this.endpoint == "" {
+		this.endpoint = DEFAULT_ENDPOINT
+	}
+
+	if this.service == "" {
+		this.service = DEFAULT_SERVICE_NAME
+	}
+
+	max := cfg.MaxQueue
+	if max == 0 {
+		max = DEFAULT_MAX_QUEUE
+	}
+	this.queue = make(chan *Span, max)
+
+	go this.loop()
+
+	return this
+}
+
+/**
+ * Queues finished span, dropping it if queue is full
+ */
+func (this *exporter) add(span *Span) {
+	select {
+	case this.queue <- span:
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+	}
+}
+
+/**
+ * Exports queued spans and stops exporting. Spans finished later are dropped
+ */
+func (this *exporter) stop() {
+	close(this.stopping)
+	<-this.stopped
+}
+
+/**
+ * Collects finished spans and exports them every interval or once batch is full
+ */
+func (this *exporter) loop() {
+
+	log := logging.For("tracing")
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatch)
+
+	flush := func() {
+		if dropped := atomic.SwapUint64(&this.dropped, 0); dropped > 0 {
+			log.Warn("Dropped ", dropped, " spans, queue is full")
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := this.export(batch); err != nil {
+			log.Error("Failed to export ", len(batch), " spans to ", this.endpoint, ": ", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-this.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatch {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+
+		case <-this.stopping:
+		drain:
+			for {
+				select {
+				case span := <-this.queue:
+					batch = append(batch, span)
+					if len(batch) >= maxBatch {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			close(this.stopped)
+			return
+		}
+	}
+}
+
+/**
+ * Sends spans to collector
+ */
+func (this *exporter) export(spans []*Span) error {
+
+	body, err := json.Marshal(this.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", this.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range this.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New(resp.Status + " " + strings.TrimSpace(string(body)))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+/**
+ * Encodes spans as ExportTraceServiceRequest
+ */
+func (this *exporter) encode(spans []*Span) map[string]interface{} {
+
+	hostname, _ := os.Hostname()
+
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		encoded = append(encoded, encodeSpan(span))
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": encodeAttributes([]attribute{
+						{"service.name", this.service},
+						{"host.name", hostname},
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "gobetween"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+/**
+ * Encodes span in OTLP JSON form
+ */
+func encodeSpan(span *Span) map[string]interface{} {
+
+	span.Lock()
+	defer span.Unlock()
+
+	encoded := map[string]interface{}{
+		"traceId":           hex.EncodeToString(span.traceId[:]),
+		"spanId":            hex.EncodeToString(span.spanId[:]),
+		"name":              span.name,
+		"kind":              span.kind,
+		"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+		"attributes":        encodeAttributes(span.attributes),
+	}
+
+	if span.parentId != [8]byte{} {
+		encoded["parentSpanId"] = hex.EncodeToString(span.parentId[:])
+	}
+
+	if len(span.events) > 0 {
+		events := make([]map[string]interface{}, 0, len(span.events))
+		for _, e := range span.events {
+			events = append(events, map[string]interface{}{
+				"name":         e.name,
+				"timeUnixNano": strconv.FormatInt(e.time.UnixNano(), 10),
+			})
+		}
+		encoded["events"] = events
+	}
+
+	if span.status != statusUnset {
+		encoded["status"] = map[string]interface{}{
+			"code":    span.status,
+			"message": span.message,
+		}
+	}
+
+	return encoded
+}
+
+/**
+ * Encodes attributes as KeyValue list
+ */
+func encodeAttributes(attributes []attribute) []map[string]interface{} {
+
+	encoded := make([]map[string]interface{}, 0, len(attributes))
+
+	for _, a := range attributes {
+		var value map[string]interface{}
+
+		switch v := a.value.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case uint:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(uint64(v), 10)}
+		case uint64:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+		default:
+			continue
+		}
+
+		encoded = append(encoded, map[string]interface{}{"key": a.key, "value": value})
+	}
+
+	return encoded
+}
@@ -0,0 +1,459 @@
+/**
+ * tracing.go - OpenTelemetry spans of proxied sessions
+ */
+
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../config"
+	"../logging"
+	"../utils"
+)
+
+/**
+ * Span kinds, as in OTLP
+ */
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+/**
+ * Span status codes, as in OTLP
+ */
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+const (
+	/* Default OTLP/HTTP traces endpoint */
+	DEFAULT_ENDPOINT = "http://localhost:4318/v1/traces"
+
+	/* Default service.name resource attribute */
+	DEFAULT_SERVICE_NAME = "gobetween"
+
+	/* Default interval to export finished spans */
+	DEFAULT_INTERVAL = 5 * time.Second
+
+	/* Default export request timeout */
+	DEFAULT_TIMEOUT = 10 * time.Second
+
+	/* Default max finished spans waiting for export */
+	DEFAULT_MAX_QUEUE = 2048
+)
+
+/**
+ * Process-wide tracer configured with [tracing] section
+ */
+var Global = &Tracer{}
+
+/**
+ * Tracer starts spans and exports finished ones, if enabled
+ */
+type Tracer struct {
+	sync.RWMutex
+
+	cfg config.TracingConfig
+
+	/* Exporter of finished spans, nil if disabled */
+	exporter *exporter
+
+	/* Root spans with lower trace id are sampled */
+	threshold uint64
+}
+
+/**
+ * Span of session or its step. All methods may be called on
+ * nil span, which is returned if tracing is disabled or span is not sampled
+ */
+type Span struct {
+	sync.Mutex
+
+	exporter *exporter
+
+	traceId  [16]byte
+	spanId   [8]byte
+	parentId [8]byte
+
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes []attribute
+	events     []event
+	status     int
+	message    string
+	ended      bool
+}
+
+/**
+ * Span attribute
+ */
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+/**
+ * Span event
+ */
+type event struct {
+	name string
+	time time.Time
+}
+
+/**
+ * Applies configuration, stopping current exporter after exporting its spans
+ */
+func (this *Tracer) Configure(cfg config.TracingConfig) error {
+
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.exporter != nil && tracingConfigEqual(cfg, this.cfg) {
+		return nil
+	}
+
+	if this.exporter != nil {
+		this.exporter.stop()
+		this.exporter = nil
+	}
+
+	this.cfg = cfg
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	ratio := 1.0
+	if cfg.SamplingRatio != nil {
+		ratio = *cfg.SamplingRatio
+	}
+
+	this.threshold = uint64(ratio * (1 << 63))
+	if ratio >= 1 {
+		this.threshold = 1<<63 - 1
+	}
+
+	this.exporter = newExporter(cfg)
+
+	logging.For("tracing").Info("Exporting spans to ", this.exporter.endpoint, " sampling ", ratio)
+
+	return nil
+}
+
+/**
+ * Exports spans finished so far and stops exporting
+ */
+func (this *Tracer) Stop() {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.exporter != nil {
+		this.exporter.stop()
+		this.exporter = nil
+	}
+}
+
+/**
+ * Returns count of finished spans waiting for export
+ */
+func (this *Tracer) Queued() int {
+
+	this.RLock()
+	defer this.RUnlock()
+
+	if this.exporter == nil {
+		return 0
+	}
+
+	return len(this.exporter.queue)
+}
+
+/**
+ * Starts span of session. If traceparent of W3C Trace Context is set, span is
+ * a child of it and is sampled if parent is, otherwise it's a sampled root span.
+ * Returns nil if tracing is disabled or span is not sampled
+ */
+func (this *Tracer) Start(name string, kind int, traceparent string) *Span {
+
+	this.RLock()
+	exporter := this.exporter
+	threshold := this.threshold
+	this.RUnlock()
+
+	if exporter == nil {
+		return nil
+	}
+
+	span := &Span{
+		exporter: exporter,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+
+	if traceId, parentId, sampled, ok := parseTraceparent(traceparent); ok {
+		if !sampled {
+			return nil
+		}
+		span.traceId = traceId
+		span.parentId = parentId
+	} else {
+		rand.Read(span.traceId[:])
+		if binary.BigEndian.Uint64(span.traceId[8:])>>1 >= threshold {
+			return nil
+		}
+	}
+
+	rand.Read(span.spanId[:])
+
+	return span
+}
+
+/**
+ * Starts child span of the same trace
+ */
+func (this *Span) Child(name string, kind int) *Span {
+
+	if this == nil {
+		return nil
+	}
+
+	child := &Span{
+		exporter: this.exporter,
+		traceId:  this.traceId,
+		parentId: this.spanId,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+
+	rand.Read(child.spanId[:])
+
+	return child
+}
+
+/**
+ * Sets attribute, value is string, bool, float64 or any integer
+ */
+func (this *Span) SetAttribute(key string, value interface{}) {
+
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	for i := range this.attributes {
+		if this.attributes[i].key == key {
+			this.attributes[i].value = value
+			return
+		}
+	}
+
+	this.attributes = append(this.attributes, attribute{key, value})
+}
+
+/**
+ * Sets <prefix>.address and <prefix>.port attributes of host:port address, i.e. client or server
+ */
+func (this *Span) SetAddress(prefix string, address string) {
+
+	if this == nil {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		this.SetAttribute(prefix+".address", address)
+		return
+	}
+
+	this.SetAttribute(prefix+".address", host)
+	if p, err := strconv.Atoi(port); err == nil {
+		this.SetAttribute(prefix+".port", p)
+	}
+}
+
+/**
+ * Adds event happened now
+ */
+func (this *Span) AddEvent(name string) {
+
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.events = append(this.events, event{name, time.Now()})
+}
+
+/**
+ * Marks span as failed with message
+ */
+func (this *Span) SetError(message string) {
+
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	this.status = statusError
+	this.message = message
+}
+
+/**
+ * Finishes span and queues it for export. Next calls are ignored
+ */
+func (this *Span) End() {
+
+	if this == nil {
+		return
+	}
+
+	this.Lock()
+	if this.ended {
+		this.Unlock()
+		return
+	}
+	this.ended = true
+	this.end = time.Now()
+	this.Unlock()
+
+	this.exporter.add(this)
+}
+
+/**
+ * Returns W3C Trace Context traceparent of span, to pass it to backend
+ */
+func (this *Span) Traceparent() string {
+
+	if this == nil {
+		return ""
+	}
+
+	return "00-" + hex.EncodeToString(this.traceId[:]) + "-" + hex.EncodeToString(this.spanId[:]) + "-01"
+}
+
+/**
+ * Parses W3C Trace Context traceparent header value
+ */
+func parseTraceparent(value string) (traceId [16]byte, parentId [8]byte, sampled bool, ok bool) {
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+
+	if parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+
+	if _, err := hex.Decode(traceId[:], []byte(parts[1])); err != nil || traceId == [16]byte{} {
+		return
+	}
+
+	if _, err := hex.Decode(parentId[:], []byte(parts[2])); err != nil || parentId == [8]byte{} {
+		return
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return
+	}
+
+	return traceId, parentId, flags[0]&1 == 1, true
+}
+
+/**
+ * Validates tracing configuration
+ */
+func Validate(cfg config.TracingConfig) error {
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("endpoint should be http(s) url")
+		}
+	}
+
+	if cfg.SamplingRatio != nil && (*cfg.SamplingRatio < 0 || *cfg.SamplingRatio > 1) {
+		return errors.New("sampling_ratio should be from 0 to 1")
+	}
+
+	for _, d := range []string{cfg.Interval, cfg.Timeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errors.New("interval and timeout should be positive durations")
+		}
+	}
+
+	if cfg.MaxQueue < 0 {
+		return errors.New("max_queue should not be negative")
+	}
+
+	return nil
+}
+
+/**
+ * Checks if configurations are the same
+ */
+func tracingConfigEqual(a config.TracingConfig, b config.TracingConfig) bool {
+
+	if a.Enabled != b.Enabled || a.Endpoint != b.Endpoint || a.ServiceName != b.ServiceName ||
+		a.Interval != b.Interval || a.Timeout != b.Timeout || a.MaxQueue != b.MaxQueue || len(a.Headers) != len(b.Headers) {
+		return false
+	}
+
+	if (a.SamplingRatio == nil) != (b.SamplingRatio == nil) || (a.SamplingRatio != nil && *a.SamplingRatio != *b.SamplingRatio) {
+		return false
+	}
+
+	for k, v := range a.Headers {
+		if b.Headers[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+ * Returns duration of configuration or default
+ */
+func durationOrDefault(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	return utils.ParseDurationOrDefault(value, def)
+}
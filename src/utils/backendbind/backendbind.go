@@ -0,0 +1,204 @@
+/**
+ * backendbind.go - connecting to backends from chosen local addresses and interface
+ */
+
+package backendbind
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"../../config"
+)
+
+/**
+ * Dialer of backend connections, binding them to source ips of backend_bind,
+ * spread round robin, and to its interface, and connecting to host names
+ * with happy eyeballs if enabled. Dials as net.Dialer does if neither is set
+ */
+type Dialer struct {
+
+	/* Connecting timeout, 0 means no timeout */
+	Timeout time.Duration
+
+	/* Source ips pool */
+	ips []net.IP
+
+	/* Interface name to bind sockets to */
+	iface string
+
+	/* Counter choosing next source ip */
+	next uint32
+
+	/* Happy eyeballs options, nil if disabled */
+	happyEyeballs *happyEyeballs
+}
+
+/**
+ * Creates dialer, cfg and happyEyeballs may be nil
+ */
+func New(cfg *config.BackendBindConfig, happyEyeballs *config.HappyEyeballsConfig, timeout time.Duration) (*Dialer, error) {
+
+	dialer := &Dialer{
+		Timeout: timeout,
+	}
+
+	if happyEyeballs != nil {
+		var err error
+		if dialer.happyEyeballs, err = newHappyEyeballs(happyEyeballs); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg == nil {
+		return dialer, nil
+	}
+
+	for _, address := range cfg.Addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, errors.New("backend_bind.addresses: " + address + " is not an ip")
+		}
+		dialer.ips = append(dialer.ips, ip)
+	}
+
+	if cfg.Interface != "" && !supported {
+		return nil, errors.New("backend_bind.interface is supported on linux only")
+	}
+
+	if len(dialer.ips) == 0 && cfg.Interface == "" {
+		return nil, errors.New("backend_bind requires addresses or interface")
+	}
+
+	dialer.iface = cfg.Interface
+
+	return dialer, nil
+}
+
+/**
+ * Checks backend_bind and happy_eyeballs configuration
+ */
+func Check(cfg *config.BackendBindConfig, happyEyeballs *config.HappyEyeballsConfig) error {
+	_, err := New(cfg, happyEyeballs, 0)
+	return err
+}
+
+/**
+ * Connects to address
+ */
+func (this *Dialer) Dial(network string, address string) (net.Conn, error) {
+	return this.DialContext(context.Background(), network, address)
+}
+
+/**
+ * Connects to address, unix sockets are connected without binding
+ */
+func (this *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+
+	if network == "unix" {
+		return this.dialFrom(ctx, network, address, nil)
+	}
+
+	if this.happyEyeballs != nil && network == "tcp" {
+		if host, port, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) == nil {
+			return this.dialHappyEyeballs(ctx, host, port)
+		}
+	}
+
+	ip, err := this.source(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return this.dialFrom(ctx, network, address, ip)
+}
+
+/**
+ * Connects to address from source ip, if it's not nil
+ */
+func (this *Dialer) dialFrom(ctx context.Context, network string, address string, ip net.IP) (net.Conn, error) {
+
+	dialer := &net.Dialer{
+		Timeout: this.Timeout,
+	}
+
+	if network == "unix" {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	udp := network == "udp" || network == "udp4" || network == "udp6"
+
+	if ip != nil {
+		if udp {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+
+	if ip != nil || this.iface != "" {
+		dialer.Control = control(this.iface, ip != nil && !udp)
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+/**
+ * Connects udp socket to backend address
+ */
+func (this *Dialer) DialUDP(address *net.UDPAddr) (*net.UDPConn, error) {
+
+	conn, err := this.Dial("udp", address.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
+}
+
+/**
+ * Chooses next source ip for address, of the same family if address host is ip.
+ * Returns nil if there are no source ips, so kernel chooses it
+ */
+func (this *Dialer) source(address string) (net.IP, error) {
+
+	if len(this.ips) == 0 {
+		return nil, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	target := net.ParseIP(host)
+	if err != nil || target == nil {
+		// Host names are resolved by net.Dialer to addresses of source ip family
+		n := int(atomic.AddUint32(&this.next, 1))
+		return this.ips[n%len(this.ips)], nil
+	}
+
+	if ip := this.sourceOf(target); ip != nil {
+		return ip, nil
+	}
+
+	return nil, errors.New("No backend_bind address of the same family as " + host)
+}
+
+/**
+ * Chooses next source ip of the same family as target ip,
+ * nil if there are no source ips of it
+ */
+func (this *Dialer) sourceOf(target net.IP) net.IP {
+
+	n := int(atomic.AddUint32(&this.next, 1))
+	ipv4 := target.To4() != nil
+
+	for i := range this.ips {
+		ip := this.ips[(n+i)%len(this.ips)]
+		if (ip.To4() != nil) == ipv4 {
+			return ip
+		}
+	}
+
+	return nil
+}
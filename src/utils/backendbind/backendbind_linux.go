@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+/**
+ * backendbind_linux.go - binding backend sockets to interface with SO_BINDTODEVICE
+ */
+
+package backendbind
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const supported = true
+
+/**
+ * Returns socket control binding it to iface if set. When tcp socket
+ * is bound to source ip, IP_BIND_ADDRESS_NO_PORT delays choosing its port
+ * to connect, so ports are shared by connections to different backends
+ */
+func control(iface string, noPort bool) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			if iface != "" {
+				if err = unix.BindToDevice(int(fd), iface); err != nil {
+					return
+				}
+			}
+			if noPort {
+				// Not supported by old kernels, binding picks port then
+				unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, 1)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
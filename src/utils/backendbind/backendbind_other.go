@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * backendbind_other.go - binding to interface is available on linux only
+ */
+
+package backendbind
+
+import (
+	"syscall"
+)
+
+const supported = false
+
+/**
+ * Returns no-op socket control, interface is rejected on config check
+ */
+func control(iface string, noPort bool) func(string, string, syscall.RawConn) error {
+	return nil
+}
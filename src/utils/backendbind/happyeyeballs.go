@@ -0,0 +1,205 @@
+/**
+ * happyeyeballs.go - connecting to dual-stack host names with happy eyeballs (RFC 8305)
+ */
+
+package backendbind
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"../../config"
+)
+
+/**
+ * Default head start of connection attempt before next address is tried, as recommended by RFC 8305
+ */
+const defaultAttemptDelay = 250 * time.Millisecond
+
+/**
+ * Happy eyeballs options
+ */
+type happyEyeballs struct {
+
+	/* Head start of every attempt */
+	attemptDelay time.Duration
+
+	/* Family of addresses tried first */
+	preferIpv4 bool
+}
+
+/**
+ * Result of connection attempt
+ */
+type attempt struct {
+	conn net.Conn
+	err  error
+}
+
+/**
+ * Parses happy eyeballs options
+ */
+func newHappyEyeballs(cfg *config.HappyEyeballsConfig) (*happyEyeballs, error) {
+
+	result := &happyEyeballs{
+		attemptDelay: defaultAttemptDelay,
+	}
+
+	if cfg.AttemptDelay != "" {
+		delay, err := time.ParseDuration(cfg.AttemptDelay)
+		if err != nil || delay <= 0 {
+			return nil, errors.New("happy_eyeballs.attempt_delay should be positive duration")
+		}
+		result.attemptDelay = delay
+	}
+
+	switch cfg.Prefer {
+	case "", "ipv6":
+	case "ipv4":
+		result.preferIpv4 = true
+	default:
+		return nil, errors.New("happy_eyeballs.prefer should be \"ipv6\" or \"ipv4\"")
+	}
+
+	return result, nil
+}
+
+/**
+ * Resolves host to all its addresses and connects to them in order of families
+ * interleaved, starting next attempt when previous one fails or does not connect
+ * within attempt delay. The first established connection is returned, others are closed
+ */
+func (this *Dialer) dialHappyEyeballs(ctx context.Context, host string, port string) (net.Conn, error) {
+
+	if this.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.Timeout)
+		defer cancel()
+	}
+
+	resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, sources := this.orderAddresses(resolved)
+	if len(ips) == 0 {
+		return nil, errors.New("No backend_bind address of the same family as addresses of " + host)
+	}
+
+	// Established connections stop the rest of attempts
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attempt, len(ips))
+
+	next, pending := 0, 0
+
+	timer := time.NewTimer(this.happyEyeballs.attemptDelay)
+	defer timer.Stop()
+
+	startNext := func() {
+		ip, source := ips[next], sources[next]
+		go func() {
+			conn, err := this.dialFrom(ctx, "tcp", net.JoinHostPort(ip.String(), port), source)
+			results <- attempt{conn, err}
+		}()
+		next++
+		pending++
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(this.happyEyeballs.attemptDelay)
+	}
+
+	startNext()
+
+	var lastErr error
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				startNext()
+			}
+
+		case result := <-results:
+			pending--
+
+			if result.err == nil {
+				// Attempts connected in the meantime are not needed
+				if pending > 0 {
+					go closeAttempts(results, pending)
+				}
+				return result.conn, nil
+			}
+
+			lastErr = result.err
+
+			// Failed attempt gives its head start to the next one
+			if next < len(ips) {
+				startNext()
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+/**
+ * Orders resolved addresses interleaving families, starting with preferred one, and chooses
+ * source ip for every address. Addresses of family without source ips in pool are skipped
+ */
+func (this *Dialer) orderAddresses(resolved []net.IPAddr) ([]net.IP, []net.IP) {
+
+	preferred, other := []net.IP{}, []net.IP{}
+
+	for _, addr := range resolved {
+		if (addr.IP.To4() != nil) == this.happyEyeballs.preferIpv4 {
+			preferred = append(preferred, addr.IP)
+		} else {
+			other = append(other, addr.IP)
+		}
+	}
+
+	ips, sources := []net.IP{}, []net.IP{}
+
+	add := func(ip net.IP) {
+		var source net.IP
+		if len(this.ips) > 0 {
+			if source = this.sourceOf(ip); source == nil {
+				return
+			}
+		}
+		ips = append(ips, ip)
+		sources = append(sources, source)
+	}
+
+	for i := 0; i < len(preferred) || i < len(other); i++ {
+		if i < len(preferred) {
+			add(preferred[i])
+		}
+		if i < len(other) {
+			add(other[i])
+		}
+	}
+
+	return ips, sources
+}
+
+/**
+ * Closes connections of attempts still pending after another one won
+ */
+func closeAttempts(results chan attempt, pending int) {
+	for ; pending > 0; pending-- {
+		if result := <-results; result.err == nil {
+			result.conn.Close()
+		}
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"strings"
 )
 
 /**
  * Encode data based on format
- * Currently supported: toml and json
+ * Currently supported: toml, json and yaml
  */
 func Encode(in interface{}, out *string, format string) error {
 
@@ -33,6 +36,25 @@ func Encode(in interface{}, out *string, format string) error {
 		}
 		*out = string(buf)
 		return nil
+	case "yaml":
+		// Encoded as json first, so yaml has the same keys as json
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(buf))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+
+		if buf, err = yaml.Marshal(value); err != nil {
+			return err
+		}
+		*out = string(buf)
+		return nil
 	default:
 		return errors.New("Unknown format " + format)
 	}
@@ -40,17 +62,92 @@ func Encode(in interface{}, out *string, format string) error {
 
 /**
  * Decode data based on format
- * Currently supported: toml and json
+ * Currently supported: toml, json and yaml
  */
 func Decode(data string, out interface{}, format string) error {
+	return decode(data, out, format, false)
+}
+
+/**
+ * Decode data based on format, failing on keys out has no fields for
+ */
+func DecodeStrict(data string, out interface{}, format string) error {
+	return decode(data, out, format, true)
+}
+
+/**
+ * Decode data based on format, optionally failing on unknown keys
+ */
+func decode(data string, out interface{}, format string, strict bool) error {
 
 	switch format {
 	case "toml":
-		_, err := toml.Decode(data, out)
-		return err
+		meta, err := toml.Decode(data, out)
+		if err != nil || !strict {
+			return err
+		}
+
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := []string{}
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			return errors.New("Unknown keys " + strings.Join(keys, ", "))
+		}
+		return nil
 	case "json":
-		return json.Unmarshal([]byte(data), out)
+		return decodeJson([]byte(data), out, strict)
+	case "yaml":
+		// Decoded via json, so yaml has the same keys as json
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(data), &value); err != nil {
+			return err
+		}
+
+		buf, err := json.Marshal(yamlToJson(value))
+		if err != nil {
+			return err
+		}
+		return decodeJson(buf, out, strict)
 	default:
 		return errors.New("Unknown format " + format)
 	}
 }
+
+/**
+ * Decode json, optionally failing on unknown keys
+ */
+func decodeJson(data []byte, out interface{}, strict bool) error {
+
+	if !strict {
+		return json.Unmarshal(data, out)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(out)
+}
+
+/**
+ * Converts yaml maps having keys of any type to json objects
+ */
+func yamlToJson(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = yamlToJson(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = yamlToJson(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
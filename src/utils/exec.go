@@ -9,6 +9,8 @@ package utils
 
 import (
 	"../logging"
+	"io"
+	"os"
 	"os/exec"
 	"time"
 )
@@ -17,15 +19,29 @@ import (
  * Exec with timeout
  */
 func ExecTimeout(timeout time.Duration, params ...string) (string, error) {
+	return ExecTimeoutEnv(timeout, nil, params...)
+}
+
+/**
+ * Exec with timeout, adding env ("KEY=value") to current environment.
+ * Process is started in own process group, so on timeout its
+ * children are killed too and do not keep running
+ */
+func ExecTimeoutEnv(timeout time.Duration, env []string, params ...string) (string, error) {
 
 	log := logging.For("execTimeout")
 
 	cmd := exec.Command(params[0], params[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	setProcessGroup(cmd)
 
 	timer := time.AfterFunc(timeout, func() {
 		if cmd.Process != nil {
 			log.Info("Response from exec ", params, " is timed out. Killing process...")
-			cmd.Process.Kill()
+			killProcessGroup(cmd)
 		}
 	})
 
@@ -38,3 +54,37 @@ func ExecTimeout(timeout time.Duration, params ...string) (string, error) {
 
 	return string(out), nil
 }
+
+/**
+ * Starts long-running process in own process group, returning its stdout and stderr.
+ * Process should be stopped with KillProcess and then waited
+ */
+func ExecStart(params ...string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+
+	cmd := exec.Command(params[0], params[1:]...)
+
+	setProcessGroup(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cmd, stdout, stderr, nil
+}
+
+/**
+ * Kills process started with ExecStart together with its children
+ */
+func KillProcess(cmd *exec.Cmd) {
+	killProcessGroup(cmd)
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * exec_unix.go - process group handling of executed processes
+ */
+
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+/**
+ * Makes process to be started in own process group
+ */
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+/**
+ * Kills process group of started process
+ */
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,22 @@
+/**
+ * exec_windows.go - process groups are not used on windows, process is killed alone
+ */
+
+package utils
+
+import (
+	"os/exec"
+)
+
+/**
+ * Process is started as usual
+ */
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+/**
+ * Kills started process
+ */
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,180 @@
+/**
+ * geoip.go - country and ASN lookups in MaxMind databases
+ */
+
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+
+	"../../config"
+	"../../logging"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+/**
+ * Process-wide databases configured with [geoip] section
+ */
+var Global = &Databases{}
+
+/**
+ * Location of ip address. Fields are empty if database
+ * is not configured or has no record of the address
+ */
+type Location struct {
+
+	/* ISO 3166-1 country code, upper case */
+	Country string
+
+	/* Continent code, upper case, i.e. EU */
+	Continent string
+
+	/* Autonomous system number */
+	Asn uint
+}
+
+/**
+ * Record fields of GeoLite2 / GeoIP2 Country, City and ASN databases
+ */
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+
+	Asn uint `maxminddb:"autonomous_system_number"`
+}
+
+/**
+ * Country and ASN databases, reloaded when their files change
+ */
+type Databases struct {
+	sync.RWMutex
+
+	cfg     config.GeoipConfig
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+
+	/* Watches databases files, nil if none configured */
+	watcher *fsnotify.Watcher
+}
+
+/**
+ * Opens databases of configuration and starts watching their files.
+ * Keeps current databases and returns error if any of new ones can't be opened
+ */
+func (this *Databases) Configure(cfg config.GeoipConfig) error {
+
+	this.Lock()
+	defer this.Unlock()
+
+	if cfg == this.cfg {
+		return nil
+	}
+
+	country, err := open(cfg.CountryDatabase)
+	if err != nil {
+		return err
+	}
+
+	asn, err := open(cfg.AsnDatabase)
+	if err != nil {
+		return err
+	}
+
+	var watcher *fsnotify.Watcher
+	if cfg.CountryDatabase != "" || cfg.AsnDatabase != "" {
+		if watcher, err = this.watch(cfg); err != nil {
+			return err
+		}
+		logging.For("geoip").Info("Opened geoip databases ", cfg.CountryDatabase, " ", cfg.AsnDatabase)
+	}
+
+	if this.watcher != nil {
+		this.watcher.Close()
+	}
+
+	this.cfg = cfg
+	this.country = country
+	this.asn = asn
+	this.watcher = watcher
+
+	return nil
+}
+
+/**
+ * Returns location of ip
+ */
+func (this *Databases) Lookup(ip net.IP) Location {
+
+	this.RLock()
+	defer this.RUnlock()
+
+	var location Location
+
+	if this.country != nil {
+		var r record
+		if err := this.country.Lookup(ip, &r); err == nil {
+			location.Country = r.Country.IsoCode
+			if location.Country == "" {
+				location.Country = r.RegisteredCountry.IsoCode
+			}
+			location.Continent = r.Continent.Code
+		}
+	}
+
+	if this.asn != nil {
+		var r record
+		if err := this.asn.Lookup(ip, &r); err == nil {
+			location.Asn = r.Asn
+		}
+	}
+
+	location.Country = strings.ToUpper(location.Country)
+	location.Continent = strings.ToUpper(location.Continent)
+
+	return location
+}
+
+/**
+ * Checks if database file can be opened
+ */
+func CheckDatabase(path string) error {
+	_, err := open(path)
+	return err
+}
+
+/**
+ * Reads whole database file, so replacing or rewriting it does not
+ * affect current reader. Returns nil reader if path is empty
+ */
+func open(path string) (*maxminddb.Reader, error) {
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,98 @@
+/**
+ * watch.go - reloading geoip databases when their files change
+ */
+
+package geoip
+
+import (
+	"path/filepath"
+
+	"../../config"
+	"../../logging"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/**
+ * Starts watching databases files, reloading database when its file is written or replaced.
+ * Files directories are watched, since updaters usually replace files
+ */
+func (this *Databases) watch(cfg config.GeoipConfig) (*fsnotify.Watcher, error) {
+
+	log := logging.For("geoip")
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	for _, path := range []string{cfg.CountryDatabase, cfg.AsnDatabase} {
+		if path == "" {
+			continue
+		}
+
+		path = filepath.Clean(path)
+		paths[path] = true
+
+		if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+			fsWatcher.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+
+				path := filepath.Clean(event.Name)
+				if !paths[path] || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+
+				if err := this.reload(path); err != nil {
+					log.Error("Failed to reload geoip database ", path, ", keeping current: ", err)
+					continue
+				}
+
+				log.Info("Reloaded geoip database ", path)
+
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn("Error watching geoip databases: ", err)
+			}
+		}
+	}()
+
+	return fsWatcher, nil
+}
+
+/**
+ * Opens again database of path if it's still configured
+ */
+func (this *Databases) reload(path string) error {
+
+	reader, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if this.cfg.CountryDatabase != "" && filepath.Clean(this.cfg.CountryDatabase) == path {
+		this.country = reader
+	}
+
+	if this.cfg.AsnDatabase != "" && filepath.Clean(this.cfg.AsnDatabase) == path {
+		this.asn = reader
+	}
+
+	return nil
+}
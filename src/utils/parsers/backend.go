@@ -16,7 +16,7 @@ import (
 )
 
 const (
-	DEFAULT_BACKEND_PATTERN = `^(?P<host>\S+):(?P<port>\d+)(\sweight=(?P<weight>\d+))?(\spriority=(?P<priority>\d+))?(\ssni=(?P<sni>[^\s]+))?$`
+	DEFAULT_BACKEND_PATTERN = `^(?:(?P<unix>unix:/\S+)|(?P<host>\S+):(?P<port>\d+))(\sweight=(?P<weight>\d+))?(\spriority=(?P<priority>\d+))?(\ssni=(?P<sni>[^\s]+))?(\smax_connections=(?P<max_connections>\d+))?$`
 )
 
 /**
@@ -61,14 +61,27 @@ func ParseBackend(line string, pattern string) (*core.Backend, error) {
 		priority = 1
 	}
 
+	// no limit by default
+	maxConnections, _ := strconv.Atoi(result["max_connections"])
+
+	target := core.Target{
+		Host: result["host"],
+		Port: result["port"],
+	}
+
+	// Unix socket backend, i.e. unix:/var/run/app.sock, has no port
+	if result["unix"] != "" {
+		target = core.Target{Host: result["unix"]}
+	}
+
 	backend := core.Backend{
-		Target: core.Target{
-			Host: result["host"],
-			Port: result["port"],
-		},
+		Target:   target,
 		Weight:   weight,
 		Sni:      result["sni"],
 		Priority: priority,
+
+		MaxConnections: maxConnections,
+
 		Stats: core.BackendStats{
 			Live: true,
 		},
@@ -0,0 +1,225 @@
+/**
+ * accept.go - PROXY protocol header parsing on incoming connections
+ */
+
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	/* Max length of v1 header line including CRLF */
+	v1MaxHeaderLength = 107
+
+	/* Size of buffer for reading header */
+	readBufferSize = 512
+)
+
+/**
+ * Conn delegates all calls to net.Conn, but reads
+ * from buffered reader and returns addresses from PROXY protocol header
+ */
+type Conn struct {
+	net.Conn //delegate
+	reader   *bufio.Reader
+
+	/* Addresses from header, nil if header did not carry them */
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+/**
+ * Reads and parses PROXY protocol header (v1 or v2) from conn.
+ * Returns wrapped connection which RemoteAddr() and LocalAddr() reflect
+ * addresses of original client connection
+ */
+func Accept(conn net.Conn, readTimeout time.Duration) (net.Conn, error) {
+
+	if readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+	}
+
+	result := &Conn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, readBufferSize),
+	}
+
+	signature, err := result.reader.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(signature, v2Signature) {
+		result.remoteAddr, result.localAddr, err = readV2(result.reader)
+	} else {
+		result.remoteAddr, result.localAddr, err = readV1(result.reader)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Time{}) // Reset read deadline
+
+	return result, nil
+}
+
+/**
+ * Reads v1 header line and parses addresses from it
+ */
+func readV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	line := make([]byte, 0, v1MaxHeaderLength)
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+
+		if len(line) >= v1MaxHeaderLength {
+			return nil, nil, errors.New("Proxy protocol v1 header is too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("Proxy protocol v1 header should end with CRLF")
+	}
+
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+
+	if len(parts) < 2 || parts[0] != "PROXY" {
+		return nil, nil, errors.New("Not a proxy protocol header")
+	}
+
+	switch parts[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, errors.New("Unsupported proxy protocol v1 family " + parts[1])
+	}
+
+	if len(parts) != 6 {
+		return nil, nil, errors.New("Bad proxy protocol v1 header format")
+	}
+
+	src, err := parseV1Addr(parts[2], parts[4])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst, err := parseV1Addr(parts[3], parts[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+/**
+ * Parses ip and port parts of v1 header
+ */
+func parseV1Addr(ip string, port string) (*net.TCPAddr, error) {
+
+	parsedIp := net.ParseIP(ip)
+	if parsedIp == nil {
+		return nil, errors.New("Bad proxy protocol v1 address " + ip)
+	}
+
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.New("Bad proxy protocol v1 port " + port)
+	}
+
+	return &net.TCPAddr{IP: parsedIp, Port: int(parsedPort)}, nil
+}
+
+/**
+ * Reads binary v2 header and parses addresses from it
+ */
+func readV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+
+	versionCmd := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch versionCmd {
+	case v2VersionCmdLocal:
+		return nil, nil, nil
+	case v2VersionCmdProxy:
+	default:
+		return nil, nil, errors.New("Unsupported proxy protocol v2 version/command")
+	}
+
+	var ipLen int
+
+	switch family {
+	case v2FamilyTcpIpv4:
+		ipLen = net.IPv4len
+	case v2FamilyTcpIpv6:
+		ipLen = net.IPv6len
+	default:
+		// Other families (udp, unix) are not applicable for tcp connection, keep original
+		return nil, nil, nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, errors.New("Proxy protocol v2 header is too short")
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[0:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen : 2*ipLen+2])),
+	}
+
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2 : 2*ipLen+4])),
+	}
+
+	return src, dst, nil
+}
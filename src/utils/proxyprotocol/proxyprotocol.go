@@ -0,0 +1,133 @@
+/**
+ * proxyprotocol.go - PROXY protocol header encoding
+ *
+ * Package proxyprotocol implements PROXY protocol v1 (text) and v2 (binary)
+ * headers as described in http://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+ */
+
+package proxyprotocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+/**
+ * PROXY protocol v2 header signature
+ */
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	v2VersionCmdLocal = 0x20
+	v2VersionCmdProxy = 0x21
+
+	v2FamilyUnspec  = 0x00
+	v2FamilyTcpIpv4 = 0x11
+	v2FamilyTcpIpv6 = 0x21
+)
+
+/**
+ * Writes PROXY protocol header of the specified version ("v1" or "v2")
+ * describing connection from src to dst to w
+ */
+func WriteHeader(w io.Writer, version string, src net.Addr, dst net.Addr) error {
+
+	var header []byte
+	var err error
+
+	switch version {
+	case "v1":
+		header, err = HeaderV1(src, dst)
+	case "v2":
+		header, err = HeaderV2(src, dst)
+	default:
+		return errors.New("Unsupported proxy protocol version " + version)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(header)
+	return err
+}
+
+/**
+ * Builds human-readable v1 header.
+ * If addresses are not tcp, "UNKNOWN" header is returned
+ */
+func HeaderV1(src net.Addr, dst net.Addr) ([]byte, error) {
+
+	srcTcp, srcOk := src.(*net.TCPAddr)
+	dstTcp, dstOk := dst.(*net.TCPAddr)
+
+	if !srcOk || !dstOk {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	if srcIp, dstIp := srcTcp.IP.To4(), dstTcp.IP.To4(); srcIp != nil && dstIp != nil {
+		return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIp, dstIp, srcTcp.Port, dstTcp.Port)), nil
+	}
+
+	if srcTcp.IP.To16() == nil || dstTcp.IP.To16() == nil {
+		return nil, errors.New("Can't build proxy protocol header: invalid ip address")
+	}
+
+	return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", formatIpv6(srcTcp.IP), formatIpv6(dstTcp.IP), srcTcp.Port, dstTcp.Port)), nil
+}
+
+/**
+ * Formats ip as ipv6 address, even if it's ipv4 one
+ */
+func formatIpv6(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+/**
+ * Builds binary v2 header.
+ * If addresses are not tcp, LOCAL command header is returned
+ */
+func HeaderV2(src net.Addr, dst net.Addr) ([]byte, error) {
+
+	buf := bytes.NewBuffer(make([]byte, 0, 16+36))
+	buf.Write(v2Signature)
+
+	srcTcp, srcOk := src.(*net.TCPAddr)
+	dstTcp, dstOk := dst.(*net.TCPAddr)
+
+	if !srcOk || !dstOk {
+		buf.WriteByte(v2VersionCmdLocal)
+		buf.WriteByte(v2FamilyUnspec)
+		binary.Write(buf, binary.BigEndian, uint16(0))
+		return buf.Bytes(), nil
+	}
+
+	family := byte(v2FamilyTcpIpv4)
+	srcIp, dstIp := srcTcp.IP.To4(), dstTcp.IP.To4()
+
+	if srcIp == nil || dstIp == nil {
+		family = v2FamilyTcpIpv6
+		srcIp, dstIp = srcTcp.IP.To16(), dstTcp.IP.To16()
+	}
+
+	if srcIp == nil || dstIp == nil {
+		return nil, errors.New("Can't build proxy protocol header: invalid ip address")
+	}
+
+	buf.WriteByte(v2VersionCmdProxy)
+	buf.WriteByte(family)
+	binary.Write(buf, binary.BigEndian, uint16(len(srcIp)+len(dstIp)+4))
+	buf.Write(srcIp)
+	buf.Write(dstIp)
+	binary.Write(buf, binary.BigEndian, uint16(srcTcp.Port))
+	binary.Write(buf, binary.BigEndian, uint16(dstTcp.Port))
+
+	return buf.Bytes(), nil
+}
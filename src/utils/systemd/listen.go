@@ -0,0 +1,103 @@
+/**
+ * listen.go - sockets passed by systemd socket activation (sd_listen_fds)
+ */
+
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+/**
+ * Prefix of bind address referring to sockets passed by systemd,
+ * i.e. "systemd:gobetween.socket" or "systemd:http" for FileDescriptorName=http
+ */
+const BindPrefix = "systemd:"
+
+/**
+ * Passed sockets are taken from environment once and kept
+ * open, so they're reused when server is restarted on reload
+ */
+var passed struct {
+	once  sync.Once
+	files map[string][]*os.File
+}
+
+/**
+ * Checks if bind refers to sockets passed by systemd
+ */
+func IsSocketBind(bind string) bool {
+	return strings.HasPrefix(bind, BindPrefix)
+}
+
+/**
+ * Returns stream listeners passed by systemd with name of bind.
+ * Returned listeners are duplicates and may be closed as usual
+ */
+func Listeners(bind string) ([]net.Listener, error) {
+
+	files, err := passedFiles(bind)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := []net.Listener{}
+
+	for _, f := range files {
+		if l, err := net.FileListener(f); err == nil {
+			listeners = append(listeners, l)
+		}
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("No stream sockets passed by systemd for " + bind)
+	}
+
+	return listeners, nil
+}
+
+/**
+ * Returns udp socket passed by systemd with name of bind.
+ * Returned connection is duplicate and may be closed as usual
+ */
+func UDPConn(bind string) (*net.UDPConn, error) {
+
+	files, err := passedFiles(bind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if c, err := net.FilePacketConn(f); err == nil {
+			if conn, ok := c.(*net.UDPConn); ok {
+				return conn, nil
+			}
+			c.Close()
+		}
+	}
+
+	return nil, errors.New("No datagram sockets passed by systemd for " + bind)
+}
+
+/**
+ * Returns sockets passed by systemd with name of bind
+ */
+func passedFiles(bind string) ([]*os.File, error) {
+
+	passed.once.Do(func() {
+		passed.files = listenFds()
+	})
+
+	name := strings.TrimPrefix(bind, BindPrefix)
+
+	files, ok := passed.files[name]
+	if !ok {
+		return nil, errors.New("No sockets passed by systemd with name " + name)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,19 @@
+//go:build windows || plan9
+// +build windows plan9
+
+/**
+ * listen_other.go - systemd socket activation is not available on windows
+ */
+
+package systemd
+
+import (
+	"os"
+)
+
+/**
+ * No sockets are passed
+ */
+func listenFds() map[string][]*os.File {
+	return map[string][]*os.File{}
+}
@@ -0,0 +1,64 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/**
+ * listen_unix.go - taking sockets passed by systemd from environment
+ */
+
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/**
+ * First file descriptor passed by systemd (SD_LISTEN_FDS_START)
+ */
+const listenFdsStart = 3
+
+/**
+ * Takes sockets passed by systemd by their names from LISTEN_FDS,
+ * LISTEN_PID and LISTEN_FDNAMES, and unsets these variables
+ * so they're not seen by started processes
+ */
+func listenFds() map[string][]*os.File {
+
+	files := map[string][]*os.File{}
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return files
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return files
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+
+		fd := listenFdsStart + i
+
+		// Passed sockets should not be inherited by exec discovery and healthcheck processes
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		files[name] = append(files[name], os.NewFile(uintptr(fd), name))
+	}
+
+	return files
+}
@@ -0,0 +1,81 @@
+/**
+ * systemd.go - systemd service notifications (sd_notify) and watchdog
+ */
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+/**
+ * Sends state, i.e. "READY=1" or "WATCHDOG=1", to systemd.
+ * Does nothing if process is not started by systemd with notify access
+ */
+func Notify(state string) error {
+
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Abstract namespace socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+/**
+ * Returns interval systemd watchdog expects to be notified within,
+ * or 0 if watchdog is not enabled for the process
+ */
+func WatchdogInterval() time.Duration {
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+/**
+ * Notifies systemd watchdog at half of its interval until stop is closed.
+ * Does nothing if watchdog is not enabled
+ */
+func Watchdog(stop <-chan bool) {
+
+	interval := WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			Notify("WATCHDOG=1")
+		case <-stop:
+			return
+		}
+	}
+}
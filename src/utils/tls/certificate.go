@@ -0,0 +1,132 @@
+/**
+ * certificate.go - reloadable tls certificate
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"../../logging"
+)
+
+/**
+ * Certificate loaded from cert and key files, that
+ * can be reloaded without recreating tls config
+ */
+type Certificate struct {
+	sync.RWMutex
+
+	/* Paths to certificate and key files */
+	certPath string
+	keyPath  string
+
+	/* Current certificate */
+	certificate *tls.Certificate
+
+	/* Modification times of files current certificate was loaded from */
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+/**
+ * Loads certificate from files
+ */
+func NewCertificate(certPath, keyPath string) (*Certificate, error) {
+
+	c := &Certificate{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+/**
+ * Loads certificate from files again, keeping
+ * current one if loading fails
+ */
+func (this *Certificate) Reload() error {
+
+	certModTime, keyModTime := modTime(this.certPath), modTime(this.keyPath)
+
+	crt, err := tls.LoadX509KeyPair(this.certPath, this.keyPath)
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	this.certificate = &crt
+	this.certModTime = certModTime
+	this.keyModTime = keyModTime
+	this.Unlock()
+
+	return nil
+}
+
+/**
+ * Returns current certificate, to be used as tls.Config.GetCertificate
+ */
+func (this *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	this.RLock()
+	defer this.RUnlock()
+
+	return this.certificate, nil
+}
+
+/**
+ * Checks files modification times every interval
+ * and reloads certificate if files has changed, until stop
+ */
+func (this *Certificate) Watch(interval time.Duration, stop <-chan bool) {
+
+	log := logging.For("tls/certificate")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+
+			this.RLock()
+			changed := !modTime(this.certPath).Equal(this.certModTime) || !modTime(this.keyPath).Equal(this.keyModTime)
+			this.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := this.Reload(); err != nil {
+				log.Error("Failed to reload certificate ", this.certPath, ": ", err)
+				continue
+			}
+
+			log.Info("Reloaded certificate ", this.certPath)
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+/**
+ * Returns file modification time, or zero time if file can't be accessed
+ */
+func modTime(path string) time.Time {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
@@ -0,0 +1,154 @@
+/**
+ * certificates.go - reloadable tls certificates selected by sni
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"../../config"
+)
+
+/**
+ * Certificate with hostnames it's selected for
+ */
+type hostsCertificate struct {
+	*Certificate
+
+	/* Hostnames, "*.<domain>" matches any subdomain of domain */
+	hosts []string
+}
+
+/**
+ * Certificates of a listener. Certificate is selected by
+ * sni hostname requested by client, falling back to the default one
+ */
+type Certificates struct {
+
+	/* Certificates in order of configuration, first match wins */
+	certificates []hostsCertificate
+
+	/* Certificate used when no hosts of certificates match, may be nil */
+	fallback *Certificate
+}
+
+/**
+ * Loads certificates of tls config files, default one
+ * is cert_path / key_path pair if set, first of certificates otherwise
+ */
+func NewCertificates(cfg *config.Tls) (*Certificates, error) {
+
+	result := &Certificates{}
+
+	if cfg.CertPath != "" || cfg.KeyPath != "" {
+		fallback, err := NewCertificate(cfg.CertPath, cfg.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		result.fallback = fallback
+	}
+
+	for _, c := range cfg.Certificates {
+		certificate, err := NewCertificate(c.CertPath, c.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		result.certificates = append(result.certificates, hostsCertificate{certificate, c.Hosts})
+	}
+
+	if result.fallback == nil && len(result.certificates) > 0 {
+		result.fallback = result.certificates[0].Certificate
+	}
+
+	return result, nil
+}
+
+/**
+ * Returns certificate for sni hostname, to be used as tls.Config.GetCertificate
+ */
+func (this *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return this.Select(hello.ServerName)
+}
+
+/**
+ * Returns certificate of first certificates having hostname
+ * in hosts, or default certificate if none has
+ */
+func (this *Certificates) Select(hostname string) (*tls.Certificate, error) {
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	if hostname != "" {
+		for _, c := range this.certificates {
+			for _, host := range c.hosts {
+				if matchesHost(strings.ToLower(host), hostname) {
+					return c.GetCertificate(nil)
+				}
+			}
+		}
+	}
+
+	return this.fallback.GetCertificate(nil)
+}
+
+/**
+ * Loads all certificates from files again, keeping
+ * current ones that fail to load
+ */
+func (this *Certificates) Reload() error {
+
+	var result error
+
+	for _, c := range this.all() {
+		if err := c.Reload(); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+/**
+ * Watches all certificates files for changes every interval until stop
+ */
+func (this *Certificates) Watch(interval time.Duration, stop <-chan bool) {
+	for _, c := range this.all() {
+		go c.Watch(interval, stop)
+	}
+}
+
+/**
+ * Returns default certificate (if it's not one of certificates) and certificates
+ */
+func (this *Certificates) all() []*Certificate {
+
+	all := []*Certificate{}
+	if len(this.certificates) == 0 || this.fallback != this.certificates[0].Certificate {
+		all = append(all, this.fallback)
+	}
+
+	for _, c := range this.certificates {
+		all = append(all, c.Certificate)
+	}
+
+	return all
+}
+
+/**
+ * Checks if host pattern matches hostname. Wildcard
+ * "*.<domain>" matches single label subdomains of domain
+ */
+func matchesHost(host string, hostname string) bool {
+
+	if !strings.HasPrefix(host, "*.") {
+		return host == hostname
+	}
+
+	i := strings.Index(hostname, ".")
+
+	return i > 0 && hostname[i:] == host[1:]
+}
@@ -0,0 +1,193 @@
+/**
+ * clientauth.go - Tls client certificate authentication
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"regexp"
+
+	"../../config"
+)
+
+/**
+ * Configures tls config to authenticate clients by certificates
+ * issued by configured CA, not revoked in CRL and with
+ * common name or SAN matching one of allowed names patterns
+ */
+func ConfigureClientAuth(tlsConfig *tls.Config, cfg *config.TlsClientAuth) error {
+
+	cas, err := loadCertificates(cfg.CaCertPath)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	for _, ca := range cas {
+		tlsConfig.ClientCAs.AddCert(ca)
+	}
+
+	switch cfg.Mode {
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "verify":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return errors.New("Not supported client_auth mode " + cfg.Mode)
+	}
+
+	revoked := map[string]bool{}
+	if cfg.CrlPath != "" {
+		if revoked, err = loadRevoked(cfg.CrlPath, cas); err != nil {
+			return err
+		}
+	}
+
+	allowed := make([]*regexp.Regexp, 0, len(cfg.AllowedNames))
+	for _, name := range cfg.AllowedNames {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return err
+		}
+		allowed = append(allowed, re)
+	}
+
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+
+		// No certificate provided in verify mode
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return nil
+		}
+
+		for _, cert := range verifiedChains[0] {
+			if revoked[cert.SerialNumber.String()] {
+				return errors.New("Client certificate is revoked: " + cert.Subject.CommonName)
+			}
+		}
+
+		if len(allowed) == 0 {
+			return nil
+		}
+
+		leaf := verifiedChains[0][0]
+		names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+		names = append(names, leaf.EmailAddresses...)
+
+		for _, re := range allowed {
+			for _, name := range names {
+				if re.MatchString(name) {
+					return nil
+				}
+			}
+		}
+
+		return errors.New("Client certificate name is not allowed: " + leaf.Subject.CommonName)
+	}
+
+	return nil
+}
+
+/**
+ * Returns verified client identity, which is the common name
+ * of client certificate, or empty string if client is not verified
+ */
+func ClientIdentity(state tls.ConnectionState) string {
+
+	cert := ClientCertificate(state)
+	if cert == nil {
+		return ""
+	}
+
+	return cert.Subject.CommonName
+}
+
+/**
+ * Returns verified client certificate, or nil if client is not verified
+ */
+func ClientCertificate(state tls.ConnectionState) *x509.Certificate {
+
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+/**
+ * Loads all PEM certificates from file
+ */
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("No certificates found in " + path)
+	}
+
+	return certs, nil
+}
+
+/**
+ * Loads serial numbers of revoked certificates from
+ * CRL (PEM or DER) signed by one of CAs
+ */
+func loadRevoked(path string, cas []*x509.Certificate) (map[string]bool, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := false
+	for _, ca := range cas {
+		if ca.CheckCRLSignature(crl) == nil {
+			signed = true
+			break
+		}
+	}
+
+	if !signed {
+		return nil, errors.New("CRL is not signed by any of client_auth CAs: " + path)
+	}
+
+	revoked := map[string]bool{}
+	for _, cert := range crl.TBSCertList.RevokedCertificates {
+		revoked[cert.SerialNumber.String()] = true
+	}
+
+	return revoked, nil
+}
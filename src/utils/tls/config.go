@@ -0,0 +1,85 @@
+/**
+ * config.go - tls configs of servers and backends connections
+ */
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"../../config"
+	"../../logging"
+)
+
+/**
+ * Creates tls config of server terminating tls with certificates
+ */
+func NewServerConfig(cfg *config.Tls, certificates *Certificates) (*tls.Config, error) {
+
+	result := &tls.Config{
+		GetCertificate:           certificates.GetCertificate,
+		CipherSuites:             MapCiphers(cfg.Ciphers),
+		PreferServerCipherSuites: cfg.PreferServerCiphers,
+		MinVersion:               MapVersion(cfg.MinVersion),
+		MaxVersion:               MapVersion(cfg.MaxVersion),
+		SessionTicketsDisabled:   !cfg.SessionTickets,
+		NextProtos:               cfg.Alpn,
+	}
+
+	if cfg.ClientAuth != nil {
+		if err := ConfigureClientAuth(result, cfg.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+/**
+ * Creates tls config of connections to backends
+ */
+func NewBackendsConfig(cfg *config.BackendsTls) (*tls.Config, error) {
+
+	log := logging.For("tls.NewBackendsConfig")
+	var err error
+
+	result := &tls.Config{
+		InsecureSkipVerify:       cfg.IgnoreVerify,
+		CipherSuites:             MapCiphers(cfg.Ciphers),
+		PreferServerCipherSuites: cfg.PreferServerCiphers,
+		MinVersion:               MapVersion(cfg.MinVersion),
+		MaxVersion:               MapVersion(cfg.MaxVersion),
+		SessionTicketsDisabled:   !cfg.SessionTickets,
+	}
+
+	if cfg.CertPath != nil && cfg.KeyPath != nil {
+
+		var crt tls.Certificate
+
+		if crt, err = tls.LoadX509KeyPair(*cfg.CertPath, *cfg.KeyPath); err != nil {
+			return nil, err
+		}
+
+		result.Certificates = []tls.Certificate{crt}
+	}
+
+	if cfg.RootCaCertPath != nil {
+
+		var caCertPem []byte
+
+		if caCertPem, err = ioutil.ReadFile(*cfg.RootCaCertPath); err != nil {
+			return nil, err
+		}
+
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCertPem); !ok {
+			log.Error("Unable to load root pem")
+		}
+
+		result.RootCAs = caCertPool
+	}
+
+	return result, nil
+}
@@ -0,0 +1,194 @@
+/**
+ * unixsocket.go - listening on unix domain sockets
+ */
+
+package unixsocket
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../config"
+	"../upgrade"
+)
+
+/**
+ * Prefix of bind address of unix domain socket, i.e. "unix:/var/run/gobetween.sock"
+ */
+const BindPrefix = "unix:"
+
+/**
+ * Timeout of connecting to existing socket file, to know if it's stale
+ */
+const staleCheckTimeout = 1 * time.Second
+
+/**
+ * Listener removing its socket file when closed, unless it's
+ * closed after new process took it over on upgrade
+ */
+type listener struct {
+	*net.UnixListener
+	path string
+}
+
+/**
+ * Checks if bind is unix domain socket
+ */
+func IsSocketBind(bind string) bool {
+	return strings.HasPrefix(bind, BindPrefix)
+}
+
+/**
+ * Returns socket file path of bind
+ */
+func Path(bind string) string {
+	return strings.TrimPrefix(bind, BindPrefix)
+}
+
+/**
+ * Listens on unix socket of bind, taking listener passed by old process on upgrade if any.
+ * Stale socket file, left by process that did not exit properly, is replaced,
+ * and mode and owner of options are set on socket file
+ */
+func Listen(bind string, cfg *config.UnixSocketConfig) (net.Listener, error) {
+
+	path := Path(bind)
+
+	if inherited := upgrade.Listeners(bind); len(inherited) > 0 {
+		for _, extra := range inherited[1:] {
+			extra.Close()
+		}
+		if l, ok := inherited[0].(*net.UnixListener); ok {
+			return &listener{l, path}, nil
+		}
+		return inherited[0], nil
+	}
+
+	mode, uid, gid, err := parseOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Socket file is removed by listener wrapper, it should be kept on upgrade
+	l.SetUnlinkOnClose(false)
+
+	if mode != 0 {
+		err = os.Chmod(path, mode)
+	}
+
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Chown(path, uid, gid)
+	}
+
+	if err != nil {
+		l.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &listener{l, path}, nil
+}
+
+/**
+ * Checks options can be applied to socket file
+ */
+func CheckOptions(cfg *config.UnixSocketConfig) error {
+	_, _, _, err := parseOptions(cfg)
+	return err
+}
+
+/**
+ * Closes listener, removing socket file if old process is not draining after upgrade
+ */
+func (this *listener) Close() error {
+
+	err := this.UnixListener.Close()
+
+	if upgrade.GetStatus().State != upgrade.StateDraining {
+		os.Remove(this.path)
+	}
+
+	return err
+}
+
+/**
+ * Removes socket file at path if nothing accepts connections on it
+ */
+func removeStale(path string) error {
+
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		// Not existing, or not socket one that net.Listen will fail on
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, staleCheckTimeout)
+	if err == nil {
+		conn.Close()
+		return errors.New("Socket " + path + " is used by another process")
+	}
+
+	return os.Remove(path)
+}
+
+/**
+ * Parses options to socket file mode, 0 if it's not set, and owner
+ * user and group ids, -1 if they're not set
+ */
+func parseOptions(cfg *config.UnixSocketConfig) (os.FileMode, int, int, error) {
+
+	if cfg == nil {
+		return 0, -1, -1, nil
+	}
+
+	var mode os.FileMode
+	uid, gid := -1, -1
+
+	if cfg.Mode != "" {
+		m, err := strconv.ParseUint(cfg.Mode, 8, 32)
+		if err != nil || m > 0777 {
+			return 0, -1, -1, errors.New("unix_socket.mode should be octal permissions, i.e. \"0660\"")
+		}
+		mode = os.FileMode(m)
+	}
+
+	if cfg.Owner != "" {
+		u, err := user.Lookup(cfg.Owner)
+		if err != nil {
+			if u, err = user.LookupId(cfg.Owner); err != nil {
+				return 0, -1, -1, errors.New("unix_socket.owner: unknown user " + cfg.Owner)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, -1, -1, errors.New("unix_socket.owner is not supported on this system")
+		}
+	}
+
+	if cfg.Group != "" {
+		g, err := user.LookupGroup(cfg.Group)
+		if err != nil {
+			if g, err = user.LookupGroupId(cfg.Group); err != nil {
+				return 0, -1, -1, errors.New("unix_socket.group: unknown group " + cfg.Group)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, -1, -1, errors.New("unix_socket.group is not supported on this system")
+		}
+	}
+
+	return mode, uid, gid, nil
+}
@@ -0,0 +1,227 @@
+/**
+ * upgrade.go - zero-downtime binary upgrade by handing listening sockets over to new process
+ */
+
+package upgrade
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+ * Upgrade states
+ */
+const (
+	StateNone       = "none"
+	StateInProgress = "in_progress"
+	StateFailed     = "failed"
+	StateDraining   = "draining"
+	StateCompleted  = "completed"
+)
+
+/**
+ * Upgrade status
+ */
+type Status struct {
+
+	/* "none" | "in_progress" | "failed" | "draining" (old process, new one took over) | "completed" (new process) */
+	State string `json:"state"`
+
+	/* Pid of new process, in old process */
+	Pid int `json:"pid,omitempty"`
+
+	/* Pid of process upgraded from, in new process */
+	ParentPid int `json:"parent_pid,omitempty"`
+
+	/* Time upgrade was started */
+	Started *time.Time `json:"started,omitempty"`
+
+	/* Time upgrade was finished or failed */
+	Finished *time.Time `json:"finished,omitempty"`
+
+	/* Error upgrade failed with */
+	Error string `json:"error,omitempty"`
+}
+
+/**
+ * Socket that can be passed to new process
+ */
+type socket interface {
+	File() (*os.File, error)
+	SyscallConn() (syscall.RawConn, error)
+}
+
+/**
+ * Passed socket description, sockets are passed in order of descriptions
+ */
+type passedSocket struct {
+	Network string `json:"network"`
+	Bind    string `json:"bind"`
+}
+
+/**
+ * Registered listening sockets of current process, and
+ * sockets inherited from old process not taken yet
+ */
+var sockets = struct {
+	sync.Mutex
+	registered map[passedSocket][]socket
+	inherited  map[passedSocket][]*os.File
+}{
+	registered: map[passedSocket][]socket{},
+	inherited:  map[passedSocket][]*os.File{},
+}
+
+/**
+ * Current upgrade status
+ */
+var status = struct {
+	sync.RWMutex
+	Status
+}{
+	Status: Status{State: StateNone},
+}
+
+/**
+ * Returns current upgrade status
+ */
+func GetStatus() Status {
+	status.RLock()
+	defer status.RUnlock()
+	return status.Status
+}
+
+/**
+ * Returns stream listeners inherited from old process for bind, if any.
+ * Listeners are taken once, so restarted server binds anew
+ */
+func Listeners(bind string) []net.Listener {
+
+	var listeners []net.Listener
+
+	for _, f := range takeInherited("tcp", bind) {
+		if l, err := net.FileListener(f); err == nil {
+			listeners = append(listeners, l)
+		}
+		f.Close()
+	}
+
+	return listeners
+}
+
+/**
+ * Returns udp socket inherited from old process for bind, if any
+ */
+func UDPConn(bind string) *net.UDPConn {
+
+	var conn *net.UDPConn
+
+	for _, f := range takeInherited("udp", bind) {
+		if c, err := net.FilePacketConn(f); err == nil {
+			if udpConn, ok := c.(*net.UDPConn); ok && conn == nil {
+				conn = udpConn
+			} else {
+				c.Close()
+			}
+		}
+		f.Close()
+	}
+
+	return conn
+}
+
+/**
+ * Returns stream listener inherited from old process for bind,
+ * or listens on bind, registering listener to be passed on upgrade
+ */
+func Listen(bind string) (net.Listener, error) {
+
+	var l net.Listener
+	var err error
+
+	if inherited := Listeners(bind); len(inherited) > 0 {
+		l = inherited[0]
+		for _, extra := range inherited[1:] {
+			extra.Close()
+		}
+	} else if l, err = net.Listen("tcp", bind); err != nil {
+		return nil, err
+	}
+
+	RegisterListener(bind, l)
+
+	return l, nil
+}
+
+/**
+ * Registers stream listener of bind to be passed to new process on upgrade
+ */
+func RegisterListener(bind string, l net.Listener) {
+	if s, ok := l.(socket); ok {
+		register(passedSocket{"tcp", bind}, s)
+	}
+}
+
+/**
+ * Registers udp socket of bind to be passed to new process on upgrade
+ */
+func RegisterUDPConn(bind string, conn *net.UDPConn) {
+	register(passedSocket{"udp", bind}, conn)
+}
+
+/**
+ * Registers socket, forgetting closed ones
+ */
+func register(key passedSocket, s socket) {
+
+	sockets.Lock()
+	defer sockets.Unlock()
+
+	for k, list := range sockets.registered {
+		open := list[:0]
+		for _, r := range list {
+			if isOpen(r) {
+				open = append(open, r)
+			}
+		}
+		if len(open) == 0 {
+			delete(sockets.registered, k)
+		} else {
+			sockets.registered[k] = open
+		}
+	}
+
+	sockets.registered[key] = append(sockets.registered[key], s)
+}
+
+/**
+ * Takes inherited sockets of network and bind
+ */
+func takeInherited(network string, bind string) []*os.File {
+
+	sockets.Lock()
+	defer sockets.Unlock()
+
+	key := passedSocket{network, bind}
+	files := sockets.inherited[key]
+	delete(sockets.inherited, key)
+
+	return files
+}
+
+/**
+ * Checks if socket is not closed
+ */
+func isOpen(s socket) bool {
+
+	raw, err := s.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	return raw.Control(func(fd uintptr) {}) == nil
+}
@@ -0,0 +1,34 @@
+//go:build windows || plan9
+// +build windows plan9
+
+/**
+ * upgrade_other.go - binary upgrade is not available on windows
+ */
+
+package upgrade
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+/**
+ * There is no upgrade signal
+ */
+func Notify(c chan<- os.Signal) {
+}
+
+/**
+ * Process is never started by upgrade
+ */
+func Ready() bool {
+	return false
+}
+
+/**
+ * Upgrade is not supported
+ */
+func Start(timeout time.Duration) error {
+	return errors.New("Upgrade is not supported on windows")
+}
@@ -0,0 +1,271 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/**
+ * upgrade_unix.go - starting new process with listening sockets passed as extra files
+ */
+
+package upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/**
+ * Environment variables describing passed sockets and pid of old process
+ */
+const (
+	socketsEnv = "GOBETWEEN_UPGRADE_SOCKETS"
+	parentEnv  = "GOBETWEEN_UPGRADE_PARENT"
+)
+
+/**
+ * Extra files of new process: pipe notifying old one new process is ready, then sockets
+ */
+const (
+	readyFd        = 3
+	firstSocketFd  = 4
+	readyMessage   = "ready"
+	defaultTimeout = time.Minute
+)
+
+/**
+ * Pipe to notify old process new one is ready, if started by upgrade
+ */
+var readyPipe *os.File
+
+/**
+ * Takes sockets passed by old process, if started by upgrade
+ */
+func init() {
+
+	env := os.Getenv(socketsEnv)
+	if env == "" {
+		return
+	}
+
+	parent, _ := strconv.Atoi(os.Getenv(parentEnv))
+
+	os.Unsetenv(socketsEnv)
+	os.Unsetenv(parentEnv)
+
+	passed := []passedSocket{}
+	if err := json.Unmarshal([]byte(env), &passed); err != nil {
+		return
+	}
+
+	syscall.CloseOnExec(readyFd)
+	readyPipe = os.NewFile(readyFd, "upgrade-ready")
+
+	for i, p := range passed {
+		fd := firstSocketFd + i
+		syscall.CloseOnExec(fd)
+		sockets.inherited[p] = append(sockets.inherited[p], os.NewFile(uintptr(fd), p.Network+":"+p.Bind))
+	}
+
+	now := time.Now()
+	status.Status = Status{State: StateInProgress, ParentPid: parent, Started: &now}
+}
+
+/**
+ * Relays SIGUSR2, signal to start upgrade, to c
+ */
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+/**
+ * Notifies old process that new one has taken over and closes inherited
+ * sockets no server took. Returns true if process is started by upgrade
+ */
+func Ready() bool {
+
+	if readyPipe == nil {
+		return false
+	}
+
+	readyPipe.Write([]byte(readyMessage))
+	readyPipe.Close()
+	readyPipe = nil
+
+	sockets.Lock()
+	for key, files := range sockets.inherited {
+		for _, f := range files {
+			f.Close()
+		}
+		delete(sockets.inherited, key)
+	}
+	sockets.Unlock()
+
+	status.Lock()
+	now := time.Now()
+	status.State = StateCompleted
+	status.Finished = &now
+	status.Unlock()
+
+	return true
+}
+
+/**
+ * Starts new process of current executable with the same arguments, passing
+ * registered listening sockets to it, and waits up to timeout for it to be ready.
+ * Returns nil if new process has taken over, so current one should drain and exit
+ */
+func Start(timeout time.Duration) error {
+
+	status.Lock()
+	if status.State == StateInProgress || status.State == StateDraining {
+		status.Unlock()
+		return errors.New("Upgrade is in progress already")
+	}
+	now := time.Now()
+	status.Status = Status{State: StateInProgress, Started: &now}
+	status.Unlock()
+
+	pid, err := start(timeout)
+
+	status.Lock()
+	defer status.Unlock()
+
+	now = time.Now()
+	status.Pid = pid
+	status.Finished = &now
+
+	if err != nil {
+		status.State = StateFailed
+		status.Error = err.Error()
+		return err
+	}
+
+	status.State = StateDraining
+	return nil
+}
+
+/**
+ * Starts new process and waits for it to be ready, returns its pid
+ */
+func start(timeout time.Duration) (int, error) {
+
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	passed, files := socketFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	env, err := json.Marshal(passed)
+	if err != nil {
+		return 0, err
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append([]*os.File{readyWriter}, files...)
+	cmd.Env = append(environ(), socketsEnv+"="+string(env), parentEnv+"="+strconv.Itoa(os.Getpid()))
+
+	err = cmd.Start()
+	readyWriter.Close()
+
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// Read ends with EOF if new process exits without notifying
+	notified := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, len(readyMessage))
+		n, _ := ready.Read(buf)
+		notified <- n > 0
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case ok := <-notified:
+		if ok {
+			return cmd.Process.Pid, nil
+		}
+		err = <-exited
+	case err = <-exited:
+	case <-timer.C:
+		cmd.Process.Kill()
+		<-exited
+		return cmd.Process.Pid, errors.New("New process was not ready in " + timeout.String())
+	}
+
+	if err == nil {
+		err = errors.New("exit status 0")
+	}
+
+	return cmd.Process.Pid, errors.New("New process exited: " + err.Error())
+}
+
+/**
+ * Returns duplicates of registered sockets and their descriptions
+ */
+func socketFiles() ([]passedSocket, []*os.File) {
+
+	sockets.Lock()
+	defer sockets.Unlock()
+
+	passed := []passedSocket{}
+	files := []*os.File{}
+
+	for key, list := range sockets.registered {
+		for _, s := range list {
+			if f, err := s.File(); err == nil {
+				passed = append(passed, key)
+				files = append(files, f)
+			}
+		}
+	}
+
+	return passed, files
+}
+
+/**
+ * Returns environment for new process. GOBETWEEN variable is left out,
+ * as arguments it holds are passed on command line already
+ */
+func environ() []string {
+
+	result := []string{}
+
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "GOBETWEEN=") {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,50 @@
+/**
+ * http.go - HTTP CONNECT handshake with basic proxy auth
+ */
+
+package upstreamproxy
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+/**
+ * Asks HTTP proxy connected with conn to tunnel connection to address
+ */
+func connectHttp(conn net.Conn, address string, username string, password string) (net.Conn, error) {
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+		req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+		delete(req.Header, "Authorization")
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Successful CONNECT response has no body, a failed one is not needed
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Proxy responded " + res.Status)
+	}
+
+	return withBuffered(conn, reader), nil
+}
@@ -0,0 +1,176 @@
+/**
+ * socks5.go - SOCKS5 CONNECT handshake (RFC 1928) with username / password auth (RFC 1929)
+ */
+
+package upstreamproxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+/**
+ * Messages of SOCKS5 reply codes
+ */
+var socks5Replies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "ttl expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+/**
+ * Asks SOCKS5 proxy connected with conn to connect to address.
+ * Host names are resolved by proxy
+ */
+func connectSocks5(conn net.Conn, address string, username string, password string) (net.Conn, error) {
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.New("Invalid port " + portStr)
+	}
+
+	method := byte(socks5AuthNone)
+	if username != "" {
+		method = socks5AuthPassword
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(reader, reply); err != nil {
+		return nil, err
+	}
+
+	if reply[0] != socks5Version {
+		return nil, errors.New("Not SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case method:
+	case socks5AuthNoAcceptable:
+		return nil, errors.New("No acceptable auth method, proxy requires other auth")
+	default:
+		return nil, errors.New("Proxy selected not offered auth method")
+	}
+
+	if method == socks5AuthPassword {
+		if err := authSocks5(conn, reader, username, password); err != nil {
+			return nil, err
+		}
+	}
+
+	request := []byte{socks5Version, socks5CmdConnect, 0}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, errors.New("Too long host name " + host)
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, socks5AddrIPv4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, socks5AddrIPv6)
+		request = append(request, ip.To16()...)
+	}
+
+	request = append(request, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	// Reply is version, code, reserved, then bound address and port which are skipped
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if header[1] != 0 {
+		message, ok := socks5Replies[header[1]]
+		if !ok {
+			message = "failure " + strconv.Itoa(int(header[1]))
+		}
+		return nil, errors.New(message)
+	}
+
+	var addrLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		l, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		addrLen = int(l)
+	default:
+		return nil, errors.New("Unknown bound address type in reply")
+	}
+
+	if _, err := reader.Discard(addrLen + 2); err != nil {
+		return nil, err
+	}
+
+	return withBuffered(conn, reader), nil
+}
+
+/**
+ * Authenticates with username and password
+ */
+func authSocks5(conn net.Conn, reader *bufio.Reader, username string, password string) error {
+
+	request := []byte{1, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(reader, reply); err != nil {
+		return err
+	}
+
+	if reply[1] != 0 {
+		return errors.New("Authentication failed")
+	}
+
+	return nil
+}
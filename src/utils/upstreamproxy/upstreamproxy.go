@@ -0,0 +1,136 @@
+/**
+ * upstreamproxy.go - connecting to backends through upstream SOCKS5 or HTTP CONNECT proxy
+ */
+
+package upstreamproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"../../config"
+	"../backendbind"
+)
+
+/**
+ * Dialer connecting to addresses through upstream proxy
+ */
+type Dialer struct {
+
+	/* Upstream proxy options */
+	cfg *config.UpstreamProxy
+
+	/* Dialer of connections to proxy, its timeout limits proxy handshake too */
+	dialer *backendbind.Dialer
+}
+
+/**
+ * Creates dialer of upstream proxy, connecting to it with dialer
+ */
+func New(cfg *config.UpstreamProxy, dialer *backendbind.Dialer) *Dialer {
+	return &Dialer{
+		cfg:    cfg,
+		dialer: dialer,
+	}
+}
+
+/**
+ * Connects to address through proxy
+ */
+func (this *Dialer) Dial(network string, address string) (net.Conn, error) {
+	return this.DialContext(context.Background(), network, address)
+}
+
+/**
+ * Connects to address through proxy. Proxy handshake is limited by
+ * ctx deadline and dialer timeout. All failures are returned as dial errors,
+ * as nothing is sent to address yet
+ */
+func (this *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+
+	if this.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.dialer.Timeout)
+		defer cancel()
+	}
+
+	conn, err := this.dialer.DialContext(ctx, "tcp", this.cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock handshake if ctx is canceled before deadline
+	done := make(chan bool)
+	exited := make(chan bool)
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	var result net.Conn
+
+	switch this.cfg.Kind {
+	case "socks5":
+		result, err = connectSocks5(conn, address, this.cfg.Username, this.cfg.Password)
+	case "http":
+		result, err = connectHttp(conn, address, this.cfg.Username, this.cfg.Password)
+	default:
+		err = errors.New("Not supported upstream proxy kind " + this.cfg.Kind)
+	}
+
+	close(done)
+	<-exited
+
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{
+			Op:  "dial",
+			Net: network,
+			Err: errors.New("upstream proxy " + this.cfg.Address + " to " + address + ": " + err.Error()),
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return result, nil
+}
+
+/**
+ * Conn reading data proxy sent after handshake response from buffered reader first
+ */
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (this *bufferedConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+/**
+ * Returns conn as is, so it stays *net.TCPConn for splice and socket options,
+ * unless reader has buffered data after handshake
+ */
+func withBuffered(conn net.Conn, reader *bufio.Reader) net.Conn {
+
+	if reader.Buffered() == 0 {
+		return conn
+	}
+
+	return &bufferedConn{Conn: conn, reader: reader}
+}
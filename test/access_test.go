@@ -0,0 +1,178 @@
+package test
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/server/modules/access"
+)
+
+func TestAccessClientIdentity(t *testing.T) {
+
+	a, err := access.NewAccess(&config.AccessConfig{
+		Default: "deny",
+		Rules: []string{
+			"deny cn=^blocked\\.",
+			"allow cn=\\.example\\.com$",
+			"allow 10.0.0.0/8",
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.168.0.1")
+	internal := net.ParseIP("10.1.1.1")
+
+	if !a.AllowsClient(&ip, "client.example.com") {
+		t.Fatal("Expected client with allowed identity to be allowed")
+	}
+
+	if a.AllowsClient(&ip, "blocked.example.com") {
+		t.Fatal("Expected client with denied identity to be denied")
+	}
+
+	if a.AllowsClient(&ip, "") || a.Allows(&ip) {
+		t.Fatal("Expected client without identity to fall to default")
+	}
+
+	if !a.Allows(&internal) {
+		t.Fatal("Expected ip rules to still be matched")
+	}
+}
+
+func TestAccessSniAndCertificate(t *testing.T) {
+
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		DNSNames:       []string{"client.example.com"},
+		EmailAddresses: []string{"ops@example.com"},
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := strings.ToUpper(hex.EncodeToString(sum[:2])) + ":" + hex.EncodeToString(sum[2:])
+
+	a, err := access.NewAccess(&config.AccessConfig{
+		Default: "deny",
+		Rules: []string{
+			"deny sni=^internal\\.",
+			"allow san=^ops@",
+			"allow fingerprint=" + fingerprint,
+			"allow sni=\\.example\\.com$",
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.168.0.1")
+
+	if !a.AllowsConnection(access.Client{Ip: &ip, Sni: "api.example.com"}) {
+		t.Fatal("Expected client with allowed sni to be allowed")
+	}
+
+	if a.AllowsConnection(access.Client{Ip: &ip, Sni: "internal.example.com", Certificate: cert}) {
+		t.Fatal("Expected first matching sni rule to deny client")
+	}
+
+	if !a.AllowsConnection(access.Client{Ip: &ip, Certificate: cert}) {
+		t.Fatal("Expected client with allowed certificate san to be allowed")
+	}
+
+	if !a.AllowsConnection(access.Client{Ip: &ip, Certificate: &x509.Certificate{Raw: cert.Raw}}) {
+		t.Fatal("Expected client with allowed certificate fingerprint to be allowed")
+	}
+
+	if a.AllowsConnection(access.Client{Ip: &ip}) {
+		t.Fatal("Expected client without sni and certificate to fall to default")
+	}
+
+	if _, err := access.ParseAccessRule("allow fingerprint=abcd"); err == nil {
+		t.Fatal("Expected short fingerprint to be rejected")
+	}
+}
+
+func TestAccessGeoipRules(t *testing.T) {
+
+	rule, err := access.ParseAccessRule("deny country=de,us")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rule.Countries) != 2 || rule.Countries[0] != "DE" {
+		t.Fatal("Expected upper case countries, got ", rule.Countries)
+	}
+
+	rule, err = access.ParseAccessRule("allow asn=AS13335,15169")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rule.Asns) != 2 || rule.Asns[0] != 13335 || rule.Asns[1] != 15169 {
+		t.Fatal("Expected parsed asns, got ", rule.Asns)
+	}
+
+	for _, r := range []string{"deny country=DEU", "deny asn=cloud"} {
+		if _, err := access.ParseAccessRule(r); err == nil {
+			t.Fatal("Expected rule to be rejected: ", r)
+		}
+	}
+
+	// Without geoip databases location is unknown and geoip rules never match
+	ip := net.ParseIP("192.168.0.1")
+	if rule.Matches(&ip) {
+		t.Fatal("Expected asn rule not to match unknown location")
+	}
+}
+
+func TestAccessRulesFileReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gobetween-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules")
+	if err := ioutil.WriteFile(path, []byte("# blocked\ndeny 192.168.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := access.NewAccess(&config.AccessConfig{
+		Default:   "allow",
+		RulesFile: path,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	ip := net.ParseIP("192.168.0.1")
+	if a.Allows(&ip) {
+		t.Fatal("Expected ip from rules file to be denied")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("allow 192.168.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50 && !a.Allows(&ip); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if !a.Allows(&ip) {
+		t.Fatal("Expected rules file change to be reloaded")
+	}
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/manager"
+)
+
+/**
+ * Starts backend serving every accepted connection with handle,
+ * returns its address and count of accepted connections
+ */
+func startTestBackend(t *testing.T, handle func(conn net.Conn, n int32)) (string, *int32, func()) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := new(int32)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn, atomic.AddInt32(accepted, 1))
+		}
+	}()
+
+	return l.Addr().String(), accepted, func() { l.Close() }
+}
+
+/**
+ * Creates tcp server proxying to backends, returns its address
+ * when backends are discovered
+ */
+func createTestServer(t *testing.T, name string, cfg config.Server, backends ...string) string {
+
+	bind := freeAddress(t)
+
+	cfg.Bind = config.Binds{bind}
+	cfg.Protocol = "tcp"
+	cfg.Discovery = &config.DiscoveryConfig{Kind: "static", StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: backends}}
+
+	if err := manager.Create(name, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enabling backend fails until it's discovered
+	for _, backend := range backends {
+		deadline := time.Now().Add(time.Second)
+		for manager.DrainBackend(name, backend, false) != nil {
+			if time.Now().After(deadline) {
+				t.Fatal("Backend ", backend, " was not discovered")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	return bind
+}
+
+/**
+ * Sends request in new session and returns response of the given size
+ */
+func testSession(t *testing.T, address string, request string, size int) string {
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal("Can't read response to ", request, ": ", err)
+	}
+
+	return string(response)
+}
+
+func TestBackendPoolReuse(t *testing.T) {
+
+	backend, accepted, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+		defer conn.Close()
+		buf := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			if string(buf) == "slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			conn.Write([]byte(strconv.Itoa(int(n)) + ":" + string(buf)))
+		}
+	})
+	defer stop()
+
+	server := createTestServer(t, "pool", config.Server{
+		BackendPool: &config.BackendPoolConfig{Mode: "request_response", DrainTimeout: "50ms"},
+	}, backend)
+	defer manager.Delete("pool")
+
+	if response := testSession(t, server, "aaaa", 6); response != "1:aaaa" {
+		t.Fatal("Unexpected response ", response)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if response := testSession(t, server, "bbbb", 6); response != "1:bbbb" {
+		t.Fatal("Expected idle backend connection to be reused, got ", response)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	// Client closes before backend answers, so answer would go to the next session
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("slow"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	time.Sleep(300 * time.Millisecond)
+
+	if response := testSession(t, server, "cccc", 6); response != "2:cccc" {
+		t.Fatal("Expected backend connection with unanswered data not to be reused, got ", response)
+	}
+
+	if n := atomic.LoadInt32(accepted); n != 2 {
+		t.Fatal("Expected 2 backend connections, got ", n)
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"../src/balance"
+	"../src/config"
+	"../src/core"
+	"../src/discovery"
+	"../src/healthcheck"
+	"../src/server/scheduler"
+	"../src/stats"
+)
+
+func newBreakerTestScheduler(t *testing.T, cfg *config.CircuitBreakerConfig) *scheduler.Scheduler {
+	return newTestScheduler(t, "127.0.0.1:1", cfg)
+}
+
+/**
+ * Starts scheduler of static backend, returns it when backend is discovered
+ */
+func newTestScheduler(t *testing.T, backend string, cfg *config.CircuitBreakerConfig) *scheduler.Scheduler {
+
+	discoveryCfg := config.DiscoveryConfig{
+		Kind:                  "static",
+		Interval:              "0",
+		StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+	}
+	healthcheckCfg := config.HealthcheckConfig{Kind: "none", Interval: "0", Timeout: "0"}
+
+	statsHandler := stats.NewHandler(t.Name())
+	s := &scheduler.Scheduler{
+		Balancer:       balance.New(nil, "weight"),
+		Discovery:      discovery.New(discoveryCfg.Kind, discoveryCfg),
+		Healthcheck:    healthcheck.New(healthcheckCfg.Kind, healthcheckCfg),
+		StatsHandler:   statsHandler,
+		CircuitBreaker: cfg,
+	}
+
+	statsHandler.Start()
+	s.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if len(s.Candidates(DummyContext{}, nil)) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Backend was not discovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return s
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+
+	s := newBreakerTestScheduler(t, &config.CircuitBreakerConfig{
+		ConsecutiveErrors: 2,
+		OpenTimeout:       "100ms",
+	})
+	defer s.Stop()
+
+	take := func() *core.Backend {
+		backend, err := s.TakeBackend(DummyContext{})
+		if err != nil {
+			return nil
+		}
+		return backend
+	}
+
+	for i := 0; i < 2; i++ {
+		backend := take()
+		if backend == nil {
+			t.Fatal("Expected backend to be elected while breaker is closed")
+		}
+		s.IncrementRefused(*backend)
+	}
+
+	if take() != nil {
+		t.Fatal("Expected breaker to open after consecutive errors")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	probe := take()
+	if probe == nil || probe.Stats.CircuitBreaker != scheduler.BREAKER_HALF_OPEN {
+		t.Fatal("Expected half-open breaker to let probe through after open timeout, got ", probe)
+	}
+
+	if take() != nil {
+		t.Fatal("Expected half-open breaker to let only one probe through")
+	}
+
+	s.IncrementRefused(*probe)
+
+	if take() != nil {
+		t.Fatal("Expected failed probe to open breaker again")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	probe = take()
+	if probe == nil {
+		t.Fatal("Expected probe after open timeout")
+	}
+
+	s.IncrementConnection(*probe)
+
+	backend := take()
+	if backend == nil || backend.Stats.CircuitBreaker != scheduler.BREAKER_CLOSED {
+		t.Fatal("Expected succeeded probe to close breaker, got ", backend)
+	}
+}
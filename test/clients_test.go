@@ -0,0 +1,113 @@
+package test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/core"
+	"../src/manager"
+)
+
+/**
+ * Waits until server has count connections connected to backend, returns them
+ */
+func waitConnections(t *testing.T, name string, count int) []core.Connection {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		connections, total, err := manager.Connections(name, "", false, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		connected := 0
+		for _, c := range connections {
+			if c.Backend != "" {
+				connected++
+			}
+		}
+
+		if total == count && connected == count {
+			return connections
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected ", count, " connections, got ", total, " (", connected, " connected)")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientsRegistry(t *testing.T) {
+
+	backend, _, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	})
+	defer stop()
+
+	server := createTestServer(t, "clients", config.Server{}, backend)
+	defer manager.Delete("clients")
+
+	conns := []net.Conn{}
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	connections := waitConnections(t, "clients", len(conns))
+
+	ids := map[uint64]bool{}
+	for _, c := range connections {
+		if ids[c.Id] {
+			t.Fatal("Expected unique connection ids, got ", c.Id, " twice")
+		}
+		ids[c.Id] = true
+
+		if c.Backend != backend {
+			t.Error("Expected connection to be proxied to ", backend, ", got ", c.Backend)
+		}
+	}
+
+	page, total, err := manager.Connections("clients", "age", true, 5, 10)
+	if err != nil || total != len(conns) || len(page) != 10 {
+		t.Fatal("Expected page of 10 of ", len(conns), " connections, got ", len(page), " of ", total, " ", err)
+	}
+
+	// Connections of the same client ip are tracked separately
+	conns[0].Close()
+	conns = conns[1:]
+
+	connections = waitConnections(t, "clients", len(conns))
+
+	if err := manager.KillConnection("clients", connections[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	waitConnections(t, "clients", len(conns)-1)
+
+	if err := manager.KillConnection("clients", connections[0].Id); err == nil {
+		t.Error("Expected killing removed connection to fail")
+	}
+
+	killed := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := conn.Read(make([]byte, 1)); err == io.EOF {
+			killed++
+		}
+	}
+
+	if killed != 1 {
+		t.Fatal("Expected killed client connection to be closed, got ", killed, " closed")
+	}
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"net"
+	"testing"
+
+	"../src/cluster"
+	"../src/config"
+)
+
+func TestClusterReconfigureBusyBind(t *testing.T) {
+
+	c := cluster.New()
+	defer c.Stop()
+
+	cfg := config.ClusterConfig{Enabled: true, Bind: freeAddress(t), Secret: "0123456789abcdef"}
+
+	if err := c.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	moved := cfg
+	moved.Bind = busy.Addr().String()
+
+	if err := c.Configure(moved); err == nil {
+		t.Fatal("Expected cluster to fail listening on busy bind")
+	}
+
+	// Current node keeps running when new bind fails
+	if !c.Status().Enabled {
+		t.Error("Expected cluster node to keep running")
+	}
+
+	conn, err := net.Dial("tcp", cfg.Bind)
+	if err != nil {
+		t.Fatal("Expected cluster node to keep listening on previous bind: ", err)
+	}
+	conn.Close()
+
+	if err := c.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node failed to listen is started again with the same configuration
+	c.Stop()
+
+	taken, err := net.Listen("tcp", cfg.Bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := cfg
+	restarted.Interval = "2s"
+
+	if err := c.Configure(restarted); err == nil {
+		t.Fatal("Expected cluster to fail listening on busy bind")
+	}
+
+	taken.Close()
+
+	if err := c.Configure(restarted); err != nil || !c.Status().Enabled {
+		t.Fatal("Expected cluster to be started again with the same configuration, got ", err)
+	}
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"../src/server/modules/connlimit"
+)
+
+func TestConnLimitQueue(t *testing.T) {
+
+	l := connlimit.New(1, 1)
+
+	if !l.Acquire(0, nil) {
+		t.Fatal("Expected connection within limit to take slot")
+	}
+
+	if l.Acquire(0, nil) {
+		t.Fatal("Expected connection over limit without timeout to be rejected")
+	}
+
+	start := time.Now()
+	if l.Acquire(50*time.Millisecond, nil) {
+		t.Fatal("Expected queued connection to expire while slot is taken")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("Expected queued connection to wait up to timeout")
+	}
+
+	if count, queued := l.Count(); count != 1 || queued != 0 {
+		t.Fatal("Expected expired connection to leave queue, got ", count, " ", queued)
+	}
+
+	granted := make(chan bool)
+	go func() {
+		granted <- l.Acquire(time.Second, nil)
+	}()
+
+	for _, queued := l.Count(); queued != 1; _, queued = l.Count() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if l.Acquire(time.Second, nil) {
+		t.Fatal("Expected connection over full queue to be rejected")
+	}
+
+	l.Release()
+
+	if !<-granted {
+		t.Fatal("Expected released slot to be handed to queued connection")
+	}
+
+	if count, queued := l.Count(); count != 1 || queued != 0 {
+		t.Fatal("Expected handed slot to be counted, got ", count, " ", queued)
+	}
+
+	l.Release()
+
+	if count, _ := l.Count(); count != 0 {
+		t.Fatal("Expected released slots not to be counted, got ", count)
+	}
+}
+
+func TestConnLimitCancelAndSetMax(t *testing.T) {
+
+	l := connlimit.New(1, 0)
+	l.Acquire(0, nil)
+
+	cancel := make(chan bool)
+	cancelled := make(chan bool)
+	go func() {
+		cancelled <- l.Acquire(time.Minute, cancel)
+	}()
+
+	for _, queued := l.Count(); queued != 1; _, queued = l.Count() {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(cancel)
+
+	if <-cancelled {
+		t.Fatal("Expected cancelled connection not to take slot")
+	}
+
+	granted := make(chan bool)
+	go func() {
+		granted <- l.Acquire(time.Minute, nil)
+	}()
+
+	for _, queued := l.Count(); queued != 1; _, queued = l.Count() {
+		time.Sleep(time.Millisecond)
+	}
+
+	l.SetMax(2)
+
+	if !<-granted {
+		t.Fatal("Expected increased limit to hand slot to queued connection")
+	}
+
+	l.SetMax(0)
+
+	if !l.Acquire(0, nil) {
+		t.Fatal("Expected connection to take slot without limit")
+	}
+
+	if count, _ := l.Count(); count != 3 {
+		t.Fatal("Expected 3 connections, got ", count)
+	}
+}
+
+func TestConnLimiters(t *testing.T) {
+
+	stopping := make(chan bool)
+	l := connlimit.NewLimiters("test", 2, 0, 1, 50*time.Millisecond, stopping)
+
+	ip := net.ParseIP("192.168.0.1")
+	other := net.ParseIP("192.168.0.2")
+
+	if err := l.Acquire(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Acquire(ip); err != connlimit.ErrClientLimit || connlimit.Reason(err) != "max_connections_per_client" {
+		t.Fatal("Expected client over its limit to be rejected, got ", err)
+	}
+
+	if err := l.Acquire(other); err != nil {
+		t.Fatal(err)
+	}
+
+	third := net.ParseIP("192.168.0.3")
+	if err := l.Acquire(third); err != connlimit.ErrServerLimit || connlimit.Reason(err) != "max_connections" {
+		t.Fatal("Expected client over server limit to be rejected after queue timeout, got ", err)
+	}
+
+	// Slot of client rejected by server limit is released
+	if counts := l.ClientCounts(); len(counts) != 2 {
+		t.Error("Expected only clients taken slots to be counted, got ", counts)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(stopping)
+	}()
+
+	if err := l.Acquire(third); err != connlimit.ErrStopping {
+		t.Fatal("Expected queued client to give up when server stops, got ", err)
+	}
+
+	l.Release(ip)
+	l.Release(other)
+
+	if count, queued := l.Count(); count != 0 || queued != 0 {
+		t.Error("Expected all slots to be released, got ", count, " and ", queued, " queued")
+	}
+}
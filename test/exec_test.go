@@ -0,0 +1,37 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"../src/core"
+	"../src/healthcheck"
+)
+
+func TestExecEnvLabels(t *testing.T) {
+
+	env := healthcheck.ExecEnv(core.Backend{
+		Target:   core.Target{Host: "127.0.0.1", Port: "8080"},
+		Weight:   2,
+		Priority: 1,
+		Labels: map[string]string{
+			"zone":                   "eu-1",
+			"app.kubernetes.io/name": "web",
+		},
+	})
+
+	expected := []string{
+		"GOBETWEEN_BACKEND_HOST=127.0.0.1",
+		"GOBETWEEN_BACKEND_PORT=8080",
+		"GOBETWEEN_BACKEND_WEIGHT=2",
+		"GOBETWEEN_BACKEND_PRIORITY=1",
+		"GOBETWEEN_BACKEND_SNI=",
+		"GOBETWEEN_BACKEND_MAX_CONNECTIONS=0",
+		"GOBETWEEN_BACKEND_LABEL_APP_KUBERNETES_IO_NAME=web",
+		"GOBETWEEN_BACKEND_LABEL_ZONE=eu-1",
+	}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatal("Unexpected environment ", env)
+	}
+}
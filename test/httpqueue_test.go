@@ -0,0 +1,68 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"../src/manager"
+)
+
+/**
+ * Waits until server has connections and queued ones
+ */
+func waitServerState(t *testing.T, name string, connections int, queued int) {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		state := manager.ServersState()[name]
+		if state.Connections == connections && state.Queued == queued {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected ", connections, " connections and ", queued, " queued, got ", state.Connections, " and ", state.Queued)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHttpConnectionsQueue(t *testing.T) {
+
+	maxConnections := 1
+	cfg := reloadTestServer(freeAddress(t))
+	cfg.Protocol = "http"
+	cfg.MaxConnections = &maxConnections
+	cfg.QueueTimeout = "2s"
+
+	if err := manager.Create("httpqueue", cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete("httpqueue")
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", cfg.Bind[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first := dial()
+	defer first.Close()
+
+	waitServerState(t, "httpqueue", 1, 0)
+
+	// Every queued connection waits for its slot without holding others
+	for i := 0; i < 3; i++ {
+		conn := dial()
+		defer conn.Close()
+	}
+
+	waitServerState(t, "httpqueue", 1, 3)
+
+	first.Close()
+
+	waitServerState(t, "httpqueue", 1, 2)
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/core"
+	"../src/metrics"
+	"../src/stats"
+)
+
+func TestInfluxdbLineProtocol(t *testing.T) {
+
+	var request *http.Request
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		request, body = r, string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	i, err := metrics.NewInfluxdb(config.InfluxdbConfig{
+		Url:      server.URL + "/",
+		Database: "gb",
+		Username: "user",
+		Password: "pass",
+		Tags:     map[string]string{"region": "eu west", "dc": "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	err = i.Push(map[string]stats.Stats{
+		"web,1": {
+			ActiveConnections: 3,
+			RxTotal:           100,
+			Disconnects:       map[string]uint64{"idle timeout": 2},
+			Backends: []core.Backend{{
+				Target: core.Target{Host: "10.0.0.1", Port: "80"},
+				Stats:  core.BackendStats{Live: true, ActiveConnections: 2, TotalConnections: 5},
+			}},
+		},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.URL.Path != "/write" || request.URL.Query().Get("db") != "gb" || request.URL.Query().Get("precision") != "s" {
+		t.Error("Unexpected v1 write url ", request.URL)
+	}
+
+	if user, pass, ok := request.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Error("Expected basic auth of v1 api")
+	}
+
+	for _, line := range []string{
+		`gobetween_backend,server=web\,1,host=10.0.0.1,port=80,dc=a,region=eu\ west live=true,active_connections=2i,connections_total=5i,refused_connections_total=0i,rx_total=0i,tx_total=0i,rx_second=0i,tx_second=0i 1700000000`,
+		`gobetween_server_disconnects,server=web\,1,reason=idle\ timeout,dc=a,region=eu\ west total=2i 1700000000`,
+		`gobetween_server,server=web\,1,dc=a,region=eu\ west active_connections=3i,rx_total=100i,tx_total=0i,rx_second=0i,tx_second=0i,dial_retries_total=0i,backends=1i,live_backends=1i 1700000000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Error("Expected line ", line, " in ", body)
+		}
+	}
+}
+
+func TestInfluxdbV2(t *testing.T) {
+
+	var request *http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := metrics.NewInfluxdb(config.InfluxdbConfig{Url: server.URL, Token: "secret"}); err == nil {
+		t.Error("Expected v2 api to require organization and bucket")
+	}
+
+	i, err := metrics.NewInfluxdb(config.InfluxdbConfig{Url: server.URL, Token: "secret", Organization: "org", Bucket: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.Push(map[string]stats.Stats{"web": {}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.URL.Path != "/api/v2/write" || request.URL.Query().Get("org") != "org" || request.URL.Query().Get("bucket") != "b" {
+		t.Error("Unexpected v2 write url ", request.URL)
+	}
+
+	if request.Header.Get("Authorization") != "Token secret" {
+		t.Error("Expected token auth of v2 api")
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"../src/logging"
+	"github.com/Sirupsen/logrus"
+)
+
+func TestRotatingFileOutput(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gobetween")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gobetween.log")
+
+	out, err := logging.OpenOutput(path, logging.OutputOptions{MaxSize: 10, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := out.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, _ := ioutil.ReadFile(path)
+	if string(current) != "third\n" {
+		t.Fatal("Expected current file to have last line only, got ", string(current))
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatal("Expected 1 rotated file kept, got ", backups)
+	}
+
+	backup, _ := ioutil.ReadFile(backups[0])
+	if string(backup) != "second\n" {
+		t.Fatal("Expected newest rotated file kept, got ", string(backup))
+	}
+}
+
+func TestRemoteSyslogOutput(t *testing.T) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	out, err := logging.OpenOutput("syslog+udp://"+conn.LocalAddr().String(), logging.OutputOptions{Tag: "gobetween", Facility: "local0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	w, ok := out.(logging.LevelWriter)
+	if !ok {
+		t.Fatal("Expected remote syslog output to be level writer")
+	}
+
+	if _, err := w.WriteLevel(logrus.WarnLevel, []byte("message\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(buf[:n])
+
+	// local0 (16) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Fatal("Unexpected priority or version: ", msg)
+	}
+
+	fields := strings.SplitN(msg, " ", 8)
+	if len(fields) != 8 || fields[3] != "gobetween" || fields[5] != "-" || fields[6] != "-" || fields[7] != "message" {
+		t.Fatal("Unexpected RFC5424 message: ", msg)
+	}
+}
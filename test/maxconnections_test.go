@@ -0,0 +1,60 @@
+package test
+
+import (
+	"sync"
+	"testing"
+
+	"../src/core"
+)
+
+func TestBackendMaxConnectionsReserved(t *testing.T) {
+
+	s := newTestScheduler(t, "127.0.0.1:1 max_connections=2", nil)
+	defer s.Stop()
+
+	// Concurrent elections count backend connections before they are dialed
+	elected := make(chan *core.Backend, 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if backend, err := s.TakeBackend(DummyContext{}); err == nil {
+				elected <- backend
+			}
+		}()
+	}
+	wg.Wait()
+	close(elected)
+
+	backends := []*core.Backend{}
+	for backend := range elected {
+		backends = append(backends, backend)
+	}
+
+	if len(backends) != 2 {
+		t.Fatal("Expected backend to be elected max_connections times, got ", len(backends))
+	}
+
+	// Failed dial releases reserved connection
+	s.IncrementRefused(*backends[0])
+
+	backend, err := s.TakeBackend(DummyContext{})
+	if err != nil {
+		t.Fatal("Expected backend to be elected after failed dial, got ", err)
+	}
+
+	// Dialed connections are counted while they are active
+	s.IncrementConnection(*backend)
+	s.IncrementConnection(*backends[1])
+
+	if _, err := s.TakeBackend(DummyContext{}); err == nil {
+		t.Fatal("Expected backend with max_connections active connections not to be elected")
+	}
+
+	s.DecrementConnection(*backend)
+
+	if _, err := s.TakeBackend(DummyContext{}); err != nil {
+		t.Fatal("Expected backend to be elected after connection closed, got ", err)
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/manager"
+)
+
+func TestMuxRoutes(t *testing.T) {
+
+	received := make(chan string, 10)
+
+	// Backends tell which of them got the first bytes
+	backend := func(label string) string {
+		addr, _, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+			data, _ := ioutil.ReadAll(conn)
+			received <- label + ":" + string(data)
+		})
+		t.Cleanup(stop)
+		return addr
+	}
+
+	route := func(name string, rule config.MuxRoute, backend string) config.MuxRoute {
+		rule.Name = name
+		rule.Discovery = &config.DiscoveryConfig{Kind: "static", StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}}}
+		return rule
+	}
+
+	ssh := backend("ssh")
+	upper := backend("upper")
+	amqp := backend("amqp")
+
+	server := createTestServer(t, "mux", config.Server{
+		Mux: &config.Mux{
+			ReadTimeout: "1s",
+			ReadSize:    16,
+			Routes: []config.MuxRoute{
+				route("ssh", config.MuxRoute{Protocol: "ssh"}, ssh),
+				route("upper", config.MuxRoute{Regexp: "^[A-Z]+ "}, upper),
+				route("amqp", config.MuxRoute{Prefix: "AMQP"}, amqp),
+			},
+		},
+	}, backend("default"))
+	defer manager.Delete("mux")
+
+	for _, b := range []string{ssh, upper, amqp} {
+		deadline := time.Now().Add(time.Second)
+		for manager.DrainBackend("mux", b, false) != nil {
+			if time.Now().After(deadline) {
+				t.Fatal("Route backend ", b, " was not discovered")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	session := func(data string, closeWrite bool) (string, time.Duration) {
+		conn, err := net.Dial("tcp", server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		started := time.Now()
+
+		conn.Write([]byte(data))
+		if closeWrite {
+			conn.(*net.TCPConn).CloseWrite()
+		}
+
+		select {
+		case r := <-received:
+			return r, time.Since(started)
+		case <-time.After(3 * time.Second):
+			t.Fatal("No backend received session sending ", data)
+			return "", 0
+		}
+	}
+
+	for _, c := range []struct {
+		data     string
+		expected string
+	}{
+		{"SSH-2.0-test\r\n", "ssh:SSH-2.0-test\r\n"},
+		{"HELLO world", "upper:HELLO world"},
+		{"amqp is lowercase", "default:amqp is lowercase"},
+	} {
+		if r, _ := session(c.data, false); r != c.expected {
+			t.Error("Expected ", c.expected, ", got ", r)
+		}
+	}
+
+	// Client sending nothing is routed to server backends when read_timeout fires
+	if r, elapsed := session("", false); r != "default:" || elapsed < time.Second {
+		t.Error("Expected silent client to be routed to default pool after read timeout, got ", r, " after ", elapsed)
+	}
+
+	// Regexp route is undecided until client closes its side, then the next route matches
+	if r, elapsed := session("AMQP", true); r != "amqp:AMQP" || elapsed > 500*time.Millisecond {
+		t.Error("Expected closed client to be routed by bytes it sent without read timeout, got ", r, " after ", elapsed)
+	}
+
+	if r, _ := session("AMQ", true); r != "default:AMQ" {
+		t.Error("Expected partial prefix of closed client to be routed to default pool, got ", r)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"../src/utils/proxyprotocol"
+)
+
+func TestProxyProtocolV1Header(t *testing.T) {
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+
+	header, err := proxyprotocol.HeaderV1(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(header) != "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n" {
+		t.Fatal("Unexpected v1 header: ", string(header))
+	}
+
+	src6 := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 56324}
+	header, _ = proxyprotocol.HeaderV1(src6, dst)
+
+	if string(header) != "PROXY TCP6 ::1 ::ffff:10.0.0.1 56324 443\r\n" {
+		t.Fatal("Unexpected v1 ipv6 header: ", string(header))
+	}
+}
+
+func TestProxyProtocolV2Header(t *testing.T) {
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+
+	header, err := proxyprotocol.HeaderV2(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+		0x21, 0x11, 0x00, 0x0C,
+		192, 168, 0, 1,
+		10, 0, 0, 1,
+		0xDC, 0x04,
+		0x01, 0xBB,
+	}
+
+	if !bytes.Equal(header, expected) {
+		t.Fatal("Unexpected v2 header: ", header)
+	}
+}
+
+func TestProxyProtocolAccept(t *testing.T) {
+
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}
+
+	for _, version := range []string{"v1", "v2"} {
+
+		client, server := net.Pipe()
+
+		go func() {
+			proxyprotocol.WriteHeader(client, version, src, dst)
+			client.Write([]byte("payload"))
+			client.Close()
+		}()
+
+		conn, err := proxyprotocol.Accept(server, time.Second)
+		if err != nil {
+			t.Fatal(version, err)
+		}
+
+		if conn.RemoteAddr().String() != src.String() || conn.LocalAddr().String() != dst.String() {
+			t.Fatal(version, " unexpected addresses: ", conn.RemoteAddr(), " ", conn.LocalAddr())
+		}
+
+		data, _ := ioutil.ReadAll(conn)
+		if string(data) != "payload" {
+			t.Fatal(version, " unexpected payload: ", string(data))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"../src/cluster"
+	"../src/config"
+	"../src/manager"
+	"../src/server/modules/ratelimit"
+)
+
+func TestRateLimitBurstAndBan(t *testing.T) {
+
+	r, err := ratelimit.NewRateLimit(&config.RateLimitConfig{
+		ConnectionsPerSecond: 1,
+		Burst:                2,
+		BanDuration:          "10s",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.168.0.1")
+	other := net.ParseIP("192.168.0.2")
+	now := time.Now()
+
+	if !r.Allows(ip, now) || !r.Allows(ip, now) {
+		t.Fatal("Expected connections within burst to be allowed")
+	}
+
+	if r.Allows(ip, now) {
+		t.Fatal("Expected connection exceeding burst to be denied")
+	}
+
+	if !r.Allows(other, now) {
+		t.Fatal("Expected other client to be limited separately")
+	}
+
+	if r.Allows(ip, now.Add(5*time.Second)) {
+		t.Fatal("Expected banned client to be denied")
+	}
+
+	if !r.Allows(ip, now.Add(11*time.Second)) {
+		t.Fatal("Expected client to be allowed after ban")
+	}
+}
+
+func TestRateLimitMergeShared(t *testing.T) {
+
+	r, err := ratelimit.NewRateLimit(&config.RateLimitConfig{
+		ConnectionsPerSecond: 1,
+		Burst:                3,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.168.0.1")
+	banned := net.ParseIP("192.168.0.2")
+	now := time.Now()
+
+	r.MergeShared([]cluster.RateCount{
+		{Client: ip.String(), Hits: 2},
+		{Client: banned.String(), BannedUntil: now.Add(10 * time.Second)},
+	}, now)
+
+	if !r.Allows(ip, now) {
+		t.Fatal("Expected connection within burst left by peer connections to be allowed")
+	}
+
+	if r.Allows(ip, now) {
+		t.Fatal("Expected connections to peer to be counted")
+	}
+
+	if r.Allows(banned, now.Add(5*time.Second)) {
+		t.Fatal("Expected client banned by peer to be denied")
+	}
+
+	if !r.Allows(banned, now.Add(11*time.Second)) {
+		t.Fatal("Expected client to be allowed after peer ban")
+	}
+}
+
+func TestRateLimitedClientTakesNoSlot(t *testing.T) {
+
+	backend, _, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	})
+	defer stop()
+
+	maxConnections := 1
+	server := createTestServer(t, "ratelimited", config.Server{
+		MaxConnections: &maxConnections,
+		QueueTimeout:   "2s",
+		RateLimit:      &config.RateLimitConfig{ConnectionsPerSecond: 0.1, Burst: 1},
+	}, backend)
+	defer manager.Delete("ratelimited")
+
+	first, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	waitConnections(t, "ratelimited", 1)
+
+	// Rate limited client is closed at once, instead of waiting for connection slot in queue
+	limited, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+
+	limited.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := limited.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("Expected rate limited client to be closed at once, got ", err)
+	}
+
+	if state := manager.ServersState()["ratelimited"]; state.Connections != 1 || state.Queued != 0 {
+		t.Error("Expected rate limited client not to take connection slot, got ", state.Connections, " connections and ", state.Queued, " queued")
+	}
+
+	if _, total, _ := manager.Connections("ratelimited", "", false, 0, 0); total != 1 {
+		t.Error("Expected rate limited client not to be registered, got ", total, " connections")
+	}
+}
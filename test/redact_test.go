@@ -0,0 +1,49 @@
+package test
+
+import (
+	"testing"
+
+	"../src/config"
+)
+
+func TestConfigRedacted(t *testing.T) {
+
+	cfg := config.Config{
+		Api: config.ApiConfig{
+			BasicAuth: &config.ApiBasicAuthConfig{Login: "admin", Password: "adminpass"},
+			Tokens:    []config.ApiTokenConfig{{Token: "admintoken", Role: "admin"}},
+		},
+		Servers: map[string]config.Server{
+			"default": {
+				Bind: config.Binds{"localhost:3000"},
+				Discovery: &config.DiscoveryConfig{
+					Kind: "consul",
+					ConsulDiscoveryConfig: &config.ConsulDiscoveryConfig{
+						ConsulHost:     "localhost:8500",
+						ConsulAclToken: "acltoken",
+					},
+				},
+			},
+		},
+	}
+
+	redacted := cfg.Redacted()
+
+	if redacted.Api.BasicAuth.Password != config.RedactedValue || redacted.Api.Tokens[0].Token != config.RedactedValue {
+		t.Error("Expected api credentials to be redacted, got", redacted.Api)
+	}
+
+	if redacted.Api.BasicAuth.Login != "admin" || redacted.Api.Tokens[0].Role != "admin" {
+		t.Error("Expected not secret options to be kept, got", redacted.Api)
+	}
+
+	discovery := redacted.Servers["default"].Discovery
+	if discovery.ConsulAclToken != config.RedactedValue || discovery.ConsulHost != "localhost:8500" {
+		t.Error("Expected consul acl token only to be redacted, got", discovery.ConsulDiscoveryConfig)
+	}
+
+	// Original configuration is not changed
+	if cfg.Api.BasicAuth.Password != "adminpass" || cfg.Servers["default"].Discovery.ConsulAclToken != "acltoken" {
+		t.Error("Expected original configuration to be kept, got", cfg.Api, cfg.Servers["default"].Discovery.ConsulDiscoveryConfig)
+	}
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"testing"
+
+	"../src/balance"
+	"../src/core"
+)
+
+type firstBalancer struct{}
+
+func (this *firstBalancer) Elect(ctx core.Context, backends []*core.Backend) (*core.Backend, error) {
+	return backends[0], nil
+}
+
+func TestRegisterBalancer(t *testing.T) {
+
+	if !balance.Registered("weight") {
+		t.Error("Builtin balancer weight should be registered")
+	}
+
+	if balance.Registered("first") {
+		t.Fatal("Balancer first should not be registered yet")
+	}
+
+	if err := balance.Register("first", func() core.Balancer { return &firstBalancer{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := balance.Register("first", func() core.Balancer { return &firstBalancer{} }); err == nil {
+		t.Error("Registering balancer twice should fail")
+	}
+
+	backends := []*core.Backend{
+		{Target: core.Target{Host: "127.0.0.1", Port: "1001"}},
+		{Target: core.Target{Host: "127.0.0.1", Port: "1002"}},
+	}
+
+	backend, err := balance.New(nil, "first").Elect(DummyContext{}, backends)
+	if err != nil || backend != backends[0] {
+		t.Error("Expected registered balancer to elect first backend, got", backend, err)
+	}
+}
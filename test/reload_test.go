@@ -0,0 +1,154 @@
+package test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/manager"
+)
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func reloadTestServer(bind string) config.Server {
+	return config.Server{
+		Bind:      config.Binds{bind},
+		Protocol:  "tcp",
+		Discovery: &config.DiscoveryConfig{Kind: "static", StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{"127.0.0.1:1"}}},
+	}
+}
+
+func TestReloadKeepsRuntimeServersAndRestoresFailed(t *testing.T) {
+
+	a, b, created := freeAddress(t), freeAddress(t), freeAddress(t)
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	next := config.Config{Servers: map[string]config.Server{}}
+
+	manager.Initialize(config.Config{Servers: map[string]config.Server{
+		"a": reloadTestServer(a),
+		"b": reloadTestServer(b),
+	}}, func() (*config.Config, error) {
+		c := next
+		return &c, nil
+	}, nil)
+
+	if err := manager.Create("created", reloadTestServer(created)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server a can't listen on new bind, b is removed
+	next.Servers["a"] = reloadTestServer(busy.Addr().String())
+
+	err = manager.Reload()
+	if err == nil || !strings.HasSuffix(err.Error(), ": a") {
+		t.Fatal("Expected reload to fail starting server a, got ", err)
+	}
+
+	all := manager.All()
+
+	if c, ok := all["a"]; !ok || c.Bind[0] != a {
+		t.Error("Expected server a to be restored with previous configuration, got ", c.Bind)
+	}
+
+	if _, ok := all["b"]; ok {
+		t.Error("Expected removed server b to be stopped")
+	}
+
+	if _, ok := all["created"]; !ok {
+		t.Error("Expected server created in runtime to be kept")
+	}
+
+	conn, err := net.Dial("tcp", a)
+	if err != nil {
+		t.Fatal("Expected restored server a to listen: ", err)
+	}
+	conn.Close()
+
+	// Fixed configuration is applied
+	next.Servers["a"] = reloadTestServer(b)
+
+	if err := manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := manager.All()["a"]; len(c.Bind) == 0 || c.Bind[0] != b {
+		t.Error("Expected server a to be restarted on new bind, got ", c.Bind)
+	}
+
+	for name := range manager.All() {
+		manager.Delete(name)
+	}
+}
+
+func TestUpdateDrainsUnlocked(t *testing.T) {
+
+	backend, _, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	})
+	defer stop()
+
+	drainTimeout := "1s"
+	server := createTestServer(t, "draining", config.Server{DrainTimeout: &drainTimeout}, backend)
+	defer manager.Delete("draining")
+
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitConnections(t, "draining", 1)
+
+	cfg := manager.All()["draining"]
+	cfg.Bind = config.Binds{freeAddress(t)}
+
+	updated := make(chan error, 1)
+	go func() {
+		updated <- manager.Update("draining", cfg)
+	}()
+
+	// Restarted server drains its connection, while others are served
+	time.Sleep(100 * time.Millisecond)
+
+	read := make(chan bool, 1)
+	go func() {
+		manager.All()
+		read <- true
+	}()
+
+	select {
+	case <-read:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected servers to be read while server is drained on update")
+	}
+
+	if err := manager.Create("draining", cfg); err == nil {
+		t.Error("Expected creating server being restarted to fail")
+	}
+
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+
+	if c := manager.All()["draining"]; len(c.Bind) == 0 || c.Bind[0] != cfg.Bind[0] {
+		t.Error("Expected server to be restarted on new bind, got ", c.Bind)
+	}
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../src/config"
+	"../src/core"
+	"../src/server/modules/accesslog"
+	"../src/server/modules/script"
+)
+
+const testScript = `
+function on_accept(conn)
+  if conn.first_bytes == "LOOP" then
+    while true do end
+  end
+  conn.tags.client = conn.client_ip
+  return conn.first_bytes ~= "DENY"
+end
+
+function select_backend(conn, backends)
+  if conn.first_bytes == "LOOP" then
+    while true do end
+  end
+  for _, b in ipairs(backends) do
+    if b.labels.zone == conn.sni then
+      return b.address
+    end
+  end
+end
+
+function on_close(conn, session)
+  conn.tags.reason = session.reason
+end
+`
+
+func writeTestScript(t *testing.T, source string) string {
+
+	dir, err := ioutil.TempDir("", "gobetween-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "policy.lua")
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func newTestScript(t *testing.T, failpolicy string) *script.Script {
+
+	s, err := script.New("test", &config.ScriptConfig{Path: writeTestScript(t, testScript), Timeout: "50ms", Failpolicy: failpolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	return s
+}
+
+func newTestContext(t *testing.T, sni string, firstBytes string) *core.TcpContext {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &core.TcpContext{Hostname: sni, Conn: conn, FirstBytes: []byte(firstBytes)}
+}
+
+func TestScriptOnAccept(t *testing.T) {
+
+	s := newTestScript(t, "accept")
+
+	ctx := newTestContext(t, "", "GET ")
+	if !s.OnAccept(ctx) {
+		t.Error("Connection should be accepted")
+	}
+
+	if ctx.Tags["client"] != "127.0.0.1" {
+		t.Error("Expected client tag 127.0.0.1, got", ctx.Tags)
+	}
+
+	if s.OnAccept(newTestContext(t, "", "DENY")) {
+		t.Error("Connection should be rejected")
+	}
+
+	// Hook exceeding timeout is ignored with accept failpolicy
+	if !s.OnAccept(newTestContext(t, "", "LOOP")) {
+		t.Error("Connection of timed out hook should be accepted")
+	}
+}
+
+func TestScriptOnAcceptRejectFailpolicy(t *testing.T) {
+
+	s := newTestScript(t, "reject")
+
+	if s.OnAccept(newTestContext(t, "", "LOOP")) {
+		t.Error("Connection of timed out hook should be rejected")
+	}
+
+	if !s.OnAccept(newTestContext(t, "", "GET ")) {
+		t.Error("Connection should be accepted")
+	}
+}
+
+func TestScriptLoadTimeout(t *testing.T) {
+
+	path := writeTestScript(t, "while true do end\nfunction on_accept(conn) return true end\n")
+
+	if _, err := script.New("test", &config.ScriptConfig{Path: path, Timeout: "50ms"}); err == nil {
+		t.Error("Expected looping script to fail loading")
+	}
+}
+
+func TestScriptSelectBackend(t *testing.T) {
+
+	s := newTestScript(t, "accept")
+
+	backends := []core.Backend{
+		{Target: core.Target{Host: "127.0.0.1", Port: "1001"}, Labels: map[string]string{"zone": "a"}},
+		{Target: core.Target{Host: "127.0.0.1", Port: "1002"}, Labels: map[string]string{"zone": "b"}},
+	}
+
+	if target := s.SelectBackend(newTestContext(t, "b", ""), backends); target == nil || *target != backends[1].Target {
+		t.Error("Expected backend 127.0.0.1:1002, got", target)
+	}
+
+	if target := s.SelectBackend(newTestContext(t, "c", ""), backends); target != nil {
+		t.Error("Expected no backend, got", target)
+	}
+
+	// Hook exceeding timeout is ignored
+	if target := s.SelectBackend(newTestContext(t, "a", "LOOP"), backends); target != nil {
+		t.Error("Expected no backend of timed out hook, got", target)
+	}
+}
+
+func TestScriptOnClose(t *testing.T) {
+
+	s := newTestScript(t, "accept")
+
+	ctx := newTestContext(t, "", "")
+	s.OnAccept(ctx)
+	s.OnClose(ctx, accesslog.Record{Reason: "client_closed"})
+
+	if ctx.Tags["reason"] != "client_closed" || ctx.Tags["client"] != "127.0.0.1" {
+		t.Error("Expected tags of both hooks, got", ctx.Tags)
+	}
+}
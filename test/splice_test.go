@@ -0,0 +1,75 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/core"
+	"../src/manager"
+)
+
+/**
+ * Plain tcp connections are spliced on linux and copied elsewhere
+ */
+func TestPlainTcpProxying(t *testing.T) {
+
+	backend, _, stop := startTestBackend(t, func(conn net.Conn, n int32) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+	defer stop()
+
+	server := createTestServer(t, "splice", config.Server{}, backend)
+	defer manager.Delete("splice")
+
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+
+	go conn.Write(data)
+
+	echoed := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, echoed) {
+		t.Fatal("Expected data to be proxied unchanged")
+	}
+
+	// Moved bytes are counted, as they are with copying
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		connections, _, err := manager.Connections("splice", "", false, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var c core.Connection
+		if len(connections) == 1 {
+			c = connections[0]
+		}
+
+		if c.Rx == uint64(len(data)) && c.Tx == uint64(len(data)) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected ", len(data), " bytes to be counted both ways, got ", c.Rx, " ", c.Tx)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/core"
+	"../src/metrics"
+	"../src/stats"
+)
+
+/**
+ * Pushes stats and returns lines of packets sent
+ */
+func pushStatsd(t *testing.T, s *metrics.Statsd, listener net.PacketConn, all map[string]stats.Stats) map[string]bool {
+
+	s.Push(all)
+
+	lines := map[string]bool{}
+	buf := make([]byte, 65536)
+
+	for {
+		listener.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			lines[line] = true
+		}
+	}
+}
+
+func statsdTestStats(live bool, rx uint64) map[string]stats.Stats {
+	return map[string]stats.Stats{
+		"web": {
+			ActiveConnections: 3,
+			RxTotal:           rx,
+			Backends: []core.Backend{{
+				Target: core.Target{Host: "10.0.0.1", Port: "80"},
+				Stats:  core.BackendStats{Live: live, ActiveConnections: 2, RxBytes: rx},
+			}},
+		},
+	}
+}
+
+func TestStatsdPlain(t *testing.T) {
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := metrics.NewStatsd(config.StatsdConfig{Prefix: "gb."}, conn)
+
+	lines := pushStatsd(t, s, listener, statsdTestStats(true, 100))
+
+	for _, line := range []string{
+		"gb.server.web.active_connections:3|g",
+		"gb.server.web.live_backends:1|g",
+		"gb.backend.web.10_0_0_1_80.live:1|g",
+		"gb.backend.web.10_0_0_1_80.active_connections:2|g",
+	} {
+		if !lines[line] {
+			t.Error("Expected line ", line, " in ", lines)
+		}
+	}
+
+	if lines["gb.server.web.rx_bytes:100|c"] {
+		t.Error("Expected counters not to be sent on the first push")
+	}
+
+	lines = pushStatsd(t, s, listener, statsdTestStats(false, 150))
+
+	for _, line := range []string{
+		"gb.server.web.rx_bytes:50|c",
+		"gb.backend.web.10_0_0_1_80.rx_bytes:50|c",
+		"gb.backend.web.10_0_0_1_80.live:0|g",
+		"gb.backend.web.10_0_0_1_80.health_transitions:1|c",
+	} {
+		if !lines[line] {
+			t.Error("Expected line ", line, " in ", lines)
+		}
+	}
+}
+
+func TestStatsdDogstatsd(t *testing.T) {
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := metrics.NewStatsd(config.StatsdConfig{Dogstatsd: true, Tags: []string{"env:test"}}, conn)
+
+	pushStatsd(t, s, listener, statsdTestStats(true, 100))
+	lines := pushStatsd(t, s, listener, statsdTestStats(false, 100))
+
+	for _, line := range []string{
+		"gobetween.server.active_connections:3|g|#env:test,server:web",
+		"gobetween.backend.live:0|g|#env:test,server:web,host:10.0.0.1,port:80",
+		"gobetween.server.rx_bytes:0|c|#env:test,server:web",
+		"_e{22,41}:gobetween backend down|Backend 10.0.0.1:80 of server web is down|t:warning|#env:test,server:web,host:10.0.0.1,port:80",
+	} {
+		if !lines[line] {
+			t.Error("Expected line ", line, " in ", lines)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../src/config"
+	"../src/core"
+	"../src/server/scheduler"
+)
+
+func stickBackend(port string) core.Backend {
+	return core.Backend{Target: core.Target{Host: "127.0.0.1", Port: port}}
+}
+
+func TestStickTableExpiryAndEviction(t *testing.T) {
+
+	table := scheduler.NewStickTable(&config.StickyConfig{Ttl: "10s", MaxEntries: 2})
+	now := time.Now()
+
+	table.Put("10.0.0.1", stickBackend("1001"), now)
+	table.Put("10.0.0.2", stickBackend("1002"), now)
+
+	if target := table.Get("10.0.0.1", now.Add(5*time.Second)); target == nil || target.Port != "1001" {
+		t.Fatal("Expected client to stick to its backend, got ", target)
+	}
+
+	// Client connecting again is kept longer
+	table.Put("10.0.0.1", stickBackend("1001"), now.Add(5*time.Second))
+
+	if target := table.Get("10.0.0.1", now.Add(12*time.Second)); target == nil {
+		t.Fatal("Expected entry of reconnected client to be prolonged")
+	}
+
+	if target := table.Get("10.0.0.2", now.Add(12*time.Second)); target != nil {
+		t.Fatal("Expected entry to expire after ttl, got ", target)
+	}
+
+	table.Put("10.0.0.2", stickBackend("1002"), now)
+	table.Put("10.0.0.3", stickBackend("1003"), now)
+
+	if target := table.Get("10.0.0.1", now); target != nil {
+		t.Fatal("Expected least recently used entry to be evicted from full table")
+	}
+
+	if entries := table.Entries(); len(entries) != 2 || entries[0].Client != "10.0.0.3" {
+		t.Fatal("Expected entries of 2 clients, most recent first, got ", entries)
+	}
+
+	table.Expire(now.Add(11 * time.Second))
+
+	if entries := table.Entries(); len(entries) != 0 {
+		t.Fatal("Expected expired entries to be removed, got ", entries)
+	}
+}
+
+func TestStickTableFlushAndPersist(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gobetween-sticky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.StickyConfig{Ttl: "1m", PersistPath: filepath.Join(dir, "sticky.json")}
+	now := time.Now()
+
+	table := scheduler.NewStickTable(cfg)
+	table.Put("10.0.0.1", stickBackend("1001"), now)
+	table.Put("10.0.0.2", stickBackend("1002"), now)
+	table.Put("10.0.0.3", stickBackend("1003"), now)
+
+	table.Flush("10.0.0.1")
+
+	if target := table.Get("10.0.0.1", now); target != nil {
+		t.Fatal("Expected flushed client not to stick")
+	}
+
+	if err := table.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := scheduler.NewStickTable(cfg)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if target := loaded.Get("10.0.0.2", now); target == nil || target.Port != "1002" {
+		t.Fatal("Expected persisted entry to be loaded, got ", target)
+	}
+
+	if target := loaded.Get("10.0.0.1", now); target != nil {
+		t.Fatal("Expected flushed entry not to be persisted")
+	}
+
+	loaded.Flush("")
+
+	if entries := loaded.Entries(); len(entries) != 0 {
+		t.Fatal("Expected flush of empty client to remove all entries, got ", entries)
+	}
+}
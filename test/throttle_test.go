@@ -0,0 +1,30 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"../src/server/modules/throttle"
+)
+
+func TestThrottleLimiterReserve(t *testing.T) {
+
+	l := throttle.NewLimiter(1000)
+	now := time.Now()
+
+	if d := l.Reserve(1000, now); d != 0 {
+		t.Fatal("Expected bytes within rate to be sent immediately, got delay ", d)
+	}
+
+	if d := l.Reserve(500, now); d != 500*time.Millisecond {
+		t.Fatal("Expected bytes exceeding rate to be delayed for 500ms, got ", d)
+	}
+
+	if d := l.Reserve(500, now.Add(time.Second)); d != 0 {
+		t.Fatal("Expected reserved bytes to be refilled in a second, got delay ", d)
+	}
+
+	if throttle.NewLimiter(0) != nil {
+		t.Fatal("Expected no limiter for unlimited bandwidth")
+	}
+}
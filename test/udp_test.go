@@ -0,0 +1,41 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"../src/manager"
+)
+
+func TestUdpServerBusyBind(t *testing.T) {
+
+	busy, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	cfg := reloadTestServer(busy.LocalAddr().String())
+	cfg.Protocol = "udp"
+
+	created := make(chan error, 1)
+	go func() {
+		created <- manager.Create("udpbusy", cfg)
+	}()
+
+	select {
+	case err := <-created:
+		if err == nil {
+			manager.Delete("udpbusy")
+			t.Fatal("Expected creating udp server on busy bind to fail")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Creating udp server on busy bind hangs")
+	}
+
+	// Manager is not left locked
+	if manager.Get("udpbusy") != nil {
+		t.Error("Expected failed udp server not to be created")
+	}
+}